@@ -2,29 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/cors"
+	"github.com/greens-marketplace/internal/cache"
 	"github.com/greens-marketplace/internal/config"
 	"github.com/greens-marketplace/internal/database"
 	"github.com/greens-marketplace/internal/handlers"
+	"github.com/greens-marketplace/internal/health"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/lifecycle"
+	"github.com/greens-marketplace/internal/metrics"
 	"github.com/greens-marketplace/internal/middleware"
 	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/storage"
 	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/version"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
-	"github.com/go-chi/httprate"
-	"github.com/go-chi/jwtauth/v5"
 )
 
 var (
@@ -34,6 +39,9 @@ var (
 func main() {
 	flag.Parse()
 
+	// Times are UTC throughout, in responses and logs alike
+	utils.UseUTC()
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Warn().Msg("No .env file found, using system environment variables")
@@ -46,128 +54,602 @@ func main() {
 	}
 
 	// Setup logging
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	log.Logger = log.With().Str("service", "greens-marketplace").Logger()
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	logOutput := utils.NewRedactingWriter(os.Stderr, cfg.Logging.RedactFields)
+	log.Logger = zerolog.New(logOutput).With().Timestamp().Str("service", "greens-marketplace").Str("version", version.Version).Logger()
 	if cfg.Environment == "development" {
 		log.Logger = log.Logger.Level(zerolog.DebugLevel)
 	} else {
 		log.Logger = log.Logger.Level(zerolog.InfoLevel)
 	}
 
+	utils.SetDevelopment(cfg.Environment == "development")
+	utils.SetListDefaults(cfg.Pagination.DefaultPageSize, cfg.Pagination.MaxPageSize)
+
+	// Load TLS certificates up front so unreadable files fail fast
+	var tlsConfig *tls.Config
+	var redirectHandler http.Handler
+	if cfg.TLS.Enabled {
+		tlsConfig, redirectHandler, err = newTLSConfig(cfg.TLS, cfg.Server.Port)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure TLS")
+		}
+	}
+
+	// Components register how to stop as they start. Shutdown stops them in
+	// reverse, so each stops before whatever it uses.
+	shutdown := lifecycle.NewManager(time.Duration(cfg.Server.ShutdownTimeout)*time.Second,
+		time.Duration(cfg.Server.ShutdownHookTimeout)*time.Second)
+
 	// Initialize database
 	db, err := database.NewPostgresDB(cfg.Database)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to connect to database")
 	}
-	defer db.Close()
+	shutdown.OnShutdown("postgres", func(context.Context) error { return db.Close() })
 
 	// Initialize Redis
 	redisClient, err := database.NewRedisClient(cfg.Redis)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to connect to Redis")
 	}
-	defer redisClient.Close()
+	shutdown.OnShutdown("redis", func(context.Context) error { return redisClient.Close() })
+
+	// Read-through cache for hot catalog reads
+	cacheTTLs := cache.NewTTLs(cfg.CacheTTLs)
+	appCache := cache.New(redisClient, cfg.Cache, cacheTTLs)
+
+	// Initialize search backend
+	searchBackend, err := services.NewSearchBackend(cfg.Search, db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize search backend")
+	}
+
+	// Initialize blob storage for uploaded images
+	blobStore, err := storage.NewBlobStore(cfg.Storage)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize blob storage")
+	}
+
+	// Load JWT signing and verification keys
+	tokenKeys, err := services.LoadTokenKeys(cfg.JWT)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load JWT keys")
+	}
+
+	// Background jobs
+	jobQueue := jobs.NewQueue(redisClient)
+	jobWorker := jobs.NewWorker(jobQueue, 4)
+	scheduler := jobs.NewScheduler()
+	outboxRelay := services.NewOutboxRelay(db, jobQueue, time.Second)
 
 	// Initialize services
-	userService := services.NewUserService(db, redisClient)
-	productService := services.NewProductService(db, redisClient)
-	orderService := services.NewOrderService(db, redisClient)
-	searchService := services.NewSearchService(db, redisClient)
-	notificationService := services.NewNotificationService(db, redisClient)
+	userService := services.NewUserService(db, redisClient, tokenKeys, cfg.JWT, cfg.PasswordPolicy,
+		services.NewEmailThrottle(redisClient, cfg.EmailResend))
+	productService := services.NewProductService(db, redisClient, searchBackend, appCache, cfg.Views, cfg.Pricing)
+	experimentService := services.NewExperimentService(db, redisClient, cfg.Experiments)
+	searchService := services.NewSearchService(db, redisClient, searchBackend, experimentService, jobQueue, cfg.Search.Fuzzy)
+	notificationService := services.NewNotificationService(db, redisClient, jobQueue, cfg.Notifications)
+	featureFlagService := services.NewFeatureFlagService(db, redisClient, cfg.Features, cacheTTLs)
+	quotaService := services.NewQuotaService(db, redisClient, cfg.Quotas, cacheTTLs)
+	degradedModeService := services.NewDegradedModeService(redisClient, cfg.Degraded)
+	reviewService := services.NewReviewService(db, productService, cfg.Reviews)
+	questionService := services.NewQuestionService(db)
+	inventoryService := services.NewInventoryService(db, redisClient, appCache, cfg.Inventory)
+	cartHolds := services.NewCartHolds(redisClient, cfg.Cart)
+	deliveryService := services.NewDeliveryService(db, appCache, cfg.Delivery)
+	shippingService, err := services.NewShippingService(deliveryService, cfg.Shipping)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load shipping rates")
+	}
+	taxService, err := services.NewTaxService(cfg.Tax)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load tax rates")
+	}
+	paymentGateway, err := services.NewPaymentGateway(cfg.Payments)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid payments configuration")
+	}
+	paymentMethodService := services.NewPaymentMethodService(db, paymentGateway)
+	fraudService := services.NewFraudService(db, cfg.Fraud)
+	orderService := services.NewOrderService(db, redisClient, inventoryService, cartHolds, shippingService, taxService, paymentMethodService, fraudService, cfg.Inventory, cfg.Orders, cfg.Returns)
+	shippingCarrier, err := services.NewShippingCarrier(cfg.Labels)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid shipping labels configuration")
+	}
+	shippingLabelService := services.NewShippingLabelService(db, blobStore, shippingCarrier, orderService)
+	cartService := services.NewCartService(db, redisClient, cartHolds, cfg.Cart)
+	webhookService := services.NewWebhookService(db, cfg.Webhooks)
+	sellerService := services.NewSellerService(db, productService)
+	accountService := services.NewAccountService(db, redisClient, productService, userService, cfg.JWT, cacheTTLs)
+	commissionService := services.NewCommissionService(db)
+	statsService := services.NewStatsService(db, appCache)
+	contentService := services.NewContentService(db, appCache)
+	maintenanceService := services.NewMaintenanceService(db, contentService)
+	retentionService, err := services.NewRetentionService(db, redisClient, cfg.Retention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid retention configuration")
+	}
+
+	// Background job handlers
+	jobWorker.Handle(services.EventStockBackInStock, inventoryService.NotifyBackInStock)
+	jobWorker.Handle(services.EventStockLow, inventoryService.NotifyLowStock)
+	jobWorker.Handle(services.JobStockAdjustment, inventoryService.ApplyQueuedStockAdjustment)
+	jobWorker.Handle(services.JobSearchQueryLogged, searchService.RecordSearchQuery)
+	jobWorker.Handle(services.EventPriceDrop, productService.NotifyPriceDrop)
+	jobWorker.Handle(services.EventSellerShipped, orderService.NotifySellerShipped)
+	jobWorker.Handle(services.EventBackorderUpdated, orderService.NotifyBackorder)
+	jobWorker.Handle(services.EventPreordersFilled, orderService.ChargePreorders)
+	jobWorker.Handle(services.EventOrderExpired, orderService.NotifyOrderExpired)
+	jobWorker.Handle(services.EventReturnUpdated, orderService.NotifyReturnUpdated)
+	jobWorker.Handle(services.JobBroadcastBatch, notificationService.SendBroadcastBatch)
+	jobWorker.Handle(services.EventNotificationCreated, notificationService.DispatchNotification)
+	jobWorker.Handle(services.JobNotificationSend, notificationService.SendNotification)
+	jobWorker.Handle(services.EventSellerStatusChanged, sellerService.NotifySellerStatusChanged)
+	jobWorker.Handle(services.EventAccountSuspensionChanged, accountService.NotifySuspensionChanged)
+	jobWorker.Handle(services.EventProductPublished, sellerService.NotifyFollowers)
+	jobWorker.Handle(services.EventReviewReplied, reviewService.NotifyReviewReply)
+	jobWorker.Handle(services.EventQuestionAsked, questionService.NotifyQuestionAsked)
+	jobWorker.Handle(services.EventQuestionAnswered, questionService.NotifyQuestionAnswered)
+
+	imageImporter := services.NewImageImporter(blobStore, productService, cfg.Server.MaxUploadBytes)
 
 	// Initialize handlers
 	userHandler := handlers.NewUserHandler(userService)
-	productHandler := handlers.NewProductHandler(productService, searchService)
-	orderHandler := handlers.NewOrderHandler(orderService)
+	productHandler := handlers.NewProductHandler(productService, searchService, cartService, imageImporter, cfg.Bulk)
+	orderHandler := handlers.NewOrderHandler(orderService, cartService, blobStore)
+	shippingLabelHandler := handlers.NewShippingLabelHandler(shippingLabelService, blobStore)
 	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	featureFlagHandler := handlers.NewFeatureFlagHandler(featureFlagService)
+	experimentHandler := handlers.NewExperimentHandler(experimentService)
+	jwksHandler := handlers.NewJWKSHandler(tokenKeys)
+	quotaHandler := handlers.NewQuotaHandler(quotaService)
+	degradedModeHandler := handlers.NewDegradedModeHandler(degradedModeService)
+	imageHandler := handlers.NewImageHandler(blobStore, productService, imageImporter)
+	inventoryHandler := handlers.NewInventoryHandler(inventoryService, cfg.Bulk)
+	reviewHandler := handlers.NewReviewHandler(reviewService, cfg.Bulk)
+	questionHandler := handlers.NewQuestionHandler(questionService)
+	deliveryHandler := handlers.NewDeliveryHandler(deliveryService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	preferencesHandler := handlers.NewPreferencesHandler(userService)
+	broadcastHandler := handlers.NewBroadcastHandler(notificationService)
+	sellerHandler := handlers.NewSellerHandler(sellerService)
+	accountHandler := handlers.NewAccountHandler(accountService)
+	commissionHandler := handlers.NewCommissionHandler(commissionService)
+	retentionHandler := handlers.NewRetentionHandler(retentionService, cfg.Retention.DryRun)
+	statsHandler := handlers.NewStatsHandler(statsService)
+	contentHandler := handlers.NewContentHandler(contentService)
+	maintenanceHandler := handlers.NewMaintenanceHandler(maintenanceService)
+	paymentMethodHandler := handlers.NewPaymentMethodHandler(paymentMethodService)
+
+	// Validate OpenAI configuration; semantic search is disabled rather than
+	// failing startup when the key or model is unusable
+	openAIErr := services.ValidateOpenAIConfig(context.Background(), cfg.OpenAI)
+	semanticSearchEnabled := openAIErr == nil
+	if !semanticSearchEnabled {
+		log.Warn().Err(openAIErr).Msg("OpenAI configuration check failed, semantic search disabled")
+	}
+
+	// Health transitions of the database and Redis are logged, and posted to
+	// the ops channel when one is configured, as are critical system alerts
+	healthBus := health.NewBus()
+	healthBus.Subscribe(health.LogEvent)
+	var opsAlerter *health.WebhookAlerter
+	if cfg.Health.AlertWebhookURL != "" {
+		opsAlerter = health.NewWebhookAlerter(cfg.Health.AlertWebhookURL, time.Duration(cfg.Health.AlertTimeout)*time.Second)
+		healthBus.Subscribe(opsAlerter.Alert)
+	}
+	alertService := services.NewAlertService(db, jobQueue, opsAlerter, cfg.Alerts, time.Duration(cfg.Jobs.MaxPendingAge)*time.Second)
+	jobWorker.Handle(services.EventOrderHeld, alertService.AlertOrderHeld)
+	alertHandler := handlers.NewAlertHandler(alertService)
+
+	// Readiness checks
+	healthHandler := handlers.NewHealthHandler(
+		handlers.HealthCheck{Name: "database", Critical: true, Check: db.PingContext},
+		handlers.HealthCheck{Name: "redis", Critical: true, Check: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}},
+		handlers.HealthCheck{Name: "openai", Critical: false, Check: func(ctx context.Context) error {
+			return openAIErr
+		}},
+		handlers.HealthCheck{Name: "jobs", Critical: false, Details: func(ctx context.Context) (interface{}, error) {
+			health, err := jobWorker.Health(ctx, time.Duration(cfg.Jobs.MaxPendingAge)*time.Second)
+			if health == nil {
+				return nil, err
+			}
+			return health, err
+		}},
+		handlers.HealthCheck{Name: "scheduler", Critical: false, Details: func(ctx context.Context) (interface{}, error) {
+			return scheduler.Status(), scheduler.Check()
+		}},
+	)
+
+	// Destructive admin operations also require an HMAC-signed request
+	requireSigned := middleware.RequireSignedRequest(cfg.AdminSigning)
+	if cfg.AdminSigning.Secret == "" {
+		log.Warn().Msg("Admin signing secret not configured, signed admin endpoints will reject all requests")
+	}
+
+	// Bot protection on sign-up and sign-in, off unless configured
+	var captchaVerifier middleware.CaptchaVerifier
+	if cfg.Captcha.Enabled {
+		verifier, err := services.NewCaptchaVerifier(cfg.Captcha)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create CAPTCHA verifier")
+		}
+		captchaVerifier = verifier
+	}
 
-	// Setup JWT authentication
-	tokenAuth := jwtauth.New("HS256", []byte(cfg.JWT.Secret), nil)
+	// Features switched off for this deployment get no routes
+	log.Info().Strs("features", cfg.Features.Enabled()).Msg("Enabled features")
 
 	// Create router
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(30 * time.Second))
-	
+	if cfg.Environment == "development" {
+		r.Use(middleware.ServerTiming)
+	}
+	r.Use(chimiddleware.RequestID)
+	r.Use(middleware.RequestLogger)
+	r.Use(chimiddleware.RealIP)
+	r.Use(chimiddleware.Logger)
+	r.Use(chimiddleware.Recoverer)
+	r.Use(middleware.Timeout(30 * time.Second)) // default, overridden per route group with RouteTimeout
+	r.Use(middleware.MaxBodyBytes(cfg.Server.MaxBodyBytes))
+	r.Use(middleware.Localize)
+
 	// CORS
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:3001"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedOrigins: []string{"http://localhost:3000", "http://localhost:3001"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Accept-Language", "Authorization", "Content-Type", "X-CSRF-Token", "Range", "If-Range", "If-None-Match",
+			"Idempotency-Key", middleware.SignatureHeader, middleware.SignatureTimestampHeader, middleware.CaptchaHeader},
+		ExposedHeaders: []string{"Link", "Accept-Ranges", "Content-Range", "ETag", "Warning",
+			middleware.MaintenanceStartsHeader, middleware.MaintenanceEndsHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
-	// Rate limiting
-	r.Use(httprate.LimitByIP(100, 1*time.Minute))
+	// Rate limiting, shared across replicas through Redis
+	rateLimiter := middleware.NewRateLimiter(redisClient, cfg.RateLimits)
+	r.Use(rateLimiter.LimitRoute(config.RateLimitGlobal))
+
+	// Scheduled maintenance is announced ahead and takes the API down while
+	// it's on, except what operators and admins need to manage it
+	r.Use(middleware.Maintenance(maintenanceService, "/health", "/readyz", "/metrics", "/.well-known", "/api/v1/auth", "/api/v1/admin"))
 
 	// Health check
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"status": "healthy", "timestamp": "` + time.Now().Format(time.RFC3339) + `"}`))
-	})
+	r.Get("/health", healthHandler.Health)
+	r.Get("/readyz", healthHandler.Ready)
+	r.Handle("/metrics", metrics.Handler())
+	r.Get("/.well-known/jwks.json", jwksHandler.JWKS)
+	r.Get("/images/*", imageHandler.ServeImage)
+	r.Head("/images/*", imageHandler.ServeImage)
 
 	// API routes
 	r.Route("/api/v1", func(r chi.Router) {
 		// Public routes
-		r.Post("/auth/register", userHandler.Register)
-		r.Post("/auth/login", userHandler.Login)
+		r.With(middleware.RequireCaptcha("register", captchaVerifier, rateLimiter, cfg.Captcha)).Post("/auth/register", userHandler.Register)
+		r.With(middleware.RequireCaptcha("login", captchaVerifier, rateLimiter, cfg.Captcha)).Post("/auth/login", userHandler.Login)
 		r.Post("/auth/refresh", userHandler.RefreshToken)
-		r.Get("/categories", productHandler.GetCategories)
+		r.With(middleware.CacheControl(cfg.HTTPCache.Categories), middleware.RouteTimeout(5*time.Second)).Get("/categories", productHandler.GetCategories)
+		r.With(middleware.CacheControl(cfg.HTTPCache.Categories), middleware.RouteTimeout(5*time.Second)).Get("/categories/tree", productHandler.GetCategoryTree)
+		r.With(middleware.CacheControl(cfg.HTTPCache.Categories), middleware.RouteTimeout(5*time.Second)).Get("/categories/{id}/filters", productHandler.GetCategoryFilters)
+		if cfg.Features.IsEnabled(config.FeatureRecommendations) {
+			r.With(middleware.OptionalJWTAuth(tokenKeys, cfg.JWT), middleware.RequireActiveAccount(accountService), middleware.AuditImpersonation(accountService), middleware.RouteTimeout(5*time.Second)).Get("/users/recently-viewed", productHandler.GetRecentlyViewed)
+		}
+		r.With(middleware.CacheControl(cfg.HTTPCache.Products), middleware.RouteTimeout(5*time.Second)).Get("/products/{id}/price-history", productHandler.GetPriceHistory)
+		r.With(middleware.RouteTimeout(5*time.Second)).Get("/content/{key}", contentHandler.GetContent)
+		r.With(middleware.RouteTimeout(5*time.Second)).Get("/sellers/{id}/availability", sellerHandler.GetSellerAvailability)
+
+		// Cacheable reads: anonymous requests get public, revalidatable
+		// responses; a token still authenticates and gets its own view, kept private
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.OptionalJWTAuth(tokenKeys, cfg.JWT))
+			r.Use(middleware.RequireActiveAccount(accountService))
+			r.Use(middleware.AuditImpersonation(accountService))
+
+			r.With(middleware.CacheControl(cfg.HTTPCache.Products), middleware.RouteTimeout(5*time.Second), middleware.NegotiateContent).Get("/products/{id}", productHandler.GetProduct)
+			r.With(middleware.CacheControl(cfg.HTTPCache.Products), middleware.RouteTimeout(5*time.Second), middleware.NegotiateContent).Get("/products/slug/{slug}", productHandler.GetProductBySlug)
+			r.With(middleware.CacheControl(cfg.HTTPCache.Products), middleware.RouteTimeout(5*time.Second), middleware.NegotiateContent).Get("/products/{id}/nutrition", productHandler.GetProductNutrition)
+			r.With(middleware.CacheControl(cfg.HTTPCache.Collections), middleware.RouteTimeout(10*time.Second), middleware.NegotiateContent).Get("/collections/{tag}", productHandler.GetCollection)
+		})
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.JWTAuth(tokenAuth))
-			r.Use(middleware.SetHeader("Authorization", "Bearer"))
+			r.Use(middleware.JWTAuth(tokenKeys, cfg.JWT))
+			r.Use(middleware.RequireActiveAccount(accountService))
+			r.Use(middleware.AuditImpersonation(accountService))
+			r.Use(chimiddleware.SetHeader("Authorization", "Bearer"))
 
 			// User routes
 			r.Get("/users/profile", userHandler.GetProfile)
 			r.Put("/users/profile", userHandler.UpdateProfile)
-			r.Get("/users/preferences", userHandler.GetPreferences)
-			r.Put("/users/preferences", userHandler.UpdatePreferences)
+			r.With(middleware.ForbidImpersonation).Delete("/users/profile", accountHandler.DeleteAccount)
+			r.Get("/users/preferences", preferencesHandler.GetPreferences)
+			r.Put("/users/preferences", preferencesHandler.UpdatePreferences)
+			r.Patch("/users/preferences", preferencesHandler.PatchPreferences)
+			r.Get("/users/quota", quotaHandler.GetQuota)
+			r.Get("/users/me/stats", statsHandler.GetUserStats)
+			r.Get("/users/feed", sellerHandler.GetFeed)
+			r.Get("/users/payment-methods", paymentMethodHandler.ListPaymentMethods)
+			r.With(middleware.ForbidImpersonation).Post("/users/payment-methods", paymentMethodHandler.AddPaymentMethod)
+			r.With(middleware.ForbidImpersonation).Post("/users/payment-methods/{id}/default", paymentMethodHandler.SetDefaultPaymentMethod)
+			r.With(middleware.ForbidImpersonation).Delete("/users/payment-methods/{id}", paymentMethodHandler.DeletePaymentMethod)
 
 			// Product routes
 			r.Post("/products", productHandler.CreateProduct)
-			r.Get("/products", productHandler.GetProducts)
-			r.Get("/products/{id}", productHandler.GetProduct)
+			r.With(middleware.RouteTimeout(10*time.Second), middleware.NegotiateContent).Get("/products", productHandler.GetProducts)
+			r.With(middleware.RouteTimeout(5*time.Second)).Get("/products/compare", productHandler.Compare)
+			r.With(middleware.RouteTimeout(5*time.Second)).Post("/products/availability", productHandler.GetAvailability)
+			if cfg.Features.IsEnabled(config.FeatureRecommendations) {
+				r.With(middleware.RouteTimeout(5*time.Second)).Get("/products/trending", productHandler.GetTrending)
+			}
+			r.With(middleware.RouteTimeout(5*time.Second)).Get("/products/featured", productHandler.GetFeatured)
 			r.Put("/products/{id}", productHandler.UpdateProduct)
+			r.Patch("/products/{id}", productHandler.PatchProduct)
 			r.Delete("/products/{id}", productHandler.DeleteProduct)
-			r.Get("/products/{id}/similar", productHandler.GetSimilarProducts)
-			r.Post("/products/{id}/reviews", productHandler.CreateReview)
-			r.Get("/products/{id}/reviews", productHandler.GetReviews)
+			r.Post("/products/{id}/publish", productHandler.PublishProduct)
+			r.Post("/products/{id}/archive", productHandler.ArchiveProduct)
+			r.Put("/products/{id}/sale", productHandler.SetSale)
+			r.Delete("/products/{id}/sale", productHandler.ClearSale)
+			r.Put("/products/{id}/price-tiers", productHandler.SetPriceTiers)
+			r.Get("/products/{id}/translations", productHandler.GetProductTranslations)
+			r.Put("/products/{id}/translations/{locale}", productHandler.PutProductTranslation)
+			r.Delete("/products/{id}/translations/{locale}", productHandler.DeleteProductTranslation)
+			r.With(
+				middleware.DegradedMode(degradedModeService),
+				middleware.MaxBodyBytes(cfg.Server.MaxUploadBytes),
+				middleware.RouteTimeout(60*time.Second),
+			).Post("/products/{id}/images", imageHandler.UploadImage)
+			r.With(
+				middleware.DegradedMode(degradedModeService),
+				middleware.RouteTimeout(60*time.Second),
+			).Post("/products/{id}/images/import", imageHandler.ImportImages)
+			if cfg.Features.IsEnabled(config.FeatureRecommendations) {
+				r.With(middleware.DegradedMode(degradedModeService)).Get("/products/{id}/similar", productHandler.GetSimilarProducts)
+			}
+			r.With(
+				rateLimiter.LimitUser("reviews", cfg.Reviews.HourlyLimit, time.Hour, cfg.Reviews.ExemptRoles,
+					"You have posted too many reviews this hour, please try again later"),
+				rateLimiter.LimitUser("reviews", cfg.Reviews.DailyLimit, 24*time.Hour, cfg.Reviews.ExemptRoles,
+					"You have posted too many reviews today, please try again tomorrow"),
+			).Post("/products/{id}/reviews", reviewHandler.CreateReview)
+			r.Get("/products/{id}/reviews", reviewHandler.GetReviews)
+			if cfg.Features.IsEnabled(config.FeatureDeliveryEstimates) {
+				r.With(middleware.RouteTimeout(5*time.Second)).Get("/products/{id}/delivery-estimate", deliveryHandler.GetProductEstimate)
+			}
+
+			// Review routes
+			r.Put("/reviews/{id}", reviewHandler.UpdateReview)
+			r.Delete("/reviews/{id}", reviewHandler.DeleteReview)
+			r.Post("/reviews/{id}/restore", reviewHandler.RestoreReview)
+			r.Post("/reviews/{id}/reply", reviewHandler.ReplyToReview)
+
+			// Question routes, limited like reviews but counted apart
+			questionLimits := []func(http.Handler) http.Handler{
+				rateLimiter.LimitUser("questions", cfg.Reviews.HourlyLimit, time.Hour, cfg.Reviews.ExemptRoles,
+					"You have posted too many questions and answers this hour, please try again later"),
+				rateLimiter.LimitUser("questions", cfg.Reviews.DailyLimit, 24*time.Hour, cfg.Reviews.ExemptRoles,
+					"You have posted too many questions and answers today, please try again tomorrow"),
+			}
+			r.With(questionLimits...).Post("/products/{id}/questions", questionHandler.AskQuestion)
+			r.Get("/products/{id}/questions", questionHandler.GetQuestions)
+			r.With(questionLimits...).Post("/questions/{id}/answers", questionHandler.AnswerQuestion)
+			r.Post("/answers/{id}/helpful", questionHandler.VoteAnswerHelpful)
 
 			// Search routes
 			r.Get("/search", productHandler.SearchProducts)
-			r.Post("/search/semantic", productHandler.SemanticSearch)
+			r.Get("/search/suggest", productHandler.SuggestProducts)
+			if semanticSearchEnabled && cfg.Features.IsEnabled(config.FeatureSemanticSearch) {
+				r.With(
+					middleware.DegradedMode(degradedModeService),
+					middleware.RequireFeature(featureFlagService, "semantic_search"),
+					rateLimiter.LimitRoute(config.RateLimitSemanticSearch),
+					middleware.QuotaBySemanticSearch(quotaService),
+					middleware.RouteTimeout(60*time.Second),
+				).Post("/search/semantic", productHandler.SemanticSearch)
+			}
 
 			// Cart routes
 			r.Get("/cart", productHandler.GetCart)
+			r.Get("/cart/summary", productHandler.GetCartSummary)
+			r.Get("/cart/savings", productHandler.GetCartSavings)
 			r.Post("/cart", productHandler.AddToCart)
+			if cfg.Features.IsEnabled(config.FeatureGuestCartMerge) {
+				r.Post("/cart/merge", productHandler.MergeGuestCart)
+			}
+			r.With(rateLimiter.LimitRoute(config.RateLimitCoupons)).Post("/cart/coupon", productHandler.ApplyCoupon)
+			r.Delete("/cart/coupon", productHandler.RemoveCoupon)
+			r.With(rateLimiter.LimitRoute(config.RateLimitCoupons)).Post("/coupons/validate", productHandler.ValidateCoupon)
 			r.Put("/cart/{productId}", productHandler.UpdateCartItem)
 			r.Delete("/cart/{productId}", productHandler.RemoveFromCart)
+			if cfg.Features.IsEnabled(config.FeatureDeliveryEstimates) {
+				r.With(middleware.RouteTimeout(5*time.Second)).Get("/cart/delivery-estimate", deliveryHandler.GetCartEstimate)
+			}
 
 			// Wishlist routes
 			r.Get("/wishlist", productHandler.GetWishlist)
+			r.Get("/wishlist/export", productHandler.ExportWishlist)
 			r.Post("/wishlist/{productId}", productHandler.AddToWishlist)
 			r.Delete("/wishlist/{productId}", productHandler.RemoveFromWishlist)
+			r.Post("/wishlist/add-to-cart", productHandler.AddWishlistToCart)
+			r.Post("/wishlist/bulk", productHandler.AddWishlistItems)
+
+			// Seller follow routes
+			r.Post("/sellers/{id}/follow", sellerHandler.FollowSeller)
+			r.Delete("/sellers/{id}/follow", sellerHandler.UnfollowSeller)
 
 			// Order routes
-			r.Post("/orders", orderHandler.CreateOrder)
+			r.With(middleware.ForbidImpersonation).Post("/orders", orderHandler.CreateOrder)
+			r.Post("/orders/quote", orderHandler.QuoteOrder)
+			r.With(middleware.ForbidImpersonation).Post("/products/{id}/buy-now", orderHandler.BuyNow)
+			r.Get("/add-ons", orderHandler.GetAddOns)
 			r.Get("/orders", orderHandler.GetOrders)
-			r.Get("/orders/{id}", orderHandler.GetOrder)
+			r.With(middleware.NegotiateContent).Get("/orders/{id}", orderHandler.GetOrder)
+			r.With(middleware.NegotiateContent).Get("/orders/{id}/packing-slip", orderHandler.GetPackingSlip)
 			r.Put("/orders/{id}/status", orderHandler.UpdateOrderStatus)
-			r.Post("/orders/{id}/payment", orderHandler.ProcessPayment)
+			r.With(
+				middleware.MaxBodyBytes(cfg.Server.MaxUploadBytes),
+				middleware.RouteTimeout(60*time.Second),
+			).Post("/orders/{id}/deliver", orderHandler.DeliverOrder)
+			r.Get("/orders/{id}/delivery/photo", orderHandler.GetDeliveryPhoto)
+			r.With(middleware.RouteTimeout(60*time.Second)).Post("/orders/{id}/label", shippingLabelHandler.CreateLabel)
+			r.Get("/orders/{id}/label/file", shippingLabelHandler.GetLabelFile)
+			r.With(middleware.ForbidImpersonation).Post("/orders/{id}/payment", orderHandler.ProcessPayment)
+			r.Post("/orders/{id}/reorder", orderHandler.Reorder)
+			r.Post("/orders/{id}/items/{itemId}/fulfill", orderHandler.FulfillItem)
+			r.Put("/orders/{id}/items/{itemId}/backorder", orderHandler.SetBackorderETA)
+			r.Delete("/orders/{id}/items/{itemId}/backorder", orderHandler.CancelBackorder)
+			r.Put("/orders/{id}/sub-orders/{subOrderId}/status", orderHandler.UpdateSubOrderStatus)
+			r.With(middleware.ForbidImpersonation, requireSigned).Post("/orders/{id}/sub-orders/{subOrderId}/refund", orderHandler.RefundSubOrder)
+			r.With(middleware.ForbidImpersonation, requireSigned).Post("/orders/{id}/add-ons/{addOnId}/refund", orderHandler.RefundAddOn)
+			r.Post("/orders/{id}/notes", orderHandler.CreateNote)
+			r.With(middleware.NegotiateContent).Get("/orders/{id}/notes", orderHandler.GetNotes)
+			r.Post("/orders/{id}/returns", orderHandler.RequestReturn)
+			r.Get("/orders/{id}/returns", orderHandler.GetReturns)
 
 			// Notification routes
 			r.Get("/notifications", notificationHandler.GetNotifications)
 			r.Put("/notifications/{id}/read", notificationHandler.MarkAsRead)
 			r.Delete("/notifications/{id}", notificationHandler.DeleteNotification)
+
+			// Experiment routes
+			r.Post("/experiments/{key}/conversions", experimentHandler.RecordConversion)
+
+			// Seller routes
+			r.Route("/seller", func(r chi.Router) {
+				r.Use(middleware.RequireRole(middleware.RoleSeller))
+
+				r.Post("/stock/adjust", inventoryHandler.AdjustStock)
+				r.Post("/deliveries", inventoryHandler.ReceiveDelivery)
+				r.Get("/bulk-jobs/{id}", inventoryHandler.GetBulkJob)
+				r.With(rateLimiter.LimitRoute(config.RateLimitReprice)).Post("/products/reprice", productHandler.RepriceProducts)
+				r.Get("/products/{id}/price-history", productHandler.GetSellerPriceHistory)
+				r.Get("/products/{id}/stock-history", inventoryHandler.GetStockHistory)
+				r.Get("/fulfillment", orderHandler.GetFulfillmentQueue)
+				r.Post("/orders/ship", orderHandler.ShipOrders)
+				r.Get("/payouts", commissionHandler.GetPayoutSummary)
+				r.Get("/availability", sellerHandler.GetAvailability)
+				r.Put("/availability", sellerHandler.SetAvailability)
+				r.Get("/location", sellerHandler.GetLocation)
+				r.Put("/location", sellerHandler.SetLocation)
+				r.Delete("/location", sellerHandler.ClearLocation)
+			})
+
+			// Webhook routes
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Use(middleware.RequireRole(middleware.RoleSeller, middleware.RoleAdmin))
+
+				r.Get("/", webhookHandler.ListWebhooks)
+				r.Post("/", webhookHandler.CreateWebhook)
+				r.Get("/versions", webhookHandler.GetPayloadVersions)
+				r.Get("/{id}", webhookHandler.GetWebhook)
+				r.Put("/{id}", webhookHandler.UpdateWebhook)
+				r.Delete("/{id}", webhookHandler.DeleteWebhook)
+				r.Post("/{id}/rotate-secret", webhookHandler.RotateWebhookSecret)
+				r.Get("/{id}/deliveries", webhookHandler.GetWebhookDeliveries)
+				r.Post("/{id}/test", webhookHandler.TestWebhook)
+			})
+
+			// Admin routes
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(middleware.RequireRole(middleware.RoleAdmin))
+
+				r.Get("/feature-flags", featureFlagHandler.ListFlags)
+				r.With(requireSigned).Post("/feature-flags", featureFlagHandler.CreateFlag)
+				r.Get("/feature-flags/{key}", featureFlagHandler.GetFlag)
+				r.With(requireSigned).Put("/feature-flags/{key}", featureFlagHandler.UpdateFlag)
+				r.With(requireSigned).Delete("/feature-flags/{key}", featureFlagHandler.DeleteFlag)
+
+				r.Get("/experiments/{key}/results", experimentHandler.GetResults)
+
+				r.Get("/content", contentHandler.ListContentBlocks)
+				r.With(requireSigned).Post("/content", contentHandler.CreateContentBlock)
+				r.Get("/content/{id}", contentHandler.GetContentBlock)
+				r.With(requireSigned).Put("/content/{id}", contentHandler.UpdateContentBlock)
+				r.With(requireSigned).Delete("/content/{id}", contentHandler.DeleteContentBlock)
+
+				r.With(middleware.NegotiateContent).Get("/orders", orderHandler.SearchOrders)
+				r.With(middleware.RouteTimeout(5*time.Minute)).Get("/orders/export", orderHandler.ExportOrders)
+				r.With(requireSigned).Post("/orders/transition", orderHandler.TransitionOrders)
+				r.With(middleware.NegotiateContent).Get("/orders/{id}/payment-attempts", orderHandler.GetPaymentAttempts)
+				r.Get("/orders/{id}/events", orderHandler.GetOrderEvents)
+				r.Get("/orders/{id}/fraud", orderHandler.GetOrderFraud)
+				r.With(requireSigned).Post("/orders/{id}/fraud", orderHandler.ReviewHeldOrder)
+
+				r.Get("/returns", orderHandler.SearchReturns)
+				r.With(requireSigned).Post("/returns/{id}/approve", orderHandler.ApproveReturn)
+				r.With(requireSigned).Post("/returns/{id}/reject", orderHandler.RejectReturn)
+				r.With(requireSigned).Post("/returns/{id}/receive", orderHandler.ReceiveReturn)
+				r.Put("/products/{id}/return-window", orderHandler.SetProductReturnWindow)
+				r.Put("/categories/{id}/return-window", orderHandler.SetCategoryReturnWindow)
+				r.Put("/products/{id}/tax-exemption", orderHandler.SetProductTaxExemption)
+				r.Put("/categories/{id}/tax-exemption", orderHandler.SetCategoryTaxExemption)
+				r.Put("/users/{id}/tax-exemption", orderHandler.SetUserTaxExemption)
+
+				r.Get("/add-ons", orderHandler.ListAllAddOns)
+				r.Post("/add-ons", orderHandler.CreateAddOn)
+				r.Put("/add-ons/{id}", orderHandler.UpdateAddOn)
+				r.With(requireSigned).Post("/coupons", productHandler.CreateCoupon)
+				r.With(requireSigned).Delete("/coupons/{id}", productHandler.DeactivateCoupon)
+
+				r.With(requireSigned).Delete("/reviews/{id}", reviewHandler.HardDeleteReview)
+				r.With(requireSigned).Post("/reviews/import", reviewHandler.ImportReviews)
+				r.Get("/reviews/imported", reviewHandler.ListImportedReviews)
+				r.With(requireSigned).Post("/reviews/imported/moderate", reviewHandler.ModerateImportedReviews)
+
+				r.Get("/products/featured", productHandler.ListFeatured)
+				r.Put("/products/{id}/featured", productHandler.SetFeatured)
+				r.Post("/products/tags", productHandler.TagProducts)
+				r.Post("/products/categories", productHandler.CategorizeProducts)
+				r.With(requireSigned).Post("/products/recategorize", productHandler.RecategorizeProducts)
+				r.With(requireSigned).Post("/products/merge", productHandler.MergeProducts)
+				r.Get("/products/merges/{id}", productHandler.GetProductMerge)
+				r.With(requireSigned).Post("/products/merges/{id}/revert", productHandler.RevertProductMerge)
+				r.Put("/categories/reorder", productHandler.ReorderCategories)
+
+				r.Get("/search/analytics", productHandler.GetSearchAnalytics)
+				r.With(rateLimiter.LimitRoute(config.RateLimitAdminStats), middleware.RouteTimeout(30*time.Second)).Get("/stats", statsHandler.GetStats)
+
+				r.With(requireSigned).Post("/notifications/broadcast", broadcastHandler.CreateBroadcast)
+				r.Get("/notifications/broadcasts/{id}", broadcastHandler.GetBroadcast)
+
+				r.Get("/sellers", sellerHandler.ListSellers)
+				r.With(requireSigned).Put("/sellers/{id}/status", sellerHandler.SetSellerStatus)
+				r.Get("/sellers/{id}/status-history", sellerHandler.GetSellerStatusHistory)
+				r.Get("/sellers/{id}/payouts", commissionHandler.GetSellerPayoutSummary)
+
+				r.Get("/commission-rates", commissionHandler.ListCommissionRates)
+				r.With(requireSigned).Post("/commission-rates", commissionHandler.SetCommissionRate)
+				r.With(requireSigned).Delete("/commission-rates/{id}", commissionHandler.DeleteCommissionRate)
+
+				r.With(rateLimiter.LimitRoute(config.RateLimitAdminUsers)).Get("/users", accountHandler.SearchUsers)
+				r.With(requireSigned).Post("/users/{id}/suspend", accountHandler.SuspendUser)
+				r.With(requireSigned).Post("/users/{id}/unsuspend", accountHandler.UnsuspendUser)
+				r.Get("/users/{id}/suspensions", accountHandler.GetSuspensions)
+				r.With(requireSigned).Post("/users/{id}/impersonate", accountHandler.ImpersonateUser)
+				r.Get("/users/{id}/impersonations", accountHandler.GetImpersonations)
+				r.Get("/users/{id}/deletion", accountHandler.GetAccountDeletion)
+				r.Get("/impersonations/{id}", accountHandler.GetImpersonation)
+				r.Get("/impersonations/{id}/requests", accountHandler.GetImpersonatedRequests)
+				r.Post("/impersonations/{id}/revoke", accountHandler.RevokeImpersonation)
+
+				r.With(requireSigned, rateLimiter.LimitRoute(config.RateLimitRetentionPurge)).Post("/retention/purge", retentionHandler.Purge)
+
+				r.Get("/alerts", alertHandler.ListAlerts)
+				r.Post("/alerts/{id}/ack", alertHandler.AcknowledgeAlert)
+
+				r.Get("/maintenance", maintenanceHandler.ListMaintenanceWindows)
+				r.With(requireSigned).Post("/maintenance", maintenanceHandler.ScheduleMaintenanceWindow)
+				r.With(requireSigned).Put("/maintenance/{id}", maintenanceHandler.UpdateMaintenanceWindow)
+				r.With(requireSigned).Delete("/maintenance/{id}", maintenanceHandler.CancelMaintenanceWindow)
+
+				r.Get("/degraded-mode", degradedModeHandler.GetDegradedMode)
+				r.With(requireSigned).Put("/degraded-mode", degradedModeHandler.SetDegradedMode)
+				r.With(requireSigned).Delete("/degraded-mode", degradedModeHandler.ResetDegradedMode)
+			})
 		})
 	})
 
@@ -176,17 +658,127 @@ func main() {
 		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
 		Handler:      r,
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: 15 * time.Second, // moved per request by middleware.Timeout and RouteTimeout
 		IdleTimeout:  60 * time.Second,
+		TLSConfig:    tlsConfig,
+	}
+	if cfg.TLS.Enabled && cfg.TLS.DisableHTTP2 {
+		// A non-nil map stops net/http from enabling HTTP/2 automatically
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	// Start background workers. They stop after the servers, so in-flight
+	// requests can still write outbox events.
+	shutdown.Go("job worker", jobWorker.Run)
+	shutdown.Go("outbox relay", outboxRelay.Run)
+	shutdown.Go("cache invalidation", appCache.Run)
+	shutdown.Go("health events", healthBus.Run)
+	healthInterval := time.Duration(cfg.Health.CheckInterval) * time.Second
+	shutdown.Go("database health watch", func(ctx context.Context) {
+		healthBus.Watch(ctx, "database", healthInterval, db.PingContext)
+	})
+	shutdown.Go("redis health watch", func(ctx context.Context) {
+		healthBus.Watch(ctx, "redis", healthInterval, func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		})
+	})
+	shutdown.Go("job queue stats scheduler", func(ctx context.Context) {
+		scheduler.Every(ctx, "job_queue_stats", time.Duration(cfg.Jobs.StatsInterval)*time.Second, func(ctx context.Context) error {
+			_, err := jobQueue.Stats(ctx)
+			return err
+		})
+	})
+	shutdown.Go("sale scheduler", func(ctx context.Context) {
+		// Prices follow sale windows exactly; listing order and price
+		// filters catch up within the interval
+		scheduler.Every(ctx, "sale_transitions", 30*time.Second, productService.RunSaleTransitions)
+	})
+	shutdown.Go("notification digest scheduler", func(ctx context.Context) {
+		scheduler.Every(ctx, "notification_digests", time.Duration(cfg.Notifications.DigestInterval)*time.Second, notificationService.RunDigests)
+	})
+	shutdown.Go("view flush scheduler", func(ctx context.Context) {
+		scheduler.Every(ctx, "view_flush", time.Duration(cfg.Views.FlushInterval)*time.Second, productService.RunViewFlush)
+	})
+	if cfg.Inventory.ConsistencyCheckInterval > 0 {
+		shutdown.Go("stock consistency scheduler", func(ctx context.Context) {
+			scheduler.Every(ctx, "stock_consistency", time.Duration(cfg.Inventory.ConsistencyCheckInterval)*time.Second, inventoryService.RunConsistencyChecks)
+		})
+	}
+	if cfg.Orders.EventCheckInterval > 0 {
+		shutdown.Go("order event check scheduler", func(ctx context.Context) {
+			scheduler.Every(ctx, "order_event_checks", time.Duration(cfg.Orders.EventCheckInterval)*time.Second, orderService.RunOrderEventChecks)
+		})
+	}
+	if cfg.Orders.UnpaidCancelInterval > 0 {
+		shutdown.Go("unpaid order cancel scheduler", func(ctx context.Context) {
+			scheduler.Every(ctx, "unpaid_order_cancels", time.Duration(cfg.Orders.UnpaidCancelInterval)*time.Second, orderService.RunUnpaidOrderCancels)
+		})
+	}
+	if cfg.Alerts.CheckInterval > 0 {
+		shutdown.Go("alert check scheduler", func(ctx context.Context) {
+			scheduler.Every(ctx, "alert_checks", time.Duration(cfg.Alerts.CheckInterval)*time.Second, alertService.RunAlertChecks)
+		})
+	}
+	if cfg.Inventory.BackorderFillInterval > 0 {
+		shutdown.Go("backorder fill scheduler", func(ctx context.Context) {
+			scheduler.Every(ctx, "backorder_fills", time.Duration(cfg.Inventory.BackorderFillInterval)*time.Second, orderService.RunBackorderFills)
+		})
+	}
+	if cfg.Retention.PurgeInterval > 0 {
+		shutdown.Go("retention purge scheduler", func(ctx context.Context) {
+			scheduler.Every(ctx, "retention_purge", time.Duration(cfg.Retention.PurgeInterval)*time.Second, retentionService.RunPurges)
+		})
 	}
 
 	// Start server in a goroutine
 	go func() {
-		log.Info().Int("port", cfg.Server.Port).Msg("Starting server")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLS.Enabled {
+			log.Info().Int("port", cfg.Server.Port).Bool("http2", !cfg.TLS.DisableHTTP2).Str("commit", version.Commit).Msg("Starting HTTPS server")
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			log.Info().Int("port", cfg.Server.Port).Str("commit", version.Commit).Msg("Starting server")
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("Server failed to start")
 		}
 	}()
+	shutdown.OnShutdown("http server", srv.Shutdown)
+
+	// Redirect plain HTTP to HTTPS
+	if cfg.TLS.Enabled && cfg.TLS.HTTPPort > 0 {
+		redirectSrv := &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.TLS.HTTPPort),
+			Handler:      redirectHandler,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+		}
+		go func() {
+			log.Info().Int("port", cfg.TLS.HTTPPort).Msg("Starting HTTP redirect server")
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("HTTP redirect server failed")
+			}
+		}()
+		shutdown.OnShutdown("http redirect server", redirectSrv.Shutdown)
+	}
+
+	// Reload the rate limits and cache TTLs from the config file on SIGHUP.
+	// A file that fails validation is ignored and the current ones are kept.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			reloaded, err := config.Load(*configFile)
+			if err != nil {
+				log.Error().Err(err).Msg("Config reload failed, keeping current rate limits and cache TTLs")
+				continue
+			}
+			rateLimiter.SetLimits(reloaded.RateLimits)
+			cacheTTLs.Set(reloaded.CacheTTLs)
+			log.Info().Msg("Rate limits and cache TTLs reloaded")
+		}
+	}()
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
@@ -194,13 +786,8 @@ func main() {
 	<-quit
 	log.Info().Msg("Shutting down server...")
 
-	// Give the server 5 seconds to finish current requests
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Error().Err(err).Msg("Server forced to shutdown")
+	if err := shutdown.Shutdown(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Shutdown did not complete cleanly")
 	}
-
 	log.Info().Msg("Server exited")
-}
\ No newline at end of file
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+// newTLSConfig builds the server TLS configuration and the handler for the
+// plain HTTP listener. Static certificates are loaded eagerly so unreadable
+// files fail startup instead of the first handshake.
+func newTLSConfig(cfg config.TLSConfig, httpsPort int) (*tls.Config, http.Handler, error) {
+	redirect := httpsRedirect(httpsPort)
+
+	if cfg.AutoCert {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Domain),
+			Cache:      autocert.DirCache(cfg.CacheDir),
+		}
+		tlsConfig := manager.TLSConfig()
+		if cfg.DisableHTTP2 {
+			tlsConfig.NextProtos = []string{"http/1.1", "acme-tls/1"}
+		}
+		// The HTTP listener must also answer ACME http-01 challenges
+		return tlsConfig, manager.HTTPHandler(redirect), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if cfg.DisableHTTP2 {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+	return tlsConfig, redirect, nil
+}
+
+// httpsRedirect permanently redirects plain HTTP requests to HTTPS
+func httpsRedirect(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
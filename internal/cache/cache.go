@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/metrics"
+)
+
+const (
+	keyPrefix = "cache:"
+	tagPrefix = "cache:tag:"
+	// invalidationChannel carries invalidated tags to every replica's local tier
+	invalidationChannel = "cache:invalidate"
+)
+
+// LoadFunc loads the value to cache on a miss
+type LoadFunc func(ctx context.Context) (interface{}, error)
+
+// Cache is a two-tier read-through cache: a small in-process LRU (L1) in
+// front of Redis (L2). Concurrent misses for the same key share a single
+// load, and entries can be tagged so related keys are invalidated together.
+type Cache struct {
+	redis *database.RedisClient
+	local *localCache // nil when the local tier is disabled
+	ttls  *TTLs
+	group singleflight.Group
+}
+
+// New creates a new cache, caching each type for its TTL in ttls. The local
+// tier is disabled when cfg.LocalSize is not positive.
+func New(redis *database.RedisClient, cfg config.CacheConfig, ttls *TTLs) *Cache {
+	c := &Cache{redis: redis, ttls: ttls}
+	if cfg.LocalSize > 0 {
+		c.local = newLocalCache(cfg.LocalSize, time.Duration(cfg.LocalTTL)*time.Second)
+	}
+	return c
+}
+
+// GetOrSet is GetOrSetWithTTL for a cache type named in config, cached for
+// the type's TTL as configured when the value is loaded
+func (c *Cache) GetOrSet(ctx context.Context, name, key string, tags []string, dest interface{}, load LoadFunc) error {
+	return c.GetOrSetWithTTL(ctx, name, key, c.ttls.Get(name), tags, dest, load)
+}
+
+// GetOrSetWithTTL decodes the cached value for key into dest, checking the
+// local tier, then Redis, then calling load. A loaded value is cached in
+// both tiers for ttl (the local tier caps it at its own TTL) and added to
+// each of tags for InvalidateTags. name identifies the cache in metrics.
+// When Redis is unavailable loaded values are kept in the local tier only.
+// A cached value that doesn't decode into dest, corrupted or written before
+// its type changed, is dropped and counts as a miss, so the load replaces
+// it.
+//
+// Keys must be built only from inputs that are the same for every caller;
+// per-user data must never be cached under a shared key.
+func (c *Cache) GetOrSetWithTTL(ctx context.Context, name, key string, ttl time.Duration, tags []string, dest interface{}, load LoadFunc) error {
+	key = keyPrefix + name + ":" + key
+
+	if c.local != nil {
+		if data, ok := c.local.get(key); ok {
+			err := decode(data, dest)
+			if err == nil {
+				metrics.CacheRequests.WithLabelValues(name, "l1", "hit").Inc()
+				return nil
+			}
+			c.local.delete(key)
+			corrupt(name, "l1", key, err)
+		}
+		metrics.CacheRequests.WithLabelValues(name, "l1", "miss").Inc()
+	}
+
+	data, err := c.redis.Get(ctx, key)
+	switch {
+	case err == nil:
+		if decodeErr := decode([]byte(data), dest); decodeErr != nil {
+			if err := c.redis.Client.Del(ctx, key).Err(); err != nil {
+				log.Warn().Err(err).Str("key", key).Msg("Failed to delete corrupt cache entry")
+			}
+			corrupt(name, "l2", key, decodeErr)
+			metrics.CacheRequests.WithLabelValues(name, "l2", "miss").Inc()
+			err = redis.Nil
+			break
+		}
+		metrics.CacheRequests.WithLabelValues(name, "l2", "hit").Inc()
+		if c.local != nil {
+			c.local.set(key, []byte(data), ttl, tags)
+		}
+		return nil
+	case err == redis.Nil:
+		metrics.CacheRequests.WithLabelValues(name, "l2", "miss").Inc()
+	default:
+		metrics.CacheRequests.WithLabelValues(name, "l2", "error").Inc()
+		log.Warn().Err(err).Str("key", key).Msg("Cache read failed, loading directly")
+	}
+	redisUp := err == redis.Nil
+
+	// Callers share the load, so it must not be cut short by whichever
+	// request happened to start it
+	loadCtx := context.WithoutCancel(ctx)
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		value, err := load(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal cache value: %w", err)
+		}
+		if redisUp {
+			if err := c.set(loadCtx, key, encoded, ttl, tags); err != nil {
+				log.Warn().Err(err).Str("key", key).Msg("Cache write failed")
+			}
+		}
+		if c.local != nil {
+			c.local.set(key, encoded, ttl, tags)
+		}
+		return encoded, nil
+	})
+	if err != nil {
+		return err
+	}
+	// Each caller decodes its own copy of the shared result
+	return json.Unmarshal(v.([]byte), dest)
+}
+
+// decode decodes a cached value into dest, leaving dest zeroed when it fails
+// so no part of a bad value is kept
+func decode(data []byte, dest interface{}) error {
+	if err := json.Unmarshal(data, dest); err != nil {
+		if v := reflect.ValueOf(dest); v.Kind() == reflect.Pointer && !v.IsNil() {
+			v.Elem().SetZero()
+		}
+		return err
+	}
+	return nil
+}
+
+// corrupt records a cached value that failed to decode
+func corrupt(name, tier, key string, err error) {
+	metrics.CacheCorrupt.WithLabelValues(name, tier).Inc()
+	log.Warn().Err(err).Str("key", key).Str("tier", tier).Msg("Corrupt cache entry dropped, reloading")
+}
+
+// InvalidateTags deletes every entry tagged with any of tags from both
+// tiers, including the local tiers of other replicas
+func (c *Cache) InvalidateTags(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	var firstErr error
+	for _, tag := range tags {
+		setKey := tagPrefix + tag
+		keys, err := c.redis.SMembers(ctx, setKey).Result()
+		if err == nil {
+			err = c.redis.Client.Del(ctx, append(keys, setKey)...).Err()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to invalidate cache tag %s: %w", tag, err)
+		}
+	}
+
+	// Clear the local tier even when Redis is unavailable
+	if c.local != nil {
+		c.local.invalidate(tags)
+		if data, err := json.Marshal(tags); err == nil {
+			if err := c.redis.Publish(ctx, invalidationChannel, data).Err(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("failed to publish cache invalidation: %w", err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Run applies invalidations published by other replicas to the local tier
+// until ctx is cancelled
+func (c *Cache) Run(ctx context.Context) {
+	if c.local == nil {
+		return
+	}
+
+	sub := c.redis.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var tags []string
+			if err := json.Unmarshal([]byte(msg.Payload), &tags); err != nil {
+				log.Warn().Err(err).Msg("Invalid cache invalidation message")
+				continue
+			}
+			c.local.invalidate(tags)
+		}
+	}
+}
+
+func (c *Cache) set(ctx context.Context, key string, data []byte, ttl time.Duration, tags []string) error {
+	_, err := c.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, ttl)
+		for _, tag := range tags {
+			setKey := tagPrefix + tag
+			pipe.SAdd(ctx, setKey, key)
+			// Tag sets outlive their entries a little so no entry is orphaned
+			pipe.Expire(ctx, setKey, ttl+time.Minute)
+		}
+		return nil
+	})
+	return err
+}
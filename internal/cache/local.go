@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// localEntry is a value held in the in-process tier
+type localEntry struct {
+	data    []byte
+	expires time.Time
+	tags    []string
+}
+
+// localCache is the in-process (L1) tier: a bounded LRU whose entries live
+// for the shorter of their own TTL and the configured local TTL. Entries are
+// indexed by tag so tag invalidation can drop them. It is safe for
+// concurrent use.
+type localCache struct {
+	ttl time.Duration
+	lru *expirable.LRU[string, localEntry]
+
+	mu   sync.Mutex
+	tags map[string]map[string]struct{}
+}
+
+func newLocalCache(size int, ttl time.Duration) *localCache {
+	l := &localCache{ttl: ttl, tags: make(map[string]map[string]struct{})}
+	l.lru = expirable.NewLRU[string, localEntry](size, l.evicted, ttl)
+	return l
+}
+
+func (l *localCache) get(key string) ([]byte, bool) {
+	e, ok := l.lru.Get(key)
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.data, true
+}
+
+func (l *localCache) set(key string, data []byte, ttl time.Duration, tags []string) {
+	if ttl <= 0 || ttl > l.ttl {
+		ttl = l.ttl
+	}
+
+	l.mu.Lock()
+	for _, tag := range tags {
+		keys, ok := l.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			l.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	l.lru.Add(key, localEntry{data: data, expires: time.Now().Add(ttl), tags: tags})
+}
+
+// delete drops the entry for key
+func (l *localCache) delete(key string) {
+	l.lru.Remove(key)
+}
+
+// invalidate drops every entry tagged with any of tags
+func (l *localCache) invalidate(tags []string) {
+	var keys []string
+	l.mu.Lock()
+	for _, tag := range tags {
+		for key := range l.tags[tag] {
+			keys = append(keys, key)
+		}
+		delete(l.tags, tag)
+	}
+	l.mu.Unlock()
+
+	for _, key := range keys {
+		l.lru.Remove(key)
+	}
+}
+
+// evicted removes an evicted or expired entry from the tag index
+func (l *localCache) evicted(key string, e localEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, tag := range e.tags {
+		if keys, ok := l.tags[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(l.tags, tag)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+// fallbackTTL is the TTL of a cache type without a default, which only a
+// type missing from config.DefaultCacheTTLs can be
+const fallbackTTL = time.Minute
+
+// TTLs are the TTLs of the cache types: those set in cache_ttls, and the
+// defaults for the rest. They can be replaced while running, applying to
+// entries cached from then on.
+type TTLs struct {
+	ttls atomic.Pointer[map[string]time.Duration] // read on every cache write
+}
+
+// NewTTLs creates the cache type TTLs from configured, in seconds per type
+func NewTTLs(configured map[string]int) *TTLs {
+	t := &TTLs{}
+	t.Set(configured)
+	return t
+}
+
+// Set replaces the TTLs with configured over the defaults
+func (t *TTLs) Set(configured map[string]int) {
+	ttls := make(map[string]time.Duration, len(config.DefaultCacheTTLs))
+	for name, seconds := range config.DefaultCacheTTLs {
+		if configured[name] > 0 {
+			seconds = configured[name]
+		}
+		ttls[name] = time.Duration(seconds) * time.Second
+	}
+	t.ttls.Store(&ttls)
+}
+
+// Get returns the TTL of cacheType
+func (t *TTLs) Get(cacheType string) time.Duration {
+	if ttl, ok := (*t.ttls.Load())[cacheType]; ok {
+		return ttl
+	}
+	return fallbackTTL
+}
@@ -3,24 +3,87 @@ package config
 import (
 	"fmt"
 	"os"
+	"sort"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/greens-marketplace/internal/money"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Environment string        `yaml:"environment"`
-	Server      ServerConfig  `yaml:"server"`
-	Database    DatabaseConfig `yaml:"database"`
-	Redis       RedisConfig   `yaml:"redis"`
-	JWT         JWTConfig     `yaml:"jwt"`
-	OpenAI      OpenAIConfig  `yaml:"openai"`
+	Environment    string                      `yaml:"environment"`
+	Server         ServerConfig                `yaml:"server"`
+	TLS            TLSConfig                   `yaml:"tls"`
+	Database       DatabaseConfig              `yaml:"database"`
+	Redis          RedisConfig                 `yaml:"redis"`
+	JWT            JWTConfig                   `yaml:"jwt"`
+	OpenAI         OpenAIConfig                `yaml:"openai"`
+	Search         SearchConfig                `yaml:"search"`
+	Quotas         QuotaConfig                 `yaml:"quotas"`
+	Degraded       DegradedConfig              `yaml:"degraded"`
+	Storage        StorageConfig               `yaml:"storage"`
+	Inventory      InventoryConfig             `yaml:"inventory"`
+	Orders         OrdersConfig                `yaml:"orders"`
+	Fraud          FraudConfig                 `yaml:"fraud"`
+	Returns        ReturnsConfig               `yaml:"returns"`
+	Bulk           BulkConfig                  `yaml:"bulk"`
+	Retention      RetentionConfig             `yaml:"retention"`
+	Cart           CartConfig                  `yaml:"cart"`
+	Pagination     PaginationConfig            `yaml:"pagination"`
+	Pricing        PricingConfig               `yaml:"pricing"`
+	Cache          CacheConfig                 `yaml:"cache"`
+	AdminSigning   AdminSigningConfig          `yaml:"admin_signing"`
+	Captcha        CaptchaConfig               `yaml:"captcha"`
+	PasswordPolicy PasswordPolicyConfig        `yaml:"password_policy"`
+	EmailResend    EmailResendConfig           `yaml:"email_resend"`
+	Reviews        ReviewConfig                `yaml:"reviews"`
+	Views          ViewConfig                  `yaml:"views"`
+	Notifications  NotificationConfig          `yaml:"notifications"`
+	Delivery       DeliveryConfig              `yaml:"delivery"`
+	Webhooks       WebhookConfig               `yaml:"webhooks"`
+	Health         HealthConfig                `yaml:"health"`
+	Alerts         AlertsConfig                `yaml:"alerts"`
+	Experiments    map[string]ExperimentConfig `yaml:"experiments"`
+	Features       FeaturesConfig              `yaml:"features"`
+	HTTPCache      HTTPCacheConfig             `yaml:"http_cache"`
+	Shipping       ShippingConfig              `yaml:"shipping"`
+	Tax            TaxConfig                   `yaml:"tax"`
+	Jobs           JobsConfig                  `yaml:"jobs"`
+	RateLimits     map[string]RateLimitConfig  `yaml:"rate_limits"`
+	CacheTTLs      map[string]int              `yaml:"cache_ttls"` // seconds per cache type; 0 or unset uses DefaultCacheTTLs
+	Payments       PaymentsConfig              `yaml:"payments"`
+	Labels         LabelsConfig                `yaml:"labels"`
+	Logging        LoggingConfig               `yaml:"logging"`
 }
 
 // ServerConfig represents server configuration
 type ServerConfig struct {
 	Port int    `yaml:"port"`
 	Host string `yaml:"host"`
+	// Request body limits, in bytes
+	MaxBodyBytes       int64 `yaml:"max_body_bytes"`       // applied to every route
+	MaxUploadBytes     int64 `yaml:"max_upload_bytes"`     // overrides MaxBodyBytes on multipart upload routes
+	MaxMultipartMemory int64 `yaml:"max_multipart_memory"` // parts beyond this are spilled to disk
+	// Shutdown deadlines, in seconds
+	ShutdownTimeout     int `yaml:"shutdown_timeout"`      // for every component together
+	ShutdownHookTimeout int `yaml:"shutdown_hook_timeout"` // for any one component
+}
+
+// TLSConfig represents TLS termination configuration. When enabled the
+// server serves HTTPS (with HTTP/2) on Server.Port and redirects plain HTTP
+// from HTTPPort.
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// AutoCert obtains certificates from Let's Encrypt for Domain instead of
+	// loading CertFile/KeyFile
+	AutoCert     bool   `yaml:"auto_cert"`
+	Domain       string `yaml:"domain"`
+	CacheDir     string `yaml:"cache_dir"`
+	HTTPPort     int    `yaml:"http_port"` // port of the HTTP to HTTPS redirect listener, 0 disables it
+	DisableHTTP2 bool   `yaml:"disable_http2"`
 }
 
 // DatabaseConfig represents database configuration
@@ -39,20 +102,573 @@ type RedisConfig struct {
 	Port     int    `yaml:"port"`
 	Password string `yaml:"password"`
 	DB       int    `yaml:"db"`
+	// KeyPrefix is prepended to every key and Pub/Sub channel, isolating
+	// environments or apps sharing a Redis instance; include a separator,
+	// like "staging:"
+	KeyPrefix string `yaml:"key_prefix"`
 }
 
 // JWTConfig represents JWT configuration
 type JWTConfig struct {
 	Secret     string `yaml:"secret"`
 	Expiration int    `yaml:"expiration"` // in hours
+	// Issuer and Audience are set as the iss and aud claims of issued tokens
+	// and required on verified tokens; empty values disable the check
+	Issuer    string `yaml:"issuer"`
+	Audience  string `yaml:"audience"`
+	ClockSkew int    `yaml:"clock_skew"` // leeway on exp, nbf and iat, in seconds
+	// ImpersonationExpiration is how long a token issued for an admin
+	// impersonating a user lasts, in minutes
+	ImpersonationExpiration int `yaml:"impersonation_expiration"`
+	// Alg is the signing algorithm: HS256 (default, signed with Secret) or an
+	// asymmetric RS*/ES* algorithm signed with PrivateKeyFile
+	Alg            string `yaml:"alg"`
+	PrivateKeyFile string `yaml:"private_key_file"` // PEM encoded
+	KeyID          string `yaml:"key_id"`           // kid of the signing key
+	// VerificationKeys are public keys still accepted alongside the signing
+	// key, such as the previous key while tokens it signed are still valid
+	VerificationKeys []JWTKeyConfig `yaml:"verification_keys"`
+}
+
+// maxJWTClockSkew bounds jwt.clock_skew: every second of leeway is a second
+// an expired token is still accepted
+const maxJWTClockSkew = 300
+
+// maxImpersonationExpiration bounds jwt.impersonation_expiration, in minutes
+const maxImpersonationExpiration = 60
+
+// JWTKeyConfig represents a public key accepted for token verification
+type JWTKeyConfig struct {
+	KeyID         string `yaml:"key_id"`
+	PublicKeyFile string `yaml:"public_key_file"` // PEM encoded
 }
 
 // OpenAIConfig represents OpenAI configuration
 type OpenAIConfig struct {
-	APIKey     string `yaml:"api_key"`
-	Model      string `yaml:"model"`
-	MaxTokens  int    `yaml:"max_tokens"`
+	APIKey      string  `yaml:"api_key"`
+	Model       string  `yaml:"model"`
+	MaxTokens   int     `yaml:"max_tokens"`
 	Temperature float32 `yaml:"temperature"`
+	BaseURL     string  `yaml:"base_url"`
+	// StartupCheck makes a live API call at startup to confirm the key and
+	// model are usable; when false only the key format is checked
+	StartupCheck bool `yaml:"startup_check"`
+}
+
+// SearchConfig represents product search configuration
+type SearchConfig struct {
+	Backend       string              `yaml:"backend"` // postgres or elasticsearch
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
+	Fuzzy         FuzzySearchConfig   `yaml:"fuzzy"`
+}
+
+// FuzzySearchConfig represents typo-tolerant matching of keyword searches
+// that find few results. Only the postgres backend matches fuzzily.
+type FuzzySearchConfig struct {
+	MinResults int     `yaml:"min_results"` // searches finding fewer also match fuzzily; 0 disables
+	Threshold  float64 `yaml:"threshold"`   // pg_trgm word similarity a title needs, in (0, 1]
+}
+
+// ElasticsearchConfig represents Elasticsearch/OpenSearch configuration
+type ElasticsearchConfig struct {
+	URL      string `yaml:"url"`
+	Index    string `yaml:"index"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// QuotaConfig represents per-plan daily usage quotas
+type QuotaConfig struct {
+	ResetHourUTC int `yaml:"reset_hour_utc"` // hour of day (0-23, UTC) daily quotas reset
+	// SemanticSearch maps a plan to its daily semantic searches; a negative
+	// limit is unlimited and unknown plans get the free plan's limit
+	SemanticSearch map[string]int `yaml:"semantic_search"`
+}
+
+// CacheConfig represents the in-process cache tier kept in front of Redis
+type CacheConfig struct {
+	LocalSize int `yaml:"local_size"` // max entries per replica; 0 disables the local tier
+	LocalTTL  int `yaml:"local_ttl"`  // seconds; bounds how stale a replica's local entry can be
+}
+
+// HTTPCacheConfig sets the caching headers of cacheable public GET route
+// groups, for browsers and CDNs
+type HTTPCacheConfig struct {
+	Categories  CachePolicy `yaml:"categories"`
+	Products    CachePolicy `yaml:"products"` // product detail and price history
+	Collections CachePolicy `yaml:"collections"`
+}
+
+// CachePolicy sets how long, in seconds, a route group's anonymous responses
+// may be reused. A zero MaxAge has browsers revalidate every time.
+type CachePolicy struct {
+	MaxAge               int `yaml:"max_age"`                // browsers, through Cache-Control
+	SharedMaxAge         int `yaml:"shared_max_age"`         // CDNs, through Surrogate-Control; 0 uses MaxAge
+	StaleWhileRevalidate int `yaml:"stale_while_revalidate"` // a stale copy may be served while it is refetched
+}
+
+// InventoryConfig represents stock management configuration
+type InventoryConfig struct {
+	LowStockThreshold int `yaml:"low_stock_threshold"` // sellers are notified when stock falls to this level
+	// ConsistencyCheckInterval is how often, in seconds, product stock is
+	// reconciled against the stock ledger; 0 disables the check
+	ConsistencyCheckInterval int `yaml:"consistency_check_interval"`
+	// BackorderFillInterval is how often, in seconds, backordered order
+	// lines are filled from returned stock; 0 disables filling
+	BackorderFillInterval int `yaml:"backorder_fill_interval"`
+	BackorderETADays      int `yaml:"backorder_eta_days"` // a backorder's expected date until its seller sets one
+	// PreorderCharge is when orders with preorders are paid for: "order"
+	// at checkout like any other, or "ship" once every preorder on the
+	// order is filled and it can ship
+	PreorderCharge string `yaml:"preorder_charge"`
+}
+
+// OrdersConfig represents order lifecycle configuration
+type OrdersConfig struct {
+	// EventCheckInterval is how often, in seconds, each order's stored
+	// state is checked against its folded event log; 0 disables the check
+	EventCheckInterval int `yaml:"event_check_interval"`
+	// SelfPurchase is what happens when sellers order their own products:
+	// "block" refuses those lines at checkout, "flag" orders them marked as
+	// self-purchases, left out of marketplace stats and raised as alerts
+	SelfPurchase string `yaml:"self_purchase"`
+	// UnpaidCancelInterval is how often, in seconds, orders left unpaid
+	// past their timeout are cancelled; 0 disables the sweep
+	UnpaidCancelInterval int `yaml:"unpaid_cancel_interval"`
+	// UnpaidTimeout is how long, in minutes, an order may stay pending
+	// before it is cancelled. UnpaidTimeouts overrides it by the order's
+	// payment method; 0 leaves orders of that method pending.
+	UnpaidTimeout  int            `yaml:"unpaid_timeout"`
+	UnpaidTimeouts map[string]int `yaml:"unpaid_timeouts"`
+}
+
+// FraudConfig represents the rules scoring orders for fraud at checkout
+// and payment. Each rule that matches adds its score, 0 turning it off, and
+// orders scoring at least HoldScore are held for an admin to review.
+type FraudConfig struct {
+	HoldScore int `yaml:"hold_score"` // 0 holds no orders
+	// ScoreTimeout is how long, in milliseconds, scoring may take before
+	// the order goes ahead unscored
+	ScoreTimeout int `yaml:"score_timeout"`
+	// SharedCard matches a card stored by at least SharedCardAccounts
+	// other accounts
+	SharedCardAccounts int `yaml:"shared_card_accounts"`
+	SharedCardScore    int `yaml:"shared_card_score"`
+	// CountryMismatch matches a card issued in another country than the
+	// order's shipping address
+	CountryMismatchScore int `yaml:"country_mismatch_score"`
+	// Velocity matches a buyer with at least VelocityOrders other orders
+	// placed in the last VelocityWindow minutes
+	VelocityOrders int `yaml:"velocity_orders"`
+	VelocityWindow int `yaml:"velocity_window"`
+	VelocityScore  int `yaml:"velocity_score"`
+}
+
+// ReturnsConfig represents customer returns. WindowDays is how many days
+// after delivery items may be returned, for products whose product and
+// category set no window of their own; 0 makes them not returnable.
+type ReturnsConfig struct {
+	WindowDays int `yaml:"window_days"`
+}
+
+// BulkConfig represents the item limits of bulk endpoints. Their arrays are
+// read one item at a time, and a request is rejected with 413 as soon as it
+// goes past the limit, without reading the rest of the body.
+type BulkConfig struct {
+	StockAdjustItems  int `yaml:"stock_adjust_items"`  // items per stock adjustment
+	DeliveryItems     int `yaml:"delivery_items"`      // items per supplier delivery
+	ProductItems      int `yaml:"product_items"`       // products per bulk tag or category change
+	ReviewImportItems int `yaml:"review_import_items"` // reviews per review import
+	// InlineStockAdjustItems is the most items a stock adjustment applies
+	// during the request; larger ones are queued as background jobs
+	InlineStockAdjustItems int `yaml:"inline_stock_adjust_items"`
+}
+
+// RetentionConfig represents how long rows are kept before the purge job
+// deletes them. Orders, and everything recording them, are under legal hold
+// and never purged.
+type RetentionConfig struct {
+	PurgeInterval int            `yaml:"purge_interval"` // seconds between purges; 0 disables them
+	BatchSize     int            `yaml:"batch_size"`     // rows deleted per transaction
+	DryRun        bool           `yaml:"dry_run"`        // count what would be purged, deleting nothing
+	Days          map[string]int `yaml:"days"`           // days kept, by entity; 0 keeps it forever
+}
+
+// CartConfig represents soft holds on limited products in carts. A hold
+// keeps a cart's quantity from other buyers for HoldTTL seconds after it was
+// last added or changed. Cart summaries are cached for SummaryTTL seconds.
+type CartConfig struct {
+	HoldTTL       int `yaml:"hold_ttl"`       // in seconds; 0 disables holds
+	HoldThreshold int `yaml:"hold_threshold"` // products with at most this much stock are held; 0 holds every product
+	SummaryTTL    int `yaml:"summary_ttl"`    // in seconds; 0 disables caching
+
+	WishlistMaxItems int `yaml:"wishlist_max_items"` // products a wishlist can hold
+}
+
+// PaginationConfig represents the page size of list endpoints that don't
+// set their own
+type PaginationConfig struct {
+	DefaultPageSize int `yaml:"default_page_size"` // when limit is not given
+	MaxPageSize     int `yaml:"max_page_size"`     // larger limits are clamped to this
+}
+
+// PricingConfig represents limits on the prices sellers set in bulk and how
+// much of the price history is public
+type PricingConfig struct {
+	// Lowest price a bulk reprice may set, per currency in major units such
+	// as "0.50"; other currencies go down to one minor unit
+	Floors            map[string]string `yaml:"floors"`
+	PublicHistoryDays int               `yaml:"public_history_days"` // days of price history shown to anyone
+}
+
+// StorageConfig represents blob storage configuration for uploaded files
+type StorageConfig struct {
+	Backend  string   `yaml:"backend"`   // local or s3
+	LocalDir string   `yaml:"local_dir"` // root directory of the local backend
+	S3       S3Config `yaml:"s3"`
+}
+
+// S3Config represents S3 (or S3-compatible) storage configuration
+type S3Config struct {
+	Bucket   string `yaml:"bucket"`
+	Region   string `yaml:"region"`
+	Endpoint string `yaml:"endpoint"` // for S3-compatible services such as MinIO
+	// Static credentials; when empty the default AWS credential chain is used
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	ForcePathStyle  bool   `yaml:"force_path_style"`
+}
+
+// AdminSigningConfig represents HMAC request signing required on destructive
+// admin endpoints in addition to the admin JWT
+type AdminSigningConfig struct {
+	Secret  string `yaml:"secret"`   // shared with admin tooling; signed routes reject all requests when empty
+	MaxSkew int    `yaml:"max_skew"` // seconds a signature timestamp may differ from server time
+}
+
+// CaptchaConfig represents bot protection on sign-up and sign-in. A client
+// IP making more than FreeAttempts requests to a protected route within
+// Window seconds must solve a CAPTCHA; when disabled no CAPTCHA is asked.
+type CaptchaConfig struct {
+	Enabled      bool    `yaml:"enabled"`
+	Provider     string  `yaml:"provider"` // recaptcha, hcaptcha or turnstile
+	SecretKey    string  `yaml:"secret_key"`
+	MinScore     float64 `yaml:"min_score"` // reCAPTCHA v3 tokens scoring lower fail
+	FreeAttempts int     `yaml:"free_attempts"`
+	Window       int     `yaml:"window"` // in seconds
+}
+
+// PasswordPolicyConfig represents the rules passwords must meet at
+// registration and reset. The breach check looks passwords up in Have I
+// Been Pwned by the first 5 characters of their SHA-1 hash only
+// (k-anonymity); passwords pass it when the service can't be reached.
+type PasswordPolicyConfig struct {
+	MinLength          int    `yaml:"min_length"` // in characters
+	MaxLength          int    `yaml:"max_length"` // in bytes; bcrypt ignores what follows the first 72
+	RequireUpper       bool   `yaml:"require_upper"`
+	RequireLower       bool   `yaml:"require_lower"`
+	RequireDigit       bool   `yaml:"require_digit"`
+	RequireSymbol      bool   `yaml:"require_symbol"`
+	BreachCheck        bool   `yaml:"breach_check"`
+	BreachCheckURL     string `yaml:"breach_check_url"`     // range API, to which the hash prefix is appended
+	BreachCheckTimeout int    `yaml:"breach_check_timeout"` // in milliseconds
+}
+
+// EmailResendConfig represents the limits on sending account emails
+// (verification, password reset and two-factor codes), per user and purpose
+type EmailResendConfig struct {
+	Limits      map[string]EmailLimitConfig `yaml:"limits"` // by purpose: verification, password_reset, two_factor
+	ExemptRoles []string                    `yaml:"exempt_roles"`
+}
+
+// EmailLimitConfig represents the limits on sending one kind of email
+type EmailLimitConfig struct {
+	Cooldown    int `yaml:"cooldown"`     // seconds between sends; 0 is none
+	HourlyLimit int `yaml:"hourly_limit"` // sends per rolling hour; 0 is unlimited
+}
+
+// ReviewConfig represents abuse protection on product reviews. Users with
+// one of ExemptRoles are not limited.
+type ReviewConfig struct {
+	HourlyLimit int `yaml:"hourly_limit"` // reviews a user may post per hour; 0 is unlimited
+	DailyLimit  int `yaml:"daily_limit"`  // reviews a user may post per day; 0 is unlimited
+	// MinAccountAge is how many hours an account must exist before it can
+	// review a product it hasn't bought; with RequirePurchase set only
+	// buyers with a delivered order of the product may review it
+	MinAccountAge   int      `yaml:"min_account_age"`
+	RequirePurchase bool     `yaml:"require_purchase"`
+	EditCooldown    int      `yaml:"edit_cooldown"` // seconds between edits of a review
+	ExemptRoles     []string `yaml:"exempt_roles"`
+}
+
+// ViewConfig represents product view counting for trending products. Each
+// viewer counts once per product per DedupWindow seconds and at most
+// MaxPerViewer times an hour.
+type ViewConfig struct {
+	DedupWindow   int `yaml:"dedup_window"`   // in seconds
+	MaxPerViewer  int `yaml:"max_per_viewer"` // counted views per viewer per hour
+	FlushInterval int `yaml:"flush_interval"` // seconds between flushes of counts to the database
+	TrendingTTL   int `yaml:"trending_ttl"`   // seconds trending lists are cached
+	RecentLimit   int `yaml:"recent_limit"`   // recently viewed products kept per user; 0 disables tracking
+	RecentTTL     int `yaml:"recent_ttl"`     // seconds a user's recently viewed list is kept after their last view
+}
+
+// NotificationConfig represents the daily notification digests. A user's
+// digest covers what accumulated up to DigestHour and is sent at the first
+// check after it.
+type NotificationConfig struct {
+	DigestHour      int `yaml:"digest_hour"`      // hour of the day, UTC
+	DigestInterval  int `yaml:"digest_interval"`  // seconds between checks for due digests
+	DigestRetention int `yaml:"digest_retention"` // days sent digest items are kept to recognize redelivered events
+	// SMTP emails notifications to users who take email notifications; an
+	// empty host leaves notifications in-app only
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+// SMTPConfig represents the mail server notifications are emailed through
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	Timeout  int    `yaml:"timeout"` // seconds to connect and send one email
+}
+
+// DeliveryConfig represents delivery date estimates. Estimates for postal
+// codes without a zone, or zones a warehouse has no transit time to, use the
+// default transit days.
+type DeliveryConfig struct {
+	DefaultWarehouse      string `yaml:"default_warehouse"`       // warehouse of products without one
+	DefaultProcessingDays int    `yaml:"default_processing_days"` // business days to ship products without their own
+	DefaultMinDays        int    `yaml:"default_min_days"`        // transit business days
+	DefaultMaxDays        int    `yaml:"default_max_days"`
+	CacheTTL              int    `yaml:"cache_ttl"` // seconds transit times are cached per warehouse and zone
+}
+
+// ShippingConfig is the rate table of the shipping methods offered at
+// checkout. Amounts are decimal strings keyed by currency code.
+type ShippingConfig struct {
+	Methods []ShippingMethodConfig `yaml:"methods"`
+}
+
+// ShippingMethodConfig is a shipping method's cost and transit time. Carts in
+// a currency the method has no rate for can't ship by it, except that a
+// method without any rates is free in every currency. Standard shipping
+// takes the delivery estimate's transit time to the destination; the other
+// methods take MinDays to MaxDays business days after processing. A method
+// with SellerHours can only be chosen while every seller of the order is
+// open and accepting orders; standard shipping always can.
+type ShippingMethodConfig struct {
+	Method      string            `yaml:"method"` // standard, express or pickup
+	Name        string            `yaml:"name"`
+	Rates       map[string]string `yaml:"rates"`
+	FreeOver    map[string]string `yaml:"free_over"` // subtotal after discounts from which the method is free
+	MinDays     int               `yaml:"min_days"`
+	MaxDays     int               `yaml:"max_days"`
+	SellerHours bool              `yaml:"seller_hours"`
+}
+
+// TaxConfig is the sales tax charged at checkout. Every jurisdiction
+// matching the shipping address taxes the order, each at its own rate, so
+// an address may owe both a country's or state's tax and a city's. Without
+// jurisdictions no tax is charged.
+type TaxConfig struct {
+	Jurisdictions []TaxJurisdictionConfig `yaml:"jurisdictions"`
+}
+
+// TaxJurisdictionConfig is a jurisdiction's sales tax. It applies to
+// addresses in Country and, when State is set, in that state.
+type TaxJurisdictionConfig struct {
+	Code    string `yaml:"code"`    // such as US-CA, unique
+	Name    string `yaml:"name"`    // the tax's label, such as California sales tax
+	Country string `yaml:"country"` // two-letter ISO 3166 code
+	State   string `yaml:"state"`   // state or province as addresses give it; empty for the whole country
+	Rate    string `yaml:"rate"`    // percent of the goods after discounts, such as 7.25
+}
+
+// JobsConfig represents background job monitoring
+type JobsConfig struct {
+	MaxPendingAge int `yaml:"max_pending_age"` // seconds the oldest pending job may wait before readiness reports degraded
+	StatsInterval int `yaml:"stats_interval"`  // seconds between samples of the queue for metrics
+}
+
+// PaymentsConfig represents the payment gateway buyers' cards are stored at
+// and charged through. Without a secret key cards can't be stored.
+type PaymentsConfig struct {
+	Gateway   string `yaml:"gateway"` // stripe
+	SecretKey string `yaml:"secret_key"`
+	BaseURL   string `yaml:"base_url"`
+	Timeout   int    `yaml:"timeout"` // seconds the gateway has to answer
+}
+
+// LabelsConfig represents the carrier shipping labels are bought from.
+// Without an API key labels can't be bought. ServiceLevel picks the
+// carrier service by its token; empty takes the cheapest rate.
+type LabelsConfig struct {
+	Carrier      string `yaml:"carrier"` // shippo
+	APIKey       string `yaml:"api_key"`
+	BaseURL      string `yaml:"base_url"`
+	Timeout      int    `yaml:"timeout"` // seconds the carrier has to answer
+	ServiceLevel string `yaml:"service_level"`
+	FileType     string `yaml:"file_type"` // PDF, PDF_4x6 or PNG
+}
+
+// Rate limit names, each covering a route or group of routes
+const (
+	RateLimitGlobal         = "global" // every request, per client IP
+	RateLimitSemanticSearch = "semantic_search"
+	RateLimitReprice        = "reprice"
+	RateLimitAdminStats     = "admin_stats"
+	RateLimitRetentionPurge = "retention_purge"
+	RateLimitAdminUsers     = "admin_users"
+	RateLimitCoupons        = "coupons" // applying and validating coupon codes, against guessing them
+)
+
+var rateLimitNames = map[string]bool{
+	RateLimitGlobal:         true,
+	RateLimitSemanticSearch: true,
+	RateLimitReprice:        true,
+	RateLimitAdminStats:     true,
+	RateLimitRetentionPurge: true,
+	RateLimitAdminUsers:     true,
+	RateLimitCoupons:        true,
+}
+
+// Cache types, each a kind of cached value with its own TTL in cache_ttls
+const (
+	CacheProductList         = "product_list"
+	CacheCategories          = "categories"
+	CacheCategoryFilters     = "category_filters"
+	CacheProductAvailability = "product_availability"
+	CacheContent             = "content"
+	CacheAdminStats          = "admin_stats"
+	CacheUserStats           = "user_stats"
+	CacheFeatureFlags        = "feature_flags"
+	CacheUserPlans           = "user_plans"
+	CacheAccountStatus       = "account_status"
+)
+
+// DefaultCacheTTLs are the TTLs, in seconds, of the cache types cache_ttls
+// leaves unset or at 0
+var DefaultCacheTTLs = map[string]int{
+	CacheProductList:         30,
+	CacheCategories:          300,
+	CacheCategoryFilters:     300,
+	CacheProductAvailability: 15,
+	CacheContent:             300,
+	CacheAdminStats:          300,
+	CacheUserStats:           60,
+	CacheFeatureFlags:        30,
+	CacheUserPlans:           300,
+	CacheAccountStatus:       600,
+}
+
+// RateLimitConfig limits the requests to a route to Requests per Window
+// seconds, counted per client IP or, with By set to user, per
+// authenticated user
+type RateLimitConfig struct {
+	Requests int    `yaml:"requests"`
+	Window   int    `yaml:"window"`
+	By       string `yaml:"by"` // ip or user; defaults to ip
+}
+
+// WebhookConfig represents outbound webhook requests
+type WebhookConfig struct {
+	Timeout int `yaml:"timeout"` // seconds a subscriber has to answer
+	// InboundLockTTL is how long, in seconds, a provider's event is locked
+	// while a delivery of it is processed
+	InboundLockTTL int `yaml:"inbound_lock_ttl"`
+}
+
+// HealthConfig represents the watch kept on the database and Redis. Each
+// replica checks them and reports when one goes unhealthy or recovers.
+type HealthConfig struct {
+	CheckInterval int `yaml:"check_interval"` // seconds between checks
+	// AlertWebhookURL is an ops channel's webhook, posted each change of
+	// state; empty only logs them
+	AlertWebhookURL string `yaml:"alert_webhook_url"`
+	AlertTimeout    int    `yaml:"alert_timeout"` // seconds the webhook has to answer
+}
+
+// AlertsConfig represents the scheduled check raising system alerts for
+// admins. Thresholds count over the last hour; 0 disables their alert.
+type AlertsConfig struct {
+	CheckInterval   int `yaml:"check_interval"`   // seconds between checks; 0 disables them
+	WebhookFailures int `yaml:"webhook_failures"` // failed deliveries of one subscription
+	LowStockSpike   int `yaml:"low_stock_spike"`  // products falling to low stock
+}
+
+// LoggingConfig represents log redaction. Values of the fields named in
+// RedactFields, at any depth of a log line, are masked with utils.Redact.
+type LoggingConfig struct {
+	RedactFields []string `yaml:"redact_fields"`
+}
+
+// DegradedConfig represents the default degraded mode state. Admins can
+// override it at runtime; the override is shared through Redis.
+type DegradedConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Message    string `yaml:"message"`
+	RetryAfter int    `yaml:"retry_after"` // in seconds
+}
+
+// Features a deployment can switch off, by name
+const (
+	FeatureSemanticSearch    = "semantic_search"
+	FeatureRecommendations   = "recommendations"
+	FeatureGuestCartMerge    = "guest_cart_merge"
+	FeatureDeliveryEstimates = "delivery_estimates"
+)
+
+// FeaturesConfig switches features on or off per deployment. A feature
+// that is off has its routes left unregistered, so they answer 404, and
+// its feature flag is off whatever its rollout.
+type FeaturesConfig struct {
+	SemanticSearch    bool `yaml:"semantic_search"`
+	Recommendations   bool `yaml:"recommendations"` // similar, trending and recently viewed products
+	GuestCartMerge    bool `yaml:"guest_cart_merge"`
+	DeliveryEstimates bool `yaml:"delivery_estimates"`
+}
+
+func (f FeaturesConfig) byName() map[string]bool {
+	return map[string]bool{
+		FeatureSemanticSearch:    f.SemanticSearch,
+		FeatureRecommendations:   f.Recommendations,
+		FeatureGuestCartMerge:    f.GuestCartMerge,
+		FeatureDeliveryEstimates: f.DeliveryEstimates,
+	}
+}
+
+// IsEnabled reports whether feature name is on. Names that aren't
+// deployment features, such as other feature flags, are on.
+func (f FeaturesConfig) IsEnabled(name string) bool {
+	on, ok := f.byName()[name]
+	return on || !ok
+}
+
+// Enabled returns the names of the features that are on, sorted
+func (f FeaturesConfig) Enabled() []string {
+	names := []string{}
+	for name, on := range f.byName() {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExperimentConfig represents an A/B experiment and its traffic split
+type ExperimentConfig struct {
+	Enabled  bool            `yaml:"enabled"`
+	Variants []VariantConfig `yaml:"variants"`
+}
+
+// VariantConfig represents one arm of an experiment
+type VariantConfig struct {
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"` // relative share of traffic
 }
 
 // Load loads configuration from a YAML file
@@ -62,7 +678,8 @@ func Load(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var cfg Config
+	// Start from defaults so keys missing from the file keep sensible values
+	cfg := *DefaultConfig()
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
@@ -74,6 +691,21 @@ func Load(filename string) (*Config, error) {
 	if port := os.Getenv("SERVER_PORT"); port != "" {
 		fmt.Sscanf(port, "%d", &cfg.Server.Port)
 	}
+	if maxBody := os.Getenv("SERVER_MAX_BODY_BYTES"); maxBody != "" {
+		fmt.Sscanf(maxBody, "%d", &cfg.Server.MaxBodyBytes)
+	}
+	if maxUpload := os.Getenv("SERVER_MAX_UPLOAD_BYTES"); maxUpload != "" {
+		fmt.Sscanf(maxUpload, "%d", &cfg.Server.MaxUploadBytes)
+	}
+	if tlsEnabled := os.Getenv("TLS_ENABLED"); tlsEnabled != "" {
+		cfg.TLS.Enabled = tlsEnabled == "true"
+	}
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		cfg.TLS.CertFile = certFile
+	}
+	if keyFile := os.Getenv("TLS_KEY_FILE"); keyFile != "" {
+		cfg.TLS.KeyFile = keyFile
+	}
 	if dbHost := os.Getenv("DB_HOST"); dbHost != "" {
 		cfg.Database.Host = dbHost
 	}
@@ -92,20 +724,384 @@ func Load(filename string) (*Config, error) {
 	if jwtSecret := os.Getenv("JWT_SECRET"); jwtSecret != "" {
 		cfg.JWT.Secret = jwtSecret
 	}
+	if adminSecret := os.Getenv("ADMIN_SIGNING_SECRET"); adminSecret != "" {
+		cfg.AdminSigning.Secret = adminSecret
+	}
+	if captchaEnabled := os.Getenv("CAPTCHA_ENABLED"); captchaEnabled != "" {
+		cfg.Captcha.Enabled = captchaEnabled == "true"
+	}
+	if captchaSecret := os.Getenv("CAPTCHA_SECRET_KEY"); captchaSecret != "" {
+		cfg.Captcha.SecretKey = captchaSecret
+	}
+	if jwtIssuer := os.Getenv("JWT_ISSUER"); jwtIssuer != "" {
+		cfg.JWT.Issuer = jwtIssuer
+	}
+	if jwtAudience := os.Getenv("JWT_AUDIENCE"); jwtAudience != "" {
+		cfg.JWT.Audience = jwtAudience
+	}
+	if jwtAlg := os.Getenv("JWT_ALG"); jwtAlg != "" {
+		cfg.JWT.Alg = jwtAlg
+	}
+	if jwtKeyFile := os.Getenv("JWT_PRIVATE_KEY_FILE"); jwtKeyFile != "" {
+		cfg.JWT.PrivateKeyFile = jwtKeyFile
+	}
+	if jwtKeyID := os.Getenv("JWT_KEY_ID"); jwtKeyID != "" {
+		cfg.JWT.KeyID = jwtKeyID
+	}
 	if openaiKey := os.Getenv("OPENAI_API_KEY"); openaiKey != "" {
 		cfg.OpenAI.APIKey = openaiKey
 	}
+	if searchBackend := os.Getenv("SEARCH_BACKEND"); searchBackend != "" {
+		cfg.Search.Backend = searchBackend
+	}
+	if esURL := os.Getenv("ELASTICSEARCH_URL"); esURL != "" {
+		cfg.Search.Elasticsearch.URL = esURL
+	}
+	if alertURL := os.Getenv("HEALTH_ALERT_WEBHOOK_URL"); alertURL != "" {
+		cfg.Health.AlertWebhookURL = alertURL
+	}
+	if storageBackend := os.Getenv("STORAGE_BACKEND"); storageBackend != "" {
+		cfg.Storage.Backend = storageBackend
+	}
+	if s3Bucket := os.Getenv("S3_BUCKET"); s3Bucket != "" {
+		cfg.Storage.S3.Bucket = s3Bucket
+	}
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		cfg.Notifications.SMTP.Host = smtpHost
+	}
+	if smtpPassword := os.Getenv("SMTP_PASSWORD"); smtpPassword != "" {
+		cfg.Notifications.SMTP.Password = smtpPassword
+	}
+	if paymentKey := os.Getenv("PAYMENT_GATEWAY_SECRET_KEY"); paymentKey != "" {
+		cfg.Payments.SecretKey = paymentKey
+	}
+	if labelsKey := os.Getenv("SHIPPING_LABELS_API_KEY"); labelsKey != "" {
+		cfg.Labels.APIKey = labelsKey
+	}
+	if degraded := os.Getenv("DEGRADED_MODE"); degraded != "" {
+		cfg.Degraded.Enabled = degraded == "true"
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
+// Validate checks the configuration for inconsistent settings
+func (c *Config) Validate() error {
+	if c.TLS.Enabled {
+		if c.TLS.AutoCert {
+			if c.TLS.Domain == "" {
+				return fmt.Errorf("tls.domain is required when tls.auto_cert is enabled")
+			}
+		} else if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			return fmt.Errorf("tls.cert_file and tls.key_file are required when tls is enabled")
+		}
+	}
+	switch c.JWT.Alg {
+	case "HS256", "HS384", "HS512":
+	case "RS256", "RS384", "RS512", "ES256", "ES384", "ES512":
+		if c.JWT.PrivateKeyFile == "" || c.JWT.KeyID == "" {
+			return fmt.Errorf("jwt.private_key_file and jwt.key_id are required for jwt.alg %s", c.JWT.Alg)
+		}
+		for _, key := range c.JWT.VerificationKeys {
+			if key.KeyID == "" || key.PublicKeyFile == "" {
+				return fmt.Errorf("jwt.verification_keys entries require key_id and public_key_file")
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported jwt.alg %q", c.JWT.Alg)
+	}
+	if c.JWT.ClockSkew < 0 || c.JWT.ClockSkew > maxJWTClockSkew {
+		return fmt.Errorf("jwt.clock_skew must be between 0 and %d seconds", maxJWTClockSkew)
+	}
+	if c.JWT.ImpersonationExpiration < 1 || c.JWT.ImpersonationExpiration > maxImpersonationExpiration {
+		return fmt.Errorf("jwt.impersonation_expiration must be between 1 and %d minutes", maxImpersonationExpiration)
+	}
+	switch c.Storage.Backend {
+	case "local":
+		if c.Storage.LocalDir == "" {
+			return fmt.Errorf("storage.local_dir is required when storage.backend is local")
+		}
+	case "s3":
+		if c.Storage.S3.Bucket == "" || c.Storage.S3.Region == "" {
+			return fmt.Errorf("storage.s3.bucket and storage.s3.region are required when storage.backend is s3")
+		}
+	default:
+		return fmt.Errorf("unknown storage.backend %q", c.Storage.Backend)
+	}
+	if c.AdminSigning.MaxSkew <= 0 {
+		return fmt.Errorf("admin_signing.max_skew must be positive")
+	}
+	if c.PasswordPolicy.MinLength < 1 || c.PasswordPolicy.MaxLength < c.PasswordPolicy.MinLength {
+		return fmt.Errorf("password_policy.min_length must be positive and at most password_policy.max_length")
+	}
+	if c.PasswordPolicy.BreachCheck && (c.PasswordPolicy.BreachCheckURL == "" || c.PasswordPolicy.BreachCheckTimeout <= 0) {
+		return fmt.Errorf("password_policy.breach_check_url and a positive password_policy.breach_check_timeout are required when password_policy.breach_check is enabled")
+	}
+	if c.Captcha.Enabled {
+		switch c.Captcha.Provider {
+		case "recaptcha", "hcaptcha", "turnstile":
+		default:
+			return fmt.Errorf("unknown captcha.provider %q", c.Captcha.Provider)
+		}
+		if c.Captcha.SecretKey == "" {
+			return fmt.Errorf("captcha.secret_key is required when captcha is enabled")
+		}
+		if c.Captcha.FreeAttempts < 0 || c.Captcha.Window <= 0 {
+			return fmt.Errorf("captcha.free_attempts must not be negative and captcha.window must be positive")
+		}
+	}
+	for purpose, limit := range c.EmailResend.Limits {
+		if limit.Cooldown < 0 || limit.HourlyLimit < 0 {
+			return fmt.Errorf("email_resend.limits.%s.cooldown and hourly_limit must not be negative", purpose)
+		}
+	}
+	if c.Reviews.HourlyLimit < 0 || c.Reviews.DailyLimit < 0 {
+		return fmt.Errorf("reviews.hourly_limit and reviews.daily_limit must not be negative")
+	}
+	if c.Reviews.MinAccountAge < 0 || c.Reviews.EditCooldown < 0 {
+		return fmt.Errorf("reviews.min_account_age and reviews.edit_cooldown must not be negative")
+	}
+	if c.Notifications.DigestHour < 0 || c.Notifications.DigestHour > 23 {
+		return fmt.Errorf("notifications.digest_hour must be between 0 and 23")
+	}
+	if c.Notifications.DigestInterval <= 0 || c.Notifications.DigestRetention <= 0 {
+		return fmt.Errorf("notifications.digest_interval and notifications.digest_retention must be positive")
+	}
+	if smtp := c.Notifications.SMTP; smtp.Host != "" {
+		if smtp.From == "" {
+			return fmt.Errorf("notifications.smtp.from is required when notifications.smtp.host is set")
+		}
+		if smtp.Port <= 0 || smtp.Timeout <= 0 {
+			return fmt.Errorf("notifications.smtp.port and notifications.smtp.timeout must be positive")
+		}
+	}
+	if c.Views.DedupWindow <= 0 || c.Views.MaxPerViewer <= 0 || c.Views.FlushInterval <= 0 || c.Views.TrendingTTL <= 0 {
+		return fmt.Errorf("views.dedup_window, views.max_per_viewer, views.flush_interval and views.trending_ttl must be positive")
+	}
+	if c.Delivery.DefaultProcessingDays < 0 || c.Delivery.DefaultMinDays < 0 {
+		return fmt.Errorf("delivery.default_processing_days and delivery.default_min_days must not be negative")
+	}
+	if c.Delivery.DefaultMaxDays < c.Delivery.DefaultMinDays {
+		return fmt.Errorf("delivery.default_max_days must be at least delivery.default_min_days")
+	}
+	if c.Delivery.CacheTTL <= 0 {
+		return fmt.Errorf("delivery.cache_ttl must be positive")
+	}
+	if c.Jobs.MaxPendingAge <= 0 || c.Jobs.StatsInterval <= 0 {
+		return fmt.Errorf("jobs.max_pending_age and jobs.stats_interval must be positive")
+	}
+	shippingMethods := map[string]bool{}
+	for _, method := range c.Shipping.Methods {
+		switch method.Method {
+		case "standard", "express", "pickup":
+		default:
+			return fmt.Errorf("shipping.methods: unknown method %q", method.Method)
+		}
+		if shippingMethods[method.Method] {
+			return fmt.Errorf("shipping.methods: %s is listed twice", method.Method)
+		}
+		shippingMethods[method.Method] = true
+		if method.MinDays < 0 || method.MaxDays < method.MinDays {
+			return fmt.Errorf("shipping.methods: %s needs 0 <= min_days <= max_days", method.Method)
+		}
+		if method.SellerHours && method.Method == "standard" {
+			return fmt.Errorf("shipping.methods: standard can't need seller_hours")
+		}
+	}
+	if !shippingMethods["standard"] {
+		return fmt.Errorf("shipping.methods must include standard, the default method")
+	}
+	taxCodes := map[string]bool{}
+	for _, j := range c.Tax.Jurisdictions {
+		if j.Code == "" || taxCodes[j.Code] {
+			return fmt.Errorf("tax.jurisdictions: each needs a unique code, %q is missing or listed twice", j.Code)
+		}
+		taxCodes[j.Code] = true
+		if len(j.Country) != 2 {
+			return fmt.Errorf("tax.jurisdictions: %s needs a two-letter country", j.Code)
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 || c.Server.ShutdownHookTimeout <= 0 {
+		return fmt.Errorf("server.shutdown_timeout and server.shutdown_hook_timeout must be positive")
+	}
+	if c.Webhooks.Timeout <= 0 || c.Webhooks.InboundLockTTL <= 0 {
+		return fmt.Errorf("webhooks.timeout and webhooks.inbound_lock_ttl must be positive")
+	}
+	if c.Health.CheckInterval <= 0 || c.Health.AlertTimeout <= 0 {
+		return fmt.Errorf("health.check_interval and health.alert_timeout must be positive")
+	}
+	if c.Payments.Gateway != "stripe" {
+		return fmt.Errorf("payments.gateway: unknown gateway %q", c.Payments.Gateway)
+	}
+	if c.Payments.Timeout <= 0 {
+		return fmt.Errorf("payments.timeout must be positive")
+	}
+	if c.Labels.Carrier != "shippo" {
+		return fmt.Errorf("labels.carrier: unknown carrier %q", c.Labels.Carrier)
+	}
+	if c.Labels.Timeout <= 0 {
+		return fmt.Errorf("labels.timeout must be positive")
+	}
+	switch c.Labels.FileType {
+	case "PDF", "PDF_4x6", "PNG":
+	default:
+		return fmt.Errorf("labels.file_type must be PDF, PDF_4x6 or PNG")
+	}
+	limitNames := make([]string, 0, len(c.RateLimits))
+	for name := range c.RateLimits {
+		limitNames = append(limitNames, name)
+	}
+	sort.Strings(limitNames)
+	for _, name := range limitNames {
+		limit := c.RateLimits[name]
+		if !rateLimitNames[name] {
+			return fmt.Errorf("rate_limits: unknown route %q", name)
+		}
+		if limit.Requests <= 0 || limit.Window <= 0 {
+			return fmt.Errorf("rate_limits.%s: requests and window must be positive", name)
+		}
+		switch limit.By {
+		case "", "ip":
+		case "user":
+			if name == RateLimitGlobal {
+				return fmt.Errorf("rate_limits.global is applied before authentication and can only be by ip")
+			}
+		default:
+			return fmt.Errorf("rate_limits.%s: by must be ip or user", name)
+		}
+	}
+	ttlNames := make([]string, 0, len(c.CacheTTLs))
+	for name := range c.CacheTTLs {
+		ttlNames = append(ttlNames, name)
+	}
+	sort.Strings(ttlNames)
+	for _, name := range ttlNames {
+		if _, ok := DefaultCacheTTLs[name]; !ok {
+			return fmt.Errorf("cache_ttls: unknown cache type %q", name)
+		}
+		if c.CacheTTLs[name] < 0 {
+			return fmt.Errorf("cache_ttls.%s must not be negative", name)
+		}
+	}
+	for name, policy := range map[string]CachePolicy{
+		"categories": c.HTTPCache.Categories, "products": c.HTTPCache.Products, "collections": c.HTTPCache.Collections,
+	} {
+		if policy.MaxAge < 0 || policy.SharedMaxAge < 0 || policy.StaleWhileRevalidate < 0 {
+			return fmt.Errorf("http_cache.%s ages must not be negative", name)
+		}
+	}
+	if c.Cache.LocalSize > 0 && c.Cache.LocalTTL <= 0 {
+		return fmt.Errorf("cache.local_ttl must be positive when the local cache is enabled")
+	}
+	if c.Cart.HoldTTL < 0 || c.Cart.HoldThreshold < 0 || c.Cart.SummaryTTL < 0 {
+		return fmt.Errorf("cart.hold_ttl, cart.hold_threshold and cart.summary_ttl must not be negative")
+	}
+	if c.Cart.WishlistMaxItems <= 0 {
+		return fmt.Errorf("cart.wishlist_max_items must be positive")
+	}
+	if c.Pagination.DefaultPageSize <= 0 || c.Pagination.DefaultPageSize > c.Pagination.MaxPageSize {
+		return fmt.Errorf("pagination.default_page_size must be positive and at most pagination.max_page_size")
+	}
+	if c.Pricing.PublicHistoryDays <= 0 {
+		return fmt.Errorf("pricing.public_history_days must be positive")
+	}
+	for currency, floor := range c.Pricing.Floors {
+		if _, err := money.Parse(floor, currency); err != nil {
+			return fmt.Errorf("pricing.floors.%s: %w", currency, err)
+		}
+	}
+	if c.Views.RecentLimit < 0 || (c.Views.RecentLimit > 0 && c.Views.RecentTTL <= 0) {
+		return fmt.Errorf("views.recent_limit must not be negative and views.recent_ttl must be positive when it is set")
+	}
+	if c.Inventory.LowStockThreshold < 0 {
+		return fmt.Errorf("inventory.low_stock_threshold must not be negative")
+	}
+	if c.Inventory.BackorderFillInterval < 0 || c.Inventory.BackorderETADays <= 0 {
+		return fmt.Errorf("inventory.backorder_fill_interval must not be negative and inventory.backorder_eta_days must be positive")
+	}
+	if c.Inventory.PreorderCharge != "order" && c.Inventory.PreorderCharge != "ship" {
+		return fmt.Errorf("inventory.preorder_charge must be order or ship")
+	}
+	if c.Orders.EventCheckInterval < 0 {
+		return fmt.Errorf("orders.event_check_interval must not be negative")
+	}
+	if c.Orders.SelfPurchase != "block" && c.Orders.SelfPurchase != "flag" {
+		return fmt.Errorf("orders.self_purchase must be block or flag")
+	}
+	if c.Orders.UnpaidCancelInterval < 0 || c.Orders.UnpaidTimeout <= 0 {
+		return fmt.Errorf("orders.unpaid_cancel_interval must not be negative and orders.unpaid_timeout must be positive")
+	}
+	for method, minutes := range c.Orders.UnpaidTimeouts {
+		if minutes < 0 {
+			return fmt.Errorf("orders.unpaid_timeouts.%s must not be negative", method)
+		}
+	}
+	if c.Fraud.HoldScore < 0 || c.Fraud.SharedCardScore < 0 || c.Fraud.CountryMismatchScore < 0 || c.Fraud.VelocityScore < 0 {
+		return fmt.Errorf("fraud.hold_score and fraud rule scores must not be negative")
+	}
+	if c.Fraud.ScoreTimeout <= 0 || c.Fraud.SharedCardAccounts <= 0 || c.Fraud.VelocityOrders <= 0 || c.Fraud.VelocityWindow <= 0 {
+		return fmt.Errorf("fraud.score_timeout, fraud.shared_card_accounts, fraud.velocity_orders and fraud.velocity_window must be positive")
+	}
+	if c.Returns.WindowDays < 0 {
+		return fmt.Errorf("returns.window_days must not be negative")
+	}
+	if c.Alerts.CheckInterval < 0 || c.Alerts.WebhookFailures < 0 || c.Alerts.LowStockSpike < 0 {
+		return fmt.Errorf("alerts.check_interval and alert thresholds must not be negative")
+	}
+	if c.Bulk.StockAdjustItems <= 0 || c.Bulk.DeliveryItems <= 0 || c.Bulk.ProductItems <= 0 || c.Bulk.ReviewImportItems <= 0 ||
+		c.Bulk.InlineStockAdjustItems <= 0 {
+		return fmt.Errorf("bulk item limits must be positive")
+	}
+	if c.Retention.PurgeInterval < 0 || c.Retention.BatchSize <= 0 {
+		return fmt.Errorf("retention.purge_interval must not be negative and retention.batch_size must be positive")
+	}
+	for entity, days := range c.Retention.Days {
+		if days < 0 {
+			return fmt.Errorf("retention.days.%s must not be negative", entity)
+		}
+	}
+	if c.Quotas.ResetHourUTC < 0 || c.Quotas.ResetHourUTC > 23 {
+		return fmt.Errorf("quotas.reset_hour_utc must be between 0 and 23")
+	}
+	switch c.Search.Backend {
+	case "postgres":
+	case "elasticsearch", "opensearch":
+		if c.Search.Elasticsearch.URL == "" {
+			return fmt.Errorf("search.elasticsearch.url is required when search.backend is %s", c.Search.Backend)
+		}
+	default:
+		return fmt.Errorf("unknown search.backend %q", c.Search.Backend)
+	}
+	if c.Search.Fuzzy.MinResults < 0 {
+		return fmt.Errorf("search.fuzzy.min_results must not be negative")
+	}
+	if c.Search.Fuzzy.Threshold <= 0 || c.Search.Fuzzy.Threshold > 1 {
+		return fmt.Errorf("search.fuzzy.threshold must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
 // DefaultConfig returns a default configuration
 func DefaultConfig() *Config {
 	return &Config{
 		Environment: "development",
 		Server: ServerConfig{
-			Port: 8080,
-			Host: "0.0.0.0",
+			Port:                8080,
+			Host:                "0.0.0.0",
+			MaxBodyBytes:        1 << 20,  // 1 MB
+			MaxUploadBytes:      32 << 20, // 32 MB
+			MaxMultipartMemory:  8 << 20,  // 8 MB
+			ShutdownTimeout:     15,
+			ShutdownHookTimeout: 5,
+		},
+		TLS: TLSConfig{
+			Enabled:  false,
+			CacheDir: "certs",
+			HTTPPort: 80,
 		},
 		Database: DatabaseConfig{
 			Host:     "localhost",
@@ -124,12 +1120,254 @@ func DefaultConfig() *Config {
 		JWT: JWTConfig{
 			Secret:     "your-super-secret-jwt-key-change-this-in-production",
 			Expiration: 24, // 24 hours
+			Issuer:     "greens-marketplace",
+			Audience:   "greens-marketplace-api",
+			ClockSkew:  30,
+			Alg:        "HS256",
+
+			ImpersonationExpiration: 15,
 		},
 		OpenAI: OpenAIConfig{
-			APIKey:     "",
-			Model:      "text-embedding-ada-002",
-			MaxTokens:  1000,
-			Temperature: 0.7,
+			APIKey:       "",
+			Model:        "text-embedding-ada-002",
+			MaxTokens:    1000,
+			Temperature:  0.7,
+			BaseURL:      "https://api.openai.com/v1",
+			StartupCheck: true,
+		},
+		Search: SearchConfig{
+			Backend: "postgres",
+			Elasticsearch: ElasticsearchConfig{
+				Index: "products",
+			},
+			Fuzzy: FuzzySearchConfig{
+				MinResults: 5,
+				Threshold:  0.4,
+			},
+		},
+		Quotas: QuotaConfig{
+			ResetHourUTC: 0,
+			SemanticSearch: map[string]int{
+				"free":     20,
+				"pro":      500,
+				"business": -1,
+			},
+		},
+		AdminSigning: AdminSigningConfig{
+			MaxSkew: 300,
+		},
+		Captcha: CaptchaConfig{
+			Enabled:      false,
+			Provider:     "turnstile",
+			MinScore:     0.5,
+			FreeAttempts: 5,
+			Window:       900,
+		},
+		PasswordPolicy: PasswordPolicyConfig{
+			MinLength:          10,
+			MaxLength:          72,
+			RequireUpper:       true,
+			RequireLower:       true,
+			RequireDigit:       true,
+			BreachCheck:        false,
+			BreachCheckURL:     "https://api.pwnedpasswords.com/range/",
+			BreachCheckTimeout: 2000,
+		},
+		EmailResend: EmailResendConfig{
+			Limits: map[string]EmailLimitConfig{
+				"verification":   {Cooldown: 60, HourlyLimit: 5},
+				"password_reset": {Cooldown: 60, HourlyLimit: 5},
+				"two_factor":     {Cooldown: 30, HourlyLimit: 10},
+			},
+		},
+		Reviews: ReviewConfig{
+			HourlyLimit:   5,
+			DailyLimit:    20,
+			MinAccountAge: 24,
+			EditCooldown:  300,
+			ExemptRoles:   []string{"admin", "support"},
+		},
+		Notifications: NotificationConfig{
+			DigestHour:      8,
+			DigestInterval:  300,
+			DigestRetention: 7,
+			SMTP: SMTPConfig{
+				Port:    587,
+				Timeout: 10,
+			},
+		},
+		Views: ViewConfig{
+			DedupWindow:   1800,
+			MaxPerViewer:  120,
+			FlushInterval: 60,
+			TrendingTTL:   300,
+			RecentLimit:   20,
+			RecentTTL:     30 * 24 * 3600,
+		},
+		Delivery: DeliveryConfig{
+			DefaultWarehouse:      "main",
+			DefaultProcessingDays: 1,
+			DefaultMinDays:        3,
+			DefaultMaxDays:        7,
+			CacheTTL:              600,
+		},
+		Shipping: ShippingConfig{
+			Methods: []ShippingMethodConfig{
+				{
+					Method:   "standard",
+					Name:     "Standard delivery",
+					Rates:    map[string]string{"USD": "4.99", "EUR": "4.49", "GBP": "3.99"},
+					FreeOver: map[string]string{"USD": "50.00", "EUR": "45.00", "GBP": "40.00"},
+				},
+				{
+					Method:  "express",
+					Name:    "Express delivery",
+					Rates:   map[string]string{"USD": "12.99", "EUR": "11.99", "GBP": "9.99"},
+					MinDays: 1,
+					MaxDays: 2,
+				},
+				{Method: "pickup", Name: "Collect from the warehouse", SellerHours: true},
+			},
+		},
+		Jobs: JobsConfig{
+			MaxPendingAge: 300,
+			StatsInterval: 15,
+		},
+		Payments: PaymentsConfig{
+			Gateway: "stripe",
+			BaseURL: "https://api.stripe.com",
+			Timeout: 10,
+		},
+		Labels: LabelsConfig{
+			Carrier:  "shippo",
+			BaseURL:  "https://api.goshippo.com",
+			Timeout:  30,
+			FileType: "PDF",
+		},
+		RateLimits: map[string]RateLimitConfig{
+			RateLimitGlobal:         {Requests: 100, Window: 60, By: "ip"},
+			RateLimitSemanticSearch: {Requests: 10, Window: 60, By: "user"},
+			RateLimitReprice:        {Requests: 5, Window: 60, By: "user"},
+			RateLimitAdminStats:     {Requests: 10, Window: 60, By: "user"},
+			RateLimitRetentionPurge: {Requests: 2, Window: 3600, By: "user"},
+			RateLimitAdminUsers:     {Requests: 30, Window: 60, By: "user"},
+			RateLimitCoupons:        {Requests: 10, Window: 60, By: "user"},
+		},
+		Webhooks: WebhookConfig{
+			Timeout:        10,
+			InboundLockTTL: 60,
+		},
+		Health: HealthConfig{
+			CheckInterval: 10,
+			AlertTimeout:  5,
+		},
+		Alerts: AlertsConfig{
+			CheckInterval:   300,
+			WebhookFailures: 5,
+			LowStockSpike:   25,
+		},
+		Logging: LoggingConfig{
+			RedactFields: []string{"email", "recipient_email", "password", "token", "phone", "address",
+				"shipping_address", "card_last4", "last4", "authorization", "cookie"},
+		},
+		Cache: CacheConfig{
+			LocalSize: 10000,
+			LocalTTL:  10,
+		},
+		Inventory: InventoryConfig{
+			LowStockThreshold:        5,
+			ConsistencyCheckInterval: 3600,
+			BackorderFillInterval:    300,
+			BackorderETADays:         14,
+			PreorderCharge:           "order",
+		},
+		Orders: OrdersConfig{
+			EventCheckInterval:   3600,
+			SelfPurchase:         "block",
+			UnpaidCancelInterval: 60,
+			UnpaidTimeout:        30,
+			UnpaidTimeouts: map[string]int{
+				"bank_transfer": 4320,
+			},
+		},
+		Fraud: FraudConfig{
+			HoldScore:            70,
+			ScoreTimeout:         200,
+			SharedCardAccounts:   3,
+			SharedCardScore:      50,
+			CountryMismatchScore: 30,
+			VelocityOrders:       5,
+			VelocityWindow:       60,
+			VelocityScore:        40,
+		},
+		Returns: ReturnsConfig{
+			WindowDays: 30,
+		},
+		Bulk: BulkConfig{
+			StockAdjustItems:       5000,
+			DeliveryItems:          500,
+			ProductItems:           500,
+			ReviewImportItems:      1000,
+			InlineStockAdjustItems: 500,
+		},
+		Retention: RetentionConfig{
+			PurgeInterval: 3600,
+			BatchSize:     1000,
+			Days: map[string]int{
+				"deleted_products":      180,
+				"stale_carts":           90,
+				"read_notifications":    90,
+				"webhook_deliveries":    30,
+				"inbound_webhooks":      30,
+				"search_queries":        180,
+				"outbox":                7,
+				"bulk_jobs":             30,
+				"seller_status_changes": 730,
+			},
+		},
+		Cart: CartConfig{
+			HoldTTL:       600,
+			HoldThreshold: 10,
+			SummaryTTL:    60,
+
+			WishlistMaxItems: 500,
+		},
+		Pagination: PaginationConfig{
+			DefaultPageSize: 20,
+			MaxPageSize:     100,
+		},
+		Pricing: PricingConfig{
+			Floors:            map[string]string{"USD": "0.50", "EUR": "0.50"},
+			PublicHistoryDays: 90,
+		},
+		Storage: StorageConfig{
+			Backend:  "local",
+			LocalDir: "uploads",
+		},
+		Degraded: DegradedConfig{
+			Enabled:    false,
+			Message:    "This feature is temporarily unavailable, please try again later",
+			RetryAfter: 300,
+		},
+		Features: FeaturesConfig{
+			SemanticSearch:    true,
+			Recommendations:   true,
+			GuestCartMerge:    true,
+			DeliveryEstimates: true,
+		},
+		HTTPCache: HTTPCacheConfig{
+			Categories:  CachePolicy{MaxAge: 300, SharedMaxAge: 3600, StaleWhileRevalidate: 60},
+			Products:    CachePolicy{MaxAge: 60, SharedMaxAge: 300, StaleWhileRevalidate: 30},
+			Collections: CachePolicy{MaxAge: 60, SharedMaxAge: 300, StaleWhileRevalidate: 30},
+		},
+		Experiments: map[string]ExperimentConfig{
+			"search_ranking": {
+				Enabled: false,
+				Variants: []VariantConfig{
+					{Name: "control", Weight: 50},
+					{Name: "recency_boost", Weight: 50},
+				},
+			},
 		},
 	}
-}
\ No newline at end of file
+}
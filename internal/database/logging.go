@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// Operation logging
+//
+// Queries and Redis commands are logged at debug level with the logger the
+// context carries, so they share the request ID and user of the request, or
+// the job ID of the job, that ran them. Operations slower than slowOperation
+// are logged at warn level. Query arguments and Redis keys are never logged;
+// they may hold personal data or tokens.
+
+const (
+	slowOperation  = 500 * time.Millisecond
+	maxLoggedQuery = 200
+)
+
+// contextLogger returns the logger ctx carries, or the global logger
+func contextLogger(ctx context.Context) *zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return &log.Logger
+}
+
+// logOperation logs an operation that started at start, ignoring err when
+// it only reports a missing row or key
+func logOperation(logger *zerolog.Logger, start time.Time, err error) *zerolog.Event {
+	duration := time.Since(start)
+	event := logger.Debug()
+	if duration >= slowOperation {
+		event = logger.Warn()
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, redis.Nil) {
+		event = event.Err(err)
+	}
+	return event.Dur("duration", duration)
+}
+
+// Conn is a database handle bound to a context. Its queries run with the
+// context and are logged with the context's logger.
+type Conn struct {
+	db     *sql.DB
+	ctx    context.Context
+	logger *zerolog.Logger
+}
+
+// WithContext returns a handle on db whose queries run with ctx and are
+// logged with ctx's logger
+func (db *PostgresDB) WithContext(ctx context.Context) *Conn {
+	return &Conn{db: db.DB, ctx: ctx, logger: contextLogger(ctx)}
+}
+
+// ExecContext executes a query without returning rows, logging it
+func (db *PostgresDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.WithContext(ctx).Exec(query, args...)
+}
+
+// QueryContext executes a query that returns rows, logging it
+func (db *PostgresDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.WithContext(ctx).Query(query, args...)
+}
+
+// QueryRowContext executes a query that returns at most one row, logging it
+func (db *PostgresDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.WithContext(ctx).QueryRow(query, args...)
+}
+
+// Exec executes a query without returning rows
+func (c *Conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer utils.TrackTiming(c.ctx, "db")()
+	start := time.Now()
+	result, err := c.db.ExecContext(c.ctx, query, args...)
+	c.logQuery(query, start, err)
+	return result, err
+}
+
+// Query executes a query that returns rows
+func (c *Conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer utils.TrackTiming(c.ctx, "db")()
+	start := time.Now()
+	rows, err := c.db.QueryContext(c.ctx, query, args...)
+	c.logQuery(query, start, err)
+	return rows, err
+}
+
+// QueryRow executes a query that returns at most one row. Rows are read
+// when scanned, so the logged duration only covers running the query.
+func (c *Conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer utils.TrackTiming(c.ctx, "db")()
+	start := time.Now()
+	row := c.db.QueryRowContext(c.ctx, query, args...)
+	c.logQuery(query, start, row.Err())
+	return row
+}
+
+// WithTx runs fn in a transaction, committing when it returns nil and
+// rolling back otherwise, and logs how long the transaction took. Queries
+// run on the transaction itself are not logged or timed one by one; the
+// whole transaction is timed as db.
+func (c *Conn) WithTx(fn func(tx *sql.Tx) error) error {
+	defer utils.TrackTiming(c.ctx, "db")()
+	start := time.Now()
+	err := withTx(c.ctx, c.db, fn)
+	logOperation(c.logger, start, err).Bool("committed", err == nil).Msg("Database transaction")
+	return err
+}
+
+func (c *Conn) logQuery(query string, start time.Time, err error) {
+	logOperation(c.logger, start, err).Str("query", compactQuery(query)).Msg("Database query")
+}
+
+// compactQuery collapses the whitespace of query and shortens it for logging
+func compactQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > maxLoggedQuery {
+		query = query[:maxLoggedQuery] + "..."
+	}
+	return query
+}
+
+// WithContext returns a handle on r bound to ctx. Every command is logged
+// with the logger of the context it runs with, whichever handle runs it.
+func (r *RedisClient) WithContext(ctx context.Context) *RedisClient {
+	return &RedisClient{Client: r.Client.WithContext(ctx), logger: *contextLogger(ctx), prefix: r.prefix}
+}
+
+type redisStartKey struct{}
+
+// redisLogHook logs Redis commands with the logger of their context
+type redisLogHook struct{}
+
+func (redisLogHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisLogHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	logRedis(ctx, cmd.Name(), 1, cmd.Err())
+	return nil
+}
+
+func (redisLogHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisLogHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && !errors.Is(cmd.Err(), redis.Nil) {
+			err = cmd.Err()
+			break
+		}
+	}
+	logRedis(ctx, "pipeline", len(cmds), err)
+	return nil
+}
+
+func logRedis(ctx context.Context, command string, commands int, err error) {
+	start, ok := ctx.Value(redisStartKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	utils.RecordTiming(ctx, "cache", time.Since(start))
+	logOperation(contextLogger(ctx), start, err).Str("command", command).Int("commands", commands).Msg("Redis command")
+}
@@ -1,14 +1,18 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog"
+
+	"github.com/greens-marketplace/internal/config"
 )
 
 // PostgresDB represents a PostgreSQL database connection
@@ -18,7 +22,7 @@ type PostgresDB struct {
 }
 
 // NewPostgresDB creates a new PostgreSQL database connection
-func NewPostgresDB(cfg DatabaseConfig) (*PostgresDB, error) {
+func NewPostgresDB(cfg config.DatabaseConfig) (*PostgresDB, error) {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using system environment variables")
@@ -38,8 +42,9 @@ func NewPostgresDB(cfg DatabaseConfig) (*PostgresDB, error) {
 		cfg.Name = name
 	}
 
-	// Build connection string
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+	// Build connection string. Sessions run in UTC, so timestamptz values
+	// are read back in UTC whatever the server's time zone.
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s timezone=UTC",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
 
 	// Open database connection
@@ -77,6 +82,27 @@ func (db *PostgresDB) GetDB() *sql.DB {
 	return db.DB
 }
 
+// WithTx runs fn in a transaction, committing when it returns nil and rolling
+// back otherwise
+func (db *PostgresDB) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return db.WithContext(ctx).WithTx(fn)
+}
+
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 // getEnv returns the value of the environment variable or the default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -9,16 +9,19 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
+
+	"github.com/greens-marketplace/internal/config"
 )
 
 // RedisClient represents a Redis client connection
 type RedisClient struct {
 	*redis.Client
 	logger zerolog.Logger
+	prefix string
 }
 
 // NewRedisClient creates a new Redis client connection
-func NewRedisClient(cfg RedisConfig) (*RedisClient, error) {
+func NewRedisClient(cfg config.RedisConfig) (*RedisClient, error) {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using system environment variables")
@@ -34,6 +37,9 @@ func NewRedisClient(cfg RedisConfig) (*RedisClient, error) {
 	if password := getEnv("REDIS_PASSWORD", ""); password != "" {
 		cfg.Password = password
 	}
+	if prefix := getEnv("REDIS_KEY_PREFIX", ""); prefix != "" {
+		cfg.KeyPrefix = prefix
+	}
 
 	// Create Redis client
 	client := redis.NewClient(&redis.Options{
@@ -41,6 +47,10 @@ func NewRedisClient(cfg RedisConfig) (*RedisClient, error) {
 		Password: cfg.Password,
 		DB:       cfg.DB,
 	})
+	if cfg.KeyPrefix != "" {
+		client.AddHook(keyPrefixHook{prefix: cfg.KeyPrefix})
+	}
+	client.AddHook(redisLogHook{})
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -56,6 +66,7 @@ func NewRedisClient(cfg RedisConfig) (*RedisClient, error) {
 	return &RedisClient{
 		Client: client,
 		logger: logger,
+		prefix: cfg.KeyPrefix,
 	}, nil
 }
 
@@ -74,6 +85,12 @@ func (r *RedisClient) SetWithExpiration(ctx context.Context, key string, value i
 	return r.Client.Set(ctx, key, value, expiration).Err()
 }
 
+// SetIfAbsent sets a key with an expiration time unless it already exists,
+// reporting whether it was set
+func (r *RedisClient) SetIfAbsent(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	return r.Client.SetNX(ctx, key, value, expiration).Result()
+}
+
 // Get retrieves a value by key
 func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
 	return r.Client.Get(ctx, key).Result()
@@ -108,6 +125,36 @@ func (r *RedisClient) Decrement(ctx context.Context, key string) error {
 	return r.Client.Decr(ctx, key).Err()
 }
 
+// incrByBoundedScript adds ARGV[1] to the counter at KEYS[1], missing keys
+// counting as 0, unless the result would fall outside [ARGV[2], ARGV[3]].
+// INCRBY keeps the key's TTL. Returns {applied, value}.
+var incrByBoundedScript = redis.NewScript(`
+local value = tonumber(redis.call('GET', KEYS[1]) or '0')
+if value == nil then
+	return redis.error_reply('ERR value is not an integer')
+end
+local next = value + tonumber(ARGV[1])
+if next < tonumber(ARGV[2]) or next > tonumber(ARGV[3]) then
+	return {0, value}
+end
+return {1, redis.call('INCRBY', KEYS[1], ARGV[1])}
+`)
+
+// IncrByBounded atomically adds delta to the counter at key, which may be
+// negative, as long as the result stays within [min, max]. When it wouldn't,
+// nothing is changed and it returns the current value with ok false. A
+// missing key counts as 0; its TTL, if any, is kept.
+func (r *RedisClient) IncrByBounded(ctx context.Context, key string, delta, min, max int64) (int64, bool, error) {
+	res, err := incrByBoundedScript.Run(ctx, r.Client, []string{key}, delta, min, max).Int64Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(res) != 2 {
+		return 0, false, fmt.Errorf("unexpected bounded increment result: %v", res)
+	}
+	return res[1], res[0] == 1, nil
+}
+
 // SetExpiration sets the expiration time for a key
 func (r *RedisClient) SetExpiration(ctx context.Context, key string, expiration time.Duration) error {
 	return r.Client.Expire(ctx, key, expiration).Err()
@@ -123,15 +170,11 @@ func (r *RedisClient) Keys(ctx context.Context, pattern string) ([]string, error
 	return r.Client.Keys(ctx, pattern).Result()
 }
 
-// FlushDB clears the current database
+// FlushDB clears the current database. With a key prefix set the database
+// may be shared, so it fails with ErrKeyPrefixed; see DeletePrefix.
 func (r *RedisClient) FlushDB(ctx context.Context) error {
+	if r.prefix != "" {
+		return ErrKeyPrefixed
+	}
 	return r.Client.FlushDB(ctx).Err()
 }
-
-// getEnv returns the value of the environment variable or the default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
\ No newline at end of file
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// RedisLock is a lock held in Redis, shared by all replicas. It expires
+// after its TTL unless extended, so a replica that dies holding it doesn't
+// hold it forever.
+type RedisLock struct {
+	redis *RedisClient
+	key   string
+	token string
+}
+
+// releaseLockScript deletes the lock only while it is still ours
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// extendLockScript renews the lock's TTL only while it is still ours
+var extendLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// TryLock takes the lock key for ttl, returning nil without an error when
+// another holder has it
+func (r *RedisClient) TryLock(ctx context.Context, key string, ttl time.Duration) (*RedisLock, error) {
+	token := uuid.NewString()
+	ok, err := r.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take lock %s: %w", key, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &RedisLock{redis: r, key: key, token: token}, nil
+}
+
+// Extend renews the lock for ttl, reporting false when it has expired and
+// may have been taken by another holder
+func (l *RedisLock) Extend(ctx context.Context, ttl time.Duration) (bool, error) {
+	n, err := extendLockScript.Run(ctx, l.redis, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to extend lock %s: %w", l.key, err)
+	}
+	return n == 1, nil
+}
+
+// Release gives the lock up, unless it has already expired
+func (l *RedisLock) Release(ctx context.Context) error {
+	if err := releaseLockScript.Run(ctx, l.redis, []string{l.key}, l.token).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", l.key, err)
+	}
+	return nil
+}
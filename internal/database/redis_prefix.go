@@ -0,0 +1,257 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Key prefixes
+//
+// With a key prefix set, every key and Pub/Sub channel this client touches is
+// prefixed with it, so environments or apps sharing a Redis instance can't
+// see or clobber each other's keys. The prefix is added by a hook as each
+// command is sent, so direct client calls, pipelines, transactions and Lua
+// scripts (through KEYS) are all covered without callers knowing. Patterns
+// given to KEYS and SCAN only match prefixed keys, and the keys they return
+// have the prefix stripped, so they can be passed straight back to other
+// commands. Keys a script builds itself, rather than takes through KEYS,
+// aren't prefixed. FLUSHDB would clear the other tenants too, so it is
+// refused; DeletePrefix deletes only this client's keys instead.
+
+// ErrKeyPrefixed is returned by FlushDB when a key prefix is set
+var ErrKeyPrefixed = errors.New("refusing to flush a shared database with a key prefix set; use DeletePrefix")
+
+// deletePrefixBatch is how many keys DeletePrefix scans for per round
+const deletePrefixBatch = 500
+
+// keyPositions returns the indexes of the keys among the arguments of a
+// command, including its name at 0
+type keyPositions func(args []interface{}) []int
+
+// firstKey is the key positions of commands taking one key first
+func firstKey(args []interface{}) []int {
+	if len(args) < 2 {
+		return nil
+	}
+	return []int{1}
+}
+
+// keysFrom returns the key positions of commands taking only keys from
+// index start, stopping short of the last trailing arguments
+func keysFrom(start, step, trailing int) keyPositions {
+	return func(args []interface{}) []int {
+		var positions []int
+		for i := start; i < len(args)-trailing; i += step {
+			positions = append(positions, i)
+		}
+		return positions
+	}
+}
+
+// numKeysAt returns the key positions of commands giving their number of
+// keys at index at, followed by that many keys, like EVAL. With store set
+// the key before the count, where the result is stored, is a key too.
+func numKeysAt(at int, store bool) keyPositions {
+	return func(args []interface{}) []int {
+		if len(args) <= at {
+			return nil
+		}
+		n, err := strconv.Atoi(fmt.Sprint(args[at]))
+		if err != nil {
+			return nil
+		}
+		var positions []int
+		if store {
+			positions = append(positions, at-1)
+		}
+		for i := at + 1; i <= at+n && i < len(args); i++ {
+			positions = append(positions, i)
+		}
+		return positions
+	}
+}
+
+// subcommandKey is the key positions of commands taking a subcommand and
+// then a key, like OBJECT ENCODING
+func subcommandKey(args []interface{}) []int {
+	if len(args) < 3 {
+		return nil
+	}
+	return []int{2}
+}
+
+// noKeys is the key positions of commands taking no keys
+func noKeys([]interface{}) []int { return nil }
+
+// commandKeys holds the key positions of commands whose keys aren't just
+// their first argument
+var commandKeys = map[string]keyPositions{
+	"del": keysFrom(1, 1, 0), "unlink": keysFrom(1, 1, 0), "exists": keysFrom(1, 1, 0),
+	"touch": keysFrom(1, 1, 0), "mget": keysFrom(1, 1, 0), "watch": keysFrom(1, 1, 0),
+	"sdiff": keysFrom(1, 1, 0), "sinter": keysFrom(1, 1, 0), "sunion": keysFrom(1, 1, 0),
+	"sdiffstore": keysFrom(1, 1, 0), "sinterstore": keysFrom(1, 1, 0), "sunionstore": keysFrom(1, 1, 0),
+	"pfcount": keysFrom(1, 1, 0), "pfmerge": keysFrom(1, 1, 0),
+	"mset": keysFrom(1, 2, 0), "msetnx": keysFrom(1, 2, 0),
+	"blpop": keysFrom(1, 1, 1), "brpop": keysFrom(1, 1, 1), "bzpopmin": keysFrom(1, 1, 1), "bzpopmax": keysFrom(1, 1, 1),
+	"rename": keysFrom(1, 1, 0), "renamenx": keysFrom(1, 1, 0), "smove": keysFrom(1, 1, 1),
+	"rpoplpush": keysFrom(1, 1, 0), "brpoplpush": keysFrom(1, 1, 1), "lmove": keysFrom(1, 1, 2),
+	"eval": numKeysAt(2, false), "evalsha": numKeysAt(2, false), "eval_ro": numKeysAt(2, false), "evalsha_ro": numKeysAt(2, false),
+	"zunionstore": numKeysAt(2, true), "zinterstore": numKeysAt(2, true), "zdiffstore": numKeysAt(2, true),
+	"object": subcommandKey, "memory": subcommandKey,
+
+	"ping": noKeys, "echo": noKeys, "auth": noKeys, "hello": noKeys, "select": noKeys, "quit": noKeys,
+	"info": noKeys, "dbsize": noKeys, "time": noKeys, "flushdb": noKeys, "flushall": noKeys,
+	"multi": noKeys, "exec": noKeys, "discard": noKeys, "unwatch": noKeys, "script": noKeys,
+	"client": noKeys, "config": noKeys, "command": noKeys, "cluster": noKeys, "readonly": noKeys,
+	"readwrite": noKeys, "pubsub": noKeys, "wait": noKeys, "role": noKeys, "slowlog": noKeys,
+	"lastsave": noKeys, "save": noKeys, "bgsave": noKeys, "debug": noKeys, "acl": noKeys,
+
+	// Patterns are handled apart
+	"keys": noKeys, "scan": noKeys,
+}
+
+// keyPrefixHook prefixes the keys of every command with prefix
+type keyPrefixHook struct {
+	prefix string
+}
+
+func (h keyPrefixHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.prefixArgs(cmd)
+	return ctx, nil
+}
+
+func (h keyPrefixHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	h.stripKeys(cmd)
+	return nil
+}
+
+func (h keyPrefixHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		h.prefixArgs(cmd)
+	}
+	return ctx, nil
+}
+
+func (h keyPrefixHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		h.stripKeys(cmd)
+	}
+	return nil
+}
+
+// prefixArgs prefixes the keys among the arguments of cmd in place
+func (h keyPrefixHook) prefixArgs(cmd redis.Cmder) {
+	args := cmd.Args()
+	name := cmd.Name()
+	switch name {
+	case "keys":
+		if len(args) > 1 {
+			args[1] = h.pattern(args[1])
+		}
+	case "scan":
+		for i := 2; i+1 < len(args); i++ {
+			if strings.EqualFold(fmt.Sprint(args[i]), "match") {
+				args[i+1] = h.pattern(args[i+1])
+			}
+		}
+	}
+
+	positions, ok := commandKeys[name]
+	if !ok {
+		positions = firstKey
+	}
+	for _, i := range positions(args) {
+		if key, ok := args[i].(string); ok {
+			args[i] = h.prefix + key
+		}
+	}
+}
+
+// pattern returns a KEYS or SCAN pattern matching only prefixed keys, with
+// the prefix's own glob characters escaped
+func (h keyPrefixHook) pattern(pattern interface{}) string {
+	var escaped strings.Builder
+	for _, r := range h.prefix {
+		if strings.ContainsRune(`*?[]\`, r) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String() + fmt.Sprint(pattern)
+}
+
+// stripKeys strips the prefix from the keys KEYS and SCAN return
+func (h keyPrefixHook) stripKeys(cmd redis.Cmder) {
+	if cmd.Err() != nil {
+		return
+	}
+	switch cmd := cmd.(type) {
+	case *redis.StringSliceCmd:
+		if cmd.Name() == "keys" {
+			cmd.SetVal(h.strip(cmd.Val()))
+		}
+	case *redis.ScanCmd:
+		if cmd.Name() == "scan" {
+			keys, cursor := cmd.Val()
+			cmd.SetVal(h.strip(keys), cursor)
+		}
+	}
+}
+
+func (h keyPrefixHook) strip(keys []string) []string {
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, h.prefix)
+	}
+	return keys
+}
+
+// KeyPrefix returns the prefix added to r's keys, if any
+func (r *RedisClient) KeyPrefix() string {
+	return r.prefix
+}
+
+// Subscribe subscribes to channels, prefixed like keys so they match what
+// Publish sends. Subscriptions don't run through hooks.
+func (r *RedisClient) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	if r.prefix != "" {
+		prefixed := make([]string, len(channels))
+		for i, channel := range channels {
+			prefixed[i] = r.prefix + channel
+		}
+		channels = prefixed
+	}
+	return r.Client.Subscribe(ctx, channels...)
+}
+
+// DeletePrefix deletes every key under r's prefix, a batch at a time, and
+// returns how many it deleted. Keys written meanwhile may survive it. It
+// fails without a prefix, since it would delete the whole database.
+func (r *RedisClient) DeletePrefix(ctx context.Context) (int64, error) {
+	if r.prefix == "" {
+		return 0, errors.New("no key prefix is set; use FlushDB")
+	}
+	var deleted int64
+	var cursor uint64
+	for {
+		keys, next, err := r.Client.Scan(ctx, cursor, "*", deletePrefixBatch).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to scan prefixed keys: %w", err)
+		}
+		if len(keys) > 0 {
+			n, err := r.Client.Del(ctx, keys...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf("failed to delete prefixed keys: %w", err)
+			}
+			deleted += n
+		}
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
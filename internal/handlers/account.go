@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// AccountHandler handles admin suspension and impersonation of user
+// accounts, and users deleting their own
+type AccountHandler struct {
+	accountService *services.AccountService
+}
+
+// NewAccountHandler creates a new account handler
+func NewAccountHandler(accountService *services.AccountService) *AccountHandler {
+	return &AccountHandler{accountService: accountService}
+}
+
+// SearchUsers lists users for admins, filtered by id, email (the whole
+// address), phone (part of the number), q (part of the email, name or
+// phone), role, status (active, suspended or deleted) and verified, with
+// cursor pagination
+func (h *AccountHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 50, MaxLimit: 200, Cursor: true})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	filter := models.AdminUserFilter{
+		ID: q.Get("id"), Email: q.Get("email"), Phone: q.Get("phone"), Query: q.Get("q"), Role: q.Get("role"),
+		Status: q.Get("status"), Sort: params.Sort, Cursor: params.Cursor, Limit: params.Limit,
+	}
+	if filter.ID != "" {
+		if _, err := uuid.Parse(filter.ID); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "id must be a UUID")
+			return
+		}
+	}
+	if v := q.Get("verified"); v != "" {
+		verified, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "verified must be true or false")
+			return
+		}
+		filter.Verified = &verified
+	}
+
+	ctx := r.Context()
+	page, err := h.accountService.SearchUsers(ctx, middleware.UserIDFromContext(ctx), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// SuspendUser suspends a user, with the reason passed on to them
+func (h *AccountHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := accountUserID(w, r)
+	if !ok {
+		return
+	}
+	var input models.SuspendInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	status, err := h.accountService.Suspend(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, status)
+}
+
+// UnsuspendUser lifts a user's suspension, optionally with a reason for the
+// audit entry
+func (h *AccountHandler) UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := accountUserID(w, r)
+	if !ok {
+		return
+	}
+	// The body is optional
+	var input models.UnsuspendInput
+	if err := utils.DecodeJSON(r, &input); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	status, err := h.accountService.Unsuspend(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, status)
+}
+
+// GetSuspensions returns a page of a user's suspensions, newest first
+func (h *AccountHandler) GetSuspensions(w http.ResponseWriter, r *http.Request) {
+	id, ok := accountUserID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.accountService.Suspensions(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// ImpersonateUser starts impersonating a user, returning a short-lived
+// token to act as them with
+func (h *AccountHandler) ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := accountUserID(w, r)
+	if !ok {
+		return
+	}
+	var input models.ImpersonateInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	token, err := h.accountService.Impersonate(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, token)
+}
+
+// GetImpersonations returns a page of a user's impersonations, newest first
+func (h *AccountHandler) GetImpersonations(w http.ResponseWriter, r *http.Request) {
+	id, ok := accountUserID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.accountService.Impersonations(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// GetImpersonation returns an impersonation
+func (h *AccountHandler) GetImpersonation(w http.ResponseWriter, r *http.Request) {
+	id, ok := impersonationID(w, r)
+	if !ok {
+		return
+	}
+	impersonation, err := h.accountService.GetImpersonation(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, impersonation)
+}
+
+// GetImpersonatedRequests returns a page of the requests made with an
+// impersonation's token, oldest first
+func (h *AccountHandler) GetImpersonatedRequests(w http.ResponseWriter, r *http.Request) {
+	id, ok := impersonationID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.accountService.ImpersonatedRequests(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// RevokeImpersonation ends an impersonation before its token expires
+func (h *AccountHandler) RevokeImpersonation(w http.ResponseWriter, r *http.Request) {
+	id, ok := impersonationID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	impersonation, err := h.accountService.RevokeImpersonation(ctx, id, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, impersonation)
+}
+
+// DeleteAccount deletes the user's own account, deleting or anonymizing
+// their reviews as they choose
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var input models.DeleteAccountInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	deletion, err := h.accountService.DeleteAccount(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, deletion)
+}
+
+// GetAccountDeletion returns the audit entry for a user's account deletion
+func (h *AccountHandler) GetAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	id, ok := accountUserID(w, r)
+	if !ok {
+		return
+	}
+	deletion, err := h.accountService.AccountDeletion(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, deletion)
+}
+
+// impersonationID reads the impersonation ID URL parameter, responding 404
+// when it is not a valid ID
+func impersonationID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Impersonation not found")
+		return "", false
+	}
+	return id, true
+}
+
+// accountUserID reads the user ID URL parameter, responding 404 when it is
+// not a valid ID
+func accountUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "User not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *AccountHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrImpersonationForbidden) {
+		utils.RespondError(w, http.StatusForbidden, "cannot_impersonate", "Staff, suspended users and yourself can't be impersonated")
+		return
+	}
+	if errors.Is(err, services.ErrInvalidUserFilter) {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrAccountHasOpenOrders) {
+		utils.RespondError(w, http.StatusConflict, "open_orders", "Orders still open must be delivered or cancelled first")
+		return
+	}
+	if errors.Is(err, services.ErrAccountHasProducts) {
+		utils.RespondError(w, http.StatusConflict, "has_products", "Your products must be deleted first")
+		return
+	}
+	log.Error().Err(err).Msg("Account operation failed")
+	utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Account operation failed")
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// AlertHandler handles the admins' inbox of system alerts
+type AlertHandler struct {
+	alertService *services.AlertService
+}
+
+// NewAlertHandler creates a new alert handler
+func NewAlertHandler(alertService *services.AlertService) *AlertHandler {
+	return &AlertHandler{alertService: alertService}
+}
+
+// ListAlerts returns a page of alerts, most recently seen first, only the
+// unacknowledged ones with ?unacked=true
+func (h *AlertHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	unacked := false
+	if v := r.URL.Query().Get("unacked"); v != "" {
+		if unacked, err = strconv.ParseBool(v); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "unacked must be true or false")
+			return
+		}
+	}
+	page, err := h.alertService.List(r.Context(), unacked, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// AcknowledgeAlert acknowledges an alert, so the next occurrence of its
+// condition opens a new one
+func (h *AlertHandler) AcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Alert not found")
+		return
+	}
+	ctx := r.Context()
+	alert, err := h.alertService.Acknowledge(ctx, id, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, alert)
+}
+
+func (h *AlertHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	log.Error().Err(err).Msg("Alert operation failed")
+	utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Alert operation failed")
+}
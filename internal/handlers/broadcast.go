@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// BroadcastHandler handles admin announcements to segments of users
+type BroadcastHandler struct {
+	notificationService *services.NotificationService
+}
+
+// NewBroadcastHandler creates a new broadcast handler
+func NewBroadcastHandler(notificationService *services.NotificationService) *BroadcastHandler {
+	return &BroadcastHandler{notificationService: notificationService}
+}
+
+// CreateBroadcast queues an announcement, answering 202 with the broadcast
+// to poll for progress, or 200 with it when its key was posted before
+func (h *BroadcastHandler) CreateBroadcast(w http.ResponseWriter, r *http.Request) {
+	var input models.BroadcastInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	broadcast, created, err := h.notificationService.Broadcast(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
+	}
+	utils.RespondJSON(w, status, broadcast)
+}
+
+// GetBroadcast returns a broadcast with its progress
+func (h *BroadcastHandler) GetBroadcast(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Broadcast not found")
+		return
+	}
+	broadcast, err := h.notificationService.GetBroadcast(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, broadcast)
+}
+
+func (h *BroadcastHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrBroadcastKeyReused):
+		utils.RespondError(w, http.StatusConflict, "key_reused", "Broadcast key already used for a different broadcast")
+	default:
+		log.Error().Err(err).Msg("Broadcast operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Broadcast operation failed")
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// GetCart returns the authenticated user's cart
+func (h *ProductHandler) GetCart(w http.ResponseWriter, r *http.Request) {
+	cart, err := h.cartService.Get(r.Context(), middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, cart)
+}
+
+// GetCartSummary returns the counts and totals of the authenticated user's
+// cart without its lines
+func (h *ProductHandler) GetCartSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := h.cartService.Summary(r.Context(), middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, summary)
+}
+
+// GetCartSavings returns what checking out the authenticated user's cart
+// would save against regular prices, by source
+func (h *ProductHandler) GetCartSavings(w http.ResponseWriter, r *http.Request) {
+	savings, err := h.cartService.Savings(r.Context(), middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, savings)
+}
+
+// AddToCart adds a product or bundle to the authenticated user's cart
+func (h *ProductHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
+	var input models.CartItemInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	cart, err := h.cartService.Add(r.Context(), middleware.UserIDFromContext(r.Context()), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, cart)
+}
+
+// UpdateCartItem changes the quantity of a product in the cart
+func (h *ProductHandler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	id, ok := cartProductID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.CartQuantityInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	cart, err := h.cartService.Update(r.Context(), middleware.UserIDFromContext(r.Context()), id, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, cart)
+}
+
+// RemoveFromCart removes a product from the cart
+func (h *ProductHandler) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
+	id, ok := cartProductID(w, r)
+	if !ok {
+		return
+	}
+
+	cart, err := h.cartService.Remove(r.Context(), middleware.UserIDFromContext(r.Context()), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, cart)
+}
+
+// MergeGuestCart merges the cart a guest built before signing in into the
+// authenticated user's cart
+func (h *ProductHandler) MergeGuestCart(w http.ResponseWriter, r *http.Request) {
+	var input models.CartMergeInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	merge, err := h.cartService.MergeGuest(r.Context(), middleware.UserIDFromContext(r.Context()), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, merge)
+}
+
+// cartProductID reads the productId URL parameter, responding 404 when it is
+// not a valid ID
+func cartProductID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "productId")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Cart item not found")
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// CommissionHandler handles commission rates and seller payout summaries
+type CommissionHandler struct {
+	commissionService *services.CommissionService
+}
+
+// NewCommissionHandler creates a new commission handler
+func NewCommissionHandler(commissionService *services.CommissionService) *CommissionHandler {
+	return &CommissionHandler{commissionService: commissionService}
+}
+
+// ListCommissionRates returns a page of the rate history of ?sellerId=,
+// ?categoryId= or, with neither, the default rate
+func (h *CommissionHandler) ListCommissionRates(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := models.CommissionRateFilter{SellerID: q.Get("sellerId"), CategoryID: q.Get("categoryId")}
+	for name, id := range map[string]string{"sellerId": filter.SellerID, "categoryId": filter.CategoryID} {
+		if _, err := uuid.Parse(id); id != "" && err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", name+" must be a valid id")
+			return
+		}
+	}
+	if filter.SellerID != "" && filter.CategoryID != "" {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "give sellerId or categoryId, not both")
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	filter.Limit, filter.Offset = params.Limit, params.Offset
+
+	page, err := h.commissionService.ListRates(r.Context(), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// SetCommissionRate adds a commission rate for a seller, a category or the
+// default, from now or a later effectiveFrom
+func (h *CommissionHandler) SetCommissionRate(w http.ResponseWriter, r *http.Request) {
+	var input models.CommissionRateInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	rate, err := h.commissionService.SetRate(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, rate)
+}
+
+// DeleteCommissionRate deletes a scheduled commission rate
+func (h *CommissionHandler) DeleteCommissionRate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Commission rate not found")
+		return
+	}
+	if err := h.commissionService.DeleteRate(r.Context(), id); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPayoutSummary returns the authenticated seller's payouts broken down
+// into gross, commission and net
+func (h *CommissionHandler) GetPayoutSummary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	summary, err := h.commissionService.PayoutSummary(ctx, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, summary)
+}
+
+// GetSellerPayoutSummary returns a seller's payout summary for admins
+func (h *CommissionHandler) GetSellerPayoutSummary(w http.ResponseWriter, r *http.Request) {
+	id, ok := sellerID(w, r)
+	if !ok {
+		return
+	}
+	summary, err := h.commissionService.PayoutSummary(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, summary)
+}
+
+func (h *CommissionHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.Is(err, services.ErrCommissionRateInEffect):
+		utils.RespondError(w, http.StatusConflict, "commission_rate_in_effect", "Commission rates that have taken effect can't be deleted")
+	default:
+		log.Error().Err(err).Msg("Commission operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Commission operation failed")
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ContentHandler handles merchandising content blocks
+type ContentHandler struct {
+	contentService *services.ContentService
+}
+
+// NewContentHandler creates a new content handler
+func NewContentHandler(contentService *services.ContentService) *ContentHandler {
+	return &ContentHandler{contentService: contentService}
+}
+
+// GetContent returns the block showing now for a key, in the language
+// ?locale= names or else the one Accept-Language prefers
+func (h *ContentHandler) GetContent(w http.ResponseWriter, r *http.Request) {
+	block, err := h.contentService.Active(r.Context(), chi.URLParam(r, "key"), requestLocales(r))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", block.Locale)
+	utils.RespondJSON(w, http.StatusOK, block)
+}
+
+// ListContentBlocks returns the content blocks, optionally those for ?key=
+func (h *ContentHandler) ListContentBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, err := h.contentService.List(r.Context(), r.URL.Query().Get("key"))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, blocks)
+}
+
+// GetContentBlock returns a single content block
+func (h *ContentHandler) GetContentBlock(w http.ResponseWriter, r *http.Request) {
+	id, ok := contentBlockID(w, r)
+	if !ok {
+		return
+	}
+	block, err := h.contentService.Get(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, block)
+}
+
+// CreateContentBlock creates a content block
+func (h *ContentHandler) CreateContentBlock(w http.ResponseWriter, r *http.Request) {
+	var input models.ContentBlockInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	block, err := h.contentService.Create(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, block)
+}
+
+// UpdateContentBlock replaces a content block
+func (h *ContentHandler) UpdateContentBlock(w http.ResponseWriter, r *http.Request) {
+	id, ok := contentBlockID(w, r)
+	if !ok {
+		return
+	}
+	var input models.ContentBlockInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	block, err := h.contentService.Update(r.Context(), id, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, block)
+}
+
+// DeleteContentBlock deletes a content block
+func (h *ContentHandler) DeleteContentBlock(w http.ResponseWriter, r *http.Request) {
+	id, ok := contentBlockID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.contentService.Delete(r.Context(), id); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestLocales returns the languages r prefers, most preferred first: the
+// one ?locale= names, then those Accept-Language lists
+func requestLocales(r *http.Request) []string {
+	locales := utils.LanguagePreferences(r.Header.Get("Accept-Language"))
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		locales = append([]string{strings.ToLower(locale)}, locales...)
+	}
+	return locales
+}
+
+// contentBlockID reads the content block ID URL parameter, responding 404
+// when it is not a valid ID
+func contentBlockID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Content block not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *ContentHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	if errors.As(err, &verr) {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	log.Error().Err(err).Msg("Content operation failed")
+	utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Content operation failed")
+}
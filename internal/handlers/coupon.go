@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ValidateCoupon checks a coupon code against the authenticated user's cart
+// without applying it, answering whether it applies, what it would take
+// off and, if it doesn't, why
+func (h *ProductHandler) ValidateCoupon(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeCouponCode(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.cartService.ValidateCoupon(r.Context(), middleware.UserIDFromContext(r.Context()), input.Code)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// ApplyCoupon applies a coupon code to the authenticated user's cart
+func (h *ProductHandler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeCouponCode(w, r)
+	if !ok {
+		return
+	}
+	cart, err := h.cartService.ApplyCoupon(r.Context(), middleware.UserIDFromContext(r.Context()), input.Code)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, cart)
+}
+
+// RemoveCoupon removes the coupon applied to the authenticated user's cart
+func (h *ProductHandler) RemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	cart, err := h.cartService.RemoveCoupon(r.Context(), middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, cart)
+}
+
+// CreateCoupon creates a coupon (admin)
+func (h *ProductHandler) CreateCoupon(w http.ResponseWriter, r *http.Request) {
+	var input models.CouponInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	userID := middleware.UserIDFromContext(ctx)
+	coupon, err := h.cartService.CreateCoupon(ctx, userID, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	log.Info().Str("coupon_id", coupon.ID).Str("user_id", userID).Msg("Coupon created")
+	utils.RespondJSON(w, http.StatusCreated, coupon)
+}
+
+// DeactivateCoupon stops a coupon being applied (admin)
+func (h *ProductHandler) DeactivateCoupon(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Coupon not found")
+		return
+	}
+	coupon, err := h.cartService.DeactivateCoupon(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, coupon)
+}
+
+func decodeCouponCode(w http.ResponseWriter, r *http.Request) (models.CouponCodeInput, bool) {
+	var input models.CouponCodeInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return input, false
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return input, false
+	}
+	return input, true
+}
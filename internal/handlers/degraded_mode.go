@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// DegradedModeHandler handles admin control of degraded mode
+type DegradedModeHandler struct {
+	degradedModeService *services.DegradedModeService
+}
+
+// NewDegradedModeHandler creates a new degraded mode handler
+func NewDegradedModeHandler(degradedModeService *services.DegradedModeService) *DegradedModeHandler {
+	return &DegradedModeHandler{degradedModeService: degradedModeService}
+}
+
+// GetDegradedMode returns the current degraded mode state
+func (h *DegradedModeHandler) GetDegradedMode(w http.ResponseWriter, r *http.Request) {
+	mode, err := h.degradedModeService.Get(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get degraded mode")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get degraded mode")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, mode)
+}
+
+// SetDegradedMode overrides the configured degraded mode state
+func (h *DegradedModeHandler) SetDegradedMode(w http.ResponseWriter, r *http.Request) {
+	var input models.DegradedModeInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	userID := middleware.UserIDFromContext(r.Context())
+	mode, err := h.degradedModeService.Set(r.Context(), input, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set degraded mode")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to set degraded mode")
+		return
+	}
+	log.Warn().Bool("enabled", mode.Enabled).Str("user_id", userID).Msg("Degraded mode changed")
+	utils.RespondJSON(w, http.StatusOK, mode)
+}
+
+// ResetDegradedMode removes the admin override, restoring the configured state
+func (h *DegradedModeHandler) ResetDegradedMode(w http.ResponseWriter, r *http.Request) {
+	mode, err := h.degradedModeService.Reset(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reset degraded mode")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to reset degraded mode")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, mode)
+}
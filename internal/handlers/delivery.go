@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// DeliveryHandler handles delivery estimate requests
+type DeliveryHandler struct {
+	deliveryService *services.DeliveryService
+}
+
+// NewDeliveryHandler creates a new delivery handler
+func NewDeliveryHandler(deliveryService *services.DeliveryService) *DeliveryHandler {
+	return &DeliveryHandler{deliveryService: deliveryService}
+}
+
+// GetProductEstimate estimates when a product would arrive at ?postalCode=
+func (h *DeliveryHandler) GetProductEstimate(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+	postalCode, ok := postalCodeParam(w, r)
+	if !ok {
+		return
+	}
+
+	estimate, err := h.deliveryService.EstimateProduct(r.Context(), id, postalCode)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, estimate)
+}
+
+// GetCartEstimate estimates when the user's cart would arrive at ?postalCode=
+func (h *DeliveryHandler) GetCartEstimate(w http.ResponseWriter, r *http.Request) {
+	postalCode, ok := postalCodeParam(w, r)
+	if !ok {
+		return
+	}
+
+	estimate, err := h.deliveryService.EstimateCart(r.Context(), middleware.UserIDFromContext(r.Context()), postalCode)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, estimate)
+}
+
+// postalCodeParam reads the optional postalCode query parameter,
+// responding 400 when it is too long
+func postalCodeParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	postalCode := strings.TrimSpace(r.URL.Query().Get("postalCode"))
+	if len(postalCode) > 20 {
+		utils.RespondValidationError(w, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "postalCode", Code: "max", Param: "20", Message: "postalCode must be at most 20",
+		}}})
+		return "", false
+	}
+	return postalCode, true
+}
+
+func (h *DeliveryHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrEmptyCart):
+		utils.RespondError(w, http.StatusBadRequest, "empty_cart", "Cart is empty")
+	default:
+		log.Error().Err(err).Msg("Delivery estimate failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Delivery estimate failed")
+	}
+}
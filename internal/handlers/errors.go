@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// notFoundErrors are the services' not found errors, with the message each
+// is answered with
+var notFoundErrors = []struct {
+	err     error
+	message string
+}{
+	{services.ErrProductNotFound, "Product not found"},
+	{services.ErrNutritionNotFound, "Product has no nutrition facts"},
+	{services.ErrTagNotFound, "Collection not found"},
+	{services.ErrCategoryNotFound, "Category not found"},
+	{services.ErrCartItemNotFound, "Cart item not found"},
+	{services.ErrOrderNotFound, "Order not found"},
+	{services.ErrSubOrderNotFound, "Sub-order not found"},
+	{services.ErrReturnNotFound, "Return not found"},
+	{services.ErrOrderItemNotFound, "Order item not found"},
+	{services.ErrReviewNotFound, "Review not found"},
+	{services.ErrWebhookNotFound, "Webhook subscription not found"},
+	{services.ErrFeatureFlagNotFound, "Feature flag not found"},
+	{services.ErrBroadcastNotFound, "Broadcast not found"},
+	{services.ErrBulkJobNotFound, "Bulk job not found"},
+	{services.ErrSellerNotFound, "Seller not found"},
+	{services.ErrContentBlockNotFound, "Content block not found"},
+	{services.ErrProductMergeNotFound, "Product merge not found"},
+	{services.ErrProductTranslationNotFound, "Product translation not found"},
+	{services.ErrPaymentMethodNotFound, "Payment method not found"},
+	{services.ErrAddOnNotFound, "Add-on not found"},
+	{services.ErrOrderAddOnNotFound, "Order add-on not found"},
+	{services.ErrAccountNotFound, "User not found"},
+	{services.ErrImpersonationNotFound, "Impersonation not found"},
+	{services.ErrAccountDeletionNotFound, "Account deletion not found"},
+	{services.ErrCommissionRateNotFound, "Commission rate not found"},
+	{services.ErrQuestionNotFound, "Question not found"},
+	{services.ErrAnswerNotFound, "Answer not found"},
+	{services.ErrAlertNotFound, "Alert not found"},
+	{services.ErrMaintenanceWindowNotFound, "Maintenance window not found"},
+	{services.ErrDeliveryPhotoNotFound, "Delivery photo not found"},
+	{services.ErrLabelNotFound, "Shipping label not found"},
+	{services.ErrCouponNotFound, "Coupon not found"},
+}
+
+// respondNotFound answers 404 not_found when err is one of the services' not
+// found errors, reporting whether it did. A sql.ErrNoRows that a service let
+// through is answered the same way, and logged so the service can be fixed,
+// rather than as a 500 or with the driver's message.
+func respondNotFound(w http.ResponseWriter, err error) bool {
+	for _, nf := range notFoundErrors {
+		if errors.Is(err, nf.err) {
+			utils.RespondError(w, http.StatusNotFound, "not_found", nf.message)
+			return true
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Warn().Err(err).Msg("Service returned sql.ErrNoRows")
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Not found")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// assertErrorEnvelope checks that rec holds the standard error envelope with
+// status, code and message
+func assertErrorEnvelope(t *testing.T, rec *httptest.ResponseRecorder, status int, code, message string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body utils.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error envelope: %v: %s", err, rec.Body)
+	}
+	if body.Error.Code != code || body.Error.Message != message {
+		t.Errorf("error = %s %q, want %s %q", body.Error.Code, body.Error.Message, code, message)
+	}
+}
+
+func TestRespondNotFound(t *testing.T) {
+	for _, nf := range notFoundErrors {
+		t.Run(nf.message, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if !respondNotFound(rec, fmt.Errorf("failed to get it: %w", nf.err)) {
+				t.Fatal("respondNotFound = false, want true")
+			}
+			assertErrorEnvelope(t, rec, http.StatusNotFound, "not_found", nf.message)
+		})
+	}
+
+	t.Run("sql.ErrNoRows", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		if !respondNotFound(rec, fmt.Errorf("failed to get product: %w", sql.ErrNoRows)) {
+			t.Fatal("respondNotFound = false, want true")
+		}
+		assertErrorEnvelope(t, rec, http.StatusNotFound, "not_found", "Not found")
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		if respondNotFound(rec, errors.New("connection refused")) {
+			t.Fatal("respondNotFound = true, want false")
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("wrote %s, want nothing", rec.Body)
+		}
+	})
+}
+
+func TestProductRespondErrorNotFound(t *testing.T) {
+	h := &ProductHandler{}
+	tests := []struct {
+		name    string
+		err     error
+		message string
+	}{
+		{"missing product", services.ErrProductNotFound, "Product not found"},
+		{"wrapped missing product", fmt.Errorf("failed to get product: %w", services.ErrProductNotFound), "Product not found"},
+		{"row a service let through", fmt.Errorf("failed to get product: %w", sql.ErrNoRows), "Not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.respondError(rec, tt.err)
+			assertErrorEnvelope(t, rec, http.StatusNotFound, "not_found", tt.message)
+		})
+	}
+}
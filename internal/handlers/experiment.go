@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// ExperimentHandler handles experiment conversion tracking and analysis
+type ExperimentHandler struct {
+	experimentService *services.ExperimentService
+}
+
+// NewExperimentHandler creates a new experiment handler
+func NewExperimentHandler(experimentService *services.ExperimentService) *ExperimentHandler {
+	return &ExperimentHandler{experimentService: experimentService}
+}
+
+// conversionRequest represents the payload for recording a conversion
+type conversionRequest struct {
+	Event string `json:"event"`
+}
+
+// RecordConversion records a conversion for the authenticated user
+func (h *ExperimentHandler) RecordConversion(w http.ResponseWriter, r *http.Request) {
+	var req conversionRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if req.Event == "" {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "event is required")
+		return
+	}
+
+	experiment := chi.URLParam(r, "key")
+	userID := middleware.UserIDFromContext(r.Context())
+	if err := h.experimentService.RecordConversion(r.Context(), experiment, userID, req.Event); err != nil {
+		log.Error().Err(err).Str("experiment", experiment).Msg("Failed to record conversion")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to record conversion")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetResults returns per-variant exposure and conversion counts. The period
+// defaults to the last 7 days and can be set with ?since=<RFC3339>.
+func (h *ExperimentHandler) GetResults(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().AddDate(0, 0, -7)
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		parsed, err := utils.ParseTime(raw)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "since must be an RFC3339 timestamp with an offset")
+			return
+		}
+		since = parsed
+	}
+
+	experiment := chi.URLParam(r, "key")
+	results, err := h.experimentService.Results(r.Context(), experiment, since)
+	if err != nil {
+		log.Error().Err(err).Str("experiment", experiment).Msg("Failed to get experiment results")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get experiment results")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// FeatureFlagHandler handles admin management of feature flags
+type FeatureFlagHandler struct {
+	featureFlagService *services.FeatureFlagService
+}
+
+// NewFeatureFlagHandler creates a new feature flag handler
+func NewFeatureFlagHandler(featureFlagService *services.FeatureFlagService) *FeatureFlagHandler {
+	return &FeatureFlagHandler{featureFlagService: featureFlagService}
+}
+
+// createFeatureFlagRequest represents the payload for creating a feature flag
+type createFeatureFlagRequest struct {
+	Key string `json:"key"`
+	models.FeatureFlagInput
+}
+
+// ListFlags returns all feature flags
+func (h *FeatureFlagHandler) ListFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := h.featureFlagService.List(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list feature flags")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to list feature flags")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, flags)
+}
+
+// GetFlag returns a single feature flag
+func (h *FeatureFlagHandler) GetFlag(w http.ResponseWriter, r *http.Request) {
+	flag, err := h.featureFlagService.Get(r.Context(), chi.URLParam(r, "key"))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, flag)
+}
+
+// CreateFlag creates a new feature flag
+func (h *FeatureFlagHandler) CreateFlag(w http.ResponseWriter, r *http.Request) {
+	var req createFeatureFlagRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+
+	flag, err := h.featureFlagService.Create(r.Context(), req.Key, req.FeatureFlagInput)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, flag)
+}
+
+// UpdateFlag updates an existing feature flag
+func (h *FeatureFlagHandler) UpdateFlag(w http.ResponseWriter, r *http.Request) {
+	var input models.FeatureFlagInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+
+	flag, err := h.featureFlagService.Update(r.Context(), chi.URLParam(r, "key"), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, flag)
+}
+
+// DeleteFlag deletes a feature flag
+func (h *FeatureFlagHandler) DeleteFlag(w http.ResponseWriter, r *http.Request) {
+	if err := h.featureFlagService.Delete(r.Context(), chi.URLParam(r, "key")); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FeatureFlagHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrFeatureFlagExists):
+		utils.RespondError(w, http.StatusConflict, "conflict", "Feature flag already exists")
+	case errors.Is(err, services.ErrInvalidFeatureFlag):
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", err.Error())
+	default:
+		log.Error().Err(err).Msg("Feature flag operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Feature flag operation failed")
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/version"
+)
+
+// HealthCheck reports whether a single dependency is ready. A failing
+// critical check marks the service not ready; a failing non-critical check
+// only marks it degraded. A check with Details runs it instead of Check,
+// reporting what it returns alongside its outcome.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+	Details  func(ctx context.Context) (interface{}, error)
+}
+
+// CheckStatus represents the outcome of a single readiness check
+type CheckStatus struct {
+	Status   string      `json:"status"`
+	Critical bool        `json:"critical"`
+	Message  string      `json:"message,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+// HealthResponse represents the health endpoint response
+type HealthResponse struct {
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	BuildTime     string `json:"buildTime"`
+	GoVersion     string `json:"goVersion"`
+	StartedAt     string `json:"startedAt"`
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+	Timestamp     string `json:"timestamp"`
+}
+
+// ReadinessResponse represents the readiness endpoint response
+type ReadinessResponse struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]CheckStatus `json:"checks"`
+	Timestamp string                 `json:"timestamp"`
+}
+
+// HealthHandler handles health and readiness probes
+type HealthHandler struct {
+	started time.Time
+
+	mu     sync.RWMutex
+	checks []HealthCheck
+}
+
+// NewHealthHandler creates a new health handler
+func NewHealthHandler(checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{started: time.Now(), checks: checks}
+}
+
+// Health reports that the process is up, with its build information. It
+// checks no dependencies; that is what Ready is for.
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	utils.RespondJSON(w, http.StatusOK, HealthResponse{
+		Status:        "healthy",
+		Version:       version.Version,
+		Commit:        version.Commit,
+		BuildTime:     version.BuildTime,
+		GoVersion:     version.GoVersion,
+		StartedAt:     h.started.UTC().Format(time.RFC3339),
+		UptimeSeconds: int64(now.Sub(h.started).Seconds()),
+		Timestamp:     now.UTC().Format(time.RFC3339),
+	})
+}
+
+// AddCheck registers an additional readiness check
+func (h *HealthHandler) AddCheck(check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, check)
+}
+
+// Ready runs all readiness checks concurrently and reports their results
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	checks := append([]HealthCheck(nil), h.checks...)
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	results := make([]CheckStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			result := CheckStatus{Status: "ok", Critical: check.Critical}
+			var err error
+			if check.Details != nil {
+				result.Details, err = check.Details(ctx)
+			} else {
+				err = check.Check(ctx)
+			}
+			if err != nil {
+				result.Status = "error"
+				result.Message = err.Error()
+			}
+			results[i] = result
+		}(i, check)
+	}
+	wg.Wait()
+
+	resp := ReadinessResponse{
+		Status:    "ready",
+		Checks:    make(map[string]CheckStatus, len(checks)),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	for i, check := range checks {
+		result := results[i]
+		resp.Checks[check.Name] = result
+		if result.Status == "ok" {
+			continue
+		}
+		if result.Critical {
+			resp.Status = "not_ready"
+		} else if resp.Status == "ready" {
+			resp.Status = "degraded"
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Status == "not_ready" {
+		status = http.StatusServiceUnavailable
+	}
+	utils.RespondJSON(w, status, resp)
+}
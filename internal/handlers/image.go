@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/storage"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// ImageHandler handles product image uploads and downloads
+type ImageHandler struct {
+	store          storage.BlobStore
+	productService *services.ProductService
+	importer       *services.ImageImporter
+}
+
+// NewImageHandler creates a new image handler
+func NewImageHandler(store storage.BlobStore, productService *services.ProductService, importer *services.ImageImporter) *ImageHandler {
+	return &ImageHandler{store: store, productService: productService, importer: importer}
+}
+
+// UploadImage stores the multipart "image" field and adds it to the product
+func (h *ImageHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	part, err := imagePart(r)
+	if err != nil {
+		if utils.IsBodyTooLarge(err) {
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large")
+			return
+		}
+		utils.RespondError(w, http.StatusBadRequest, "invalid_request", "Multipart field image is required")
+		return
+	}
+	defer part.Close()
+
+	body := bufio.NewReaderSize(part, 512)
+	sniff, err := body.Peek(512)
+	if err != nil && err != io.EOF {
+		if utils.IsBodyTooLarge(err) {
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large")
+			return
+		}
+		utils.RespondError(w, http.StatusBadRequest, "invalid_request", "Invalid image upload")
+		return
+	}
+	contentType := http.DetectContentType(sniff)
+	ext, ok := services.ImageExtensions[contentType]
+	if !ok {
+		utils.RespondError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Image must be JPEG, PNG, GIF or WebP")
+		return
+	}
+
+	ctx := r.Context()
+	key := fmt.Sprintf("products/%s/%s%s", id, uuid.New().String(), ext)
+	info, err := h.store.Put(ctx, key, body, contentType)
+	if err != nil {
+		if utils.IsBodyTooLarge(err) {
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large")
+			return
+		}
+		log.Error().Err(err).Str("key", key).Msg("Failed to store image")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to store image")
+		return
+	}
+
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.AddImage(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, models.ProductImage{
+		URL:         "/images/" + key,
+		Key:         key,
+		ContentType: contentType,
+		Size:        info.Size,
+	})
+	if err != nil {
+		if derr := h.store.Delete(ctx, key); derr != nil {
+			log.Warn().Err(derr).Str("key", key).Msg("Failed to remove orphaned image")
+		}
+		if respondNotFound(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrProductForbidden):
+			utils.RespondError(w, http.StatusForbidden, "forbidden", "You cannot modify this product")
+		default:
+			log.Error().Err(err).Str("product_id", id).Msg("Failed to add product image")
+			utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to add product image")
+		}
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, product)
+}
+
+// ImportImages fetches the given image URLs and adds them to the product.
+// URLs that cannot be imported are listed in the product's imageErrors.
+func (h *ImageHandler) ImportImages(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.ImageImportInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.importer.Import(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, input.URLs)
+	if err != nil {
+		if respondNotFound(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrProductForbidden):
+			utils.RespondError(w, http.StatusForbidden, "forbidden", "You cannot modify this product")
+		default:
+			log.Error().Err(err).Str("product_id", id).Msg("Failed to import product images")
+			utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to import product images")
+		}
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// ServeImage streams a stored image. It supports single byte-range requests
+// for resumable downloads and conditional requests against the image's
+// strong ETag and Last-Modified time. Only product images are public;
+// other blobs, like delivery photos, have their own authorized routes.
+func (h *ImageHandler) ServeImage(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	ctx := r.Context()
+	if !strings.HasPrefix(key, "products/") {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Image not found")
+		return
+	}
+
+	info, err := h.store.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Image not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Failed to stat image")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get image")
+		return
+	}
+
+	header := w.Header()
+	header.Set("ETag", info.ETag)
+	header.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Cache-Control", "public, max-age=86400")
+
+	if status := checkPreconditions(r, info); status != 0 {
+		if status == http.StatusNotModified {
+			w.WriteHeader(status)
+			return
+		}
+		utils.RespondError(w, status, "precondition_failed", "Precondition failed")
+		return
+	}
+
+	var rng *storage.ByteRange
+	if spec := r.Header.Get("Range"); spec != "" && ifRangeMatches(r, info) {
+		rng, err = parseRange(spec, info.Size)
+		if errors.Is(err, errUnsatisfiableRange) {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			utils.RespondError(w, http.StatusRequestedRangeNotSatisfiable, "range_not_satisfiable", "Requested range not satisfiable")
+			return
+		}
+		// Malformed and multi-range requests are served in full
+	}
+
+	if r.Method == http.MethodHead {
+		header.Set("Content-Type", info.ContentType)
+		writeRangeHeaders(w, rng, info.Size)
+		return
+	}
+
+	blob, err := h.store.Get(ctx, key, rng)
+	if errors.Is(err, storage.ErrNotFound) {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Image not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Failed to get image")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get image")
+		return
+	}
+	defer blob.Body.Close()
+
+	// The image may have been replaced since Stat; describe what is being sent
+	header.Set("ETag", blob.ETag)
+	header.Set("Last-Modified", blob.LastModified.UTC().Format(http.TimeFormat))
+	header.Set("Content-Type", blob.ContentType)
+	writeRangeHeaders(w, rng, blob.Size)
+
+	if _, err := io.Copy(w, blob.Body); err != nil && ctx.Err() == nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to stream image")
+	}
+}
+
+// imagePart returns the multipart "image" field without buffering the upload
+func imagePart(r *http.Request) (io.ReadCloser, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "image" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// writeRangeHeaders writes the status line and length headers for a full
+// (rng nil) or partial response
+func writeRangeHeaders(w http.ResponseWriter, rng *storage.ByteRange, size int64) {
+	if rng == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.Start, rng.End, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(rng.Length(), 10))
+	w.WriteHeader(http.StatusPartialContent)
+}
+
+// checkPreconditions evaluates conditional request headers in RFC 9110
+// order, returning the status to respond with or 0 to serve the image
+func checkPreconditions(r *http.Request, info *storage.BlobInfo) int {
+	modified := info.LastModified.Truncate(time.Second)
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, info.ETag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && modified.After(ius) {
+		return http.StatusPreconditionFailed
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, info.ETag, true) {
+			return http.StatusNotModified
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(ims) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// ifRangeMatches reports whether a Range request should be honoured given
+// its If-Range validator, which must match strongly
+func ifRangeMatches(r *http.Request, info *storage.BlobInfo) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return ir == info.ETag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && t.Equal(info.LastModified.Truncate(time.Second))
+}
+
+// etagListMatches reports whether a comma-separated If-Match/If-None-Match
+// list matches etag, using weak comparison when weak is set
+func etagListMatches(list, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a single "bytes=" range against a blob of size bytes. It
+// returns nil without error for range headers that should be ignored, such as
+// multiple ranges or other units.
+func parseRange(spec string, size int64) (*storage.ByteRange, error) {
+	spec, ok := strings.CutPrefix(spec, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		// Suffix range: the final n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return &storage.ByteRange{Start: size - n, End: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return nil, errUnsatisfiableRange
+	}
+	return &storage.ByteRange{Start: start, End: end}, nil
+}
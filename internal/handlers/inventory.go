@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// InventoryHandler handles seller stock management requests
+type InventoryHandler struct {
+	inventoryService *services.InventoryService
+	bulk             config.BulkConfig
+}
+
+// NewInventoryHandler creates a new inventory handler, limiting bulk
+// requests to bulk's item counts
+func NewInventoryHandler(inventoryService *services.InventoryService, bulk config.BulkConfig) *InventoryHandler {
+	return &InventoryHandler{inventoryService: inventoryService, bulk: bulk}
+}
+
+// AdjustStock applies a bulk stock adjustment to the seller's products.
+// Adjustments with more items than are applied inline are queued instead,
+// answering 202 with the bulk job to poll.
+func (h *InventoryHandler) AdjustStock(w http.ResponseWriter, r *http.Request) {
+	var input models.StockAdjustmentInput
+	if err := utils.DecodeJSONLimited(r, &input, "items", h.bulk.StockAdjustItems); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	if len(input.Items) > h.bulk.InlineStockAdjustItems {
+		job, err := h.inventoryService.QueueStockAdjustment(ctx, middleware.UserIDFromContext(ctx), input)
+		if err != nil {
+			h.respondError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/seller/bulk-jobs/"+job.ID)
+		utils.RespondJSON(w, http.StatusAccepted, job)
+		return
+	}
+	adjustment, err := h.inventoryService.AdjustStock(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, adjustment)
+}
+
+// ReceiveDelivery restocks the authenticated seller's products from a
+// supplier delivery, answering 201 the first time a reference is posted and
+// 200 with the recorded delivery after that
+func (h *InventoryHandler) ReceiveDelivery(w http.ResponseWriter, r *http.Request) {
+	var input models.StockDeliveryInput
+	if err := utils.DecodeJSONLimited(r, &input, "items", h.bulk.DeliveryItems); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	delivery, err := h.inventoryService.ReceiveDelivery(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	status := http.StatusCreated
+	if delivery.Replayed {
+		status = http.StatusOK
+	}
+	utils.RespondJSON(w, status, delivery)
+}
+
+// GetBulkJob returns one of the authenticated seller's bulk jobs, with its
+// result once it has finished
+func (h *InventoryHandler) GetBulkJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Bulk job not found")
+		return
+	}
+
+	ctx := r.Context()
+	job, err := h.inventoryService.GetBulkJob(ctx, id, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, job)
+}
+
+// GetStockHistory returns a page of a product's stock ledger, newest first
+func (h *InventoryHandler) GetStockHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 50, MaxLimit: 200})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	ctx := r.Context()
+	page, err := h.inventoryService.StockHistory(ctx, id, middleware.UserIDFromContext(ctx), params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+func (h *InventoryHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.Is(err, services.ErrProductForbidden):
+		utils.RespondError(w, http.StatusForbidden, "forbidden", "Product belongs to another seller")
+	case errors.Is(err, services.ErrDeliveryReferenceReused):
+		utils.RespondError(w, http.StatusConflict, "reference_reused", "Delivery reference already used for a different delivery")
+	default:
+		log.Error().Err(err).Msg("Inventory operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Inventory operation failed")
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// JWKSHandler publishes the public keys that verify access tokens
+type JWKSHandler struct {
+	tokenKeys *services.TokenKeys
+}
+
+// NewJWKSHandler creates a new JWKS handler
+func NewJWKSHandler(tokenKeys *services.TokenKeys) *JWKSHandler {
+	return &JWKSHandler{tokenKeys: tokenKeys}
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set
+func (h *JWKSHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.RespondJSON(w, http.StatusOK, h.tokenKeys.PublicKeys())
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// MaintenanceHandler handles admin scheduling of maintenance windows
+type MaintenanceHandler struct {
+	maintenanceService *services.MaintenanceService
+}
+
+// NewMaintenanceHandler creates a new maintenance handler
+func NewMaintenanceHandler(maintenanceService *services.MaintenanceService) *MaintenanceHandler {
+	return &MaintenanceHandler{maintenanceService: maintenanceService}
+}
+
+// ListMaintenanceWindows returns a page of windows, the latest start first
+func (h *MaintenanceHandler) ListMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	page, err := h.maintenanceService.List(r.Context(), params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// ScheduleMaintenanceWindow schedules a window with its banner
+func (h *MaintenanceHandler) ScheduleMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	var input models.MaintenanceWindowInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	userID := middleware.UserIDFromContext(ctx)
+	window, err := h.maintenanceService.Schedule(ctx, userID, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	log.Warn().Str("window_id", window.ID).Time("starts_at", window.StartsAt).Time("ends_at", window.EndsAt).
+		Str("user_id", userID).Msg("Maintenance window scheduled")
+	utils.RespondJSON(w, http.StatusCreated, window)
+}
+
+// UpdateMaintenanceWindow reschedules a window or changes its message
+func (h *MaintenanceHandler) UpdateMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	id, ok := maintenanceWindowID(w, r)
+	if !ok {
+		return
+	}
+	var input models.MaintenanceWindowInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	window, err := h.maintenanceService.Update(r.Context(), id, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, window)
+}
+
+// CancelMaintenanceWindow cancels a window, on or not, and removes its banner
+func (h *MaintenanceHandler) CancelMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	id, ok := maintenanceWindowID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	window, err := h.maintenanceService.Cancel(ctx, id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	log.Warn().Str("window_id", window.ID).Str("user_id", middleware.UserIDFromContext(ctx)).Msg("Maintenance window cancelled")
+	utils.RespondJSON(w, http.StatusOK, window)
+}
+
+func maintenanceWindowID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Maintenance window not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *MaintenanceHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.Is(err, services.ErrMaintenanceWindowClosed):
+		utils.RespondError(w, http.StatusConflict, "maintenance_window_closed", "Maintenance window has ended or been cancelled")
+	default:
+		log.Error().Err(err).Msg("Maintenance window operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Maintenance window operation failed")
+	}
+}
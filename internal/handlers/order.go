@@ -0,0 +1,1244 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/storage"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// OrderHandler handles order requests
+type OrderHandler struct {
+	orderService *services.OrderService
+	cartService  *services.CartService
+	store        storage.BlobStore
+}
+
+// NewOrderHandler creates a new order handler keeping delivery photos in
+// store
+func NewOrderHandler(orderService *services.OrderService, cartService *services.CartService, store storage.BlobStore) *OrderHandler {
+	return &OrderHandler{orderService: orderService, cartService: cartService, store: store}
+}
+
+// CreateOrder checks out the authenticated user's cart
+func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	var input models.OrderInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	order, err := h.orderService.Create(r.Context(), middleware.UserIDFromContext(r.Context()), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, order)
+}
+
+// QuoteOrder prices the authenticated user's cart as checkout would,
+// without placing an order; with ?allowBackorder=true as checkout with
+// allowBackorder would. Shipping is priced for ?shippingMethod= and dated
+// for delivery to ?postalCode=. The optional body lists the add-ons to
+// price with the cart.
+func (h *OrderHandler) QuoteOrder(w http.ResponseWriter, r *http.Request) {
+	var input models.QuoteInput
+	if err := utils.DecodeJSON(r, &input); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	allowBackorder := q.Get("allowBackorder") == "true"
+	postalCode, ok := postalCodeParam(w, r)
+	if !ok {
+		return
+	}
+	shippingMethod := q.Get("shippingMethod")
+	switch shippingMethod {
+	case "", models.ShippingStandard, models.ShippingExpress, models.ShippingPickup:
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "shippingMethod must be standard, express or pickup")
+		return
+	}
+	quote, err := h.orderService.Quote(r.Context(), middleware.UserIDFromContext(r.Context()), allowBackorder, postalCode, shippingMethod, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, quote)
+}
+
+// GetOrder returns an order with its customer-visible notes
+func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.Get(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.Respond(w, r, http.StatusOK, order)
+}
+
+// GetPackingSlip returns an order's packing slip
+func (h *OrderHandler) GetPackingSlip(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	slip, err := h.orderService.PackingSlip(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.Respond(w, r, http.StatusOK, slip)
+}
+
+// Reorder puts a past order's items back in its buyer's cart at current
+// prices, reporting the items that could not be added
+func (h *OrderHandler) Reorder(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	reorder, err := h.cartService.Reorder(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, reorder)
+}
+
+// updateOrderStatusRequest represents the payload for changing an order's status
+type updateOrderStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=pending paid shipped delivered cancelled"`
+}
+
+// UpdateOrderStatus changes an order's status
+func (h *OrderHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateOrderStatusRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(req); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.UpdateStatus(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), req.Status)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// maxDeliveryFieldBytes is the most read of a text field of a multipart
+// proof of delivery
+const maxDeliveryFieldBytes = 2048
+
+// DeliverOrder confirms a shipped order delivered with a proof of delivery:
+// a multipart form with an optional "photo" and "signature" and "notes"
+// fields, or a JSON body with the latter two, or no body at all
+func (h *OrderHandler) DeliverOrder(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var input models.DeliveryInput
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if !h.readDeliveryForm(w, r, id, &input) {
+			return
+		}
+	} else if err := utils.DecodeJSON(r, &input); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	removePhoto := func() {
+		if input.Photo == nil {
+			return
+		}
+		if err := h.store.Delete(ctx, input.Photo.Key); err != nil {
+			log.Warn().Err(err).Str("key", input.Photo.Key).Msg("Failed to remove orphaned delivery photo")
+		}
+	}
+	if err := validators.Validate(input); err != nil {
+		removePhoto()
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	order, err := h.orderService.Deliver(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), input)
+	if err != nil {
+		removePhoto()
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// readDeliveryForm reads a multipart proof of delivery of order id into
+// input, storing its photo as it streams in. It responds with an error and
+// returns false when the form is invalid, leaving no photo stored.
+func (h *OrderHandler) readDeliveryForm(w http.ResponseWriter, r *http.Request, id string, input *models.DeliveryInput) bool {
+	respond := func(err error, status int, code, message string) bool {
+		if input.Photo != nil {
+			if derr := h.store.Delete(r.Context(), input.Photo.Key); derr != nil {
+				log.Warn().Err(derr).Str("key", input.Photo.Key).Msg("Failed to remove orphaned delivery photo")
+			}
+		}
+		if utils.IsBodyTooLarge(err) {
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large")
+			return false
+		}
+		utils.RespondError(w, status, code, message)
+		return false
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return respond(err, http.StatusBadRequest, "invalid_request", "Invalid multipart form")
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+		if err != nil {
+			return respond(err, http.StatusBadRequest, "invalid_request", "Invalid multipart form")
+		}
+		switch part.FormName() {
+		case "photo":
+			if input.Photo != nil {
+				part.Close()
+				return respond(nil, http.StatusBadRequest, "invalid_request", "Only one photo may be given")
+			}
+			body := bufio.NewReaderSize(part, 512)
+			sniff, err := body.Peek(512)
+			if err != nil && err != io.EOF {
+				part.Close()
+				return respond(err, http.StatusBadRequest, "invalid_request", "Invalid photo upload")
+			}
+			contentType := http.DetectContentType(sniff)
+			ext, ok := services.ImageExtensions[contentType]
+			if !ok {
+				part.Close()
+				return respond(nil, http.StatusUnsupportedMediaType, "unsupported_media_type", "Photo must be JPEG, PNG, GIF or WebP")
+			}
+			key := fmt.Sprintf("deliveries/%s/%s%s", id, uuid.New().String(), ext)
+			info, err := h.store.Put(r.Context(), key, body, contentType)
+			part.Close()
+			if err != nil {
+				if !utils.IsBodyTooLarge(err) {
+					log.Error().Err(err).Str("key", key).Msg("Failed to store delivery photo")
+				}
+				return respond(err, http.StatusInternalServerError, "internal_error", "Failed to store photo")
+			}
+			input.Photo = &models.DeliveryPhoto{Key: key, ContentType: contentType, Size: info.Size}
+		case "signature", "notes":
+			value, err := io.ReadAll(io.LimitReader(part, maxDeliveryFieldBytes))
+			name := part.FormName()
+			part.Close()
+			if err != nil {
+				return respond(err, http.StatusBadRequest, "invalid_request", "Invalid multipart form")
+			}
+			if name == "signature" {
+				input.Signature = string(value)
+			} else {
+				input.Notes = string(value)
+			}
+		default:
+			part.Close()
+		}
+	}
+}
+
+// GetDeliveryPhoto streams the photo proving an order was delivered, to
+// those who can view the order
+func (h *OrderHandler) GetDeliveryPhoto(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	photo, err := h.orderService.DeliveryPhoto(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	blob, err := h.store.Get(ctx, photo.Key, nil)
+	if errors.Is(err, storage.ErrNotFound) {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Delivery photo not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", photo.Key).Msg("Failed to get delivery photo")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get delivery photo")
+		return
+	}
+	defer blob.Body.Close()
+
+	w.Header().Set("Content-Type", photo.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, blob.Body); err != nil && ctx.Err() == nil {
+		log.Warn().Err(err).Str("key", photo.Key).Msg("Failed to stream delivery photo")
+	}
+}
+
+// ProcessPayment charges an order to one of its buyer's stored cards
+func (h *OrderHandler) ProcessPayment(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	// The body is optional
+	var input models.PaymentInput
+	if err := utils.DecodeJSON(r, &input); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.Pay(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted
+const maxIdempotencyKeyLength = 255
+
+// BuyNow orders and pays for a single product at once, bypassing the cart.
+// The Idempotency-Key header is required; retries with the same key answer
+// with the order the first request placed. The body is optional.
+func (h *OrderHandler) BuyNow(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error",
+			"Idempotency-Key header is required, at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters")
+		return
+	}
+	var input models.BuyNowInput
+	if err := utils.DecodeJSON(r, &input); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.BuyNow(ctx, middleware.UserIDFromContext(ctx), id, key, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, order)
+}
+
+// UpdateSubOrderStatus changes the status of one seller's sub-order
+func (h *OrderHandler) UpdateSubOrderStatus(w http.ResponseWriter, r *http.Request) {
+	id, subOrderID, ok := subOrderIDs(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateOrderStatusRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(req); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.UpdateSubOrderStatus(ctx, id, subOrderID, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), req.Status)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// RefundSubOrder refunds some or all of a sub-order
+func (h *OrderHandler) RefundSubOrder(w http.ResponseWriter, r *http.Request) {
+	id, subOrderID, ok := subOrderIDs(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.RefundInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.RefundSubOrder(ctx, id, subOrderID, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// RefundAddOn refunds an order's add-on, in full or in part
+func (h *OrderHandler) RefundAddOn(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	addOnID := chi.URLParam(r, "addOnId")
+	if _, err := uuid.Parse(addOnID); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Order add-on not found")
+		return
+	}
+
+	var input models.RefundInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.RefundAddOn(ctx, id, addOnID, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// GetAddOns returns the add-ons offered at checkout
+func (h *OrderHandler) GetAddOns(w http.ResponseWriter, r *http.Request) {
+	addOns, err := h.orderService.ListAddOns(r.Context(), false)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"addOns": addOns})
+}
+
+// ListAllAddOns returns every add-on, including those no longer offered
+func (h *OrderHandler) ListAllAddOns(w http.ResponseWriter, r *http.Request) {
+	addOns, err := h.orderService.ListAddOns(r.Context(), true)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"addOns": addOns})
+}
+
+// CreateAddOn adds an add-on to those offered at checkout
+func (h *OrderHandler) CreateAddOn(w http.ResponseWriter, r *http.Request) {
+	var input models.AddOnInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	addOn, err := h.orderService.CreateAddOn(r.Context(), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, addOn)
+}
+
+// UpdateAddOn replaces an add-on
+func (h *OrderHandler) UpdateAddOn(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Add-on not found")
+		return
+	}
+	var input models.AddOnInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	addOn, err := h.orderService.UpdateAddOn(r.Context(), id, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, addOn)
+}
+
+// CreateNote appends a note to an order
+func (h *OrderHandler) CreateNote(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.OrderNoteInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	note, err := h.orderService.AddNote(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, note)
+}
+
+// GetNotes returns a page of an order's notes, newest first
+func (h *OrderHandler) GetNotes(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	page, err := h.orderService.ListNotes(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.Respond(w, r, http.StatusOK, page)
+}
+
+// GetPaymentAttempts lists an order's payment attempts for support, with the
+// gateway's decline details
+func (h *OrderHandler) GetPaymentAttempts(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.orderService.PaymentAttempts(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.Respond(w, r, http.StatusOK, page)
+}
+
+// GetOrderEvents returns an order's event log with the state it folds to
+func (h *OrderHandler) GetOrderEvents(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	events, err := h.orderService.OrderEvents(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, events)
+}
+
+// GetOrderFraud returns an order's fraud score, the rules behind it and the
+// admin decisions on it while it was held
+func (h *OrderHandler) GetOrderFraud(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	fraud, err := h.orderService.OrderFraud(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, fraud)
+}
+
+// ReviewHeldOrder releases or cancels an order held for fraud review
+func (h *OrderHandler) ReviewHeldOrder(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	var input models.FraudReviewInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	fraud, err := h.orderService.ReviewHeldOrder(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, fraud)
+}
+
+// RequestReturn asks to return items of the buyer's delivered order
+func (h *OrderHandler) RequestReturn(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	var input models.ReturnInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	ret, err := h.orderService.RequestReturn(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, ret)
+}
+
+// GetReturns lists an order's returns, newest first
+func (h *OrderHandler) GetReturns(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	returns, err := h.orderService.ListReturns(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"returns": returns})
+}
+
+// SearchReturns lists every order's returns for admins, newest first,
+// filtered by status when it is given
+func (h *OrderHandler) SearchReturns(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.ReturnRequested, models.ReturnApproved, models.ReturnRejected, models.ReturnReceived:
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "status must be requested, approved, rejected or received")
+		return
+	}
+
+	page, err := h.orderService.SearchReturns(r.Context(), status, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// ApproveReturn approves a requested return with the label it is shipped
+// back with
+func (h *OrderHandler) ApproveReturn(w http.ResponseWriter, r *http.Request) {
+	id, ok := returnID(w, r)
+	if !ok {
+		return
+	}
+	var input models.ReturnApprovalInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	ret, err := h.orderService.ApproveReturn(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, ret)
+}
+
+// RejectReturn rejects a requested return
+func (h *OrderHandler) RejectReturn(w http.ResponseWriter, r *http.Request) {
+	id, ok := returnID(w, r)
+	if !ok {
+		return
+	}
+	var input models.ReturnRejectionInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	ret, err := h.orderService.RejectReturn(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, ret)
+}
+
+// ReceiveReturn records an approved return as received back, refunding and
+// quarantining its items
+func (h *OrderHandler) ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	id, ok := returnID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	ret, err := h.orderService.ReceiveReturn(ctx, id, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, ret)
+}
+
+// SetProductReturnWindow sets how many days after delivery a product may
+// be returned
+func (h *OrderHandler) SetProductReturnWindow(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Product not found")
+		return
+	}
+	var input models.ReturnWindowInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	if err := h.orderService.SetProductReturnWindow(r.Context(), id, input.Days); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, input)
+}
+
+// SetCategoryReturnWindow sets how many days after delivery a category's
+// products may be returned
+func (h *OrderHandler) SetCategoryReturnWindow(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Category not found")
+		return
+	}
+	var input models.ReturnWindowInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	if err := h.orderService.SetCategoryReturnWindow(r.Context(), id, input.Days); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, input)
+}
+
+// SetProductTaxExemption sets why a product is exempt from sales tax
+func (h *OrderHandler) SetProductTaxExemption(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Product not found")
+		return
+	}
+	var input models.TaxExemptionInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	if err := h.orderService.SetProductTaxExemption(r.Context(), id, input.Reason); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, input)
+}
+
+// SetCategoryTaxExemption sets why a category's products are exempt from
+// sales tax
+func (h *OrderHandler) SetCategoryTaxExemption(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Category not found")
+		return
+	}
+	var input models.TaxExemptionInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	if err := h.orderService.SetCategoryTaxExemption(r.Context(), id, input.Reason); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, input)
+}
+
+// SetUserTaxExemption sets why a buyer's orders are exempt from sales tax
+func (h *OrderHandler) SetUserTaxExemption(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "User not found")
+		return
+	}
+	var input models.TaxExemptionInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	if err := h.orderService.SetBuyerTaxExemption(r.Context(), id, input.Reason); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, input)
+}
+
+// orderReferencePattern matches order references, like GM-2024-000123, in
+// any case
+var orderReferencePattern = regexp.MustCompile(`^(?i)GM-\d{4}-\d{6,}$`)
+
+// SearchOrders lists orders for admins, filtered by status (comma-separated),
+// createdFrom/createdTo (RFC3339), email, orderNumber (the number or the
+// reference) and q (customer email or name), with cursor pagination
+func (h *OrderHandler) SearchOrders(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 50, MaxLimit: 200, Cursor: true})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	filter, ok := adminOrderFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.Sort, filter.Cursor, filter.Limit = params.Sort, params.Cursor, params.Limit
+	filter.IncludeItems = q.Get("includeItems") == "true"
+
+	page, err := h.orderService.Search(r.Context(), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.Respond(w, r, http.StatusOK, page)
+}
+
+// ExportOrders streams every order matching the SearchOrders filters as a
+// JSON array, sorted by ?sort=, without their items. Memory stays flat
+// however many orders match; an export that fails midway is cut off.
+func (h *OrderHandler) ExportOrders(w http.ResponseWriter, r *http.Request) {
+	filter, ok := adminOrderFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.Sort = r.URL.Query().Get("sort")
+
+	rows, err := h.orderService.ExportOrders(r.Context(), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	defer rows.Close()
+	if err := utils.StreamJSONArray(w, rows, services.ScanExportedOrder); err != nil {
+		h.respondError(w, err)
+	}
+}
+
+// adminOrderFilter parses the filters of the admin order search, responding
+// with an error if they are invalid
+func adminOrderFilter(w http.ResponseWriter, r *http.Request) (models.AdminOrderFilter, bool) {
+	q := r.URL.Query()
+	filter := models.AdminOrderFilter{Email: q.Get("email"), Query: q.Get("q")}
+	if statuses := q.Get("status"); statuses != "" {
+		filter.Statuses = strings.Split(statuses, ",")
+	}
+	for name, dest := range map[string]**time.Time{"createdFrom": &filter.From, "createdTo": &filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := utils.ParseTime(v)
+			if err != nil {
+				utils.RespondError(w, http.StatusBadRequest, "validation_error", name+" must be an RFC3339 timestamp with an offset")
+				return filter, false
+			}
+			*dest = &t
+		}
+	}
+	if v := q.Get("orderNumber"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		switch {
+		case err == nil && n > 0:
+			filter.OrderNumber = n
+		case orderReferencePattern.MatchString(v):
+			filter.Reference = v
+		default:
+			utils.RespondError(w, http.StatusBadRequest, "validation_error",
+				"orderNumber must be a positive integer or an order reference like GM-2024-000123")
+			return filter, false
+		}
+	}
+	return filter, true
+}
+
+// GetFulfillmentQueue returns a page of the seller's orders with items to
+// ship, filtered by status (comma-separated, paid by default)
+func (h *OrderHandler) GetFulfillmentQueue(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	var statuses []string
+	if v := r.URL.Query().Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			if _, ok := fulfillmentStatuses[status]; !ok {
+				utils.RespondError(w, http.StatusBadRequest, "validation_error", "status must be one of pending, paid, shipped, delivered, cancelled")
+				return
+			}
+			statuses = append(statuses, status)
+		}
+	}
+
+	ctx := r.Context()
+	queue, err := h.orderService.FulfillmentQueue(ctx, middleware.UserIDFromContext(ctx), statuses, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, queue)
+}
+
+// fulfillmentStatuses are the order statuses the fulfillment queue can be filtered by
+var fulfillmentStatuses = map[string]struct{}{
+	"pending": {}, "paid": {}, "shipped": {}, "delivered": {}, "cancelled": {},
+}
+
+// FulfillItem marks an order item shipped by the seller of its product
+func (h *OrderHandler) FulfillItem(w http.ResponseWriter, r *http.Request) {
+	id, itemID, ok := orderItemIDs(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.FulfillmentInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	item, err := h.orderService.FulfillItem(ctx, id, itemID, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, item)
+}
+
+// ShipOrders marks many of the seller's orders shipped, each with its
+// tracking, reporting the orders that could not be shipped in failed
+func (h *OrderHandler) ShipOrders(w http.ResponseWriter, r *http.Request) {
+	var input models.BulkShipInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := h.orderService.ShipOrders(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// TransitionOrders moves many orders to a status at once, reporting the
+// orders moved in applied and those left alone in skipped, with why
+func (h *OrderHandler) TransitionOrders(w http.ResponseWriter, r *http.Request) {
+	var input models.BulkTransitionInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := h.orderService.TransitionOrders(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// SetBackorderETA sets when a backordered order item's stock is expected
+func (h *OrderHandler) SetBackorderETA(w http.ResponseWriter, r *http.Request) {
+	id, itemID, ok := orderItemIDs(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.BackorderInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.SetBackorderETA(ctx, id, itemID, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// CancelBackorder cancels a backordered order item and refunds it
+func (h *OrderHandler) CancelBackorder(w http.ResponseWriter, r *http.Request) {
+	id, itemID, ok := orderItemIDs(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	order, err := h.orderService.CancelBackorder(ctx, id, itemID, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, order)
+}
+
+// orderID reads the order ID URL parameter, responding 404 when it is not a
+// valid ID
+func orderID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Order not found")
+		return "", false
+	}
+	return id, true
+}
+
+// returnID reads the return ID URL parameter, responding 404 when it is not
+// a valid ID
+func returnID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Return not found")
+		return "", false
+	}
+	return id, true
+}
+
+// orderItemIDs reads the order and item ID URL parameters, responding 404
+// when either is not a valid ID
+func orderItemIDs(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return "", "", false
+	}
+	itemID := chi.URLParam(r, "itemId")
+	if _, err := uuid.Parse(itemID); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Order item not found")
+		return "", "", false
+	}
+	return id, itemID, true
+}
+
+// subOrderIDs reads the order and sub-order ID URL parameters, responding
+// 404 when either is not a valid ID
+func subOrderIDs(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return "", "", false
+	}
+	subOrderID := chi.URLParam(r, "subOrderId")
+	if _, err := uuid.Parse(subOrderID); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Sub-order not found")
+		return "", "", false
+	}
+	return id, subOrderID, true
+}
+
+func (h *OrderHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	var declined *services.PaymentDeclinedError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.As(err, &declined):
+		utils.RespondErrorWithDetails(w, http.StatusPaymentRequired, "payment_declined", declined.Message(),
+			map[string]string{"reason": declined.Reason})
+	case errors.Is(err, services.ErrEmptyCart):
+		utils.RespondError(w, http.StatusBadRequest, "empty_cart", "Cart is empty")
+	case errors.Is(err, services.ErrIdempotencyKeyReused):
+		utils.RespondError(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency key was already used to buy another product")
+	case errors.Is(err, services.ErrPaymentsUnavailable):
+		utils.RespondError(w, http.StatusServiceUnavailable, "payments_unavailable", "Payments are unavailable")
+	case errors.Is(err, services.ErrOrderForbidden):
+		utils.RespondError(w, http.StatusForbidden, "forbidden", "Insufficient permissions")
+	case errors.Is(err, services.ErrOrderNotPaid):
+		utils.RespondError(w, http.StatusConflict, "not_paid", "Order has not been paid")
+	case errors.Is(err, services.ErrPreordersPending):
+		utils.RespondError(w, http.StatusConflict, "preorders_pending", "Order is paid for once its preorders are available")
+	case errors.Is(err, services.ErrItemFulfilled):
+		utils.RespondError(w, http.StatusConflict, "already_fulfilled", "Order item already fulfilled")
+	case errors.Is(err, services.ErrItemBackordered):
+		utils.RespondError(w, http.StatusConflict, "backordered", "Order item is backordered")
+	case errors.Is(err, services.ErrItemCancelled):
+		utils.RespondError(w, http.StatusConflict, "cancelled", "Order item was cancelled")
+	case errors.Is(err, services.ErrItemNotBackordered):
+		utils.RespondError(w, http.StatusConflict, "not_backordered", "Order item is not backordered")
+	case errors.Is(err, services.ErrOrderNotFulfillable):
+		utils.RespondError(w, http.StatusConflict, "not_fulfillable", err.Error())
+	case errors.Is(err, services.ErrOrderHeld):
+		utils.RespondError(w, http.StatusConflict, "under_review", "Order is held for review")
+	case errors.Is(err, services.ErrOrderNotHeld):
+		utils.RespondError(w, http.StatusConflict, "not_held", "Order is not held for review")
+	case errors.Is(err, services.ErrInvalidOrderTransition):
+		utils.RespondError(w, http.StatusConflict, "invalid_transition", err.Error())
+	case errors.Is(err, services.ErrOrderNotDelivered):
+		utils.RespondError(w, http.StatusConflict, "not_delivered", "Order has not been delivered")
+	case errors.Is(err, services.ErrInvalidReturnTransition):
+		utils.RespondError(w, http.StatusConflict, "invalid_return_transition", err.Error())
+	case errors.Is(err, services.ErrInvalidOrderFilter):
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", err.Error())
+	default:
+		log.Error().Err(err).Msg("Order operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Order operation failed")
+	}
+}
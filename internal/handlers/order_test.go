@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// orderRouter routes the order endpoints that charge the buyer to h as
+// main.go does, behind JWT authentication
+func orderRouter(tokens *jwtauth.JWTAuth, h *OrderHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.JWTAuth(tokens, config.JWTConfig{}))
+	r.With(middleware.ForbidImpersonation).Post("/orders", h.CreateOrder)
+	r.With(middleware.ForbidImpersonation).Post("/products/{id}/buy-now", h.BuyNow)
+	return r
+}
+
+// userToken signs an access token for user-1, made by adminID acting as
+// them when adminID isn't empty, as UserService does
+func userToken(t *testing.T, tokens *jwtauth.JWTAuth, adminID string) string {
+	t.Helper()
+	claims := map[string]interface{}{
+		"sub":     "user-1",
+		"user_id": "user-1",
+		"role":    middleware.RoleUser,
+	}
+	if adminID != "" {
+		claims["act"] = map[string]interface{}{"sub": adminID}
+		claims["jti"] = "impersonation-1"
+	}
+	jwtauth.SetIssuedNow(claims)
+	jwtauth.SetExpiry(claims, time.Now().Add(time.Hour))
+	_, token, err := tokens.Encode(claims)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+// Ordering charges the buyer's stored card, so an admin impersonating them
+// is turned away before the handler runs. The handler has no services: a
+// request reaching it past its checks would panic.
+func TestOrderingForbidsImpersonation(t *testing.T) {
+	tokens := jwtauth.New("HS256", []byte("test-secret"), nil)
+	router := orderRouter(tokens, &OrderHandler{})
+	buyNow := "/products/" + uuid.NewString() + "/buy-now"
+
+	tests := []struct {
+		name    string
+		path    string
+		adminID string
+		status  int
+		code    string
+	}{
+		{"buy now impersonated", buyNow, "admin-1", http.StatusForbidden, "impersonation_forbidden"},
+		{"create order impersonated", "/orders", "admin-1", http.StatusForbidden, "impersonation_forbidden"},
+		// The user's own token reaches the handler, which rejects the
+		// request for its missing Idempotency-Key and body
+		{"buy now own token", buyNow, "", http.StatusBadRequest, "validation_error"},
+		{"create order own token", "/orders", "", http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+userToken(t, tokens, tt.adminID))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code == "" {
+				return
+			}
+			var body utils.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode error envelope: %v: %s", err, rec.Body)
+			}
+			if body.Error.Code != tt.code {
+				t.Errorf("error code = %q, want %q", body.Error.Code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// PaymentMethodHandler handles users' stored payment methods
+type PaymentMethodHandler struct {
+	paymentMethodService *services.PaymentMethodService
+}
+
+// NewPaymentMethodHandler creates a new payment method handler
+func NewPaymentMethodHandler(paymentMethodService *services.PaymentMethodService) *PaymentMethodHandler {
+	return &PaymentMethodHandler{paymentMethodService: paymentMethodService}
+}
+
+// ListPaymentMethods returns the user's stored cards, default first
+func (h *PaymentMethodHandler) ListPaymentMethods(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	methods, err := h.paymentMethodService.List(ctx, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, methods)
+}
+
+// AddPaymentMethod stores the card behind a payment gateway token
+func (h *PaymentMethodHandler) AddPaymentMethod(w http.ResponseWriter, r *http.Request) {
+	var input models.PaymentMethodInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	method, err := h.paymentMethodService.Add(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, method)
+}
+
+// SetDefaultPaymentMethod makes a stored card the user's default
+func (h *PaymentMethodHandler) SetDefaultPaymentMethod(w http.ResponseWriter, r *http.Request) {
+	id, ok := paymentMethodID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	method, err := h.paymentMethodService.SetDefault(ctx, middleware.UserIDFromContext(ctx), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, method)
+}
+
+// DeletePaymentMethod removes a stored card, detaching it at the gateway
+func (h *PaymentMethodHandler) DeletePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	id, ok := paymentMethodID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	if err := h.paymentMethodService.Delete(ctx, middleware.UserIDFromContext(ctx), id); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// paymentMethodID reads the payment method ID URL parameter, responding 404
+// when it is not a valid ID
+func paymentMethodID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Payment method not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *PaymentMethodHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.Is(err, services.ErrPaymentsUnavailable):
+		utils.RespondError(w, http.StatusServiceUnavailable, "payments_unavailable", "Payments are unavailable")
+	default:
+		log.Error().Err(err).Msg("Payment method operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Payment method operation failed")
+	}
+}
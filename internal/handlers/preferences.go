@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// PreferencesHandler handles user preferences requests
+type PreferencesHandler struct {
+	userService *services.UserService
+}
+
+// NewPreferencesHandler creates a new preferences handler
+func NewPreferencesHandler(userService *services.UserService) *PreferencesHandler {
+	return &PreferencesHandler{userService: userService}
+}
+
+// GetPreferences returns the authenticated user's preferences
+func (h *PreferencesHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prefs, err := h.userService.GetPreferences(ctx, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, prefs)
+}
+
+// UpdatePreferences replaces the authenticated user's preferences
+func (h *PreferencesHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	var input models.PreferencesInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	prefs, err := h.userService.UpdatePreferences(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, prefs)
+}
+
+// PatchPreferences merges a JSON merge patch into the authenticated user's
+// preferences
+func (h *PreferencesHandler) PatchPreferences(w http.ResponseWriter, r *http.Request) {
+	var patch models.PreferencesPatch
+	if err := utils.DecodeJSON(r, &patch); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	prefs, err := h.userService.PatchPreferences(ctx, middleware.UserIDFromContext(ctx), patch)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, prefs)
+}
+
+func (h *PreferencesHandler) respondError(w http.ResponseWriter, err error) {
+	var verr *validators.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	default:
+		log.Error().Err(err).Msg("Preferences operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Preferences operation failed")
+	}
+}
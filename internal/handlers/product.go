@@ -0,0 +1,1376 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ProductHandler handles product, search, cart, and wishlist requests
+type ProductHandler struct {
+	productService *services.ProductService
+	searchService  *services.SearchService
+	cartService    *services.CartService
+	imageImporter  *services.ImageImporter
+	bulk           config.BulkConfig
+}
+
+// NewProductHandler creates a new product handler
+func NewProductHandler(productService *services.ProductService, searchService *services.SearchService, cartService *services.CartService, imageImporter *services.ImageImporter, bulk config.BulkConfig) *ProductHandler {
+	return &ProductHandler{
+		productService: productService,
+		searchService:  searchService,
+		cartService:    cartService,
+		imageImporter:  imageImporter,
+		bulk:           bulk,
+	}
+}
+
+// SearchProducts performs a keyword product search
+func (h *ProductHandler) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "Query parameter q is required")
+		return
+	}
+
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	near, ok := geoFilterParam(w, r)
+	if !ok {
+		return
+	}
+	if params.Sort == "distance" && near == nil {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "sorting by distance needs lat and lng")
+		return
+	}
+
+	result, err := h.searchService.Search(r.Context(), services.SearchParams{
+		Query:          query,
+		CategoryID:     q.Get("category"),
+		UserID:         middleware.UserIDFromContext(r.Context()),
+		Limit:          params.Limit,
+		Offset:         params.Offset,
+		Highlight:      q.Get("highlight") == "true",
+		Locale:         q.Get("locale"),
+		Near:           near,
+		SortByDistance: params.Sort == "distance",
+	})
+	if err != nil {
+		log.Error().Err(err).Str("query", query).Msg("Search failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Search failed")
+		return
+	}
+	products := make([]*models.Product, len(result.Products))
+	for i := range result.Products {
+		products[i] = &result.Products[i]
+	}
+	h.localize(w, r, products...)
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// SuggestProducts returns product title completions for the prefix q
+func (h *ProductHandler) SuggestProducts(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("q")
+	if prefix == "" {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "Query parameter q is required")
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 10, MaxLimit: 20})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	suggestions, err := h.searchService.Suggest(r.Context(), prefix, params.Limit)
+	if err != nil {
+		log.Error().Err(err).Str("prefix", prefix).Msg("Suggest failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Suggest failed")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"suggestions": suggestions})
+}
+
+// SemanticSearch searches products by the meaning of the query
+func (h *ProductHandler) SemanticSearch(w http.ResponseWriter, r *http.Request) {
+	var input models.SemanticSearchInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	if input.Limit == 0 {
+		input.Limit = 10
+	}
+
+	result, err := h.searchService.Semantic(r.Context(), services.SemanticSearchParams{
+		Query:      input.Query,
+		CategoryID: input.CategoryID,
+		Limit:      input.Limit,
+		Offset:     input.Offset,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("query", input.Query).Msg("Semantic search failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Search failed")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// GetSearchAnalytics returns the top queries and top zero-result queries. The
+// period defaults to the last 7 days and can be set with ?since=<RFC3339>.
+func (h *ProductHandler) GetSearchAnalytics(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().AddDate(0, 0, -7)
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		parsed, err := utils.ParseTime(raw)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "since must be an RFC3339 timestamp with an offset")
+			return
+		}
+		since = parsed
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	analytics, err := h.searchService.Analytics(r.Context(), since, params.Limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get search analytics")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get search analytics")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, analytics)
+}
+
+// GetProducts lists active products, filtered by category, condition, tags
+// and price range, or given as comma-separated ?ids=, or the products changed
+// since ?updatedSince= for a delta sync
+func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("ids") {
+		h.getProductsByIDs(w, r)
+		return
+	}
+	if q := r.URL.Query(); q.Has("updatedSince") || q.Has("cursor") {
+		h.getProductChanges(w, r)
+		return
+	}
+	filter, ok := productFilter(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := h.productService.List(r.Context(), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	h.localize(w, r, page.Products...)
+	utils.Respond(w, r, http.StatusOK, page)
+}
+
+// maxProductIDs bounds how many products can be fetched by ID at once
+const maxProductIDs = 100
+
+// productIDsParam parses the comma-separated product ids query parameter,
+// dropping repeats, and responds with an error if it is invalid
+func productIDsParam(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "ids must be valid product ids")
+			return nil, false
+		}
+		// Canonical, so ids match the keys of the products found
+		if id = parsed.String(); seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) > maxProductIDs {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error",
+			"ids must list at most "+strconv.Itoa(maxProductIDs)+" products")
+		return nil, false
+	}
+	return ids, true
+}
+
+// getProductsByIDs returns the active products with the comma-separated ids,
+// in the order given. Products that don't exist are left out.
+func (h *ProductHandler) getProductsByIDs(w http.ResponseWriter, r *http.Request) {
+	ids, ok := productIDsParam(w, r)
+	if !ok {
+		return
+	}
+	if len(ids) == 0 {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error",
+			"ids must list between 1 and "+strconv.Itoa(maxProductIDs)+" products")
+		return
+	}
+
+	products, err := h.productService.GetByIDs(r.Context(), ids)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	page := &models.ProductPage{Products: make([]*models.Product, 0, len(products))}
+	for _, id := range ids {
+		if product, ok := products[id]; ok {
+			page.Products = append(page.Products, product)
+		}
+	}
+	page.Total = len(page.Products)
+	utils.Respond(w, r, http.StatusOK, page)
+}
+
+// getProductChanges streams the products changed after ?cursor=, or after
+// ?updatedSince= to start a sync, as a JSON array with tombstones for the
+// products no longer listed. Memory stays flat however many changed; a sync
+// that fails midway is cut off, and resumes from its last change's cursor.
+func (h *ProductHandler) getProductChanges(w http.ResponseWriter, r *http.Request) {
+	filter := models.ProductChangeFilter{Cursor: r.URL.Query().Get("cursor")}
+	if filter.Cursor == "" {
+		var err error
+		if filter.Since, err = utils.ParseTime(r.URL.Query().Get("updatedSince")); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "updatedSince must be an RFC3339 timestamp with an offset")
+			return
+		}
+	}
+
+	rows, err := h.productService.Changes(r.Context(), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	defer rows.Close()
+	if err := utils.StreamJSONArray(w, rows, h.productService.ScanChange(r.Context())); err != nil {
+		h.respondError(w, err)
+	}
+}
+
+// GetCollection lists the active products with a tag, taking the same
+// filters as GetProducts
+func (h *ProductHandler) GetCollection(w http.ResponseWriter, r *http.Request) {
+	filter, ok := productFilter(w, r)
+	if !ok {
+		return
+	}
+
+	collection, err := h.productService.Collection(r.Context(), chi.URLParam(r, "tag"), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	h.localize(w, r, collection.Products...)
+	utils.Respond(w, r, http.StatusOK, collection)
+}
+
+// maxCompareProducts bounds how many products can be compared at once
+const maxCompareProducts = 5
+
+// Compare returns 2 to maxCompareProducts products, given as comma-separated
+// ids, side by side. Products that don't exist are listed in notFound
+// rather than failing the comparison.
+func (h *ProductHandler) Compare(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[strings.ToLower(id)] {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "ids must be valid product ids")
+			return
+		}
+		seen[strings.ToLower(id)] = true
+		ids = append(ids, id)
+	}
+	if len(ids) < 2 || len(ids) > maxCompareProducts {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error",
+			"ids must list between 2 and "+strconv.Itoa(maxCompareProducts)+" products")
+		return
+	}
+
+	comparison, err := h.productService.Compare(r.Context(), ids)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, comparison)
+}
+
+// GetAvailability returns the availability of up to maxProductIDs products
+// given in the body, keyed by id. Unknown ids are left out.
+func (h *ProductHandler) GetAvailability(w http.ResponseWriter, r *http.Request) {
+	var input models.AvailabilityInput
+	if err := utils.DecodeJSONLimited(r, &input, "ids", maxProductIDs); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	availability, err := h.productService.Availability(r.Context(), input.IDs)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"products": availability})
+}
+
+// trendingWindowNames lists services.TrendingWindows for error messages
+const trendingWindowNames = "1h 6h 24h 7d"
+
+// GetTrending returns the in-stock products most viewed in the window given
+// by ?window=, 24h by default
+func (h *ProductHandler) GetTrending(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "24h"
+	}
+	if _, ok := services.TrendingWindows[window]; !ok {
+		utils.RespondValidationError(w, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "window", Code: "oneof", Param: trendingWindowNames,
+			Message: "window must be one of: " + trendingWindowNames,
+		}}})
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 20, MaxLimit: 50})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	trending, err := h.productService.Trending(r.Context(), window, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, trending)
+}
+
+// GetFeatured returns the products in the featured slot now (?limit=,
+// default 8, max 24)
+func (h *ProductHandler) GetFeatured(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 8, MaxLimit: 24})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	featured, err := h.productService.Featured(r.Context(), params.Limit)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, featured)
+}
+
+// ListFeatured returns a page of the products eligible for the featured
+// slot, with their weights
+func (h *ProductHandler) ListFeatured(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.productService.ListFeatured(r.Context(), params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// SetFeatured makes a product eligible for the featured slot or not, and
+// sets its weight there
+func (h *ProductHandler) SetFeatured(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.FeaturedInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	product, err := h.productService.SetFeatured(r.Context(), id, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// TagProducts attaches and detaches tags on many products
+func (h *ProductHandler) TagProducts(w http.ResponseWriter, r *http.Request) {
+	var input models.ProductTagsInput
+	if err := utils.DecodeJSONLimited(r, &input, "productIds", h.bulk.ProductItems); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	result, err := h.productService.TagProducts(r.Context(), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// CategorizeProducts adds and removes categories on many products
+func (h *ProductHandler) CategorizeProducts(w http.ResponseWriter, r *http.Request) {
+	var input models.ProductCategoriesInput
+	if err := utils.DecodeJSONLimited(r, &input, "productIds", h.bulk.ProductItems); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	result, err := h.productService.CategorizeProducts(r.Context(), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// RecategorizeProducts moves many products to another category
+func (h *ProductHandler) RecategorizeProducts(w http.ResponseWriter, r *http.Request) {
+	var input models.RecategorizeInput
+	if err := utils.DecodeJSONLimited(r, &input, "productIds", h.bulk.ProductItems); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := h.productService.Recategorize(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// MergeProducts merges a duplicate product into the one it duplicates
+func (h *ProductHandler) MergeProducts(w http.ResponseWriter, r *http.Request) {
+	var input models.ProductMergeInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	merge, err := h.productService.Merge(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, merge)
+}
+
+// GetProductMerge returns a product merge
+func (h *ProductHandler) GetProductMerge(w http.ResponseWriter, r *http.Request) {
+	id, ok := productMergeID(w, r)
+	if !ok {
+		return
+	}
+	merge, err := h.productService.GetMerge(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, merge)
+}
+
+// RevertProductMerge undoes a product merge within its undo window
+func (h *ProductHandler) RevertProductMerge(w http.ResponseWriter, r *http.Request) {
+	id, ok := productMergeID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	merge, err := h.productService.RevertMerge(ctx, middleware.UserIDFromContext(ctx), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, merge)
+}
+
+// productMergeID reads the product merge ID URL parameter, responding 404
+// when it is not a valid ID
+func productMergeID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Product merge not found")
+		return "", false
+	}
+	return id, true
+}
+
+// maxTagFilters bounds the tags a listing can be filtered by
+const maxTagFilters = 10
+
+// productFilter reads the listing query parameters, responding 400 when one
+// is invalid
+func productFilter(w http.ResponseWriter, r *http.Request) (models.ProductFilter, bool) {
+	q := r.URL.Query()
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return models.ProductFilter{}, false
+	}
+	near, ok := geoFilterParam(w, r)
+	if !ok {
+		return models.ProductFilter{}, false
+	}
+	filter := models.ProductFilter{
+		Near:       near,
+		CategoryID: q.Get("category"),
+		Condition:  q.Get("condition"),
+		Currency:   strings.ToUpper(q.Get("currency")),
+		Sort:       params.Sort,
+		Locale:     q.Get("locale"),
+		Limit:      params.Limit,
+		Offset:     params.Offset,
+	}
+	if filter.CategoryID != "" {
+		if _, err := uuid.Parse(filter.CategoryID); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "category must be a valid id")
+			return models.ProductFilter{}, false
+		}
+	}
+	if filter.Currency != "" && !money.ValidCurrency(filter.Currency) {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "currency must be a supported currency code")
+		return models.ProductFilter{}, false
+	}
+	if v := q.Get("tags"); v != "" {
+		filter.Tags = strings.Split(v, ",")
+		if len(filter.Tags) > maxTagFilters {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "tags allows at most "+strconv.Itoa(maxTagFilters)+" tags")
+			return models.ProductFilter{}, false
+		}
+	}
+	if v := q.Get("allergenFree"); v != "" {
+		for _, allergen := range strings.Split(v, ",") {
+			allergen = strings.ToLower(strings.TrimSpace(allergen))
+			if !slices.Contains(models.Allergens, allergen) {
+				utils.RespondError(w, http.StatusBadRequest, "validation_error",
+					"allergenFree must list allergens among: "+strings.Join(models.Allergens, ", "))
+				return models.ProductFilter{}, false
+			}
+			filter.AllergenFree = append(filter.AllergenFree, allergen)
+		}
+	}
+	for name, dest := range map[string]*float64{"minPrice": &filter.MinPrice, "maxPrice": &filter.MaxPrice, "maxCalories": &filter.MaxCalories} {
+		if v := q.Get(name); v != "" {
+			price, err := strconv.ParseFloat(v, 64)
+			if err != nil || price < 0 {
+				utils.RespondError(w, http.StatusBadRequest, "validation_error", name+" must be a non-negative number")
+				return models.ProductFilter{}, false
+			}
+			*dest = price
+		}
+	}
+	return filter, true
+}
+
+// Listings near a location take in sellers within radiusKm of it, by
+// default defaultRadiusKm and at most maxRadiusKm
+const (
+	defaultRadiusKm = 25
+	maxRadiusKm     = 100
+)
+
+// geoFilterParam parses the lat, lng and radiusKm query parameters,
+// returning nil when no location is given, and responds with an error if
+// they are invalid
+func geoFilterParam(w http.ResponseWriter, r *http.Request) (*models.GeoFilter, bool) {
+	q := r.URL.Query()
+	if !q.Has("lat") && !q.Has("lng") && !q.Has("radiusKm") {
+		return nil, true
+	}
+	near := &models.GeoFilter{RadiusKm: defaultRadiusKm}
+	for _, p := range []struct {
+		name     string
+		dest     *float64
+		min, max float64
+	}{
+		{"lat", &near.Latitude, -90, 90},
+		{"lng", &near.Longitude, -180, 180},
+	} {
+		v, err := strconv.ParseFloat(q.Get(p.name), 64)
+		if err != nil || v < p.min || v > p.max {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error",
+				fmt.Sprintf("%s must be a number between %g and %g", p.name, p.min, p.max))
+			return nil, false
+		}
+		*p.dest = v
+	}
+	if v := q.Get("radiusKm"); v != "" {
+		radius, err := strconv.ParseFloat(v, 64)
+		if err != nil || radius <= 0 || radius > maxRadiusKm {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error",
+				"radiusKm must be a number above 0 and at most "+strconv.Itoa(maxRadiusKm))
+			return nil, false
+		}
+		near.RadiusKm = radius
+	}
+	return near, true
+}
+
+// GetCategories lists the active product categories
+func (h *ProductHandler) GetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.productService.Categories(r.Context())
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"categories": categories})
+}
+
+// GetCategoryTree returns the active product categories as a tree
+func (h *ProductHandler) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.productService.CategoryTree(r.Context())
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"categories": tree})
+}
+
+// GetCategoryFilters returns the brands, prices and attribute values a
+// category's products can be filtered by
+func (h *ProductHandler) GetCategoryFilters(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Category not found")
+		return
+	}
+	filters, err := h.productService.CategoryFilters(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, filters)
+}
+
+// ReorderCategories sets the display order of categories sharing a parent
+func (h *ProductHandler) ReorderCategories(w http.ResponseWriter, r *http.Request) {
+	var input models.CategoryReorderInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	categories, err := h.productService.ReorderCategories(r.Context(), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"categories": categories})
+}
+
+// CreateProduct creates a product listed by the authenticated seller
+func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	var input models.ProductInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	userID := middleware.UserIDFromContext(r.Context())
+	product, err := h.productService.Create(r.Context(), userID, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	// The product is created even when none of its image URLs can be imported
+	if len(input.ImageURLs) > 0 {
+		imported, err := h.imageImporter.Import(r.Context(), product.ID, userID, false, input.ImageURLs)
+		if err != nil {
+			log.Error().Err(err).Str("product_id", product.ID).Msg("Failed to import product images")
+			for _, url := range input.ImageURLs {
+				product.ImageErrors = append(product.ImageErrors, models.ImageError{
+					URL: url, Code: "fetch_failed", Message: "image could not be imported",
+				})
+			}
+		} else {
+			product = imported
+		}
+	}
+	utils.RespondJSON(w, http.StatusCreated, product)
+}
+
+// GetProduct returns a single product
+func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	userID := middleware.UserIDFromContext(ctx)
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.GetAs(ctx, id, userID, isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	h.productService.RecordView(ctx, product, userID, r.UserAgent())
+	h.cartService.Availability(ctx, product, userID)
+	h.productService.SellerOpen(ctx, product)
+	h.productService.TrackRecentlyViewed(ctx, userID, product.ID)
+	h.localize(w, r, product)
+	w.Header().Set("Content-Language", product.Locale)
+	utils.Respond(w, r, http.StatusOK, product)
+}
+
+// GetProductBySlug gets product details like GetProduct, by the product's
+// slug. A slug it had before redirects to its current one.
+func (h *ProductHandler) GetProductBySlug(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := middleware.UserIDFromContext(ctx)
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.GetBySlug(ctx, chi.URLParam(r, "slug"), userID, isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	h.productService.RecordView(ctx, product, userID, r.UserAgent())
+	h.cartService.Availability(ctx, product, userID)
+	h.productService.SellerOpen(ctx, product)
+	h.productService.TrackRecentlyViewed(ctx, userID, product.ID)
+	h.localize(w, r, product)
+	w.Header().Set("Content-Language", product.Locale)
+	utils.Respond(w, r, http.StatusOK, product)
+}
+
+// GetProductTranslations returns a product's translations, to its seller or
+// an admin
+func (h *ProductHandler) GetProductTranslations(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	translations, err := h.productService.Translations(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, translations)
+}
+
+// PutProductTranslation sets a product's title and description in a locale
+func (h *ProductHandler) PutProductTranslation(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.ProductTranslationInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	input.Locale = chi.URLParam(r, "locale")
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	translation, err := h.productService.PutTranslation(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, translation)
+}
+
+// DeleteProductTranslation removes a product's translation into a locale
+func (h *ProductHandler) DeleteProductTranslation(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	if err := h.productService.DeleteTranslation(ctx, id, chi.URLParam(r, "locale"), middleware.UserIDFromContext(ctx), isAdmin); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// localize translates products into the languages r prefers. Products it
+// fails to translate are shown in the default locale, as they are stored.
+func (h *ProductHandler) localize(w http.ResponseWriter, r *http.Request, products ...*models.Product) {
+	w.Header().Add("Vary", "Accept-Language")
+	if err := h.productService.Localize(r.Context(), requestLocales(r), products...); err != nil {
+		log.Warn().Err(err).Msg("Failed to localize products")
+	}
+}
+
+// GetProductNutrition returns a product's nutrition facts
+func (h *ProductHandler) GetProductNutrition(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	nutrition, err := h.productService.Nutrition(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.Respond(w, r, http.StatusOK, nutrition)
+}
+
+// GetSimilarProducts returns the listed products most like a product
+func (h *ProductHandler) GetSimilarProducts(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 10, MaxLimit: 50})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	similar, err := h.productService.Similar(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, params.Limit)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, similar)
+}
+
+// GetRecentlyViewed returns the signed-in user's recently viewed products.
+// Guests pass the products they viewed, most recent first, as ids.
+func (h *ProductHandler) GetRecentlyViewed(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserIDFromContext(r.Context())
+	var ids []string
+	if userID == "" {
+		var ok bool
+		if ids, ok = productIDsParam(w, r); !ok {
+			return
+		}
+	}
+
+	recent, err := h.productService.RecentlyViewed(r.Context(), userID, ids)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, recent)
+}
+
+// UpdateProduct replaces a product's details
+func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.ProductInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.Update(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// PatchProduct changes only the fields of a product given in the request
+func (h *ProductHandler) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var patch models.ProductPatch
+	if err := utils.DecodeJSON(r, &patch); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.Patch(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, patch)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// SetSale schedules a sale of a product, replacing any it had
+func (h *ProductHandler) SetSale(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.SaleInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.SetSale(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// SetPriceTiers replaces a product's quantity price tiers
+func (h *ProductHandler) SetPriceTiers(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.PriceTiersInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.SetPriceTiers(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// ClearSale cancels a product's sale
+func (h *ProductHandler) ClearSale(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.ClearSale(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// RepriceProducts changes the prices of the authenticated seller's products
+// in bulk, or previews the change with dryRun
+func (h *ProductHandler) RepriceProducts(w http.ResponseWriter, r *http.Request) {
+	var input models.RepriceInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := h.productService.Reprice(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// GetPriceHistory returns a page of a listed product's recent price changes,
+// newest first
+func (h *ProductHandler) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	page, err := h.productService.PublicPriceHistory(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// GetSellerPriceHistory returns a page of the authenticated seller's
+// product's whole price history, newest first
+func (h *ProductHandler) GetSellerPriceHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	ctx := r.Context()
+	page, err := h.productService.SellerPriceHistory(ctx, id, middleware.UserIDFromContext(ctx), params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// DeleteProduct removes a product listing
+func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	if err := h.productService.Delete(ctx, id, middleware.UserIDFromContext(ctx), isAdmin); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PublishProduct lists a draft or archived product once it is complete
+func (h *ProductHandler) PublishProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.Publish(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// ArchiveProduct unlists a product until it is published again
+func (h *ProductHandler) ArchiveProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	product, err := h.productService.Archive(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, product)
+}
+
+// AddWishlistToCart adds the in-stock items of the user's wishlist to their
+// cart. With ?clear=true the added items are removed from the wishlist.
+func (h *ProductHandler) AddWishlistToCart(w http.ResponseWriter, r *http.Request) {
+	clear := false
+	if v := r.URL.Query().Get("clear"); v != "" {
+		var err error
+		if clear, err = strconv.ParseBool(v); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "clear must be true or false")
+			return
+		}
+	}
+
+	move, err := h.cartService.AddWishlist(r.Context(), middleware.UserIDFromContext(r.Context()), clear)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, move)
+}
+
+// GetWishlist returns the user's wishlist, newest item first, with how each
+// item's price moved since it was added
+func (h *ProductHandler) GetWishlist(w http.ResponseWriter, r *http.Request) {
+	wishlist, err := h.cartService.Wishlist(r.Context(), middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, wishlist)
+}
+
+// AddToWishlist adds a product to the user's wishlist, answering 201 with
+// the item when it is added and 200 when it was already there
+func (h *ProductHandler) AddToWishlist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Product not found")
+		return
+	}
+
+	ctx := r.Context()
+	userID := middleware.UserIDFromContext(ctx)
+	result, err := h.cartService.AddWishlistItems(ctx, userID, models.WishlistBulkInput{
+		Items: []models.WishlistItemInput{{ProductID: id}},
+	})
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	if len(result.NotFound) > 0 {
+		h.respondError(w, services.ErrProductNotFound)
+		return
+	}
+	item, err := h.cartService.WishlistItem(ctx, userID, id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if len(result.Added) > 0 {
+		status = http.StatusCreated
+	}
+	utils.RespondJSON(w, status, item)
+}
+
+// AddWishlistItems adds many products to the user's wishlist at once, with
+// optional target prices
+func (h *ProductHandler) AddWishlistItems(w http.ResponseWriter, r *http.Request) {
+	var input models.WishlistBulkInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	result, err := h.cartService.AddWishlistItems(r.Context(), middleware.UserIDFromContext(r.Context()), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// wishlistExportHeader is the header row of a CSV wishlist export
+var wishlistExportHeader = []string{"product_id", "name", "price", "currency", "target_price", "status", "product_url", "added_at"}
+
+// ExportWishlist streams the user's wishlist as ?format=json (the default)
+// or csv for download, oldest item first, including items out of stock or
+// no longer available with their status
+func (h *ProductHandler) ExportWishlist(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "format must be csv or json")
+		return
+	}
+
+	rows, err := h.cartService.ExportWishlist(r.Context(), middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	defer rows.Close()
+	w.Header().Set("Content-Disposition", `attachment; filename="wishlist.`+format+`"`)
+
+	if format == "json" {
+		err = utils.StreamJSONArray(w, rows, services.ScanWishlistExportItem)
+	} else {
+		err = utils.StreamCSV(w, wishlistExportHeader, rows, func(rows *sql.Rows) ([]string, error) {
+			item, err := services.ScanWishlistExportItem(rows)
+			if err != nil {
+				return nil, err
+			}
+			targetPrice := ""
+			if item.TargetPrice != nil {
+				targetPrice = item.TargetPrice.Decimal()
+			}
+			return []string{item.ProductID, item.Name, item.Price.Decimal(), item.Price.Currency, targetPrice,
+				item.Status, item.ProductURL, item.AddedAt.Format(time.RFC3339)}, nil
+		})
+	}
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		h.respondError(w, err)
+	}
+}
+
+// productID reads the product ID URL parameter, responding 404 when it is
+// not a valid ID
+func productID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Product not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *ProductHandler) respondError(w http.ResponseWriter, err error) {
+	// Old links to a merged duplicate lead to the product it was merged into
+	var merged *services.ProductMergedError
+	if errors.As(err, &merged) {
+		w.Header().Set("Location", "/api/v1/products/"+merged.TargetID)
+		utils.RespondErrorWithDetails(w, http.StatusMovedPermanently, "product_merged", "Product was merged into another",
+			map[string]string{"targetId": merged.TargetID})
+		return
+	}
+	// Links to a slug the product had before lead to its current one
+	var moved *services.ProductSlugMovedError
+	if errors.As(err, &moved) {
+		w.Header().Set("Location", "/api/v1/products/slug/"+moved.Slug)
+		utils.RespondErrorWithDetails(w, http.StatusMovedPermanently, "product_slug_moved", "Product has a new slug",
+			map[string]string{"slug": moved.Slug})
+		return
+	}
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	var notVerified *services.SellerNotVerifiedError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.As(err, &notVerified):
+		utils.RespondErrorWithDetails(w, http.StatusForbidden, "seller_not_verified", notVerified.Message(),
+			map[string]string{"sellerStatus": notVerified.Status})
+	case errors.Is(err, services.ErrProductForbidden):
+		utils.RespondError(w, http.StatusForbidden, "forbidden", "You cannot modify this product")
+	case errors.Is(err, services.ErrProductVersionConflict):
+		utils.RespondError(w, http.StatusConflict, "version_conflict", "Product has changed since it was read")
+	case errors.Is(err, services.ErrProductMergeReverted):
+		utils.RespondError(w, http.StatusConflict, "merge_reverted", "Product merge has already been reverted")
+	case errors.Is(err, services.ErrProductMergeExpired):
+		utils.RespondError(w, http.StatusConflict, "undo_window_closed", "Product merge can no longer be reverted")
+	case errors.Is(err, services.ErrDuplicateSKU):
+		utils.RespondError(w, http.StatusConflict, "duplicate_sku", err.Error())
+	case errors.Is(err, services.ErrDuplicateSlug):
+		utils.RespondError(w, http.StatusConflict, "duplicate_slug", err.Error())
+	case errors.Is(err, services.ErrDuplicateCoupon):
+		utils.RespondError(w, http.StatusConflict, "duplicate_coupon", err.Error())
+	case errors.Is(err, services.ErrInvalidProductPatch):
+		utils.RespondError(w, http.StatusBadRequest, "invalid_request", err.Error())
+	case errors.Is(err, services.ErrInvalidProductFilter):
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", err.Error())
+	case errors.Is(err, services.ErrInsufficientStock):
+		utils.RespondError(w, http.StatusConflict, "insufficient_stock", err.Error())
+	default:
+		log.Error().Err(err).Msg("Product operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Product operation failed")
+	}
+}
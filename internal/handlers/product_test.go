@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// productRouter routes GET /products/{id} to h.GetProduct
+func productRouter(h *ProductHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/products/{id}", h.GetProduct)
+	return r
+}
+
+func TestGetProductMalformedID(t *testing.T) {
+	rec := httptest.NewRecorder()
+	productRouter(&ProductHandler{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/products/not-a-uuid", nil))
+	assertErrorEnvelope(t, rec, http.StatusNotFound, "not_found", "Product not found")
+}
+
+// A product that doesn't exist is a 404, found by the service rather than
+// the ID check, against the migrated database TEST_DATABASE_URL names
+func TestGetMissingProduct(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	products := services.NewProductService(&database.PostgresDB{DB: db}, nil, nil, nil, config.ViewConfig{}, config.PricingConfig{})
+	h := &ProductHandler{productService: products}
+
+	rec := httptest.NewRecorder()
+	productRouter(h).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/products/"+uuid.NewString(), nil))
+	assertErrorEnvelope(t, rec, http.StatusNotFound, "not_found", "Product not found")
+}
+
+func TestTimeParamsNeedOffset(t *testing.T) {
+	h := &ProductHandler{}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"search analytics since", h.GetSearchAnalytics, "/admin/search/analytics?since=2026-03-01T10:00:00"},
+		{"search analytics since date", h.GetSearchAnalytics, "/admin/search/analytics?since=2026-03-01"},
+		{"product changes since", h.getProductChanges, "/products?updatedSince=2026-03-01T10:00:00"},
+		{"product changes since with a space", h.getProductChanges, "/products?updatedSince=2026-03-01+10:00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			var body utils.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode error envelope: %v", err)
+			}
+			if body.Error.Code != "validation_error" {
+				t.Errorf("error code = %q, want validation_error", body.Error.Code)
+			}
+		})
+	}
+}
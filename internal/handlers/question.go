@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// QuestionHandler handles product question and answer requests
+type QuestionHandler struct {
+	questionService *services.QuestionService
+}
+
+// NewQuestionHandler creates a new question handler
+func NewQuestionHandler(questionService *services.QuestionService) *QuestionHandler {
+	return &QuestionHandler{questionService: questionService}
+}
+
+// AskQuestion adds the authenticated user's question about a product
+func (h *QuestionHandler) AskQuestion(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.QuestionInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	question, err := h.questionService.Ask(r.Context(), id, middleware.UserIDFromContext(r.Context()), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, question)
+}
+
+// GetQuestions returns a page of a product's questions with their answers,
+// newest first
+func (h *QuestionHandler) GetQuestions(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.questionService.List(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// AnswerQuestion adds the authenticated user's answer to a question
+func (h *QuestionHandler) AnswerQuestion(w http.ResponseWriter, r *http.Request) {
+	id, ok := questionID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.QuestionInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	answer, err := h.questionService.Answer(r.Context(), id, middleware.UserIDFromContext(r.Context()), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, answer)
+}
+
+// VoteAnswerHelpful records the authenticated user finding an answer helpful
+func (h *QuestionHandler) VoteAnswerHelpful(w http.ResponseWriter, r *http.Request) {
+	id, ok := answerID(w, r)
+	if !ok {
+		return
+	}
+
+	answer, err := h.questionService.VoteHelpful(r.Context(), id, middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, answer)
+}
+
+// questionID reads the question ID URL parameter, responding 404 when it is
+// not a valid ID
+func questionID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Question not found")
+		return "", false
+	}
+	return id, true
+}
+
+// answerID reads the answer ID URL parameter, responding 404 when it is not
+// a valid ID
+func answerID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Answer not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *QuestionHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrQuestionOwnProduct), errors.Is(err, services.ErrAnswerNotAllowed),
+		errors.Is(err, services.ErrAnswerOwnVote):
+		utils.RespondError(w, http.StatusForbidden, "forbidden", err.Error())
+	default:
+		log.Error().Err(err).Msg("Question operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Question operation failed")
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// QuotaHandler handles usage quota requests
+type QuotaHandler struct {
+	quotaService *services.QuotaService
+}
+
+// NewQuotaHandler creates a new quota handler
+func NewQuotaHandler(quotaService *services.QuotaService) *QuotaHandler {
+	return &QuotaHandler{quotaService: quotaService}
+}
+
+// GetQuota returns the authenticated user's quota usage
+func (h *QuotaHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	quotas, err := h.quotaService.Quotas(ctx, middleware.UserIDFromContext(ctx), middleware.RoleFromContext(ctx) == middleware.RoleAdmin)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get quotas")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get quotas")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"quotas": quotas})
+}
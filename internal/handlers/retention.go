@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// RetentionHandler handles admin runs of the retention purge
+type RetentionHandler struct {
+	retentionService *services.RetentionService
+	dryRun           bool
+}
+
+// NewRetentionHandler creates a new retention handler. Runs are dry runs
+// unless asked otherwise when dryRun is set.
+func NewRetentionHandler(retentionService *services.RetentionService, dryRun bool) *RetentionHandler {
+	return &RetentionHandler{retentionService: retentionService, dryRun: dryRun}
+}
+
+// Purge runs the retention purge now, answering with what it purged, or
+// would have with ?dryRun=true
+func (h *RetentionHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	dryRun := h.dryRun
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", "dryRun must be true or false")
+			return
+		}
+		dryRun = parsed
+	}
+
+	report, err := h.retentionService.Purge(r.Context(), dryRun)
+	switch {
+	case errors.Is(err, services.ErrPurgeRunning):
+		utils.RespondError(w, http.StatusConflict, "purge_running", "A purge is already running")
+	case err != nil:
+		log.Error().Err(err).Msg("Retention purge failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Retention purge failed")
+	default:
+		utils.RespondJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ReviewHandler handles product review requests
+type ReviewHandler struct {
+	reviewService *services.ReviewService
+	bulk          config.BulkConfig
+}
+
+// NewReviewHandler creates a new review handler; bulk limits the reviews of
+// an import
+func NewReviewHandler(reviewService *services.ReviewService, bulk config.BulkConfig) *ReviewHandler {
+	return &ReviewHandler{reviewService: reviewService, bulk: bulk}
+}
+
+// CreateReview adds the authenticated user's review of a product
+func (h *ReviewHandler) CreateReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.ReviewInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	review, err := h.reviewService.Create(ctx, id, middleware.UserIDFromContext(ctx), middleware.RoleFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, review)
+}
+
+// UpdateReview replaces the rating and text of the authenticated user's review
+func (h *ReviewHandler) UpdateReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.ReviewInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	review, err := h.reviewService.Update(ctx, id, middleware.UserIDFromContext(ctx), middleware.RoleFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, review)
+}
+
+// GetReviews returns a page of a product's reviews, newest first
+func (h *ReviewHandler) GetReviews(w http.ResponseWriter, r *http.Request) {
+	id, ok := productID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.reviewService.List(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// ReplyToReview sets the authenticated seller's reply to a review of their
+// product, answering 201 for the first reply and 200 when it is edited
+func (h *ReviewHandler) ReplyToReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.ReviewReplyInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	reply, created, err := h.reviewService.Reply(r.Context(), id, middleware.UserIDFromContext(r.Context()), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	utils.RespondJSON(w, status, reply)
+}
+
+// DeleteReview hides a review until its author restores it
+func (h *ReviewHandler) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	if err := h.reviewService.Delete(ctx, id, middleware.UserIDFromContext(ctx), isAdmin); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreReview shows a review its author deleted again
+func (h *ReviewHandler) RestoreReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+
+	review, err := h.reviewService.Restore(r.Context(), id, middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, review)
+}
+
+// HardDeleteReview permanently removes a review
+func (h *ReviewHandler) HardDeleteReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.reviewService.HardDelete(r.Context(), id); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ImportReviews stages reviews imported from another platform for
+// moderation
+func (h *ReviewHandler) ImportReviews(w http.ResponseWriter, r *http.Request) {
+	var input models.ReviewImportInput
+	if err := utils.DecodeJSONLimited(r, &input, "reviews", h.bulk.ReviewImportItems); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	result, err := h.reviewService.ImportReviews(r.Context(), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// ListImportedReviews returns a page of the imported reviews awaiting
+// moderation, oldest first, filtered by source, rating (comma-separated)
+// and keyword
+func (h *ReviewHandler) ListImportedReviews(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Limit: 50, MaxLimit: 200})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	filter := models.ReviewImportFilter{Source: q.Get("source"), Keyword: q.Get("q")}
+	if v := q.Get("rating"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			rating, err := strconv.Atoi(s)
+			if err != nil || rating < 1 || rating > 5 {
+				utils.RespondError(w, http.StatusBadRequest, "validation_error", "rating must be ratings from 1 to 5, separated by commas")
+				return
+			}
+			filter.Ratings = append(filter.Ratings, rating)
+		}
+	}
+	if err := validators.Validate(filter); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.reviewService.ListImported(r.Context(), filter, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// ModerateImportedReviews approves or rejects imported reviews, by ID or by
+// filter
+func (h *ReviewHandler) ModerateImportedReviews(w http.ResponseWriter, r *http.Request) {
+	var input models.ReviewModerationInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	result, err := h.reviewService.ModerateImported(r.Context(), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// reviewID reads the review ID URL parameter, responding 404 when it is not
+// a valid ID
+func reviewID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Review not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *ReviewHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	var cooldown *services.ReviewCooldownError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.As(err, &cooldown):
+		seconds := int((cooldown.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		utils.RespondError(w, http.StatusTooManyRequests, "edit_cooldown",
+			"This review was edited recently, please wait "+strconv.Itoa(seconds)+" seconds before editing it again")
+	case errors.Is(err, services.ErrReviewForbidden):
+		utils.RespondError(w, http.StatusForbidden, "forbidden", "You cannot modify this review")
+	case errors.Is(err, services.ErrReviewOwnProduct), errors.Is(err, services.ErrReviewReplyForbidden):
+		utils.RespondError(w, http.StatusForbidden, "forbidden", err.Error())
+	case errors.Is(err, services.ErrReviewPurchaseRequired):
+		utils.RespondError(w, http.StatusForbidden, "review_not_allowed", "Only buyers with a delivered order of this product can review it")
+	case errors.Is(err, services.ErrReviewAccountTooNew):
+		utils.RespondError(w, http.StatusForbidden, "review_not_allowed", "Your account is too new to review products you haven't bought")
+	case errors.Is(err, services.ErrReviewRestoreExpired):
+		utils.RespondError(w, http.StatusConflict, "restore_window_expired", "The review was deleted too long ago to restore")
+	default:
+		log.Error().Err(err).Msg("Review operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Review operation failed")
+	}
+}
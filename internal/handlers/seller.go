@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// SellerHandler handles admin review of sellers and shoppers' follows of
+// them
+type SellerHandler struct {
+	sellerService *services.SellerService
+}
+
+// NewSellerHandler creates a new seller handler
+func NewSellerHandler(sellerService *services.SellerService) *SellerHandler {
+	return &SellerHandler{sellerService: sellerService}
+}
+
+// ListSellers returns a page of sellers, optionally those with ?status=
+func (h *SellerHandler) ListSellers(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.SellerPending, models.SellerVerified, models.SellerSuspended:
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "status must be pending, verified or suspended")
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.sellerService.ListSellers(r.Context(), status, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// SetSellerStatus verifies, suspends or reinstates a seller
+func (h *SellerHandler) SetSellerStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := sellerID(w, r)
+	if !ok {
+		return
+	}
+	var input models.SellerStatusInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	seller, err := h.sellerService.SetSellerStatus(ctx, id, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, seller)
+}
+
+// GetSellerStatusHistory returns a page of a seller's status changes,
+// newest first
+func (h *SellerHandler) GetSellerStatusHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := sellerID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	page, err := h.sellerService.SellerStatusHistory(r.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// FollowSeller follows a seller, optionally with {"notify": false} to get
+// their new products in the feed without a notification for each
+func (h *SellerHandler) FollowSeller(w http.ResponseWriter, r *http.Request) {
+	id, ok := sellerID(w, r)
+	if !ok {
+		return
+	}
+	// The body is optional
+	var input models.SellerFollowInput
+	if err := utils.DecodeJSON(r, &input); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	follow, err := h.sellerService.Follow(ctx, middleware.UserIDFromContext(ctx), id, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, follow)
+}
+
+// UnfollowSeller stops following a seller
+func (h *SellerHandler) UnfollowSeller(w http.ResponseWriter, r *http.Request) {
+	id, ok := sellerID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	if err := h.sellerService.Unfollow(ctx, middleware.UserIDFromContext(ctx), id); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetFeed returns a page of the products of the sellers the user follows,
+// newest first, out of stock ones too with ?includeOutOfStock=true
+func (h *SellerHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{Cursor: true})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	filter := models.FeedFilter{
+		Cursor:            params.Cursor,
+		Limit:             params.Limit,
+		IncludeOutOfStock: r.URL.Query().Get("includeOutOfStock") == "true",
+	}
+
+	ctx := r.Context()
+	page, err := h.sellerService.Feed(ctx, middleware.UserIDFromContext(ctx), filter)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// GetSellerAvailability returns a seller's business hours and whether they
+// take orders now
+func (h *SellerHandler) GetSellerAvailability(w http.ResponseWriter, r *http.Request) {
+	id, ok := sellerID(w, r)
+	if !ok {
+		return
+	}
+	availability, err := h.sellerService.Availability(r.Context(), id)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, availability)
+}
+
+// GetAvailability returns the seller's own business hours and whether they
+// take orders now
+func (h *SellerHandler) GetAvailability(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	availability, err := h.sellerService.Availability(ctx, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, availability)
+}
+
+// SetAvailability replaces the seller's business hours and whether they
+// accept orders
+func (h *SellerHandler) SetAvailability(w http.ResponseWriter, r *http.Request) {
+	var input models.SellerAvailabilityInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	availability, err := h.sellerService.SetAvailability(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, availability)
+}
+
+// GetLocation returns where the authenticated seller is
+func (h *SellerHandler) GetLocation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	location, err := h.sellerService.Location(ctx, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, location)
+}
+
+// SetLocation sets where the authenticated seller is, so shoppers near them
+// find their products
+func (h *SellerHandler) SetLocation(w http.ResponseWriter, r *http.Request) {
+	var input models.SellerLocationInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	location, err := h.sellerService.SetLocation(ctx, middleware.UserIDFromContext(ctx), &input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, location)
+}
+
+// ClearLocation forgets where the authenticated seller is
+func (h *SellerHandler) ClearLocation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	location, err := h.sellerService.SetLocation(ctx, middleware.UserIDFromContext(ctx), nil)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, location)
+}
+
+// sellerID reads the seller ID URL parameter, responding 404 when it is not
+// a valid ID
+func sellerID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Seller not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *SellerHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	if errors.As(err, &verr) {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidProductFilter) {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	log.Error().Err(err).Msg("Seller operation failed")
+	utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Seller operation failed")
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/storage"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ShippingLabelHandler handles orders' shipping labels
+type ShippingLabelHandler struct {
+	labelService *services.ShippingLabelService
+	store        storage.BlobStore
+}
+
+// NewShippingLabelHandler creates a new shipping label handler serving
+// label files from store
+func NewShippingLabelHandler(labelService *services.ShippingLabelService, store storage.BlobStore) *ShippingLabelHandler {
+	return &ShippingLabelHandler{labelService: labelService, store: store}
+}
+
+// CreateLabel buys an order's shipping label for the parcel described, or
+// returns the one it has
+func (h *ShippingLabelHandler) CreateLabel(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+	var input models.ShippingLabelInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	label, bought, err := h.labelService.CreateLabel(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if bought {
+		status = http.StatusCreated
+	}
+	utils.RespondJSON(w, status, label)
+}
+
+// GetLabelFile streams an order's shipping label file to those who can
+// view the order
+func (h *ShippingLabelHandler) GetLabelFile(w http.ResponseWriter, r *http.Request) {
+	id, ok := orderID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	key, contentType, err := h.labelService.LabelFile(ctx, id, middleware.UserIDFromContext(ctx), middleware.IsStaff(ctx))
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	blob, err := h.store.Get(ctx, key, nil)
+	if errors.Is(err, storage.ErrNotFound) {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Shipping label not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Failed to get shipping label")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get shipping label")
+		return
+	}
+	defer blob.Body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, blob.Body); err != nil && ctx.Err() == nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to stream shipping label")
+	}
+}
+
+func (h *ShippingLabelHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	var carrierErr *services.CarrierError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	case errors.As(err, &carrierErr):
+		utils.RespondError(w, http.StatusUnprocessableEntity, carrierErr.Code, carrierErr.Message)
+	case errors.Is(err, services.ErrLabelsUnavailable):
+		utils.RespondError(w, http.StatusServiceUnavailable, "labels_unavailable", "Shipping labels are unavailable")
+	case errors.Is(err, services.ErrCarrierUnavailable):
+		log.Warn().Err(err).Msg("Shipping carrier unavailable")
+		utils.RespondError(w, http.StatusBadGateway, "carrier_unavailable", "The shipping carrier is unavailable, try again later")
+	case errors.Is(err, services.ErrOrderForbidden):
+		utils.RespondError(w, http.StatusForbidden, "forbidden", "Insufficient permissions")
+	case errors.Is(err, services.ErrOrderNotFulfillable):
+		utils.RespondError(w, http.StatusConflict, "not_fulfillable", "Order is not awaiting fulfillment")
+	case errors.Is(err, services.ErrOrderSeveralSellers):
+		utils.RespondError(w, http.StatusConflict, "several_sellers", "Order ships from several sellers")
+	default:
+		log.Error().Err(err).Msg("Shipping label operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Shipping label operation failed")
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// maxStatsWindow is the longest window marketplace stats are computed over
+const maxStatsWindow = 731 * 24 * time.Hour
+
+// StatsHandler handles the admin marketplace stats
+type StatsHandler struct {
+	statsService *services.StatsService
+}
+
+// NewStatsHandler creates a new stats handler
+func NewStatsHandler(statsService *services.StatsService) *StatsHandler {
+	return &StatsHandler{statsService: statsService}
+}
+
+// GetStats returns marketplace stats over ?from= to ?to= (RFC3339 times or
+// YYYY-MM-DD dates, the last 30 days by default), with the order series in
+// periods of ?granularity=day|week|month
+func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to := services.DefaultStatsWindow(time.Now())
+	params := services.StatsParams{From: from, To: to, Granularity: models.GranularityDay}
+	for name, dest := range map[string]*time.Time{"from": &params.From, "to": &params.To} {
+		raw := q.Get(name)
+		if raw == "" {
+			continue
+		}
+		parsed, ok := parseStatsTime(raw)
+		if !ok {
+			utils.RespondError(w, http.StatusBadRequest, "validation_error", name+" must be an RFC3339 timestamp or a YYYY-MM-DD date")
+			return
+		}
+		*dest = parsed
+	}
+	if q.Has("to") && !q.Has("from") {
+		params.From = params.To.AddDate(0, 0, -30)
+	}
+	if !params.To.After(params.From) {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "to must be after from")
+		return
+	}
+	if params.To.Sub(params.From) > maxStatsWindow {
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "the window must be at most two years")
+		return
+	}
+	switch granularity := q.Get("granularity"); granularity {
+	case "":
+	case models.GranularityDay, models.GranularityWeek, models.GranularityMonth:
+		params.Granularity = granularity
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "validation_error", "granularity must be day, week or month")
+		return
+	}
+
+	stats, err := h.statsService.Marketplace(r.Context(), params)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get marketplace stats")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get marketplace stats")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, stats)
+}
+
+// GetUserStats returns the authenticated user's order stats
+func (h *StatsHandler) GetUserStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	stats, err := h.statsService.Buyer(ctx, middleware.UserIDFromContext(ctx))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get user stats")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Failed to get user stats")
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, stats)
+}
+
+// parseStatsTime parses an RFC3339 time, or a date as its start in UTC
+func parseStatsTime(raw string) (time.Time, bool) {
+	if t, err := utils.ParseTime(raw); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
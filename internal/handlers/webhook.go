@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/middleware"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/services"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// WebhookHandler handles webhook subscription management for sellers and admins
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// ListWebhooks returns a page of the user's subscriptions, or everyone's for admins
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	page, err := h.webhookService.List(ctx, middleware.UserIDFromContext(ctx), isAdmin, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// GetPayloadVersions returns the payload versions subscriptions can register for
+func (h *WebhookHandler) GetPayloadVersions(w http.ResponseWriter, r *http.Request) {
+	utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"versions": services.WebhookPayloadVersions()})
+}
+
+// GetWebhook returns a subscription
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	sub, err := h.webhookService.Get(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, sub)
+}
+
+// CreateWebhook subscribes a URL to events, returning its signing secret
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var input models.WebhookInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	sub, err := h.webhookService.Create(ctx, middleware.UserIDFromContext(ctx), input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusCreated, sub)
+}
+
+// UpdateWebhook replaces a subscription's URL, events and active flag
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	var input models.WebhookInput
+	if err := utils.DecodeJSON(r, &input); err != nil {
+		utils.RespondDecodeError(w, err)
+		return
+	}
+	if err := validators.Validate(input); err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	sub, err := h.webhookService.Update(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, input)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, sub)
+}
+
+// DeleteWebhook removes a subscription
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	if err := h.webhookService.Delete(ctx, id, middleware.UserIDFromContext(ctx), isAdmin); err != nil {
+		h.respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateWebhookSecret replaces a subscription's signing secret, returning the new one
+func (h *WebhookHandler) RotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	sub, err := h.webhookService.RotateSecret(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, sub)
+}
+
+// GetWebhookDeliveries returns a page of a subscription's delivery attempts, newest first
+func (h *WebhookHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	params, err := utils.ParseListParams(r, utils.ListDefaults{})
+	if err != nil {
+		utils.RespondValidationError(w, err)
+		return
+	}
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	page, err := h.webhookService.Deliveries(ctx, id, middleware.UserIDFromContext(ctx), isAdmin, params.Limit, params.Offset)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, page)
+}
+
+// TestWebhook sends a signed ping to a subscription and returns the attempt
+func (h *WebhookHandler) TestWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	isAdmin := middleware.RoleFromContext(ctx) == middleware.RoleAdmin
+	delivery, err := h.webhookService.Test(ctx, id, middleware.UserIDFromContext(ctx), isAdmin)
+	if err != nil {
+		h.respondError(w, err)
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, delivery)
+}
+
+// webhookID reads the subscription ID URL parameter, responding 404 when it
+// is not a valid ID
+func webhookID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		utils.RespondError(w, http.StatusNotFound, "not_found", "Webhook subscription not found")
+		return "", false
+	}
+	return id, true
+}
+
+func (h *WebhookHandler) respondError(w http.ResponseWriter, err error) {
+	if respondNotFound(w, err) {
+		return
+	}
+	var verr *validators.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		utils.RespondValidationError(w, err)
+	default:
+		log.Error().Err(err).Msg("Webhook operation failed")
+		utils.RespondError(w, http.StatusInternalServerError, "internal_error", "Webhook operation failed")
+	}
+}
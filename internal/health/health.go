@@ -0,0 +1,130 @@
+// Package health publishes the health transitions of the components the
+// process depends on, so operators hear the moment one goes unhealthy or
+// recovers rather than on the next metrics scrape.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// State is the health of a component
+type State string
+
+const (
+	StateHealthy   State = "healthy"
+	StateUnhealthy State = "unhealthy"
+)
+
+// Event is a component's change of state
+type Event struct {
+	Component string    `json:"component"`
+	From      State     `json:"from"`
+	To        State     `json:"to"`
+	Error     string    `json:"error,omitempty"` // why it became unhealthy
+	At        time.Time `json:"at"`
+}
+
+// Subscriber handles the events published on a Bus. Subscribers are called
+// one event at a time, in order, so a slow one delays the others.
+type Subscriber func(ctx context.Context, event Event)
+
+// eventBuffer is how many events may wait for subscribers before new ones
+// are dropped
+const eventBuffer = 64
+
+// Bus tracks the state of each component reported to it and publishes an
+// Event to its subscribers whenever one changes. Components are assumed
+// healthy until first reported otherwise. Anything can report: a component
+// that notices its own failures calls Report as they happen, and others are
+// polled with Watch.
+type Bus struct {
+	events chan Event
+
+	mu          sync.Mutex
+	states      map[string]State
+	subscribers []Subscriber
+}
+
+// NewBus creates a new bus. Its events reach subscribers once Run starts.
+func NewBus() *Bus {
+	return &Bus{events: make(chan Event, eventBuffer), states: make(map[string]State)}
+}
+
+// Subscribe adds subscriber to the bus
+func (b *Bus) Subscribe(subscriber Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, subscriber)
+}
+
+// Report records the outcome of checking component, publishing an Event if
+// its state changed. A nil err is healthy.
+func (b *Bus) Report(component string, err error) {
+	state := StateHealthy
+	if err != nil {
+		state = StateUnhealthy
+	}
+	b.mu.Lock()
+	from, ok := b.states[component]
+	if !ok {
+		from = StateHealthy
+	}
+	b.states[component] = state
+	b.mu.Unlock()
+	if from == state {
+		return
+	}
+
+	event := Event{Component: component, From: from, To: state, At: time.Now().UTC()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	select {
+	case b.events <- event:
+	default:
+		log.Error().Str("component", component).Str("state", string(state)).Msg("Health event dropped, subscribers are behind")
+	}
+}
+
+// Watch reports the outcome of check for component every interval until ctx
+// is done, giving each check the interval to complete
+func (b *Bus) Watch(ctx context.Context, component string, interval time.Duration, check func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := check(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		b.Report(component, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run delivers published events to the subscribers until ctx is done
+func (b *Bus) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-b.events:
+			b.mu.Lock()
+			subscribers := append([]Subscriber(nil), b.subscribers...)
+			b.mu.Unlock()
+			for _, subscriber := range subscribers {
+				subscriber(ctx, event)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/version"
+)
+
+// LogEvent logs event, at error level when a component went unhealthy and
+// at warn level when it recovered
+func LogEvent(ctx context.Context, event Event) {
+	logEvent := log.Warn()
+	message := "Component recovered"
+	if event.To == StateUnhealthy {
+		logEvent = log.Error()
+		message = "Component unhealthy"
+	}
+	logEvent.Str("component", event.Component).Str("from", string(event.From)).Str("to", string(event.To)).
+		Str("error", event.Error).Time("at", event.At).Msg(message)
+}
+
+// WebhookAlerter posts each event as JSON to an ops channel's webhook. Other
+// alerts for the channel can be sent through it with Send.
+type WebhookAlerter struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookAlerter creates an alerter posting to url, giving up on a post
+// after timeout
+func NewWebhookAlerter(url string, timeout time.Duration) *WebhookAlerter {
+	return &WebhookAlerter{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Alert posts event to the webhook. Failures are logged, not retried: the
+// next transition is posted regardless.
+func (a *WebhookAlerter) Alert(ctx context.Context, event Event) {
+	if err := a.post(ctx, event); err != nil {
+		log.Warn().Err(err).Str("component", event.Component).Msg("Failed to send health alert")
+	}
+}
+
+// Send posts payload as JSON to the webhook
+func (a *WebhookAlerter) Send(ctx context.Context, payload interface{}) error {
+	return a.post(ctx, payload)
+}
+
+func (a *WebhookAlerter) post(ctx context.Context, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post alert: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
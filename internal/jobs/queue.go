@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/greens-marketplace/internal/database"
+)
+
+const (
+	queueKey      = "jobs:queue"
+	deadLetterKey = "jobs:dead"
+)
+
+// Job represents a unit of background work
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	EnqueuedAt time.Time       `json:"enqueuedAt"`
+}
+
+// Queue is a Redis-backed job queue shared by all replicas
+type Queue struct {
+	redis *database.RedisClient
+}
+
+// NewQueue creates a new job queue
+func NewQueue(redis *database.RedisClient) *Queue {
+	return &Queue{redis: redis}
+}
+
+// Enqueue adds a job of jobType with the given payload. id identifies the job
+// to handlers for deduplication; an empty id is replaced with a random one.
+func (q *Queue) Enqueue(ctx context.Context, id, jobType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job payload: %w", err)
+	}
+	if id == "" {
+		id = uuid.NewString()
+	}
+	return q.push(ctx, queueKey, &Job{ID: id, Type: jobType, Payload: data, EnqueuedAt: time.Now()})
+}
+
+func (q *Queue) push(ctx context.Context, key string, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	if err := q.redis.LPush(ctx, key, data).Err(); err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+// pop waits up to timeout for the next job, returning nil when none arrived
+func (q *Queue) pop(ctx context.Context, timeout time.Duration) (*Job, error) {
+	res, err := q.redis.BRPop(ctx, timeout, queueKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(res[1]), &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	}
+	return &job, nil
+}
@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/metrics"
+)
+
+// Scheduler runs the periodic tasks of a process and keeps track of when
+// each last ran and runs next. Every replica runs its own tasks, so what it
+// reports is this process's.
+type Scheduler struct {
+	mu    sync.Mutex
+	tasks []*scheduledTask
+}
+
+// scheduledTask is the state of a task, guarded by the scheduler's mutex
+type scheduledTask struct {
+	name         string
+	interval     time.Duration
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    string
+	nextRun      time.Time
+	running      bool
+	missed       int
+}
+
+// TaskStatus is the state of a scheduled task
+type TaskStatus struct {
+	Name            string     `json:"name"`
+	IntervalSeconds float64    `json:"intervalSeconds"`
+	LastRun         *time.Time `json:"lastRun,omitempty"` // when the last run started; nil before the first
+	LastDurationMs  int64      `json:"lastDurationMs"`
+	LastError       string     `json:"lastError,omitempty"` // why the last run failed, if it did
+	NextRun         time.Time  `json:"nextRun"`
+	Running         bool       `json:"running"`
+	MissedRuns      int        `json:"missedRuns"` // runs skipped since start because the previous one was still going
+	Overdue         bool       `json:"overdue"`    // the next run is more than an interval late
+}
+
+// NewScheduler creates a new scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Every runs fn every interval, the first time an interval from now, until
+// ctx is cancelled. Runs don't overlap: runs due while the previous one is
+// still going are skipped and counted as missed. A failed run is logged and
+// the task runs again at its next time.
+func (s *Scheduler) Every(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	t := &scheduledTask{name: name, interval: interval, nextRun: time.Now().Add(interval)}
+	s.mu.Lock()
+	s.tasks = append(s.tasks, t)
+	s.mu.Unlock()
+	metrics.SchedulerNextRun.WithLabelValues(name).Set(float64(t.nextRun.Unix()))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		s.mu.Lock()
+		t.running, t.lastRun = true, start
+		s.mu.Unlock()
+		metrics.SchedulerLastRun.WithLabelValues(name).Set(float64(start.Unix()))
+
+		err := fn(ctx)
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+
+		// The ticker drops the ticks a run overlaps, so the next run is at
+		// the first tick after this one ends
+		duration := time.Since(start)
+		missed := int(duration / interval)
+		s.mu.Lock()
+		t.running, t.lastDuration = false, duration
+		t.missed += missed
+		t.nextRun = start.Add(time.Duration(missed+1) * interval)
+		t.lastError = ""
+		if err != nil {
+			t.lastError = err.Error()
+		}
+		next := t.nextRun
+		s.mu.Unlock()
+
+		metrics.SchedulerNextRun.WithLabelValues(name).Set(float64(next.Unix()))
+		if missed > 0 {
+			metrics.SchedulerMissedRuns.WithLabelValues(name).Add(float64(missed))
+			log.Warn().Str("task", name).Int("missed", missed).Dur("duration", duration).Msg("Scheduled task outlasted its interval")
+		}
+		if err != nil {
+			metrics.SchedulerRuns.WithLabelValues(name, "failed").Inc()
+			log.Error().Err(err).Str("task", name).Msg("Scheduled task failed")
+			continue
+		}
+		metrics.SchedulerRuns.WithLabelValues(name, "succeeded").Inc()
+	}
+}
+
+// Status returns the state of every task, in the order they were scheduled
+func (s *Scheduler) Status() []TaskStatus {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]TaskStatus, len(s.tasks))
+	for i, t := range s.tasks {
+		status := TaskStatus{
+			Name:            t.name,
+			IntervalSeconds: t.interval.Seconds(),
+			LastDurationMs:  t.lastDuration.Milliseconds(),
+			LastError:       t.lastError,
+			NextRun:         t.nextRun,
+			Running:         t.running,
+			MissedRuns:      t.missed,
+			// A run still going past its next time is late as well
+			Overdue: now.After(t.nextRun.Add(t.interval)),
+		}
+		if !t.lastRun.IsZero() {
+			lastRun := t.lastRun
+			status.LastRun = &lastRun
+		}
+		statuses[i] = status
+	}
+	return statuses
+}
+
+// Check returns an error naming the overdue tasks, if any are
+func (s *Scheduler) Check() error {
+	var overdue []string
+	for _, status := range s.Status() {
+		if status.Overdue {
+			overdue = append(overdue, status.Name)
+		}
+	}
+	if len(overdue) > 0 {
+		return fmt.Errorf("scheduled tasks overdue: %s", strings.Join(overdue, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/greens-marketplace/internal/metrics"
+)
+
+// QueueStats is the backlog of the queue, shared by all replicas
+type QueueStats struct {
+	Depth                   int64   `json:"depth"`
+	OldestPendingAgeSeconds float64 `json:"oldestPendingAgeSeconds"` // 0 when the queue is empty
+	DeadLetters             int64   `json:"deadLetters"`
+}
+
+// Health is the queue's backlog with a worker's job counts
+type Health struct {
+	Queue  *QueueStats `json:"queue"`
+	Worker WorkerStats `json:"worker"`
+}
+
+// Health samples the queue for a readiness check. It fails, still returning
+// the sample, when the oldest pending job has waited longer than
+// maxPendingAge, which means workers are stuck or can't keep up.
+func (w *Worker) Health(ctx context.Context, maxPendingAge time.Duration) (*Health, error) {
+	stats, err := w.queue.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	health := &Health{Queue: stats, Worker: w.Stats()}
+	if age := time.Duration(stats.OldestPendingAgeSeconds * float64(time.Second)); age > maxPendingAge {
+		return health, fmt.Errorf("oldest pending job has waited %s, longer than %s", age.Round(time.Second), maxPendingAge)
+	}
+	return health, nil
+}
+
+// Stats samples the queue's backlog and records it in the queue metrics.
+// Retried jobs keep their first enqueue time, so a job failing over and
+// over ages like one nobody picks up.
+func (q *Queue) Stats(ctx context.Context) (*QueueStats, error) {
+	pipe := q.redis.Pipeline()
+	depth := pipe.LLen(ctx, queueKey)
+	oldest := pipe.LIndex(ctx, queueKey, -1) // the next job popped
+	dead := pipe.LLen(ctx, deadLetterKey)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get queue stats: %w", err)
+	}
+
+	stats := &QueueStats{Depth: depth.Val(), DeadLetters: dead.Val()}
+	if data, err := oldest.Bytes(); err == nil {
+		var job Job
+		if err := json.Unmarshal(data, &job); err == nil && !job.EnqueuedAt.IsZero() {
+			stats.OldestPendingAgeSeconds = max(time.Since(job.EnqueuedAt).Seconds(), 0)
+		}
+	}
+	metrics.JobsQueueDepth.Set(float64(stats.Depth))
+	metrics.JobsOldestPendingAge.Set(stats.OldestPendingAgeSeconds)
+	metrics.JobsDeadLetterSize.Set(float64(stats.DeadLetters))
+	return stats, nil
+}
@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/metrics"
+)
+
+// MaxAttempts is the number of times a job is tried before it is moved to
+// the dead letter list
+const MaxAttempts = 5
+
+// HandlerFunc processes a job. Jobs may be delivered more than once, so
+// handlers must be idempotent, using Job.ID to detect repeats.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Worker consumes jobs from a queue and dispatches them by type
+type Worker struct {
+	queue       *Queue
+	concurrency int
+	handlers    map[string]HandlerFunc
+
+	inFlight     atomic.Int64
+	processed    atomic.Int64
+	failed       atomic.Int64
+	deadLettered atomic.Int64
+}
+
+// WorkerStats counts the jobs a worker has run since the process started
+type WorkerStats struct {
+	InFlight     int64 `json:"inFlight"`
+	Processed    int64 `json:"processed"` // attempts that succeeded
+	Failed       int64 `json:"failed"`    // attempts that failed, dead-lettered ones included
+	DeadLettered int64 `json:"deadLettered"`
+}
+
+// NewWorker creates a worker running concurrency jobs at a time
+func NewWorker(queue *Queue, concurrency int) *Worker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Worker{queue: queue, concurrency: concurrency, handlers: make(map[string]HandlerFunc)}
+}
+
+// Handle registers the handler for jobType. Handlers must be registered
+// before Run is called.
+func (w *Worker) Handle(jobType string, handler HandlerFunc) {
+	w.handlers[jobType] = handler
+}
+
+// Run processes jobs until ctx is cancelled, then waits for in-flight jobs
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := w.queue.pop(ctx, 5*time.Second)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Msg("Failed to fetch job")
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		if job != nil {
+			// In-flight jobs finish even when shutdown begins
+			w.process(context.WithoutCancel(ctx), job)
+		}
+	}
+}
+
+// process runs a job, re-enqueueing it on failure until MaxAttempts
+func (w *Worker) process(ctx context.Context, job *Job) {
+	handler, ok := w.handlers[job.Type]
+	if !ok {
+		// Events are published whether or not anything subscribes to them
+		log.Debug().Str("job_id", job.ID).Str("job_type", job.Type).Msg("No handler for job, skipping")
+		return
+	}
+	// The handler's database and Redis operations are logged with the job
+	jobLogger := log.With().Str("job_id", job.ID).Str("job_type", job.Type).Logger()
+	w.inFlight.Add(1)
+	metrics.JobsInFlight.Inc()
+	err := w.run(jobLogger.WithContext(ctx), handler, job)
+	w.inFlight.Add(-1)
+	metrics.JobsInFlight.Dec()
+	if err == nil {
+		w.processed.Add(1)
+		metrics.JobsProcessed.WithLabelValues(job.Type, "succeeded").Inc()
+		return
+	}
+
+	w.failed.Add(1)
+	job.Attempts++
+	logger := jobLogger.With().Int("attempts", job.Attempts).Logger()
+	if job.Attempts >= MaxAttempts {
+		w.deadLettered.Add(1)
+		metrics.JobsProcessed.WithLabelValues(job.Type, "dead").Inc()
+		logger.Error().Err(err).Msg("Job failed permanently, moving to dead letter list")
+		if err := w.queue.push(ctx, deadLetterKey, job); err != nil {
+			logger.Error().Err(err).Msg("Failed to dead-letter job")
+		}
+		return
+	}
+
+	metrics.JobsProcessed.WithLabelValues(job.Type, "retried").Inc()
+	logger.Warn().Err(err).Msg("Job failed, retrying")
+	if err := w.queue.push(ctx, queueKey, job); err != nil {
+		logger.Error().Err(err).Msg("Failed to re-enqueue job")
+	}
+}
+
+// Stats returns the worker's job counts
+func (w *Worker) Stats() WorkerStats {
+	return WorkerStats{
+		InFlight:     w.inFlight.Load(),
+		Processed:    w.processed.Load(),
+		Failed:       w.failed.Load(),
+		DeadLettered: w.deadLettered.Load(),
+	}
+}
+
+// run calls handler, converting panics into errors
+func (w *Worker) run(ctx context.Context, handler HandlerFunc, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
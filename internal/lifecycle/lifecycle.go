@@ -0,0 +1,128 @@
+// Package lifecycle stops the components of the process in order on
+// shutdown.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Hook stops a component. It should return once the component has stopped,
+// or when ctx is done.
+type Hook func(ctx context.Context) error
+
+type namedHook struct {
+	name string
+	hook Hook
+}
+
+// Manager runs the shutdown hooks of the components of a process
+type Manager struct {
+	timeout     time.Duration
+	hookTimeout time.Duration
+
+	mu       sync.Mutex
+	hooks    []namedHook
+	shutdown bool
+}
+
+// NewManager creates a manager whose Shutdown runs every hook within
+// timeout, giving each at most hookTimeout of it
+func NewManager(timeout, hookTimeout time.Duration) *Manager {
+	return &Manager{timeout: timeout, hookTimeout: hookTimeout}
+}
+
+// OnShutdown registers hook under name. Hooks run in reverse order of
+// registration, so a component registered after those it uses is stopped
+// before them. Hooks registered once Shutdown has begun are ignored.
+func (m *Manager) OnShutdown(name string, hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shutdown {
+		log.Warn().Str("hook", name).Msg("Shutdown hook registered during shutdown, ignored")
+		return
+	}
+	m.hooks = append(m.hooks, namedHook{name: name, hook: hook})
+}
+
+// Go runs fn in a goroutine until shutdown, registering a hook under name
+// that cancels fn's context and waits for fn to return
+func (m *Manager) Go(name string, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+	m.OnShutdown(name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Shutdown runs the registered hooks, last registered first, logging how
+// long each took. Each hook gets the rest of the shared deadline, up to the
+// manager's hook timeout. A hook still running when its time is up is left
+// behind so the rest can run; hooks reached after the shared deadline are
+// still started, so quick cleanups happen, but are not waited for. The
+// errors of the hooks are returned joined. Shutdown runs the hooks once.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.shutdown {
+		m.mu.Unlock()
+		return nil
+	}
+	m.shutdown = true
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		start := time.Now()
+		err := m.run(ctx, h)
+		logger := log.With().Str("hook", h.name).Dur("duration", time.Since(start)).Logger()
+		if err != nil {
+			logger.Error().Err(err).Msg("Shutdown hook failed")
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		logger.Info().Msg("Shutdown hook finished")
+	}
+	return errors.Join(errs...)
+}
+
+// run runs h until it returns or its share of ctx's deadline is up
+func (m *Manager) run(ctx context.Context, h namedHook) error {
+	hookCtx, cancel := context.WithTimeout(ctx, m.hookTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- h.hook(hookCtx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-hookCtx.Done():
+		return fmt.Errorf("did not finish in time: %w", hookCtx.Err())
+	}
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// CacheRequests counts cache lookups by cache name, tier (l1 in-process, l2
+// Redis) and result (hit, miss, error)
+var CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "greens_cache_requests_total",
+	Help: "Cache lookups by cache, tier and result.",
+}, []string{"cache", "tier", "result"})
+
+// CacheCorrupt counts cached values that could not be decoded, by cache
+// name and tier, such as entries written before a change of their type.
+// They are dropped and reloaded.
+var CacheCorrupt = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "greens_cache_corrupt_entries_total",
+	Help: "Cached values that failed to decode and were reloaded, by cache and tier.",
+}, []string{"cache", "tier"})
+
+// RetentionPurgedRows counts the rows purged past their retention by entity
+// and mode (delete, or dry_run for the rows a dry run would have purged)
+var RetentionPurgedRows = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "greens_retention_purged_rows_total",
+	Help: "Rows purged past retention by entity and mode.",
+}, []string{"entity", "mode"})
+
+// RetentionPurgeDuration observes how long each purge run took
+var RetentionPurgeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "greens_retention_purge_duration_seconds",
+	Help:    "Duration of retention purge runs.",
+	Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+})
+
+// JobsQueueDepth, JobsOldestPendingAge and JobsDeadLetterSize are the
+// backlog of the shared job queue, as last sampled
+var (
+	JobsQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "greens_jobs_queue_depth",
+		Help: "Jobs waiting in the queue.",
+	})
+	JobsOldestPendingAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "greens_jobs_oldest_pending_age_seconds",
+		Help: "Age of the job that has waited longest in the queue.",
+	})
+	JobsDeadLetterSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "greens_jobs_dead_letter_size",
+		Help: "Jobs in the dead letter list.",
+	})
+)
+
+// JobsInFlight is the number of jobs this process is running
+var JobsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "greens_jobs_in_flight",
+	Help: "Jobs being processed.",
+})
+
+// JobsProcessed counts job attempts by job type and result (succeeded,
+// retried, or dead for attempts that moved the job to the dead letter list)
+var JobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "greens_jobs_processed_total",
+	Help: "Job attempts by type and result.",
+}, []string{"type", "result"})
+
+// SchedulerRuns counts scheduled task runs by task and result (succeeded or
+// failed)
+var SchedulerRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "greens_scheduler_runs_total",
+	Help: "Scheduled task runs by task and result.",
+}, []string{"task", "result"})
+
+// SchedulerMissedRuns counts the runs of a task skipped because its previous
+// run was still going
+var SchedulerMissedRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "greens_scheduler_missed_runs_total",
+	Help: "Scheduled task runs skipped while the previous run was still going.",
+}, []string{"task"})
+
+// SchedulerLastRun and SchedulerNextRun are when each task last started and
+// next runs, as Unix timestamps
+var (
+	SchedulerLastRun = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "greens_scheduler_last_run_timestamp_seconds",
+		Help: "When a scheduled task last started.",
+	}, []string{"task"})
+	SchedulerNextRun = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "greens_scheduler_next_run_timestamp_seconds",
+		Help: "When a scheduled task runs next.",
+	}, []string{"task"})
+)
+
+// Handler serves the Prometheus metrics endpoint
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// AccountChecker looks up whether users may use their accounts
+type AccountChecker interface {
+	// AccountStatus returns nil when there is no such user
+	AccountStatus(ctx context.Context, userID string) (*models.AccountStatus, error)
+}
+
+// RequireActiveAccount rejects requests from suspended users with 403 and
+// the reason they were suspended, and with 401 tokens issued before the
+// user's tokens were revoked, or to users who no longer exist or deleted
+// their accounts. It runs after
+// JWTAuth on every authenticated request, so a suspension takes effect at
+// once; guests are let through. Unlike the rate limiter it fails closed
+// when the status can't be looked up.
+func RequireActiveAccount(accounts AccountChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			token, _, err := jwtauth.FromContext(ctx)
+			userID := UserIDFromContext(ctx)
+			if err != nil || token == nil || userID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			status, err := accounts.AccountStatus(ctx, userID)
+			switch {
+			case err != nil:
+				log.Error().Err(err).Msg("Failed to get account status")
+				utils.RespondError(w, http.StatusServiceUnavailable, "account_status_unavailable", "Account status is unavailable, please retry later")
+				return
+			case status != nil && status.Suspended:
+				utils.RespondErrorWithDetails(w, http.StatusForbidden, "account_suspended", "Your account is suspended",
+					map[string]string{"reason": status.Reason})
+				return
+			case status == nil, status.Deleted, status.TokensRevokedAt != nil && !token.IssuedAt().After(*status.TokensRevokedAt):
+				utils.RespondError(w, http.StatusUnauthorized, "token_revoked", "Token has been revoked, please sign in again")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// Roles carried in the JWT "role" claim
+const (
+	RoleUser    = "user"
+	RoleSeller  = "seller"
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+)
+
+// TokenDecoder verifies a token's signature and parses it without validating
+// its claims; *jwtauth.JWTAuth implements it
+type TokenDecoder interface {
+	Decode(tokenString string) (jwt.Token, error)
+}
+
+// ValidateOptions returns the options every token is validated with, access
+// and refresh tokens alike: exp, nbf and iat are checked with cfg.ClockSkew
+// leeway either way, so a token issued by a server slightly ahead of the
+// client is not yet "not valid" and one just expired on a client slightly
+// behind still works, and the iss and aud claims must match cfg when
+// configured.
+func ValidateOptions(cfg config.JWTConfig) []jwt.ValidateOption {
+	opts := []jwt.ValidateOption{jwt.WithAcceptableSkew(time.Duration(cfg.ClockSkew) * time.Second)}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	return opts
+}
+
+// JWTAuth verifies the bearer token on incoming requests and rejects requests
+// without a valid token using the standard error envelope. Besides the
+// signature, the token's claims are validated with ValidateOptions.
+func JWTAuth(tokens TokenDecoder, cfg config.JWTConfig) func(http.Handler) http.Handler {
+	opts := ValidateOptions(cfg)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			doneAuth := utils.TrackTiming(r.Context(), "auth")
+			tokenString := jwtauth.TokenFromHeader(r)
+			if tokenString == "" {
+				tokenString = jwtauth.TokenFromCookie(r)
+			}
+			if tokenString == "" {
+				utils.RespondError(w, http.StatusUnauthorized, "unauthorized", "Invalid or missing authentication token")
+				return
+			}
+
+			// Decode checks the signature only; claims are validated below
+			token, err := tokens.Decode(tokenString)
+			if err == nil && token != nil {
+				err = jwt.Validate(token, opts...)
+			}
+			switch {
+			case token == nil:
+				utils.RespondError(w, http.StatusUnauthorized, "unauthorized", "Invalid or missing authentication token")
+				return
+			case errors.Is(err, jwt.ErrInvalidIssuer()):
+				utils.RespondError(w, http.StatusUnauthorized, "invalid_token_issuer", "Token issuer is not accepted")
+				return
+			case errors.Is(err, jwt.ErrInvalidAudience()):
+				utils.RespondError(w, http.StatusUnauthorized, "invalid_token_audience", "Token audience is not accepted")
+				return
+			case errors.Is(err, jwt.ErrTokenExpired()):
+				utils.RespondError(w, http.StatusUnauthorized, "token_expired", "Token has expired")
+				return
+			case errors.Is(err, jwt.ErrTokenNotYetValid()), errors.Is(err, jwt.ErrInvalidIssuedAt()):
+				// Issued in the future by more than the leeway; the client's
+				// or our clock is off
+				utils.RespondError(w, http.StatusUnauthorized, "token_not_yet_valid", "Token is not valid yet")
+				return
+			case err != nil:
+				utils.RespondError(w, http.StatusUnauthorized, "unauthorized", "Invalid or missing authentication token")
+				return
+			}
+
+			ctx := withUserLogger(jwtauth.NewContext(r.Context(), token, nil))
+			doneAuth()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// OptionalJWTAuth authenticates requests that carry a token the way JWTAuth
+// does, rejecting invalid tokens, and lets requests without one through as
+// guests
+func OptionalJWTAuth(tokens TokenDecoder, cfg config.JWTConfig) func(http.Handler) http.Handler {
+	auth := JWTAuth(tokens, cfg)
+	return func(next http.Handler) http.Handler {
+		authenticated := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if jwtauth.TokenFromHeader(r) == "" && jwtauth.TokenFromCookie(r) == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole rejects requests whose token role is not one of roles
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := RoleFromContext(r.Context())
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			utils.RespondError(w, http.StatusForbidden, "forbidden", "Insufficient permissions")
+		})
+	}
+}
+
+// UserIDFromContext returns the authenticated user's ID, or an empty string
+// for unauthenticated requests
+func UserIDFromContext(ctx context.Context) string {
+	return claimString(ctx, "user_id")
+}
+
+// RoleFromContext returns the authenticated user's role, or an empty string
+// for unauthenticated requests
+func RoleFromContext(ctx context.Context) string {
+	return claimString(ctx, "role")
+}
+
+// IsStaff reports whether the authenticated user is a marketplace staff
+// member (admin or support agent)
+func IsStaff(ctx context.Context) bool {
+	role := RoleFromContext(ctx)
+	return role == RoleAdmin || role == RoleSupport
+}
+
+// HasRole reports whether the authenticated user has one of roles
+func HasRole(ctx context.Context, roles []string) bool {
+	role := RoleFromContext(ctx)
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// claimString returns a string claim from the verified token in ctx
+func claimString(ctx context.Context, name string) string {
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil || claims == nil {
+		return ""
+	}
+	value, _ := claims[name].(string)
+	return value
+}
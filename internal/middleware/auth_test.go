@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// signedToken signs an access token issued at issuedAt and expiring at
+// expiresAt, as UserService does
+func signedToken(t *testing.T, tokens *jwtauth.JWTAuth, issuedAt, expiresAt time.Time) string {
+	t.Helper()
+	claims := map[string]interface{}{
+		"sub":     "user-1",
+		"user_id": "user-1",
+		"role":    RoleUser,
+		"nbf":     issuedAt.Unix(),
+	}
+	jwtauth.SetIssuedAt(claims, issuedAt)
+	jwtauth.SetExpiry(claims, expiresAt)
+	_, token, err := tokens.Encode(claims)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func TestJWTAuthClockSkew(t *testing.T) {
+	tokens := jwtauth.New("HS256", []byte("test-secret"), nil)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		skew      int
+		issuedAt  time.Time
+		expiresAt time.Time
+		status    int
+		code      string
+	}{
+		{"valid", 30, now.Add(-time.Minute), now.Add(time.Hour), http.StatusOK, ""},
+		{"issued slightly in the future", 30, now.Add(10 * time.Second), now.Add(time.Hour), http.StatusOK, ""},
+		{"issued beyond the leeway", 30, now.Add(2 * time.Minute), now.Add(time.Hour), http.StatusUnauthorized, "token_not_yet_valid"},
+		{"slightly expired", 30, now.Add(-time.Hour), now.Add(-10 * time.Second), http.StatusOK, ""},
+		{"expired beyond the leeway", 30, now.Add(-time.Hour), now.Add(-2 * time.Minute), http.StatusUnauthorized, "token_expired"},
+		{"slightly expired without leeway", 0, now.Add(-time.Hour), now.Add(-10 * time.Second), http.StatusUnauthorized, "token_expired"},
+		{"slightly in the future without leeway", 0, now.Add(10 * time.Second), now.Add(time.Hour), http.StatusUnauthorized, "token_not_yet_valid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := JWTAuth(tokens, config.JWTConfig{ClockSkew: tt.skew})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if UserIDFromContext(r.Context()) != "user-1" {
+					t.Errorf("user ID = %q, want user-1", UserIDFromContext(r.Context()))
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+signedToken(t, tokens, tt.issuedAt, tt.expiresAt))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code == "" {
+				return
+			}
+			var body utils.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode error envelope: %v", err)
+			}
+			if body.Error.Code != tt.code {
+				t.Errorf("error code = %q, want %q", body.Error.Code, tt.code)
+			}
+		})
+	}
+}
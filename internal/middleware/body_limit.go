@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+)
+
+// limitedBody is a request body capped by http.MaxBytesReader that keeps a
+// reference to the original body so a route-level limit can replace the
+// global one instead of being nested inside it
+type limitedBody struct {
+	io.ReadCloser
+	orig  io.ReadCloser
+	limit int64
+	// declared is the request's Content-Length, -1 when unknown
+	declared int64
+}
+
+// Read fails straight away with *http.MaxBytesError when the declared
+// Content-Length is over the limit, so nothing is read from the client
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.declared > b.limit {
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// MaxBodyBytes limits request bodies to n bytes. Reading a body that is over
+// the limit, whether by its declared Content-Length or by the bytes actually
+// sent, surfaces *http.MaxBytesError to the handler, which utils.
+// RespondDecodeError turns into a 413. The check happens when the body is
+// read rather than up front so that applying MaxBodyBytes again on a route
+// replaces the global limit instead of being nested inside it.
+func MaxBodyBytes(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n <= 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			orig := r.Body
+			if lb, ok := r.Body.(*limitedBody); ok {
+				orig = lb.orig
+			}
+			r.Body = &limitedBody{
+				ReadCloser: http.MaxBytesReader(w, orig, n),
+				orig:       orig,
+				limit:      n,
+				declared:   r.ContentLength,
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
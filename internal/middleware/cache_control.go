@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// cacheVary are the request headers every cached response varies on. The
+// currency is chosen with the currency query parameter, so it is already
+// part of the URL caches key on.
+var cacheVary = []string{"Accept", "Accept-Encoding"}
+
+// CacheControl sets caching headers on a GET route group. Successful
+// anonymous responses may be cached publicly, for policy.MaxAge by browsers
+// and policy.SharedMaxAge by CDNs through Surrogate-Control, and carry a weak
+// ETag of their body, so a cache holding a stale copy revalidates with
+// If-None-Match and gets a bodiless 304. Requests carrying a token may get
+// personalized data, so their responses are private and no-store, as are
+// errors. Responses are buffered to compute the ETag.
+func CacheControl(policy config.CachePolicy) func(http.Handler) http.Handler {
+	public := publicCacheControl(policy)
+	shared := policy.SharedMaxAge
+	if shared == 0 {
+		shared = policy.MaxAge
+	}
+	surrogate := fmt.Sprintf("max-age=%d", shared)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			personalized := jwtauth.TokenFromHeader(r) != "" || jwtauth.TokenFromCookie(r) != ""
+
+			cw := &cacheWriter{ResponseWriter: w}
+			next.ServeHTTP(utils.Rewrap(w, cw), r)
+
+			header := w.Header()
+			addVary(header, cacheVary...)
+			status := cw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			switch {
+			case personalized:
+				header.Set("Cache-Control", "private, no-store")
+				header.Set("Surrogate-Control", "no-store")
+			case status != http.StatusOK:
+				header.Set("Cache-Control", "no-store")
+				header.Set("Surrogate-Control", "no-store")
+			default:
+				etag := bodyETag(cw.body.Bytes())
+				header.Set("ETag", etag)
+				header.Set("Cache-Control", public)
+				header.Set("Surrogate-Control", surrogate)
+				if etagMatches(r.Header.Get("If-None-Match"), etag) {
+					header.Del("Content-Length")
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			w.WriteHeader(status)
+			w.Write(cw.body.Bytes())
+		})
+	}
+}
+
+// publicCacheControl returns the Cache-Control value of a policy's
+// anonymous responses
+func publicCacheControl(policy config.CachePolicy) string {
+	value := "public, no-cache"
+	if policy.MaxAge > 0 {
+		value = fmt.Sprintf("public, max-age=%d", policy.MaxAge)
+	}
+	if policy.StaleWhileRevalidate > 0 {
+		value += fmt.Sprintf(", stale-while-revalidate=%d", policy.StaleWhileRevalidate)
+	}
+	return value
+}
+
+// bodyETag returns a weak entity tag of a response body. It is weak because
+// the same representation may be encoded differently, such as compressed.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match list matches etag, comparing
+// weakly as RFC 9110 requires for If-None-Match
+func etagMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds names to the Vary header, leaving out those already listed
+func addVary(header http.Header, names ...string) {
+	listed := map[string]bool{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			listed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, name := range names {
+		if !listed[name] {
+			header.Add("Vary", name)
+		}
+	}
+}
+
+// cacheWriter holds a response back until its caching headers are known
+type cacheWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+// cachedProductRouter mirrors main.go: product detail takes an optional
+// token and is cached under policy
+func cachedProductRouter(tokens *jwtauth.JWTAuth, policy config.CachePolicy) http.Handler {
+	r := chi.NewRouter()
+	r.Use(OptionalJWTAuth(tokens, config.JWTConfig{}))
+	r.With(CacheControl(policy)).Get("/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"` + chi.URLParam(r, "id") + `"}`))
+	})
+	return r
+}
+
+func TestCacheControlAnonymousRevalidation(t *testing.T) {
+	tokens := jwtauth.New("HS256", []byte("test-secret"), nil)
+	router := cachedProductRouter(tokens, config.CachePolicy{MaxAge: 60, SharedMaxAge: 300})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/products/p-1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on an anonymous 200")
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q, want %q", got, "public, max-age=60")
+	}
+	if got := rec.Header().Get("Surrogate-Control"); got != "max-age=300" {
+		t.Errorf("Surrogate-Control = %q, want %q", got, "max-age=300")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/products/p-1", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("revalidation status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("304 body = %q, want none", rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Errorf("304 ETag = %q, want %q", got, etag)
+	}
+
+	// A changed body no longer matches
+	req = httptest.NewRequest(http.MethodGet, "/products/p-2", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status for another product = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestCacheControlAuthenticatedIsPrivate(t *testing.T) {
+	tokens := jwtauth.New("HS256", []byte("test-secret"), nil)
+	router := cachedProductRouter(tokens, config.CachePolicy{MaxAge: 60})
+
+	now := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/products/p-1", nil)
+	req.Header.Set("Authorization", "Bearer "+signedToken(t, tokens, now, now.Add(time.Hour)))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "private, no-store" {
+		t.Errorf("Cache-Control = %q, want %q", got, "private, no-store")
+	}
+	if got := rec.Header().Get("ETag"); got != "" {
+		t.Errorf("ETag = %q on a personalized response, want none", got)
+	}
+}
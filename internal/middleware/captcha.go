@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// CaptchaHeader carries the token of a CAPTCHA solved by the client
+const CaptchaHeader = "X-Captcha-Token"
+
+// CaptchaVerifier checks CAPTCHA tokens with their provider. It reports
+// false for a token the provider rejects and an error when the provider
+// can't be asked.
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// RequireCaptcha requires a solved CAPTCHA in CaptchaHeader once a client IP
+// has made more than cfg.FreeAttempts requests to the route within
+// cfg.Window seconds; name namespaces the attempt counts per route. Clients
+// below the threshold are never asked. When cfg is disabled or verifier is
+// nil it does nothing, and like the rate limiter it lets requests through
+// when attempts can't be counted.
+func RequireCaptcha(name string, verifier CaptchaVerifier, limiter *RateLimiter, cfg config.CaptchaConfig) func(http.Handler) http.Handler {
+	if !cfg.Enabled || verifier == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	window := time.Duration(cfg.Window) * time.Second
+	key := "captcha:" + name + ":"
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			allowed, _, _, err := limiter.take(r.Context(), key+ip, cfg.FreeAttempts, window)
+			if err != nil {
+				limiter.markDegraded(err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := r.Header.Get(CaptchaHeader)
+			if token == "" {
+				utils.RespondError(w, http.StatusForbidden, "captcha_required", "Please complete the CAPTCHA")
+				return
+			}
+			ok, err := verifier.Verify(r.Context(), token, ip)
+			if err != nil {
+				log.Error().Err(err).Str("route", name).Msg("CAPTCHA verification failed")
+				utils.RespondError(w, http.StatusServiceUnavailable, "captcha_unavailable", "CAPTCHA verification is unavailable, please retry later")
+				return
+			}
+			if !ok {
+				utils.RespondError(w, http.StatusForbidden, "captcha_invalid", "CAPTCHA verification failed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// DegradedChecker reports whether degraded mode is on, with the message and
+// retry delay for rejected requests
+type DegradedChecker interface {
+	Degraded(ctx context.Context) (bool, string, time.Duration)
+}
+
+// DegradedMode rejects requests with 503 while degraded mode is on. Apply it
+// only to non-essential routes so browsing and checkout keep working.
+func DegradedMode(checker DegradedChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			degraded, message, retryAfter := checker.Degraded(r.Context())
+			if !degraded {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+			}
+			utils.RespondError(w, http.StatusServiceUnavailable, "degraded_mode", message)
+		})
+	}
+}
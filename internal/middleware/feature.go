@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// FeatureChecker reports whether a feature is enabled for a user
+type FeatureChecker interface {
+	IsEnabled(ctx context.Context, flag string, userID string) bool
+}
+
+// RequireFeature hides a route unless flag is enabled for the requesting
+// user. Disabled features respond with 404 so they stay invisible to clients.
+func RequireFeature(features FeatureChecker, flag string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !features.IsEnabled(r.Context(), flag, UserIDFromContext(r.Context())) {
+				utils.RespondError(w, http.StatusNotFound, "not_found", "Resource not found")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// ImpersonatedByHeader names the admin on responses to requests made with
+// an impersonation token
+const ImpersonatedByHeader = "X-Impersonated-By"
+
+// ImpersonationAuditor checks impersonations and records the requests made
+// with their tokens
+type ImpersonationAuditor interface {
+	ImpersonationActive(ctx context.Context, id, adminID, userID string) (bool, error)
+	RecordImpersonatedRequest(ctx context.Context, id, method, path string, status int, requestID string, at time.Time) error
+}
+
+// ImpersonatorFromContext returns the ID of the admin impersonating the
+// authenticated user, from the token's act claim, or an empty string when
+// the token is the user's own
+func ImpersonatorFromContext(ctx context.Context) string {
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil || claims == nil {
+		return ""
+	}
+	act, _ := claims["act"].(map[string]interface{})
+	adminID, _ := act["sub"].(string)
+	return adminID
+}
+
+// ImpersonationIDFromContext returns the ID of the impersonation the
+// authenticated user's token was issued for, or an empty string
+func ImpersonationIDFromContext(ctx context.Context) string {
+	if ImpersonatorFromContext(ctx) == "" {
+		return ""
+	}
+	return claimString(ctx, "jti")
+}
+
+// AuditImpersonation lets requests made with an impersonation token through
+// only while the impersonation is active, answering 401 once it has been
+// revoked or has expired, and records each of them, with its response
+// status, in the impersonation's audit trail. It runs after
+// RequireActiveAccount; other requests pass untouched. Like
+// RequireActiveAccount it fails closed.
+func AuditImpersonation(auditor ImpersonationAuditor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			adminID := ImpersonatorFromContext(ctx)
+			if adminID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id := ImpersonationIDFromContext(ctx)
+			active, err := auditor.ImpersonationActive(ctx, id, adminID, UserIDFromContext(ctx))
+			switch {
+			case err != nil:
+				log.Error().Err(err).Msg("Failed to check impersonation")
+				utils.RespondError(w, http.StatusServiceUnavailable, "impersonation_unavailable", "Impersonation status is unavailable, please retry later")
+				return
+			case !active:
+				utils.RespondError(w, http.StatusUnauthorized, "impersonation_ended", "Impersonation has ended")
+				return
+			}
+
+			w.Header().Set(ImpersonatedByHeader, adminID)
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			// Recorded even when the client has gone, as the request may
+			// have changed something
+			if err := auditor.RecordImpersonatedRequest(context.WithoutCancel(ctx), id, r.Method, r.URL.RequestURI(),
+				status, chimiddleware.GetReqID(ctx), start); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to record impersonated request")
+			}
+		})
+	}
+}
+
+// ForbidImpersonation rejects requests made with an impersonation token
+// with 403. Apply it to routes an admin acting as a user must not use, such
+// as changing payment methods, charging and refunding.
+func ForbidImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ImpersonatorFromContext(r.Context()) != "" {
+			utils.RespondError(w, http.StatusForbidden, "impersonation_forbidden", "Not allowed while impersonating a user")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
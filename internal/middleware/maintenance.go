@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// Headers announcing a maintenance window
+const (
+	MaintenanceStartsHeader = "X-Maintenance-Starts-At"
+	MaintenanceEndsHeader   = "X-Maintenance-Ends-At"
+)
+
+// MaintenanceChecker returns the maintenance window in its notice period or
+// on now, or nil
+type MaintenanceChecker interface {
+	Maintenance(ctx context.Context) *models.MaintenanceWindow
+}
+
+// Maintenance announces upcoming maintenance windows on every response with
+// a Warning header and the window's start and end, and rejects requests
+// with 503 while one is on. Requests under the exempt path prefixes, such
+// as health checks and admin routes, are only warned.
+func Maintenance(checker MaintenanceChecker, exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			window := checker.Maintenance(r.Context())
+			if window == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set(MaintenanceStartsHeader, window.StartsAt.UTC().Format(time.RFC3339))
+			w.Header().Set(MaintenanceEndsHeader, window.EndsAt.UTC().Format(time.RFC3339))
+			w.Header().Set("Warning", fmt.Sprintf("299 - %s", strconv.Quote(window.Message)))
+			if window.Status != models.MaintenanceActive || underPrefix(r.URL.Path, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			retryAfter := time.Until(window.EndsAt).Round(time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(retryAfter/time.Second), 1)))
+			utils.RespondError(w, http.StatusServiceUnavailable, "maintenance", window.Message)
+		})
+	}
+}
+
+// underPrefix reports whether path is one of prefixes or under one
+func underPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
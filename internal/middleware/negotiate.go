@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// NegotiateContent answers a route in JSON or XML as the Accept header
+// prefers, errors included, for handlers writing with utils.Respond.
+// Requests accepting neither get 406.
+func NegotiateContent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		format, ok := utils.NegotiateFormat(r.Header.Get("Accept"))
+		if !ok {
+			utils.RespondError(w, http.StatusNotAcceptable, "not_acceptable", "Responses are available as application/json or application/xml")
+			return
+		}
+		next.ServeHTTP(utils.WithFormat(w, format), r)
+	})
+}
+
+// Localize writes validation errors in the language the Accept-Language
+// header prefers, English when none of its languages is supported
+func Localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(utils.WithLocale(w, utils.NegotiateLanguage(r.Header.Get("Accept-Language"))), r)
+	})
+}
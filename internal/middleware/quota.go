@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// SemanticSearchQuota counts semantic searches against per-user daily quotas
+type SemanticSearchQuota interface {
+	ConsumeSemanticSearch(ctx context.Context, userID string, isAdmin bool) (*models.QuotaStatus, bool, error)
+}
+
+// QuotaBySemanticSearch limits semantic searches to the requesting user's
+// daily plan quota, responding 429 with the quota status once it is used up.
+// Like the rate limiter it fails open when the quota store is unavailable.
+func QuotaBySemanticSearch(quotas SemanticSearchQuota) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			status, allowed, err := quotas.ConsumeSemanticSearch(ctx, UserIDFromContext(ctx), RoleFromContext(ctx) == RoleAdmin)
+			if err != nil {
+				log.Warn().Err(err).Msg("Semantic search quota unavailable, allowing request")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !status.Unlimited {
+				w.Header().Set("X-Quota-Limit", strconv.Itoa(status.Limit))
+				w.Header().Set("X-Quota-Remaining", strconv.Itoa(status.Remaining))
+				w.Header().Set("X-Quota-Reset", strconv.FormatInt(status.ResetsAt.Unix(), 10))
+			}
+			if !allowed {
+				seconds := int(time.Until(status.ResetsAt).Seconds()) + 1
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				utils.RespondErrorWithDetails(w, http.StatusTooManyRequests, "quota_exceeded",
+					"Daily semantic search quota exhausted", status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
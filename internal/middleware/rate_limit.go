@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// slidingWindowScript implements a sliding window log in a sorted set. It
+// uses the Redis server clock so replicas with skewed clocks agree on the
+// window. Returns {allowed, remaining, retry_after_ms}.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local member = ARGV[3]
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+local count = redis.call('ZCARD', key)
+if count < limit then
+	redis.call('ZADD', key, now, member)
+	redis.call('PEXPIRE', key, window)
+	return {1, limit - count - 1, 0}
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local retry = window
+if oldest[2] then
+	retry = window - (now - tonumber(oldest[2]))
+end
+return {0, 0, retry}
+`)
+
+// KeyFunc derives the rate limit bucket for a request
+type KeyFunc func(r *http.Request) string
+
+// RateLimiter enforces request limits shared by all replicas through Redis.
+// When Redis is unavailable it fails open and lets requests through.
+type RateLimiter struct {
+	redis    *database.RedisClient
+	degraded atomic.Bool
+	limits   atomic.Pointer[map[string]config.RateLimitConfig] // read by LimitRoute on each request
+}
+
+// NewRateLimiter creates a new Redis-backed rate limiter enforcing limits on
+// the routes wrapped by LimitRoute
+func NewRateLimiter(redis *database.RedisClient, limits map[string]config.RateLimitConfig) *RateLimiter {
+	l := &RateLimiter{redis: redis}
+	l.SetLimits(limits)
+	return l
+}
+
+// SetLimits replaces the route limits, taking effect from the next request
+func (l *RateLimiter) SetLimits(limits map[string]config.RateLimitConfig) {
+	copied := make(map[string]config.RateLimitConfig, len(limits))
+	for name, limit := range limits {
+		copied[name] = limit
+	}
+	l.limits.Store(&copied)
+}
+
+// LimitRoute applies the configured limit called name, as it is when each
+// request arrives. Routes without a configured limit are not limited.
+func (l *RateLimiter) LimitRoute(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, ok := (*l.limits.Load())[name]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			keyFn := clientIP
+			if limit.By == "user" {
+				keyFn = userOrIPKey
+			}
+			l.serve(w, r, next, name, limit.Requests, time.Duration(limit.Window)*time.Second, keyFn,
+				"Too many requests, please retry later")
+		})
+	}
+}
+
+// LimitByIP limits each client IP to count requests per window
+func (l *RateLimiter) LimitByIP(count int, window time.Duration) func(http.Handler) http.Handler {
+	return l.Limit("ip", count, window, clientIP)
+}
+
+// Limit limits each key returned by keyFn to count requests per window.
+// name namespaces the buckets so separate limits don't share counters.
+func (l *RateLimiter) Limit(name string, count int, window time.Duration, keyFn KeyFunc) func(http.Handler) http.Handler {
+	return l.limit(name, count, window, keyFn, "Too many requests, please retry later")
+}
+
+// LimitUser limits each authenticated user to count requests per window,
+// responding with message once the limit is hit. Users with one of
+// exemptRoles are not limited, and a count of 0 disables the limit.
+func (l *RateLimiter) LimitUser(name string, count int, window time.Duration, exemptRoles []string, message string) func(http.Handler) http.Handler {
+	if count <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limit := l.limit(name, count, window, userKey, message)
+	return func(next http.Handler) http.Handler {
+		limited := limit(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if HasRole(r.Context(), exemptRoles) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limit limits each key returned by keyFn to count requests per window,
+// responding with message to requests over the limit
+func (l *RateLimiter) limit(name string, count int, window time.Duration, keyFn KeyFunc, message string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l.serve(w, r, next, name, count, window, keyFn, message)
+		})
+	}
+}
+
+// serve passes r to next if its key is under the limit, and otherwise
+// responds 429 with message
+func (l *RateLimiter) serve(w http.ResponseWriter, r *http.Request, next http.Handler, name string, count int, window time.Duration, keyFn KeyFunc, message string) {
+	key := fmt.Sprintf("ratelimit:%s:%d:%s", name, window.Milliseconds(), keyFn(r))
+	allowed, remaining, retryAfter, err := l.take(r.Context(), key, count, window)
+	if err != nil {
+		l.markDegraded(err)
+		next.ServeHTTP(w, r)
+		return
+	}
+	l.markHealthy()
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(count))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !allowed {
+		seconds := int((retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		utils.RespondError(w, http.StatusTooManyRequests, "rate_limited", message)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// take records a request against key, reporting whether it is allowed
+func (l *RateLimiter) take(ctx context.Context, key string, count int, window time.Duration) (bool, int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	res, err := slidingWindowScript.Run(ctx, l.redis.Client, []string{key},
+		window.Milliseconds(), count, uuid.NewString()).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if len(res) != 3 {
+		return false, 0, 0, fmt.Errorf("unexpected rate limit script result: %v", res)
+	}
+	return res[0] == 1, int(res[1]), time.Duration(res[2]) * time.Millisecond, nil
+}
+
+// markDegraded logs the switch to fail-open mode once per outage
+func (l *RateLimiter) markDegraded(err error) {
+	if l.degraded.CompareAndSwap(false, true) {
+		log.Warn().Err(err).Msg("Rate limiter cannot reach Redis, allowing all requests")
+	}
+}
+
+// markHealthy logs recovery from fail-open mode
+func (l *RateLimiter) markHealthy() {
+	if l.degraded.CompareAndSwap(true, false) {
+		log.Info().Msg("Rate limiter reconnected to Redis, limits enforced again")
+	}
+}
+
+// userKey buckets requests by authenticated user
+func userKey(r *http.Request) string {
+	return UserIDFromContext(r.Context())
+}
+
+// userOrIPKey buckets requests by authenticated user, or by client IP for
+// anonymous requests
+func userOrIPKey(r *http.Request) string {
+	if userID := UserIDFromContext(r.Context()); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the client IP, falling back to the raw remote address
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestLogger puts a logger carrying the request ID into the request
+// context, for handlers, services and the database and Redis clients to log
+// with through zerolog.Ctx. It must run after chimiddleware.RequestID;
+// JWTAuth adds the authenticated user to it.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := log.With().Str("request_id", chimiddleware.GetReqID(r.Context())).Logger()
+		next.ServeHTTP(w, r.WithContext(logger.WithContext(r.Context())))
+	})
+}
+
+// withUserLogger adds the authenticated user to the request logger in ctx,
+// and the admin impersonating them if any
+func withUserLogger(ctx context.Context) context.Context {
+	logger := zerolog.Ctx(ctx)
+	if logger.GetLevel() == zerolog.Disabled {
+		return ctx
+	}
+	fields := logger.With().Str("user_id", UserIDFromContext(ctx)).Str("role", RoleFromContext(ctx))
+	if adminID := ImpersonatorFromContext(ctx); adminID != "" {
+		fields = fields.Str("impersonator_id", adminID).Str("impersonation_id", ImpersonationIDFromContext(ctx))
+	}
+	userLogger := fields.Logger()
+	return userLogger.WithContext(ctx)
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// ServerTiming reports how long each request spent in the phases recorded
+// with utils.TrackTiming in a Server-Timing response header. It turns
+// timings on for the process, so it is only installed in development.
+func ServerTiming(next http.Handler) http.Handler {
+	utils.EnableTimings()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, timings := utils.WithTimings(r.Context())
+		tw := &timingWriter{ResponseWriter: w, timings: timings, start: time.Now()}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+	})
+}
+
+// timingWriter adds the Server-Timing header as the response is written,
+// covering the phases of the request until then
+type timingWriter struct {
+	http.ResponseWriter
+	timings *utils.Timings
+	start   time.Time
+	written bool
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		w.Header().Set("Server-Timing", w.timings.Header(w.start))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// Request timeouts
+//
+// Timeout applies a default deadline to every request. RouteTimeout, applied
+// to a route group, replaces that default for the group: it may be longer
+// (slow exports, semantic search) or shorter (cheap reads). Any deadline that
+// existed before the default was applied, and any RouteTimeout applied
+// further out, still bounds the request, so between two route-level timeouts
+// the tighter one wins. Streaming requests (SSE and WebSocket upgrades) are
+// exempt from both and have the server write deadline lifted.
+//
+// The server's WriteTimeout (15s in main.go) would otherwise cut off any
+// response slower than that whatever the request deadline says, so both
+// middlewares move the connection's write deadline to just past the request
+// deadline with http.ResponseController.SetWriteDeadline.
+
+type timeoutStateKey struct{}
+
+// timeoutState lets RouteTimeout replace the deadline set by Timeout
+type timeoutState struct {
+	base      context.Context // request context before the default deadline
+	effective context.Context // context whose deadline currently applies
+	replaced  bool
+}
+
+// deadlineContext takes values from one context and cancellation from another,
+// so a replacement deadline keeps values added by earlier middleware
+type deadlineContext struct {
+	context.Context
+	deadline context.Context
+}
+
+func (c deadlineContext) Deadline() (time.Time, bool) { return c.deadline.Deadline() }
+func (c deadlineContext) Done() <-chan struct{}       { return c.deadline.Done() }
+func (c deadlineContext) Err() error                  { return c.deadline.Err() }
+
+// timeoutWriter records whether the handler started a response, so a 504 is
+// only sent in place of one
+type timeoutWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Timeout applies the default request deadline d and responds with 504 when
+// a handler returns after its effective deadline passed without having
+// written anything
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isStreaming(r) {
+				// Streams outlive the server write timeout as well
+				http.NewResponseController(w).SetWriteDeadline(time.Time{})
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			base := r.Context()
+			ctx, cancel := context.WithTimeout(base, d)
+			state := &timeoutState{base: base, effective: ctx}
+			ctx = context.WithValue(ctx, timeoutStateKey{}, state)
+			tw := &timeoutWriter{ResponseWriter: w}
+			defer func() {
+				cancel()
+				// A handler that already wrote owns the response; a second
+				// status line would be dropped and the body would mix two replies
+				if state.effective.Err() == context.DeadlineExceeded && !tw.wrote {
+					utils.RespondError(w, http.StatusGatewayTimeout, "request_timeout", "Request timed out")
+				}
+			}()
+
+			if deadline, ok := ctx.Deadline(); ok {
+				// Leave time to write the 504 once the deadline passes
+				http.NewResponseController(w).SetWriteDeadline(deadline.Add(time.Second))
+			}
+
+			next.ServeHTTP(tw, r.WithContext(ctx))
+		})
+	}
+}
+
+// RouteTimeout replaces the default request deadline with d for a route
+// group, extending the server write deadline to match
+func RouteTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isStreaming(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			parent := r.Context()
+			state, _ := parent.Value(timeoutStateKey{}).(*timeoutState)
+
+			var ctx context.Context
+			var cancel context.CancelFunc
+			if state != nil && !state.replaced {
+				// Derive from the context before the default deadline so d can exceed it
+				var deadline context.Context
+				deadline, cancel = context.WithTimeout(state.base, d)
+				ctx = deadlineContext{Context: parent, deadline: deadline}
+				state.replaced = true
+				state.effective = ctx
+			} else {
+				ctx, cancel = context.WithTimeout(parent, d)
+				if state != nil {
+					state.effective = ctx
+				}
+			}
+			defer cancel()
+
+			if deadline, ok := ctx.Deadline(); ok {
+				// Leave time to write the 504 once the deadline passes
+				http.NewResponseController(w).SetWriteDeadline(deadline.Add(time.Second))
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// isStreaming reports whether r opens a long-lived stream that must not be
+// cut off by request timeouts
+func isStreaming(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// waitForDeadline blocks until the request context ends, like a handler stuck
+// on a slow query
+func waitForDeadline(r *http.Request) {
+	<-r.Context().Done()
+}
+
+func TestTimeoutRespondsWhenNothingWritten(t *testing.T) {
+	h := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		waitForDeadline(r)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	var body utils.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body %q: %v", rec.Body.String(), err)
+	}
+	if body.Error.Code != "request_timeout" {
+		t.Fatalf("error code = %q, want %q", body.Error.Code, "request_timeout")
+	}
+}
+
+func TestTimeoutKeepsWrittenResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+		want  int
+		body  string
+	}{
+		{"header only", func(w http.ResponseWriter) { w.WriteHeader(http.StatusAccepted) }, http.StatusAccepted, ""},
+		{"body", func(w http.ResponseWriter) { io.WriteString(w, "partial") }, http.StatusOK, "partial"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.write(w)
+				waitForDeadline(r)
+			}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Body.String() != tt.body {
+				t.Fatalf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestRouteTimeoutOverridesDefault(t *testing.T) {
+	tests := []struct {
+		name  string
+		route time.Duration
+		want  int
+	}{
+		{"longer", time.Second, http.StatusOK},
+		{"shorter", 10 * time.Millisecond, http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Timeout(100 * time.Millisecond)(RouteTimeout(tt.route)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(200 * time.Millisecond):
+					w.WriteHeader(http.StatusOK)
+				}
+			})))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutOutlivesServerWriteTimeout(t *testing.T) {
+	// The server write timeout is shorter than the request deadlines, as in
+	// main.go; without the extension the connection closes before the reply
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+	}{
+		{"default", Timeout(time.Second)},
+		{"route", func(next http.Handler) http.Handler {
+			return Timeout(10 * time.Millisecond)(RouteTimeout(time.Second)(next))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(tt.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+				io.WriteString(w, "done")
+			})))
+			srv.Config.WriteTimeout = 50 * time.Millisecond
+			srv.Start()
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK || string(body) != "done" {
+				t.Fatalf("got %d %q, want %d %q", resp.StatusCode, body, http.StatusOK, "done")
+			}
+		})
+	}
+}
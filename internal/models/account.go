@@ -0,0 +1,160 @@
+package models
+
+import "time"
+
+// SuspendInput represents an admin's suspension of a user. The reason is
+// passed on to the user and given when they try to sign in.
+type SuspendInput struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// UnsuspendInput represents an admin lifting a user's suspension. The
+// reason is optional and only kept with the audit entry.
+type UnsuspendInput struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// AccountStatus is whether a user may use their account. Tokens issued at
+// or before TokensRevokedAt are no longer accepted, and none are once the
+// account is deleted.
+type AccountStatus struct {
+	UserID          string     `json:"userId"`
+	Suspended       bool       `json:"suspended"`
+	Deleted         bool       `json:"deleted,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	SuspendedAt     *time.Time `json:"suspendedAt,omitempty"`
+	TokensRevokedAt *time.Time `json:"tokensRevokedAt,omitempty"`
+}
+
+// AccountSuspension is an audit entry for a user being suspended, or their
+// suspension lifted
+type AccountSuspension struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Suspended bool      `json:"suspended"` // false when the suspension was lifted
+	Reason    string    `json:"reason,omitempty"`
+	ChangedBy *string   `json:"changedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AccountSuspensionPage is a page of a user's suspensions, newest first
+type AccountSuspensionPage struct {
+	Suspensions []AccountSuspension `json:"suspensions"`
+	Total       int                 `json:"total"`
+}
+
+// ImpersonateInput represents an admin starting to impersonate a user. The
+// reason is kept with the audit entry.
+type ImpersonateInput struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// Impersonation is an admin acting as a user through a short-lived token,
+// and the audit entry for it
+type Impersonation struct {
+	ID        string     `json:"id"`
+	AdminID   string     `json:"adminId"`
+	UserID    string     `json:"userId"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	RevokedBy *string    `json:"revokedBy,omitempty"`
+	Active    bool       `json:"active"`
+	Requests  int        `json:"requests"` // made with its token
+}
+
+// ImpersonationToken is the access token issued for an impersonation
+type ImpersonationToken struct {
+	Impersonation
+	Token string `json:"token"`
+}
+
+// ImpersonatedRequest is a request made with an impersonation's token
+type ImpersonatedRequest struct {
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	RequestID string    `json:"requestId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ImpersonatedRequestPage is a page of an impersonation's requests, oldest
+// first
+type ImpersonatedRequestPage struct {
+	Requests []ImpersonatedRequest `json:"requests"`
+	Total    int                   `json:"total"`
+}
+
+// ImpersonationPage is a page of the impersonations of a user, newest first
+type ImpersonationPage struct {
+	Impersonations []Impersonation `json:"impersonations"`
+	Total          int             `json:"total"`
+}
+
+// AdminUserFilter selects users for the admin user search. Email matches
+// the whole address and Query part of the email, name or phone, both
+// ignoring case.
+type AdminUserFilter struct {
+	ID       string `json:"id,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Phone    string `json:"phone,omitempty"` // part of the phone number
+	Query    string `json:"q,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Status   string `json:"status,omitempty"` // active, suspended or deleted
+	Verified *bool  `json:"verified,omitempty"`
+	Sort     string `json:"sort,omitempty"` // created_desc (default), created_asc, last_login_desc, email_asc
+	Cursor   string `json:"-"`
+	Limit    int    `json:"-"`
+}
+
+// AdminUserSummary is a user in the admin user search results. It never
+// carries credentials.
+type AdminUserSummary struct {
+	ID                string     `json:"id"`
+	Email             string     `json:"email"`
+	Username          string     `json:"username"`
+	Name              string     `json:"name"`
+	Phone             string     `json:"phone,omitempty"`
+	Role              string     `json:"role"`
+	IsVerified        bool       `json:"isVerified"`
+	VerificationLevel string     `json:"verificationLevel"`
+	SellerStatus      string     `json:"sellerStatus"`
+	Suspended         bool       `json:"suspended"`
+	SuspendedAt       *time.Time `json:"suspendedAt,omitempty"`
+	Deleted           bool       `json:"deleted"`
+	LastLogin         *time.Time `json:"lastLogin,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt"`
+}
+
+// AdminUserPage is a page of admin user search results. NextCursor is empty
+// on the last page.
+type AdminUserPage struct {
+	Users      []AdminUserSummary `json:"users"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+// What an account deletion does with the user's reviews: delete them, or
+// keep them without the user's name
+const (
+	DeletionReviewsAnonymize = "anonymize"
+	DeletionReviewsDelete    = "delete"
+)
+
+// DeleteAccountInput represents a user deleting their account
+type DeleteAccountInput struct {
+	Reviews string `json:"reviews" validate:"required,oneof=anonymize delete"`
+}
+
+// AccountDeletion is the audit entry for a deleted account, with what was
+// done to the user's reviews
+type AccountDeletion struct {
+	ID                string    `json:"id"`
+	UserID            string    `json:"userId"`
+	Reviews           string    `json:"reviews"`
+	ReviewsDeleted    int       `json:"reviewsDeleted"`
+	ReviewsAnonymized int       `json:"reviewsAnonymized"`
+	RepliesDeleted    int       `json:"repliesDeleted"` // sellers' replies deleted with the reviews
+	ProductIDs        []string  `json:"productIds"`     // whose ratings were recomputed
+	CreatedAt         time.Time `json:"createdAt"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// AddOn is a paid extra admins offer at checkout, like gift wrapping or a
+// greeting card. Add-ons aren't products: they take no stock and belong to
+// no seller.
+type AddOn struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Price       money.Money `json:"price"`
+	MaxQuantity int         `json:"maxQuantity"` // the most an order may have
+	IsActive    bool        `json:"isActive"`    // offered at checkout
+	CreatedAt   time.Time   `json:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// AddOnInput represents the payload for creating or replacing an add-on.
+// IsActive is true when omitted.
+type AddOnInput struct {
+	Name        string      `json:"name" validate:"required,max=100"`
+	Description string      `json:"description" validate:"max=500"`
+	Price       money.Money `json:"price"`
+	MaxQuantity int         `json:"maxQuantity" validate:"gte=0,max=100"` // 0 means 1
+	IsActive    *bool       `json:"isActive"`
+}
+
+// OrderAddOnInput is an add-on to order at checkout
+type OrderAddOnInput struct {
+	AddOnID  string `json:"addOnId" validate:"required,uuid"`
+	Quantity int    `json:"quantity" validate:"gte=0"` // 0 means 1
+}
+
+// OrderAddOn is an add-on on an order, named and priced as it was ordered
+type OrderAddOn struct {
+	ID         string      `json:"id,omitempty" xml:"id,omitempty"` // empty on quotes
+	AddOnID    string      `json:"addOnId" xml:"addOnId"`
+	Name       string      `json:"name" xml:"name"`
+	Quantity   int         `json:"quantity" xml:"quantity"`
+	Price      money.Money `json:"price" xml:"price"`
+	TotalPrice money.Money `json:"totalPrice" xml:"totalPrice"`
+	Refunded   money.Money `json:"refunded" xml:"refunded"`
+}
+
+// QuoteInput represents the optional payload of a quote: the add-ons
+// checkout would order and the address it would ship to, which the quote is
+// taxed for
+type QuoteInput struct {
+	AddOns          []OrderAddOnInput `json:"addOns" validate:"max=10,unique=AddOnID,dive"`
+	ShippingAddress json.RawMessage   `json:"shippingAddress"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// System alert severities
+const (
+	AlertInfo     = "info"
+	AlertWarning  = "warning"
+	AlertCritical = "critical" // also sent to the ops channel
+)
+
+// SystemAlert is an operational problem raised for admins. Occurrences
+// counts how many times its condition was raised while it was open.
+type SystemAlert struct {
+	ID             string          `json:"id"`
+	Severity       string          `json:"severity"`
+	Source         string          `json:"source"`
+	DedupeKey      string          `json:"dedupeKey"`
+	Title          string          `json:"title"`
+	Message        string          `json:"message"`
+	Details        json.RawMessage `json:"details"`
+	Occurrences    int             `json:"occurrences"`
+	FirstSeenAt    time.Time       `json:"firstSeenAt"`
+	LastSeenAt     time.Time       `json:"lastSeenAt"`
+	AcknowledgedAt *time.Time      `json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy *string         `json:"acknowledgedBy,omitempty"`
+}
+
+// AlertInput is a condition raised as a system alert. Alerts with the same
+// DedupeKey are the same condition.
+type AlertInput struct {
+	Severity  string
+	Source    string
+	DedupeKey string
+	Title     string
+	Message   string
+	Details   interface{}
+}
+
+// SystemAlertPage is a page of system alerts, most recently seen first
+type SystemAlertPage struct {
+	Alerts []SystemAlert `json:"alerts"`
+	Total  int           `json:"total"`
+}
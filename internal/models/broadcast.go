@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Broadcast audiences
+const (
+	BroadcastAll  = "all"
+	BroadcastRole = "role"
+	BroadcastPlan = "plan"
+)
+
+// Broadcast statuses
+const (
+	BroadcastQueued    = "queued"
+	BroadcastSending   = "sending"
+	BroadcastCompleted = "completed"
+)
+
+// BroadcastInput represents an announcement to notify a segment of users of.
+// {{name}} in Title and Message is replaced by each user's name. Key
+// identifies the broadcast, so posting it again sends nothing more.
+type BroadcastInput struct {
+	Key         string `json:"key" validate:"required,max=100"`
+	Audience    string `json:"audience" validate:"required,oneof=all role plan"`
+	Role        string `json:"role" validate:"required_if=Audience role,omitempty,oneof=user seller admin support"`
+	Plan        string `json:"plan" validate:"required_if=Audience plan,omitempty,oneof=free pro business"`
+	Title       string `json:"title" validate:"required,max=200"`
+	Message     string `json:"message" validate:"required,max=2000"`
+	Promotional bool   `json:"promotional"` // only sent to users accepting marketing
+}
+
+// Broadcast is an announcement and how far sending it has got
+type Broadcast struct {
+	ID            string     `json:"id"`
+	Key           string     `json:"key"`
+	CreatedBy     *string    `json:"createdBy"`
+	Audience      string     `json:"audience"`
+	AudienceValue string     `json:"audienceValue,omitempty"`
+	Title         string     `json:"title"`
+	Message       string     `json:"message"`
+	Promotional   bool       `json:"promotional"`
+	Status        string     `json:"status"`
+	Recipients    int        `json:"recipients"` // users in the segment when it was created
+	Sent          int        `json:"sent"`
+	Skipped       int        `json:"skipped"` // opted out of promotions
+	CreatedAt     time.Time  `json:"createdAt"`
+	CompletedAt   *time.Time `json:"completedAt,omitempty"`
+}
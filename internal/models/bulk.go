@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Bulk job kinds
+const BulkStockAdjustment = "stock_adjustment"
+
+// Bulk job statuses
+const (
+	BulkJobQueued    = "queued"
+	BulkJobSucceeded = "succeeded"
+	BulkJobFailed    = "failed"
+)
+
+// BulkJob is a bulk request too large to apply during the request, applied
+// by a background job. Once it has succeeded, Result holds the response the
+// request would have had; when it failed, Errors holds the offending items
+// as a validation error's fields.
+type BulkJob struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Status     string          `json:"status"`
+	Items      int             `json:"items"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Errors     json.RawMessage `json:"errors,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Cart represents a user's shopping cart. Its totals are in the currency of
+// its first line; lines priced in another currency are unavailable and not
+// counted. Its discount is the applied coupon's, when Coupon is valid.
+type Cart struct {
+	Items  []CartItem        `json:"items"`
+	Coupon *CouponValidation `json:"coupon,omitempty"` // the applied coupon, checked as checkout would check it
+	Totals
+}
+
+// CartItem is a product line in a cart. A bundle stays a single line; its
+// components are listed with their quantities for the whole line.
+type CartItem struct {
+	ProductID     string            `json:"productId"`
+	Title         string            `json:"title"`
+	Type          string            `json:"type"`
+	UnitType      string            `json:"unitType"`
+	Price         money.Money       `json:"price"`            // per kg when sold by weight
+	Quantity      int               `json:"quantity"`         // in grams when sold by weight
+	Weight        *Weight           `json:"weight,omitempty"` // the quantity in kg, when sold by weight
+	LineTotal     money.Money       `json:"lineTotal"`
+	StockQuantity int               `json:"stockQuantity"`           // less what other carts hold
+	IsAvailable   bool              `json:"isAvailable"`             // listed, in stock, within the quantity rules and in the cart's currency
+	AvailableFrom *time.Time        `json:"availableFrom,omitempty"` // set on preorder, which needs no stock
+	Components    []BundleComponent `json:"components,omitempty"`
+}
+
+// CartSummary is a cart's counts and totals without its lines, for showing
+// in a header badge. It agrees with the Cart's totals.
+type CartSummary struct {
+	ItemCount    int         `json:"itemCount"`    // the quantities of every line, a line sold by weight counting once
+	ProductCount int         `json:"productCount"` // distinct products, one per line
+	Subtotal     money.Money `json:"subtotal"`
+	Discount     money.Money `json:"discount"`
+	Total        money.Money `json:"estimatedTotal"` // before shipping and anything checkout adds
+}
+
+// CartSavings is what checking out a cart now would save against its
+// products' regular prices, broken down by source as the order's Discounts
+// would be. Total is Sale plus Order, and never negative.
+type CartSavings struct {
+	DiscountBreakdown
+	Total money.Money `json:"total"`
+}
+
+// CartItemInput represents the payload for adding a product to the cart.
+// Products sold by weight take a Weight instead of a Quantity.
+type CartItemInput struct {
+	ProductID string  `json:"productId" validate:"required,uuid"`
+	Quantity  int     `json:"quantity" validate:"required_without=Weight,omitempty,gte=1,lte=100"`
+	Weight    *Weight `json:"weight" validate:"omitempty,gt=0"` // in kg
+}
+
+// Reasons a wishlist item is skipped when the wishlist is moved to the cart
+const (
+	WishlistSkipUnavailable = "unavailable"       // unlisted or deleted
+	WishlistSkipOutOfStock  = "out_of_stock"      // too little stock for the minimum order quantity
+	WishlistSkipInCart      = "already_in_cart"   // the cart already has a line of the product
+	WishlistSkipCurrency    = "currency_mismatch" // priced in another currency than the cart
+)
+
+// WishlistMove is the result of moving a wishlist into the cart
+type WishlistMove struct {
+	Cart    *Cart                 `json:"cart"`
+	Added   []string              `json:"added"` // product IDs
+	Skipped []WishlistSkippedItem `json:"skipped"`
+	Cleared bool                  `json:"cleared"` // added items were removed from the wishlist
+}
+
+// WishlistSkippedItem is a wishlist item that was not added to the cart
+type WishlistSkippedItem struct {
+	ProductID string `json:"productId"`
+	Title     string `json:"title"`
+	Reason    string `json:"reason"`
+}
+
+// WishlistBulkInput represents the payload for adding many products to the
+// wishlist at once
+type WishlistBulkInput struct {
+	Items []WishlistItemInput `json:"items" validate:"required,min=1,max=100,dive"`
+}
+
+// WishlistItemInput is a product to add to the wishlist. With a TargetPrice,
+// price drops notify only once the price is at or below it.
+type WishlistItemInput struct {
+	ProductID   string       `json:"productId" validate:"required,uuid"`
+	TargetPrice *money.Money `json:"targetPrice"` // in the product's currency
+}
+
+// WishlistBulkResult is the result of adding many products to the wishlist
+type WishlistBulkResult struct {
+	Added          []string `json:"added"`          // product IDs
+	AlreadyPresent []string `json:"alreadyPresent"` // already on the wishlist, left as they were
+	NotFound       []string `json:"notFound"`       // deleted, unlisted or unknown
+}
+
+// Statuses of an exported wishlist item
+const (
+	WishlistItemAvailable   = "available"
+	WishlistItemOutOfStock  = "out_of_stock"
+	WishlistItemUnavailable = "unavailable" // unlisted, or its seller suspended
+	WishlistItemDeleted     = "deleted"
+)
+
+// Wishlist represents a user's wishlist, newest item first
+type Wishlist struct {
+	Items []WishlistItem `json:"items"`
+}
+
+// WishlistItem is a product on a wishlist, priced as charged now. AddedPrice
+// is what it was charged when added, kept as it was; PriceChange and
+// PriceChangePercent compare the two, negative when the price dropped, and
+// are left out when there is no added price or the currency has changed.
+type WishlistItem struct {
+	ProductID          string       `json:"productId"`
+	Title              string       `json:"title"`
+	Price              money.Money  `json:"price"`
+	AddedPrice         *money.Money `json:"addedPrice,omitempty"`
+	PriceChange        *money.Money `json:"priceChange,omitempty"`
+	PriceChangePercent *float64     `json:"priceChangePercent,omitempty"` // of AddedPrice, to two decimals
+	TargetPrice        *money.Money `json:"targetPrice,omitempty"`
+	Status             string       `json:"status"` // as for WishlistExportItem
+	AddedAt            time.Time    `json:"addedAt"`
+}
+
+// WishlistExportItem is a wishlist item as exported, priced as charged now
+type WishlistExportItem struct {
+	ProductID   string       `json:"productId"`
+	Name        string       `json:"name"`
+	Price       money.Money  `json:"price"`
+	TargetPrice *money.Money `json:"targetPrice,omitempty"`
+	Status      string       `json:"status"`
+	ProductURL  string       `json:"productUrl"`
+	AddedAt     time.Time    `json:"addedAt"`
+}
+
+// CartQuantityInput represents the payload for changing a cart line's
+// quantity, or its weight when sold by weight
+type CartQuantityInput struct {
+	Quantity int     `json:"quantity" validate:"required_without=Weight,omitempty,gte=1,lte=100"`
+	Weight   *Weight `json:"weight" validate:"omitempty,gt=0"`
+}
+
+// GuestCartItem is a line of a guest's cart, as their client kept it. Price
+// is the price the guest was shown, if given, to flag a change.
+type GuestCartItem struct {
+	CartItemInput
+	Price *money.Money `json:"price"`
+}
+
+// CartMergeInput represents a guest's cart to merge into the signed-in
+// user's cart. GuestCartToken is the ID the guest's client gave the cart;
+// a token is merged once.
+type CartMergeInput struct {
+	GuestCartToken string          `json:"guestCartToken" validate:"required,uuid"`
+	Items          []GuestCartItem `json:"items" validate:"max=100,dive"`
+}
+
+// Reasons a guest cart's line isn't merged into the user's cart
+const (
+	CartMergeSkipUnavailable   = "unavailable"       // unlisted or deleted
+	CartMergeSkipOutOfStock    = "out_of_stock"      // too little stock for even the minimum order quantity
+	CartMergeSkipQuantityRules = "quantity_rules"    // no quantity up to the merged one meets the product's rules
+	CartMergeSkipCurrency      = "currency_mismatch" // priced in another currency than the cart
+)
+
+// CartMerge is the result of merging a guest's cart into the user's cart.
+// When the token was merged before, nothing is merged again and
+// AlreadyMerged is set.
+type CartMerge struct {
+	Cart          *Cart           `json:"cart"`
+	Merged        []CartMergeItem `json:"merged"`
+	Unavailable   []CartMergeItem `json:"unavailable"`
+	AlreadyMerged bool            `json:"alreadyMerged"`
+}
+
+// CartMergeItem is a guest cart's line merged into the user's cart, or not.
+// Quantity is the cart line's quantity after the merge.
+type CartMergeItem struct {
+	ProductID    string       `json:"productId"`
+	Title        string       `json:"title,omitempty"`
+	Quantity     int          `json:"quantity"`         // in grams when sold by weight
+	Weight       *Weight      `json:"weight,omitempty"` // the quantity in kg, when sold by weight
+	Price        *money.Money `json:"price,omitempty"`  // unset when the product is no longer listed
+	PriceChanged bool         `json:"priceChanged"`     // from the price the guest was shown
+	Reduced      bool         `json:"reduced"`          // less than the two lines together, to stay in stock and within the rules
+	Reason       string       `json:"reason,omitempty"`
+	Message      string       `json:"message,omitempty"`
+}
+
+// Reasons a past order's item isn't put back in the cart on reorder
+const (
+	ReorderSkipUnavailable   = "unavailable"       // unlisted or deleted
+	ReorderSkipOutOfStock    = "out_of_stock"      // too little stock for the quantity, with what the cart has
+	ReorderSkipQuantityRules = "quantity_rules"    // the quantity breaks the product's current order quantity rules
+	ReorderSkipCurrency      = "currency_mismatch" // priced in another currency than the cart
+)
+
+// Reorder is the result of putting a past order's items back in the cart
+type Reorder struct {
+	Cart        *Cart         `json:"cart"`
+	Added       []ReorderItem `json:"added"`
+	Unavailable []ReorderItem `json:"unavailable"`
+}
+
+// ReorderItem is an item of a past order put back in the cart, or not. Price
+// is the product's current price, which is what the cart charges.
+type ReorderItem struct {
+	ProductID    string       `json:"productId"`
+	Title        string       `json:"title"`
+	Quantity     int          `json:"quantity"`         // in grams when sold by weight
+	Weight       *Weight      `json:"weight,omitempty"` // the quantity in kg, when sold by weight
+	OrderedPrice money.Money  `json:"orderedPrice"`
+	Price        *money.Money `json:"price,omitempty"` // unset when the product is no longer listed
+	PriceChanged bool         `json:"priceChanged"`
+	Reason       string       `json:"reason,omitempty"`
+	Message      string       `json:"message,omitempty"`
+}
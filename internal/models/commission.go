@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// CommissionRateInput represents an admin setting a commission rate, for a
+// seller, a category or, with neither, the default. The rate applies from
+// EffectiveFrom, now when unset.
+type CommissionRateInput struct {
+	SellerID      string     `json:"sellerId" validate:"omitempty,uuid,excluded_with=CategoryID"`
+	CategoryID    string     `json:"categoryId" validate:"omitempty,uuid"`
+	RateBps       *int       `json:"rateBps" validate:"required,min=0,max=10000"` // basis points of the sale
+	EffectiveFrom *time.Time `json:"effectiveFrom"`
+}
+
+// CommissionRate is a commission rate in a seller's, a category's or the
+// default history
+type CommissionRate struct {
+	ID            string    `json:"id"`
+	SellerID      *string   `json:"sellerId"`
+	CategoryID    *string   `json:"categoryId"`
+	RateBps       int       `json:"rateBps"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	InEffect      bool      `json:"inEffect"` // the rate its scope applies now
+	CreatedBy     *string   `json:"createdBy"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// CommissionRateFilter selects the rates listed: a seller's, a category's,
+// or the default ones when neither is set
+type CommissionRateFilter struct {
+	SellerID   string
+	CategoryID string
+	Limit      int
+	Offset     int
+}
+
+// CommissionRatePage is a page of commission rates, latest effective first
+type CommissionRatePage struct {
+	Rates []CommissionRate `json:"rates"`
+	Total int              `json:"total"`
+}
+
+// PayoutTotals are a seller's sub-orders in one currency and payout status.
+// Gross is their totals less refunds, Commission the commission snapshotted
+// at checkout less its share of the refunds, and Net what is paid out.
+type PayoutTotals struct {
+	Currency   string      `json:"currency"`
+	Status     string      `json:"status"`
+	SubOrders  int         `json:"subOrders"`
+	Gross      money.Money `json:"gross"`
+	Commission money.Money `json:"commission"`
+	Net        money.Money `json:"net"`
+}
+
+// PayoutSummary breaks a seller's payouts down by currency and status
+type PayoutSummary struct {
+	SellerID string         `json:"sellerId"`
+	Payouts  []PayoutTotals `json:"payouts"`
+}
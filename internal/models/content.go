@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ContentBlock is a piece of merchandising content, such as a homepage
+// banner, for the slot named by its key in one locale. It is shown from
+// StartsAt until EndsAt; either may be open.
+type ContentBlock struct {
+	ID        string          `json:"id"`
+	Key       string          `json:"key"`
+	Locale    string          `json:"locale"`
+	StartsAt  *time.Time      `json:"startsAt,omitempty"`
+	EndsAt    *time.Time      `json:"endsAt,omitempty"`
+	Payload   json.RawMessage `json:"payload"` // shaped by the key's schema
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// ActiveAt reports whether the block is shown at t
+func (b *ContentBlock) ActiveAt(t time.Time) bool {
+	return (b.StartsAt == nil || !t.Before(*b.StartsAt)) && (b.EndsAt == nil || t.Before(*b.EndsAt))
+}
+
+// ContentBlockInput represents the payload for creating or replacing a
+// content block
+type ContentBlockInput struct {
+	Key      string          `json:"key" validate:"required,max=100"`
+	Locale   string          `json:"locale" validate:"required,max=20,bcp47_language_tag"`
+	StartsAt *time.Time      `json:"startsAt"`
+	EndsAt   *time.Time      `json:"endsAt"`
+	Payload  json.RawMessage `json:"payload" validate:"required"`
+}
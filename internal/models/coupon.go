@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Coupon is a code admins hand out that takes PercentOff percent or
+// AmountOff off the cart lines it applies to: lines of ProductIDs or in
+// CategoryIDs, or every line when both are empty. The cart must be in
+// Currency and spend at least MinSpend on those lines.
+type Coupon struct {
+	ID            string       `json:"id"`
+	Code          string       `json:"code"`
+	PercentOff    *int         `json:"percentOff,omitempty"`
+	AmountOff     *money.Money `json:"amountOff,omitempty"`
+	Currency      string       `json:"currency"`
+	MinSpend      money.Money  `json:"minSpend"`
+	ProductIDs    []string     `json:"productIds"`
+	CategoryIDs   []string     `json:"categoryIds"`
+	UsageLimit    *int         `json:"usageLimit,omitempty"` // orders it may be used on, unlimited when unset
+	TimesUsed     int          `json:"timesUsed"`
+	StartsAt      *time.Time   `json:"startsAt,omitempty"`
+	ExpiresAt     *time.Time   `json:"expiresAt,omitempty"`
+	DeactivatedAt *time.Time   `json:"deactivatedAt,omitempty"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+}
+
+// CouponInput represents the payload for creating a coupon. It takes either
+// PercentOff or AmountOff; AmountOff and MinSpend are in Currency.
+type CouponInput struct {
+	Code        string       `json:"code" validate:"required,min=3,max=32,alphanum"`
+	PercentOff  *int         `json:"percentOff" validate:"required_without=AmountOff,excluded_with=AmountOff,omitempty,gte=1,lte=100"`
+	AmountOff   *money.Money `json:"amountOff" validate:"required_without=PercentOff"`
+	Currency    string       `json:"currency" validate:"required,len=3"`
+	MinSpend    *money.Money `json:"minSpend"`
+	ProductIDs  []string     `json:"productIds" validate:"max=100,dive,uuid"`
+	CategoryIDs []string     `json:"categoryIds" validate:"max=100,dive,uuid"`
+	UsageLimit  *int         `json:"usageLimit" validate:"omitempty,gte=1"`
+	StartsAt    *time.Time   `json:"startsAt"`
+	ExpiresAt   *time.Time   `json:"expiresAt"`
+}
+
+// CouponCodeInput represents the payload for applying a coupon to the cart
+// or checking one against it
+type CouponCodeInput struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// Reasons a coupon doesn't apply to a cart
+const (
+	CouponNotFound      = "not_found"           // unknown or deactivated
+	CouponNotStarted    = "not_started"         // before its start
+	CouponExpired       = "expired"             // at or after its expiry
+	CouponUsageLimit    = "usage_limit_reached" // used on as many orders as it may be
+	CouponNotApplicable = "not_applicable"      // no line it applies to, or a cart in another currency
+	CouponMinSpend      = "min_spend_not_met"   // too little spent on the lines it applies to
+)
+
+// CouponValidation is a coupon checked against a cart as checkout would
+// check it. Discount is what it takes off the cart, zero unless Valid;
+// Reason says why it isn't.
+type CouponValidation struct {
+	Code     string      `json:"code" xml:"code"`
+	Valid    bool        `json:"valid" xml:"valid"`
+	Discount money.Money `json:"discount" xml:"discount"`
+	Reason   string      `json:"reason,omitempty" xml:"reason,omitempty"`
+	Message  string      `json:"message,omitempty" xml:"message,omitempty"`
+}
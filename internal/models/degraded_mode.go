@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// DegradedMode represents whether non-essential features are switched off
+type DegradedMode struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message"`
+	RetryAfter int        `json:"retryAfter"` // in seconds
+	Source     string     `json:"source"`     // config, or admin when overridden at runtime
+	UpdatedBy  string     `json:"updatedBy,omitempty"`
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
+}
+
+// DegradedModeInput represents the payload for overriding degraded mode
+type DegradedModeInput struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message" validate:"max=500"`
+	RetryAfter int    `json:"retryAfter" validate:"gte=0,lte=86400"`
+}
@@ -0,0 +1,23 @@
+package models
+
+// DeliveryEstimate is when a product or cart is expected to arrive at a
+// postal code: when its last shipment does. Dates are YYYY-MM-DD.
+type DeliveryEstimate struct {
+	PostalCode   string             `json:"postalCode,omitempty"`
+	EarliestDate string             `json:"earliestDate"`
+	LatestDate   string             `json:"latestDate"`
+	IsDefault    bool               `json:"isDefault"` // a shipment's transit time is the default, as for unknown postal codes
+	Shipments    []ShipmentEstimate `json:"shipments"`
+}
+
+// ShipmentEstimate is when the products shipped together from a warehouse
+// are expected to arrive
+type ShipmentEstimate struct {
+	Warehouse      string   `json:"warehouse"`
+	Zone           string   `json:"zone,omitempty"` // empty when the postal code didn't resolve to a zone
+	ProductIDs     []string `json:"productIds"`
+	ProcessingDays int      `json:"processingDays"` // business days before the shipment leaves, the longest of its products
+	EarliestDate   string   `json:"earliestDate"`
+	LatestDate     string   `json:"latestDate"`
+	IsDefault      bool     `json:"isDefault"`
+}
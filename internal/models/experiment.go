@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ExperimentResults summarizes exposure and conversion events for an experiment
+type ExperimentResults struct {
+	Experiment string          `json:"experiment"`
+	Since      time.Time       `json:"since"`
+	Variants   []VariantResult `json:"variants"`
+}
+
+// VariantResult summarizes events for one variant of an experiment
+type VariantResult struct {
+	Variant        string  `json:"variant"`
+	ExposedUsers   int     `json:"exposedUsers"`
+	ConvertedUsers int     `json:"convertedUsers"`
+	Conversions    int     `json:"conversions"`
+	ConversionRate float64 `json:"conversionRate"` // converted users / exposed users
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// FeatureFlag represents a runtime toggle for a feature
+type FeatureFlag struct {
+	Key               string    `json:"key"`
+	Description       string    `json:"description"`
+	Enabled           bool      `json:"enabled"`
+	RolloutPercentage int       `json:"rolloutPercentage"` // 0-100, share of users the flag is enabled for
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// FeatureFlagInput represents the payload for creating or updating a feature flag
+type FeatureFlagInput struct {
+	Description       *string `json:"description"`
+	Enabled           *bool   `json:"enabled"`
+	RolloutPercentage *int    `json:"rolloutPercentage"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// OrderStatusReview is the status of an order held for fraud review
+const OrderStatusReview = "review"
+
+// Fraud review actions
+const (
+	FraudReviewRelease = "release"
+	FraudReviewCancel  = "cancel"
+)
+
+// FraudReviewInput represents an admin's decision on an order held for
+// fraud review. The note is only kept with the audit entry.
+type FraudReviewInput struct {
+	Action string `json:"action" validate:"required,oneof=release cancel"`
+	Note   string `json:"note" validate:"max=1000"`
+}
+
+// OrderFraud is an order's latest fraud score, the rules behind it, and
+// the admin decisions on it while it was held
+type OrderFraud struct {
+	OrderID    string             `json:"orderId"`
+	Status     string             `json:"status"`
+	Score      *int               `json:"score"` // null when the order was never scored
+	Reasons    []string           `json:"reasons"`
+	HeldAt     *time.Time         `json:"heldAt"`
+	ReleasedAt *time.Time         `json:"releasedAt"`
+	Reviews    []OrderFraudReview `json:"reviews"`
+}
+
+// OrderFraudReview is the audit entry of an admin's decision on a held
+// order, with the score and reasons it was held for
+type OrderFraudReview struct {
+	ID        string    `json:"id"`
+	AdminID   *string   `json:"adminId"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note,omitempty"`
+	Score     int       `json:"score"`
+	Reasons   []string  `json:"reasons"`
+	CreatedAt time.Time `json:"createdAt"`
+}
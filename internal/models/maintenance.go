@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Maintenance window statuses, at the time a window is read
+const (
+	MaintenanceScheduled = "scheduled" // before its notice period
+	MaintenanceNotice    = "notice"    // announced, starting soon
+	MaintenanceActive    = "active"
+	MaintenanceEnded     = "ended"
+	MaintenanceCancelled = "cancelled"
+)
+
+// MaintenanceWindow is scheduled downtime. From NoticeAt requests are warned
+// of it and its banner shows; from StartsAt until EndsAt the API is down.
+type MaintenanceWindow struct {
+	ID            string     `json:"id"`
+	StartsAt      time.Time  `json:"startsAt"`
+	EndsAt        time.Time  `json:"endsAt"`
+	NoticeAt      time.Time  `json:"noticeAt"`
+	Message       string     `json:"message"`
+	Status        string     `json:"status"`
+	BannerBlockID *string    `json:"bannerBlockId,omitempty"` // its announcement_bar content block
+	CreatedBy     *string    `json:"createdBy,omitempty"`
+	CancelledAt   *time.Time `json:"cancelledAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// StatusAt returns the window's status at t
+func (m *MaintenanceWindow) StatusAt(t time.Time) string {
+	switch {
+	case m.CancelledAt != nil:
+		return MaintenanceCancelled
+	case !t.Before(m.EndsAt):
+		return MaintenanceEnded
+	case !t.Before(m.StartsAt):
+		return MaintenanceActive
+	case !t.Before(m.NoticeAt):
+		return MaintenanceNotice
+	}
+	return MaintenanceScheduled
+}
+
+// MaintenanceWindowInput represents the payload for scheduling or updating
+// a maintenance window. NoticeMinutes defaults to 60.
+type MaintenanceWindowInput struct {
+	StartsAt      time.Time `json:"startsAt" validate:"required"`
+	EndsAt        time.Time `json:"endsAt" validate:"required,gtfield=StartsAt"`
+	NoticeMinutes *int      `json:"noticeMinutes" validate:"omitempty,gte=0,lte=10080"`
+	Message       string    `json:"message" validate:"required,max=200"`
+}
+
+// MaintenanceWindowPage is a page of maintenance windows, latest start first
+type MaintenanceWindowPage struct {
+	Windows []MaintenanceWindow `json:"windows"`
+	Total   int                 `json:"total"`
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+var moneyType = reflect.TypeOf(money.Money{})
+
+// setMoney sets every money field reachable through v's exported fields to
+// m, giving empty slices a line and nil pointers a value so nested amounts
+// are set too
+func setMoney(v reflect.Value, m money.Money, depth int) {
+	if depth > 4 {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.Type().Elem() != moneyType && v.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		setMoney(v.Elem(), m, depth+1)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		if v.Len() == 0 {
+			v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		}
+		for i := 0; i < v.Len(); i++ {
+			setMoney(v.Index(i), m, depth+1)
+		}
+	case reflect.Struct:
+		if v.Type() == moneyType {
+			v.Set(reflect.ValueOf(m))
+			return
+		}
+		if v.Type() == timeType {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				setMoney(v.Field(i), m, depth)
+			}
+		}
+	}
+}
+
+// moneyAmounts collects the amount of every {"amount", "currency"} object in
+// a decoded JSON document
+func moneyAmounts(v any, found *[]any) {
+	switch v := v.(type) {
+	case map[string]any:
+		if _, ok := v["currency"]; ok {
+			if amount, ok := v["amount"]; ok {
+				*found = append(*found, amount)
+			}
+		}
+		for _, e := range v {
+			moneyAmounts(e, found)
+		}
+	case []any:
+		for _, e := range v {
+			moneyAmounts(e, found)
+		}
+	}
+}
+
+func TestMoneyFieldsMarshalAsFixedPrecisionStrings(t *testing.T) {
+	amounts := []struct {
+		money money.Money
+		want  string
+	}{
+		{money.New(999, "USD"), "9.99"},
+		{money.New(10, "USD"), "0.10"},
+		{money.New(1, "EUR"), "0.01"},
+		{money.New(100000, "EUR"), "1000.00"},
+		{money.New(-5, "USD"), "-0.05"},
+		{money.New(1000, "JPY"), "1000"},
+	}
+	responses := map[string]func() any{
+		"product": func() any { return &Product{} },
+		"cart":    func() any { return &Cart{} },
+		"order":   func() any { return &Order{} },
+	}
+
+	for name, newResponse := range responses {
+		for _, a := range amounts {
+			response := newResponse()
+			setMoney(reflect.ValueOf(response), a.money, 0)
+
+			body, err := json.Marshal(response)
+			if err != nil {
+				t.Fatalf("%s: marshal: %v", name, err)
+			}
+			var decoded any
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				t.Fatalf("%s: unmarshal: %v", name, err)
+			}
+			var found []any
+			moneyAmounts(decoded, &found)
+			if len(found) == 0 {
+				t.Fatalf("%s: no money fields in %s", name, body)
+			}
+			for _, amount := range found {
+				s, ok := amount.(string)
+				if !ok {
+					t.Errorf("%s: amount %v is a %T, want a string", name, amount, amount)
+					continue
+				}
+				if s != a.want {
+					t.Errorf("%s: amount of %s = %q, want %q", name, a.money, s, a.want)
+				}
+			}
+		}
+	}
+}
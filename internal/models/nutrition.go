@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/xml"
+	"slices"
+)
+
+// Allergens are the allergens a product's nutrition can declare, and listings
+// can be filtered to exclude
+var Allergens = []string{
+	"celery", "crustaceans", "eggs", "fish", "gluten", "lupin", "milk", "molluscs",
+	"mustard", "nuts", "peanuts", "sesame", "soy", "sulphites",
+}
+
+// Nutrition is a product's nutrition facts. Calories and nutrients are per
+// serving of ServingSize ServingUnit, nutrients in grams and calories in
+// kcal. Allergens must list every allergen the product contains, and be
+// given empty to declare none.
+type Nutrition struct {
+	ServingSize   float64  `json:"servingSize" xml:"servingSize" validate:"gt=0,lte=5000"`
+	ServingUnit   string   `json:"servingUnit" xml:"servingUnit" validate:"required,oneof=g ml"`
+	Calories      float64  `json:"calories" xml:"calories" validate:"gte=0,lte=10000"`
+	Fat           *float64 `json:"fat,omitempty" xml:"fat,omitempty" validate:"omitempty,gte=0"`
+	SaturatedFat  *float64 `json:"saturatedFat,omitempty" xml:"saturatedFat,omitempty" validate:"omitempty,gte=0"`
+	Carbohydrates *float64 `json:"carbohydrates,omitempty" xml:"carbohydrates,omitempty" validate:"omitempty,gte=0"`
+	Sugars        *float64 `json:"sugars,omitempty" xml:"sugars,omitempty" validate:"omitempty,gte=0"`
+	Fiber         *float64 `json:"fiber,omitempty" xml:"fiber,omitempty" validate:"omitempty,gte=0"`
+	Protein       *float64 `json:"protein,omitempty" xml:"protein,omitempty" validate:"omitempty,gte=0"`
+	Salt          *float64 `json:"salt,omitempty" xml:"salt,omitempty" validate:"omitempty,gte=0"`
+	Allergens     []string `json:"allergens" xml:"allergens>allergen" validate:"required,max=14,unique,dive,required"`
+	Ingredients   []string `json:"ingredients" xml:"ingredients>ingredient" validate:"max=100,dive,required,max=200"` // in descending order of weight
+}
+
+// Clone returns a deep copy of n, or nil
+func (n *Nutrition) Clone() *Nutrition {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	c.Fat = clonePtr(n.Fat)
+	c.SaturatedFat = clonePtr(n.SaturatedFat)
+	c.Carbohydrates = clonePtr(n.Carbohydrates)
+	c.Sugars = clonePtr(n.Sugars)
+	c.Fiber = clonePtr(n.Fiber)
+	c.Protein = clonePtr(n.Protein)
+	c.Salt = clonePtr(n.Salt)
+	c.Allergens = slices.Clone(n.Allergens)
+	c.Ingredients = slices.Clone(n.Ingredients)
+	return &c
+}
+
+// ProductNutrition is a product's nutrition facts on their own
+type ProductNutrition struct {
+	XMLName   xml.Name   `json:"-" xml:"productNutrition"`
+	ProductID string     `json:"productId" xml:"productId"`
+	Title     string     `json:"title" xml:"title"`
+	Nutrition *Nutrition `json:"nutrition" xml:"nutrition"`
+}
@@ -0,0 +1,507 @@
+package models
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Order note visibilities
+const (
+	NoteVisibilityCustomer = "customer" // shown to the buyer and sellers on the order
+	NoteVisibilityInternal = "internal" // staff only
+)
+
+// Order represents a buyer's order
+type Order struct {
+	XMLName       xml.Name `json:"-" xml:"order"`
+	ID            string   `json:"id" xml:"id"`
+	OrderNumber   int64    `json:"orderNumber" xml:"orderNumber"`
+	Reference     string   `json:"reference" xml:"reference"` // what customers and support quote, like GM-2024-000123
+	BuyerID       string   `json:"buyerId" xml:"buyerId"`
+	Status        string   `json:"status" xml:"status"`
+	PaymentStatus string   `json:"paymentStatus" xml:"paymentStatus"`
+	Totals
+	Discounts       DiscountBreakdown `json:"discounts" xml:"discounts"`
+	ShippingAddress json.RawMessage   `json:"shippingAddress,omitempty" xml:"shippingAddress,omitempty"`
+	ShippingMethod  string            `json:"shippingMethod,omitempty" xml:"shippingMethod,omitempty"` // empty on orders placed before shipping methods
+	PaymentMethod   string            `json:"paymentMethod,omitempty" xml:"paymentMethod,omitempty"`
+	PaymentMethodID string            `json:"paymentMethodId,omitempty" xml:"paymentMethodId,omitempty"`             // the stored card to charge
+	DeliveryDate    string            `json:"requestedDeliveryDate,omitempty" xml:"requestedDeliveryDate,omitempty"` // YYYY-MM-DD, when the buyer asked for one
+	IsGift          bool              `json:"isGift" xml:"isGift"`
+	Gift            *OrderGift        `json:"gift,omitempty" xml:"gift,omitempty"`
+	Items           []OrderItem       `json:"items" xml:"items>item"`
+	AddOns          []OrderAddOn      `json:"addOns" xml:"addOns>addOn"`
+	SubOrders       []SubOrder        `json:"subOrders,omitempty" xml:"subOrders>subOrder,omitempty"` // one per seller
+	Notes           []OrderNote       `json:"notes" xml:"notes>note"`
+	Delivery        *OrderDelivery    `json:"delivery,omitempty" xml:"delivery,omitempty"` // proof of delivery, once delivered with one
+	CreatedAt       time.Time         `json:"createdAt" xml:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt" xml:"updatedAt"`
+}
+
+// OrderDelivery is the proof an order was delivered. PhotoURL serves the
+// photo, if one was taken, to those who can view the order.
+type OrderDelivery struct {
+	DeliveredBy string    `json:"deliveredBy,omitempty" xml:"deliveredBy,omitempty"`
+	DeliveredAt time.Time `json:"deliveredAt" xml:"deliveredAt"`
+	PhotoURL    string    `json:"photoUrl,omitempty" xml:"photoUrl,omitempty"`
+	Signature   string    `json:"signature,omitempty" xml:"signature,omitempty"` // the name the recipient signed with
+	Notes       string    `json:"notes,omitempty" xml:"notes,omitempty"`
+}
+
+// DeliveryInput represents a proof of delivery. Photo is set by the handler
+// from the uploaded photo once it is stored.
+type DeliveryInput struct {
+	Signature string         `json:"signature" validate:"max=100"`
+	Notes     string         `json:"notes" validate:"max=500"`
+	Photo     *DeliveryPhoto `json:"-"`
+}
+
+// DeliveryPhoto is a stored photo proving delivery
+type DeliveryPhoto struct {
+	Key         string
+	ContentType string
+	Size        int64
+}
+
+// Totals break down what an order or cart costs, all in one currency. Total
+// is always exactly Subtotal - Discount + Tax + Shipping.
+type Totals struct {
+	Subtotal money.Money `json:"subtotal" xml:"subtotal"` // sum of the line totals, add-ons included
+	Discount money.Money `json:"discount" xml:"discount"`
+	Tax      money.Money `json:"tax" xml:"tax"`
+	Shipping money.Money `json:"shipping" xml:"shipping"` // an order's shipping cost, split over its sub-orders by subtotal
+	Total    money.Money `json:"total" xml:"total"`
+}
+
+// DiscountBreakdown accounts for what was taken off an order's regular
+// prices: RegularSubtotal less Sale is the order's Subtotal, and Order is
+// its Discount. The lines' sale discounts and discount shares sum to Sale
+// and Order exactly.
+type DiscountBreakdown struct {
+	RegularSubtotal money.Money `json:"regularSubtotal" xml:"regularSubtotal"` // the lines at their regular prices
+	Sale            money.Money `json:"sale" xml:"sale"`                       // sale prices below the regular prices
+	Order           money.Money `json:"order" xml:"order"`                     // order-level discounts, split over the lines
+}
+
+// OrderItem represents a product line on an order
+type OrderItem struct {
+	ID             string      `json:"id" xml:"id"`
+	ProductID      string      `json:"productId" xml:"productId"`
+	UnitType       string      `json:"unitType" xml:"unitType"`
+	Quantity       int         `json:"quantity" xml:"quantity"`                 // in grams when sold by weight
+	Weight         *Weight     `json:"weight,omitempty" xml:"weight,omitempty"` // the quantity in kg, when sold by weight
+	Price          money.Money `json:"price" xml:"price"`                       // per kg when sold by weight
+	TotalPrice     money.Money `json:"totalPrice" xml:"totalPrice"`
+	RegularPrice   money.Money `json:"regularPrice" xml:"regularPrice"`                   // the unit price before any sale
+	SaleDiscount   money.Money `json:"saleDiscount" xml:"saleDiscount"`                   // the line at RegularPrice less TotalPrice
+	Discount       money.Money `json:"discount" xml:"discount"`                           // the line's share of the order's discount, taken off TotalPrice
+	FulfilledAt    *time.Time  `json:"fulfilledAt,omitempty" xml:"fulfilledAt,omitempty"` // when the seller shipped it
+	Carrier        string      `json:"carrier,omitempty" xml:"carrier,omitempty"`
+	TrackingNumber string      `json:"trackingNumber,omitempty" xml:"trackingNumber,omitempty"`
+	SubOrderID     string      `json:"subOrderId,omitempty" xml:"subOrderId,omitempty"`
+	Backordered    bool        `json:"backordered" xml:"backordered"`                       // waiting for stock
+	Preorder       bool        `json:"preorder" xml:"preorder"`                             // ordered before the product was available; backordered until it is
+	BackorderETA   string      `json:"backorderEta,omitempty" xml:"backorderEta,omitempty"` // YYYY-MM-DD, while backordered
+	CancelledAt    *time.Time  `json:"cancelledAt,omitempty" xml:"cancelledAt,omitempty"`   // when a backorder that couldn't be supplied was refunded
+}
+
+// Sub-order payout statuses
+const (
+	PayoutPending   = "pending"   // not yet delivered
+	PayoutDue       = "due"       // delivered, owed to the seller
+	PayoutHeld      = "held"      // delivered, held until the seller is verified
+	PayoutCancelled = "cancelled" // cancelled or refunded in full
+)
+
+// SubOrder is one seller's part of an order, with its own status,
+// fulfillment and payout. An order's totals are the sums of its sub-orders'
+// and its add-ons'.
+type SubOrder struct {
+	ID       string `json:"id" xml:"id"`
+	SellerID string `json:"sellerId" xml:"sellerId"`
+	Status   string `json:"status" xml:"status"`
+	Totals
+	Refunded     money.Money `json:"refunded" xml:"refunded"`
+	PayoutStatus string      `json:"payoutStatus" xml:"payoutStatus"`
+	Commission   money.Money `json:"commission" xml:"commission"` // taken at checkout, less its share of refunds
+	Payout       money.Money `json:"payout" xml:"payout"`         // total less refunds and commission
+	ItemIDs      []string    `json:"itemIds" xml:"itemIds>itemId"`
+	CreatedAt    time.Time   `json:"createdAt" xml:"createdAt"`
+	UpdatedAt    time.Time   `json:"updatedAt" xml:"updatedAt"`
+}
+
+// RefundInput represents the payload for refunding a sub-order. Without an
+// amount everything not yet refunded is refunded.
+type RefundInput struct {
+	Amount *money.Money `json:"amount"`
+}
+
+// OrderQuote is what checking out the cart would order now. Unavailable
+// lines are left out of the totals, and Orderable is false while there are
+// any. Nothing is reserved, so stock can still run out before checkout.
+type OrderQuote struct {
+	XMLName   xml.Name `json:"-" xml:"orderQuote"`
+	Orderable bool     `json:"orderable" xml:"orderable"`
+	Totals
+	ShippingMethod  string            `json:"shippingMethod,omitempty" xml:"shippingMethod,omitempty"` // the method the totals ship by
+	ShippingMethods []ShippingOption  `json:"shippingMethods" xml:"shippingMethods>method"`            // every method the cart can ship by
+	Items           []QuoteItem       `json:"items" xml:"items>item"`
+	AddOns          []OrderAddOn      `json:"addOns" xml:"addOns>addOn"`
+	SubOrders       []QuoteSubOrder   `json:"subOrders" xml:"subOrders>subOrder"`      // one per seller with available lines
+	Charges         []QuoteCharge     `json:"charges" xml:"charges>charge"`            // the totals itemized
+	Coupon          *CouponValidation `json:"coupon,omitempty" xml:"coupon,omitempty"` // the cart's coupon; the quote isn't orderable when it isn't valid
+}
+
+// Kinds of quote charges
+const (
+	ChargeGoods    = "goods"    // a seller's available lines
+	ChargeDiscount = "discount" // taken off a seller's lines, so negative
+	ChargeAddOn    = "add_on"
+	ChargeShipping = "shipping"
+	ChargeTax      = "tax"
+)
+
+// QuoteCharge is a line of a quote's itemized totals. The amounts of a
+// quote's charges sum exactly to its total, as checkout would charge it. A
+// tax charge is a jurisdiction's tax at its rate, or, with a Reason, a zero
+// charge for an exemption: the buyer's in a jurisdiction, or a product's.
+type QuoteCharge struct {
+	Type         string      `json:"type" xml:"type"`
+	Label        string      `json:"label" xml:"label"`
+	SellerID     string      `json:"sellerId,omitempty" xml:"sellerId,omitempty"`
+	ProductID    string      `json:"productId,omitempty" xml:"productId,omitempty"`       // an exempt product
+	Jurisdiction string      `json:"jurisdiction,omitempty" xml:"jurisdiction,omitempty"` // the tax's jurisdiction code
+	Rate         string      `json:"rate,omitempty" xml:"rate,omitempty"`                 // the tax's rate in percent
+	Amount       money.Money `json:"amount" xml:"amount"`
+	Reason       string      `json:"reason,omitempty" xml:"reason,omitempty"` // the exemption of a zero tax charge
+}
+
+// QuoteItem is a cart line as checkout would order it. An unavailable line
+// has the validation code and message checkout would reject it with.
+type QuoteItem struct {
+	ProductID  string       `json:"productId" xml:"productId"`
+	SellerID   string       `json:"sellerId" xml:"sellerId"`
+	UnitType   string       `json:"unitType" xml:"unitType"`
+	Quantity   int          `json:"quantity" xml:"quantity"`                 // in grams when sold by weight
+	Weight     *Weight      `json:"weight,omitempty" xml:"weight,omitempty"` // the quantity in kg, when sold by weight
+	Price      money.Money  `json:"price" xml:"price"`                       // per kg when sold by weight
+	TotalPrice *money.Money `json:"totalPrice,omitempty" xml:"totalPrice,omitempty"`
+	Available  bool         `json:"available" xml:"available"`
+	Backorder  bool         `json:"backordered" xml:"backordered"` // short of stock, backordered at checkout with allowBackorder
+	Preorder   bool         `json:"preorder" xml:"preorder"`       // on preorder, backordered at checkout until available
+	Reason     string       `json:"reason,omitempty" xml:"reason,omitempty"`
+	Message    string       `json:"message,omitempty" xml:"message,omitempty"`
+}
+
+// QuoteSubOrder is a seller's part of a quote
+type QuoteSubOrder struct {
+	SellerID string `json:"sellerId" xml:"sellerId"`
+	Totals
+}
+
+// OrderGift is the recipient and message of a gift order. A gift ships to
+// the recipient at the order's shipping address.
+type OrderGift struct {
+	RecipientName   string `json:"recipientName" xml:"recipientName"`
+	RecipientEmail  string `json:"recipientEmail,omitempty" xml:"recipientEmail,omitempty"`
+	Message         string `json:"message,omitempty" xml:"message,omitempty"`
+	NotifyRecipient bool   `json:"notifyRecipient" xml:"notifyRecipient"` // status changes are also sent to RecipientEmail
+}
+
+// OrderInput represents the payload for checking out the cart as an order.
+// For a gift, ShippingAddress is the recipient's. ShippingMethod is standard
+// when empty.
+type OrderInput struct {
+	ShippingAddress json.RawMessage   `json:"shippingAddress" validate:"required"`
+	ShippingMethod  string            `json:"shippingMethod" validate:"omitempty,oneof=standard express pickup"`
+	PaymentMethod   string            `json:"paymentMethod" validate:"max=50"`
+	PaymentMethodID string            `json:"paymentMethodId" validate:"omitempty,uuid"` // a stored card of the buyer's to pay with
+	IsGift          bool              `json:"isGift"`
+	Gift            *GiftInput        `json:"gift" validate:"required_if=IsGift true"`
+	AllowBackorder  bool              `json:"allowBackorder"`        // order lines short of stock as backorders instead of failing
+	DeliveryDate    string            `json:"requestedDeliveryDate"` // YYYY-MM-DD; empty ships as soon as possible
+	AddOns          []OrderAddOnInput `json:"addOns" validate:"max=10,unique=AddOnID,dive"`
+}
+
+// BuyNowInput represents the payload for buying a single product at once.
+// Quantity is the product's minimum order quantity when nil, in grams when
+// it is sold by weight. ShippingAddress is the address of the buyer's last
+// order that wasn't a gift when empty, and PaymentMethodID their default
+// card.
+type BuyNowInput struct {
+	Quantity        *int            `json:"quantity" validate:"omitempty,min=1"`
+	ShippingAddress json.RawMessage `json:"shippingAddress"`
+	ShippingMethod  string          `json:"shippingMethod" validate:"omitempty,oneof=standard express pickup"`
+	PaymentMethodID string          `json:"paymentMethodId" validate:"omitempty,uuid"`
+}
+
+// GiftInput represents the recipient and message of a gift order
+type GiftInput struct {
+	RecipientName   string `json:"recipientName" validate:"required,max=100"`
+	RecipientEmail  string `json:"recipientEmail" validate:"required_if=NotifyRecipient true,omitempty,email,max=255"`
+	Message         string `json:"message" validate:"max=500"`
+	NotifyRecipient bool   `json:"notifyRecipient"`
+}
+
+// PackingSlip is what goes in the parcel of an order. Gift slips carry the
+// gift message and leave out prices.
+type PackingSlip struct {
+	XMLName       xml.Name           `json:"-" xml:"packingSlip"`
+	OrderID       string             `json:"orderId" xml:"orderId"`
+	OrderNumber   int64              `json:"orderNumber" xml:"orderNumber"`
+	Reference     string             `json:"reference" xml:"reference"`
+	ShipTo        json.RawMessage    `json:"shipTo,omitempty" xml:"shipTo,omitempty"`
+	IsGift        bool               `json:"isGift" xml:"isGift"`
+	RecipientName string             `json:"recipientName,omitempty" xml:"recipientName,omitempty"`
+	GiftMessage   string             `json:"giftMessage,omitempty" xml:"giftMessage,omitempty"`
+	Items         []PackingSlipItem  `json:"items" xml:"items>item"`
+	AddOns        []PackingSlipAddOn `json:"addOns" xml:"addOns>addOn"`
+	Total         *money.Money       `json:"total,omitempty" xml:"total,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt" xml:"createdAt"`
+}
+
+// PackingSlipItem is a product line on a packing slip
+type PackingSlipItem struct {
+	ProductID  string       `json:"productId" xml:"productId"`
+	Title      string       `json:"title" xml:"title"`
+	SKU        string       `json:"sku,omitempty" xml:"sku,omitempty"`
+	UnitType   string       `json:"unitType" xml:"unitType"`
+	Quantity   int          `json:"quantity" xml:"quantity"`                 // in grams when sold by weight
+	Weight     *Weight      `json:"weight,omitempty" xml:"weight,omitempty"` // the quantity in kg, when sold by weight
+	Price      *money.Money `json:"price,omitempty" xml:"price,omitempty"`   // per kg when sold by weight
+	TotalPrice *money.Money `json:"totalPrice,omitempty" xml:"totalPrice,omitempty"`
+}
+
+// PackingSlipAddOn is an add-on on a packing slip
+type PackingSlipAddOn struct {
+	Name       string       `json:"name" xml:"name"`
+	Quantity   int          `json:"quantity" xml:"quantity"`
+	Price      *money.Money `json:"price,omitempty" xml:"price,omitempty"`
+	TotalPrice *money.Money `json:"totalPrice,omitempty" xml:"totalPrice,omitempty"`
+}
+
+// FulfillmentOrder is an order in a seller's fulfillment queue, with only
+// the seller's items
+type FulfillmentOrder struct {
+	ID              string            `json:"id"`
+	OrderNumber     int64             `json:"orderNumber"`
+	Reference       string            `json:"reference"`
+	Status          string            `json:"status"`
+	ShippingAddress json.RawMessage   `json:"shippingAddress,omitempty"`
+	ShippingMethod  string            `json:"shippingMethod,omitempty"`
+	DeliveryDate    string            `json:"requestedDeliveryDate,omitempty"` // YYYY-MM-DD, when the buyer asked for one
+	IsGift          bool              `json:"isGift"`
+	Gift            *OrderGift        `json:"gift,omitempty"` // recipient name and message to pack
+	Items           []FulfillmentItem `json:"items"`
+	CreatedAt       time.Time         `json:"createdAt"`
+}
+
+// FulfillmentItem is a seller's item on an order to pack and ship
+type FulfillmentItem struct {
+	ID             string     `json:"id"`
+	OrderID        string     `json:"orderId"`
+	ProductID      string     `json:"productId"`
+	Title          string     `json:"title"`
+	SKU            string     `json:"sku,omitempty"`
+	UnitType       string     `json:"unitType"`
+	Quantity       int        `json:"quantity"`         // in grams when sold by weight
+	Weight         *Weight    `json:"weight,omitempty"` // the quantity in kg, when sold by weight
+	FulfilledAt    *time.Time `json:"fulfilledAt,omitempty"`
+	Carrier        string     `json:"carrier,omitempty"`
+	TrackingNumber string     `json:"trackingNumber,omitempty"`
+	Backordered    bool       `json:"backordered"`            // waiting for stock, not to ship yet
+	Preorder       bool       `json:"preorder"`               // backordered until the product is available
+	BackorderETA   string     `json:"backorderEta,omitempty"` // YYYY-MM-DD, while backordered
+}
+
+// FulfillmentQueue is a page of a seller's orders awaiting fulfillment,
+// oldest first
+type FulfillmentQueue struct {
+	Orders []FulfillmentOrder `json:"orders"`
+	Total  int                `json:"total"`
+}
+
+// FulfillmentInput represents the payload for marking an order item shipped
+type FulfillmentInput struct {
+	Carrier        string `json:"carrier" validate:"max=50"`
+	TrackingNumber string `json:"trackingNumber" validate:"max=100"`
+}
+
+// BulkShipInput represents the payload for marking many of a seller's
+// orders shipped at once
+type BulkShipInput struct {
+	Orders []BulkShipOrder `json:"orders" validate:"required,min=1,max=100,unique=OrderID,dive"`
+}
+
+// BulkShipOrder is an order to ship with the tracking of its shipment
+type BulkShipOrder struct {
+	OrderID        string `json:"orderId" validate:"required,uuid"`
+	Carrier        string `json:"carrier" validate:"required,max=50"`
+	TrackingNumber string `json:"trackingNumber" validate:"required,max=100"`
+}
+
+// BulkShipResult is the result of marking many orders shipped
+type BulkShipResult struct {
+	Shipped []BulkShipped     `json:"shipped"`
+	Failed  []BulkShipFailure `json:"failed"`
+}
+
+// BulkShipped is an order whose items were shipped by a bulk shipment
+type BulkShipped struct {
+	OrderID string   `json:"orderId"`
+	ItemIDs []string `json:"itemIds"` // the items this shipment shipped
+}
+
+// BulkShipFailure is an order a bulk shipment could not ship, with the
+// error code shipping it alone would have answered
+type BulkShipFailure struct {
+	OrderID string `json:"orderId"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BulkTransitionInput represents the payload for moving many orders to a
+// status at once
+type BulkTransitionInput struct {
+	OrderIDs []string `json:"orderIds" validate:"required,min=1,max=500,unique,dive,uuid"`
+	Status   string   `json:"status" validate:"required,oneof=paid shipped delivered cancelled"`
+}
+
+// BulkTransitionResult is the result of moving many orders to Status
+type BulkTransitionResult struct {
+	Status  string               `json:"status"`
+	Applied []BulkTransitioned   `json:"applied"`
+	Skipped []BulkTransitionSkip `json:"skipped"`
+}
+
+// BulkTransitioned is an order a bulk transition moved, with the status it
+// moved from
+type BulkTransitioned struct {
+	OrderID    string `json:"orderId"`
+	FromStatus string `json:"fromStatus"`
+}
+
+// BulkTransitionSkip is an order a bulk transition left alone. Reason is
+// not_found, invalid_transition, preorders_pending or internal_error.
+type BulkTransitionSkip struct {
+	OrderID    string `json:"orderId"`
+	FromStatus string `json:"fromStatus,omitempty"` // empty when the order wasn't found
+	Reason     string `json:"reason"`
+	Message    string `json:"message"`
+}
+
+// BackorderInput represents the payload for setting when a backordered
+// order item's stock is expected
+type BackorderInput struct {
+	ExpectedAt string `json:"expectedAt" validate:"required"` // YYYY-MM-DD, not in the past
+}
+
+// OrderNote represents an append-only note on an order
+type OrderNote struct {
+	ID         string    `json:"id" xml:"id"`
+	OrderID    string    `json:"orderId" xml:"orderId"`
+	AuthorID   string    `json:"authorId" xml:"authorId"`
+	Visibility string    `json:"visibility" xml:"visibility"`
+	Body       string    `json:"body" xml:"body"`
+	CreatedAt  time.Time `json:"createdAt" xml:"createdAt"`
+}
+
+// OrderNoteInput represents the payload for adding an order note
+type OrderNoteInput struct {
+	Visibility string `json:"visibility" validate:"omitempty,oneof=customer internal"`
+	Body       string `json:"body" validate:"required,max=5000"`
+}
+
+// Order event types. Events named after an order status move the order to
+// it.
+const (
+	OrderEventCreated   = "created"
+	OrderEventReserved  = "reserved" // stock taken for some of its items
+	OrderEventPaid      = "paid"
+	OrderEventShipped   = "shipped"
+	OrderEventDelivered = "delivered"
+	OrderEventCancelled = "cancelled"
+	OrderEventRefunded  = "refunded"
+	OrderEventHeld      = "held"     // held for fraud review, moving it to review
+	OrderEventReleased  = "released" // released from review, back to pending
+)
+
+// OrderEvent is an entry in an order's append-only event log
+type OrderEvent struct {
+	Sequence  int             `json:"sequence"` // from 1, without gaps
+	Type      string          `json:"type"`
+	ActorID   *string         `json:"actorId"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// OrderState is an order's status and payment status, as stored or as
+// folded from its events
+type OrderState struct {
+	Status        string `json:"status"`
+	PaymentStatus string `json:"paymentStatus"`
+}
+
+// OrderEventLog is an order's events in sequence, with the state they fold
+// to and the state stored on the order
+type OrderEventLog struct {
+	OrderID    string       `json:"orderId"`
+	Events     []OrderEvent `json:"events"`
+	Folded     OrderState   `json:"folded"`
+	Stored     OrderState   `json:"stored"`
+	Consistent bool         `json:"consistent"`
+}
+
+// AdminOrderFilter selects orders for the admin order search
+type AdminOrderFilter struct {
+	Statuses     []string
+	From         *time.Time // inclusive
+	To           *time.Time // exclusive
+	Email        string     // exact customer email, case-insensitive
+	OrderNumber  int64
+	Reference    string // exact order reference, case-insensitive
+	Query        string // substring of customer email or name
+	Sort         string // created_desc (default), created_asc, total_desc, total_asc
+	Cursor       string
+	Limit        int
+	IncludeItems bool
+}
+
+// OrderCustomer is the buyer shown in order list views
+type OrderCustomer struct {
+	ID    string `json:"id" xml:"id"`
+	Email string `json:"email" xml:"email"`
+	Name  string `json:"name" xml:"name"`
+}
+
+// AdminOrderSummary is an order in the admin order search results
+type AdminOrderSummary struct {
+	ID            string        `json:"id" xml:"id"`
+	OrderNumber   int64         `json:"orderNumber" xml:"orderNumber"`
+	Reference     string        `json:"reference" xml:"reference"`
+	Status        string        `json:"status" xml:"status"`
+	PaymentStatus string        `json:"paymentStatus" xml:"paymentStatus"`
+	Total         money.Money   `json:"total" xml:"total"`
+	ItemCount     int           `json:"itemCount" xml:"itemCount"`
+	Customer      OrderCustomer `json:"customer" xml:"customer"`
+	Items         []OrderItem   `json:"items,omitempty" xml:"items>item,omitempty"`
+	CreatedAt     time.Time     `json:"createdAt" xml:"createdAt"`
+}
+
+// AdminOrderPage is a page of admin order search results. NextCursor is
+// empty on the last page.
+type AdminOrderPage struct {
+	XMLName    xml.Name            `json:"-" xml:"orderPage"`
+	Orders     []AdminOrderSummary `json:"orders" xml:"orders>order"`
+	NextCursor string              `json:"nextCursor,omitempty" xml:"nextCursor,omitempty"`
+}
+
+// OrderNotePage represents a page of order notes
+type OrderNotePage struct {
+	XMLName xml.Name    `json:"-" xml:"notePage"`
+	Notes   []OrderNote `json:"notes" xml:"notes>note"`
+	Total   int         `json:"total" xml:"total"`
+}
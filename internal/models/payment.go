@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Payment attempt statuses
+const (
+	PaymentAttemptSucceeded = "succeeded"
+	PaymentAttemptDeclined  = "declined"
+)
+
+// Payment decline reasons. These are what clients see; the gateway's own
+// codes are kept for support.
+const (
+	DeclineInsufficientFunds      = "insufficient_funds"
+	DeclineCardExpired            = "card_expired"
+	DeclineIncorrectCVC           = "incorrect_cvc"
+	DeclineIncorrectNumber        = "incorrect_number"
+	DeclineLimitExceeded          = "limit_exceeded"
+	DeclineAuthenticationRequired = "authentication_required"
+	DeclineCardNotSupported       = "card_not_supported"
+	DeclineProcessingError        = "processing_error" // worth retrying
+	DeclineGeneric                = "card_declined"    // anything else, including suspected fraud
+)
+
+// PaymentAttempt is an attempt to pay for an order. GatewayCode and
+// GatewayMessage are only shown to support.
+type PaymentAttempt struct {
+	ID             string      `json:"id" xml:"id"`
+	OrderID        string      `json:"orderId" xml:"orderId"`
+	Status         string      `json:"status" xml:"status"`
+	Amount         money.Money `json:"amount" xml:"amount"`
+	DeclineReason  string      `json:"declineReason,omitempty" xml:"declineReason,omitempty"`
+	GatewayCode    string      `json:"gatewayCode,omitempty" xml:"gatewayCode,omitempty"`
+	GatewayMessage string      `json:"gatewayMessage,omitempty" xml:"gatewayMessage,omitempty"`
+	CreatedAt      time.Time   `json:"createdAt" xml:"createdAt"`
+}
+
+// PaymentMethod is a card a buyer stored at the payment gateway, shown by
+// its brand, last four digits and expiry
+type PaymentMethod struct {
+	ID        string    `json:"id"`
+	Brand     string    `json:"brand"`
+	Last4     string    `json:"last4"`
+	ExpMonth  int       `json:"expMonth"`
+	ExpYear   int       `json:"expYear"`
+	IsDefault bool      `json:"isDefault"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PaymentMethodInput represents the payload for storing a card, by the
+// token the gateway's card form returned for it
+type PaymentMethodInput struct {
+	Token     string `json:"token" validate:"required,max=255"`
+	IsDefault bool   `json:"isDefault"` // a buyer's first card is their default either way
+}
+
+// PaymentInput represents the payload for paying for an order. Without a
+// PaymentMethodID the card chosen at checkout is charged, or else the
+// buyer's default card.
+type PaymentInput struct {
+	PaymentMethodID string `json:"paymentMethodId" validate:"omitempty,uuid"`
+}
+
+// PaymentAttemptPage is a page of an order's payment attempts, newest first
+type PaymentAttemptPage struct {
+	XMLName  xml.Name         `json:"-" xml:"paymentAttemptPage"`
+	Attempts []PaymentAttempt `json:"attempts" xml:"attempts>attempt"`
+	Total    int              `json:"total" xml:"total"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Notification modes
+const (
+	NotificationModeInstant = "instant"      // each notification as it happens
+	NotificationModeDigest  = "daily_digest" // one summary a day, urgent notifications still instant
+)
+
+// UserPreferences are a user's settings, with their defaults until the user
+// first saves them
+type UserPreferences struct {
+	PreferencesInput
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"` // unset until first saved
+}
+
+// PreferencesInput represents the payload for replacing a user's preferences
+type PreferencesInput struct {
+	Theme              string   `json:"theme" validate:"required,oneof=light dark system"`
+	Language           string   `json:"language" validate:"required,max=5,bcp47_language_tag"`
+	EmailNotifications bool     `json:"emailNotifications"`
+	PushNotifications  bool     `json:"pushNotifications"`
+	MarketingEmails    bool     `json:"marketingEmails"`
+	Interests          []string `json:"interests" validate:"max=50,unique,dive,required,max=100"`
+	NotificationMode   string   `json:"notificationMode" validate:"omitempty,oneof=instant daily_digest"` // instant when empty
+}
+
+// DefaultPreferences returns the preferences of a user who hasn't set any
+func DefaultPreferences() PreferencesInput {
+	return PreferencesInput{
+		Theme:              "system",
+		Language:           "en",
+		EmailNotifications: true,
+		PushNotifications:  true,
+		MarketingEmails:    false,
+		Interests:          []string{},
+		NotificationMode:   NotificationModeInstant,
+	}
+}
+
+// PreferencesPatch is a JSON merge patch (RFC 7396) of PreferencesInput,
+// keyed by JSON field name. Only the keys present are changed, each as a
+// whole, and a key set to null is reset to its default.
+type PreferencesPatch map[string]json.RawMessage
@@ -0,0 +1,752 @@
+package models
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"slices"
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Product types
+const (
+	ProductTypeSimple = "simple"
+	ProductTypeBundle = "bundle" // sells other products together; see Bundle
+)
+
+// Bundle pricing modes
+const (
+	BundlePricingFixed      = "fixed"       // the bundle's own price
+	BundlePricingPercentOff = "percent_off" // a percentage off the components' total
+)
+
+// Product statuses
+const (
+	ProductStatusDraft     = "draft"     // seen only by its seller until published
+	ProductStatusPublished = "published" // listed
+	ProductStatusArchived  = "archived"  // unlisted until published again
+)
+
+// Product represents a product listing
+type Product struct {
+	XMLName        xml.Name        `json:"-" xml:"product"`
+	ID             string          `json:"id" xml:"id"`
+	SellerID       string          `json:"sellerId" xml:"sellerId"`
+	CategoryID     *string         `json:"categoryId" xml:"categoryId"`              // primary category
+	CategoryIDs    []string        `json:"categoryIds" xml:"categoryIds>categoryId"` // every category, including the primary one
+	Title          string          `json:"title" xml:"title"`
+	Slug           string          `json:"slug" xml:"slug"` // unique name in URLs, for GET /products/slug/{slug}
+	Description    string          `json:"description" xml:"description"`
+	Locale         string          `json:"locale,omitempty" xml:"locale,omitempty"`              // language of Title and Description, set once localized
+	Price          money.Money     `json:"price" xml:"price"`                                    // the price charged now, the sale price during a sale
+	RegularPrice   *money.Money    `json:"regularPrice,omitempty" xml:"regularPrice,omitempty"`  // set during a sale
+	Sale           *Sale           `json:"sale,omitempty" xml:"sale,omitempty"`                  // a scheduled or running sale
+	PriceTiers     []PriceTier     `json:"priceTiers,omitempty" xml:"priceTiers>tier,omitempty"` // quantity breaks, by ascending MinQuantity
+	Condition      string          `json:"condition" xml:"condition"`                            // new, used, refurbished
+	Type           string          `json:"type" xml:"type"`
+	UnitType       string          `json:"unitType" xml:"unitType"`                               // each, or weight for a price per kg and quantities in grams
+	StockQuantity  int             `json:"stockQuantity" xml:"stockQuantity"`                     // for bundles, how many can be assembled from component stock
+	Available      *int            `json:"available,omitempty" xml:"available,omitempty"`         // stock not held in other buyers' carts; set on single product reads while cart holds are enabled
+	SellerOpen     *bool           `json:"sellerOpen,omitempty" xml:"sellerOpen,omitempty"`       // its seller is accepting orders and within business hours; set on single product reads
+	DistanceKm     *float64        `json:"distanceKm,omitempty" xml:"distanceKm,omitempty"`       // from the location listings and searches near one were given
+	Preorder       bool            `json:"preorder" xml:"preorder"`                               // orderable without stock until AvailableFrom
+	AvailableFrom  *time.Time      `json:"availableFrom,omitempty" xml:"availableFrom,omitempty"` // when a preorder product ships from
+	MinOrderQty    int             `json:"minOrderQty" xml:"minOrderQty"`
+	MaxOrderQty    *int            `json:"maxOrderQty" xml:"maxOrderQty"` // nil when uncapped
+	StepQty        int             `json:"stepQty" xml:"stepQty"`
+	PurchaseLimit  *PurchaseLimit  `json:"purchaseLimit,omitempty" xml:"purchaseLimit,omitempty"`
+	SKU            string          `json:"sku" xml:"sku"`
+	Warehouse      string          `json:"warehouse,omitempty" xml:"warehouse,omitempty"` // empty ships from the default warehouse
+	ProcessingDays *int            `json:"processingDays" xml:"processingDays"`           // business days to ship; nil uses the default
+	Tags           []string        `json:"tags" xml:"tags>tag"`
+	Images         json.RawMessage `json:"images,omitempty" xml:"images,omitempty"`
+	ImageErrors    []ImageError    `json:"imageErrors,omitempty" xml:"-"` // image URLs given on create that could not be imported
+	Specifications json.RawMessage `json:"specifications,omitempty" xml:"specifications,omitempty"`
+	Nutrition      *Nutrition      `json:"nutrition,omitempty" xml:"nutrition,omitempty"`
+	IsFeatured     bool            `json:"isFeatured" xml:"isFeatured"`
+	IsActive       bool            `json:"isActive" xml:"isActive"` // published
+	Status         string          `json:"status" xml:"status"`
+	PublishedAt    *time.Time      `json:"publishedAt,omitempty" xml:"publishedAt,omitempty"`
+	AvgRating      float64         `json:"avgRating" xml:"avgRating"`     // over visible reviews
+	ReviewCount    int             `json:"reviewCount" xml:"reviewCount"` // visible reviews
+	Bundle         *Bundle         `json:"bundle,omitempty" xml:"bundle,omitempty"`
+	Version        int             `json:"version" xml:"version"` // bumped by every edit of the listing
+	CreatedAt      time.Time       `json:"createdAt" xml:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt" xml:"updatedAt"`
+}
+
+// Input returns the ProductInput that describes p as it is, at its regular
+// price. p's bundle must have its items loaded.
+func (p *Product) Input() ProductInput {
+	input := ProductInput{
+		CategoryID:     clonePtr(p.CategoryID),
+		CategoryIDs:    []string{},
+		Title:          p.Title,
+		Description:    p.Description,
+		Price:          p.Price,
+		Condition:      p.Condition,
+		Type:           p.Type,
+		UnitType:       p.UnitType,
+		StockQuantity:  p.StockQuantity,
+		MinOrderQty:    p.MinOrderQty,
+		MaxOrderQty:    clonePtr(p.MaxOrderQty),
+		StepQty:        p.StepQty,
+		PurchaseLimit:  clonePtr(p.PurchaseLimit),
+		SKU:            p.SKU,
+		Warehouse:      p.Warehouse,
+		ProcessingDays: clonePtr(p.ProcessingDays),
+		Preorder:       p.Preorder,
+		AvailableFrom:  clonePtr(p.AvailableFrom),
+		Tags:           slices.Clone(p.Tags),
+		Images:         slices.Clone(p.Images),
+		Specifications: slices.Clone(p.Specifications),
+		Nutrition:      p.Nutrition.Clone(),
+	}
+	if p.RegularPrice != nil {
+		input.Price = *p.RegularPrice
+	}
+	for _, id := range p.CategoryIDs {
+		if p.CategoryID == nil || id != *p.CategoryID {
+			input.CategoryIDs = append(input.CategoryIDs, id)
+		}
+	}
+	if p.Bundle != nil {
+		// Bundle stock follows its components and is not set directly
+		input.StockQuantity = 0
+		input.Bundle = &BundleInput{Pricing: p.Bundle.Pricing, DiscountPercent: p.Bundle.DiscountPercent}
+		for _, item := range p.Bundle.Items {
+			input.Bundle.Items = append(input.Bundle.Items, BundleItemInput{ProductID: item.ProductID, Quantity: item.Quantity})
+		}
+	}
+	return input
+}
+
+// Clone returns a deep copy of p, sharing nothing that can be mutated
+func (p *Product) Clone() *Product {
+	c := *p
+	c.CategoryID = clonePtr(p.CategoryID)
+	c.CategoryIDs = slices.Clone(p.CategoryIDs)
+	c.RegularPrice = clonePtr(p.RegularPrice)
+	c.Sale = clonePtr(p.Sale)
+	c.PriceTiers = slices.Clone(p.PriceTiers)
+	c.Available = clonePtr(p.Available)
+	c.SellerOpen = clonePtr(p.SellerOpen)
+	c.DistanceKm = clonePtr(p.DistanceKm)
+	c.MaxOrderQty = clonePtr(p.MaxOrderQty)
+	c.PurchaseLimit = clonePtr(p.PurchaseLimit)
+	c.ProcessingDays = clonePtr(p.ProcessingDays)
+	c.AvailableFrom = clonePtr(p.AvailableFrom)
+	c.Tags = slices.Clone(p.Tags)
+	c.Images = slices.Clone(p.Images)
+	c.ImageErrors = slices.Clone(p.ImageErrors)
+	c.Specifications = slices.Clone(p.Specifications)
+	c.Nutrition = p.Nutrition.Clone()
+	c.PublishedAt = clonePtr(p.PublishedAt)
+	if p.Bundle != nil {
+		bundle := *p.Bundle
+		bundle.ComponentsTotal = clonePtr(p.Bundle.ComponentsTotal)
+		bundle.Items = slices.Clone(p.Bundle.Items)
+		c.Bundle = &bundle
+	}
+	return &c
+}
+
+// clonePtr returns a pointer to a copy of *v, or nil
+func clonePtr[T any](v *T) *T {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	return &c
+}
+
+// PurchaseLimit caps how much of a product one buyer may order over the
+// last WindowDays days, in the product's unit: a count, or grams by weight
+type PurchaseLimit struct {
+	Quantity   int `json:"quantity" xml:"quantity" validate:"gte=1"`
+	WindowDays int `json:"windowDays" xml:"windowDays" validate:"gte=1,lte=365"`
+}
+
+// Sale is a price a product is sold at from StartsAt until EndsAt
+type Sale struct {
+	Price    money.Money `json:"price" xml:"price"`
+	StartsAt time.Time   `json:"startsAt" xml:"startsAt"`
+	EndsAt   time.Time   `json:"endsAt" xml:"endsAt"`
+}
+
+// ActiveAt reports whether the sale price applies at t
+func (s *Sale) ActiveAt(t time.Time) bool {
+	return s != nil && !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+// ApplySaleAt sets Price to the price charged at t: the sale price while the
+// sale is active, with the regular price in RegularPrice, and the regular
+// price otherwise. It can be applied again for another instant.
+func (p *Product) ApplySaleAt(t time.Time) {
+	if p.RegularPrice != nil {
+		p.Price = *p.RegularPrice
+		p.RegularPrice = nil
+	}
+	if p.Sale.ActiveAt(t) {
+		regular := p.Price
+		p.RegularPrice = &regular
+		p.Price = p.Sale.Price
+	}
+}
+
+// SaleInput represents the payload for scheduling a product's sale. The
+// price's currency must be the product's currency.
+type SaleInput struct {
+	Price    money.Money `json:"price"`
+	StartsAt time.Time   `json:"startsAt" validate:"required"`
+	EndsAt   time.Time   `json:"endsAt" validate:"required,gtfield=StartsAt"`
+}
+
+// PriceTier is a unit price charged for buying at least MinQuantity of a
+// product. A line is charged the lower of its tier price and the product's
+// price at the time, so tiers and sales never stack.
+type PriceTier struct {
+	MinQuantity int         `json:"minQuantity" xml:"minQuantity"`
+	Price       money.Money `json:"price" xml:"price"`
+}
+
+// PriceTiersInput represents the payload for replacing a product's price
+// tiers. An empty list removes them.
+type PriceTiersInput struct {
+	Tiers []PriceTierInput `json:"tiers" validate:"max=10,dive"`
+}
+
+// PriceTierInput is one tier of a PriceTiersInput. The price's currency
+// must be the product's currency.
+type PriceTierInput struct {
+	MinQuantity int         `json:"minQuantity" validate:"gt=1"`
+	Price       money.Money `json:"price"`
+}
+
+// Bundle describes how a bundle product is priced and, on the product view,
+// what it contains
+type Bundle struct {
+	Pricing         string            `json:"pricing" xml:"pricing"`
+	DiscountPercent float64           `json:"discountPercent,omitempty" xml:"discountPercent,omitempty"`
+	ComponentsTotal *money.Money      `json:"componentsTotal,omitempty" xml:"componentsTotal,omitempty"` // price of the components bought separately
+	Items           []BundleComponent `json:"items,omitempty" xml:"items>item,omitempty"`
+}
+
+// BundleComponent is a product included in a bundle
+type BundleComponent struct {
+	ProductID     string      `json:"productId" xml:"productId"`
+	Title         string      `json:"title" xml:"title"`
+	Price         money.Money `json:"price" xml:"price"`
+	Quantity      int         `json:"quantity" xml:"quantity"`
+	StockQuantity int         `json:"stockQuantity" xml:"stockQuantity"`
+	IsActive      bool        `json:"isActive" xml:"isActive"`
+}
+
+// ProductImage is an entry in a product's images list
+type ProductImage struct {
+	URL         string `json:"url"`
+	Key         string `json:"key"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// ImageError reports why an image URL could not be imported
+type ImageError struct {
+	URL     string `json:"url"`
+	Code    string `json:"code"` // unsafe_url, fetch_failed, too_large or unsupported_media_type
+	Message string `json:"message"`
+}
+
+// ImageImportInput represents the payload for importing product images from URLs
+type ImageImportInput struct {
+	URLs []string `json:"urls" validate:"required,min=1,max=10,dive,required,url"`
+}
+
+// ProductInput represents the payload for creating or replacing a product.
+// The price's currency is the product's currency. When Version is given the
+// product is only replaced if that is still its version.
+type ProductInput struct {
+	Version        *int            `json:"version" validate:"omitempty,gte=1"`
+	CategoryID     *string         `json:"categoryId" validate:"omitempty,uuid"`
+	CategoryIDs    []string        `json:"categoryIds" validate:"max=10,dive,uuid"` // further categories
+	Title          string          `json:"title" validate:"required,max=255"`
+	Slug           string          `json:"slug"` // empty keeps the product's slug, or derives one from a new title
+	Description    string          `json:"description"`
+	Price          money.Money     `json:"price"`
+	Condition      string          `json:"condition" validate:"omitempty,oneof=new used refurbished"`
+	Type           string          `json:"type" validate:"omitempty,oneof=simple bundle"`
+	UnitType       string          `json:"unitType" validate:"omitempty,oneof=each weight"` // for weight, the price is per kg and quantities are in grams
+	StockQuantity  int             `json:"stockQuantity" validate:"gte=0"`
+	MinOrderQty    int             `json:"minOrderQty" validate:"gte=0"` // 0 means 1, or 100 g by weight
+	MaxOrderQty    *int            `json:"maxOrderQty" validate:"omitempty,gte=1"`
+	StepQty        int             `json:"stepQty" validate:"gte=0"` // 0 means 1, or 100 g by weight
+	PurchaseLimit  *PurchaseLimit  `json:"purchaseLimit"`            // nil for no limit
+	SKU            string          `json:"sku" validate:"max=100"`
+	Warehouse      string          `json:"warehouse" validate:"max=50"` // code of a known warehouse; empty for the default
+	ProcessingDays *int            `json:"processingDays" validate:"omitempty,gte=0,lte=60"`
+	Preorder       bool            `json:"preorder"`                                           // take orders without stock until AvailableFrom
+	AvailableFrom  *time.Time      `json:"availableFrom" validate:"required_if=Preorder true"` // when preorders are filled
+	Tags           []string        `json:"tags" validate:"max=20,dive,max=50"`
+	Images         json.RawMessage `json:"images"`
+	ImageURLs      []string        `json:"imageUrls" validate:"max=10,dive,required,url"` // images to fetch and add on create
+	Specifications json.RawMessage `json:"specifications"`
+	Nutrition      *Nutrition      `json:"nutrition"`
+	Bundle         *BundleInput    `json:"bundle" validate:"required_if=Type bundle"`
+}
+
+// ProductPatch is a partial ProductInput, keyed by JSON field name. Only the
+// fields present are changed, each as a whole, and a field set to null is
+// cleared or reset to its default.
+type ProductPatch map[string]json.RawMessage
+
+// BundleInput represents the contents and pricing of a bundle product.
+// Percent-off bundles are priced from their components, so only the currency
+// of their price input is used.
+type BundleInput struct {
+	Pricing         string            `json:"pricing" validate:"required,oneof=fixed percent_off"`
+	DiscountPercent float64           `json:"discountPercent" validate:"required_if=Pricing percent_off,gte=0,lt=100"`
+	Items           []BundleItemInput `json:"items" validate:"required,min=2,max=20,unique=ProductID,dive"`
+}
+
+// BundleItemInput is a product and quantity included in a bundle
+type BundleItemInput struct {
+	ProductID string `json:"productId" validate:"required,uuid"`
+	Quantity  int    `json:"quantity" validate:"required,gte=1,lte=100"`
+}
+
+// ProductFilter selects a page of active products. It holds only filters
+// that are the same for every user, since listings are cached by filter.
+type ProductFilter struct {
+	CategoryID   string
+	Condition    string
+	MinPrice     float64
+	MaxPrice     float64
+	Tags         []string // tag slugs, all of which a product must have
+	AllergenFree []string // allergens a product must declare it doesn't contain
+	MaxCalories  float64  // per serving
+	Currency     string   // when set, prices are converted to it to filter and sort
+	Sort         string   // newest, price_asc, price_desc, name_asc, name_desc
+	Locale       string   // collation of name sorts; empty for the database default
+	Near         *GeoFilter
+	Limit        int
+	Offset       int
+}
+
+// GeoFilter narrows products to those of sellers within RadiusKm of a
+// location
+type GeoFilter struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+// ProductPage is a page of products with the total number of matches
+type ProductPage struct {
+	XMLName  xml.Name   `json:"-" xml:"productPage"`
+	Products []*Product `json:"products" xml:"products>product"`
+	Total    int        `json:"total" xml:"total"`
+}
+
+// CategoryFilters are the values a category's products can be filtered by,
+// counted over its listed products that are in stock or on preorder
+type CategoryFilters struct {
+	CategoryID string            `json:"categoryId"`
+	Total      int               `json:"total"`      // products counted
+	Brands     []FilterValue     `json:"brands"`     // by descending count
+	Prices     []PriceRange      `json:"prices"`     // one per currency, by descending count
+	Attributes []FilterAttribute `json:"attributes"` // by name
+}
+
+// FilterValue is a value and how many products have it
+type FilterValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// PriceRange is the lowest and highest listed price in a currency
+type PriceRange struct {
+	Min   money.Money `json:"min"`
+	Max   money.Money `json:"max"`
+	Count int         `json:"count"`
+}
+
+// FilterAttribute is a specification attribute and its values, by
+// descending count
+type FilterAttribute struct {
+	Name   string        `json:"name"`
+	Values []FilterValue `json:"values"`
+}
+
+// ProductTranslation is a product's title and description in a locale other
+// than the default one
+type ProductTranslation struct {
+	Locale      string    `json:"locale"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"` // empty shows the product's own description
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// ProductTranslationInput represents the payload for setting a product's
+// translation into the locale named in the URL
+type ProductTranslationInput struct {
+	Locale      string `json:"-" validate:"required,max=20,bcp47_language_tag"`
+	Title       string `json:"title" validate:"required,max=255"`
+	Description string `json:"description"`
+}
+
+// ProductChangeFilter selects the products changed since a delta sync's
+// last change: after Cursor when set, else after Since
+type ProductChangeFilter struct {
+	Since  time.Time
+	Cursor string
+}
+
+// ProductChange is a product changed since the last sync: the product as
+// listed, or a tombstone without it once the product is deleted or no longer
+// listed. Cursor resumes the sync after this change.
+type ProductChange struct {
+	ID        string    `json:"id"`
+	Deleted   bool      `json:"deleted"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Cursor    string    `json:"cursor"`
+	Product   *Product  `json:"product,omitempty"`
+}
+
+// TrendingProduct is a product with its views in the trending window
+type TrendingProduct struct {
+	*Product
+	Views  int64                `json:"views"`
+	Reason RecommendationReason `json:"reason"`
+}
+
+// TrendingProducts lists in-stock products by their views in a window
+type TrendingProducts struct {
+	Window   string            `json:"window"`
+	Products []TrendingProduct `json:"products"`
+}
+
+// FeaturedInput sets whether a product is eligible for the featured slot
+// and its weight there; without a weight it keeps the one it has, 1 at first
+type FeaturedInput struct {
+	Featured *bool `json:"featured" validate:"required"`
+	Weight   *int  `json:"weight,omitempty" validate:"omitempty,min=1,max=1000"`
+}
+
+// FeaturedProduct is a product eligible for the featured slot with its
+// weight there
+type FeaturedProduct struct {
+	*Product
+	Weight int `json:"featuredWeight"`
+}
+
+// FeaturedProductPage is a page of the products eligible for the featured
+// slot
+type FeaturedProductPage struct {
+	Products []FeaturedProduct `json:"products"`
+	Total    int               `json:"total"`
+}
+
+// FeaturedProducts is the featured slot's selection until RotatesAt
+type FeaturedProducts struct {
+	Products  []*Product `json:"products"`
+	RotatesAt time.Time  `json:"rotatesAt"`
+}
+
+// AvailabilityInput lists the products to check the availability of. The
+// number of ids is limited while decoding.
+type AvailabilityInput struct {
+	IDs []string `json:"ids" validate:"required,min=1,dive,uuid"`
+}
+
+// ProductAvailability is whether a product can be added to a cart now, with
+// its stock and the price it is sold at
+type ProductAvailability struct {
+	Available      bool        `json:"available"`
+	Stock          int         `json:"stock"`
+	EffectivePrice money.Money `json:"effectivePrice"`
+}
+
+// RecentlyViewedProduct is a recently viewed product, flagged when it has run
+// out of stock
+type RecentlyViewedProduct struct {
+	*Product
+	InStock bool `json:"inStock"`
+}
+
+// RecentlyViewed lists recently viewed products, most recent first. Products
+// that were deleted or unlisted since they were viewed are left out.
+type RecentlyViewed struct {
+	Products []RecentlyViewedProduct `json:"products"`
+}
+
+// SimilarProducts lists the products most like a product. Fallback is set
+// when they are related by category and tags rather than by embedding, as
+// embeddings haven't been built yet.
+type SimilarProducts struct {
+	Products []SimilarProduct `json:"products"`
+	Fallback bool             `json:"fallback"`
+}
+
+// SimilarProduct is a product like another, with why it is
+type SimilarProduct struct {
+	*Product
+	Reason RecommendationReason `json:"reason"`
+}
+
+// Reasons a product is recommended
+const (
+	RecommendationSimilar        = "similar"         // close by embedding to the reference product
+	RecommendationSharedTags     = "shared_tags"     // in the reference collection, one of Count shared
+	RecommendationSameCategory   = "same_category"   // in the reference primary category
+	RecommendationSharedCategory = "shared_category" // also in the reference category
+	RecommendationTrending       = "trending"        // Count views in the reference window
+)
+
+// Kinds of thing a recommendation reason refers to
+const (
+	RecommendationRefProduct  = "product"
+	RecommendationRefTag      = "tag"
+	RecommendationRefCategory = "category"
+	RecommendationRefWindow   = "window"
+)
+
+// RecommendationReason says why a product is recommended, for labelling
+// it: the strongest reason of the strategy that ranked it, and what it
+// refers to
+type RecommendationReason struct {
+	Type      string                  `json:"type"`
+	Reference RecommendationReference `json:"reference"`
+}
+
+// RecommendationReference is what a recommendation reason refers to. ID is
+// a product or category ID, a tag name or a trending window; Name is a
+// product's title.
+type RecommendationReference struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Count int64  `json:"count,omitempty"`
+}
+
+// ProductComparison lines products up for a side by side comparison.
+// Attributes lists the normalized specification names of every compared
+// product, and each product has an entry, possibly null, for all of them.
+type ProductComparison struct {
+	Attributes []string          `json:"attributes"`
+	Products   []ComparedProduct `json:"products"`
+	NotFound   []string          `json:"notFound"` // requested IDs that don't exist
+}
+
+// ComparedProduct is a product's column in a ProductComparison
+type ComparedProduct struct {
+	ID            string                     `json:"id"`
+	Title         string                     `json:"title"`
+	Price         money.Money                `json:"price"`
+	AvgRating     float64                    `json:"avgRating"`
+	ReviewCount   int                        `json:"reviewCount"`
+	Condition     string                     `json:"condition"`
+	StockQuantity int                        `json:"stockQuantity"`
+	Attributes    map[string]json.RawMessage `json:"attributes"`
+}
+
+// Tag is a label that groups products into a collection
+type Tag struct {
+	ID   string `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	Slug string `json:"slug" xml:"slug"`
+}
+
+// Collection is a page of the products with a tag
+type Collection struct {
+	XMLName xml.Name `json:"-" xml:"collection"`
+	Tag     Tag      `json:"tag" xml:"tag"`
+	ProductPage
+}
+
+// ProductTagsInput represents a bulk change to the tags of products. Tags
+// are given by name and created when first attached. The number of products
+// is limited while decoding, by the bulk config.
+type ProductTagsInput struct {
+	ProductIDs []string `json:"productIds" validate:"required,min=1,unique,dive,uuid"`
+	Attach     []string `json:"attach" validate:"max=20,dive,required,max=50"`
+	Detach     []string `json:"detach" validate:"max=20,dive,required,max=50"`
+}
+
+// ProductCategoriesInput represents a bulk change to the categories of
+// products, limited like ProductTagsInput
+type ProductCategoriesInput struct {
+	ProductIDs []string `json:"productIds" validate:"required,min=1,unique,dive,uuid"`
+	Attach     []string `json:"attach" validate:"max=10,dive,uuid"`
+	Detach     []string `json:"detach" validate:"max=10,dive,uuid"`
+}
+
+// BulkAssignResult reports a bulk tag or category change
+type BulkAssignResult struct {
+	Updated  int      `json:"updated"`
+	NotFound []string `json:"notFound"` // product IDs that don't exist
+}
+
+// RecategorizeInput represents a bulk move of products to another
+// category. Products are chosen either by ID or by filter: those in
+// fromCategoryId, tagged tag, or both.
+type RecategorizeInput struct {
+	ProductIDs       []string `json:"productIds" validate:"omitempty,unique,dive,uuid"`
+	FromCategoryID   *string  `json:"fromCategoryId" validate:"omitempty,uuid"`
+	Tag              string   `json:"tag" validate:"omitempty,max=50"`
+	TargetCategoryID string   `json:"targetCategoryId" validate:"required,uuid"`
+}
+
+// Recategorization is the audit record of a bulk move of products to
+// another category
+type Recategorization struct {
+	ID               string    `json:"id"`
+	TargetCategoryID string    `json:"targetCategoryId"`
+	FromCategoryID   *string   `json:"fromCategoryId,omitempty"`
+	Tag              *string   `json:"tag,omitempty"`
+	Moved            int       `json:"moved"`
+	Unchanged        int       `json:"unchanged"` // already only in the target
+	Skipped          []string  `json:"skipped"`   // deleted product IDs
+	NotFound         []string  `json:"notFound"`  // product IDs that don't exist
+	RecategorizedBy  *string   `json:"recategorizedBy"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// Category represents a product category
+type Category struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Slug        string  `json:"slug"`
+	Description string  `json:"description"`
+	ParentID    *string `json:"parentId"`
+	Icon        string  `json:"icon"`
+	Color       string  `json:"color"`
+	// Position among the categories sharing ParentID, from 1
+	DisplayOrder int       `json:"displayOrder"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// CategoryNode is a category in the category tree
+type CategoryNode struct {
+	Category
+	Children []CategoryNode `json:"children"`
+}
+
+// CategoryReorderInput represents a new order for categories sharing a
+// parent. Categories of that parent not listed keep their relative order
+// after those listed.
+type CategoryReorderInput struct {
+	IDs []string `json:"ids" validate:"required,min=1,max=500,unique,dive,uuid"`
+}
+
+// Bulk repricing operations
+const (
+	RepricePercent = "percent" // change each price by Percent of it
+	RepriceDelta   = "delta"   // add Amount, which may be negative, to each price
+	RepriceSet     = "set"     // set each price to Amount
+)
+
+// Reasons a product's price is left as it is by a bulk reprice
+const (
+	RepriceSkipComputed   = "computed_price"    // a percent-off bundle, priced from its components
+	RepriceSkipCurrency   = "currency_mismatch" // priced in another currency than Amount
+	RepriceSkipUnchanged  = "unchanged"         // the new price is the current one
+	RepriceSkipBelowFloor = "below_floor"       // the new price is below the currency's floor
+	RepriceSkipBelowSale  = "below_sale"        // the new price is not above the product's scheduled sale price
+)
+
+// RepriceInput represents the payload for changing the prices of a seller's
+// products in bulk. Products are picked by CategoryID and Tag, every one of
+// the seller's when neither is given. Percent prices are rounded to the
+// minor unit. With DryRun the new prices are worked out but not saved.
+type RepriceInput struct {
+	CategoryID string       `json:"categoryId" validate:"omitempty,uuid"`
+	Tag        string       `json:"tag" validate:"max=100"`
+	Operation  string       `json:"operation" validate:"required,oneof=percent delta set"`
+	Percent    float64      `json:"percent" validate:"required_if=Operation percent,gt=-100,lte=1000"`
+	Amount     *money.Money `json:"amount" validate:"required_unless=Operation percent"`
+	DryRun     bool         `json:"dryRun"`
+}
+
+// RepriceResult lists the products a bulk reprice changed, or would change
+// on a dry run, and those it left as they were
+type RepriceResult struct {
+	DryRun  bool              `json:"dryRun"`
+	Changed []RepricedProduct `json:"changed"`
+	Skipped []RepricedProduct `json:"skipped"`
+}
+
+// RepricedProduct is a product's regular price before and after a bulk
+// reprice
+type RepricedProduct struct {
+	ProductID string       `json:"productId"`
+	Title     string       `json:"title"`
+	OldPrice  money.Money  `json:"oldPrice"`
+	NewPrice  *money.Money `json:"newPrice,omitempty"` // unset when no new price could be worked out
+	Reason    string       `json:"reason,omitempty"`
+	Message   string       `json:"message,omitempty"`
+}
+
+// Kinds of price in the price history
+const (
+	PriceKindRegular = "regular"
+	PriceKindSale    = "sale"
+)
+
+// Reasons for price changes in the price history
+const (
+	PriceChangeCreate  = "create"  // the product was listed
+	PriceChangeUpdate  = "update"  // the product, or a component of a percent-off bundle, was updated
+	PriceChangeSale    = "sale"    // a sale was scheduled or cancelled
+	PriceChangeReprice = "reprice" // a bulk reprice
+	PriceChangeSystem  = "system"  // made without a reason, such as by a migration
+)
+
+// PriceChange is a change of a product's regular price, or of its sale. A
+// product's first regular price and a new sale have no OldPrice, and a
+// cancelled sale has no NewPrice. Reason and ActorID are only shown to the
+// product's seller.
+type PriceChange struct {
+	ID           int64        `json:"id"`
+	Kind         string       `json:"kind"`
+	OldPrice     *money.Money `json:"oldPrice,omitempty"`
+	NewPrice     *money.Money `json:"newPrice,omitempty"`
+	SaleStartsAt *time.Time   `json:"saleStartsAt,omitempty"`
+	SaleEndsAt   *time.Time   `json:"saleEndsAt,omitempty"`
+	Reason       string       `json:"reason,omitempty"`
+	ActorID      *string      `json:"actorId,omitempty"`
+	ChangedAt    time.Time    `json:"changedAt"`
+}
+
+// PriceHistoryPage is a page of a product's price changes, newest first
+type PriceHistoryPage struct {
+	Changes []PriceChange `json:"changes"`
+	Total   int           `json:"total"`
+}
+
+// ProductMergeInput represents the payload for merging a duplicate product
+// into another
+type ProductMergeInput struct {
+	SourceID string `json:"sourceId" validate:"required,uuid"` // the duplicate, deleted by the merge
+	TargetID string `json:"targetId" validate:"required,uuid"`
+}
+
+// ProductMerge is the audit record of a merge of one product into another,
+// with what it moved
+type ProductMerge struct {
+	ID            string     `json:"id"`
+	SourceID      string     `json:"sourceId"`
+	TargetID      string     `json:"targetId"`
+	MergedBy      *string    `json:"mergedBy"`
+	Reviews       int        `json:"reviews"`
+	WishlistItems int        `json:"wishlistItems"` // moved or dropped as duplicates
+	CartItems     int        `json:"cartItems"`     // moved or dropped as duplicates
+	OrderItems    int        `json:"orderItems"`
+	StockMoved    int        `json:"stockMoved"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UndoUntil     time.Time  `json:"undoUntil"`
+	RevertedAt    *time.Time `json:"revertedAt,omitempty"`
+	RevertedBy    *string    `json:"revertedBy,omitempty"`
+	StockReturned *int       `json:"stockReturned,omitempty"` // by the revert, at most the target's stock
+}
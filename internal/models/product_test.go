@@ -0,0 +1,102 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// fill sets every pointer, slice and map reachable through v's exported
+// fields to a non-empty value, so a clone has something to share
+func fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		fill(v.Elem())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		}
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		v.SetMapIndex(reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem())
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				fill(f)
+			}
+		}
+	}
+}
+
+// assertUnshared fails for each pointer, slice or map reachable through a
+// that b shares with it
+func assertUnshared(t *testing.T, path string, a, b reflect.Value) {
+	t.Helper()
+	switch a.Kind() {
+	case reflect.Pointer:
+		if a.IsNil() {
+			return
+		}
+		if a.Pointer() == b.Pointer() {
+			t.Errorf("%s is shared with the clone", path)
+			return
+		}
+		assertUnshared(t, path, a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.Len() == 0 {
+			return
+		}
+		if a.Pointer() == b.Pointer() {
+			t.Errorf("%s is shared with the clone", path)
+			return
+		}
+		for i := 0; i < a.Len(); i++ {
+			assertUnshared(t, path+"[]", a.Index(i), b.Index(i))
+		}
+	case reflect.Map:
+		if !a.IsNil() && a.Pointer() == b.Pointer() {
+			t.Errorf("%s is shared with the clone", path)
+		}
+	case reflect.Struct:
+		if a.Type() == timeType {
+			return
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).IsExported() {
+				assertUnshared(t, path+"."+a.Type().Field(i).Name, a.Field(i), b.Field(i))
+			}
+		}
+	}
+}
+
+func TestProductCloneSharesNothing(t *testing.T) {
+	p := &Product{}
+	fill(reflect.ValueOf(p))
+
+	c := p.Clone()
+	if !reflect.DeepEqual(p, c) {
+		t.Fatal("clone differs from the product")
+	}
+	assertUnshared(t, "Product", reflect.ValueOf(p).Elem(), reflect.ValueOf(c).Elem())
+}
+
+func TestProductCloneOfEmptyProduct(t *testing.T) {
+	p := &Product{ID: "p1"}
+	c := p.Clone()
+	if !reflect.DeepEqual(p, c) {
+		t.Fatalf("clone = %+v, want %+v", c, p)
+	}
+}
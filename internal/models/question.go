@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// ProductQuestion is a shopper's question about a product, with its answers
+type ProductQuestion struct {
+	ID          string          `json:"id"`
+	ProductID   string          `json:"productId"`
+	UserID      string          `json:"userId"`
+	Body        string          `json:"body"`
+	AnswerCount int             `json:"answerCount"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Answers     []ProductAnswer `json:"answers"` // the seller's first, then most helpful
+}
+
+// ProductAnswer is an answer to a product question by the product's seller
+// or a buyer who received it
+type ProductAnswer struct {
+	ID                 string    `json:"id"`
+	QuestionID         string    `json:"questionId"`
+	UserID             string    `json:"userId"`
+	Body               string    `json:"body"`
+	IsSeller           bool      `json:"isSeller"`
+	IsVerifiedPurchase bool      `json:"isVerifiedPurchase"`
+	HelpfulVotes       int       `json:"helpfulVotes"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+// QuestionInput represents the payload for asking or answering a question,
+// checked like the comment of a review
+type QuestionInput struct {
+	Body string `json:"body" validate:"required,max=5000"`
+}
+
+// QuestionPage represents a page of a product's questions
+type QuestionPage struct {
+	Questions []ProductQuestion `json:"questions"`
+	Total     int               `json:"total"`
+}
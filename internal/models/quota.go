@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// QuotaStatus represents a user's usage of a daily quota
+type QuotaStatus struct {
+	Feature   string    `json:"feature"`
+	Plan      string    `json:"plan"`
+	Unlimited bool      `json:"unlimited"`
+	Limit     int       `json:"limit"` // 0 when unlimited
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
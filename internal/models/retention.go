@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PurgeReport is the outcome of a retention purge run. In a dry run nothing
+// is deleted and Rows counts what would have been.
+type PurgeReport struct {
+	DryRun     bool           `json:"dryRun"`
+	Entities   []PurgedEntity `json:"entities"`
+	StartedAt  time.Time      `json:"startedAt"`
+	DurationMs int64          `json:"durationMs"`
+}
+
+// PurgedEntity is what a purge run did to one entity
+type PurgedEntity struct {
+	Entity string    `json:"entity"`
+	Cutoff time.Time `json:"cutoff"` // rows older than this were purged
+	Rows   int64     `json:"rows"`
+}
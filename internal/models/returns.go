@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Order return statuses. Requested returns are approved or rejected by an
+// admin, and approved ones are received once they arrive back.
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+)
+
+// ReturnInput represents a buyer's request to return items of a delivered
+// order
+type ReturnInput struct {
+	Items   []ReturnItemInput `json:"items" validate:"required,min=1,max=100,unique=OrderItemID,dive"`
+	Reason  string            `json:"reason" validate:"required,oneof=damaged wrong_item not_as_described no_longer_needed other"`
+	Comment string            `json:"comment" validate:"max=1000"`
+}
+
+// ReturnItemInput is an order item to return and how much of it
+type ReturnItemInput struct {
+	OrderItemID string `json:"orderItemId" validate:"required,uuid"`
+	Quantity    int    `json:"quantity" validate:"required,min=1"` // in grams when sold by weight
+}
+
+// ReturnApprovalInput represents an admin approving a return with the
+// label the buyer ships it back with
+type ReturnApprovalInput struct {
+	Carrier        string `json:"carrier" validate:"required,max=50"`
+	TrackingNumber string `json:"trackingNumber" validate:"required,max=100"`
+	LabelURL       string `json:"labelUrl" validate:"required,url,max=2000"`
+}
+
+// ReturnRejectionInput represents an admin rejecting a return. The reason
+// is passed on to the buyer.
+type ReturnRejectionInput struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// ReturnWindowInput sets how many days after delivery a product's or a
+// category's items may be returned. Nil falls back to the category's
+// window, then to the default; 0 makes them not returnable.
+type ReturnWindowInput struct {
+	Days *int `json:"days" validate:"omitempty,max=365"`
+}
+
+// ReturnLabel is the label an approved return is shipped back with
+type ReturnLabel struct {
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"trackingNumber"`
+	URL            string `json:"url"`
+}
+
+// OrderReturn is a buyer's return of items of one of their orders
+type OrderReturn struct {
+	ID              string            `json:"id"`
+	OrderID         string            `json:"orderId"`
+	Status          string            `json:"status"`
+	Reason          string            `json:"reason"`
+	Comment         string            `json:"comment,omitempty"`
+	Items           []OrderReturnItem `json:"items"`
+	Label           *ReturnLabel      `json:"label"` // set once approved
+	RejectionReason string            `json:"rejectionReason,omitempty"`
+	Refund          *money.Money      `json:"refund"`                    // set once received
+	RefundReference string            `json:"refundReference,omitempty"` // the gateway's refund, when it refunded it
+	CreatedAt       time.Time         `json:"createdAt"`
+	ReviewedAt      *time.Time        `json:"reviewedAt"`
+	ReceivedAt      *time.Time        `json:"receivedAt"`
+}
+
+// OrderReturnItem is an order item on a return
+type OrderReturnItem struct {
+	OrderItemID string `json:"orderItemId"`
+	ProductID   string `json:"productId"`
+	Quantity    int    `json:"quantity"` // in grams when sold by weight
+}
+
+// OrderReturnPage is a page of returns
+type OrderReturnPage struct {
+	Returns []OrderReturn `json:"returns"`
+	Total   int           `json:"total"`
+}
@@ -0,0 +1,125 @@
+package models
+
+import "time"
+
+// Review statuses. Imported reviews are hidden until an admin publishes or
+// rejects them.
+const (
+	ReviewPublished = "published"
+	ReviewImported  = "imported"
+	ReviewRejected  = "rejected"
+)
+
+// Review represents a buyer's review of a product
+type Review struct {
+	ID                 string       `json:"id"`
+	ProductID          string       `json:"productId"`
+	BuyerID            string       `json:"buyerId"` // empty for imported reviews by reviewers without an account
+	AuthorName         string       `json:"authorName,omitempty"`
+	SellerID           string       `json:"sellerId"`
+	Status             string       `json:"status"`
+	Source             string       `json:"source,omitempty"`   // the platform an imported review came from
+	SourceID           string       `json:"sourceId,omitempty"` // its ID there
+	Rating             int          `json:"rating"`
+	Title              string       `json:"title"`
+	Comment            string       `json:"comment"`
+	IsVerifiedPurchase bool         `json:"isVerifiedPurchase"`
+	HelpfulVotes       int          `json:"helpfulVotes"`
+	CreatedAt          time.Time    `json:"createdAt"`
+	UpdatedAt          time.Time    `json:"updatedAt"`
+	EditedAt           *time.Time   `json:"editedAt,omitempty"`  // last edit by the author
+	DeletedAt          *time.Time   `json:"deletedAt,omitempty"` // set while the author can still restore it
+	Reply              *ReviewReply `json:"reply,omitempty"`     // the seller's reply, if any
+}
+
+// ReviewReply is the seller's public reply to a review of their product
+type ReviewReply struct {
+	ReviewID  string     `json:"reviewId"`
+	SellerID  string     `json:"sellerId"`
+	Comment   string     `json:"comment"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+}
+
+// ReviewInput represents the payload for reviewing a product
+type ReviewInput struct {
+	Rating  int    `json:"rating" validate:"required,min=1,max=5"`
+	Title   string `json:"title" validate:"max=255"`
+	Comment string `json:"comment" validate:"max=5000"`
+}
+
+// ReviewReplyInput represents the payload for replying to a review, checked
+// like the comment of a review
+type ReviewReplyInput struct {
+	Comment string `json:"comment" validate:"required,max=5000"`
+}
+
+// ReviewPage represents a page of a product's reviews
+type ReviewPage struct {
+	Reviews []Review `json:"reviews"`
+	Total   int      `json:"total"`
+}
+
+// ReviewImportInput represents reviews imported from another platform,
+// named by source. The number of reviews is limited while decoding, by the
+// bulk config.
+type ReviewImportInput struct {
+	Source  string             `json:"source" validate:"required,max=50"`
+	Reviews []ReviewImportItem `json:"reviews" validate:"required,min=1,dive"`
+}
+
+// ReviewImportItem is one imported review. SourceID is its ID on the source
+// platform; BuyerID names the reviewer's account here, if they have one,
+// and AuthorName the reviewer otherwise.
+type ReviewImportItem struct {
+	SourceID           string    `json:"sourceId" validate:"required,max=255"`
+	ProductID          string    `json:"productId" validate:"required,uuid"`
+	BuyerID            string    `json:"buyerId" validate:"omitempty,uuid"`
+	AuthorName         string    `json:"authorName" validate:"required_without=BuyerID,max=100"`
+	Rating             int       `json:"rating" validate:"required,min=1,max=5"`
+	Title              string    `json:"title" validate:"max=255"`
+	Comment            string    `json:"comment" validate:"max=5000"`
+	IsVerifiedPurchase bool      `json:"isVerifiedPurchase"`
+	CreatedAt          time.Time `json:"createdAt" validate:"required"`
+}
+
+// ReviewImportResult reports an import: how many reviews were staged, how
+// many had been imported before, and those skipped, with why
+type ReviewImportResult struct {
+	Imported   int                `json:"imported"`
+	Duplicates int                `json:"duplicates"`
+	Skipped    []ReviewImportSkip `json:"skipped"`
+}
+
+// ReviewImportSkip is an imported review that was left out. Reason is
+// product_not_found, buyer_not_found or own_product.
+type ReviewImportSkip struct {
+	SourceID string `json:"sourceId"`
+	Reason   string `json:"reason"`
+}
+
+// ReviewImportFilter selects imported reviews awaiting moderation. Keyword
+// matches their title or comment, case-insensitively.
+type ReviewImportFilter struct {
+	Source  string `json:"source" validate:"max=50"`
+	Ratings []int  `json:"ratings" validate:"max=5,dive,min=1,max=5"`
+	Keyword string `json:"keyword" validate:"max=100"`
+}
+
+// ReviewModerationInput represents an admin approving or rejecting imported
+// reviews, those listed in ReviewIDs or, without them, all matching the
+// filter. One of them is required.
+type ReviewModerationInput struct {
+	Action    string   `json:"action" validate:"required,oneof=approve reject"`
+	ReviewIDs []string `json:"reviewIds" validate:"max=1000,unique,dive,uuid"`
+	ReviewImportFilter
+}
+
+// ReviewModerationResult reports how many imported reviews a moderation
+// approved or rejected, and how many products had their rating recomputed
+type ReviewModerationResult struct {
+	Action   string `json:"action"`
+	Reviews  int    `json:"reviews"`
+	Products int    `json:"products"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// SearchAnalytics summarizes logged search queries over a period
+type SearchAnalytics struct {
+	Since                time.Time         `json:"since"`
+	Searches             int               `json:"searches"`
+	ZeroResultSearches   int               `json:"zeroResultSearches"`
+	TopQueries           []SearchQueryStat `json:"topQueries"`
+	TopZeroResultQueries []SearchQueryStat `json:"topZeroResultQueries"`
+}
+
+// SearchHighlight shows why a product matched a search. Title and
+// Description are snippets of the product's text, HTML-escaped, with the
+// matched words wrapped in <mark>; Matches locates the query's terms in the
+// full title and description.
+type SearchHighlight struct {
+	Title       string      `json:"title,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Matches     []TextMatch `json:"matches"`
+}
+
+// TextMatch is a matched term in a product field, from Start up to End,
+// counted in characters
+type TextMatch struct {
+	Field string `json:"field"` // title or description
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// SearchQueryStat summarizes the searches for one normalized query
+type SearchQueryStat struct {
+	Query        string  `json:"query"`
+	Searches     int     `json:"searches"`
+	Users        int     `json:"users"` // distinct signed-in searchers
+	AvgResults   float64 `json:"avgResults"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+}
+
+// SemanticSearchInput represents a semantic search request
+type SemanticSearchInput struct {
+	Query      string `json:"query" validate:"required,max=500"`
+	CategoryID string `json:"categoryId" validate:"omitempty,uuid"`
+	Limit      int    `json:"limit" validate:"omitempty,gte=1,lte=50"`
+	Offset     int    `json:"offset" validate:"gte=0"`
+}
@@ -0,0 +1,129 @@
+package models
+
+import "time"
+
+// Seller verification statuses. Only verified sellers can list products and
+// be paid out; suspended sellers' products are left out of listings.
+const (
+	SellerPending   = "pending"
+	SellerVerified  = "verified"
+	SellerSuspended = "suspended"
+)
+
+// SellerStatusInput represents an admin's change of a seller's status. A
+// reason is required for anything but verifying, and is passed on to the
+// seller.
+type SellerStatusInput struct {
+	Status string `json:"status" validate:"required,oneof=pending verified suspended"`
+	Reason string `json:"reason" validate:"required_unless=Status verified,max=500"`
+}
+
+// Seller is a seller account as reviewed by admins
+type Seller struct {
+	ID              string     `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	FullName        string     `json:"fullName,omitempty"`
+	Status          string     `json:"status"`
+	Products        int        `json:"products"` // not deleted
+	AcceptingOrders bool       `json:"acceptingOrders"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	StatusChangedAt *time.Time `json:"statusChangedAt,omitempty"`
+}
+
+// SellerPage is a page of sellers, oldest first
+type SellerPage struct {
+	Sellers []Seller `json:"sellers"`
+	Total   int      `json:"total"`
+}
+
+// SellerStatusChange is an audit entry for a change of a seller's status
+type SellerStatusChange struct {
+	ID         string    `json:"id"`
+	SellerID   string    `json:"sellerId"`
+	FromStatus string    `json:"fromStatus"`
+	ToStatus   string    `json:"toStatus"`
+	Reason     string    `json:"reason,omitempty"`
+	ChangedBy  *string   `json:"changedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// SellerStatusChangePage is a page of a seller's status changes, newest first
+type SellerStatusChangePage struct {
+	Changes []SellerStatusChange `json:"changes"`
+	Total   int                  `json:"total"`
+}
+
+// SellerLocationInput represents a seller setting where they are
+type SellerLocationInput struct {
+	Latitude  *float64 `json:"latitude" validate:"required,gte=-90,lte=90"`
+	Longitude *float64 `json:"longitude" validate:"required,gte=-180,lte=180"`
+}
+
+// SellerLocation is where a seller is; both coordinates are nil when they
+// haven't said
+type SellerLocation struct {
+	SellerID  string   `json:"sellerId"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+// BusinessHours is a seller's weekly opening hours in their timezone. Days
+// are keyed by lowercase weekday name, "monday" to "sunday"; a day without
+// ranges is closed.
+type BusinessHours struct {
+	Timezone string                  `json:"timezone" validate:"required,max=64"` // IANA name, like "Europe/London"
+	Days     map[string][]HoursRange `json:"days" validate:"required,max=7,dive,max=4,dive"`
+}
+
+// HoursRange is a range of a day a seller is open, in 24-hour HH:MM local
+// time. A range closing at or before it opens runs overnight, closing the
+// next day; "24:00" closes at midnight.
+type HoursRange struct {
+	Open  string `json:"open" validate:"required"`
+	Close string `json:"close" validate:"required"`
+}
+
+// SellerAvailabilityInput represents a seller's change of their
+// availability. Clearing businessHours leaves the seller always open while
+// they accept orders.
+type SellerAvailabilityInput struct {
+	AcceptingOrders *bool          `json:"acceptingOrders" validate:"required"`
+	BusinessHours   *BusinessHours `json:"businessHours" validate:"omitempty"`
+}
+
+// SellerAvailability is whether a seller takes orders now, from their hours
+// and whether they are accepting orders
+type SellerAvailability struct {
+	SellerID        string         `json:"sellerId"`
+	AcceptingOrders bool           `json:"acceptingOrders"`
+	BusinessHours   *BusinessHours `json:"businessHours,omitempty"`
+	OpenNow         bool           `json:"openNow"` // accepting orders and within business hours
+}
+
+// SellerFollowInput represents the payload for following a seller
+type SellerFollowInput struct {
+	Notify *bool `json:"notify"` // notified of each new product when unset
+}
+
+// SellerFollow is a user's follow of a seller
+type SellerFollow struct {
+	SellerID  string    `json:"sellerId"`
+	Notify    bool      `json:"notify"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// FeedFilter selects a page of a user's feed: the products published after
+// Cursor when set, in stock unless IncludeOutOfStock
+type FeedFilter struct {
+	Cursor            string
+	Limit             int
+	IncludeOutOfStock bool
+}
+
+// FeedPage is a page of the products of the sellers a user follows, newest
+// first. NextCursor is empty on the last page.
+type FeedPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
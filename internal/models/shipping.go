@@ -0,0 +1,22 @@
+package models
+
+import "github.com/greens-marketplace/internal/money"
+
+// Shipping methods
+const (
+	ShippingStandard = "standard"
+	ShippingExpress  = "express"
+	ShippingPickup   = "pickup"
+)
+
+// ShippingOption is a method an order can ship by, what it would cost and
+// when the order would arrive by it. Dates are YYYY-MM-DD.
+type ShippingOption struct {
+	Method       string       `json:"method" xml:"method"`
+	Name         string       `json:"name" xml:"name"`
+	Cost         money.Money  `json:"cost" xml:"cost"`
+	Free         bool         `json:"free" xml:"free"`                             // the method costs the order nothing
+	FreeOver     *money.Money `json:"freeOver,omitempty" xml:"freeOver,omitempty"` // the threshold, when the method has one
+	EarliestDate string       `json:"earliestDate" xml:"earliestDate"`
+	LatestDate   string       `json:"latestDate" xml:"latestDate"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// ShippingLabelInput describes the parcel a label is bought for
+type ShippingLabelInput struct {
+	Weight int `json:"weight" validate:"required,min=1,max=70000"` // in grams
+	Length int `json:"length" validate:"required,min=1,max=270"`   // in centimetres, the longest side
+	Width  int `json:"width" validate:"required,min=1,max=270"`
+	Height int `json:"height" validate:"required,min=1,max=270"`
+}
+
+// ShippingLabel is the label an order ships with. URL serves the label
+// file to the order's viewers.
+type ShippingLabel struct {
+	OrderID        string             `json:"orderId"`
+	Carrier        string             `json:"carrier"`
+	Service        string             `json:"service"`
+	TrackingNumber string             `json:"trackingNumber"`
+	URL            string             `json:"url"`
+	ContentType    string             `json:"contentType"`
+	Cost           money.Money        `json:"cost"`
+	Parcel         ShippingLabelInput `json:"parcel"`
+	CreatedBy      string             `json:"createdBy,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Stats series granularities
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+// MarketplaceStats aggregates marketplace activity over a window for the
+// admin dashboard. Orders and GMV count orders placed in the window that were
+// paid and not cancelled; GMV is given per currency. A figure whose query
+// failed is left null, or its list empty, with a warning saying so.
+type MarketplaceStats struct {
+	From          time.Time      `json:"from"`
+	To            time.Time      `json:"to"`
+	Granularity   string         `json:"granularity"`
+	TotalUsers    *int           `json:"totalUsers"`    // registered before the window's end
+	NewUsers      *int           `json:"newUsers"`      // registered in the window
+	ActiveSellers *int           `json:"activeSellers"` // sellers with an order in the window
+	Orders        *int           `json:"orders"`
+	GMV           []money.Money  `json:"gmv"`
+	Series        []StatsPoint   `json:"series"` // every period of the window, oldest first
+	TopCategories []CategoryStat `json:"topCategories"`
+	Warnings      []string       `json:"warnings,omitempty"`
+	GeneratedAt   time.Time      `json:"generatedAt"`
+}
+
+// StatsPoint is one period of the stats series, starting at Period (UTC)
+type StatsPoint struct {
+	Period time.Time     `json:"period"`
+	Orders int           `json:"orders"`
+	GMV    []money.Money `json:"gmv"`
+}
+
+// CategoryStat is a primary category's share of the window's orders
+type CategoryStat struct {
+	CategoryID string        `json:"categoryId"`
+	Name       string        `json:"name"`
+	Orders     int           `json:"orders"` // orders with an item in the category
+	GMV        []money.Money `json:"gmv"`    // of the category's items
+}
+
+// UserStats summarizes a buyer's own orders. Like marketplace stats it counts
+// orders that were paid and not cancelled; spend is net of refunds and given
+// per currency.
+type UserStats struct {
+	Orders           int              `json:"orders"`
+	Spent            []money.Money    `json:"spent"`
+	Months           []UserStatsMonth `json:"months"` // the last 12 months, oldest first
+	TopProducts      []ProductStat    `json:"topProducts"`
+	FavoriteCategory *CategoryStat    `json:"favoriteCategory"` // null until the buyer has an order
+	GeneratedAt      time.Time        `json:"generatedAt"`
+}
+
+// UserStatsMonth is a buyer's orders and spend in a calendar month (UTC)
+type UserStatsMonth struct {
+	Month  time.Time     `json:"month"`
+	Orders int           `json:"orders"`
+	Spent  []money.Money `json:"spent"`
+}
+
+// ProductStat is how often a buyer has ordered a product
+type ProductStat struct {
+	ProductID string `json:"productId"`
+	Name      string `json:"name"`
+	Orders    int    `json:"orders"` // orders with the product in them
+}
@@ -0,0 +1,88 @@
+package models
+
+import "time"
+
+// StockAdjustmentInput represents a bulk stock adjustment request. The
+// number of items is limited while decoding, by the bulk config.
+type StockAdjustmentInput struct {
+	Items []StockAdjustmentItem `json:"items" validate:"required,min=1,unique=ProductID,dive"`
+}
+
+// StockAdjustmentItem is a change to one product's stock; a negative delta
+// records shrinkage
+type StockAdjustmentItem struct {
+	ProductID string `json:"productId" validate:"required,uuid"`
+	Delta     int    `json:"delta" validate:"required,min=-100000,max=100000"`
+}
+
+// StockLevel is a product's stock after an adjustment
+type StockLevel struct {
+	ProductID        string `json:"productId"`
+	Delta            int    `json:"delta"`
+	PreviousQuantity int    `json:"previousQuantity"`
+	StockQuantity    int    `json:"stockQuantity"`
+}
+
+// StockAdjustment is an applied bulk stock adjustment
+type StockAdjustment struct {
+	ID        string       `json:"id"`
+	SellerID  string       `json:"sellerId"`
+	Items     []StockLevel `json:"items"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+// StockDeliveryInput represents a delivery received from a supplier.
+// Reference identifies the delivery among the seller's, so posting it again
+// doesn't restock twice.
+type StockDeliveryInput struct {
+	Reference string              `json:"reference" validate:"required,max=100"`
+	Items     []StockDeliveryItem `json:"items" validate:"required,min=1,unique=ProductID,dive"`
+}
+
+// StockDeliveryItem is the quantity of a product received. QuantityOrdered,
+// when given, records a partial delivery when more was ordered than received.
+type StockDeliveryItem struct {
+	ProductID        string `json:"productId" validate:"required,uuid"`
+	QuantityOrdered  *int   `json:"quantityOrdered" validate:"omitempty,min=0,max=100000"`
+	QuantityReceived int    `json:"quantityReceived" validate:"min=0,max=100000"`
+}
+
+// StockDeliveryLine is a product's stock after a delivery. Shortfall is how
+// many fewer were received than ordered.
+type StockDeliveryLine struct {
+	ProductID        string `json:"productId"`
+	QuantityOrdered  *int   `json:"quantityOrdered,omitempty"`
+	QuantityReceived int    `json:"quantityReceived"`
+	Shortfall        int    `json:"shortfall"`
+	PreviousQuantity int    `json:"previousQuantity"`
+	StockQuantity    int    `json:"stockQuantity"`
+}
+
+// StockDelivery is a received delivery. Replayed is set when the delivery
+// had already been recorded and nothing was restocked this time.
+type StockDelivery struct {
+	ID        string              `json:"id"`
+	SellerID  string              `json:"sellerId"`
+	Reference string              `json:"reference"`
+	Items     []StockDeliveryLine `json:"items"`
+	Replayed  bool                `json:"replayed"`
+	CreatedAt time.Time           `json:"createdAt"`
+}
+
+// StockMovement is an entry in a product's stock ledger
+type StockMovement struct {
+	ID          int64     `json:"id"`
+	ProductID   string    `json:"productId"`
+	Delta       int       `json:"delta"`
+	Reason      string    `json:"reason"` // initial, adjustment, correction, order, cancellation, reservation, release, delivery
+	ReferenceID *string   `json:"referenceId"`
+	ActorID     *string   `json:"actorId"`
+	Balance     int       `json:"balance"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// StockMovementPage is a page of a product's stock ledger, newest first
+type StockMovementPage struct {
+	Movements []StockMovement `json:"movements"`
+	Total     int             `json:"total"`
+}
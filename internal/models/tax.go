@@ -0,0 +1,8 @@
+package models
+
+// TaxExemptionInput sets why a product's, a category's or a buyer's orders
+// are exempt from sales tax. Nil makes them taxable again; a product's then
+// falls back to its category's exemption.
+type TaxExemptionInput struct {
+	Reason *string `json:"reason" validate:"omitempty,min=1,max=200"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// Webhook delivery statuses
+const (
+	WebhookDeliverySucceeded = "succeeded" // the subscriber answered 2xx
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookSubscription is a URL subscribed to events. Secret is only
+// returned when the subscription is created and when it is rotated.
+type WebhookSubscription struct {
+	ID              string    `json:"id"`
+	OwnerID         string    `json:"ownerId"`
+	URL             string    `json:"url"`
+	Events          []string  `json:"events"`
+	IsActive        bool      `json:"isActive"`
+	PayloadVersion  int       `json:"payloadVersion"`
+	Secret          string    `json:"secret,omitempty"`
+	SecretRotatedAt time.Time `json:"secretRotatedAt"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// WebhookInput represents the payload for creating or updating a webhook
+// subscription. IsActive defaults to true and PayloadVersion to the current
+// version on create; both are left as they are on update when omitted.
+type WebhookInput struct {
+	URL            string   `json:"url" validate:"required,url,max=2048"`
+	Events         []string `json:"events" validate:"required,min=1,max=50,unique,dive,required,max=100"`
+	IsActive       *bool    `json:"isActive"`
+	PayloadVersion *int     `json:"payloadVersion"`
+}
+
+// WebhookPayloadVersion is a webhook payload version subscriptions can
+// register for, and what it changed from the one before
+type WebhookPayloadVersion struct {
+	Version int    `json:"version"`
+	Changes string `json:"changes"`
+	Current bool   `json:"current"` // given to subscriptions that don't ask for a version
+}
+
+// WebhookDelivery is an attempt to deliver an event to a subscription
+type WebhookDelivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscriptionId"`
+	EventID        string    `json:"eventId"`
+	EventType      string    `json:"eventType"`
+	PayloadVersion *int      `json:"payloadVersion,omitempty"` // unset for deliveries before versions
+	Status         string    `json:"status"`
+	ResponseCode   *int      `json:"responseCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	DurationMs     int       `json:"durationMs"`
+	AttemptedAt    time.Time `json:"attemptedAt"`
+}
+
+// WebhookSubscriptionPage is a page of webhook subscriptions, oldest first
+type WebhookSubscriptionPage struct {
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+	Total         int                   `json:"total"`
+}
+
+// WebhookDeliveryPage is a page of a subscription's delivery attempts,
+// newest first
+type WebhookDeliveryPage struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Total      int               `json:"total"`
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Unit types. A product sold by weight is priced per WeightUnit, and its
+// stock, order quantity rules and line quantities are held in grams, the way
+// money is held in minor units, so they stay exact integers.
+const (
+	UnitTypeEach   = "each"
+	UnitTypeWeight = "weight"
+
+	WeightUnit     = "kg"
+	GramsPerUnit   = 1000
+	weightDecimals = 3
+)
+
+// Weight is a weight in grams. It is written as a decimal string of kg with
+// every gram, such as "1.250", and read from such a string or a JSON number.
+type Weight int
+
+// ParseWeight parses a decimal weight in kg, such as "1.25", exactly.
+// Weights finer than a gram are rejected, not rounded.
+func ParseWeight(s string) (Weight, error) {
+	d, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid weight %q", s)
+	}
+	grams := d.Shift(weightDecimals)
+	if !grams.IsInteger() {
+		return 0, fmt.Errorf("weight allows %d decimal places", weightDecimals)
+	}
+	if grams.GreaterThan(decimal.NewFromInt(math.MaxInt32)) || grams.LessThan(decimal.NewFromInt(math.MinInt32)) {
+		return 0, fmt.Errorf("weight %q out of range", s)
+	}
+	return Weight(grams.IntPart()), nil
+}
+
+// String returns the weight in kg with every gram, such as "1.250"
+func (w Weight) String() string {
+	return decimal.New(int64(w), -weightDecimals).StringFixed(weightDecimals)
+}
+
+// MarshalJSON encodes w as a decimal string of kg
+func (w Weight) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.String())
+}
+
+// MarshalXML encodes w as a decimal of kg within start
+func (w Weight) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(w.String(), start)
+}
+
+// UnmarshalJSON decodes a decimal string or number of kg
+func (w *Weight) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	text := string(data)
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		text = s
+	}
+	parsed, err := ParseWeight(text)
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
+
+// LineWeight returns the weight of quantity of a product of unitType, or nil
+// when it isn't sold by weight
+func LineWeight(unitType string, quantity int) *Weight {
+	if unitType != UnitTypeWeight {
+		return nil
+	}
+	w := Weight(quantity)
+	return &w
+}
+
+// LineTotal returns the total of quantity of a product priced at price: the
+// unit price times the quantity, or for a product sold by weight the price
+// per kg times the grams, rounded to the minor unit
+func LineTotal(price money.Money, unitType string, quantity int) (money.Money, error) {
+	if unitType == UnitTypeWeight {
+		return price.MulFrac(int64(quantity), GramsPerUnit)
+	}
+	return price.Mul(int64(quantity))
+}
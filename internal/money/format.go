@@ -0,0 +1,93 @@
+package money
+
+import "strings"
+
+// nbsp keeps a symbol or digit group on the same line as the number
+const nbsp = "\u00a0"
+
+// numberFormat is how a locale writes an amount
+type numberFormat struct {
+	decimal     string
+	group       string
+	symbolAfter bool   // "1.234,56 €" rather than "€1,234.56"
+	symbolSpace string // between the symbol and the number
+}
+
+// formats are keyed by lowercase language or language-region tag
+var formats = map[string]numberFormat{
+	"en":    {decimal: ".", group: ","},
+	"de":    {decimal: ",", group: ".", symbolAfter: true, symbolSpace: nbsp},
+	"de-ch": {decimal: ".", group: "’", symbolSpace: nbsp},
+	"es":    {decimal: ",", group: ".", symbolAfter: true, symbolSpace: nbsp},
+	"es-mx": {decimal: ".", group: ","},
+	"fr":    {decimal: ",", group: "\u202f", symbolAfter: true, symbolSpace: nbsp},
+	"it":    {decimal: ",", group: ".", symbolAfter: true, symbolSpace: nbsp},
+	"ja":    {decimal: ".", group: ","},
+	"nl":    {decimal: ",", group: ".", symbolSpace: nbsp},
+	"pt":    {decimal: ",", group: ".", symbolAfter: true, symbolSpace: nbsp},
+	"pt-br": {decimal: ",", group: ".", symbolSpace: nbsp},
+	"sv":    {decimal: ",", group: nbsp, symbolAfter: true, symbolSpace: nbsp},
+}
+
+// symbols are the display symbols of common currencies; others show their code
+var symbols = map[string]string{
+	"AUD": "A$", "BRL": "R$", "CAD": "CA$", "CHF": "CHF", "CNY": "CN¥", "EUR": "€", "GBP": "£",
+	"INR": "₹", "JPY": "¥", "KRW": "₩", "MXN": "MX$", "SEK": "kr", "USD": "$",
+}
+
+// Format writes m for display in locale, a language tag such as "de-DE" or
+// "fr". Unknown locales fall back to their language, then to English.
+func (m Money) Format(locale string) string {
+	f := lookupFormat(locale)
+
+	symbol, ok := symbols[m.Currency]
+	if !ok {
+		symbol = m.Currency
+	}
+	space := f.symbolSpace
+	if !ok && space == "" {
+		space = nbsp
+	}
+
+	sign := ""
+	if m.Amount < 0 {
+		sign = "-"
+	}
+	number := groupDigits(strings.TrimPrefix(m.Decimal(), "-"), f)
+
+	if f.symbolAfter {
+		return sign + number + space + symbol
+	}
+	return sign + symbol + space + number
+}
+
+func lookupFormat(locale string) numberFormat {
+	tag := strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if f, ok := formats[tag]; ok {
+		return f
+	}
+	if i := strings.IndexByte(tag, '-'); i > 0 {
+		if f, ok := formats[tag[:i]]; ok {
+			return f
+		}
+	}
+	return formats["en"]
+}
+
+// groupDigits rewrites an unsigned decimal such as "1234.56" with f's
+// separators
+func groupDigits(s string, f numberFormat) string {
+	whole, fraction, hasFraction := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(f.group)
+		}
+		b.WriteRune(c)
+	}
+	if hasFraction {
+		b.WriteString(f.decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
@@ -0,0 +1,287 @@
+package money
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrOverflow         = errors.New("amount out of range")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrInvalidCurrency  = errors.New("invalid currency")
+)
+
+// Money is an exact amount of a currency, held in the currency's minor units:
+// cents for USD, yen for JPY. Arithmetic never mixes currencies and fails
+// rather than overflowing.
+type Money struct {
+	Amount   int64  // minor units
+	Currency string // ISO 4217 code
+}
+
+// exponents lists the currencies whose minor unit isn't a hundredth of the
+// major unit. Keep in step with the currency_exponent SQL function.
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Exponent returns the number of decimal places in currency's minor unit
+func Exponent(currency string) int {
+	if e, ok := exponents[currency]; ok {
+		return e
+	}
+	return 2
+}
+
+// ValidCurrency reports whether code is shaped like an ISO 4217 code
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// New returns amount minor units of currency
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns no money in currency
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// Parse parses a decimal amount in major units, such as "19.99", exactly.
+// Amounts finer than the currency's minor unit are rejected, not rounded.
+func Parse(amount, currency string) (Money, error) {
+	if !ValidCurrency(currency) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidCurrency, currency)
+	}
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+	exp := Exponent(currency)
+	minor := d.Shift(int32(exp))
+	if !minor.IsInteger() {
+		return Money{}, fmt.Errorf("%w: %s allows %d decimal places", ErrInvalidAmount, currency, exp)
+	}
+	if minor.GreaterThan(decimal.NewFromInt(math.MaxInt64)) || minor.LessThan(decimal.NewFromInt(math.MinInt64)) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: minor.IntPart(), Currency: currency}, nil
+}
+
+// Add returns m + o
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount || o.Amount < 0 && m.Amount < math.MinInt64-o.Amount {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o
+func (m Money) Sub(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if o.Amount < 0 && m.Amount > math.MaxInt64+o.Amount || o.Amount > 0 && m.Amount < math.MinInt64+o.Amount {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m times n, such as a unit price times a quantity
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	product := m.Amount * n
+	if product/n != m.Amount || m.Amount == -1 && n == math.MinInt64 || n == -1 && m.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// MulFrac returns m times n/d rounded half away from zero to the minor
+// unit, such as a price per kg times a weight in grams over 1000. d must be
+// positive.
+func (m Money) MulFrac(n, d int64) (Money, error) {
+	if d <= 0 {
+		return Money{}, fmt.Errorf("%w: non-positive divisor", ErrInvalidAmount)
+	}
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(n))
+	quotient, remainder := new(big.Int).QuoRem(product, big.NewInt(d), new(big.Int))
+	if twice := new(big.Int).Abs(remainder); twice.Lsh(twice, 1).Cmp(big.NewInt(d)) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(product.Sign())))
+	}
+	if !quotient.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: quotient.Int64(), Currency: m.Currency}, nil
+}
+
+// Sum returns the total of amounts, all of which must be in currency
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := Zero(currency)
+	for _, a := range amounts {
+		var err error
+		if total, err = total.Add(a); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// Allocate splits m over weights in proportion to them by the largest
+// remainder method: each share is rounded toward zero to the minor unit, and
+// the minor units left over go one each to the shares with the largest
+// remainders, the earliest on a tie. The shares always sum to m exactly.
+// Weights must be in m's currency and not negative; when none is positive,
+// m is split evenly.
+func (m Money) Allocate(weights []Money) ([]Money, error) {
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("%w: nothing to allocate over", ErrInvalidAmount)
+	}
+	if m.Amount == math.MinInt64 {
+		return nil, ErrOverflow
+	}
+	parts := make([]*big.Int, len(weights))
+	total := new(big.Int)
+	for i, w := range weights {
+		if err := m.sameCurrency(w); err != nil {
+			return nil, err
+		}
+		if w.Amount < 0 {
+			return nil, fmt.Errorf("%w: negative weight", ErrInvalidAmount)
+		}
+		parts[i] = big.NewInt(w.Amount)
+		total.Add(total, parts[i])
+	}
+	if total.Sign() == 0 {
+		for i := range parts {
+			parts[i].SetInt64(1)
+		}
+		total.SetInt64(int64(len(parts)))
+	}
+
+	amount := m.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	shares := make([]Money, len(weights))
+	remainders := make([]*big.Int, len(weights))
+	left := amount
+	for i, part := range parts {
+		quotient, remainder := new(big.Int).QuoRem(part.Mul(part, big.NewInt(amount)), total, new(big.Int))
+		shares[i] = Money{Amount: quotient.Int64(), Currency: m.Currency}
+		remainders[i] = remainder
+		left -= shares[i].Amount
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]].Cmp(remainders[order[b]]) > 0
+	})
+	for _, i := range order[:left] {
+		shares[i].Amount++
+	}
+	if m.Amount < 0 {
+		for i := range shares {
+			shares[i].Amount = -shares[i].Amount
+		}
+	}
+	return shares, nil
+}
+
+// IsZero reports whether m is no money
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) sameCurrency(o Money) error {
+	if m.Currency != o.Currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return nil
+}
+
+// Decimal returns the amount in major units with every minor-unit digit,
+// such as "19.90"
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	return decimal.New(m.Amount, -int32(exp)).StringFixed(int32(exp))
+}
+
+// String returns the amount and currency, such as "19.90 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// moneyJSON is the wire form of Money. The amount is a decimal string so no
+// client parses it into a float.
+type moneyJSON struct {
+	Amount   string `json:"amount" xml:"amount"`
+	Currency string `json:"currency" xml:"currency"`
+}
+
+// MarshalJSON encodes m as {"amount": "19.90", "currency": "USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+// MarshalXML encodes m as <amount>19.90</amount><currency>USD</currency>
+// within start
+func (m Money) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(moneyJSON{Amount: m.Decimal(), Currency: m.Currency}, start)
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON. The amount may also
+// be a JSON number, which is read from its text rather than through a float.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var v struct {
+		Amount   json.RawMessage `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Amount) == 0 {
+		return fmt.Errorf("%w: amount is required", ErrInvalidAmount)
+	}
+	amount := string(v.Amount)
+	var s string
+	if err := json.Unmarshal(v.Amount, &s); err == nil {
+		amount = s
+	}
+	parsed, err := Parse(strings.TrimSpace(amount), strings.ToUpper(v.Currency))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
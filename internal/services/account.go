@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Account suspension
+//
+// Admins suspend abusive accounts rather than deleting them. Suspending a
+// user revokes every token issued to them so far, and RequireActiveAccount
+// checks each request against the account's status, so a suspension takes
+// effect at once rather than when tokens expire. Statuses are cached in
+// Redis and rewritten whenever a suspension changes. A suspended user's
+// reviews are hidden from their products, and left out of their ratings,
+// until the suspension is lifted.
+
+// EventAccountSuspensionChanged is published when a user is suspended or
+// their suspension lifted
+const EventAccountSuspensionChanged = "account.suspension_changed"
+
+var ErrAccountNotFound = errors.New("user not found")
+
+// AccountSuspendedError is returned when a suspended user signs in
+type AccountSuspendedError struct {
+	Reason string
+}
+
+func (e *AccountSuspendedError) Error() string {
+	return "account is suspended"
+}
+
+// Message explains to the user why they were refused
+func (e *AccountSuspendedError) Message() string {
+	if e.Reason == "" {
+		return "Your account is suspended"
+	}
+	return "Your account is suspended: " + e.Reason
+}
+
+// AccountSuspensionChangedEvent is the payload of
+// EventAccountSuspensionChanged
+type AccountSuspensionChangedEvent struct {
+	SuspensionID string   `json:"suspensionId"`
+	UserID       string   `json:"userId"`
+	Suspended    bool     `json:"suspended"`
+	Reason       string   `json:"reason,omitempty"`
+	ProductIDs   []string `json:"productIds,omitempty"` // whose ratings the user's reviews count toward
+}
+
+// AccountService handles the suspension, impersonation and deletion of user
+// accounts
+type AccountService struct {
+	db       *database.PostgresDB
+	redis    *database.RedisClient
+	products *ProductService
+	users    *UserService
+	jwt      config.JWTConfig
+	ttls     *cache.TTLs
+}
+
+// NewAccountService creates a new account service. Account statuses are
+// cached for their cache type's TTL in ttls.
+func NewAccountService(db *database.PostgresDB, redis *database.RedisClient, products *ProductService, users *UserService, jwt config.JWTConfig, ttls *cache.TTLs) *AccountService {
+	return &AccountService{db: db, redis: redis, products: products, users: users, jwt: jwt, ttls: ttls}
+}
+
+func accountStatusKey(userID string) string {
+	return "account_status:" + userID
+}
+
+// AccountStatus returns whether user userID may use their account, or nil
+// when there is no such user. When Redis is unavailable it is read from the
+// database every time.
+func (s *AccountService) AccountStatus(ctx context.Context, userID string) (*models.AccountStatus, error) {
+	key := accountStatusKey(userID)
+	data, err := s.redis.Get(ctx, key)
+	if err == nil {
+		var status models.AccountStatus
+		if err := json.Unmarshal([]byte(data), &status); err == nil {
+			return &status, nil
+		}
+	} else if err != redis.Nil {
+		log.Warn().Err(err).Str("user_id", userID).Msg("Failed to read cached account status")
+	}
+
+	status, err := scanAccountStatus(s.db.QueryRowContext(ctx, `
+		SELECT `+accountStatusColumns+` FROM users WHERE id = $1`, userID), userID)
+	if errors.Is(err, ErrAccountNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only set when absent, so a status read before a suspension changed
+	// can't replace the one cached by the change
+	if encoded, err := json.Marshal(status); err == nil {
+		if _, err := s.redis.SetIfAbsent(ctx, key, encoded, s.ttls.Get(config.CacheAccountStatus)); err != nil {
+			log.Warn().Err(err).Str("user_id", userID).Msg("Failed to cache account status")
+		}
+	}
+	return status, nil
+}
+
+// CheckSignIn fails with an *AccountSuspendedError when user userID is
+// suspended, and with ErrAccountNotFound once their account is deleted.
+// Signing in and refreshing tokens check it before issuing any.
+func (s *AccountService) CheckSignIn(ctx context.Context, userID string) error {
+	status, err := s.AccountStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if status == nil || status.Deleted {
+		return ErrAccountNotFound
+	}
+	if status.Suspended {
+		return &AccountSuspendedError{Reason: status.Reason}
+	}
+	return nil
+}
+
+// Suspend suspends user userID, revoking their tokens and hiding their
+// reviews, and records who suspended them and why. The user is notified by
+// EventAccountSuspensionChanged. Suspending a suspended user changes
+// nothing.
+func (s *AccountService) Suspend(ctx context.Context, userID, adminID string, input models.SuspendInput) (*models.AccountStatus, error) {
+	return s.setSuspended(ctx, userID, adminID, true, input.Reason)
+}
+
+// Unsuspend lifts user userID's suspension, showing their reviews again.
+// Tokens revoked by the suspension stay revoked, so the user signs in
+// again. Unsuspending a user who isn't suspended changes nothing.
+func (s *AccountService) Unsuspend(ctx context.Context, userID, adminID string, input models.UnsuspendInput) (*models.AccountStatus, error) {
+	return s.setSuspended(ctx, userID, adminID, false, input.Reason)
+}
+
+func (s *AccountService) setSuspended(ctx context.Context, userID, adminID string, suspended bool, reason string) (*models.AccountStatus, error) {
+	var status *models.AccountStatus
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var current bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT suspended_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get account status: %w", err)
+		}
+		if current == suspended {
+			status, err = scanAccountStatus(tx.QueryRowContext(ctx, `
+				SELECT `+accountStatusColumns+` FROM users WHERE id = $1`, userID), userID)
+			return err
+		}
+
+		if suspended {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE users SET suspended_at = NOW(), suspension_reason = $2, tokens_revoked_at = NOW(), updated_at = NOW()
+				WHERE id = $1`, userID, reason)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE users SET suspended_at = NULL, suspension_reason = NULL, updated_at = NOW()
+				WHERE id = $1`, userID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update account status: %w", err)
+		}
+
+		// The user's reviews are hidden or shown again, so the ratings of
+		// their products are recomputed under the products' locks
+		event := &AccountSuspensionChangedEvent{UserID: userID, Suspended: suspended, Reason: reason}
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(array_agg(DISTINCT product_id::text), '{}')
+			FROM reviews WHERE buyer_id = $1 AND deleted_at IS NULL`, userID).Scan(pq.Array(&event.ProductIDs)); err != nil {
+			return fmt.Errorf("failed to get user's reviews: %w", err)
+		}
+		if len(event.ProductIDs) > 0 {
+			if _, err := lockStock(ctx, tx, event.ProductIDs); err != nil {
+				return err
+			}
+			if err := refreshRatings(ctx, tx, event.ProductIDs...); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO account_suspensions (user_id, suspended, reason, changed_by)
+			VALUES ($1, $2, NULLIF($3, ''), $4) RETURNING id`,
+			userID, suspended, reason, adminID).Scan(&event.SuspensionID); err != nil {
+			return fmt.Errorf("failed to record account suspension: %w", err)
+		}
+		if err := WriteOutbox(ctx, tx, EventAccountSuspensionChanged, userID, event); err != nil {
+			return err
+		}
+		status, err = scanAccountStatus(tx.QueryRowContext(ctx, `
+			SELECT `+accountStatusColumns+` FROM users WHERE id = $1`, userID), userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Overwritten rather than dropped, so requests see the change at once
+	// and a concurrent read of the old status can't be cached after it
+	encoded, err := json.Marshal(status)
+	if err == nil {
+		err = s.redis.SetWithExpiration(ctx, accountStatusKey(userID), encoded, s.ttls.Get(config.CacheAccountStatus))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to cache account status")
+	}
+	return status, nil
+}
+
+// Suspensions returns a page of user userID's suspensions, newest first
+func (s *AccountService) Suspensions(ctx context.Context, userID string, limit, offset int) (*models.AccountSuspensionPage, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !exists {
+		return nil, ErrAccountNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, suspended, COALESCE(reason, ''), changed_by, created_at, COUNT(*) OVER()
+		FROM account_suspensions
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account suspensions: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.AccountSuspensionPage{Suspensions: []models.AccountSuspension{}}
+	for rows.Next() {
+		var a models.AccountSuspension
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Suspended, &a.Reason, &a.ChangedBy, &a.CreatedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan account suspension: %w", err)
+		}
+		page.Suspensions = append(page.Suspensions, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get account suspensions: %w", err)
+	}
+	return page, nil
+}
+
+// NotifySuspensionChanged is the job handler for
+// EventAccountSuspensionChanged. It refreshes the search documents and
+// cached listings of the products the user reviewed, whose ratings changed,
+// then notifies the user, with the admin's reason for a suspension.
+func (s *AccountService) NotifySuspensionChanged(ctx context.Context, job *jobs.Job) error {
+	var event AccountSuspensionChangedEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal account suspension event: %w", err)
+	}
+	for _, id := range event.ProductIDs {
+		s.products.ratingChanged(ctx, id)
+	}
+
+	title, message := "Account reinstated", "Your account is no longer suspended. Please sign in again."
+	if event.Suspended {
+		title, message = "Account suspended", (&AccountSuspendedError{Reason: event.Reason}).Message()
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.UserID, "account_suspension", title, message,
+		map[string]interface{}{"suspended": event.Suspended, "suspensionId": event.SuspensionID})
+}
+
+const accountStatusColumns = `suspended_at IS NOT NULL, deleted_at IS NOT NULL, COALESCE(suspension_reason, ''), suspended_at,
+	tokens_revoked_at`
+
+// scanAccountStatus scans user userID's account status, selected with
+// accountStatusColumns
+func scanAccountStatus(row rowScanner, userID string) (*models.AccountStatus, error) {
+	status := &models.AccountStatus{UserID: userID}
+	err := row.Scan(&status.Suspended, &status.Deleted, &status.Reason, &status.SuspendedAt, &status.TokensRevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account status: %w", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Account deletion
+//
+// Users delete their own accounts. Orders and the records kept with them
+// still refer to the user, so the user row stays, stripped of everything
+// naming the user, and deleted_at marks it; their cart, wishlist,
+// preferences and follows go. Every token is revoked and no more are
+// issued. The user chooses what becomes of their reviews: deleted, with the
+// sellers' replies to them and their products' ratings recomputed, or kept
+// under anonymousReviewAuthor. Anonymized reviews keep their verified
+// purchase badge, as the order it was checked against is kept too, and
+// their replies. Reviews the user had deleted are deleted for good either
+// way, as no one can restore them any more. It all happens in one
+// transaction, recorded in account_deletions. Accounts with open orders,
+// as buyer or seller, or with products not deleted can't be deleted until
+// those are settled.
+
+var (
+	ErrAccountDeletionNotFound = errors.New("account deletion not found")
+	ErrAccountHasOpenOrders    = errors.New("account has open orders")
+	ErrAccountHasProducts      = errors.New("account has products")
+)
+
+// anonymousReviewAuthor names the author of reviews kept after their
+// author's account was deleted
+const anonymousReviewAuthor = "Anonymous"
+
+const accountDeletionColumns = `id, user_id, reviews, reviews_deleted, reviews_anonymized, replies_deleted,
+	product_ids::text[], created_at`
+
+// scanAccountDeletion scans a deletion selected with accountDeletionColumns
+func scanAccountDeletion(row rowScanner) (*models.AccountDeletion, error) {
+	var d models.AccountDeletion
+	if err := row.Scan(&d.ID, &d.UserID, &d.Reviews, &d.ReviewsDeleted, &d.ReviewsAnonymized, &d.RepliesDeleted,
+		pq.Array(&d.ProductIDs), &d.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DeleteAccount deletes user userID's account, deleting or anonymizing
+// their reviews as input chooses, and returns the audit entry for it. It
+// fails with ErrAccountHasOpenOrders or ErrAccountHasProducts while the
+// account still has business to settle.
+func (s *AccountService) DeleteAccount(ctx context.Context, userID string, input models.DeleteAccountInput) (*models.AccountDeletion, error) {
+	var deletion *models.AccountDeletion
+	var status *models.AccountStatus
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Locked so a suspension or impersonation can't be started meanwhile
+		var deleted bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT deleted_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&deleted)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && deleted {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if err := checkAccountSettled(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		deletion = &models.AccountDeletion{UserID: userID, Reviews: input.Reviews, ProductIDs: []string{}}
+		if err := deleteAccountReviews(ctx, tx, userID, deletion); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users SET email = 'deleted-' || id || '@deleted.invalid', username = 'deleted-' || id, password_hash = '',
+				full_name = NULL, bio = NULL, avatar_url = NULL, phone = NULL, address = NULL, verification_details = NULL,
+				latitude = NULL, longitude = NULL, is_active = false, deleted_at = NOW(), tokens_revoked_at = NOW(),
+				updated_at = NOW()
+			WHERE id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to delete account: %w", err)
+		}
+		for _, query := range []string{
+			`DELETE FROM cart WHERE user_id = $1`,
+			`DELETE FROM wishlist WHERE user_id = $1`,
+			`DELETE FROM user_preferences WHERE user_id = $1`,
+			`DELETE FROM seller_followers WHERE user_id = $1 OR seller_id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+				return fmt.Errorf("failed to delete account data: %w", err)
+			}
+		}
+
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO account_deletions (user_id, reviews, reviews_deleted, reviews_anonymized, replies_deleted, product_ids)
+			VALUES ($1, $2, $3, $4, $5, $6::uuid[])
+			RETURNING id, created_at`,
+			userID, deletion.Reviews, deletion.ReviewsDeleted, deletion.ReviewsAnonymized, deletion.RepliesDeleted,
+			pq.Array(deletion.ProductIDs)).Scan(&deletion.ID, &deletion.CreatedAt); err != nil {
+			return fmt.Errorf("failed to record account deletion: %w", err)
+		}
+		status, err = scanAccountStatus(tx.QueryRowContext(ctx, `
+			SELECT `+accountStatusColumns+` FROM users WHERE id = $1`, userID), userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Overwritten, as for a suspension, so the user's tokens are refused at
+	// once
+	encoded, err := json.Marshal(status)
+	if err == nil {
+		err = s.redis.SetWithExpiration(ctx, accountStatusKey(userID), encoded, s.ttls.Get(config.CacheAccountStatus))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to cache account status")
+	}
+	for _, id := range deletion.ProductIDs {
+		s.products.ratingChanged(ctx, id)
+	}
+	log.Info().Str("user_id", userID).Str("reviews", deletion.Reviews).Int("reviews_deleted", deletion.ReviewsDeleted).
+		Int("reviews_anonymized", deletion.ReviewsAnonymized).Msg("Account deleted")
+	return deletion, nil
+}
+
+// checkAccountSettled fails when user userID has orders, as buyer or
+// seller, that aren't delivered or cancelled, or products not deleted
+func checkAccountSettled(ctx context.Context, tx *sql.Tx, userID string) error {
+	var openOrders, products bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM orders o WHERE o.buyer_id = $1 AND o.status IN ('pending', 'paid', 'shipped'))
+				OR EXISTS (
+					SELECT 1 FROM order_items oi
+					JOIN orders o ON o.id = oi.order_id
+					JOIN products p ON p.id = oi.product_id
+					WHERE p.seller_id = $1 AND o.status IN ('pending', 'paid', 'shipped')),
+			EXISTS (SELECT 1 FROM products p WHERE p.seller_id = $1 AND p.deleted_at IS NULL)`,
+		userID).Scan(&openOrders, &products); err != nil {
+		return fmt.Errorf("failed to check account: %w", err)
+	}
+	if openOrders {
+		return ErrAccountHasOpenOrders
+	}
+	if products {
+		return ErrAccountHasProducts
+	}
+	return nil
+}
+
+// deleteAccountReviews deletes or anonymizes user userID's reviews as
+// deletion asks, counting what it did in deletion. Deleting visible reviews
+// recomputes their products' ratings, under the products' row locks.
+func deleteAccountReviews(ctx context.Context, tx *sql.Tx, userID string, deletion *models.AccountDeletion) error {
+	// Reviews the user had deleted go whatever they chose
+	remove := `r.buyer_id = $1 AND r.deleted_at IS NOT NULL`
+	if deletion.Reviews == models.DeletionReviewsDelete {
+		remove = `r.buyer_id = $1`
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(array_agg(DISTINCT r.product_id::text), '{}')
+			FROM reviews r WHERE r.buyer_id = $1 AND `+reviewVisible, userID).Scan(pq.Array(&deletion.ProductIDs)); err != nil {
+			return fmt.Errorf("failed to get user's reviews: %w", err)
+		}
+		if len(deletion.ProductIDs) > 0 {
+			if _, err := lockStock(ctx, tx, deletion.ProductIDs); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM review_replies rr JOIN reviews r ON r.id = rr.review_id
+		WHERE `+remove, userID).Scan(&deletion.RepliesDeleted); err != nil {
+		return fmt.Errorf("failed to count review replies: %w", err)
+	}
+	// Replies go with their reviews
+	result, err := tx.ExecContext(ctx, `DELETE FROM reviews r WHERE `+remove, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete reviews: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	deletion.ReviewsDeleted = int(n)
+
+	result, err = tx.ExecContext(ctx, `
+		UPDATE reviews SET buyer_id = NULL, author_name = $2 WHERE buyer_id = $1`, userID, anonymousReviewAuthor)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize reviews: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	deletion.ReviewsAnonymized = int(n)
+
+	if len(deletion.ProductIDs) == 0 {
+		return nil
+	}
+	return refreshRatings(ctx, tx, deletion.ProductIDs...)
+}
+
+// AccountDeletion returns the audit entry for user userID's account
+// deletion
+func (s *AccountService) AccountDeletion(ctx context.Context, userID string) (*models.AccountDeletion, error) {
+	deletion, err := scanAccountDeletion(s.db.QueryRowContext(ctx, `
+		SELECT `+accountDeletionColumns+` FROM account_deletions WHERE user_id = $1`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccountDeletionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account deletion: %w", err)
+	}
+	return deletion, nil
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Impersonation
+//
+// An admin looking into a user's problem can act as them with a token
+// issued for the user whose act claim names the admin and whose jti is the
+// impersonation's ID. The token lasts jwt.impersonation_expiration minutes
+// and can be revoked sooner; AuditImpersonation checks the impersonation on
+// every request made with it, which also ends it once the admin is no
+// longer an active admin, and records the request. Staff accounts, the
+// admin's own and suspended ones can't be impersonated, and routes that
+// charge, refund, change payment methods or delete the account refuse
+// impersonation tokens.
+
+var (
+	ErrImpersonationNotFound  = errors.New("impersonation not found")
+	ErrImpersonationForbidden = errors.New("user can't be impersonated")
+)
+
+const impersonationColumns = `i.id, i.admin_id, i.user_id, i.reason, i.created_at, i.expires_at, i.revoked_at, i.revoked_by,
+	i.revoked_at IS NULL AND i.expires_at > NOW(),
+	(SELECT COUNT(*) FROM impersonation_requests ir WHERE ir.impersonation_id = i.id)`
+
+// scanImpersonation scans an impersonation selected with
+// impersonationColumns, and any extra destinations after them
+func scanImpersonation(row rowScanner, extra ...interface{}) (*models.Impersonation, error) {
+	var i models.Impersonation
+	dest := append([]interface{}{&i.ID, &i.AdminID, &i.UserID, &i.Reason, &i.CreatedAt, &i.ExpiresAt, &i.RevokedAt,
+		&i.RevokedBy, &i.Active, &i.Requests}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// Impersonate starts admin adminID's impersonation of user userID,
+// returning the token to act as them with. It fails with
+// ErrImpersonationForbidden for staff, the admin themselves and suspended
+// users.
+func (s *AccountService) Impersonate(ctx context.Context, userID, adminID string, input models.ImpersonateInput) (*models.ImpersonationToken, error) {
+	var result *models.ImpersonationToken
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Locked so a suspension can't be committed between the check and
+		// the token being issued
+		var role string
+		var suspended bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT role, suspended_at IS NOT NULL FROM users WHERE id = $1 FOR SHARE`, userID).Scan(&role, &suspended)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if userID == adminID || role == "admin" || role == "support" || suspended {
+			return ErrImpersonationForbidden
+		}
+
+		impersonation, err := scanImpersonation(tx.QueryRowContext(ctx, `
+			WITH i AS (
+				INSERT INTO impersonations (admin_id, user_id, reason, expires_at)
+				VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 minute')
+				RETURNING *
+			)
+			SELECT `+impersonationColumns+` FROM i`,
+			adminID, userID, input.Reason, s.jwt.ImpersonationExpiration))
+		if err != nil {
+			return fmt.Errorf("failed to start impersonation: %w", err)
+		}
+		token, err := s.users.GenerateImpersonationToken(userID, role, adminID, impersonation.ID, impersonation.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		result = &models.ImpersonationToken{Impersonation: *impersonation, Token: token}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("impersonation_id", result.ID).Str("admin_id", adminID).Str("user_id", userID).
+		Time("expires_at", result.ExpiresAt).Msg("Impersonation started")
+	return result, nil
+}
+
+// RevokeImpersonation ends impersonation id at once, recording admin
+// adminID as having revoked it. Revoking one that has already ended changes
+// nothing.
+func (s *AccountService) RevokeImpersonation(ctx context.Context, id, adminID string) (*models.Impersonation, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE impersonations SET revoked_at = NOW(), revoked_by = $2
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()`, id, adminID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke impersonation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Info().Str("impersonation_id", id).Str("admin_id", adminID).Msg("Impersonation revoked")
+	}
+	return s.GetImpersonation(ctx, id)
+}
+
+// GetImpersonation returns impersonation id
+func (s *AccountService) GetImpersonation(ctx context.Context, id string) (*models.Impersonation, error) {
+	impersonation, err := scanImpersonation(s.db.QueryRowContext(ctx, `
+		SELECT `+impersonationColumns+` FROM impersonations i WHERE i.id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrImpersonationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get impersonation: %w", err)
+	}
+	return impersonation, nil
+}
+
+// Impersonations returns a page of the impersonations of user userID, newest
+// first
+func (s *AccountService) Impersonations(ctx context.Context, userID string, limit, offset int) (*models.ImpersonationPage, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !exists {
+		return nil, ErrAccountNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+impersonationColumns+`, COUNT(*) OVER()
+		FROM impersonations i
+		WHERE i.user_id = $1
+		ORDER BY i.created_at DESC, i.id
+		LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get impersonations: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.ImpersonationPage{Impersonations: []models.Impersonation{}}
+	for rows.Next() {
+		impersonation, err := scanImpersonation(rows, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan impersonation: %w", err)
+		}
+		page.Impersonations = append(page.Impersonations, *impersonation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get impersonations: %w", err)
+	}
+	return page, nil
+}
+
+// ImpersonatedRequests returns a page of the requests made with
+// impersonation id's token, oldest first
+func (s *AccountService) ImpersonatedRequests(ctx context.Context, id string, limit, offset int) (*models.ImpersonatedRequestPage, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM impersonations WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to get impersonation: %w", err)
+	}
+	if !exists {
+		return nil, ErrImpersonationNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT method, path, status, COALESCE(request_id, ''), created_at, COUNT(*) OVER()
+		FROM impersonation_requests
+		WHERE impersonation_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3`, id, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get impersonated requests: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.ImpersonatedRequestPage{Requests: []models.ImpersonatedRequest{}}
+	for rows.Next() {
+		var r models.ImpersonatedRequest
+		if err := rows.Scan(&r.Method, &r.Path, &r.Status, &r.RequestID, &r.CreatedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan impersonated request: %w", err)
+		}
+		page.Requests = append(page.Requests, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get impersonated requests: %w", err)
+	}
+	return page, nil
+}
+
+// ImpersonationActive reports whether impersonation id, by admin adminID of
+// user userID, is neither revoked nor expired, and adminID is still an
+// admin who isn't suspended
+func (s *AccountService) ImpersonationActive(ctx context.Context, id, adminID, userID string) (bool, error) {
+	var active bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM impersonations i JOIN users a ON a.id = i.admin_id
+			WHERE i.id = $1 AND i.admin_id = $2 AND i.user_id = $3
+				AND i.revoked_at IS NULL AND i.expires_at > NOW()
+				AND a.role = 'admin' AND a.suspended_at IS NULL
+		)`, id, adminID, userID).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check impersonation: %w", err)
+	}
+	return active, nil
+}
+
+// RecordImpersonatedRequest adds a request made with impersonation id's
+// token to its audit trail
+func (s *AccountService) RecordImpersonatedRequest(ctx context.Context, id, method, path string, status int, requestID string, at time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO impersonation_requests (impersonation_id, method, path, status, request_id, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)`, id, method, path, status, requestID, at); err != nil {
+		return fmt.Errorf("failed to record impersonated request: %w", err)
+	}
+	return nil
+}
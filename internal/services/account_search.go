@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Admin user search
+//
+// Support finds users by ID, email, name or phone. Results carry what
+// support needs to tell users apart and see their standing, never
+// credentials. Every search that returns users is recorded in
+// admin_user_searches with its filters and the users shown, before they
+// are returned: a search that can't be recorded fails rather than showing
+// anyone unrecorded. The route is rate limited as admin_users.
+
+var ErrInvalidUserFilter = errors.New("invalid user filter")
+
+// userSort is a keyset-paginated ordering of the admin user search
+type userSort struct {
+	column string // only these fixed columns are ever interpolated
+	cast   string
+	desc   bool
+}
+
+var userSorts = map[string]userSort{
+	"":                {column: "u.created_at", cast: "timestamptz", desc: true},
+	"created_desc":    {column: "u.created_at", cast: "timestamptz", desc: true},
+	"created_asc":     {column: "u.created_at", cast: "timestamptz"},
+	"last_login_desc": {column: "COALESCE(u.last_login, '-infinity')", cast: "timestamptz", desc: true},
+	"email_asc":       {column: "LOWER(u.email)", cast: "text"},
+}
+
+// userStatusConditions select users by account status, on users aliased
+// as u
+var userStatusConditions = map[string]string{
+	"active":    "u.suspended_at IS NULL AND u.deleted_at IS NULL",
+	"suspended": "u.suspended_at IS NOT NULL",
+	"deleted":   "u.deleted_at IS NOT NULL",
+}
+
+// userCursor marks the last user on a page: its sort value and ID
+type userCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// adminUserColumns is the column list matching scanAdminUser, for queries
+// aliasing users as u
+const adminUserColumns = `u.id, u.email, u.username, COALESCE(u.full_name, ''), COALESCE(u.phone, ''), u.role,
+	COALESCE(u.is_verified, false), COALESCE(u.verification_level, 'basic'), u.seller_status,
+	u.suspended_at IS NOT NULL, u.suspended_at, u.deleted_at IS NOT NULL, u.last_login, u.created_at`
+
+// userSearchWhere returns the sort, WHERE clause and arguments selecting
+// the users matching filter, after its cursor when it has one
+func userSearchWhere(filter models.AdminUserFilter) (userSort, string, []interface{}, error) {
+	sort, ok := userSorts[filter.Sort]
+	if !ok {
+		return sort, "", nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidUserFilter, filter.Sort)
+	}
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(format string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	if filter.ID != "" {
+		addCondition("u.id = $%d::uuid", filter.ID)
+	}
+	if filter.Email != "" {
+		addCondition("LOWER(u.email) = LOWER($%d)", filter.Email)
+	}
+	if filter.Phone != "" {
+		addCondition("u.phone ILIKE $%d", "%"+escapeLike(filter.Phone)+"%")
+	}
+	if filter.Query != "" {
+		pattern := "%" + escapeLike(filter.Query) + "%"
+		addCondition("(u.email ILIKE $%d OR u.full_name ILIKE $%d OR u.phone ILIKE $%d)", pattern, pattern, pattern)
+	}
+	if filter.Role != "" {
+		addCondition("u.role = $%d", filter.Role)
+	}
+	if filter.Status != "" {
+		condition, ok := userStatusConditions[filter.Status]
+		if !ok {
+			return sort, "", nil, fmt.Errorf("%w: unknown status %q", ErrInvalidUserFilter, filter.Status)
+		}
+		conditions = append(conditions, condition)
+	}
+	if filter.Verified != nil {
+		addCondition("COALESCE(u.is_verified, false) = $%d", *filter.Verified)
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeUserCursor(filter.Cursor)
+		if err != nil || cursor.Sort != filter.Sort {
+			return sort, "", nil, fmt.Errorf("%w: invalid cursor", ErrInvalidUserFilter)
+		}
+		op := ">"
+		if sort.desc {
+			op = "<"
+		}
+		addCondition(fmt.Sprintf("(%s, u.id) %s ($%%d::%s, $%%d::uuid)", sort.column, op, sort.cast), cursor.Value, cursor.ID)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	return sort, where, args, nil
+}
+
+// direction is the SQL direction of the sort
+func (s userSort) direction() string {
+	if s.desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// SearchUsers returns a page of the users matching filter for admin
+// adminID, newest first by default, keyset paginated like the admin order
+// search. Searches returning users are recorded first.
+func (s *AccountService) SearchUsers(ctx context.Context, adminID string, filter models.AdminUserFilter) (*models.AdminUserPage, error) {
+	sort, where, args, err := userSearchWhere(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to learn whether there is a next page
+	args = append(args, filter.Limit+1)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+adminUserColumns+`, %s::text
+		FROM users u
+		%s
+		ORDER BY %s %s, u.id %s
+		LIMIT $%d`, sort.column, where, sort.column, sort.direction(), sort.direction(), len(args)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.AdminUserPage{Users: []models.AdminUserSummary{}}
+	var lastSortValue string
+	for rows.Next() {
+		var sortValue string
+		u, err := scanAdminUser(rows, &sortValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if len(page.Users) == filter.Limit {
+			page.NextCursor = encodeUserCursor(userCursor{Sort: filter.Sort, Value: lastSortValue, ID: page.Users[len(page.Users)-1].ID})
+			break
+		}
+		page.Users = append(page.Users, u)
+		lastSortValue = sortValue
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	if len(page.Users) > 0 {
+		if err := s.recordUserSearch(ctx, adminID, filter, page.Users); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// recordUserSearch records that admin adminID was shown users by a search
+// with filter
+func (s *AccountService) recordUserSearch(ctx context.Context, adminID string, filter models.AdminUserFilter, users []models.AdminUserSummary) error {
+	filters, err := json.Marshal(filter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user search filters: %w", err)
+	}
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO admin_user_searches (admin_id, filters, user_ids) VALUES ($1, $2, $3::uuid[])`,
+		adminID, filters, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to record user search: %w", err)
+	}
+	return nil
+}
+
+// scanAdminUser scans a row selected with adminUserColumns, followed by any
+// extra destinations for additional selected columns
+func scanAdminUser(row rowScanner, extra ...interface{}) (models.AdminUserSummary, error) {
+	var u models.AdminUserSummary
+	dest := []interface{}{
+		&u.ID, &u.Email, &u.Username, &u.Name, &u.Phone, &u.Role, &u.IsVerified, &u.VerificationLevel, &u.SellerStatus,
+		&u.Suspended, &u.SuspendedAt, &u.Deleted, &u.LastLogin, &u.CreatedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return u, err
+}
+
+func encodeUserCursor(c userCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(s string) (userCursor, error) {
+	var c userCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
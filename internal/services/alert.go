@@ -0,0 +1,345 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/health"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// System alerts
+//
+// Subsystems raise an alert for each operational problem an admin should
+// look at, keyed by a dedupe key naming the condition. While an alert is
+// unacknowledged, raising its key again updates it, counting the
+// occurrence, rather than adding another; once acknowledged, the next
+// occurrence opens a new alert. Critical alerts are also sent to the ops
+// channel when first raised. A scheduled check raises alerts for stuck and
+// dead-lettered jobs, webhook subscriptions failing deliveries, spikes in
+// products running low on stock and sellers ordering their own products;
+// orders held for fraud review raise one each as they are held.
+
+// alertLookback is how far back the scheduled check counts failures and
+// low stock events, and how the alerts it raises describe it
+const (
+	alertLookback     = time.Hour
+	alertLookbackText = "hour"
+)
+
+var ErrAlertNotFound = errors.New("alert not found")
+
+// AlertService raises and acknowledges system alerts
+type AlertService struct {
+	db            *database.PostgresDB
+	queue         *jobs.Queue
+	ops           *health.WebhookAlerter
+	cfg           config.AlertsConfig
+	maxPendingAge time.Duration
+}
+
+// NewAlertService creates a new alert service. Jobs pending longer than
+// maxPendingAge are stuck. ops may be nil when no ops channel is set up.
+func NewAlertService(db *database.PostgresDB, queue *jobs.Queue, ops *health.WebhookAlerter, cfg config.AlertsConfig, maxPendingAge time.Duration) *AlertService {
+	return &AlertService{db: db, queue: queue, ops: ops, cfg: cfg, maxPendingAge: maxPendingAge}
+}
+
+// systemAlertColumns is the column list matching scanSystemAlert
+const systemAlertColumns = `id, severity, source, dedupe_key, title, message, details, occurrences,
+	first_seen_at, last_seen_at, acknowledged_at, acknowledged_by`
+
+// scanSystemAlert scans a row selected with systemAlertColumns, followed by
+// any extra destinations
+func scanSystemAlert(row rowScanner, extra ...interface{}) (*models.SystemAlert, error) {
+	var a models.SystemAlert
+	var details []byte
+	if err := row.Scan(append([]interface{}{&a.ID, &a.Severity, &a.Source, &a.DedupeKey, &a.Title, &a.Message, &details,
+		&a.Occurrences, &a.FirstSeenAt, &a.LastSeenAt, &a.AcknowledgedAt, &a.AcknowledgedBy}, extra...)...); err != nil {
+		return nil, err
+	}
+	a.Details = details
+	return &a, nil
+}
+
+// Raise raises input as an alert, updating the open alert with its dedupe
+// key if there is one. A critical alert is sent to the ops channel when
+// it is first raised; failing to send it is logged, not returned.
+func (s *AlertService) Raise(ctx context.Context, input models.AlertInput) (*models.SystemAlert, error) {
+	if input.Details == nil {
+		input.Details = map[string]interface{}{}
+	}
+	details, err := json.Marshal(input.Details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal alert details: %w", err)
+	}
+	var inserted bool
+	alert, err := scanSystemAlert(s.db.QueryRowContext(ctx, `
+		INSERT INTO system_alerts (severity, source, dedupe_key, title, message, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (dedupe_key) WHERE acknowledged_at IS NULL DO UPDATE SET
+			severity = EXCLUDED.severity, title = EXCLUDED.title, message = EXCLUDED.message,
+			details = EXCLUDED.details, occurrences = system_alerts.occurrences + 1, last_seen_at = NOW()
+		RETURNING `+systemAlertColumns+`, xmax = 0`,
+		input.Severity, input.Source, input.DedupeKey, input.Title, input.Message, string(details)), &inserted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to raise alert: %w", err)
+	}
+	if inserted && alert.Severity == models.AlertCritical && s.ops != nil {
+		if err := s.ops.Send(ctx, alert); err != nil {
+			log.Warn().Err(err).Str("alert_id", alert.ID).Str("dedupe_key", alert.DedupeKey).Msg("Failed to send critical alert")
+		}
+	}
+	return alert, nil
+}
+
+// List returns a page of alerts, most recently seen first, only the
+// unacknowledged ones with unacked
+func (s *AlertService) List(ctx context.Context, unacked bool, limit, offset int) (*models.SystemAlertPage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+systemAlertColumns+`, COUNT(*) OVER() AS total
+		FROM system_alerts
+		WHERE NOT $1 OR acknowledged_at IS NULL
+		ORDER BY last_seen_at DESC, id
+		LIMIT $2 OFFSET $3`, unacked, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alerts: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.SystemAlertPage{Alerts: []models.SystemAlert{}}
+	for rows.Next() {
+		alert, err := scanSystemAlert(rows, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		page.Alerts = append(page.Alerts, *alert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list alerts: %w", err)
+	}
+	return page, nil
+}
+
+// Acknowledge acknowledges alert id on behalf of admin adminID. An alert
+// already acknowledged is returned as it is.
+func (s *AlertService) Acknowledge(ctx context.Context, id, adminID string) (*models.SystemAlert, error) {
+	alert, err := scanSystemAlert(s.db.QueryRowContext(ctx, `
+		WITH acked AS (
+			UPDATE system_alerts SET acknowledged_at = NOW(), acknowledged_by = $2
+			WHERE id = $1 AND acknowledged_at IS NULL
+			RETURNING `+systemAlertColumns+`
+		)
+		SELECT `+systemAlertColumns+` FROM acked
+		UNION ALL
+		SELECT `+systemAlertColumns+` FROM system_alerts WHERE id = $1 AND acknowledged_at IS NOT NULL`,
+		id, adminID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acknowledge alert: %w", err)
+	}
+	return alert, nil
+}
+
+// RunAlertChecks raises alerts for the conditions the scheduled check
+// watches, as a scheduled task. Every check runs even when one fails.
+func (s *AlertService) RunAlertChecks(ctx context.Context) error {
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{"jobs", s.checkJobs},
+		{"webhooks", s.checkWebhookFailures},
+		{"low_stock", s.checkLowStockSpike},
+		{"self_purchases", s.checkSelfPurchases},
+	}
+	var firstErr error
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			log.Error().Err(err).Str("check", c.name).Msg("Alert check failed")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("alert check %s failed: %w", c.name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// checkJobs raises a critical alert when the oldest pending job has waited
+// longer than its maximum, meaning workers are stuck or can't keep up, and
+// a warning while jobs are dead-lettered
+func (s *AlertService) checkJobs(ctx context.Context) error {
+	stats, err := s.queue.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	if age := time.Duration(stats.OldestPendingAgeSeconds * float64(time.Second)); age > s.maxPendingAge {
+		if _, err := s.Raise(ctx, models.AlertInput{
+			Severity: models.AlertCritical, Source: "jobs", DedupeKey: "jobs:stuck", Title: "Background jobs are stuck",
+			Message: fmt.Sprintf("The oldest pending job has waited %s, longer than %s; %d jobs are pending", age.Round(time.Second), s.maxPendingAge, stats.Depth),
+			Details: stats,
+		}); err != nil {
+			return err
+		}
+	}
+	if stats.DeadLetters > 0 {
+		if _, err := s.Raise(ctx, models.AlertInput{
+			Severity: models.AlertWarning, Source: "jobs", DedupeKey: "jobs:dead_letters", Title: "Background jobs failed for good",
+			Message: fmt.Sprintf("%d jobs ran out of retries and are dead-lettered", stats.DeadLetters),
+			Details: stats,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWebhookFailures raises a warning for each webhook subscription with
+// at least the configured number of failed deliveries in the lookback
+func (s *AlertService) checkWebhookFailures(ctx context.Context) error {
+	if s.cfg.WebhookFailures <= 0 {
+		return nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.subscription_id, s.url, COUNT(*),
+			(ARRAY_AGG(d.error ORDER BY d.attempted_at DESC))[1]
+		FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = $1 AND d.attempted_at > NOW() - $2 * INTERVAL '1 second'
+		GROUP BY d.subscription_id, s.url
+		HAVING COUNT(*) >= $3
+		ORDER BY d.subscription_id`,
+		models.WebhookDeliveryFailed, alertLookback.Seconds(), s.cfg.WebhookFailures)
+	if err != nil {
+		return fmt.Errorf("failed to count webhook failures: %w", err)
+	}
+	type failing struct {
+		subscriptionID, url, lastError string
+		failures                       int
+	}
+	var subs []failing
+	for rows.Next() {
+		var f failing
+		var lastError sql.NullString
+		if err := rows.Scan(&f.subscriptionID, &f.url, &f.failures, &lastError); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan webhook failures: %w", err)
+		}
+		f.lastError = lastError.String
+		subs = append(subs, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to count webhook failures: %w", err)
+	}
+
+	for _, f := range subs {
+		if _, err := s.Raise(ctx, models.AlertInput{
+			Severity: models.AlertWarning, Source: "webhooks", DedupeKey: "webhooks:failing:" + f.subscriptionID,
+			Title:   "Webhook deliveries failing",
+			Message: fmt.Sprintf("%d deliveries to %s failed in the last %s", f.failures, f.url, alertLookbackText),
+			Details: map[string]interface{}{"subscriptionId": f.subscriptionID, "failures": f.failures, "lastError": f.lastError},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLowStockSpike raises a warning when at least the configured number
+// of products fell to low stock in the lookback
+func (s *AlertService) checkLowStockSpike(ctx context.Context) error {
+	if s.cfg.LowStockSpike <= 0 {
+		return nil
+	}
+	var products int
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT aggregate_id) FROM outbox
+		WHERE event_type = $1 AND created_at > NOW() - $2 * INTERVAL '1 second'`,
+		EventStockLow, alertLookback.Seconds()).Scan(&products); err != nil {
+		return fmt.Errorf("failed to count low stock events: %w", err)
+	}
+	if products < s.cfg.LowStockSpike {
+		return nil
+	}
+	_, err := s.Raise(ctx, models.AlertInput{
+		Severity: models.AlertWarning, Source: "inventory", DedupeKey: "inventory:low_stock_spike", Title: "Spike in low stock",
+		Message: fmt.Sprintf("%d products fell to low stock in the last %s", products, alertLookbackText),
+		Details: map[string]int{"products": products},
+	})
+	return err
+}
+
+// checkSelfPurchases raises a warning for each seller who ordered their own
+// products in the lookback, for an admin to review
+func (s *AlertService) checkSelfPurchases(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.buyer_id, COUNT(DISTINCT o.id), ARRAY_AGG(DISTINCT oi.product_id::text)
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		WHERE oi.self_purchase AND o.created_at > NOW() - $1 * INTERVAL '1 second'
+		GROUP BY o.buyer_id
+		ORDER BY o.buyer_id`, alertLookback.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to count self-purchases: %w", err)
+	}
+	type selfPurchase struct {
+		sellerID   string
+		orders     int
+		productIDs []string
+	}
+	var sellers []selfPurchase
+	for rows.Next() {
+		var p selfPurchase
+		if err := rows.Scan(&p.sellerID, &p.orders, pq.Array(&p.productIDs)); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan self-purchases: %w", err)
+		}
+		sellers = append(sellers, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to count self-purchases: %w", err)
+	}
+
+	for _, p := range sellers {
+		if _, err := s.Raise(ctx, models.AlertInput{
+			Severity: models.AlertWarning, Source: "orders", DedupeKey: "orders:self_purchase:" + p.sellerID,
+			Title:   "Seller ordered their own products",
+			Message: fmt.Sprintf("Seller %s placed %d orders of their own products in the last %s", p.sellerID, p.orders, alertLookbackText),
+			Details: map[string]interface{}{"sellerId": p.sellerID, "orders": p.orders, "productIds": p.productIDs},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AlertOrderHeld is the job handler for EventOrderHeld, raising a warning
+// for admins to review the held order
+func (s *AlertService) AlertOrderHeld(ctx context.Context, job *jobs.Job) error {
+	var event OrderHeldEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal order held event: %w", err)
+	}
+	_, err := s.Raise(ctx, models.AlertInput{
+		Severity: models.AlertWarning, Source: "fraud", DedupeKey: "fraud:order_held:" + event.OrderID,
+		Title: "Order held for fraud review",
+		Message: fmt.Sprintf("Order %s scored %d at %s (%s) and is held until an admin releases or cancels it",
+			event.OrderReference, event.Score, event.Stage, strings.Join(event.Reasons, ", ")),
+		Details: event,
+	})
+	return err
+}
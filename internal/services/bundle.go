@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// productStock is the available stock of the product aliased as p. A bundle
+// has no stock of its own: it can be sold as many times as its scarcest
+// component allows, and not at all while any component is unlisted.
+const productStock = `CASE WHEN p.product_type = 'bundle' THEN (
+		SELECT COALESCE(MIN(CASE WHEN c.deleted_at IS NULL AND COALESCE(c.is_active, true)
+			THEN COALESCE(c.stock_quantity, 0) / bi.quantity ELSE 0 END), 0)
+		FROM product_bundle_items bi JOIN products c ON c.id = bi.product_id
+		WHERE bi.bundle_id = p.id)
+	ELSE COALESCE(p.stock_quantity, 0) END`
+
+// saveBundle replaces the contents and pricing of bundle id within tx.
+// Components must be the seller's own listed simple products sold each, in
+// the bundle's currency.
+func saveBundle(ctx context.Context, tx *sql.Tx, id, sellerID, currency string, input *models.BundleInput) error {
+	ids := make([]string, len(input.Items))
+	for i, item := range input.Items {
+		ids[i] = item.ProductID
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, seller_id, product_type, unit_type, COALESCE(currency, 'USD')
+		FROM products
+		WHERE id = ANY($1) AND deleted_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get bundle components: %w", err)
+	}
+	type component struct {
+		sellerID    string
+		productType string
+		unitType    string
+		currency    string
+	}
+	components := make(map[string]component, len(ids))
+	for rows.Next() {
+		var id string
+		var c component
+		if err := rows.Scan(&id, &c.sellerID, &c.productType, &c.unitType, &c.currency); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan bundle component: %w", err)
+		}
+		components[id] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get bundle components: %w", err)
+	}
+
+	if currency == "" {
+		currency = "USD"
+	}
+	var invalid []validators.FieldError
+	for i, item := range input.Items {
+		field := fmt.Sprintf("bundle.items[%d].productId", i)
+		c, ok := components[item.ProductID]
+		switch {
+		case !ok:
+			invalid = append(invalid, validators.FieldError{Field: field, Code: "not_found", Message: "product not found"})
+		case item.ProductID == id:
+			invalid = append(invalid, validators.FieldError{Field: field, Code: "self", Message: "a bundle cannot contain itself"})
+		case c.sellerID != sellerID:
+			invalid = append(invalid, validators.FieldError{Field: field, Code: "forbidden", Message: "product belongs to another seller"})
+		case c.productType == models.ProductTypeBundle:
+			invalid = append(invalid, validators.FieldError{Field: field, Code: "bundle", Message: "a bundle cannot contain another bundle"})
+		case c.unitType == models.UnitTypeWeight:
+			invalid = append(invalid, validators.FieldError{Field: field, Code: "weight", Message: "a bundle cannot contain products sold by weight"})
+		case c.currency != currency:
+			invalid = append(invalid, validators.FieldError{
+				Field: field, Code: "currency", Param: c.currency, Message: fmt.Sprintf("product is priced in %s, not %s", c.currency, currency),
+			})
+		}
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM product_bundle_items WHERE bundle_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to replace bundle items: %w", err)
+	}
+	for _, item := range input.Items {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO product_bundle_items (bundle_id, product_id, quantity) VALUES ($1, $2, $3)`,
+			id, item.ProductID, item.Quantity); err != nil {
+			return fmt.Errorf("failed to add bundle item: %w", err)
+		}
+	}
+
+	discount := 0.0
+	if input.Pricing == models.BundlePricingPercentOff {
+		discount = input.DiscountPercent
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE products SET bundle_pricing = $2, bundle_discount_percent = NULLIF($3, 0)
+		WHERE id = $1`, id, input.Pricing, discount); err != nil {
+		return fmt.Errorf("failed to update bundle pricing: %w", err)
+	}
+	return refreshBundlePrices(ctx, tx, id)
+}
+
+// refreshBundlePrices reprices the percent-off bundles that are, or contain,
+// product id from their current component prices
+func refreshBundlePrices(ctx context.Context, tx *sql.Tx, id string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE products b SET price_cents = ROUND(t.total * (100 - b.bundle_discount_percent) / 100)
+		FROM (
+			SELECT bi.bundle_id, SUM(c.price_cents * bi.quantity) AS total
+			FROM product_bundle_items bi JOIN products c ON c.id = bi.product_id
+			WHERE bi.bundle_id = $1
+				OR bi.bundle_id IN (SELECT bundle_id FROM product_bundle_items WHERE product_id = $1)
+			GROUP BY bi.bundle_id
+		) t
+		WHERE b.id = t.bundle_id AND b.bundle_pricing = 'percent_off'`, id)
+	if err != nil {
+		return fmt.Errorf("failed to reprice bundles: %w", err)
+	}
+	return nil
+}
+
+// bundleComponents returns the components of each of bundleIDs, with the
+// quantity of each included in one bundle
+func bundleComponents(ctx context.Context, db *database.PostgresDB, bundleIDs []string) (map[string][]models.BundleComponent, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT bi.bundle_id, c.id, c.title, c.price_cents, COALESCE(c.currency, 'USD'), bi.quantity, COALESCE(c.stock_quantity, 0),
+			c.deleted_at IS NULL AND COALESCE(c.is_active, true)
+		FROM product_bundle_items bi
+		JOIN products c ON c.id = bi.product_id
+		WHERE bi.bundle_id = ANY($1)
+		ORDER BY c.title, c.id`, pq.Array(bundleIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bundle components: %w", err)
+	}
+	defer rows.Close()
+
+	components := make(map[string][]models.BundleComponent, len(bundleIDs))
+	for rows.Next() {
+		var bundleID string
+		var c models.BundleComponent
+		if err := rows.Scan(&bundleID, &c.ProductID, &c.Title, &c.Price.Amount, &c.Price.Currency, &c.Quantity, &c.StockQuantity, &c.IsActive); err != nil {
+			return nil, fmt.Errorf("failed to scan bundle component: %w", err)
+		}
+		components[bundleID] = append(components[bundleID], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get bundle components: %w", err)
+	}
+	return components, nil
+}
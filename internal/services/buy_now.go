@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Buy now
+//
+// Buy now orders a single product and charges it in one request, without
+// the cart. The product is priced and its stock taken by the same checkout
+// Create runs on the cart, so quantity rules, purchase limits, shipping and
+// the sellers' hours are checked the same way, and the order is then paid
+// as Pay would pay it. A declined payment cancels the order, returning its
+// stock. Each request carries an idempotency key: a retry with the same
+// key, even one racing the first, returns the order the first placed, and
+// pays it if the first didn't get that far, instead of placing another.
+
+// orderIdempotencyKeyIndex is the unique index on a buyer's idempotency keys
+const orderIdempotencyKeyIndex = "idx_orders_idempotency_key"
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used to buy another product")
+
+// BuyNow orders input.Quantity of product productID for buyerID and
+// charges it to their card, or replays the buy now idempotencyKey already
+// placed. The order ships to input.ShippingAddress, else to the address of
+// the buyer's last order that wasn't a gift, and is paid with
+// input.PaymentMethodID, else their default card; a
+// *validators.ValidationError says which is missing.
+// Products on preorder can't be bought now. A decline fails with a
+// *PaymentDeclinedError once the order is cancelled, and so does a retry
+// of the declined request.
+func (s *OrderService) BuyNow(ctx context.Context, buyerID, productID, idempotencyKey string, input models.BuyNowInput) (*models.Order, error) {
+	if s.payments.gateway == nil {
+		return nil, ErrPaymentsUnavailable
+	}
+	if order, err := s.replayBuyNow(ctx, buyerID, productID, idempotencyKey); order != nil || err != nil {
+		return order, err
+	}
+
+	var placed *placedOrder
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		cart, err := priceLines(ctx, tx, `
+			SELECT `+checkoutLineColumns("$2", "COALESCE($3::int, p.min_order_qty)")+`
+			FROM products p
+			WHERE p.id = $1`, productID, now, input.Quantity)
+		if err != nil {
+			return fmt.Errorf("failed to get product: %w", err)
+		}
+		if len(cart.lines) == 0 {
+			return ErrProductNotFound
+		}
+		if line := &cart.lines[0]; line.problem == nil && line.preorder {
+			line.problem = &validators.FieldError{
+				Field: "items[0].productId", Code: "preorder", Message: "product is on preorder; preorder it through the cart",
+			}
+		}
+		orderInput, err := s.buyNowOrderInput(ctx, tx, buyerID, input)
+		if err != nil {
+			return err
+		}
+		placed, err = s.placeOrder(ctx, tx, buyerID, cart, orderInput, idempotencyKey, now)
+		return err
+	})
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == orderIdempotencyKeyIndex {
+		// A request with the same key placed it first
+		return s.replayBuyNow(ctx, buyerID, productID, idempotencyKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.inventory.invalidateListings(ctx, placed.categoryIDs)
+	return s.payBuyNow(ctx, placed.id, buyerID)
+}
+
+// buyNowOrderInput returns the checkout input of a buy now, with the
+// shipping address and card filled in from the buyer's when not given
+func (s *OrderService) buyNowOrderInput(ctx context.Context, tx *sql.Tx, buyerID string, input models.BuyNowInput) (models.OrderInput, error) {
+	orderInput := models.OrderInput{ShippingAddress: input.ShippingAddress, ShippingMethod: input.ShippingMethod}
+	if len(orderInput.ShippingAddress) == 0 {
+		var address []byte
+		err := tx.QueryRowContext(ctx, `
+			SELECT shipping_address FROM orders
+			WHERE buyer_id = $1 AND NOT COALESCE(is_gift, false) AND shipping_address IS NOT NULL
+			ORDER BY created_at DESC
+			LIMIT 1`, buyerID).Scan(&address)
+		if errors.Is(err, sql.ErrNoRows) {
+			return orderInput, &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: "shippingAddress", Code: "required", Message: "shipping address is required on a first order",
+			}}}
+		}
+		if err != nil {
+			return orderInput, fmt.Errorf("failed to get last shipping address: %w", err)
+		}
+		orderInput.ShippingAddress = json.RawMessage(address)
+	}
+
+	card, err := s.payments.card(ctx, tx, buyerID, input.PaymentMethodID)
+	if errors.Is(err, ErrPaymentMethodNotFound) {
+		message := "payment method not found"
+		if input.PaymentMethodID == "" {
+			message = "no default payment method"
+		}
+		return orderInput, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "paymentMethodId", Code: "not_found", Message: message,
+		}}}
+	}
+	if err != nil {
+		return orderInput, err
+	}
+	orderInput.PaymentMethodID = card.id
+	return orderInput, nil
+}
+
+// replayBuyNow returns the outcome of the buy now buyerID placed with
+// idempotencyKey, paying its order if it is still pending, or nil when
+// there is none
+func (s *OrderService) replayBuyNow(ctx context.Context, buyerID, productID, idempotencyKey string) (*models.Order, error) {
+	var orderID string
+	var orderedID sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT o.id, (SELECT product_id::text FROM order_items WHERE order_id = o.id LIMIT 1)
+		FROM orders o
+		WHERE o.buyer_id = $1 AND o.idempotency_key = $2`, buyerID, idempotencyKey).Scan(&orderID, &orderedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if orderedID.String != productID {
+		return nil, ErrIdempotencyKeyReused
+	}
+	return s.buyNowOutcome(ctx, orderID, buyerID)
+}
+
+// payBuyNow pays buy now order orderID, cancelling it when the payment is
+// declined
+func (s *OrderService) payBuyNow(ctx context.Context, orderID, buyerID string) (*models.Order, error) {
+	order, err := s.Pay(ctx, orderID, buyerID, models.PaymentInput{})
+	var declined *PaymentDeclinedError
+	switch {
+	case errors.As(err, &declined):
+		if err := s.cancelDeclined(ctx, orderID, buyerID); err != nil {
+			return nil, err
+		}
+		return nil, declined
+	case errors.Is(err, ErrInvalidOrderTransition):
+		// A request with the same key paid or cancelled it meanwhile
+		return s.buyNowOutcome(ctx, orderID, buyerID)
+	case errors.Is(err, ErrOrderHeld):
+		// Left unpaid for an admin to review
+		return s.Get(ctx, orderID, buyerID, false)
+	}
+	return order, err
+}
+
+// buyNowOutcome returns buy now order orderID as its request answers it:
+// paid when still pending, a *PaymentDeclinedError when its payment was
+// declined, otherwise the order as it is
+func (s *OrderService) buyNowOutcome(ctx context.Context, orderID, buyerID string) (*models.Order, error) {
+	var status string
+	var declineReason sql.NullString
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(o.status, 'pending'),
+			(SELECT decline_reason FROM payment_attempts WHERE order_id = o.id ORDER BY created_at DESC LIMIT 1)
+		FROM orders o
+		WHERE o.id = $1`, orderID).Scan(&status, &declineReason); err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	switch {
+	case status == "pending":
+		return s.payBuyNow(ctx, orderID, buyerID)
+	case status == "cancelled" && declineReason.Valid:
+		return nil, &PaymentDeclinedError{Reason: declineReason.String}
+	}
+	return s.Get(ctx, orderID, buyerID, false)
+}
+
+// cancelDeclined cancels order orderID after its payment was declined,
+// returning its stock, unless it has moved on from pending meanwhile
+func (s *OrderService) cancelDeclined(ctx context.Context, orderID, buyerID string) error {
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.status != "pending" {
+			return nil
+		}
+		categoryIDs, err = s.transition(ctx, tx, order, "cancelled", buyerID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cancel declined order: %w", err)
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return nil
+}
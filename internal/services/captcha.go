@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// captchaVerifyURLs are the siteverify endpoints of the supported providers
+var captchaVerifyURLs = map[string]string{
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// CaptchaVerifier verifies CAPTCHA tokens server-side with reCAPTCHA,
+// hCaptcha or Cloudflare Turnstile, which share the siteverify protocol
+type CaptchaVerifier struct {
+	provider string
+	url      string
+	secret   string
+	minScore float64
+	client   *http.Client
+}
+
+// NewCaptchaVerifier creates a verifier for cfg.Provider
+func NewCaptchaVerifier(cfg config.CaptchaConfig) (*CaptchaVerifier, error) {
+	verifyURL, ok := captchaVerifyURLs[cfg.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown captcha provider %q", cfg.Provider)
+	}
+	return &CaptchaVerifier{
+		provider: cfg.Provider,
+		url:      verifyURL,
+		secret:   cfg.SecretKey,
+		minScore: cfg.MinScore,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+// Verify reports whether the provider accepts token, solved by a client at
+// remoteIP. reCAPTCHA v3 tokens must also score at least the configured
+// minimum.
+func (v *CaptchaVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, fmt.Errorf("failed to create captcha request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	defer utils.TrackTiming(req.Context(), "external")()
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify captcha: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("captcha provider returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		Score      *float64 `json:"score"` // reCAPTCHA v3 only
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode captcha response: %w", err)
+	}
+	for _, code := range result.ErrorCodes {
+		// A bad secret fails every token, which is a misconfiguration rather
+		// than a bot
+		if code == "invalid-input-secret" || code == "missing-input-secret" {
+			return false, fmt.Errorf("captcha provider rejected the secret key: %s", code)
+		}
+	}
+	if !result.Success {
+		log.Debug().Str("provider", v.provider).Strs("error_codes", result.ErrorCodes).Msg("CAPTCHA token rejected")
+		return false, nil
+	}
+	if result.Score != nil && *result.Score < v.minScore {
+		log.Debug().Str("provider", v.provider).Float64("score", *result.Score).Msg("CAPTCHA score below minimum")
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,480 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+var (
+	ErrCartItemNotFound  = errors.New("cart item not found")
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// CartService handles shopping carts
+type CartService struct {
+	db    *database.PostgresDB
+	redis *database.RedisClient
+	holds *CartHolds
+
+	summaryTTL       time.Duration
+	wishlistMaxItems int
+}
+
+// NewCartService creates a new cart service. Limited products added to a
+// cart are held for it through holds.
+func NewCartService(db *database.PostgresDB, redis *database.RedisClient, holds *CartHolds, cfg config.CartConfig) *CartService {
+	return &CartService{db: db, redis: redis, holds: holds, summaryTTL: time.Duration(cfg.SummaryTTL) * time.Second,
+		wishlistMaxItems: cfg.WishlistMaxItems}
+}
+
+// Get returns a user's cart, priced as of now at the price tier of each
+// line's quantity. Lines whose product was unlisted, ran out of stock or no
+// longer meets its order quantity rules stay in the cart but are marked
+// unavailable. Stock other carts hold doesn't count as in stock. Lines on
+// preorder need no stock. The applied coupon is checked as checkout would
+// check it, and its discount taken off when it applies.
+func (s *CartService) Get(ctx context.Context, userID string) (*models.Cart, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.title, p.product_type, `+productUnitPriceAt("$2", "c.quantity")+`, COALESCE(p.currency, 'USD'), c.quantity,
+			`+productStock+`, p.deleted_at IS NULL AND COALESCE(p.is_active, true),
+			p.min_order_qty, p.max_order_qty, p.step_qty, p.unit_type,
+			CASE WHEN `+productPreorderAt("$2")+` THEN p.available_from END
+		FROM cart c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.user_id = $1
+		ORDER BY c.created_at, p.id`, userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+	defer rows.Close()
+
+	cart := &models.Cart{Items: []models.CartItem{}}
+	var bundleIDs, heldIDs []string
+	for rows.Next() {
+		var item models.CartItem
+		var listed bool
+		var rules quantityRules
+		if err := rows.Scan(&item.ProductID, &item.Title, &item.Type, &item.Price.Amount, &item.Price.Currency, &item.Quantity,
+			&item.StockQuantity, &listed, &rules.min, &rules.max, &rules.step, &rules.unitType, &item.AvailableFrom); err != nil {
+			return nil, fmt.Errorf("failed to scan cart item: %w", err)
+		}
+		item.UnitType, item.Weight = rules.unitType, models.LineWeight(rules.unitType, item.Quantity)
+		if item.LineTotal, err = models.LineTotal(item.Price, item.UnitType, item.Quantity); err != nil {
+			return nil, fmt.Errorf("failed to total cart item: %w", err)
+		}
+		inStock := item.StockQuantity >= item.Quantity || item.AvailableFrom != nil
+		item.IsAvailable = listed && inStock && rules.check(rules.field(), item.Quantity) == nil
+		if item.Type == models.ProductTypeBundle {
+			bundleIDs = append(bundleIDs, item.ProductID)
+		} else if item.AvailableFrom == nil {
+			heldIDs = append(heldIDs, item.ProductID)
+		}
+		cart.Items = append(cart.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+
+	held := s.holds.heldByOthers(ctx, userID, heldIDs)
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		if n := held[item.ProductID]; n > 0 {
+			item.StockQuantity = max(item.StockQuantity-n, 0)
+			item.IsAvailable = item.IsAvailable && item.StockQuantity >= item.Quantity
+		}
+	}
+
+	if len(bundleIDs) > 0 {
+		components, err := bundleComponents(ctx, s.db, bundleIDs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range cart.Items {
+			item := &cart.Items[i]
+			for _, c := range components[item.ProductID] {
+				c.Quantity *= item.Quantity
+				item.Components = append(item.Components, c)
+			}
+		}
+	}
+
+	currency := "USD"
+	if len(cart.Items) > 0 {
+		currency = cart.Items[0].Price.Currency
+	}
+	subtotal := money.Zero(currency)
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		if item.Price.Currency != currency {
+			item.IsAvailable = false
+			continue
+		}
+		if subtotal, err = subtotal.Add(item.LineTotal); err != nil {
+			return nil, fmt.Errorf("failed to total cart: %w", err)
+		}
+	}
+	if cart.Coupon, err = s.cartCouponDiscount(ctx, userID); err != nil {
+		return nil, err
+	}
+	discount := money.Zero(currency)
+	if cart.Coupon != nil && cart.Coupon.Valid && cart.Coupon.Discount.Currency == currency {
+		discount = cart.Coupon.Discount
+	}
+	if cart.Totals, err = newTotals(subtotal, discount, money.Zero(currency), money.Zero(currency)); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// Add adds quantity of a product to a user's cart, or its weight for a
+// product sold by weight, merging with any existing line. The merged
+// quantity must meet the product's order quantity rules and
+// be in stock that other carts don't hold, unless the product is on
+// preorder; a limited product is then held for the whole line. The merge is a single upsert checked within its
+// transaction, so concurrent additions neither duplicate the line nor slip
+// past the rules.
+func (s *CartService) Add(ctx context.Context, userID string, input models.CartItemInput) (*models.Cart, error) {
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		product, err := cartProduct(ctx, tx, input.ProductID)
+		if err != nil {
+			return err
+		}
+		added, err := product.lineQuantity(input.Quantity, input.Weight)
+		if err != nil {
+			return err
+		}
+
+		var quantity int
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO cart (user_id, product_id, quantity) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, product_id) DO UPDATE SET quantity = cart.quantity + EXCLUDED.quantity
+			RETURNING quantity`,
+			userID, input.ProductID, added).Scan(&quantity)
+		if err != nil {
+			return fmt.Errorf("failed to add cart item: %w", err)
+		}
+		if err := product.check(quantity); err != nil {
+			return err
+		}
+		return s.holdLine(ctx, userID, input.ProductID, quantity, product)
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateCartSummary(ctx, s.redis, userID)
+	return s.Get(ctx, userID)
+}
+
+// Update sets the quantity or weight of a product already in a user's cart,
+// subject to the same checks as Add
+func (s *CartService) Update(ctx context.Context, userID, productID string, input models.CartQuantityInput) (*models.Cart, error) {
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		product, err := cartProduct(ctx, tx, productID)
+		if err != nil {
+			return err
+		}
+		quantity, err := product.lineQuantity(input.Quantity, input.Weight)
+		if err != nil {
+			return err
+		}
+		if err := product.check(quantity); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE cart SET quantity = $3 WHERE user_id = $1 AND product_id = $2`, userID, productID, quantity)
+		if err != nil {
+			return fmt.Errorf("failed to update cart item: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrCartItemNotFound
+		}
+		return s.holdLine(ctx, userID, productID, quantity, product)
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateCartSummary(ctx, s.redis, userID)
+	return s.Get(ctx, userID)
+}
+
+// Remove removes a product from a user's cart
+func (s *CartService) Remove(ctx context.Context, userID, productID string) (*models.Cart, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM cart WHERE user_id = $1 AND product_id = $2`, userID, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove cart item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrCartItemNotFound
+	}
+	s.holds.release(ctx, userID, productID)
+	invalidateCartSummary(ctx, s.redis, userID)
+	return s.Get(ctx, userID)
+}
+
+// Availability sets product's available quantity to its stock less what
+// carts other than userID's hold, for showing how many are left. Products
+// aren't held when holds are disabled, so it is left unset then.
+func (s *CartService) Availability(ctx context.Context, product *models.Product, userID string) {
+	if !s.holds.enabled() || product.Type == models.ProductTypeBundle {
+		return
+	}
+	available := s.holds.available(ctx, userID, product.ID, product.StockQuantity)
+	product.Available = &available
+}
+
+// holdLine holds a cart line of quantity of a product with limits, checking
+// that stock other carts hold leaves enough for it. Bundles aren't held;
+// their stock is their components'. Nor are preorders, which take none.
+func (s *CartService) holdLine(ctx context.Context, userID, productID string, quantity int, limits cartLimits) error {
+	if limits.isBundle || limits.preorder {
+		return nil
+	}
+	return s.holds.hold(ctx, productID, userID, quantity, limits.available)
+}
+
+// AddWishlist adds every listed, in-stock product on a user's wishlist to
+// their cart at its minimum order quantity, in one transaction, and returns
+// the cart priced as of now, not at the items' added prices, with the items
+// skipped and why. Products already in the cart are left as they are. With clear, the added products are removed
+// from the wishlist; skipped ones always stay.
+func (s *CartService) AddWishlist(ctx context.Context, userID string, clear bool) (*models.WishlistMove, error) {
+	move := &models.WishlistMove{Added: []string{}, Skipped: []models.WishlistSkippedItem{}, Cleared: clear}
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Lines in another currency than the cart's would be unavailable
+		var currency string
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(p.currency, 'USD') FROM cart c
+			JOIN products p ON p.id = c.product_id
+			WHERE c.user_id = $1
+			ORDER BY c.created_at, p.id LIMIT 1`, userID).Scan(&currency)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get cart currency: %w", err)
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT p.id, p.title, COALESCE(p.currency, 'USD'), `+productStock+`,
+				p.deleted_at IS NULL AND COALESCE(p.is_active, true), p.min_order_qty, p.product_type
+			FROM wishlist w
+			JOIN products p ON p.id = w.product_id
+			WHERE w.user_id = $1
+			ORDER BY w.created_at, p.id`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to get wishlist: %w", err)
+		}
+		type wished struct {
+			id, title string
+			quantity  int
+			limits    cartLimits
+		}
+		var addable []wished
+		for rows.Next() {
+			var item wished
+			var itemCurrency string
+			var productType string
+			var listed bool
+			if err := rows.Scan(&item.id, &item.title, &itemCurrency, &item.limits.available, &listed, &item.quantity, &productType); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan wishlist item: %w", err)
+			}
+			reason := ""
+			switch {
+			case !listed:
+				reason = models.WishlistSkipUnavailable
+			case item.limits.available < item.quantity:
+				reason = models.WishlistSkipOutOfStock
+			case currency != "" && itemCurrency != currency:
+				reason = models.WishlistSkipCurrency
+			}
+			if reason != "" {
+				move.Skipped = append(move.Skipped, models.WishlistSkippedItem{ProductID: item.id, Title: item.title, Reason: reason})
+				continue
+			}
+			currency = itemCurrency
+			item.limits.isBundle = productType == models.ProductTypeBundle
+			addable = append(addable, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get wishlist: %w", err)
+		}
+
+		for _, item := range addable {
+			res, err := tx.ExecContext(ctx, `
+				INSERT INTO cart (user_id, product_id, quantity) VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, product_id) DO NOTHING`, userID, item.id, item.quantity)
+			if err != nil {
+				return fmt.Errorf("failed to add cart item: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				move.Skipped = append(move.Skipped, models.WishlistSkippedItem{ProductID: item.id, Title: item.title, Reason: models.WishlistSkipInCart})
+				continue
+			}
+			if err := s.holdLine(ctx, userID, item.id, item.quantity, item.limits); errors.Is(err, ErrInsufficientStock) {
+				// Other carts hold the rest of the stock
+				if _, err := tx.ExecContext(ctx, `
+					DELETE FROM cart WHERE user_id = $1 AND product_id = $2`, userID, item.id); err != nil {
+					return fmt.Errorf("failed to remove cart item: %w", err)
+				}
+				move.Skipped = append(move.Skipped, models.WishlistSkippedItem{ProductID: item.id, Title: item.title, Reason: models.WishlistSkipOutOfStock})
+				continue
+			} else if err != nil {
+				return err
+			}
+			move.Added = append(move.Added, item.id)
+		}
+
+		if clear && len(move.Added) > 0 {
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM wishlist WHERE user_id = $1 AND product_id = ANY($2)`, userID, pq.Array(move.Added)); err != nil {
+				return fmt.Errorf("failed to clear wishlist: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateCartSummary(ctx, s.redis, userID)
+	if move.Cart, err = s.Get(ctx, userID); err != nil {
+		return nil, err
+	}
+	return move, nil
+}
+
+// cartLimits are a listed product's available stock and quantity rules
+type cartLimits struct {
+	available int
+	rules     quantityRules
+	isBundle  bool
+	preorder  bool // needs no stock
+}
+
+// cartProduct returns the limits on a cart line of a listed product
+func cartProduct(ctx context.Context, tx *sql.Tx, productID string) (cartLimits, error) {
+	var limits cartLimits
+	var productType string
+	err := tx.QueryRowContext(ctx, `
+		SELECT `+productStock+`, p.min_order_qty, p.max_order_qty, p.step_qty, p.unit_type, p.product_type,
+			`+productPreorderAt("NOW()")+` FROM products p
+		WHERE p.id = $1 AND p.deleted_at IS NULL AND COALESCE(p.is_active, true)`, productID).Scan(
+		&limits.available, &limits.rules.min, &limits.rules.max, &limits.rules.step, &limits.rules.unitType, &productType,
+		&limits.preorder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return limits, ErrProductNotFound
+	}
+	if err != nil {
+		return limits, fmt.Errorf("failed to get product stock: %w", err)
+	}
+	limits.isBundle = productType == models.ProductTypeBundle
+	return limits, nil
+}
+
+// check checks that a line of quantity meets the product's order quantity
+// rules, returning a *validators.ValidationError if not, and is in stock
+// unless on preorder. Stock is only taken at checkout, so the stock check
+// is advisory.
+func (l cartLimits) check(quantity int) error {
+	if ferr := l.rules.check(l.rules.field(), quantity); ferr != nil {
+		return &validators.ValidationError{Fields: []validators.FieldError{*ferr}}
+	}
+	if l.available < quantity && !l.preorder {
+		return fmt.Errorf("%w: %s available", ErrInsufficientStock, l.rules.format(l.available))
+	}
+	return nil
+}
+
+// lineQuantity returns the quantity of a cart line of the product given as
+// quantity or weight: the weight in grams for a product sold by weight, the
+// quantity otherwise. The other one must not be given.
+func (l cartLimits) lineQuantity(quantity int, weight *models.Weight) (int, error) {
+	var ferr *validators.FieldError
+	switch {
+	case l.rules.unitType == models.UnitTypeWeight && weight == nil:
+		ferr = &validators.FieldError{Field: "weight", Code: "required", Message: "weight is required for products sold by weight"}
+	case l.rules.unitType == models.UnitTypeWeight && quantity != 0:
+		ferr = &validators.FieldError{Field: "quantity", Code: "excluded", Message: "products sold by weight take a weight, not a quantity"}
+	case l.rules.unitType != models.UnitTypeWeight && weight != nil:
+		ferr = &validators.FieldError{Field: "weight", Code: "excluded", Message: "weight is only allowed on products sold by weight"}
+	}
+	if ferr != nil {
+		return 0, &validators.ValidationError{Fields: []validators.FieldError{*ferr}}
+	}
+	if weight != nil {
+		return int(*weight), nil
+	}
+	return quantity, nil
+}
+
+// quantityRules are a product's order quantity rules: a line holds at least
+// min, at most max when set, in steps of step from min. For a product sold
+// by weight they are in grams.
+type quantityRules struct {
+	min      int
+	max      *int
+	step     int
+	unitType string
+}
+
+// check returns the rule that quantity breaks as an error on field, or nil
+func (r quantityRules) check(field string, quantity int) *validators.FieldError {
+	noun := "quantity"
+	if r.unitType == models.UnitTypeWeight {
+		noun = "weight"
+	}
+	switch {
+	case quantity < r.min:
+		return &validators.FieldError{
+			Field: field, Code: "min_order_qty", Param: r.param(r.min),
+			Message: fmt.Sprintf("%s must be at least %s", noun, r.format(r.min)),
+		}
+	case r.max != nil && quantity > *r.max:
+		return &validators.FieldError{
+			Field: field, Code: "max_order_qty", Param: r.param(*r.max),
+			Message: fmt.Sprintf("%s must be at most %s", noun, r.format(*r.max)),
+		}
+	case r.step > 1 && (quantity-r.min)%r.step != 0:
+		return &validators.FieldError{
+			Field: field, Code: "step_qty", Param: r.param(r.step),
+			Message: fmt.Sprintf("%s must be %s plus a multiple of %s", noun, r.format(r.min), r.format(r.step)),
+		}
+	}
+	return nil
+}
+
+// field is the input field a cart line's quantity is given in
+func (r quantityRules) field() string {
+	if r.unitType == models.UnitTypeWeight {
+		return "weight"
+	}
+	return "quantity"
+}
+
+// param returns quantity as a validation error parameter: a count, or a
+// weight in kg
+func (r quantityRules) param(quantity int) string {
+	if r.unitType == models.UnitTypeWeight {
+		return models.Weight(quantity).String()
+	}
+	return strconv.Itoa(quantity)
+}
+
+// format returns quantity for a message, such as "3" or "0.250 kg"
+func (r quantityRules) format(quantity int) string {
+	if r.unitType == models.UnitTypeWeight {
+		return models.Weight(quantity).String() + " " + models.WeightUnit
+	}
+	return strconv.Itoa(quantity)
+}
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Coupons
+//
+// A coupon is applied to a cart by code and stays on it until removed or
+// the cart is checked out. Whether it applies, and what it takes off, is
+// decided in one place, checkCoupon, over the lines priceCart prices: so
+// validating a code, applying it, showing the cart, quoting and checking out
+// always agree. Its discount is split over the lines it applies to and
+// counts as their sellers' discounts. A coupon is used once an order is
+// placed with it; usage isn't given back when the order is cancelled.
+
+var (
+	ErrCouponNotFound  = errors.New("coupon not found")
+	ErrDuplicateCoupon = errors.New("coupon code already exists")
+)
+
+const couponCodeIndex = "idx_coupons_code"
+
+// cartCoupon is the coupon applied to a cart being priced
+type cartCoupon struct {
+	*models.Coupon
+	applies map[string]bool // the cart's products it applies to
+	// result is the coupon checked against the lines without problems, set
+	// by totals
+	result *models.CouponValidation
+}
+
+// appliedCoupon returns the coupon applied to userID's cart within tx,
+// locking it when lock is set, or nil when there is none
+func appliedCoupon(ctx context.Context, tx *sql.Tx, userID string, cart *pricedCart, lock bool) (*cartCoupon, error) {
+	query := `
+		SELECT ` + couponColumns + `
+		FROM cart_coupons cc
+		JOIN coupons c ON c.id = cc.coupon_id
+		WHERE cc.user_id = $1`
+	if lock {
+		query += `
+		FOR UPDATE OF c`
+	}
+	coupon, err := scanCoupon(tx.QueryRowContext(ctx, query, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart coupon: %w", err)
+	}
+	return couponFor(ctx, tx, coupon, cart)
+}
+
+// couponByCode returns the coupon with code, ignoring case, within tx, or
+// ErrCouponNotFound
+func couponByCode(ctx context.Context, tx *sql.Tx, code string, cart *pricedCart) (*cartCoupon, error) {
+	coupon, err := scanCoupon(tx.QueryRowContext(ctx, `
+		SELECT `+couponColumns+` FROM coupons c WHERE c.code = $1`, normalizeCouponCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get coupon: %w", err)
+	}
+	return couponFor(ctx, tx, coupon, cart)
+}
+
+// couponFor finds the products of cart that coupon applies to
+func couponFor(ctx context.Context, tx *sql.Tx, coupon *models.Coupon, cart *pricedCart) (*cartCoupon, error) {
+	c := &cartCoupon{Coupon: coupon, applies: make(map[string]bool)}
+	productIDs := make([]string, 0, len(cart.lines))
+	for _, line := range cart.lines {
+		productIDs = append(productIDs, line.productID)
+	}
+	if len(coupon.ProductIDs) == 0 && len(coupon.CategoryIDs) == 0 {
+		for _, id := range productIDs {
+			c.applies[id] = true
+		}
+		return c, nil
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.id FROM products p
+		WHERE p.id = ANY($1)
+			AND (p.id = ANY($2) OR EXISTS (
+				SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id = ANY($3)))`,
+		pq.Array(productIDs), pq.Array(coupon.ProductIDs), pq.Array(coupon.CategoryIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get coupon products: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan coupon product: %w", err)
+		}
+		c.applies[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get coupon products: %w", err)
+	}
+	return c, nil
+}
+
+// checkCoupon checks coupon against the lines of cart without problems at
+// now, returning each line's share of its discount, or why it doesn't
+// apply. Every path that prices a coupon goes through here.
+func (c *pricedCart) checkCoupon(coupon *cartCoupon, now time.Time) ([]money.Money, *models.CouponValidation, error) {
+	result := &models.CouponValidation{Code: coupon.Code, Discount: money.Zero(c.currencyOr(coupon.Currency))}
+	reject := func(reason, message string) ([]money.Money, *models.CouponValidation, error) {
+		result.Reason, result.Message = reason, message
+		return nil, result, nil
+	}
+
+	switch {
+	case coupon.DeactivatedAt != nil:
+		return reject(models.CouponNotFound, "coupon not found")
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return reject(models.CouponNotStarted, "coupon can't be used yet")
+	case coupon.ExpiresAt != nil && !now.Before(*coupon.ExpiresAt):
+		return reject(models.CouponExpired, "coupon has expired")
+	case coupon.UsageLimit != nil && coupon.TimesUsed >= *coupon.UsageLimit:
+		return reject(models.CouponUsageLimit, "coupon has been used as many times as it can be")
+	}
+
+	var indexes []int
+	var weights []money.Money
+	for i, line := range c.lines {
+		if line.problem == nil && coupon.applies[line.productID] {
+			indexes = append(indexes, i)
+			weights = append(weights, line.lineTotal)
+		}
+	}
+	if len(indexes) == 0 {
+		return reject(models.CouponNotApplicable, "coupon doesn't apply to any item in the cart")
+	}
+	if c.currency != coupon.Currency {
+		return reject(models.CouponNotApplicable, fmt.Sprintf("coupon is for carts in %s", coupon.Currency))
+	}
+	spent, err := money.Sum(c.currency, weights...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to total coupon lines: %w", err)
+	}
+	if spent.Amount < coupon.MinSpend.Amount {
+		return reject(models.CouponMinSpend, fmt.Sprintf("spend at least %s %s on the items the coupon applies to",
+			coupon.MinSpend.Decimal(), coupon.MinSpend.Currency))
+	}
+
+	discount := spent
+	if coupon.PercentOff != nil {
+		if discount, err = spent.MulFrac(int64(*coupon.PercentOff), 100); err != nil {
+			return nil, nil, fmt.Errorf("failed to price coupon: %w", err)
+		}
+	} else if coupon.AmountOff.Amount < spent.Amount {
+		discount = *coupon.AmountOff
+	}
+	shares, err := discount.Allocate(weights)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to allocate coupon: %w", err)
+	}
+	discounts := make([]money.Money, len(c.lines))
+	for i := range discounts {
+		discounts[i] = money.Zero(c.currency)
+	}
+	for j, i := range indexes {
+		discounts[i] = shares[j]
+	}
+	result.Valid, result.Discount = true, discount
+	return discounts, result, nil
+}
+
+// currencyOr returns the cart's currency, or currency for a cart without
+// lines
+func (c *pricedCart) currencyOr(currency string) string {
+	if c.currency != "" {
+		return c.currency
+	}
+	return currency
+}
+
+// couponProblem returns why checkout would refuse the cart's coupon, if it
+// would. totals must have been called.
+func (c *pricedCart) couponProblem() *validators.FieldError {
+	if c.coupon == nil || c.coupon.result == nil || c.coupon.result.Valid {
+		return nil
+	}
+	return &validators.FieldError{Field: "coupon", Code: c.coupon.result.Reason, Message: c.coupon.result.Message}
+}
+
+// useCoupon counts the cart's coupon as used on orderID within tx, when it
+// took anything off the order
+func (c *pricedCart) useCoupon(ctx context.Context, tx *sql.Tx, orderID string) error {
+	if c.coupon == nil || c.coupon.result == nil || !c.coupon.result.Valid {
+		return nil
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE coupons SET times_used = times_used + 1, updated_at = NOW()
+		WHERE id = $1 AND (usage_limit IS NULL OR times_used < usage_limit)`, c.coupon.ID)
+	if err != nil {
+		return fmt.Errorf("failed to use coupon: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to use coupon: %w", err)
+	} else if n == 0 {
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "coupon", Code: models.CouponUsageLimit, Message: "coupon has been used as many times as it can be",
+		}}}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET coupon_id = $2 WHERE id = $1`, orderID, c.coupon.ID); err != nil {
+		return fmt.Errorf("failed to record order coupon: %w", err)
+	}
+	return nil
+}
+
+// ValidateCoupon checks code against userID's cart the way ApplyCoupon and
+// checkout would, without changing the cart. A code that doesn't apply is
+// not an error: the result says why.
+func (s *CartService) ValidateCoupon(ctx context.Context, userID, code string) (*models.CouponValidation, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	return validateCoupon(ctx, tx, userID, code, false)
+}
+
+// validateCoupon checks code against userID's cart within tx as of now
+func validateCoupon(ctx context.Context, tx *sql.Tx, userID, code string, lock bool) (*models.CouponValidation, error) {
+	now := time.Now()
+	cart, err := priceCart(ctx, tx, userID, now, lock)
+	if errors.Is(err, ErrEmptyCart) {
+		cart = &pricedCart{}
+	} else if err != nil {
+		return nil, err
+	}
+	coupon, err := couponByCode(ctx, tx, code, cart)
+	if errors.Is(err, ErrCouponNotFound) {
+		return &models.CouponValidation{
+			Code: normalizeCouponCode(code), Discount: money.Zero(cart.currencyOr("USD")),
+			Reason: models.CouponNotFound, Message: "coupon not found",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, result, err := cart.checkCoupon(coupon, now)
+	return result, err
+}
+
+// ApplyCoupon applies code to userID's cart, replacing any coupon already
+// applied, when ValidateCoupon would find it valid. A code that doesn't apply
+// fails with a *validators.ValidationError giving the reason as its code.
+func (s *CartService) ApplyCoupon(ctx context.Context, userID, code string) (*models.Cart, error) {
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		result, err := validateCoupon(ctx, tx, userID, code, true)
+		if err != nil {
+			return err
+		}
+		if !result.Valid {
+			return &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: "code", Code: result.Reason, Message: result.Message,
+			}}}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO cart_coupons (user_id, coupon_id)
+			SELECT $1, id FROM coupons WHERE code = $2
+			ON CONFLICT (user_id) DO UPDATE SET coupon_id = EXCLUDED.coupon_id, applied_at = NOW()`,
+			userID, result.Code); err != nil {
+			return fmt.Errorf("failed to apply coupon: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateCartSummary(ctx, s.redis, userID)
+	return s.Get(ctx, userID)
+}
+
+// RemoveCoupon removes the coupon applied to userID's cart, if any
+func (s *CartService) RemoveCoupon(ctx context.Context, userID string) (*models.Cart, error) {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM cart_coupons WHERE user_id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("failed to remove coupon: %w", err)
+	}
+	invalidateCartSummary(ctx, s.redis, userID)
+	return s.Get(ctx, userID)
+}
+
+// cartCouponDiscount checks the coupon applied to userID's cart as checkout
+// would, returning nil when none is applied
+func (s *CartService) cartCouponDiscount(ctx context.Context, userID string) (*models.CouponValidation, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var applied bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM cart_coupons WHERE user_id = $1)`, userID).Scan(&applied); err != nil {
+		return nil, fmt.Errorf("failed to get cart coupon: %w", err)
+	}
+	if !applied {
+		return nil, nil
+	}
+	now := time.Now()
+	cart, err := priceCart(ctx, tx, userID, now, false)
+	if errors.Is(err, ErrEmptyCart) {
+		cart = &pricedCart{}
+		if cart.coupon, err = appliedCoupon(ctx, tx, userID, cart, false); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if cart.coupon == nil {
+		return nil, nil
+	}
+	_, result, err := cart.checkCoupon(cart.coupon, now)
+	return result, err
+}
+
+// CreateCoupon creates a coupon from input, created by adminID
+func (s *CartService) CreateCoupon(ctx context.Context, adminID string, input models.CouponInput) (*models.Coupon, error) {
+	currency := strings.ToUpper(input.Currency)
+	if err := validateCouponInput(input, currency); err != nil {
+		return nil, err
+	}
+	var amountOff sql.NullInt64
+	if input.AmountOff != nil {
+		amountOff = sql.NullInt64{Int64: input.AmountOff.Amount, Valid: true}
+	}
+	var minSpend int64
+	if input.MinSpend != nil {
+		minSpend = input.MinSpend.Amount
+	}
+	productIDs, categoryIDs := input.ProductIDs, input.CategoryIDs
+	if productIDs == nil {
+		productIDs = []string{}
+	}
+	if categoryIDs == nil {
+		categoryIDs = []string{}
+	}
+	coupon, err := scanCoupon(s.db.QueryRowContext(ctx, `
+		INSERT INTO coupons AS c (code, percent_off, amount_off_cents, currency, min_spend_cents, product_ids, category_ids,
+			usage_limit, starts_at, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+couponColumns,
+		normalizeCouponCode(input.Code), input.PercentOff, amountOff, currency, minSpend, pq.Array(productIDs),
+		pq.Array(categoryIDs), input.UsageLimit, input.StartsAt, input.ExpiresAt, adminID))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == couponCodeIndex {
+		return nil, ErrDuplicateCoupon
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coupon: %w", err)
+	}
+	return coupon, nil
+}
+
+// DeactivateCoupon stops coupon id being applied. Carts it is applied to
+// keep it, but checkout refuses it as not found.
+func (s *CartService) DeactivateCoupon(ctx context.Context, id string) (*models.Coupon, error) {
+	coupon, err := scanCoupon(s.db.QueryRowContext(ctx, `
+		UPDATE coupons c SET deactivated_at = COALESCE(deactivated_at, NOW()), updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+couponColumns, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate coupon: %w", err)
+	}
+	return coupon, nil
+}
+
+// validateCouponInput checks that a coupon's amounts are in its currency and
+// its dates in order
+func validateCouponInput(input models.CouponInput, currency string) error {
+	var invalid []validators.FieldError
+	if !money.ValidCurrency(currency) {
+		invalid = append(invalid, validators.FieldError{Field: "currency", Code: "currency", Message: "currency is not supported"})
+	}
+	for field, amount := range map[string]*money.Money{"amountOff": input.AmountOff, "minSpend": input.MinSpend} {
+		switch {
+		case amount == nil:
+		case amount.Currency != currency:
+			invalid = append(invalid, validators.FieldError{
+				Field: field, Code: "currency", Param: currency, Message: fmt.Sprintf("%s must be in %s", field, currency),
+			})
+		case amount.Amount < 0 || field == "amountOff" && amount.Amount == 0:
+			invalid = append(invalid, validators.FieldError{
+				Field: field, Code: "gt", Param: "0", Message: fmt.Sprintf("%s must be greater than 0", field),
+			})
+		}
+	}
+	if input.StartsAt != nil && input.ExpiresAt != nil && !input.ExpiresAt.After(*input.StartsAt) {
+		invalid = append(invalid, validators.FieldError{
+			Field: "expiresAt", Code: "gtfield", Param: "startsAt", Message: "expiresAt must be after startsAt",
+		})
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
+
+// normalizeCouponCode returns code as coupons are stored
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+const couponColumns = `c.id, c.code, c.percent_off, c.amount_off_cents, c.currency, c.min_spend_cents, c.product_ids, c.category_ids,
+	c.usage_limit, c.times_used, c.starts_at, c.expires_at, c.deactivated_at, c.created_at, c.updated_at`
+
+func scanCoupon(row rowScanner) (*models.Coupon, error) {
+	var c models.Coupon
+	var amountOff sql.NullInt64
+	if err := row.Scan(&c.ID, &c.Code, &c.PercentOff, &amountOff, &c.Currency, &c.MinSpend.Amount, pq.Array(&c.ProductIDs),
+		pq.Array(&c.CategoryIDs), &c.UsageLimit, &c.TimesUsed, &c.StartsAt, &c.ExpiresAt, &c.DeactivatedAt,
+		&c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	c.MinSpend.Currency = c.Currency
+	if amountOff.Valid {
+		c.AmountOff = &money.Money{Amount: amountOff.Int64, Currency: c.Currency}
+	}
+	if c.ProductIDs == nil {
+		c.ProductIDs = []string{}
+	}
+	if c.CategoryIDs == nil {
+		c.CategoryIDs = []string{}
+	}
+	return &c, nil
+}
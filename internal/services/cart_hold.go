@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+)
+
+// Cart holds
+//
+// Adding a limited product to the cart places a soft hold on it in Redis for
+// a short while, so a buyer checking out first can't take the stock out from
+// under the cart. Holds never touch product stock: they only lower what
+// other buyers can add to their carts and check out, and they lapse on their
+// own when their TTL runs out. Stock is still only taken at checkout, under
+// the product's row lock, and checkout leaves what other carts hold alone;
+// together the two can't sell more than there is. Holds are best effort:
+// when Redis is unavailable, carts and checkout carry on as if nothing were
+// held.
+
+// placeHoldScript holds a quantity of a product for a user's cart, replacing
+// any hold they had, unless the stock minus what other users hold is less
+// than that. Holds past their expiry are dropped first. KEYS are the
+// product's sorted set of holders by expiry and its hash of held quantities
+// by holder; ARGV the user, the quantity, now and the hold's expiry in
+// milliseconds, the stock, the hold TTL in seconds and whether to place the
+// hold or only check. Returns whether the quantity is available and how much
+// is left for others after it.
+var placeHoldScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+if #expired > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+	redis.call('HDEL', KEYS[2], unpack(expired))
+end
+local held = 0
+local holds = redis.call('HGETALL', KEYS[2])
+for i = 1, #holds, 2 do
+	if holds[i] ~= ARGV[1] then
+		held = held + tonumber(holds[i + 1])
+	end
+end
+local available = tonumber(ARGV[5]) - held
+if available < tonumber(ARGV[2]) then
+	return {0, available}
+end
+if ARGV[7] == '1' then
+	redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+	redis.call('EXPIRE', KEYS[1], ARGV[6])
+	redis.call('EXPIRE', KEYS[2], ARGV[6])
+end
+return {1, available - tonumber(ARGV[2])}
+`)
+
+// CartHolds places and reads the soft holds carts have on products. A nil
+// or disabled CartHolds holds nothing.
+type CartHolds struct {
+	redis     *database.RedisClient
+	ttl       time.Duration
+	threshold int
+}
+
+// NewCartHolds creates the cart holds of cfg
+func NewCartHolds(redis *database.RedisClient, cfg config.CartConfig) *CartHolds {
+	return &CartHolds{redis: redis, ttl: time.Duration(cfg.HoldTTL) * time.Second, threshold: cfg.HoldThreshold}
+}
+
+func (h *CartHolds) enabled() bool {
+	return h != nil && h.ttl > 0
+}
+
+// limited reports whether a product with stock is scarce enough to hold
+func (h *CartHolds) limited(stock int) bool {
+	return h.threshold == 0 || stock <= h.threshold
+}
+
+func cartHoldKeys(productID string) []string {
+	return []string{"cart:holds:" + productID, "cart:holds:" + productID + ":qty"}
+}
+
+// hold holds quantity of productID, of which there is stock, for userID's
+// cart when the product is limited, replacing any hold they had. It fails
+// with ErrInsufficientStock when the stock other carts don't hold is less
+// than quantity, whether or not the product is limited.
+func (h *CartHolds) hold(ctx context.Context, productID, userID string, quantity, stock int) error {
+	if !h.enabled() {
+		return nil
+	}
+	place := "0"
+	if h.limited(stock) {
+		place = "1"
+	}
+	now := time.Now()
+	res, err := placeHoldScript.Run(ctx, h.redis.Client, cartHoldKeys(productID),
+		userID, quantity, now.UnixMilli(), now.Add(h.ttl).UnixMilli(), stock, int(h.ttl.Seconds()), place).Int64Slice()
+	if err != nil {
+		log.Warn().Err(err).Str("product_id", productID).Msg("Failed to hold cart item")
+		return nil
+	}
+	if res[0] == 0 {
+		return fmt.Errorf("%w: %d available", ErrInsufficientStock, max(res[1], 0))
+	}
+	return nil
+}
+
+// release drops userID's holds on productIDs
+func (h *CartHolds) release(ctx context.Context, userID string, productIDs ...string) {
+	if !h.enabled() || len(productIDs) == 0 {
+		return
+	}
+	_, err := h.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range productIDs {
+			keys := cartHoldKeys(id)
+			pipe.ZRem(ctx, keys[0], userID)
+			pipe.HDel(ctx, keys[1], userID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to release cart holds")
+	}
+}
+
+// heldByOthers returns how much of each of productIDs carts other than
+// userID's hold. Products nothing is held of are left out.
+func (h *CartHolds) heldByOthers(ctx context.Context, userID string, productIDs []string) map[string]int {
+	held := make(map[string]int)
+	if !h.enabled() || len(productIDs) == 0 {
+		return held
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	holders := make([]*redis.StringSliceCmd, len(productIDs))
+	quantities := make([]*redis.StringStringMapCmd, len(productIDs))
+	_, err := h.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range productIDs {
+			keys := cartHoldKeys(id)
+			holders[i] = pipe.ZRangeByScore(ctx, keys[0], &redis.ZRangeBy{Min: "(" + now, Max: "+inf"})
+			quantities[i] = pipe.HGetAll(ctx, keys[1])
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		log.Warn().Err(err).Msg("Failed to read cart holds")
+		return held
+	}
+	for i, id := range productIDs {
+		for _, holder := range holders[i].Val() {
+			if holder == userID {
+				continue
+			}
+			if quantity, err := strconv.Atoi(quantities[i].Val()[holder]); err == nil {
+				held[id] += quantity
+			}
+		}
+	}
+	return held
+}
+
+// available returns stock less what carts other than userID's hold, or
+// stock as it is when nothing is held
+func (h *CartHolds) available(ctx context.Context, userID, productID string, stock int) int {
+	return max(stock-h.heldByOthers(ctx, userID, []string{productID})[productID], 0)
+}
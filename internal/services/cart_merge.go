@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// MergeGuest merges the cart a guest built before signing in into the
+// user's cart, in one transaction, and returns the cart priced as of now.
+// Guest lines of a product already in the cart add to its line, as do
+// repeated guest lines of a product. A merged line is brought down to the
+// most that is in stock and meets the product's order quantity rules, and
+// is never left below what the cart had; lines of products no longer
+// listed, in another currency than the cart, or with no such quantity are
+// returned as unavailable with the reason. Each guest cart token merges
+// once: a retry finds the token recorded and changes nothing.
+func (s *CartService) MergeGuest(ctx context.Context, userID string, input models.CartMergeInput) (*models.CartMerge, error) {
+	merge := &models.CartMerge{Merged: []models.CartMergeItem{}, Unavailable: []models.CartMergeItem{}}
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Concurrent merges of a token wait here for the first to commit
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO cart_merges (guest_cart_token, user_id) VALUES ($1, $2)
+			ON CONFLICT (guest_cart_token) DO NOTHING`, input.GuestCartToken, userID)
+		if err != nil {
+			return fmt.Errorf("failed to record cart merge: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			merge.AlreadyMerged = true
+			return nil
+		}
+		if len(input.Items) == 0 {
+			return nil
+		}
+
+		var currency string
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(p.currency, 'USD') FROM cart c
+			JOIN products p ON p.id = c.product_id
+			WHERE c.user_id = $1
+			ORDER BY c.created_at, p.id LIMIT 1`, userID).Scan(&currency)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get cart currency: %w", err)
+		}
+
+		var ids []string
+		seen := map[string]bool{}
+		for _, item := range input.Items {
+			if !seen[item.ProductID] {
+				seen[item.ProductID] = true
+				ids = append(ids, item.ProductID)
+			}
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT p.id, p.title, `+productPriceAt("$3")+`, COALESCE(p.currency, 'USD'), `+productStock+`,
+				p.deleted_at IS NULL AND COALESCE(p.is_active, true), p.min_order_qty, p.max_order_qty, p.step_qty,
+				p.unit_type, p.product_type, COALESCE(c.quantity, 0)
+			FROM products p
+			LEFT JOIN cart c ON c.user_id = $1 AND c.product_id = p.id
+			WHERE p.id = ANY($2)`, userID, pq.Array(ids), time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+		type mergeLine struct {
+			item    models.CartMergeItem
+			limits  cartLimits
+			listed  bool
+			inCart  int
+			guest   int
+			shown   *money.Money // the price the guest was shown
+			invalid string       // why the guest's quantity doesn't fit the product
+		}
+		lines := map[string]*mergeLine{}
+		for rows.Next() {
+			line := &mergeLine{}
+			var productType string
+			var price money.Money
+			if err := rows.Scan(&line.item.ProductID, &line.item.Title, &price.Amount, &price.Currency,
+				&line.limits.available, &line.listed, &line.limits.rules.min, &line.limits.rules.max, &line.limits.rules.step,
+				&line.limits.rules.unitType, &productType, &line.inCart); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan product: %w", err)
+			}
+			line.limits.isBundle = productType == models.ProductTypeBundle
+			if line.listed {
+				line.item.Price = &price
+			}
+			lines[line.item.ProductID] = line
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+
+		for _, item := range input.Items {
+			line, ok := lines[item.ProductID]
+			if !ok || line.invalid != "" {
+				continue
+			}
+			quantity, err := line.limits.lineQuantity(item.Quantity, item.Weight)
+			var verr *validators.ValidationError
+			if errors.As(err, &verr) {
+				line.invalid = verr.Fields[0].Message
+				continue
+			}
+			line.guest += quantity
+			if item.Price != nil {
+				line.shown = item.Price
+			}
+		}
+
+		for _, id := range ids {
+			line, ok := lines[id]
+			if !ok {
+				merge.Unavailable = append(merge.Unavailable, models.CartMergeItem{
+					ProductID: id, Reason: models.CartMergeSkipUnavailable, Message: "product does not exist",
+				})
+				continue
+			}
+			item := line.item
+			rules := line.limits.rules
+			wanted := line.inCart + line.guest
+			quantity := rules.fit(min(wanted, line.limits.available))
+			if item.Price != nil && line.shown != nil {
+				item.PriceChanged = *item.Price != *line.shown
+			}
+			switch {
+			case !line.listed:
+				item.Reason, item.Message = models.CartMergeSkipUnavailable, "product is no longer listed"
+			case line.invalid != "":
+				item.Reason, item.Message = models.CartMergeSkipQuantityRules, line.invalid
+			case currency != "" && item.Price.Currency != currency:
+				item.Reason = models.CartMergeSkipCurrency
+				item.Message = fmt.Sprintf("product is priced in %s, not %s", item.Price.Currency, currency)
+			case quantity == 0 && line.limits.available < rules.min:
+				item.Reason = models.CartMergeSkipOutOfStock
+				item.Message = fmt.Sprintf("%s: %s available", ErrInsufficientStock, rules.format(line.limits.available))
+			case quantity == 0:
+				item.Reason, item.Message = models.CartMergeSkipQuantityRules, rules.check(rules.field(), wanted).Message
+			}
+			if item.Reason == "" && quantity > line.inCart {
+				if err := s.holdLine(ctx, userID, id, quantity, line.limits); errors.Is(err, ErrInsufficientStock) {
+					item.Reason, item.Message = models.CartMergeSkipOutOfStock, err.Error()
+				} else if err != nil {
+					return err
+				}
+			}
+			if item.Reason != "" {
+				merge.Unavailable = append(merge.Unavailable, item)
+				continue
+			}
+
+			// The cart's own line is kept as it was rather than reduced
+			quantity = max(quantity, line.inCart)
+			if quantity > line.inCart {
+				if _, err := tx.ExecContext(ctx, `
+					INSERT INTO cart (user_id, product_id, quantity) VALUES ($1, $2, $3)
+					ON CONFLICT (user_id, product_id) DO UPDATE SET quantity = EXCLUDED.quantity`,
+					userID, id, quantity); err != nil {
+					return fmt.Errorf("failed to merge cart item: %w", err)
+				}
+			}
+			item.Quantity, item.Weight = quantity, models.LineWeight(rules.unitType, quantity)
+			item.Reduced = quantity < wanted
+			currency = item.Price.Currency
+			merge.Merged = append(merge.Merged, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateCartSummary(ctx, s.redis, userID)
+	if merge.Cart, err = s.Get(ctx, userID); err != nil {
+		return nil, err
+	}
+	return merge, nil
+}
+
+// fit returns the largest quantity up to quantity that meets the rules, or 0
+// when there is none
+func (r quantityRules) fit(quantity int) int {
+	if r.max != nil && quantity > *r.max {
+		quantity = *r.max
+	}
+	if quantity < r.min {
+		return 0
+	}
+	if r.step > 1 {
+		quantity -= (quantity - r.min) % r.step
+	}
+	return quantity
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Reorder puts the items of a past order back in its buyer's cart at their
+// current prices, in one transaction, leaving the buyer to check out when
+// they are ready. An item is added at the quantity ordered, merged with any
+// line the cart has, when its product is still listed and in the cart's
+// currency, and the merged quantity meets the product's order quantity rules
+// and is in stock; the rest are returned as unavailable with the reason.
+// Added items whose price changed since the order are flagged. Buyers may
+// reorder their own orders and admins any order, on behalf of its buyer.
+func (s *CartService) Reorder(ctx context.Context, orderID, userID string, isAdmin bool) (*models.Reorder, error) {
+	reorder := &models.Reorder{Added: []models.ReorderItem{}, Unavailable: []models.ReorderItem{}}
+	var buyerID string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `SELECT buyer_id FROM orders WHERE id = $1`, orderID).Scan(&buyerID)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && buyerID != userID && !isAdmin {
+			return ErrOrderNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get order: %w", err)
+		}
+
+		var currency string
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(p.currency, 'USD') FROM cart c
+			JOIN products p ON p.id = c.product_id
+			WHERE c.user_id = $1
+			ORDER BY c.created_at, p.id LIMIT 1`, buyerID).Scan(&currency)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get cart currency: %w", err)
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT oi.product_id, p.title, oi.quantity, oi.price_cents, COALESCE(o.currency, 'USD'),
+				`+productPriceAt("$3")+`, COALESCE(p.currency, 'USD'), `+productStock+`,
+				p.deleted_at IS NULL AND COALESCE(p.is_active, true), p.min_order_qty, p.max_order_qty, p.step_qty,
+				p.unit_type, p.product_type, COALESCE(c.quantity, 0)
+			FROM order_items oi
+			JOIN orders o ON o.id = oi.order_id
+			JOIN products p ON p.id = oi.product_id
+			LEFT JOIN cart c ON c.user_id = $2 AND c.product_id = oi.product_id
+			WHERE oi.order_id = $1
+			ORDER BY p.title, oi.id`, orderID, buyerID, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to get order items: %w", err)
+		}
+		type reorderLine struct {
+			item    models.ReorderItem
+			limits  cartLimits
+			listed  bool
+			inCart  int
+			current string // the product's currency
+		}
+		var lines []reorderLine
+		for rows.Next() {
+			var line reorderLine
+			var productType string
+			var price int64
+			if err := rows.Scan(&line.item.ProductID, &line.item.Title, &line.item.Quantity,
+				&line.item.OrderedPrice.Amount, &line.item.OrderedPrice.Currency, &price, &line.current,
+				&line.limits.available, &line.listed, &line.limits.rules.min, &line.limits.rules.max, &line.limits.rules.step,
+				&line.limits.rules.unitType, &productType, &line.inCart); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan order item: %w", err)
+			}
+			line.item.Weight = models.LineWeight(line.limits.rules.unitType, line.item.Quantity)
+			line.limits.isBundle = productType == models.ProductTypeBundle
+			if line.listed {
+				line.item.Price = &money.Money{Amount: price, Currency: line.current}
+			}
+			lines = append(lines, line)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get order items: %w", err)
+		}
+
+		for _, line := range lines {
+			item := line.item
+			quantity := line.inCart + item.Quantity
+			if item.Price != nil {
+				item.PriceChanged = *item.Price != item.OrderedPrice
+			}
+			switch {
+			case !line.listed:
+				item.Reason, item.Message = models.ReorderSkipUnavailable, "product is no longer listed"
+			case currency != "" && line.current != currency:
+				item.Reason = models.ReorderSkipCurrency
+				item.Message = fmt.Sprintf("product is priced in %s, not %s", line.current, currency)
+			default:
+				if err := line.limits.check(quantity); err != nil {
+					var verr *validators.ValidationError
+					if errors.As(err, &verr) {
+						item.Reason, item.Message = models.ReorderSkipQuantityRules, verr.Fields[0].Message
+					} else {
+						item.Reason, item.Message = models.ReorderSkipOutOfStock, err.Error()
+					}
+				} else if err := s.holdLine(ctx, buyerID, item.ProductID, quantity, line.limits); errors.Is(err, ErrInsufficientStock) {
+					item.Reason, item.Message = models.ReorderSkipOutOfStock, err.Error()
+				} else if err != nil {
+					return err
+				}
+			}
+			if item.Reason != "" {
+				reorder.Unavailable = append(reorder.Unavailable, item)
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO cart (user_id, product_id, quantity) VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, product_id) DO UPDATE SET quantity = EXCLUDED.quantity`,
+				buyerID, item.ProductID, quantity); err != nil {
+				return fmt.Errorf("failed to add cart item: %w", err)
+			}
+			currency = line.current
+			reorder.Added = append(reorder.Added, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateCartSummary(ctx, s.redis, buyerID)
+	if reorder.Cart, err = s.Get(ctx, buyerID); err != nil {
+		return nil, err
+	}
+	return reorder, nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Savings returns what checking out userID's cart now would save. The cart
+// is priced by priceCart, as checkout and quotes price it, over the lines
+// checkout would order, so the savings are the order's Discounts: each
+// line's regular price less its sale price, and the order-level discounts.
+// A line whose sale price is above its regular price saves nothing rather
+// than counting against the others. An empty cart saves zero.
+func (s *CartService) Savings(ctx context.Context, userID string) (*models.CartSavings, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cart, err := priceCart(ctx, tx, userID, time.Now(), false)
+	if errors.Is(err, ErrEmptyCart) {
+		zero := money.Zero("USD")
+		return &models.CartSavings{
+			DiscountBreakdown: models.DiscountBreakdown{RegularSubtotal: zero, Sale: zero, Order: zero},
+			Total:             zero,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	totals, err := cart.totals()
+	if err != nil {
+		return nil, err
+	}
+
+	savings := &models.CartSavings{DiscountBreakdown: models.DiscountBreakdown{
+		RegularSubtotal: money.Zero(cart.currency), Sale: money.Zero(cart.currency), Order: totals.order.Discount,
+	}}
+	for _, line := range cart.lines {
+		if line.problem != nil {
+			continue
+		}
+		regularTotal, err := models.LineTotal(line.regularPrice, line.rules.unitType, line.quantity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to total cart savings: %w", err)
+		}
+		sale, err := regularTotal.Sub(line.lineTotal)
+		if err == nil && sale.Amount < 0 {
+			sale, regularTotal = money.Zero(cart.currency), line.lineTotal
+		}
+		if err == nil {
+			savings.RegularSubtotal, err = savings.RegularSubtotal.Add(regularTotal)
+		}
+		if err == nil {
+			savings.Sale, err = savings.Sale.Add(sale)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to total cart savings: %w", err)
+		}
+	}
+	if savings.Total, err = savings.Sale.Add(savings.Order); err != nil {
+		return nil, fmt.Errorf("failed to total cart savings: %w", err)
+	}
+	return savings, nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// cartSummaryKey is the Redis key of userID's cached cart summary
+func cartSummaryKey(userID string) string {
+	return "cart:summary:" + userID
+}
+
+// Summary returns the counts and totals of a user's cart. It is worked out
+// from Get, so it agrees with the full cart, and cached in Redis until the
+// cart next changes. Product changes don't reach carts, so the cache is also
+// kept short; a summary can lag a price or stock change by up to
+// cart.summary_ttl. When Redis is unavailable it is worked out every time.
+func (s *CartService) Summary(ctx context.Context, userID string) (*models.CartSummary, error) {
+	key := cartSummaryKey(userID)
+	if s.summaryTTL > 0 {
+		data, err := s.redis.Get(ctx, key)
+		if err == nil {
+			var summary models.CartSummary
+			if err := json.Unmarshal([]byte(data), &summary); err == nil {
+				return &summary, nil
+			}
+		} else if err != redis.Nil {
+			log.Warn().Err(err).Str("user_id", userID).Msg("Failed to read cached cart summary")
+		}
+	}
+
+	cart, err := s.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	summary := &models.CartSummary{
+		ProductCount: len(cart.Items),
+		Subtotal:     cart.Subtotal,
+		Discount:     cart.Discount,
+		Total:        cart.Total,
+	}
+	for _, item := range cart.Items {
+		if item.Weight != nil {
+			// A weighed line is one item, however many grams
+			summary.ItemCount++
+			continue
+		}
+		summary.ItemCount += item.Quantity
+	}
+
+	if s.summaryTTL > 0 {
+		if data, err := json.Marshal(summary); err == nil {
+			if err := s.redis.SetWithExpiration(ctx, key, data, s.summaryTTL); err != nil {
+				log.Warn().Err(err).Str("user_id", userID).Msg("Failed to cache cart summary")
+			}
+		}
+	}
+	return summary, nil
+}
+
+// invalidateCartSummary drops userID's cached cart summary after their cart
+// changed. A failure is logged; the summary then lapses with its TTL.
+func invalidateCartSummary(ctx context.Context, redis *database.RedisClient, userID string) {
+	if err := redis.Delete(ctx, cartSummaryKey(userID)); err != nil {
+		log.Warn().Err(err).Str("user_id", userID).Msg("Failed to invalidate cart summary")
+	}
+}
@@ -0,0 +1,716 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+var ErrEmptyCart = errors.New("cart is empty")
+
+// cartLine is a cart line being checked out
+type cartLine struct {
+	orderLine
+	sellerID       string
+	price          money.Money
+	regularPrice   money.Money // the price before any sale or price tier
+	lineTotal      money.Money
+	listed         bool
+	warehouse      string
+	processingDays sql.NullInt64
+	limit          *models.PurchaseLimit // nil when the product has no purchase limit
+	availableFrom  sql.NullTime          // when a product on preorder is available
+	rules          quantityRules
+	problem        *validators.FieldError // why the line can't be ordered, if it can't
+	discount       money.Money            // the line's share of the coupon's discount, set by totals
+}
+
+// pricedCart is a buyer's cart priced for checkout
+type pricedCart struct {
+	currency string
+	lines    []cartLine
+	pricedAt time.Time
+	coupon   *cartCoupon // the coupon applied to the cart, if any
+}
+
+// checkoutLineColumns returns the columns priceLines scans for a line of
+// product p ordering quantity, priced at now
+func checkoutLineColumns(now, quantity string) string {
+	return `p.id, p.seller_id, ` + quantity + `, p.product_type, ` + productUnitPriceAt(now, quantity) + `, p.price_cents,
+			COALESCE(p.currency, 'USD'), COALESCE(p.warehouse, ''), p.processing_days,
+			p.purchase_limit_qty, p.purchase_limit_days,
+			p.deleted_at IS NULL AND COALESCE(p.is_active, true), p.min_order_qty, p.max_order_qty, p.step_qty, p.unit_type,
+			CASE WHEN ` + productPreorderAt(now) + ` THEN p.available_from END`
+}
+
+// priceCart reads and prices buyerID's cart within tx at the prices of now,
+// locking its rows when lock is set. Lines that can't be ordered as they are
+// get a problem instead of failing: an unlisted product, a price in another
+// currency than the first line's or a quantity the product's rules don't
+// allow. Create and Quote both price the cart here, so a quote always
+// prices the cart the way checkout would; both then check purchase limits.
+// The coupon applied to the cart comes with it, locked when lock is set,
+// and is checked by totals.
+func priceCart(ctx context.Context, tx *sql.Tx, buyerID string, now time.Time, lock bool) (*pricedCart, error) {
+	query := `
+		SELECT ` + checkoutLineColumns("$2", "c.quantity") + `
+		FROM cart c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.user_id = $1
+		ORDER BY c.created_at, c.product_id`
+	if lock {
+		query += `
+		FOR UPDATE OF c`
+	}
+	cart, err := priceLines(ctx, tx, query, buyerID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+	if len(cart.lines) == 0 {
+		return nil, ErrEmptyCart
+	}
+	cart.pricedAt = now
+	if cart.coupon, err = appliedCoupon(ctx, tx, buyerID, cart, lock); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// priceLines prices the lines query selects with checkoutLineColumns, as
+// priceCart describes. It returns a cart without lines when there are none.
+func priceLines(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (*pricedCart, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var lines []cartLine
+	for rows.Next() {
+		var line cartLine
+		var productType string
+		var limitQty, limitDays sql.NullInt64
+		if err := rows.Scan(&line.productID, &line.sellerID, &line.quantity, &productType, &line.price.Amount, &line.regularPrice.Amount,
+			&line.price.Currency, &line.warehouse, &line.processingDays, &limitQty, &limitDays, &line.listed, &line.rules.min, &line.rules.max, &line.rules.step, &line.rules.unitType,
+			&line.availableFrom); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan line: %w", err)
+		}
+		line.regularPrice.Currency = line.price.Currency
+		if limitQty.Valid {
+			line.limit = &models.PurchaseLimit{Quantity: int(limitQty.Int64), WindowDays: int(limitDays.Int64)}
+		}
+		line.isBundle = productType == models.ProductTypeBundle
+		line.preorder = line.availableFrom.Valid
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return &pricedCart{}, nil
+	}
+
+	currency := lines[0].price.Currency
+	for i := range lines {
+		line := &lines[i]
+		if line.problem = line.rules.check(fmt.Sprintf("items[%d].%s", i, line.rules.field()), line.quantity); line.problem != nil {
+			continue
+		}
+		switch {
+		case !line.listed:
+			line.problem = &validators.FieldError{
+				Field: fmt.Sprintf("items[%d].productId", i), Code: "unavailable", Message: "product is no longer listed",
+			}
+		case line.price.Currency != currency:
+			line.problem = &validators.FieldError{
+				Field: fmt.Sprintf("items[%d].productId", i), Code: "currency", Param: line.price.Currency,
+				Message: fmt.Sprintf("product is priced in %s, not %s", line.price.Currency, currency),
+			}
+		default:
+			if line.lineTotal, err = models.LineTotal(line.price, line.rules.unitType, line.quantity); err != nil {
+				return nil, fmt.Errorf("failed to total order: %w", err)
+			}
+		}
+	}
+	return &pricedCart{currency: currency, lines: lines}, nil
+}
+
+// problems returns the problems of the cart's lines, in line order
+func (c *pricedCart) problems() []validators.FieldError {
+	var invalid []validators.FieldError
+	for _, line := range c.lines {
+		if line.problem != nil {
+			invalid = append(invalid, *line.problem)
+		}
+	}
+	return invalid
+}
+
+// blockSelfPurchases gives a problem to each line without one that buyerID
+// sells. Lines come with their seller, so this takes no query.
+func (c *pricedCart) blockSelfPurchases(buyerID string) {
+	for i := range c.lines {
+		line := &c.lines[i]
+		if line.problem == nil && line.sellerID == buyerID {
+			line.problem = &validators.FieldError{
+				Field: fmt.Sprintf("items[%d].productId", i), Code: "self_purchase", Message: "sellers can't order their own products",
+			}
+		}
+	}
+}
+
+// selfPurchases returns the number of lines buyerID sells
+func (c *pricedCart) selfPurchases(buyerID string) int {
+	n := 0
+	for _, line := range c.lines {
+		if line.sellerID == buyerID {
+			n++
+		}
+	}
+	return n
+}
+
+// stockLines returns the lines without problems for a stock check, with the
+// index of each in the cart
+func (c *pricedCart) stockLines() ([]orderLine, []int) {
+	var lines []orderLine
+	var indexes []int
+	for i, line := range c.lines {
+		if line.problem == nil {
+			lines = append(lines, line.orderLine)
+			indexes = append(indexes, i)
+		}
+	}
+	return lines, indexes
+}
+
+// cartTotals are the totals of a priced cart: one per seller, in the order
+// their first line appears, and the whole order's
+type cartTotals struct {
+	sellerIDs []string
+	sellers   []models.Totals
+	order     models.Totals
+}
+
+// totals totals the lines without problems by seller and altogether. The
+// cart's coupon is checked against those lines, and what it takes off each
+// counts toward its seller's discount.
+func (c *pricedCart) totals() (*cartTotals, error) {
+	var couponDiscounts []money.Money
+	if c.coupon != nil {
+		var err error
+		if couponDiscounts, c.coupon.result, err = c.checkCoupon(c.coupon, c.pricedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &cartTotals{}
+	subtotals := make(map[string]money.Money)
+	discounts := make(map[string]money.Money)
+	for i := range c.lines {
+		line := &c.lines[i]
+		line.discount = money.Zero(c.currency)
+		if line.problem != nil {
+			continue
+		}
+		subtotal, ok := subtotals[line.sellerID]
+		if !ok {
+			subtotal = money.Zero(c.currency)
+			discounts[line.sellerID] = money.Zero(c.currency)
+			t.sellerIDs = append(t.sellerIDs, line.sellerID)
+		}
+		subtotal, err := subtotal.Add(line.lineTotal)
+		if err != nil {
+			return nil, fmt.Errorf("failed to total order: %w", err)
+		}
+		subtotals[line.sellerID] = subtotal
+		if couponDiscounts != nil {
+			line.discount = couponDiscounts[i]
+			if discounts[line.sellerID], err = discounts[line.sellerID].Add(line.discount); err != nil {
+				return nil, fmt.Errorf("failed to total order: %w", err)
+			}
+		}
+	}
+	t.sellers = make([]models.Totals, len(t.sellerIDs))
+	for i, sellerID := range t.sellerIDs {
+		var err error
+		if t.sellers[i], err = newTotals(subtotals[sellerID], discounts[sellerID], money.Zero(c.currency), money.Zero(c.currency)); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if t.order, err = sumTotals(c.currency, t.sellers); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// lineDiscounts returns each line's share of its seller's discount as totals
+// set them, so the lines' shares sum to the seller's discount and the
+// order's to the order's to the cent. Lines with problems get none.
+func (c *pricedCart) lineDiscounts(t *cartTotals) ([]money.Money, error) {
+	discounts := make([]money.Money, len(c.lines))
+	sums := make(map[string]money.Money, len(t.sellerIDs))
+	for i, line := range c.lines {
+		discounts[i] = money.Zero(c.currency)
+		if line.problem != nil || line.discount.Currency != c.currency {
+			continue
+		}
+		discounts[i] = line.discount
+		sum, ok := sums[line.sellerID]
+		if !ok {
+			sum = money.Zero(c.currency)
+		}
+		var err error
+		if sums[line.sellerID], err = sum.Add(line.discount); err != nil {
+			return nil, fmt.Errorf("failed to allocate discount: %w", err)
+		}
+	}
+	for s, sellerID := range t.sellerIDs {
+		if sum := sums[sellerID]; sum.Amount != t.sellers[s].Discount.Amount {
+			return nil, fmt.Errorf("failed to allocate discount: lines of seller %s sum to %s, not %s", sellerID, sum, t.sellers[s].Discount)
+		}
+	}
+	return discounts, nil
+}
+
+// Create checks out a buyer's cart as a pending order, taking the stock of
+// every line and emptying the cart in one transaction. Lines are charged
+// the price as of the start of checkout, sale prices included. Bundles are ordered
+// as a single line and take their components' stock. If any line is
+// unlisted, priced in another currency or short of stock, nothing is
+// ordered and a *validators.ValidationError lists the cart lines; with
+// input.AllowBackorder lines short of stock are ordered backordered instead,
+// taking no stock until FillBackorders finds it. Order
+// quantity rules are checked again, since they may have changed after the
+// lines were added, and so are purchase limits, counting the buyer's recent
+// orders, and so is the cart's coupon, which fails checkout with a
+// *validators.ValidationError when it no longer applies and is otherwise
+// counted as used and removed with the cart's lines. A gift order keeps its recipient and a sanitized gift
+// message and is still the buyer's order. The order is split into one
+// sub-order per seller, whose totals with the add-ons' sum to the
+// order's, and each line's stock is taken for its sub-order so sub-orders
+// can be cancelled alone.
+// The order ships by input.ShippingMethod, standard when empty, and fails
+// with a *validators.ValidationError when the method can't ship the cart,
+// or needs the sellers open and one is closed or not accepting orders.
+// Sales tax is charged for input.ShippingAddress, each sub-order's on its
+// seller's lines. A stored card named by input.PaymentMethodID must be the
+// buyer's, and is the one ProcessPayment charges. Lines of products on
+// preorder are ordered as preorders, backordered until the product is
+// available without taking stock. A requested delivery date must fall after
+// the preorders are available. The add-ons of input.AddOns are ordered on the order itself,
+// added to its totals after shipping is priced. Orders the fraud rules
+// score high enough are placed held for review rather than pending.
+func (s *OrderService) Create(ctx context.Context, buyerID string, input models.OrderInput) (*models.Order, error) {
+	var placed *placedOrder
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		cart, err := priceCart(ctx, tx, buyerID, now, true)
+		if err != nil {
+			return err
+		}
+		if placed, err = s.placeOrder(ctx, tx, buyerID, cart, input, "", now); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM cart WHERE user_id = $1`, buyerID); err != nil {
+			return fmt.Errorf("failed to empty cart: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM cart_coupons WHERE user_id = $1`, buyerID); err != nil {
+			return fmt.Errorf("failed to remove cart coupon: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.inventory.invalidateListings(ctx, placed.categoryIDs)
+	s.holds.release(ctx, buyerID, placed.productIDs...)
+	invalidateCartSummary(ctx, s.redis, buyerID)
+	return s.Get(ctx, placed.id, buyerID, false)
+}
+
+// placedOrder is an order placeOrder placed, with the categories of the
+// listings whose stock it took and the products it ordered
+type placedOrder struct {
+	id          string
+	categoryIDs []string
+	productIDs  []string
+}
+
+// placeOrder orders the lines of cart, priced at now, for buyerID within tx
+// as Create describes, leaving the cart itself to the caller. The order
+// records idempotencyKey when it isn't empty.
+func (s *OrderService) placeOrder(ctx context.Context, tx *sql.Tx, buyerID string, cart *pricedCart, input models.OrderInput, idempotencyKey string, now time.Time) (*placedOrder, error) {
+	var orderID string
+	var categoryIDs, productIDs []string
+	if s.selfPurchase == "block" {
+		cart.blockSelfPurchases(buyerID)
+	}
+	if err := cart.checkPurchaseLimits(ctx, tx, buyerID, now, true); err != nil {
+		return nil, err
+	}
+	if invalid := cart.problems(); len(invalid) > 0 {
+		return nil, &validators.ValidationError{Fields: invalid}
+	}
+	totals, err := cart.totals()
+	if err != nil {
+		return nil, err
+	}
+	if problem := cart.couponProblem(); problem != nil {
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{*problem}}
+	}
+	shippingMethod := input.ShippingMethod
+	if shippingMethod == "" {
+		shippingMethod = models.ShippingStandard
+	}
+	if err := s.shipping.checkSellersOpen(ctx, tx, shippingMethod, totals.sellerIDs, now); err != nil {
+		return nil, err
+	}
+	if err := s.shipping.ship(cart, totals, shippingMethod); err != nil {
+		return nil, err
+	}
+	if input.DeliveryDate != "" {
+		if err := checkRequestedDelivery(input.DeliveryDate, cart, now); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.taxCart(ctx, tx, buyerID, cart, totals, input.ShippingAddress); err != nil {
+		return nil, err
+	}
+	addOns, err := priceAddOns(ctx, tx, cart.currency, input.AddOns)
+	if err != nil {
+		return nil, err
+	}
+	if err := totals.addAddOns(cart.currency, addOns); err != nil {
+		return nil, err
+	}
+	currency, lines := cart.currency, cart.lines
+	sellerIDs, subOrderTotals := totals.sellerIDs, totals.sellers
+	discounts, err := cart.lineDiscounts(totals)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.PaymentMethodID != "" {
+		if _, err := s.payments.card(ctx, tx, buyerID, input.PaymentMethodID); errors.Is(err, ErrPaymentMethodNotFound) {
+			return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: "paymentMethodId", Code: "not_found", Message: "payment method not found",
+			}}}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var gift models.OrderGift
+	isGift := input.IsGift && input.Gift != nil
+	if isGift {
+		gift = models.OrderGift{
+			RecipientName:   strings.TrimSpace(input.Gift.RecipientName),
+			RecipientEmail:  input.Gift.RecipientEmail,
+			Message:         sanitizeGiftMessage(input.Gift.Message),
+			NotifyRecipient: input.Gift.NotifyRecipient,
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO orders (buyer_id, status, payment_status, subtotal_cents, discount_cents, tax_cents, shipping_cents, total_cents,
+			currency, shipping_address, shipping_method, payment_method, payment_method_id,
+			is_gift, gift_recipient_name, gift_recipient_email, gift_message, gift_notify_recipient, requested_delivery_date,
+			idempotency_key)
+		VALUES ($1, 'pending', 'pending', $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), NULLIF($11, '')::uuid,
+			$12, NULLIF($13, ''), NULLIF($14, ''), NULLIF($15, ''), $16, NULLIF($17, '')::date, NULLIF($18, ''))
+		RETURNING id`,
+		buyerID, totals.order.Subtotal.Amount, totals.order.Discount.Amount, totals.order.Tax.Amount, totals.order.Shipping.Amount,
+		totals.order.Total.Amount, currency, jsonParam(input.ShippingAddress), shippingMethod, input.PaymentMethod, input.PaymentMethodID,
+		isGift, gift.RecipientName, gift.RecipientEmail, gift.Message, gift.NotifyRecipient, input.DeliveryDate, idempotencyKey).Scan(&orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	created := map[string]interface{}{"total": totals.order.Total, "items": len(lines), "shippingMethod": shippingMethod}
+	if selfPurchases := cart.selfPurchases(buyerID); selfPurchases > 0 {
+		created["selfPurchases"] = selfPurchases
+	}
+	if err := appendOrderEvent(ctx, tx, orderID, models.OrderEventCreated, buyerID, created); err != nil {
+		return nil, err
+	}
+
+	if err := saveAddOns(ctx, tx, orderID, addOns); err != nil {
+		return nil, err
+	}
+	if err := cart.useCoupon(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	subOrderIDs := make(map[string]string, len(sellerIDs))
+	for i, sellerID := range sellerIDs {
+		var subOrderID string
+		t := subOrderTotals[i]
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO sub_orders (order_id, seller_id, status, subtotal_cents, discount_cents, tax_cents, shipping_cents, total_cents)
+			VALUES ($1, $2, 'pending', $3, $4, $5, $6, $7)
+			RETURNING id`,
+			orderID, sellerID, t.Subtotal.Amount, t.Discount.Amount, t.Tax.Amount, t.Shipping.Amount, t.Total.Amount).Scan(&subOrderID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create sub-order: %w", err)
+		}
+		subOrderIDs[sellerID] = subOrderID
+	}
+
+	stockLines := make([]orderLine, len(lines))
+	itemIDs := make([]string, len(lines))
+	for i, line := range lines {
+		subOrderID := subOrderIDs[line.sellerID]
+		var eta string
+		if line.preorder {
+			eta = preorderETA(line.availableFrom.Time)
+		}
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO order_items (order_id, sub_order_id, product_id, quantity, price_cents, total_cents,
+				regular_price_cents, discount_cents, unit_type, preorder, backordered_at, backorder_eta, self_purchase)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CASE WHEN $10 THEN NOW() END, NULLIF($11, '')::date, $12)
+			RETURNING id`,
+			orderID, subOrderID, line.productID, line.quantity, line.price.Amount, line.lineTotal.Amount,
+			line.regularPrice.Amount, discounts[i].Amount, line.rules.unitType, line.preorder, eta, line.sellerID == buyerID).Scan(&itemIDs[i]); err != nil {
+			return nil, fmt.Errorf("failed to create order item: %w", err)
+		}
+		stockLines[i] = line.orderLine
+		stockLines[i].referenceID = subOrderID
+		productIDs = append(productIDs, line.productID)
+	}
+
+	if err := snapshotCommission(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	var backordered map[int]bool
+	categoryIDs, backordered, err = s.inventory.consumeOrderStock(ctx, tx, orderID, buyerID, stockLines, s.holds, input.AllowBackorder)
+	if err != nil {
+		return nil, err
+	}
+	if len(backordered) > 0 {
+		var ids []string
+		for i := range backordered {
+			ids = append(ids, itemIDs[i])
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_items SET backordered_at = NOW(), backorder_eta = CURRENT_DATE + $2::int
+			WHERE id = ANY($1)`, pq.Array(ids), s.backorderETADays); err != nil {
+			return nil, fmt.Errorf("failed to backorder order items: %w", err)
+		}
+	}
+	if err := appendOrderEvent(ctx, tx, orderID, models.OrderEventReserved, buyerID, map[string]int{
+		"items": len(lines) - len(backordered), "backordered": len(backordered),
+	}); err != nil {
+		return nil, err
+	}
+
+	order, err := lockOrder(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.screenOrder(ctx, tx, order, FraudOrder{
+		BuyerID: buyerID, PaymentMethodID: input.PaymentMethodID, ShippingAddress: input.ShippingAddress,
+	}, fraudStageCheckout); err != nil {
+		return nil, err
+	}
+	return &placedOrder{id: orderID, categoryIDs: categoryIDs, productIDs: productIDs}, nil
+}
+
+// Quote prices buyerID's cart the way Create would check it out now, from
+// the same pricing and stock checks, without ordering anything or taking
+// any stock or locks. Lines checkout would reject are marked unavailable
+// with the reason it would give and left out of the totals. With
+// allowBackorder, lines short of stock are marked backordered instead, as
+// checkout would order them; lines on preorder are always marked backordered
+// and preorder. The quote lists every shipping method the cart
+// can ship by, dated for delivery to postalCode, and its totals ship by
+// shippingMethod, standard when empty; when that method can't ship the cart,
+// or checkout would refuse it because a seller is closed, the quote has no
+// shipping method and isn't orderable. Its totals are taxed for
+// input.ShippingAddress as checkout would tax them, and include
+// input.AddOns, which fail the quote as checkout would fail if one can't be
+// ordered. The totals are also itemized as charges summing exactly to them.
+func (s *OrderService) Quote(ctx context.Context, buyerID string, allowBackorder bool, postalCode, shippingMethod string, input models.QuoteInput) (*models.OrderQuote, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	cart, err := priceCart(ctx, tx, buyerID, now, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.selfPurchase == "block" {
+		cart.blockSelfPurchases(buyerID)
+	}
+	if err := cart.checkPurchaseLimits(ctx, tx, buyerID, now, false); err != nil {
+		return nil, err
+	}
+	backordered := make(map[int]bool)
+	if lines, indexes := cart.stockLines(); len(lines) > 0 {
+		_, short, err := planOrderStock(ctx, tx, lines, false, s.holds, buyerID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range short {
+			if allowBackorder {
+				backordered[indexes[i]] = true
+				continue
+			}
+			problem := shortStockError(indexes[i])
+			cart.lines[indexes[i]].problem = &problem
+		}
+	}
+	totals, err := cart.totals()
+	if err != nil {
+		return nil, err
+	}
+	options, err := s.shipping.options(ctx, cart, totals, postalCode)
+	if err != nil {
+		return nil, err
+	}
+	if shippingMethod == "" {
+		shippingMethod = models.ShippingStandard
+	}
+	shipped := true
+	var invalid *validators.ValidationError
+	err = s.shipping.checkSellersOpen(ctx, tx, shippingMethod, totals.sellerIDs, now)
+	if err == nil {
+		err = s.shipping.ship(cart, totals, shippingMethod)
+	}
+	if errors.As(err, &invalid) {
+		shipped = false
+	} else if err != nil {
+		return nil, err
+	}
+	taxes, err := s.taxCart(ctx, tx, buyerID, cart, totals, input.ShippingAddress)
+	if err != nil {
+		return nil, err
+	}
+	addOnLines, err := priceAddOns(ctx, tx, cart.currency, input.AddOns)
+	if err != nil {
+		return nil, err
+	}
+	if err := totals.addAddOns(cart.currency, addOnLines); err != nil {
+		return nil, err
+	}
+
+	quote := &models.OrderQuote{
+		Orderable: shipped, Totals: totals.order, ShippingMethods: options, Items: make([]models.QuoteItem, len(cart.lines)),
+		AddOns: quoteAddOns(addOnLines),
+	}
+	if shipped && len(totals.sellerIDs) > 0 {
+		quote.ShippingMethod = shippingMethod
+	}
+	if cart.coupon != nil {
+		quote.Coupon = cart.coupon.result
+		quote.Orderable = quote.Orderable && quote.Coupon.Valid
+	}
+	for i, line := range cart.lines {
+		item := models.QuoteItem{
+			ProductID: line.productID, SellerID: line.sellerID, UnitType: line.rules.unitType, Quantity: line.quantity,
+			Weight: models.LineWeight(line.rules.unitType, line.quantity), Price: line.price, Available: true,
+			Backorder: backordered[i] || line.preorder, Preorder: line.preorder,
+		}
+		if line.problem != nil {
+			item.Available, item.Reason, item.Message = false, line.problem.Code, line.problem.Message
+			quote.Orderable = false
+		} else {
+			item.TotalPrice = &line.lineTotal
+		}
+		quote.Items[i] = item
+	}
+	quote.SubOrders = make([]models.QuoteSubOrder, len(totals.sellerIDs))
+	for i, sellerID := range totals.sellerIDs {
+		quote.SubOrders[i] = models.QuoteSubOrder{SellerID: sellerID, Totals: totals.sellers[i]}
+	}
+	if quote.Charges, err = quoteCharges(cart.currency, totals, addOnLines, options, quote.ShippingMethod, taxes); err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// giftMarkup and giftBlankLines match the markup and runs of blank lines
+// sanitizeGiftMessage removes
+var (
+	giftMarkup     = regexp.MustCompile(`<[^>]*>`)
+	giftBlankLines = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// sanitizeGiftMessage makes a gift message safe to print on a packing slip.
+// Markup and control and invisible formatting characters other than line
+// breaks are dropped, runs of blank lines collapsed and surrounding space
+// trimmed.
+func sanitizeGiftMessage(message string) string {
+	message = giftMarkup.ReplaceAllString(strings.ReplaceAll(message, "\r\n", "\n"), "")
+	message = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\t':
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, message)
+	return strings.TrimSpace(giftBlankLines.ReplaceAllString(message, "\n\n"))
+}
+
+// sumTotals adds up the totals of an order's sub-orders, all in currency
+func sumTotals(currency string, parts []models.Totals) (models.Totals, error) {
+	subtotals := make([]money.Money, len(parts))
+	discounts := make([]money.Money, len(parts))
+	taxes := make([]money.Money, len(parts))
+	shippings := make([]money.Money, len(parts))
+	for i, part := range parts {
+		subtotals[i], discounts[i], taxes[i], shippings[i] = part.Subtotal, part.Discount, part.Tax, part.Shipping
+	}
+	subtotal, err := money.Sum(currency, subtotals...)
+	if err != nil {
+		return models.Totals{}, fmt.Errorf("failed to total order: %w", err)
+	}
+	discount, err := money.Sum(currency, discounts...)
+	if err != nil {
+		return models.Totals{}, fmt.Errorf("failed to total order: %w", err)
+	}
+	tax, err := money.Sum(currency, taxes...)
+	if err != nil {
+		return models.Totals{}, fmt.Errorf("failed to total order: %w", err)
+	}
+	shipping, err := money.Sum(currency, shippings...)
+	if err != nil {
+		return models.Totals{}, fmt.Errorf("failed to total order: %w", err)
+	}
+	return newTotals(subtotal, discount, tax, shipping)
+}
+
+// newTotals totals subtotal less discount plus tax and shipping
+func newTotals(subtotal, discount, tax, shipping money.Money) (models.Totals, error) {
+	total, err := subtotal.Sub(discount)
+	if err == nil {
+		total, err = total.Add(tax)
+	}
+	if err == nil {
+		total, err = total.Add(shipping)
+	}
+	if err != nil {
+		return models.Totals{}, fmt.Errorf("failed to total order: %w", err)
+	}
+	return models.Totals{Subtotal: subtotal, Discount: discount, Tax: tax, Shipping: shipping, Total: total}, nil
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Commissions
+//
+// The platform takes a commission on each line sold, at the rate in effect
+// for it at checkout: its seller's, else its primary category's, else the
+// default, else none. Checkout snapshots the rate and the commission on the
+// line's total less its discount onto the line, and their sum onto its
+// sub-order, so payouts never move when rates change. Refunds take back
+// the same share of a sub-order's commission as of its total; a seller is
+// paid its total less refunds and that commission.
+
+var (
+	ErrCommissionRateNotFound = errors.New("commission rate not found")
+	// ErrCommissionRateInEffect is returned when deleting a rate that has
+	// already applied; only scheduled rates can be deleted
+	ErrCommissionRateInEffect = errors.New("commission rate has already taken effect")
+)
+
+// commissionRate is the SQL for the commission rate, in basis points, in
+// effect for product p at the start of the transaction
+const commissionRate = `COALESCE(
+	(SELECT cr.rate_bps FROM commission_rates cr
+		WHERE cr.seller_id = p.seller_id AND cr.effective_from <= NOW() ORDER BY cr.effective_from DESC, cr.created_at DESC LIMIT 1),
+	(SELECT cr.rate_bps FROM commission_rates cr
+		WHERE cr.category_id = p.category_id AND cr.effective_from <= NOW() ORDER BY cr.effective_from DESC, cr.created_at DESC LIMIT 1),
+	(SELECT cr.rate_bps FROM commission_rates cr
+		WHERE cr.seller_id IS NULL AND cr.category_id IS NULL AND cr.effective_from <= NOW() ORDER BY cr.effective_from DESC, cr.created_at DESC LIMIT 1),
+	0)`
+
+// subOrderCommission is the SQL for the commission kept on sub-order so:
+// its snapshot less the share refunded, as netCommission works it out
+const subOrderCommission = `(so.commission_cents - CASE WHEN so.total_cents > 0
+	THEN so.commission_cents * so.refunded_cents / so.total_cents ELSE 0 END)`
+
+// netCommission is the commission kept on a sub-order with total and
+// refunded, out of the commission snapshotted at checkout
+func netCommission(commission, total, refunded int64) int64 {
+	if total <= 0 {
+		return commission
+	}
+	return commission - commission*refunded/total
+}
+
+// snapshotCommission records on the lines and sub-orders of order orderID,
+// just created, the commission rates in effect and the commission taken
+func snapshotCommission(ctx context.Context, tx *sql.Tx, orderID string) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE order_items oi SET commission_bps = r.rate,
+			commission_cents = ROUND((oi.total_cents - oi.discount_cents) * r.rate / 10000.0)
+		FROM products p, LATERAL (SELECT `+commissionRate+` AS rate) r
+		WHERE oi.order_id = $1 AND p.id = oi.product_id`, orderID); err != nil {
+		return fmt.Errorf("failed to snapshot item commissions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sub_orders so SET commission_cents = COALESCE(
+			(SELECT SUM(oi.commission_cents) FROM order_items oi WHERE oi.sub_order_id = so.id), 0)
+		WHERE so.order_id = $1`, orderID); err != nil {
+		return fmt.Errorf("failed to snapshot sub-order commissions: %w", err)
+	}
+	return nil
+}
+
+// CommissionService handles commission rates and the payouts they leave
+// sellers
+type CommissionService struct {
+	db *database.PostgresDB
+}
+
+// NewCommissionService creates a new commission service
+func NewCommissionService(db *database.PostgresDB) *CommissionService {
+	return &CommissionService{db: db}
+}
+
+const commissionRateColumns = `cr.id, cr.seller_id, cr.category_id, cr.rate_bps, cr.effective_from,
+	cr.id = (SELECT cur.id FROM commission_rates cur
+		WHERE cur.seller_id IS NOT DISTINCT FROM cr.seller_id AND cur.category_id IS NOT DISTINCT FROM cr.category_id
+			AND cur.effective_from <= NOW()
+		ORDER BY cur.effective_from DESC, cur.created_at DESC LIMIT 1),
+	cr.created_by, cr.created_at`
+
+// ListRates returns a page of the rate history of a seller, a category or
+// the default, latest effective first
+func (s *CommissionService) ListRates(ctx context.Context, filter models.CommissionRateFilter) (*models.CommissionRatePage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+commissionRateColumns+`, COUNT(*) OVER()
+		FROM commission_rates cr
+		WHERE cr.seller_id IS NOT DISTINCT FROM NULLIF($1, '')::uuid AND cr.category_id IS NOT DISTINCT FROM NULLIF($2, '')::uuid
+		ORDER BY cr.effective_from DESC, cr.created_at DESC
+		LIMIT $3 OFFSET $4`, filter.SellerID, filter.CategoryID, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commission rates: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.CommissionRatePage{Rates: []models.CommissionRate{}}
+	for rows.Next() {
+		rate, err := scanCommissionRate(rows, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan commission rate: %w", err)
+		}
+		page.Rates = append(page.Rates, *rate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list commission rates: %w", err)
+	}
+	return page, nil
+}
+
+// SetRate adds a rate to the history of its scope, applying from its
+// effective time on. Rates can be scheduled ahead but not backdated, so
+// the rate each order was charged stays the one in the history.
+func (s *CommissionService) SetRate(ctx context.Context, adminID string, input models.CommissionRateInput) (*models.CommissionRate, error) {
+	now := time.Now()
+	effectiveFrom := now
+	if input.EffectiveFrom != nil {
+		if input.EffectiveFrom.Before(now.Add(-time.Minute)) {
+			return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: "effectiveFrom", Code: "future", Message: "effectiveFrom must not be in the past",
+			}}}
+		}
+		effectiveFrom = *input.EffectiveFrom
+	}
+
+	if input.SellerID != "" {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM users u WHERE u.id = $1 AND `+isSeller+`)`, input.SellerID).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to get seller: %w", err)
+		}
+		if !exists {
+			return nil, ErrSellerNotFound
+		}
+	}
+	if input.CategoryID != "" {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, input.CategoryID).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to get category: %w", err)
+		}
+		if !exists {
+			return nil, ErrCategoryNotFound
+		}
+	}
+
+	var id string
+	if err := s.db.QueryRowContext(ctx, `
+		INSERT INTO commission_rates (seller_id, category_id, rate_bps, effective_from, created_by)
+		VALUES (NULLIF($1, '')::uuid, NULLIF($2, '')::uuid, $3, $4, $5)
+		RETURNING id`, input.SellerID, input.CategoryID, *input.RateBps, effectiveFrom, adminID).Scan(&id); err != nil {
+		return nil, fmt.Errorf("failed to create commission rate: %w", err)
+	}
+	return s.getRate(ctx, id)
+}
+
+// DeleteRate deletes a scheduled rate. Rates that have taken effect are
+// kept, as orders may have been charged them.
+func (s *CommissionService) DeleteRate(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM commission_rates WHERE id = $1 AND effective_from > NOW()`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete commission rate: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+	if _, err := s.getRate(ctx, id); err != nil {
+		return err
+	}
+	return ErrCommissionRateInEffect
+}
+
+// PayoutSummary breaks seller sellerID's sub-orders down by currency and
+// payout status into gross, commission and net, from the commission
+// snapshotted on each
+func (s *CommissionService) PayoutSummary(ctx context.Context, sellerID string) (*models.PayoutSummary, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(o.currency, 'USD'), so.payout_status, COUNT(*),
+			COALESCE(SUM(so.total_cents - so.refunded_cents), 0), COALESCE(SUM(`+subOrderCommission+`), 0)
+		FROM sub_orders so
+		JOIN orders o ON o.id = so.order_id
+		WHERE so.seller_id = $1
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, sellerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payout summary: %w", err)
+	}
+	defer rows.Close()
+
+	summary := &models.PayoutSummary{SellerID: sellerID, Payouts: []models.PayoutTotals{}}
+	for rows.Next() {
+		var t models.PayoutTotals
+		var gross, commission int64
+		if err := rows.Scan(&t.Currency, &t.Status, &t.SubOrders, &gross, &commission); err != nil {
+			return nil, fmt.Errorf("failed to scan payout summary: %w", err)
+		}
+		t.Gross, t.Commission = money.New(gross, t.Currency), money.New(commission, t.Currency)
+		t.Net = money.New(gross-commission, t.Currency)
+		summary.Payouts = append(summary.Payouts, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get payout summary: %w", err)
+	}
+	return summary, nil
+}
+
+func (s *CommissionService) getRate(ctx context.Context, id string) (*models.CommissionRate, error) {
+	rate, err := scanCommissionRate(s.db.QueryRowContext(ctx, `
+		SELECT `+commissionRateColumns+` FROM commission_rates cr WHERE cr.id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommissionRateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commission rate: %w", err)
+	}
+	return rate, nil
+}
+
+// scanCommissionRate scans a row selected with commissionRateColumns,
+// followed by any extra destinations for additional selected columns
+func scanCommissionRate(row rowScanner, extra ...interface{}) (*models.CommissionRate, error) {
+	var r models.CommissionRate
+	var inEffect sql.NullBool
+	dest := []interface{}{&r.ID, &r.SellerID, &r.CategoryID, &r.RateBps, &r.EffectiveFrom, &inEffect, &r.CreatedBy, &r.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	r.InEffect = inEffect.Bool
+	return &r, nil
+}
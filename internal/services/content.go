@@ -0,0 +1,390 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Content blocks
+//
+// Merchandising manages banners and similar content without a deploy. Each
+// block fills the slot named by its key, in one locale, for a window of
+// time. Which block is shown is worked out from the windows at each request,
+// so scheduled blocks come and go on time, whatever was cached; the cache
+// holds every block for a key that hasn't ended, and is dropped when one is
+// written. Payloads are checked against their key's schema, so the frontend
+// can rely on their shape; keys without a schema can't be used.
+
+// ErrContentBlockNotFound is returned when a content block does not exist,
+// or when no block for a key is showing
+var ErrContentBlockNotFound = errors.New("content block not found")
+
+// Kinds of content payload field
+const (
+	contentText  = "text"
+	contentURL   = "url"
+	contentColor = "color"
+	contentBool  = "bool"
+)
+
+// contentField describes a field of a content payload
+type contentField struct {
+	kind     string
+	required bool
+	max      int // longest text, in characters
+}
+
+// contentSchemas are the payload fields of each content key. Payloads may
+// have no other fields.
+var contentSchemas = map[string]map[string]contentField{
+	"homepage_hero": {
+		"title":    {kind: contentText, required: true, max: 120},
+		"subtitle": {kind: contentText, max: 240},
+		"imageUrl": {kind: contentURL, required: true},
+		"linkUrl":  {kind: contentURL},
+		"ctaLabel": {kind: contentText, max: 40},
+	},
+	"promo_banner": {
+		"imageUrl": {kind: contentURL, required: true},
+		"altText":  {kind: contentText, required: true, max: 200},
+		"linkUrl":  {kind: contentURL, required: true},
+		"title":    {kind: contentText, max: 120},
+	},
+	"announcement_bar": {
+		"message":         {kind: contentText, required: true, max: 200},
+		"linkUrl":         {kind: contentURL},
+		"backgroundColor": {kind: contentColor},
+		"dismissible":     {kind: contentBool},
+	},
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+const contentBlockColumns = `id, key, locale, starts_at, ends_at, payload, created_at, updated_at`
+
+// ContentService handles merchandising content blocks
+type ContentService struct {
+	db    *database.PostgresDB
+	cache *cache.Cache
+}
+
+// NewContentService creates a new content service
+func NewContentService(db *database.PostgresDB, cache *cache.Cache) *ContentService {
+	return &ContentService{db: db, cache: cache}
+}
+
+// Active returns the block for key showing now in the first of locales
+// that has one, falling back to the default locale. Locales are language
+// tags, most preferred first; a tag with no block matches its primary
+// language, so fr-CA gets fr.
+func (s *ContentService) Active(ctx context.Context, key string, locales []string) (*models.ContentBlock, error) {
+	if _, ok := contentSchemas[key]; !ok {
+		return nil, ErrContentBlockNotFound
+	}
+	var blocks []models.ContentBlock
+	err := s.cache.GetOrSet(ctx, config.CacheContent, key, []string{contentTag(key)}, &blocks, func(ctx context.Context) (interface{}, error) {
+		return s.unended(ctx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks are in the order they win in: the latest start first
+	now := time.Now()
+	showing := make(map[string]*models.ContentBlock)
+	for i := range blocks {
+		if b := &blocks[i]; b.ActiveAt(now) && showing[b.Locale] == nil {
+			showing[b.Locale] = b
+		}
+	}
+	for _, locale := range locales {
+		if b, ok := showing[locale]; ok {
+			return b, nil
+		}
+		primary, _, _ := strings.Cut(locale, "-")
+		if b, ok := showing[primary]; ok {
+			return b, nil
+		}
+	}
+	if b, ok := showing[utils.DefaultLocale]; ok {
+		return b, nil
+	}
+	return nil, ErrContentBlockNotFound
+}
+
+// unended returns key's blocks that haven't ended, the latest start first
+func (s *ContentService) unended(ctx context.Context, key string) ([]models.ContentBlock, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+contentBlockColumns+`
+		FROM content_blocks
+		WHERE key = $1 AND (ends_at IS NULL OR ends_at > NOW())
+		ORDER BY starts_at DESC NULLS LAST, created_at DESC, id`, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get content blocks: %w", err)
+	}
+	defer rows.Close()
+	return scanContentBlocks(rows)
+}
+
+// List returns the content blocks, only those for key when it is given, by
+// key and locale and the latest start first
+func (s *ContentService) List(ctx context.Context, key string) ([]models.ContentBlock, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+contentBlockColumns+`
+		FROM content_blocks
+		WHERE $1 = '' OR key = $1
+		ORDER BY key, locale, starts_at DESC NULLS LAST, created_at DESC, id`, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list content blocks: %w", err)
+	}
+	defer rows.Close()
+	return scanContentBlocks(rows)
+}
+
+// Get returns a content block by ID
+func (s *ContentService) Get(ctx context.Context, id string) (*models.ContentBlock, error) {
+	b, err := scanContentBlock(s.db.QueryRowContext(ctx, `SELECT `+contentBlockColumns+` FROM content_blocks WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrContentBlockNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get content block: %w", err)
+	}
+	return b, nil
+}
+
+// Create creates a content block. It fails with a
+// *validators.ValidationError when the key has no schema, the payload
+// doesn't match it, or the block would end before it starts.
+func (s *ContentService) Create(ctx context.Context, adminID string, input models.ContentBlockInput) (*models.ContentBlock, error) {
+	payload, err := checkContentBlockInput(&input)
+	if err != nil {
+		return nil, err
+	}
+	b, err := scanContentBlock(s.db.QueryRowContext(ctx, `
+		INSERT INTO content_blocks (key, locale, starts_at, ends_at, payload, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+contentBlockColumns,
+		input.Key, input.Locale, input.StartsAt, input.EndsAt, string(payload), adminID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create content block: %w", err)
+	}
+	s.invalidate(ctx, b.Key)
+	return b, nil
+}
+
+// Update replaces a content block, checked as by Create
+func (s *ContentService) Update(ctx context.Context, id string, input models.ContentBlockInput) (*models.ContentBlock, error) {
+	payload, err := checkContentBlockInput(&input)
+	if err != nil {
+		return nil, err
+	}
+	var previousKey string
+	b, err := scanContentBlock(s.db.QueryRowContext(ctx, `
+		UPDATE content_blocks c SET key = $2, locale = $3, starts_at = $4, ends_at = $5, payload = $6
+		FROM (SELECT key FROM content_blocks WHERE id = $1) previous
+		WHERE c.id = $1
+		RETURNING c.id, c.key, c.locale, c.starts_at, c.ends_at, c.payload, c.created_at, c.updated_at, previous.key`,
+		id, input.Key, input.Locale, input.StartsAt, input.EndsAt, string(payload)), &previousKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrContentBlockNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update content block: %w", err)
+	}
+	s.invalidate(ctx, previousKey, b.Key)
+	return b, nil
+}
+
+// Delete removes a content block
+func (s *ContentService) Delete(ctx context.Context, id string) error {
+	var key string
+	err := s.db.QueryRowContext(ctx, `DELETE FROM content_blocks WHERE id = $1 RETURNING key`, id).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrContentBlockNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete content block: %w", err)
+	}
+	s.invalidate(ctx, key)
+	return nil
+}
+
+// invalidate drops the cached blocks of keys so changes show at once
+func (s *ContentService) invalidate(ctx context.Context, keys ...string) {
+	tags := make([]string, len(keys))
+	for i, key := range keys {
+		tags[i] = contentTag(key)
+	}
+	if err := s.cache.InvalidateTags(ctx, tags...); err != nil {
+		log.Warn().Err(err).Strs("tags", tags).Msg("Failed to invalidate content blocks")
+	}
+}
+
+func contentTag(key string) string {
+	return "content:" + key
+}
+
+// checkContentBlockInput normalizes input's locale and checks it against
+// its key's schema, returning the payload compacted for storage
+func checkContentBlockInput(input *models.ContentBlockInput) ([]byte, error) {
+	input.Locale = strings.ToLower(input.Locale)
+	var invalid []validators.FieldError
+	if input.StartsAt != nil && input.EndsAt != nil && !input.EndsAt.After(*input.StartsAt) {
+		invalid = append(invalid, validators.FieldError{
+			Field: "endsAt", Code: "gtfield", Param: "startsAt", Message: "endsAt must be after startsAt",
+		})
+	}
+	schema, ok := contentSchemas[input.Key]
+	if !ok {
+		keys := make([]string, 0, len(contentSchemas))
+		for key := range contentSchemas {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		invalid = append(invalid, validators.FieldError{
+			Field: "key", Code: "oneof", Param: strings.Join(keys, " "),
+			Message: "key must be one of " + strings.Join(keys, ", "),
+		})
+		return nil, &validators.ValidationError{Fields: invalid}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(input.Payload, &fields); err != nil || fields == nil {
+		invalid = append(invalid, validators.FieldError{
+			Field: "payload", Code: "object", Message: "payload must be a JSON object",
+		})
+		return nil, &validators.ValidationError{Fields: invalid}
+	}
+	invalid = append(invalid, checkContentPayload(schema, fields)...)
+	if len(invalid) > 0 {
+		return nil, &validators.ValidationError{Fields: invalid}
+	}
+
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, input.Payload); err != nil {
+		return nil, fmt.Errorf("failed to compact content payload: %w", err)
+	}
+	return payload.Bytes(), nil
+}
+
+// checkContentPayload checks a payload's fields against schema, in field
+// order so the errors are the same every time
+func checkContentPayload(schema map[string]contentField, fields map[string]json.RawMessage) []validators.FieldError {
+	names := make([]string, 0, len(schema)+len(fields))
+	for name := range schema {
+		names = append(names, name)
+	}
+	for name := range fields {
+		if _, ok := schema[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var invalid []validators.FieldError
+	for _, name := range names {
+		field, known := schema[name]
+		raw, present := fields[name]
+		path := "payload." + name
+		switch {
+		case !known:
+			invalid = append(invalid, validators.FieldError{Field: path, Code: "unknown", Message: path + " is not a field of this content"})
+			continue
+		case !present || string(raw) == "null":
+			if field.required {
+				invalid = append(invalid, validators.FieldError{Field: path, Code: "required", Message: path + " is required"})
+			}
+			continue
+		}
+
+		if field.kind == contentBool {
+			var v bool
+			if json.Unmarshal(raw, &v) != nil {
+				invalid = append(invalid, validators.FieldError{Field: path, Code: "boolean", Message: path + " must be true or false"})
+			}
+			continue
+		}
+		var v string
+		if json.Unmarshal(raw, &v) != nil {
+			invalid = append(invalid, validators.FieldError{Field: path, Code: "string", Message: path + " must be a string"})
+			continue
+		}
+		switch {
+		case field.required && strings.TrimSpace(v) == "":
+			invalid = append(invalid, validators.FieldError{Field: path, Code: "required", Message: path + " is required"})
+		case field.kind == contentText && field.max > 0 && utf8.RuneCountInString(v) > field.max:
+			invalid = append(invalid, validators.FieldError{
+				Field: path, Code: "max", Param: fmt.Sprint(field.max),
+				Message: fmt.Sprintf("%s must be at most %d characters", path, field.max),
+			})
+		case field.kind == contentURL && v != "" && !isContentURL(v):
+			invalid = append(invalid, validators.FieldError{
+				Field: path, Code: "url", Message: path + " must be an http or https URL, or a path starting with /",
+			})
+		case field.kind == contentColor && v != "" && !hexColorPattern.MatchString(v):
+			invalid = append(invalid, validators.FieldError{
+				Field: path, Code: "hexcolor", Message: path + " must be a color such as #1a7f37",
+			})
+		}
+	}
+	return invalid
+}
+
+// isContentURL reports whether raw is an absolute http or https URL, or a
+// path on this site
+func isContentURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		return strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//")
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func scanContentBlock(row rowScanner, extra ...interface{}) (*models.ContentBlock, error) {
+	var b models.ContentBlock
+	var payload []byte
+	dest := []interface{}{&b.ID, &b.Key, &b.Locale, &b.StartsAt, &b.EndsAt, &payload, &b.CreatedAt, &b.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	b.Payload = payload
+	return &b, nil
+}
+
+func scanContentBlocks(rows *sql.Rows) ([]models.ContentBlock, error) {
+	blocks := []models.ContentBlock{}
+	for rows.Next() {
+		b, err := scanContentBlock(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan content block: %w", err)
+		}
+		blocks = append(blocks, *b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get content blocks: %w", err)
+	}
+	return blocks, nil
+}
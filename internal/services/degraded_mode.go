@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+const (
+	degradedModeKey = "degraded_mode"
+	// degradedModeRefresh bounds how long a replica serves a stale state, so
+	// flagged routes don't read Redis on every request
+	degradedModeRefresh = 2 * time.Second
+)
+
+// DegradedModeService manages degraded mode, which sheds load during
+// incidents by switching off non-essential features. The configured state
+// applies unless an admin override is stored in Redis.
+type DegradedModeService struct {
+	redis    *database.RedisClient
+	defaults config.DegradedConfig
+
+	mu        sync.Mutex
+	cached    models.DegradedMode
+	refreshAt time.Time
+}
+
+// NewDegradedModeService creates a new degraded mode service
+func NewDegradedModeService(redis *database.RedisClient, defaults config.DegradedConfig) *DegradedModeService {
+	return &DegradedModeService{redis: redis, defaults: defaults}
+}
+
+// Get returns the current degraded mode state
+func (s *DegradedModeService) Get(ctx context.Context) (*models.DegradedMode, error) {
+	data, err := s.redis.Get(ctx, degradedModeKey)
+	if err == redis.Nil {
+		return s.configured(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get degraded mode: %w", err)
+	}
+
+	var mode models.DegradedMode
+	if err := json.Unmarshal([]byte(data), &mode); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal degraded mode: %w", err)
+	}
+	return &mode, nil
+}
+
+// Set overrides the configured state for all replicas
+func (s *DegradedModeService) Set(ctx context.Context, input models.DegradedModeInput, updatedBy string) (*models.DegradedMode, error) {
+	now := time.Now()
+	mode := &models.DegradedMode{
+		Enabled:    input.Enabled,
+		Message:    input.Message,
+		RetryAfter: input.RetryAfter,
+		Source:     "admin",
+		UpdatedBy:  updatedBy,
+		UpdatedAt:  &now,
+	}
+	if mode.Message == "" {
+		mode.Message = s.defaults.Message
+	}
+
+	data, err := json.Marshal(mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal degraded mode: %w", err)
+	}
+	if err := s.redis.Set(ctx, degradedModeKey, string(data)); err != nil {
+		return nil, fmt.Errorf("failed to set degraded mode: %w", err)
+	}
+	s.invalidate()
+	return mode, nil
+}
+
+// Reset removes the admin override, restoring the configured state
+func (s *DegradedModeService) Reset(ctx context.Context) (*models.DegradedMode, error) {
+	if err := s.redis.Delete(ctx, degradedModeKey); err != nil {
+		return nil, fmt.Errorf("failed to reset degraded mode: %w", err)
+	}
+	s.invalidate()
+	return s.configured(), nil
+}
+
+// Degraded reports whether degraded mode is on, with the message and retry
+// delay for rejected requests. It serves a briefly cached state and falls
+// back to the last known (or configured) state when Redis is unavailable.
+func (s *DegradedModeService) Degraded(ctx context.Context) (bool, string, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().After(s.refreshAt) {
+		mode, err := s.Get(ctx)
+		switch {
+		case err == nil:
+			s.cached = *mode
+		case s.refreshAt.IsZero():
+			log.Warn().Err(err).Msg("Failed to load degraded mode, using configured state")
+			s.cached = *s.configured()
+		default:
+			log.Warn().Err(err).Msg("Failed to refresh degraded mode, using last known state")
+		}
+		s.refreshAt = time.Now().Add(degradedModeRefresh)
+	}
+	return s.cached.Enabled, s.cached.Message, time.Duration(s.cached.RetryAfter) * time.Second
+}
+
+func (s *DegradedModeService) invalidate() {
+	s.mu.Lock()
+	s.refreshAt = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *DegradedModeService) configured() *models.DegradedMode {
+	return &models.DegradedMode{
+		Enabled:    s.defaults.Enabled,
+		Message:    s.defaults.Message,
+		RetryAfter: s.defaults.RetryAfter,
+		Source:     "config",
+	}
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Delivery estimates
+//
+// A product leaves its warehouse after its processing days and then takes
+// the transit days from the warehouse to the zone of the destination postal
+// code, all in business days. Products in a cart ship from each warehouse
+// together, as soon as the slowest of them is ready. Estimates never fail on
+// the destination: postal codes without a zone, and zones a warehouse has no
+// transit time to, get the configured default transit days.
+
+// DeliveryService estimates delivery dates
+type DeliveryService struct {
+	db    *database.PostgresDB
+	cache *cache.Cache
+	cfg   config.DeliveryConfig
+}
+
+// NewDeliveryService creates a new delivery service
+func NewDeliveryService(db *database.PostgresDB, cache *cache.Cache, cfg config.DeliveryConfig) *DeliveryService {
+	return &DeliveryService{db: db, cache: cache, cfg: cfg}
+}
+
+// deliveryLine is a product to be delivered
+type deliveryLine struct {
+	productID      string
+	warehouse      string
+	processingDays int
+}
+
+// deliveryTransit is the transit time from a warehouse to a zone
+type deliveryTransit struct {
+	MinDays int  `json:"minDays"`
+	MaxDays int  `json:"maxDays"`
+	Default bool `json:"default"`
+}
+
+// EstimateProduct estimates when a listed product ordered now would arrive
+// at postalCode
+func (s *DeliveryService) EstimateProduct(ctx context.Context, productID, postalCode string) (*models.DeliveryEstimate, error) {
+	line := deliveryLine{productID: productID}
+	var processingDays sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(p.warehouse, ''), p.processing_days FROM products p
+		WHERE p.id = $1 AND p.deleted_at IS NULL AND COALESCE(p.is_active, true)`, productID).Scan(
+		&line.warehouse, &processingDays)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	s.applyDefaults(&line, processingDays)
+	return s.estimate(ctx, postalCode, []deliveryLine{line}, nil), nil
+}
+
+// EstimateCart estimates when a user's cart checked out now would arrive at
+// postalCode
+func (s *DeliveryService) EstimateCart(ctx context.Context, userID, postalCode string) (*models.DeliveryEstimate, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, COALESCE(p.warehouse, ''), p.processing_days
+		FROM cart c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.user_id = $1
+		ORDER BY c.created_at, p.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []deliveryLine
+	for rows.Next() {
+		var line deliveryLine
+		var processingDays sql.NullInt64
+		if err := rows.Scan(&line.productID, &line.warehouse, &processingDays); err != nil {
+			return nil, fmt.Errorf("failed to scan cart item: %w", err)
+		}
+		s.applyDefaults(&line, processingDays)
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+	if len(lines) == 0 {
+		return nil, ErrEmptyCart
+	}
+	return s.estimate(ctx, postalCode, lines, nil), nil
+}
+
+// applyDefaults fills in the default warehouse and processing days of a line
+// whose product has none
+func (s *DeliveryService) applyDefaults(line *deliveryLine, processingDays sql.NullInt64) {
+	if line.warehouse == "" {
+		line.warehouse = s.cfg.DefaultWarehouse
+	}
+	line.processingDays = s.cfg.DefaultProcessingDays
+	if processingDays.Valid {
+		line.processingDays = int(processingDays.Int64)
+	}
+}
+
+// estimate estimates the delivery of lines to postalCode, one shipment per
+// warehouse, counting from today. Shipments take the transit time from
+// their warehouse to the postal code's zone, or fixed when it is set.
+func (s *DeliveryService) estimate(ctx context.Context, postalCode string, lines []deliveryLine, fixed *deliveryTransit) *models.DeliveryEstimate {
+	postalCode = normalizePostalCode(postalCode)
+	zone := s.zone(ctx, postalCode)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	var shipments []*models.ShipmentEstimate
+	byWarehouse := make(map[string]*models.ShipmentEstimate)
+	for _, line := range lines {
+		shipment, ok := byWarehouse[line.warehouse]
+		if !ok {
+			shipment = &models.ShipmentEstimate{Warehouse: line.warehouse, Zone: zone}
+			byWarehouse[line.warehouse] = shipment
+			shipments = append(shipments, shipment)
+		}
+		shipment.ProductIDs = append(shipment.ProductIDs, line.productID)
+		shipment.ProcessingDays = max(shipment.ProcessingDays, line.processingDays)
+	}
+
+	estimate := &models.DeliveryEstimate{PostalCode: postalCode, Shipments: make([]models.ShipmentEstimate, len(shipments))}
+	var earliest, latest time.Time
+	for i, shipment := range shipments {
+		var transit deliveryTransit
+		if fixed != nil {
+			transit = *fixed
+		} else {
+			transit = s.transit(ctx, shipment.Warehouse, zone)
+		}
+		shipmentEarliest := addBusinessDays(today, shipment.ProcessingDays+transit.MinDays)
+		shipmentLatest := addBusinessDays(today, shipment.ProcessingDays+transit.MaxDays)
+		shipment.EarliestDate = shipmentEarliest.Format(time.DateOnly)
+		shipment.LatestDate = shipmentLatest.Format(time.DateOnly)
+		shipment.IsDefault = transit.Default
+		estimate.Shipments[i] = *shipment
+
+		if shipmentEarliest.After(earliest) {
+			earliest = shipmentEarliest
+		}
+		if shipmentLatest.After(latest) {
+			latest = shipmentLatest
+		}
+		estimate.IsDefault = estimate.IsDefault || transit.Default
+	}
+	estimate.EarliestDate = earliest.Format(time.DateOnly)
+	estimate.LatestDate = latest.Format(time.DateOnly)
+	return estimate
+}
+
+// zone returns the zone of postalCode's longest matching prefix, or "" when
+// it has none or can't be looked up
+func (s *DeliveryService) zone(ctx context.Context, postalCode string) string {
+	if postalCode == "" {
+		return ""
+	}
+	var zone string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT zone FROM delivery_zones
+		WHERE left($1, length(postal_prefix)) = postal_prefix
+		ORDER BY length(postal_prefix) DESC LIMIT 1`, postalCode).Scan(&zone)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Warn().Err(err).Msg("Failed to resolve delivery zone, using the default estimate")
+	}
+	return zone
+}
+
+// transit returns the transit time from warehouse to zone, cached per
+// warehouse and zone, or the default transit time when there is none or it
+// can't be looked up
+func (s *DeliveryService) transit(ctx context.Context, warehouse, zone string) deliveryTransit {
+	fallback := deliveryTransit{MinDays: s.cfg.DefaultMinDays, MaxDays: s.cfg.DefaultMaxDays, Default: true}
+	if zone == "" {
+		return fallback
+	}
+
+	var transit deliveryTransit
+	ttl := time.Duration(s.cfg.CacheTTL) * time.Second
+	err := s.cache.GetOrSetWithTTL(ctx, "delivery_transit", warehouse+":"+zone, ttl, nil, &transit, func(ctx context.Context) (interface{}, error) {
+		t := deliveryTransit{}
+		err := s.db.QueryRowContext(ctx, `
+			SELECT min_days, max_days FROM delivery_transit WHERE warehouse = $1 AND zone = $2`,
+			warehouse, zone).Scan(&t.MinDays, &t.MaxDays)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fallback, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transit time: %w", err)
+		}
+		return t, nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("warehouse", warehouse).Str("zone", zone).Msg("Failed to get transit time, using the default estimate")
+		return fallback
+	}
+	return transit
+}
+
+// checkWarehouse checks that code, if set, is a known warehouse
+func checkWarehouse(ctx context.Context, tx *sql.Tx, code string) error {
+	if code == "" {
+		return nil
+	}
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM warehouses WHERE code = $1)`, code).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check warehouse: %w", err)
+	}
+	if !exists {
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "warehouse", Code: "unknown", Param: code, Message: "warehouse is not a known warehouse",
+		}}}
+	}
+	return nil
+}
+
+// normalizePostalCode uppercases postalCode and drops its spaces and dashes
+func normalizePostalCode(postalCode string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(postalCode)))
+}
+
+// addBusinessDays returns the date n business days after t, skipping weekends
+func addBusinessDays(t time.Time, n int) time.Time {
+	for n > 0 {
+		t = t.AddDate(0, 0, 1)
+		if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+			n--
+		}
+	}
+	return t
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+)
+
+// Account email purposes, each limited separately
+const (
+	EmailPurposeVerification  = "verification"
+	EmailPurposePasswordReset = "password_reset"
+	EmailPurposeTwoFactor     = "two_factor"
+)
+
+// EmailThrottledError is returned when an account email may not be sent yet
+type EmailThrottledError struct {
+	Purpose    string
+	RetryAfter time.Duration // until the email may be sent
+}
+
+func (e *EmailThrottledError) Error() string {
+	return fmt.Sprintf("%s email sent too recently, retry in %s", e.Purpose, e.RetryAfter.Round(time.Second))
+}
+
+// emailThrottleScript enforces the cooldown in KEYS[1] and a sliding hour of
+// sends in the sorted set KEYS[2] together, on the Redis server clock, so a
+// send denied by either limit counts against neither. Returns
+// {allowed, retry_after_ms}.
+var emailThrottleScript = redis.NewScript(`
+local cooldown = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local member = ARGV[4]
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local wait = redis.call('PTTL', KEYS[1])
+if wait > 0 then
+	return {0, wait}
+end
+
+if limit > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], 0, now - window)
+	if redis.call('ZCARD', KEYS[2]) >= limit then
+		local oldest = redis.call('ZRANGE', KEYS[2], 0, 0, 'WITHSCORES')
+		return {0, window - (now - tonumber(oldest[2]))}
+	end
+	redis.call('ZADD', KEYS[2], now, member)
+	redis.call('PEXPIRE', KEYS[2], window)
+end
+if cooldown > 0 then
+	redis.call('SET', KEYS[1], 1, 'PX', cooldown)
+end
+return {1, 0}
+`)
+
+// EmailThrottle limits how often account emails are sent to each user, per
+// purpose, whichever endpoint asks for them. Limits are shared by all
+// replicas through Redis; when Redis is unavailable emails are let through.
+type EmailThrottle struct {
+	redis    *database.RedisClient
+	cfg      config.EmailResendConfig
+	degraded atomic.Bool
+}
+
+// NewEmailThrottle creates a new email throttle
+func NewEmailThrottle(redis *database.RedisClient, cfg config.EmailResendConfig) *EmailThrottle {
+	return &EmailThrottle{redis: redis, cfg: cfg}
+}
+
+// Allow records a send of a purpose email to userID, or returns an
+// *EmailThrottledError when the purpose's cooldown or hourly limit would be
+// exceeded. Users with one of the exempt roles are not limited, nor are
+// purposes without configured limits. Call it right before sending.
+func (t *EmailThrottle) Allow(ctx context.Context, userID, role, purpose string) error {
+	limit, ok := t.cfg.Limits[purpose]
+	if !ok || (limit.Cooldown == 0 && limit.HourlyLimit == 0) || slices.Contains(t.cfg.ExemptRoles, role) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	prefix := fmt.Sprintf("email_throttle:%s:%s", purpose, userID)
+	res, err := emailThrottleScript.Run(ctx, t.redis.Client, []string{prefix + ":cooldown", prefix + ":sent"},
+		limit.Cooldown*1000, limit.HourlyLimit, time.Hour.Milliseconds(), uuid.NewString()).Int64Slice()
+	if err == nil && len(res) != 2 {
+		err = fmt.Errorf("unexpected email throttle script result: %v", res)
+	}
+	if err != nil {
+		if t.degraded.CompareAndSwap(false, true) {
+			log.Warn().Err(err).Msg("Email throttle cannot reach Redis, allowing all emails")
+		}
+		return nil
+	}
+	if t.degraded.CompareAndSwap(true, false) {
+		log.Info().Msg("Email throttle reconnected to Redis, limits enforced again")
+	}
+
+	if res[0] != 1 {
+		return &EmailThrottledError{Purpose: purpose, RetryAfter: time.Duration(res[1]) * time.Millisecond}
+	}
+	return nil
+}
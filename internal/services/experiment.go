@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// ControlVariant is served when an experiment is disabled, unknown, or the
+// user is anonymous
+const ControlVariant = "control"
+
+// exposureDedupeTTL limits exposure events to one per user per experiment per day
+const exposureDedupeTTL = 24 * time.Hour
+
+// ExperimentService handles A/B experiment bucketing and event tracking
+type ExperimentService struct {
+	db          *database.PostgresDB
+	redis       *database.RedisClient
+	experiments map[string]config.ExperimentConfig
+}
+
+// NewExperimentService creates a new experiment service
+func NewExperimentService(db *database.PostgresDB, redis *database.RedisClient, experiments map[string]config.ExperimentConfig) *ExperimentService {
+	return &ExperimentService{db: db, redis: redis, experiments: experiments}
+}
+
+// Variant returns the variant userID is bucketed into for experiment and
+// records an exposure event. Bucketing hashes the experiment and user ID, so
+// the same user always lands in the same variant for a given traffic split.
+func (s *ExperimentService) Variant(ctx context.Context, experiment, userID string) string {
+	variant, active := s.assign(experiment, userID)
+	if active {
+		s.recordExposure(experiment, variant, userID)
+	}
+	return variant
+}
+
+// RecordConversion records a conversion event for userID against the variant
+// they are bucketed into. Conversions for inactive experiments are ignored.
+func (s *ExperimentService) RecordConversion(ctx context.Context, experiment, userID, event string) error {
+	variant, active := s.assign(experiment, userID)
+	if !active {
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO experiment_events (experiment, variant, user_id, event_type, event_name)
+		VALUES ($1, $2, $3, 'conversion', $4)`,
+		experiment, variant, userID, event)
+	if err != nil {
+		return fmt.Errorf("failed to record conversion: %w", err)
+	}
+	return nil
+}
+
+// Results summarizes exposures and conversions per variant since the given time
+func (s *ExperimentService) Results(ctx context.Context, experiment string, since time.Time) (*models.ExperimentResults, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT variant,
+			COUNT(DISTINCT user_id) FILTER (WHERE event_type = 'exposure'),
+			COUNT(DISTINCT user_id) FILTER (WHERE event_type = 'conversion'),
+			COUNT(*) FILTER (WHERE event_type = 'conversion')
+		FROM experiment_events
+		WHERE experiment = $1 AND created_at >= $2
+		GROUP BY variant
+		ORDER BY variant`, experiment, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query experiment results: %w", err)
+	}
+	defer rows.Close()
+
+	results := &models.ExperimentResults{Experiment: experiment, Since: since, Variants: []models.VariantResult{}}
+	for rows.Next() {
+		var v models.VariantResult
+		if err := rows.Scan(&v.Variant, &v.ExposedUsers, &v.ConvertedUsers, &v.Conversions); err != nil {
+			return nil, fmt.Errorf("failed to scan experiment results: %w", err)
+		}
+		if v.ExposedUsers > 0 {
+			v.ConversionRate = float64(v.ConvertedUsers) / float64(v.ExposedUsers)
+		}
+		results.Variants = append(results.Variants, v)
+	}
+	return results, rows.Err()
+}
+
+// assign buckets userID into a variant of experiment, reporting whether the
+// experiment is active for the user
+func (s *ExperimentService) assign(experiment, userID string) (string, bool) {
+	exp, ok := s.experiments[experiment]
+	if !ok || !exp.Enabled || userID == "" {
+		return ControlVariant, false
+	}
+
+	total := 0
+	for _, v := range exp.Variants {
+		if v.Weight > 0 {
+			total += v.Weight
+		}
+	}
+	if total == 0 {
+		return ControlVariant, false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(experiment + ":" + userID))
+	bucket := int(h.Sum32() % uint32(total))
+	for _, v := range exp.Variants {
+		if v.Weight <= 0 {
+			continue
+		}
+		if bucket < v.Weight {
+			return v.Name, true
+		}
+		bucket -= v.Weight
+	}
+	return ControlVariant, false
+}
+
+// recordExposure writes an exposure event in the background, at most once per
+// user and experiment within exposureDedupeTTL
+func (s *ExperimentService) recordExposure(experiment, variant, userID string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		key := fmt.Sprintf("experiment_exposure:%s:%s", experiment, userID)
+		first, err := s.redis.SetNX(ctx, key, variant, exposureDedupeTTL).Result()
+		if err != nil {
+			log.Warn().Err(err).Str("experiment", experiment).Msg("Failed to dedupe experiment exposure")
+		} else if !first {
+			return
+		}
+
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO experiment_events (experiment, variant, user_id, event_type)
+			VALUES ($1, $2, $3, 'exposure')`,
+			experiment, variant, userID); err != nil {
+			log.Warn().Err(err).Str("experiment", experiment).Msg("Failed to record experiment exposure")
+		}
+	}()
+}
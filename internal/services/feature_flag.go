@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+var (
+	// ErrFeatureFlagNotFound is returned when a feature flag does not exist
+	ErrFeatureFlagNotFound = errors.New("feature flag not found")
+	// ErrFeatureFlagExists is returned when creating a flag whose key is taken
+	ErrFeatureFlagExists = errors.New("feature flag already exists")
+	// ErrInvalidFeatureFlag is returned when a flag key or rollout is invalid
+	ErrInvalidFeatureFlag = errors.New("invalid feature flag")
+
+	featureFlagKeyPattern = regexp.MustCompile(`^[a-z0-9_]{1,100}$`)
+)
+
+// FeatureFlagService handles feature flag evaluation and management
+type FeatureFlagService struct {
+	db       *database.PostgresDB
+	redis    *database.RedisClient
+	features config.FeaturesConfig
+	ttls     *cache.TTLs
+}
+
+// NewFeatureFlagService creates a new feature flag service. Flags of
+// features switched off in features are always off; flags are cached for
+// their cache type's TTL in ttls.
+func NewFeatureFlagService(db *database.PostgresDB, redis *database.RedisClient, features config.FeaturesConfig, ttls *cache.TTLs) *FeatureFlagService {
+	return &FeatureFlagService{db: db, redis: redis, features: features, ttls: ttls}
+}
+
+// IsEnabled reports whether flag is enabled for userID. Partial rollouts
+// bucket users by a hash of the flag and user ID, so a user stays in the same
+// bucket across requests. Unknown flags, lookup failures and features
+// switched off for the deployment are disabled.
+func (s *FeatureFlagService) IsEnabled(ctx context.Context, flag string, userID string) bool {
+	if !s.features.IsEnabled(flag) {
+		return false
+	}
+	f, err := s.getCached(ctx, flag)
+	if err != nil {
+		if !errors.Is(err, ErrFeatureFlagNotFound) {
+			log.Warn().Err(err).Str("flag", flag).Msg("Failed to evaluate feature flag")
+		}
+		return false
+	}
+	if !f.Enabled {
+		return false
+	}
+	if f.RolloutPercentage >= 100 {
+		return true
+	}
+	if userID == "" || f.RolloutPercentage <= 0 {
+		return false
+	}
+	return rolloutBucket(flag, userID) < f.RolloutPercentage
+}
+
+// rolloutBucket maps a flag and user to a stable bucket in [0, 100)
+func rolloutBucket(flag, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag + ":" + userID))
+	return int(h.Sum32() % 100)
+}
+
+// List returns all feature flags
+func (s *FeatureFlagService) List(ctx context.Context) ([]models.FeatureFlag, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT key, COALESCE(description, ''), enabled, rollout_percentage, created_at, updated_at
+		FROM feature_flags
+		ORDER BY key`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feature flags: %w", err)
+	}
+	defer rows.Close()
+
+	flags := []models.FeatureFlag{}
+	for rows.Next() {
+		var f models.FeatureFlag
+		if err := rows.Scan(&f.Key, &f.Description, &f.Enabled, &f.RolloutPercentage, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan feature flag: %w", err)
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+// Get returns a single feature flag by key
+func (s *FeatureFlagService) Get(ctx context.Context, key string) (*models.FeatureFlag, error) {
+	var f models.FeatureFlag
+	err := s.db.QueryRowContext(ctx, `
+		SELECT key, COALESCE(description, ''), enabled, rollout_percentage, created_at, updated_at
+		FROM feature_flags
+		WHERE key = $1`, key).
+		Scan(&f.Key, &f.Description, &f.Enabled, &f.RolloutPercentage, &f.CreatedAt, &f.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFeatureFlagNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feature flag: %w", err)
+	}
+	return &f, nil
+}
+
+// Create creates a new feature flag
+func (s *FeatureFlagService) Create(ctx context.Context, key string, input models.FeatureFlagInput) (*models.FeatureFlag, error) {
+	if !featureFlagKeyPattern.MatchString(key) {
+		return nil, fmt.Errorf("%w: key must be lowercase letters, digits or underscores", ErrInvalidFeatureFlag)
+	}
+
+	f := models.FeatureFlag{Key: key, RolloutPercentage: 100}
+	applyFeatureFlagInput(&f, input)
+	if f.RolloutPercentage < 0 || f.RolloutPercentage > 100 {
+		return nil, fmt.Errorf("%w: rollout percentage must be between 0 and 100", ErrInvalidFeatureFlag)
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO feature_flags (key, description, enabled, rollout_percentage)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO NOTHING
+		RETURNING created_at, updated_at`,
+		f.Key, f.Description, f.Enabled, f.RolloutPercentage).Scan(&f.CreatedAt, &f.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFeatureFlagExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create feature flag: %w", err)
+	}
+
+	s.invalidate(ctx, key)
+	return &f, nil
+}
+
+// Update applies the provided fields to an existing feature flag
+func (s *FeatureFlagService) Update(ctx context.Context, key string, input models.FeatureFlagInput) (*models.FeatureFlag, error) {
+	f, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	applyFeatureFlagInput(f, input)
+	if f.RolloutPercentage < 0 || f.RolloutPercentage > 100 {
+		return nil, fmt.Errorf("%w: rollout percentage must be between 0 and 100", ErrInvalidFeatureFlag)
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE feature_flags
+		SET description = $2, enabled = $3, rollout_percentage = $4
+		WHERE key = $1
+		RETURNING updated_at`,
+		f.Key, f.Description, f.Enabled, f.RolloutPercentage).Scan(&f.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFeatureFlagNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update feature flag: %w", err)
+	}
+
+	s.invalidate(ctx, key)
+	return f, nil
+}
+
+// Delete removes a feature flag
+func (s *FeatureFlagService) Delete(ctx context.Context, key string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM feature_flags WHERE key = $1`, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete feature flag: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrFeatureFlagNotFound
+	}
+
+	s.invalidate(ctx, key)
+	return nil
+}
+
+// getCached returns a flag from Redis, loading it from the database on a miss.
+// Missing flags are cached as "null" so unknown keys don't hit the database.
+func (s *FeatureFlagService) getCached(ctx context.Context, key string) (*models.FeatureFlag, error) {
+	cacheKey := featureFlagCacheKey(key)
+	if cached, err := s.redis.Get(ctx, cacheKey); err == nil {
+		if cached == "null" {
+			return nil, ErrFeatureFlagNotFound
+		}
+		var f models.FeatureFlag
+		if err := json.Unmarshal([]byte(cached), &f); err == nil {
+			return &f, nil
+		}
+	}
+
+	f, err := s.Get(ctx, key)
+	if err != nil && !errors.Is(err, ErrFeatureFlagNotFound) {
+		return nil, err
+	}
+
+	data, _ := json.Marshal(f)
+	if err := s.redis.SetWithExpiration(ctx, cacheKey, string(data), s.ttls.Get(config.CacheFeatureFlags)); err != nil {
+		log.Warn().Err(err).Str("flag", key).Msg("Failed to cache feature flag")
+	}
+	if f == nil {
+		return nil, ErrFeatureFlagNotFound
+	}
+	return f, nil
+}
+
+// invalidate drops the cached copy of a flag so changes apply immediately
+func (s *FeatureFlagService) invalidate(ctx context.Context, key string) {
+	if err := s.redis.Delete(ctx, featureFlagCacheKey(key)); err != nil {
+		log.Warn().Err(err).Str("flag", key).Msg("Failed to invalidate feature flag cache")
+	}
+}
+
+func featureFlagCacheKey(key string) string {
+	return "feature_flag:" + key
+}
+
+func applyFeatureFlagInput(f *models.FeatureFlag, input models.FeatureFlagInput) {
+	if input.Description != nil {
+		f.Description = *input.Description
+	}
+	if input.Enabled != nil {
+		f.Enabled = *input.Enabled
+	}
+	if input.RolloutPercentage != nil {
+		f.RolloutPercentage = *input.RolloutPercentage
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Fraud review
+//
+// Orders are scored by a few rules when placed and again when paid: a card
+// stored by many other accounts, a card issued in another country than the
+// order ships to, and a buyer placing many orders in a short time. Each
+// rule that matches adds its configured score, and an order scoring at
+// least the hold score is held in review, before it is charged, instead of
+// going on to be paid and shipped; admins are alerted through
+// EventOrderHeld. Scoring is one indexed query, run outside the order's
+// transaction and cut off after a short timeout: an order that can't be
+// scored goes ahead unscored, so the rules never fail or stall a checkout.
+// Admins release a held order back to pending, to be paid without being
+// scored again, or cancel it, returning its stock; each decision is kept
+// as an audit entry with the score the order was held for.
+
+// EventOrderHeld is published through the outbox when an order is held for
+// fraud review
+const EventOrderHeld = "order.held"
+
+var (
+	ErrOrderHeld    = errors.New("order is held for fraud review")
+	ErrOrderNotHeld = errors.New("order is not held for fraud review")
+)
+
+// Fraud rules, named in an order's fraud reasons
+const (
+	fraudSharedCard      = "shared_card"
+	fraudCountryMismatch = "country_mismatch"
+	fraudVelocity        = "velocity"
+)
+
+// Where an order was scored
+const (
+	fraudStageCheckout = "checkout"
+	fraudStagePayment  = "payment"
+)
+
+// FraudOrder is what the fraud rules read of an order being placed or paid
+type FraudOrder struct {
+	ID              string // empty while the order is being placed
+	BuyerID         string
+	PaymentMethodID string // the stored card paying for it; empty when none is chosen
+	ShippingAddress json.RawMessage
+}
+
+// OrderHeldEvent is the payload of EventOrderHeld
+type OrderHeldEvent struct {
+	OrderID        string   `json:"orderId"`
+	OrderReference string   `json:"orderReference"`
+	BuyerID        string   `json:"buyerId"`
+	Score          int      `json:"score"`
+	Reasons        []string `json:"reasons"`
+	Stage          string   `json:"stage"` // checkout or payment
+}
+
+// FraudService scores orders by the configured fraud rules
+type FraudService struct {
+	db  *database.PostgresDB
+	cfg config.FraudConfig
+}
+
+// NewFraudService creates a new fraud service
+func NewFraudService(db *database.PostgresDB, cfg config.FraudConfig) *FraudService {
+	return &FraudService{db: db, cfg: cfg}
+}
+
+// enabled reports whether any orders are held, and so scored
+func (s *FraudService) enabled() bool {
+	return s.cfg.HoldScore > 0
+}
+
+// holds reports whether an order scoring score is held for review
+func (s *FraudService) holds(score int) bool {
+	return s.enabled() && score >= s.cfg.HoldScore
+}
+
+// Score returns order's fraud score and the rules that matched. An order
+// that can't be scored in time, or at all, scores 0; the failure is
+// logged.
+func (s *FraudService) Score(ctx context.Context, order FraudOrder) (score int, reasons []string) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.ScoreTimeout)*time.Millisecond)
+	defer cancel()
+
+	var sharedAccounts, recentOrders int
+	var cardCountry string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT other.user_id)
+			 FROM payment_methods pm
+			 JOIN payment_methods other ON other.fingerprint = pm.fingerprint AND other.user_id <> pm.user_id
+			 WHERE pm.id = NULLIF($1, '')::uuid),
+			COALESCE((SELECT country FROM payment_methods WHERE id = NULLIF($1, '')::uuid), ''),
+			(SELECT COUNT(*) FROM orders
+			 WHERE buyer_id = $2 AND created_at > NOW() - $3 * INTERVAL '1 minute' AND id IS DISTINCT FROM NULLIF($4, '')::uuid)`,
+		order.PaymentMethodID, order.BuyerID, s.cfg.VelocityWindow, order.ID).Scan(&sharedAccounts, &cardCountry, &recentOrders)
+	if err != nil {
+		log.Warn().Err(err).Str("buyer_id", order.BuyerID).Str("order_id", order.ID).Msg("Failed to score order for fraud")
+		return 0, []string{}
+	}
+
+	reasons = []string{}
+	add := func(reason string, points int) {
+		if points > 0 {
+			score += points
+			reasons = append(reasons, reason)
+		}
+	}
+	if sharedAccounts >= s.cfg.SharedCardAccounts {
+		add(fraudSharedCard, s.cfg.SharedCardScore)
+	}
+	if country := shippingCountry(order.ShippingAddress); country != "" && cardCountry != "" && country != cardCountry {
+		add(fraudCountryMismatch, s.cfg.CountryMismatchScore)
+	}
+	if recentOrders >= s.cfg.VelocityOrders {
+		add(fraudVelocity, s.cfg.VelocityScore)
+	}
+	return score, reasons
+}
+
+// shippingCountry returns the two-letter country code of a shipping
+// address, uppercased, or empty when it has none
+func shippingCountry(address json.RawMessage) string {
+	var a struct {
+		Country string `json:"country"`
+	}
+	if err := json.Unmarshal(address, &a); err != nil {
+		return ""
+	}
+	if country := strings.ToUpper(strings.TrimSpace(a.Country)); len(country) == 2 {
+		return country
+	}
+	return ""
+}
+
+// screenOrder scores locked order as check describes it, at stage,
+// recording the score on the order within tx, and holds the order for
+// review when the score is high enough, reporting whether it did
+func (s *OrderService) screenOrder(ctx context.Context, tx *sql.Tx, order lockedOrder, check FraudOrder, stage string) (bool, error) {
+	if !s.fraud.enabled() {
+		return false, nil
+	}
+	score, reasons := s.fraud.Score(ctx, check)
+	held := s.fraud.holds(score)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE orders SET fraud_score = $2, fraud_reasons = $3,
+			status = CASE WHEN $4 THEN 'review' ELSE status END,
+			held_at = CASE WHEN $4 THEN NOW() ELSE held_at END
+		WHERE id = $1`, order.id, score, pq.Array(reasons), held); err != nil {
+		return false, fmt.Errorf("failed to record fraud score: %w", err)
+	}
+	if !held {
+		return false, nil
+	}
+	if err := appendOrderEvent(ctx, tx, order.id, models.OrderEventHeld, "", map[string]interface{}{
+		"from": order.status, "score": score, "reasons": reasons, "stage": stage,
+	}); err != nil {
+		return false, err
+	}
+	if err := WriteOutbox(ctx, tx, EventOrderHeld, order.id, OrderHeldEvent{
+		OrderID: order.id, OrderReference: order.reference, BuyerID: order.buyerID, Score: score, Reasons: reasons, Stage: stage,
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReviewHeldOrder releases or cancels order orderID, held for fraud review,
+// on behalf of admin adminID, recording the decision. Released orders go
+// back to pending, to be paid without being scored again; cancelled ones
+// return their stock and their buyer is told as for any cancellation.
+func (s *OrderService) ReviewHeldOrder(ctx context.Context, orderID, adminID string, input models.FraudReviewInput) (*models.OrderFraud, error) {
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.status != models.OrderStatusReview {
+			return ErrOrderNotHeld
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO order_fraud_reviews (order_id, admin_id, action, note, score, reasons)
+			SELECT id, $2, $3, NULLIF($4, ''), COALESCE(fraud_score, 0), COALESCE(fraud_reasons, '{}')
+			FROM orders WHERE id = $1`, orderID, adminID, input.Action, input.Note); err != nil {
+			return fmt.Errorf("failed to record fraud review: %w", err)
+		}
+
+		if input.Action == models.FraudReviewCancel {
+			categoryIDs, err = s.transition(ctx, tx, order, "cancelled", adminID)
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE orders SET status = 'pending', released_at = NOW() WHERE id = $1`, orderID); err != nil {
+			return fmt.Errorf("failed to release order: %w", err)
+		}
+		return appendOrderEvent(ctx, tx, orderID, models.OrderEventReleased, adminID, map[string]string{"from": order.status})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return s.OrderFraud(ctx, orderID)
+}
+
+// OrderFraud returns order orderID's latest fraud score and the admin
+// decisions on it, oldest first
+func (s *OrderService) OrderFraud(ctx context.Context, orderID string) (*models.OrderFraud, error) {
+	fraud := &models.OrderFraud{OrderID: orderID, Reasons: []string{}, Reviews: []models.OrderFraudReview{}}
+	var score sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(status, 'pending'), fraud_score, COALESCE(fraud_reasons, '{}'), held_at, released_at
+		FROM orders WHERE id = $1`, orderID).Scan(
+		&fraud.Status, &score, pq.Array(&fraud.Reasons), &fraud.HeldAt, &fraud.ReleasedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if score.Valid {
+		n := int(score.Int64)
+		fraud.Score = &n
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, admin_id, action, COALESCE(note, ''), score, reasons, created_at
+		FROM order_fraud_reviews
+		WHERE order_id = $1
+		ORDER BY created_at, id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fraud reviews: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		review := models.OrderFraudReview{Reasons: []string{}}
+		if err := rows.Scan(&review.ID, &review.AdminID, &review.Action, &review.Note, &review.Score,
+			pq.Array(&review.Reasons), &review.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan fraud review: %w", err)
+		}
+		fraud.Reviews = append(fraud.Reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list fraud reviews: %w", err)
+	}
+	return fraud, nil
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Stock events published through the outbox when stock crosses a threshold
+const (
+	EventStockLow         = "stock.low"
+	EventStockBackInStock = "stock.back_in_stock"
+)
+
+// StockEvent is the payload of EventStockLow and EventStockBackInStock
+type StockEvent struct {
+	ProductID     string `json:"productId"`
+	SellerID      string `json:"sellerId"`
+	Title         string `json:"title"`
+	StockQuantity int    `json:"stockQuantity"`
+	Threshold     int    `json:"threshold"`
+}
+
+// InventoryService handles stock management
+type InventoryService struct {
+	db    *database.PostgresDB
+	redis *database.RedisClient
+	cache *cache.Cache
+	cfg   config.InventoryConfig
+}
+
+// NewInventoryService creates a new inventory service
+func NewInventoryService(db *database.PostgresDB, redis *database.RedisClient, cache *cache.Cache, cfg config.InventoryConfig) *InventoryService {
+	return &InventoryService{db: db, redis: redis, cache: cache, cfg: cfg}
+}
+
+// AdjustStock applies a seller's stock changes in a single transaction and
+// records them as a stock adjustment and in the stock ledger. Every item is checked before anything
+// is written; if any product is unknown, belongs to another seller, or would
+// go below zero, nothing is applied and a *validators.ValidationError lists
+// the offending items.
+func (s *InventoryService) AdjustStock(ctx context.Context, sellerID string, input models.StockAdjustmentInput) (*models.StockAdjustment, error) {
+	var adjustment *models.StockAdjustment
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		adjustment, categoryIDs, err = s.adjustStock(ctx, tx, sellerID, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+	return adjustment, nil
+}
+
+// adjustStock applies a stock adjustment in tx, returning it with the
+// categories of the listings to invalidate once tx commits. Nothing is
+// written when it fails with a *validators.ValidationError.
+func (s *InventoryService) adjustStock(ctx context.Context, tx *sql.Tx, sellerID string, input models.StockAdjustmentInput) (*models.StockAdjustment, []string, error) {
+	adjustment := &models.StockAdjustment{SellerID: sellerID, Items: make([]models.StockLevel, len(input.Items))}
+	var categoryIDs []string
+
+	ids := make([]string, len(input.Items))
+	for i, item := range input.Items {
+		ids[i] = item.ProductID
+	}
+
+	products, err := lockStock(ctx, tx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var invalid []validators.FieldError
+	for i, item := range input.Items {
+		p, ok := products[item.ProductID]
+		switch {
+		case !ok || p.deleted:
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("items[%d].productId", i), Code: "not_found", Message: "product not found",
+			})
+		case p.sellerID != sellerID:
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("items[%d].productId", i), Code: "forbidden", Message: "product belongs to another seller",
+			})
+		case p.isBundle:
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("items[%d].productId", i), Code: "bundle", Message: "bundle stock follows its components",
+			})
+		case p.quantity+item.Delta < 0:
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("items[%d].delta", i), Code: "insufficient_stock", Param: fmt.Sprint(p.quantity),
+				Message: fmt.Sprintf("stock cannot go below zero (current stock %d)", p.quantity),
+			})
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, nil, &validators.ValidationError{Fields: invalid}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO stock_adjustments (seller_id) VALUES ($1) RETURNING id, created_at`,
+		sellerID).Scan(&adjustment.ID, &adjustment.CreatedAt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create stock adjustment: %w", err)
+	}
+
+	for i, item := range input.Items {
+		p := products[item.ProductID]
+		balance, err := changeStock(ctx, tx, stockChange{
+			productID:   item.ProductID,
+			delta:       item.Delta,
+			reason:      StockReasonAdjustment,
+			referenceID: adjustment.ID,
+			actorID:     sellerID,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		level := models.StockLevel{
+			ProductID:        item.ProductID,
+			Delta:            item.Delta,
+			PreviousQuantity: p.quantity,
+			StockQuantity:    balance,
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO stock_adjustment_items (adjustment_id, product_id, delta, previous_quantity, stock_quantity)
+			VALUES ($1, $2, $3, $4, $5)`,
+			adjustment.ID, item.ProductID, item.Delta, level.PreviousQuantity, level.StockQuantity); err != nil {
+			return nil, nil, fmt.Errorf("failed to record stock adjustment: %w", err)
+		}
+		if err := s.writeStockEvents(ctx, tx, p.sellerID, p.title, level); err != nil {
+			return nil, nil, err
+		}
+		adjustment.Items[i] = level
+		categoryIDs = append(categoryIDs, p.categoryIDs...)
+	}
+	return adjustment, categoryIDs, nil
+}
+
+// writeStockEvents publishes threshold crossings caused by a stock change
+func (s *InventoryService) writeStockEvents(ctx context.Context, tx *sql.Tx, sellerID, title string, level models.StockLevel) error {
+	event := StockEvent{
+		ProductID:     level.ProductID,
+		SellerID:      sellerID,
+		Title:         title,
+		StockQuantity: level.StockQuantity,
+		Threshold:     s.cfg.LowStockThreshold,
+	}
+	if level.PreviousQuantity <= 0 && level.StockQuantity > 0 {
+		if err := WriteOutbox(ctx, tx, EventStockBackInStock, level.ProductID, event); err != nil {
+			return err
+		}
+	}
+	if level.PreviousQuantity > s.cfg.LowStockThreshold && level.StockQuantity <= s.cfg.LowStockThreshold {
+		if err := WriteOutbox(ctx, tx, EventStockLow, level.ProductID, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotifyBackInStock is the job handler for EventStockBackInStock, notifying
+// users with the product on their wishlist
+func (s *InventoryService) NotifyBackInStock(ctx context.Context, job *jobs.Job) error {
+	var event StockEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal stock event: %w", err)
+	}
+	return notifyProductEvent(ctx, s.db, job.ID, `SELECT user_id FROM wishlist WHERE product_id = $1`, event.ProductID,
+		"back_in_stock", "Back in stock", fmt.Sprintf("%s is back in stock", event.Title), event.ProductID)
+}
+
+// NotifyLowStock is the job handler for EventStockLow, notifying the seller
+func (s *InventoryService) NotifyLowStock(ctx context.Context, job *jobs.Job) error {
+	var event StockEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal stock event: %w", err)
+	}
+	return notifyProductEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.SellerID,
+		"low_stock", "Low stock", fmt.Sprintf("%s has %d left in stock", event.Title, event.StockQuantity), event.ProductID)
+}
+
+// notifyProductEvent creates a notification about product productID for
+// each recipient selected by recipients (with $1 bound to arg), as notifyEvent
+func notifyProductEvent(ctx context.Context, db *database.PostgresDB, jobID, recipients, arg, notificationType, title, message, productID string) error {
+	return notifyEvent(ctx, db, jobID, recipients, arg, notificationType, title, message, map[string]interface{}{"productId": productID})
+}
+
+// notifyEvent creates a notification with data for each recipient selected
+// by recipients (with $1 bound to arg). Recipients taking a daily digest get
+// the notification in their next digest instead, unless its type is
+// instant-only. The job ID is stored with the notification so redelivered
+// jobs don't notify twice, and each notification created is published as
+// EventNotificationCreated for its external channels.
+func notifyEvent(ctx context.Context, db *database.PostgresDB, jobID, recipients, arg, notificationType, title, message string, data map[string]interface{}) error {
+	data["jobId"] = jobID
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification data: %w", err)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`
+		WITH recipients AS (
+			SELECT r.user_id, NOT $7 AND COALESCE(up.notification_mode, 'instant') = 'daily_digest' AS digest
+			FROM (%s) AS r(user_id)
+			LEFT JOIN user_preferences up ON up.user_id = r.user_id
+		), digested AS (
+			INSERT INTO notification_digest_items (user_id, job_id, type, title, message, data)
+			SELECT user_id, $6, $2, $3, $4, $5 FROM recipients WHERE digest
+			ON CONFLICT (user_id, job_id) DO NOTHING
+		), notified AS (
+			INSERT INTO notifications (user_id, type, title, message, data)
+			SELECT r.user_id, $2, $3, $4, $5
+			FROM recipients r
+			WHERE NOT r.digest AND NOT EXISTS (
+				SELECT 1 FROM notifications n WHERE n.user_id = r.user_id AND n.data->>'jobId' = $6
+			)
+			RETURNING id
+		)
+		INSERT INTO outbox (event_type, aggregate_id, payload)
+		SELECT $8, id::text, jsonb_build_object('notificationId', id) FROM notified`, recipients),
+		arg, notificationType, title, message, string(encoded), jobID, instantNotificationTypes[notificationType], EventNotificationCreated)
+	if err != nil {
+		return fmt.Errorf("failed to create %s notifications: %w", notificationType, err)
+	}
+	return nil
+}
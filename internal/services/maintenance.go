@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Maintenance windows
+//
+// Admins schedule downtime ahead rather than switching degraded mode on
+// without warning. From a window's notice time, responses carry a Warning
+// header naming it and its announcement_bar banner shows in the default
+// locale; while it is on, the API answers 503 with its message, except
+// health checks, sign-in and admin routes, so admins can still manage it.
+// Windows may not overlap. Each replica reads the open windows at most
+// every maintenanceRefresh and works out what applies at each request, so
+// windows start and end on time: changes show at once on the replica that
+// made them and within the refresh elsewhere.
+
+// maintenanceRefresh bounds how long a replica serves stale windows
+const maintenanceRefresh = 10 * time.Second
+
+// defaultMaintenanceNotice is how long ahead windows are announced unless
+// scheduled otherwise
+const defaultMaintenanceNotice = 60 * time.Minute
+
+// maintenanceBannerKey is the content slot windows are announced in
+const maintenanceBannerKey = "announcement_bar"
+
+var (
+	ErrMaintenanceWindowNotFound = errors.New("maintenance window not found")
+	ErrMaintenanceWindowClosed   = errors.New("maintenance window has ended or been cancelled")
+)
+
+const maintenanceWindowColumns = `id, starts_at, ends_at, notice_at, message, banner_block_id, created_by,
+	cancelled_at, created_at, updated_at`
+
+// MaintenanceService schedules maintenance windows and reports the one
+// applying now
+type MaintenanceService struct {
+	db      *database.PostgresDB
+	content *ContentService
+
+	mu        sync.Mutex
+	open      []models.MaintenanceWindow // not ended nor cancelled, soonest first
+	refreshAt time.Time
+}
+
+// NewMaintenanceService creates a new maintenance service. Banners are
+// dropped from content's cache when windows change.
+func NewMaintenanceService(db *database.PostgresDB, content *ContentService) *MaintenanceService {
+	return &MaintenanceService{db: db, content: content}
+}
+
+// scanMaintenanceWindow scans a row selected with maintenanceWindowColumns,
+// followed by any extra destinations, with its status at now
+func scanMaintenanceWindow(row rowScanner, now time.Time, extra ...interface{}) (*models.MaintenanceWindow, error) {
+	var m models.MaintenanceWindow
+	if err := row.Scan(append([]interface{}{&m.ID, &m.StartsAt, &m.EndsAt, &m.NoticeAt, &m.Message, &m.BannerBlockID,
+		&m.CreatedBy, &m.CancelledAt, &m.CreatedAt, &m.UpdatedAt}, extra...)...); err != nil {
+		return nil, err
+	}
+	m.Status = m.StatusAt(now)
+	return &m, nil
+}
+
+// Maintenance returns the window in its notice period or on now, or nil.
+// When the windows can't be read it keeps to the last ones read.
+func (s *MaintenanceService) Maintenance(ctx context.Context) *models.MaintenanceWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.refreshAt) {
+		open, err := s.openWindows(ctx)
+		if err == nil {
+			s.open = open
+		} else {
+			log.Warn().Err(err).Msg("Failed to refresh maintenance windows, using last known")
+		}
+		s.refreshAt = now.Add(maintenanceRefresh)
+	}
+	for i := range s.open {
+		if status := s.open[i].StatusAt(now); status == models.MaintenanceNotice || status == models.MaintenanceActive {
+			window := s.open[i]
+			window.Status = status
+			return &window
+		}
+	}
+	return nil
+}
+
+// openWindows returns the windows not ended nor cancelled, soonest first
+func (s *MaintenanceService) openWindows(ctx context.Context) ([]models.MaintenanceWindow, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+maintenanceWindowColumns+`
+		FROM maintenance_windows
+		WHERE cancelled_at IS NULL AND ends_at > NOW()
+		ORDER BY starts_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get maintenance windows: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var windows []models.MaintenanceWindow
+	for rows.Next() {
+		m, err := scanMaintenanceWindow(rows, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan maintenance window: %w", err)
+		}
+		windows = append(windows, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get maintenance windows: %w", err)
+	}
+	return windows, nil
+}
+
+// List returns a page of windows, the latest start first
+func (s *MaintenanceService) List(ctx context.Context, limit, offset int) (*models.MaintenanceWindowPage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+maintenanceWindowColumns+`, COUNT(*) OVER() AS total
+		FROM maintenance_windows
+		ORDER BY starts_at DESC, id
+		LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list maintenance windows: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	page := &models.MaintenanceWindowPage{Windows: []models.MaintenanceWindow{}}
+	for rows.Next() {
+		m, err := scanMaintenanceWindow(rows, now, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan maintenance window: %w", err)
+		}
+		page.Windows = append(page.Windows, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list maintenance windows: %w", err)
+	}
+	return page, nil
+}
+
+// Schedule schedules a window on behalf of admin adminID, with its banner.
+// It fails with a *validators.ValidationError when it ends in the past or
+// overlaps another window.
+func (s *MaintenanceService) Schedule(ctx context.Context, adminID string, input models.MaintenanceWindowInput) (*models.MaintenanceWindow, error) {
+	noticeAt, payload, err := checkMaintenanceWindow(input)
+	if err != nil {
+		return nil, err
+	}
+	var window *models.MaintenanceWindow
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkMaintenanceOverlap(ctx, tx, "", input); err != nil {
+			return err
+		}
+		var bannerID string
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO content_blocks (key, locale, starts_at, ends_at, payload, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id`,
+			maintenanceBannerKey, utils.DefaultLocale, noticeAt, input.EndsAt, string(payload), adminID).Scan(&bannerID); err != nil {
+			return fmt.Errorf("failed to create maintenance banner: %w", err)
+		}
+		var err error
+		window, err = scanMaintenanceWindow(tx.QueryRowContext(ctx, `
+			INSERT INTO maintenance_windows (starts_at, ends_at, notice_at, message, banner_block_id, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+maintenanceWindowColumns,
+			input.StartsAt, input.EndsAt, noticeAt, input.Message, bannerID, adminID), time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to schedule maintenance window: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.changed(ctx)
+	return window, nil
+}
+
+// Update reschedules window id, or changes its message, with its banner.
+// It is checked as by Schedule; windows ended or cancelled fail with
+// ErrMaintenanceWindowClosed.
+func (s *MaintenanceService) Update(ctx context.Context, id string, input models.MaintenanceWindowInput) (*models.MaintenanceWindow, error) {
+	noticeAt, payload, err := checkMaintenanceWindow(input)
+	if err != nil {
+		return nil, err
+	}
+	var window *models.MaintenanceWindow
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		current, err := lockMaintenanceWindow(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkMaintenanceOverlap(ctx, tx, id, input); err != nil {
+			return err
+		}
+		if current.BannerBlockID != nil {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE content_blocks SET starts_at = $2, ends_at = $3, payload = $4 WHERE id = $1`,
+				*current.BannerBlockID, noticeAt, input.EndsAt, string(payload)); err != nil {
+				return fmt.Errorf("failed to update maintenance banner: %w", err)
+			}
+		}
+		window, err = scanMaintenanceWindow(tx.QueryRowContext(ctx, `
+			UPDATE maintenance_windows SET starts_at = $2, ends_at = $3, notice_at = $4, message = $5
+			WHERE id = $1
+			RETURNING `+maintenanceWindowColumns,
+			id, input.StartsAt, input.EndsAt, noticeAt, input.Message), time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to update maintenance window: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.changed(ctx)
+	return window, nil
+}
+
+// Cancel cancels window id, on or not, and removes its banner. Windows
+// ended or cancelled already fail with ErrMaintenanceWindowClosed.
+func (s *MaintenanceService) Cancel(ctx context.Context, id string) (*models.MaintenanceWindow, error) {
+	var window *models.MaintenanceWindow
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		current, err := lockMaintenanceWindow(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if current.BannerBlockID != nil {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM content_blocks WHERE id = $1`, *current.BannerBlockID); err != nil {
+				return fmt.Errorf("failed to remove maintenance banner: %w", err)
+			}
+		}
+		window, err = scanMaintenanceWindow(tx.QueryRowContext(ctx, `
+			UPDATE maintenance_windows SET cancelled_at = NOW() WHERE id = $1
+			RETURNING `+maintenanceWindowColumns, id), time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to cancel maintenance window: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.changed(ctx)
+	return window, nil
+}
+
+// changed makes the next request read the windows again, and drops the
+// cached banners
+func (s *MaintenanceService) changed(ctx context.Context) {
+	s.mu.Lock()
+	s.refreshAt = time.Time{}
+	s.mu.Unlock()
+	s.content.invalidate(ctx, maintenanceBannerKey)
+}
+
+// lockMaintenanceWindow locks window id, failing with
+// ErrMaintenanceWindowClosed when it has ended or been cancelled
+func lockMaintenanceWindow(ctx context.Context, tx *sql.Tx, id string) (*models.MaintenanceWindow, error) {
+	window, err := scanMaintenanceWindow(tx.QueryRowContext(ctx, `
+		SELECT `+maintenanceWindowColumns+` FROM maintenance_windows WHERE id = $1 FOR UPDATE`, id), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMaintenanceWindowNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get maintenance window: %w", err)
+	}
+	if window.Status == models.MaintenanceEnded || window.Status == models.MaintenanceCancelled {
+		return nil, ErrMaintenanceWindowClosed
+	}
+	return window, nil
+}
+
+// checkMaintenanceWindow checks that input ends in the future, returning
+// when it is announced and its banner's payload
+func checkMaintenanceWindow(input models.MaintenanceWindowInput) (time.Time, []byte, error) {
+	if !input.EndsAt.After(time.Now()) {
+		return time.Time{}, nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "endsAt", Code: "future", Message: "endsAt must be in the future",
+		}}}
+	}
+	notice := defaultMaintenanceNotice
+	if input.NoticeMinutes != nil {
+		notice = time.Duration(*input.NoticeMinutes) * time.Minute
+	}
+	payload, err := json.Marshal(map[string]interface{}{"message": input.Message, "dismissible": false})
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("failed to marshal maintenance banner: %w", err)
+	}
+	return input.StartsAt.Add(-notice), payload, nil
+}
+
+// checkMaintenanceOverlap fails with a *validators.ValidationError when
+// input overlaps an open window other than exceptID
+func checkMaintenanceOverlap(ctx context.Context, tx *sql.Tx, exceptID string, input models.MaintenanceWindowInput) error {
+	var overlapping string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM maintenance_windows
+		WHERE cancelled_at IS NULL AND id::text <> $1 AND starts_at < $3 AND ends_at > $2
+		ORDER BY starts_at
+		LIMIT 1`, exceptID, input.StartsAt, input.EndsAt).Scan(&overlapping)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check maintenance windows: %w", err)
+	}
+	return &validators.ValidationError{Fields: []validators.FieldError{{
+		Field: "startsAt", Code: "overlap", Param: overlapping, Message: "window overlaps maintenance window " + overlapping,
+	}}}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/jobs"
+)
+
+// instantNotificationTypes are sent as they happen even to users taking a
+// daily digest, as they need acting on at once
+var instantNotificationTypes = map[string]bool{
+	"payment_failed": true,
+	"seller_status":  true,
+}
+
+// digestLabels name what a digest counts for each notification type, in the
+// singular and plural
+var digestLabels = map[string][2]string{
+	"partial_shipment": {"order shipped", "orders shipped"},
+	"backorder":        {"backorder update", "backorder updates"},
+	"order_expired":    {"unpaid order cancelled", "unpaid orders cancelled"},
+	"order_return":     {"return update", "return updates"},
+	"back_in_stock":    {"item back in stock", "items back in stock"},
+	"price_drop":       {"price drop", "price drops"},
+	"low_stock":        {"product low on stock", "products low on stock"},
+	"announcement":     {"announcement", "announcements"},
+	"new_product":      {"new product from sellers you follow", "new products from sellers you follow"},
+	"review_reply":     {"reply to your reviews", "replies to your reviews"},
+	"product_question": {"question about your products", "questions about your products"},
+	"question_answer":  {"answer to your questions", "answers to your questions"},
+}
+
+// digestBatchSize is the number of users whose digests are sent per check
+const digestBatchSize = 500
+
+// NotificationService handles user notifications
+type NotificationService struct {
+	db    *database.PostgresDB
+	redis *database.RedisClient
+	queue *jobs.Queue
+	cfg   config.NotificationConfig
+
+	channels []NotificationChannel // external channels enabled in cfg
+}
+
+// NewNotificationService creates a new notification service. Broadcasts are
+// sent in batches through queue, as are sends through external channels.
+func NewNotificationService(db *database.PostgresDB, redis *database.RedisClient, queue *jobs.Queue, cfg config.NotificationConfig) *NotificationService {
+	return &NotificationService{db: db, redis: redis, queue: queue, cfg: cfg, channels: notificationChannels(cfg)}
+}
+
+// digestItem is a notification waiting for its digest
+type digestItem struct {
+	id               string
+	notificationType string
+	title            string
+	message          string
+	data             json.RawMessage
+}
+
+// DigestEntry is a notification summarized in a digest
+type DigestEntry struct {
+	Type    string          `json:"type"`
+	Title   string          `json:"title"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// SendDueDigests sends the digests of notifications accumulated up to the
+// latest digest hour, returning how many were sent, then forgets sent items
+// past their retention
+func (s *NotificationService) SendDueDigests(ctx context.Context) (int, error) {
+	// The cutoff is on the database clock, like the items' created_at
+	var cutoff time.Time
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT CASE WHEN c > NOW() THEN c - INTERVAL '1 day' ELSE c END
+		FROM (SELECT (date_trunc('day', NOW() AT TIME ZONE 'UTC') + $1 * INTERVAL '1 hour') AT TIME ZONE 'UTC' AS c) t`,
+		s.cfg.DigestHour).Scan(&cutoff); err != nil {
+		return 0, fmt.Errorf("failed to get digest cutoff: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT user_id FROM notification_digest_items
+		WHERE sent_at IS NULL AND created_at < $1
+		LIMIT $2`, cutoff, digestBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due digests: %w", err)
+	}
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan due digest: %w", err)
+		}
+		users = append(users, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list due digests: %w", err)
+	}
+
+	sent := 0
+	for _, userID := range users {
+		ok, err := s.sendDigest(ctx, userID, cutoff)
+		if err != nil {
+			if ctx.Err() != nil {
+				return sent, err
+			}
+			// Its items stay pending for the next check
+			log.Error().Err(err).Str("user_id", userID).Msg("Failed to send notification digest")
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM notification_digest_items WHERE sent_at < NOW() - $1 * INTERVAL '1 day'`,
+		s.cfg.DigestRetention); err != nil {
+		return sent, fmt.Errorf("failed to purge sent digest items: %w", err)
+	}
+	return sent, nil
+}
+
+// sendDigest sends a user's digest of the items accumulated before cutoff,
+// marking them sent in the same transaction, so each is in exactly one
+// digest. Items locked by another replica's digest are left to it, and a
+// user gets one digest per cutoff: items committed after theirs was sent
+// wait for the next.
+func (s *NotificationService) sendDigest(ctx context.Context, userID string, cutoff time.Time) (bool, error) {
+	jobID := "digest:" + cutoff.UTC().Format(time.RFC3339)
+	sent := false
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, type, title, COALESCE(message, ''), COALESCE(data, 'null')
+			FROM notification_digest_items
+			WHERE user_id = $1 AND sent_at IS NULL AND created_at < $2
+			ORDER BY created_at, id
+			FOR UPDATE SKIP LOCKED`, userID, cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to get digest items: %w", err)
+		}
+		var items []digestItem
+		for rows.Next() {
+			var item digestItem
+			if err := rows.Scan(&item.id, &item.notificationType, &item.title, &item.message, &item.data); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan digest item: %w", err)
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get digest items: %w", err)
+		}
+		if len(items) == 0 {
+			return nil
+		}
+
+		var already bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM notifications WHERE user_id = $1 AND type = 'digest' AND data->>'jobId' = $2)`,
+			userID, jobID).Scan(&already); err != nil {
+			return fmt.Errorf("failed to check digest: %w", err)
+		}
+		if already {
+			return nil
+		}
+
+		message, counts, entries := summarizeDigest(items)
+		data, err := json.Marshal(map[string]interface{}{"jobId": jobID, "counts": counts, "notifications": entries})
+		if err != nil {
+			return fmt.Errorf("failed to marshal digest: %w", err)
+		}
+		var notificationID string
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO notifications (user_id, type, title, message, data) VALUES ($1, 'digest', 'Your daily summary', $2, $3)
+			RETURNING id`,
+			userID, message, string(data)).Scan(&notificationID); err != nil {
+			return fmt.Errorf("failed to create digest notification: %w", err)
+		}
+		if err := WriteOutbox(ctx, tx, EventNotificationCreated, notificationID, NotificationCreatedEvent{NotificationID: notificationID}); err != nil {
+			return err
+		}
+
+		ids := make([]string, len(items))
+		for i, item := range items {
+			ids[i] = item.id
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE notification_digest_items SET sent_at = NOW() WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+			return fmt.Errorf("failed to mark digest items sent: %w", err)
+		}
+		sent = true
+		return nil
+	})
+	return sent, err
+}
+
+// summarizeDigest dedupes items about the same order or product, keeping
+// the latest of each, and counts what is left by type, as in "3 orders
+// shipped, 1 price drop"
+func summarizeDigest(items []digestItem) (string, map[string]int, []DigestEntry) {
+	latest := make(map[string]int, len(items))
+	var keys []string
+	for i, item := range items {
+		var subject struct {
+			OrderID   string `json:"orderId"`
+			ProductID string `json:"productId"`
+		}
+		json.Unmarshal(item.data, &subject)
+		key := item.notificationType + ":" + cmp.Or(subject.OrderID, subject.ProductID, item.id)
+		if _, ok := latest[key]; !ok {
+			keys = append(keys, key)
+		}
+		latest[key] = i
+	}
+
+	counts := make(map[string]int)
+	entries := make([]DigestEntry, 0, len(keys))
+	for _, key := range keys {
+		item := items[latest[key]]
+		counts[item.notificationType]++
+		entries = append(entries, DigestEntry{Type: item.notificationType, Title: item.title, Message: item.message, Data: item.data})
+	}
+
+	// Most frequent first, naming types without a label together at the end
+	types := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(-cmp.Compare(counts[a], counts[b]), cmp.Compare(a, b))
+	})
+	var parts []string
+	others := 0
+	for _, t := range types {
+		label, ok := digestLabels[t]
+		if !ok {
+			others += counts[t]
+			continue
+		}
+		parts = append(parts, pluralize(counts[t], label))
+	}
+	if others > 0 {
+		parts = append(parts, pluralize(others, [2]string{"other notification", "other notifications"}))
+	}
+	return strings.Join(parts, ", "), counts, entries
+}
+
+func pluralize(n int, label [2]string) string {
+	if n == 1 {
+		return "1 " + label[0]
+	}
+	return fmt.Sprintf("%d %s", n, label[1])
+}
+
+// RunDigests sends due digests as a scheduled task
+func (s *NotificationService) RunDigests(ctx context.Context) error {
+	if _, err := s.SendDueDigests(ctx); err != nil {
+		return fmt.Errorf("notification digests failed: %w", err)
+	}
+	return nil
+}
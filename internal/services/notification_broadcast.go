@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// JobBroadcastBatch notifies the next batch of a broadcast's users
+const JobBroadcastBatch = "notification.broadcast_batch"
+
+// broadcastBatchSize is the number of users notified per broadcast job
+const broadcastBatchSize = 1000
+
+var (
+	ErrBroadcastNotFound = errors.New("broadcast not found")
+	// ErrBroadcastKeyReused is returned when a broadcast is posted under the
+	// key of an earlier one with different content
+	ErrBroadcastKeyReused = errors.New("broadcast key already used for a different broadcast")
+)
+
+// broadcastJob is the payload of JobBroadcastBatch
+type broadcastJob struct {
+	BroadcastID string `json:"broadcastId"`
+}
+
+// broadcastSegment selects the active users of a broadcast's audience, with
+// $1 the audience and $2 its role or plan
+const broadcastSegment = `COALESCE(u.is_active, true) AND ($1 = 'all' OR ($1 = 'role' AND u.role = $2) OR ($1 = 'plan' AND u.plan = $2))`
+
+const broadcastColumns = `id, key, created_by, audience, COALESCE(audience_value, ''), title, message, promotional,
+	status, recipients, sent, skipped, created_at, completed_at`
+
+// Broadcast queues an announcement to the users of input's audience,
+// returning it with true when it was created. Users are notified in batches
+// by background jobs; the broadcast's status and counts show the progress.
+// Posting a broadcast again under its key returns it as it stands, sending
+// nothing more, and fails with ErrBroadcastKeyReused if the content differs.
+func (s *NotificationService) Broadcast(ctx context.Context, adminID string, input models.BroadcastInput) (*models.Broadcast, bool, error) {
+	audienceValue := input.Role
+	if input.Audience == models.BroadcastPlan {
+		audienceValue = input.Plan
+	}
+	if input.Audience == models.BroadcastAll {
+		audienceValue = ""
+	}
+
+	broadcast, err := scanBroadcast(s.db.QueryRowContext(ctx, `
+		INSERT INTO notification_broadcasts (key, created_by, audience, audience_value, title, message, promotional, recipients)
+		SELECT $3, $4, $1, NULLIF($2, ''), $5, $6, $7, COUNT(*) FROM users u WHERE `+broadcastSegment+`
+		ON CONFLICT (key) DO NOTHING
+		RETURNING `+broadcastColumns,
+		input.Audience, audienceValue, input.Key, adminID, input.Title, input.Message, input.Promotional))
+	created := err == nil
+	if errors.Is(err, sql.ErrNoRows) {
+		broadcast, err = scanBroadcast(s.db.QueryRowContext(ctx, `
+			SELECT `+broadcastColumns+` FROM notification_broadcasts WHERE key = $1`, input.Key))
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create broadcast: %w", err)
+	}
+	if !created && (broadcast.Audience != input.Audience || broadcast.AudienceValue != audienceValue ||
+		broadcast.Title != input.Title || broadcast.Message != input.Message || broadcast.Promotional != input.Promotional) {
+		return nil, false, ErrBroadcastKeyReused
+	}
+
+	// A broadcast still queued may have failed to enqueue its first batch;
+	// batches pick up where the last stopped, so a spare one is harmless
+	if broadcast.Status == models.BroadcastQueued {
+		if err := s.queue.Enqueue(ctx, "", JobBroadcastBatch, broadcastJob{BroadcastID: broadcast.ID}); err != nil {
+			return nil, false, err
+		}
+	}
+	return broadcast, created, nil
+}
+
+// GetBroadcast returns a broadcast with its progress
+func (s *NotificationService) GetBroadcast(ctx context.Context, id string) (*models.Broadcast, error) {
+	broadcast, err := scanBroadcast(s.db.QueryRowContext(ctx, `
+		SELECT `+broadcastColumns+` FROM notification_broadcasts WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBroadcastNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get broadcast: %w", err)
+	}
+	return broadcast, nil
+}
+
+// SendBroadcastBatch is the job handler for JobBroadcastBatch. It notifies
+// the next batch of the broadcast's users after the last one notified, then
+// queues the batch after, until the segment is done. The broadcast row is
+// locked while a batch is sent, and notifications carry the broadcast's ID,
+// so repeated or concurrent jobs never notify a user twice. Users taking a
+// daily digest get the announcement in their digest, and users who don't
+// accept marketing are skipped for promotional ones.
+func (s *NotificationService) SendBroadcastBatch(ctx context.Context, job *jobs.Job) error {
+	var payload broadcastJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal broadcast job: %w", err)
+	}
+
+	done := false
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var b models.Broadcast
+		var lastUserID sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT audience, COALESCE(audience_value, ''), title, message, promotional, status, last_user_id
+			FROM notification_broadcasts WHERE id = $1 FOR UPDATE`, payload.BroadcastID).Scan(
+			&b.Audience, &b.AudienceValue, &b.Title, &b.Message, &b.Promotional, &b.Status, &lastUserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			done = true
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get broadcast: %w", err)
+		}
+		if b.Status == models.BroadcastCompleted {
+			done = true
+			return nil
+		}
+
+		data, err := json.Marshal(map[string]interface{}{"jobId": "broadcast:" + payload.BroadcastID, "broadcastId": payload.BroadcastID})
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification data: %w", err)
+		}
+		var count, skipped int
+		var last sql.NullString
+		err = tx.QueryRowContext(ctx, `
+			WITH batch AS (
+				SELECT u.id, COALESCE(NULLIF(u.full_name, ''), u.username) AS name,
+					NOT $5 OR COALESCE(up.marketing_emails, false) AS accepts,
+					COALESCE(up.notification_mode, 'instant') = 'daily_digest' AS digest
+				FROM users u
+				LEFT JOIN user_preferences up ON up.user_id = u.id
+				WHERE `+broadcastSegment+` AND ($3::uuid IS NULL OR u.id > $3::uuid)
+				ORDER BY u.id
+				LIMIT $4
+			), digested AS (
+				INSERT INTO notification_digest_items (user_id, job_id, type, title, message, data)
+				SELECT id, $6, 'announcement', left(replace($7, '{{name}}', name), 255), replace($8, '{{name}}', name), $9
+				FROM batch WHERE accepts AND digest
+				ON CONFLICT (user_id, job_id) DO NOTHING
+			), notified AS (
+				INSERT INTO notifications (user_id, type, title, message, data)
+				SELECT id, 'announcement', left(replace($7, '{{name}}', name), 255), replace($8, '{{name}}', name), $9
+				FROM batch b WHERE accepts AND NOT digest AND NOT EXISTS (
+					SELECT 1 FROM notifications n WHERE n.user_id = b.id AND n.data->>'jobId' = $6
+				)
+				RETURNING id
+			), published AS (
+				INSERT INTO outbox (event_type, aggregate_id, payload)
+				SELECT $10, id::text, jsonb_build_object('notificationId', id) FROM notified
+			)
+			SELECT COUNT(*), COUNT(*) FILTER (WHERE NOT accepts), MAX(id::text) FROM batch`,
+			b.Audience, b.AudienceValue, lastUserID, broadcastBatchSize, b.Promotional,
+			"broadcast:"+payload.BroadcastID, b.Title, b.Message, string(data), EventNotificationCreated).Scan(&count, &skipped, &last)
+		if err != nil {
+			return fmt.Errorf("failed to send broadcast batch: %w", err)
+		}
+
+		done = count < broadcastBatchSize
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE notification_broadcasts SET
+				sent = sent + $2, skipped = skipped + $3, last_user_id = COALESCE($4::uuid, last_user_id),
+				status = CASE WHEN $5 THEN 'completed' ELSE 'sending' END,
+				completed_at = CASE WHEN $5 THEN NOW() END
+			WHERE id = $1`, payload.BroadcastID, count-skipped, skipped, last, done); err != nil {
+			return fmt.Errorf("failed to update broadcast: %w", err)
+		}
+		return nil
+	})
+	if err != nil || done {
+		return err
+	}
+	// Should this fail, retrying the job sends the next batch all the same
+	return s.queue.Enqueue(ctx, "", JobBroadcastBatch, payload)
+}
+
+func scanBroadcast(row rowScanner) (*models.Broadcast, error) {
+	var b models.Broadcast
+	if err := row.Scan(&b.ID, &b.Key, &b.CreatedBy, &b.Audience, &b.AudienceValue, &b.Title, &b.Message, &b.Promotional,
+		&b.Status, &b.Recipients, &b.Sent, &b.Skipped, &b.CreatedAt, &b.CompletedAt); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
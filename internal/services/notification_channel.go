@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// Notification delivery
+//
+// A notification is persisted in-app first, and the same statement writes
+// EventNotificationCreated to the outbox, so it reaches external channels
+// if and only if it exists, without the business action that caused it
+// waiting on a mail server. The event fans out to one JobNotificationSend
+// per channel, so a channel that is down fails only its own jobs: they are
+// retried by the job worker and dead-lettered, logged and counted in
+// greens_jobs_processed_total{result="dead"} after jobs.MaxAttempts.
+// Deliveries are recorded per channel, so a retried job sends nothing twice.
+
+// EventNotificationCreated is published through the outbox for each in-app
+// notification created
+const EventNotificationCreated = "notification.created"
+
+// JobNotificationSend sends one notification through one external channel
+const JobNotificationSend = "notification.send"
+
+// NotificationCreatedEvent is the payload of EventNotificationCreated
+type NotificationCreatedEvent struct {
+	NotificationID string `json:"notificationId"`
+}
+
+// notificationSendJob is the payload of JobNotificationSend
+type notificationSendJob struct {
+	NotificationID string `json:"notificationId"`
+	Channel        string `json:"channel"`
+}
+
+// NotificationChannel delivers notifications outside the app. A failed Send
+// is retried, so channels report a down provider as an error.
+type NotificationChannel interface {
+	Name() string
+	Send(ctx context.Context, to NotificationRecipient, n ChannelNotification) error
+}
+
+// NotificationRecipient is the user a notification is sent to
+type NotificationRecipient struct {
+	UserID string
+	Name   string
+	Email  string
+}
+
+// ChannelNotification is a notification as external channels send it
+type ChannelNotification struct {
+	ID      string
+	Type    string
+	Title   string
+	Message string
+}
+
+// notificationChannels returns the external channels enabled in cfg
+func notificationChannels(cfg config.NotificationConfig) []NotificationChannel {
+	var channels []NotificationChannel
+	if cfg.SMTP.Host != "" {
+		channels = append(channels, NewSMTPChannel(cfg.SMTP))
+	}
+	return channels
+}
+
+// DispatchNotification is the job handler for EventNotificationCreated,
+// queueing the notification's send through each external channel
+func (s *NotificationService) DispatchNotification(ctx context.Context, job *jobs.Job) error {
+	var event NotificationCreatedEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal notification event: %w", err)
+	}
+	for _, channel := range s.channels {
+		payload := notificationSendJob{NotificationID: event.NotificationID, Channel: channel.Name()}
+		if err := s.queue.Enqueue(ctx, job.ID+":"+channel.Name(), JobNotificationSend, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendNotification is the job handler for JobNotificationSend. Recipients
+// who turned the channel off in their preferences are skipped.
+func (s *NotificationService) SendNotification(ctx context.Context, job *jobs.Job) error {
+	var payload notificationSendJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal notification send: %w", err)
+	}
+	var channel NotificationChannel
+	for _, c := range s.channels {
+		if c.Name() == payload.Channel {
+			channel = c
+		}
+	}
+	if channel == nil {
+		// Disabled since the job was queued
+		return nil
+	}
+
+	var n ChannelNotification
+	var to NotificationRecipient
+	var wanted, delivered bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT n.id, n.type, n.title, COALESCE(n.message, ''), u.id, COALESCE(NULLIF(u.full_name, ''), u.username), u.email,
+			COALESCE(up.email_notifications, true),
+			EXISTS (SELECT 1 FROM notification_deliveries d WHERE d.notification_id = n.id AND d.channel = $2)
+		FROM notifications n
+		JOIN users u ON u.id = n.user_id
+		LEFT JOIN user_preferences up ON up.user_id = n.user_id
+		WHERE n.id = $1`, payload.NotificationID, payload.Channel).Scan(
+		&n.ID, &n.Type, &n.Title, &n.Message, &to.UserID, &to.Name, &to.Email, &wanted, &delivered)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Purged before it was sent
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get notification: %w", err)
+	}
+	if delivered || !wanted || to.Email == "" {
+		return nil
+	}
+
+	if err := channel.Send(ctx, to, n); err != nil {
+		return fmt.Errorf("%s channel failed to send notification: %w", channel.Name(), err)
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO notification_deliveries (notification_id, channel) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, n.ID, channel.Name()); err != nil {
+		return fmt.Errorf("failed to record notification delivery: %w", err)
+	}
+	return nil
+}
+
+// SMTPChannel emails notifications through a mail server, upgrading to TLS
+// when the server offers STARTTLS
+type SMTPChannel struct {
+	host    string
+	addr    string
+	auth    smtp.Auth // nil without a username
+	from    string
+	timeout time.Duration
+}
+
+// NewSMTPChannel creates an email channel sending through cfg's server
+func NewSMTPChannel(cfg config.SMTPConfig) *SMTPChannel {
+	c := &SMTPChannel{
+		host:    cfg.Host,
+		addr:    net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		from:    cfg.From,
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+	}
+	if cfg.Username != "" {
+		c.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return c
+}
+
+// Name returns the channel's name, email
+func (c *SMTPChannel) Name() string {
+	return "email"
+}
+
+// Send emails n to the recipient as plain text
+func (c *SMTPChannel) Send(ctx context.Context, to NotificationRecipient, n ChannelNotification) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	defer utils.TrackTiming(ctx, "external")()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		return fmt.Errorf("failed to greet mail server: %w", err)
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if c.auth != nil {
+		if err := client.Auth(c.auth); err != nil {
+			return fmt.Errorf("failed to authenticate to mail server: %w", err)
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return fmt.Errorf("mail server refused sender: %w", err)
+	}
+	if err := client.Rcpt(to.Email); err != nil {
+		return fmt.Errorf("mail server refused recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start email: %w", err)
+	}
+	if _, err := w.Write(c.message(to, n)); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mail server refused email: %w", err)
+	}
+	return client.Quit()
+}
+
+// message builds the email for n, with CRLF line endings
+func (c *SMTPChannel) message(to NotificationRecipient, n ChannelNotification) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", c.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to.Email)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", n.ID, c.host)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	if to.Name != "" {
+		fmt.Fprintf(&b, "Hi %s,\r\n\r\n", to.Name)
+	}
+	body := n.Message
+	if body == "" {
+		body = n.Title
+	}
+	// Lines starting with a dot are escaped by the DATA writer
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/version"
+)
+
+// ErrOpenAINotConfigured is returned when no OpenAI API key is set
+var ErrOpenAINotConfigured = errors.New("openai api key not configured")
+
+// ValidateOpenAIConfig checks that the OpenAI configuration is usable. The
+// key format is always checked; when StartupCheck is enabled the configured
+// model is also fetched, which confirms the key without spending tokens.
+func ValidateOpenAIConfig(ctx context.Context, cfg config.OpenAIConfig) error {
+	if cfg.APIKey == "" {
+		return ErrOpenAINotConfigured
+	}
+	if !strings.HasPrefix(cfg.APIKey, "sk-") {
+		return errors.New("openai api key has an unexpected format")
+	}
+	if cfg.Model == "" {
+		return errors.New("openai model not configured")
+	}
+	if !cfg.StartupCheck {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	url := strings.TrimRight(cfg.BaseURL, "/") + "/models/" + cfg.Model
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build openai request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach openai: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return errors.New("openai rejected the api key")
+	case http.StatusNotFound:
+		return fmt.Errorf("openai model %q is not available", cfg.Model)
+	default:
+		return fmt.Errorf("openai model check returned status %d", resp.StatusCode)
+	}
+}
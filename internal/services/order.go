@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// orderViewNoteLimit is the number of most recent customer-visible notes
+// embedded in the order view; older notes are paged through ListNotes
+const orderViewNoteLimit = 20
+
+// EventOrderStatusChanged is published through the outbox when an order's status changes
+const EventOrderStatusChanged = "order.status_changed"
+
+var (
+	ErrOrderNotFound          = errors.New("order not found")
+	ErrOrderForbidden         = errors.New("insufficient permissions for order")
+	ErrInvalidOrderTransition = errors.New("invalid order status transition")
+)
+
+// orderTransitions lists the statuses each order status may move to
+var orderTransitions = map[string][]string{
+	"pending": {"paid", "cancelled"},
+	"paid":    {"shipped", "cancelled"},
+	"shipped": {"delivered"},
+	"review":  {"cancelled"}, // held for fraud review; admins release it to pending
+}
+
+// OrderStatusChangedEvent is the payload of EventOrderStatusChanged
+type OrderStatusChangedEvent struct {
+	OrderID        string    `json:"orderId"`
+	OrderReference string    `json:"orderReference"`
+	BuyerID        string    `json:"buyerId"`
+	FromStatus     string    `json:"fromStatus"`
+	ToStatus       string    `json:"toStatus"`
+	ChangedBy      string    `json:"changedBy"`
+	ChangedAt      time.Time `json:"changedAt"`
+	RecipientEmail string    `json:"recipientEmail,omitempty"` // gift recipient to notify as well as the buyer
+	SubOrderID     string    `json:"subOrderId,omitempty"`     // set when one seller's sub-order changed
+}
+
+// orderLabel names an order to its buyer by its reference, or by its number
+// for events published before orders had references
+func orderLabel(reference string, number int64) string {
+	if reference != "" {
+		return reference
+	}
+	return fmt.Sprintf("#%d", number)
+}
+
+// OrderService handles order business logic
+type OrderService struct {
+	db        *database.PostgresDB
+	redis     *database.RedisClient
+	inventory *InventoryService
+	holds     *CartHolds
+	shipping  *ShippingService
+	tax       *TaxService
+	payments  *PaymentMethodService
+	fraud     *FraudService
+
+	backorderETADays int
+	preorderCharge   string
+	selfPurchase     string
+	unpaidTimeout    int
+	unpaidTimeouts   map[string]int
+	returnWindowDays int
+}
+
+// NewOrderService creates a new order service. Orders take and return stock
+// through inventory, leaving what other carts hold through holds, and ship
+// by the methods of shipping, taxed by tax. Buyers pay with their cards
+// stored in payments. Orders are held for review when fraud scores them
+// high enough. orders sets whether sellers may order their own products and
+// how long orders may stay unpaid, and returns how long after delivery
+// items may be returned.
+func NewOrderService(db *database.PostgresDB, redis *database.RedisClient, inventory *InventoryService, holds *CartHolds, shipping *ShippingService, tax *TaxService, payments *PaymentMethodService, fraud *FraudService, cfg config.InventoryConfig, orders config.OrdersConfig, returns config.ReturnsConfig) *OrderService {
+	return &OrderService{db: db, redis: redis, inventory: inventory, holds: holds, shipping: shipping, tax: tax, payments: payments, fraud: fraud,
+		backorderETADays: cfg.BackorderETADays, preorderCharge: cfg.PreorderCharge, selfPurchase: orders.SelfPurchase,
+		unpaidTimeout: orders.UnpaidTimeout, unpaidTimeouts: orders.UnpaidTimeouts, returnWindowDays: returns.WindowDays}
+}
+
+// Get returns an order with its items and recent customer-visible notes.
+// Orders are visible to their buyer, sellers with items on the order, and staff.
+func (s *OrderService) Get(ctx context.Context, id, userID string, isStaff bool) (*models.Order, error) {
+	if err := s.authorizeView(ctx, id, userID, isStaff); err != nil {
+		return nil, err
+	}
+
+	var o models.Order
+	var shippingAddress []byte
+	var currency string
+	var gift models.OrderGift
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, order_number, reference, buyer_id, COALESCE(status, 'pending'), COALESCE(payment_status, 'pending'),
+			subtotal_cents, discount_cents, tax_cents, shipping_cents, total_cents,
+			COALESCE(currency, 'USD'), shipping_address, COALESCE(shipping_method, ''), COALESCE(payment_method, ''),
+			COALESCE(payment_method_id::text, ''), COALESCE(to_char(requested_delivery_date, 'YYYY-MM-DD'), ''), is_gift, COALESCE(gift_recipient_name, ''), COALESCE(gift_recipient_email, ''), COALESCE(gift_message, ''),
+			gift_notify_recipient, created_at, updated_at
+		FROM orders WHERE id = $1`, id).Scan(
+		&o.ID, &o.OrderNumber, &o.Reference, &o.BuyerID, &o.Status, &o.PaymentStatus,
+		&o.Subtotal.Amount, &o.Discount.Amount, &o.Tax.Amount, &o.Shipping.Amount, &o.Total.Amount,
+		&currency, &shippingAddress, &o.ShippingMethod, &o.PaymentMethod,
+		&o.PaymentMethodID, &o.DeliveryDate, &o.IsGift, &gift.RecipientName, &gift.RecipientEmail, &gift.Message,
+		&gift.NotifyRecipient, &o.CreatedAt, &o.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	o.ShippingAddress = shippingAddress
+	if o.IsGift {
+		o.Gift = &gift
+	}
+	o.Subtotal.Currency, o.Discount.Currency, o.Tax.Currency, o.Total.Currency = currency, currency, currency, currency
+	o.Shipping.Currency = currency
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, product_id, unit_type, quantity, price_cents, total_cents, regular_price_cents, discount_cents,
+			fulfilled_at, COALESCE(carrier, ''), COALESCE(tracking_number, ''), COALESCE(sub_order_id::text, ''),
+			backordered_at IS NOT NULL AND cancelled_at IS NULL, preorder,
+			CASE WHEN cancelled_at IS NULL THEN COALESCE(to_char(backorder_eta, 'YYYY-MM-DD'), '') ELSE '' END, cancelled_at
+		FROM order_items WHERE order_id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	defer rows.Close()
+
+	o.Items = []models.OrderItem{}
+	for rows.Next() {
+		var item models.OrderItem
+		if err := rows.Scan(&item.ID, &item.ProductID, &item.UnitType, &item.Quantity, &item.Price.Amount, &item.TotalPrice.Amount,
+			&item.RegularPrice.Amount, &item.Discount.Amount,
+			&item.FulfilledAt, &item.Carrier, &item.TrackingNumber, &item.SubOrderID,
+			&item.Backordered, &item.Preorder, &item.BackorderETA, &item.CancelledAt); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		item.Weight = models.LineWeight(item.UnitType, item.Quantity)
+		item.Price.Currency, item.TotalPrice.Currency = currency, currency
+		item.RegularPrice.Currency, item.Discount.Currency = currency, currency
+		o.Items = append(o.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	if o.Discounts, err = discountBreakdown(currency, o.Items); err != nil {
+		return nil, err
+	}
+
+	if o.AddOns, err = s.listOrderAddOns(ctx, id, currency); err != nil {
+		return nil, err
+	}
+	if o.SubOrders, err = s.listSubOrders(ctx, id, currency); err != nil {
+		return nil, err
+	}
+
+	notes, err := s.listNotes(ctx, id, false, orderViewNoteLimit, 0)
+	if err != nil {
+		return nil, err
+	}
+	o.Notes = notes.Notes
+	if o.Delivery, err = s.orderDelivery(ctx, id); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// discountBreakdown fills in the sale discount of each of items and totals
+// the items' discounts
+func discountBreakdown(currency string, items []models.OrderItem) (models.DiscountBreakdown, error) {
+	b := models.DiscountBreakdown{RegularSubtotal: money.Zero(currency), Sale: money.Zero(currency), Order: money.Zero(currency)}
+	for i := range items {
+		item := &items[i]
+		regularTotal, err := models.LineTotal(item.RegularPrice, item.UnitType, item.Quantity)
+		if err == nil {
+			item.SaleDiscount, err = regularTotal.Sub(item.TotalPrice)
+		}
+		if err == nil {
+			b.RegularSubtotal, err = b.RegularSubtotal.Add(regularTotal)
+		}
+		if err == nil {
+			b.Sale, err = b.Sale.Add(item.SaleDiscount)
+		}
+		if err == nil {
+			b.Order, err = b.Order.Add(item.Discount)
+		}
+		if err != nil {
+			return b, fmt.Errorf("failed to total order discounts: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// UpdateStatus moves an order to status, publishing EventOrderStatusChanged
+// through the outbox in the same transaction. Staff and sellers on the order
+// may advance it; buyers may only cancel. Cancelling returns the stock the
+// order took. On an order split between sellers, sellers advance their own
+// sub-orders instead, and the order's sub-orders follow its status.
+func (s *OrderService) UpdateStatus(ctx context.Context, id, userID string, isStaff bool, status string) (*models.Order, error) {
+	if err := s.authorizeView(ctx, id, userID, isStaff); err != nil {
+		return nil, err
+	}
+
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if !isStaff && order.buyerID == userID && status != "cancelled" {
+			return ErrOrderForbidden
+		}
+		if !isStaff && order.buyerID != userID {
+			if err := checkSoleSeller(ctx, tx, id, userID); err != nil {
+				return err
+			}
+		}
+		categoryIDs, err = s.transition(ctx, tx, order, status, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return s.Get(ctx, id, userID, isStaff)
+}
+
+// checkSoleSeller checks that sellerID is the only seller on order id. An
+// order split between sellers is advanced through its sub-orders, so a
+// seller moving the order itself gets ErrOrderForbidden.
+func checkSoleSeller(ctx context.Context, tx *sql.Tx, id, sellerID string) error {
+	var otherSellers bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM sub_orders WHERE order_id = $1 AND seller_id::text <> $2)`,
+		id, sellerID).Scan(&otherSellers); err != nil {
+		return fmt.Errorf("failed to get sub-orders: %w", err)
+	}
+	if otherSellers {
+		return ErrOrderForbidden
+	}
+	return nil
+}
+
+// transition moves locked order to status on behalf of actorID, within tx,
+// returning the categories of listings whose stock it returned
+func (s *OrderService) transition(ctx context.Context, tx *sql.Tx, order lockedOrder, status, actorID string) ([]string, error) {
+	if !slices.Contains(orderTransitions[order.status], status) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidOrderTransition, order.status, status)
+	}
+	if status == "paid" {
+		if err := s.checkPreordersFilled(ctx, tx, order.id); err != nil {
+			return nil, err
+		}
+	}
+
+	// Payment is captured once against the order
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE orders SET status = $2, payment_status = CASE WHEN $2 = 'paid' THEN 'paid' ELSE payment_status END
+		WHERE id = $1`, order.id, status); err != nil {
+		return nil, fmt.Errorf("failed to update order status: %w", err)
+	}
+	if status == "paid" {
+		order.paymentStatus = "paid"
+	}
+	var categoryIDs []string
+	if status == "cancelled" {
+		var err error
+		if categoryIDs, err = s.inventory.releaseOrderStock(ctx, tx, order.id, actorID); err != nil {
+			return nil, err
+		}
+	}
+	if err := appendOrderStatusEvent(ctx, tx, order, status, actorID); err != nil {
+		return nil, err
+	}
+	released, err := s.cascadeOrderStatus(ctx, tx, order, status, actorID)
+	if err != nil {
+		return nil, err
+	}
+	categoryIDs = append(categoryIDs, released...)
+	if status == "cancelled" && order.paid() {
+		if err := refundAddOns(ctx, tx, order, actorID); err != nil {
+			return nil, err
+		}
+	}
+	if err := WriteOutbox(ctx, tx, EventOrderStatusChanged, order.id, OrderStatusChangedEvent{
+		OrderID:        order.id,
+		OrderReference: order.reference,
+		BuyerID:        order.buyerID,
+		FromStatus:     order.status,
+		ToStatus:       status,
+		ChangedBy:      actorID,
+		ChangedAt:      time.Now(),
+		RecipientEmail: order.recipientEmail,
+	}); err != nil {
+		return nil, err
+	}
+	return categoryIDs, nil
+}
+
+// AddNote appends a note to an order. Customer-visible notes may be added by
+// anyone who can view the order; internal notes are restricted to staff.
+func (s *OrderService) AddNote(ctx context.Context, orderID, authorID string, isStaff bool, input models.OrderNoteInput) (*models.OrderNote, error) {
+	visibility := input.Visibility
+	if visibility == "" {
+		visibility = models.NoteVisibilityCustomer
+	}
+	if visibility == models.NoteVisibilityInternal && !isStaff {
+		return nil, ErrOrderForbidden
+	}
+	if err := s.authorizeView(ctx, orderID, authorID, isStaff); err != nil {
+		return nil, err
+	}
+
+	var note models.OrderNote
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO order_notes (order_id, author_id, visibility, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, order_id, author_id, visibility, body, created_at`,
+		orderID, authorID, visibility, input.Body).Scan(
+		&note.ID, &note.OrderID, &note.AuthorID, &note.Visibility, &note.Body, &note.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add order note: %w", err)
+	}
+	return &note, nil
+}
+
+// ListNotes returns a page of an order's notes, newest first. Internal notes
+// are only included for staff.
+func (s *OrderService) ListNotes(ctx context.Context, orderID, userID string, isStaff bool, limit, offset int) (*models.OrderNotePage, error) {
+	if err := s.authorizeView(ctx, orderID, userID, isStaff); err != nil {
+		return nil, err
+	}
+	return s.listNotes(ctx, orderID, isStaff, limit, offset)
+}
+
+// PackingSlip returns the packing slip of an order, for anyone who can view
+// it. A gift's slip is addressed to the recipient, carries the gift message
+// and has no prices. Add-ons are listed after the items.
+func (s *OrderService) PackingSlip(ctx context.Context, id, userID string, isStaff bool) (*models.PackingSlip, error) {
+	if err := s.authorizeView(ctx, id, userID, isStaff); err != nil {
+		return nil, err
+	}
+
+	var slip models.PackingSlip
+	var shipTo []byte
+	var total money.Money
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, order_number, reference, shipping_address, is_gift, COALESCE(gift_recipient_name, ''), COALESCE(gift_message, ''),
+			total_cents, COALESCE(currency, 'USD'), created_at
+		FROM orders WHERE id = $1`, id).Scan(
+		&slip.OrderID, &slip.OrderNumber, &slip.Reference, &shipTo, &slip.IsGift, &slip.RecipientName, &slip.GiftMessage,
+		&total.Amount, &total.Currency, &slip.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	slip.ShipTo = shipTo
+	if !slip.IsGift {
+		slip.Total = &total
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT oi.product_id, p.title, COALESCE(p.sku, ''), oi.unit_type, oi.quantity, oi.price_cents, oi.total_cents
+		FROM order_items oi
+		JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = $1 AND oi.cancelled_at IS NULL
+		ORDER BY p.title, oi.id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	defer rows.Close()
+
+	slip.Items = []models.PackingSlipItem{}
+	for rows.Next() {
+		var item models.PackingSlipItem
+		price, lineTotal := money.Zero(total.Currency), money.Zero(total.Currency)
+		if err := rows.Scan(&item.ProductID, &item.Title, &item.SKU, &item.UnitType, &item.Quantity, &price.Amount, &lineTotal.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		item.Weight = models.LineWeight(item.UnitType, item.Quantity)
+		if !slip.IsGift {
+			item.Price, item.TotalPrice = &price, &lineTotal
+		}
+		slip.Items = append(slip.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+
+	addOns, err := s.listOrderAddOns(ctx, id, total.Currency)
+	if err != nil {
+		return nil, err
+	}
+	slip.AddOns = make([]models.PackingSlipAddOn, len(addOns))
+	for i, a := range addOns {
+		slip.AddOns[i] = models.PackingSlipAddOn{Name: a.Name, Quantity: a.Quantity}
+		if !slip.IsGift {
+			slip.AddOns[i].Price, slip.AddOns[i].TotalPrice = &addOns[i].Price, &addOns[i].TotalPrice
+		}
+	}
+	return &slip, nil
+}
+
+func (s *OrderService) listNotes(ctx context.Context, orderID string, includeInternal bool, limit, offset int) (*models.OrderNotePage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, order_id, author_id, visibility, body, created_at, COUNT(*) OVER() AS total
+		FROM order_notes
+		WHERE order_id = $1 AND ($2 OR visibility = 'customer')
+		ORDER BY created_at DESC, id
+		LIMIT $3 OFFSET $4`, orderID, includeInternal, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list order notes: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.OrderNotePage{Notes: []models.OrderNote{}}
+	for rows.Next() {
+		var note models.OrderNote
+		if err := rows.Scan(&note.ID, &note.OrderID, &note.AuthorID, &note.Visibility, &note.Body, &note.CreatedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan order note: %w", err)
+		}
+		page.Notes = append(page.Notes, note)
+	}
+	return page, rows.Err()
+}
+
+// authorizeView checks that userID may view order id. Users unrelated to the
+// order get ErrOrderNotFound so order IDs cannot be probed.
+func (s *OrderService) authorizeView(ctx context.Context, id, userID string, isStaff bool) error {
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT $3 OR o.buyer_id::text = $2 OR EXISTS (
+			SELECT 1 FROM order_items oi JOIN products p ON p.id = oi.product_id
+			WHERE oi.order_id = o.id AND p.seller_id::text = $2
+		)
+		FROM orders o WHERE o.id = $1`, id, userID, isStaff).Scan(&allowed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+	if !allowed {
+		return ErrOrderNotFound
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Add-ons
+//
+// Add-ons are paid extras, like gift wrapping or a greeting card, that the
+// buyer picks at checkout from the set admins offer. They take no stock and
+// belong to no seller, so they are ordered on the order rather than on a
+// sub-order: an order's totals are its sub-orders' plus its add-ons'. They
+// count toward the subtotal like lines but not toward a free-shipping
+// threshold, and take no share of seller discounts or shipping. Each is
+// refunded on its own, and cancelling a paid order refunds them with it.
+// Payouts are the sellers' sub-orders only.
+
+var (
+	ErrAddOnNotFound      = errors.New("add-on not found")
+	ErrOrderAddOnNotFound = errors.New("order add-on not found")
+)
+
+// addOnLine is an add-on being checked out
+type addOnLine struct {
+	addOnID  string
+	name     string
+	quantity int
+	price    money.Money
+	total    money.Money
+}
+
+// priceAddOns prices the add-ons of inputs for an order in currency within
+// tx. Add-ons that aren't offered, are priced in another currency or are
+// ordered more of than allowed fail with a *validators.ValidationError.
+func priceAddOns(ctx context.Context, tx *sql.Tx, currency string, inputs []models.OrderAddOnInput) ([]addOnLine, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(inputs))
+	for i, input := range inputs {
+		ids[i] = strings.ToLower(input.AddOnID)
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, name, price_cents, currency, max_quantity
+		FROM addons WHERE id = ANY($1) AND is_active`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get add-ons: %w", err)
+	}
+	type offered struct {
+		name        string
+		price       money.Money
+		maxQuantity int
+	}
+	found := make(map[string]offered, len(ids))
+	for rows.Next() {
+		var id string
+		var a offered
+		if err := rows.Scan(&id, &a.name, &a.price.Amount, &a.price.Currency, &a.maxQuantity); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan add-on: %w", err)
+		}
+		found[id] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get add-ons: %w", err)
+	}
+
+	lines := make([]addOnLine, len(inputs))
+	var invalid []validators.FieldError
+	for i, input := range inputs {
+		a, ok := found[ids[i]]
+		quantity := max(input.Quantity, 1)
+		switch {
+		case !ok:
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("addOns[%d].addOnId", i), Code: "not_found", Message: "add-on is not offered",
+			})
+		case a.price.Currency != currency:
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("addOns[%d].addOnId", i), Code: "currency", Param: a.price.Currency,
+				Message: fmt.Sprintf("add-on is priced in %s, not %s", a.price.Currency, currency),
+			})
+		case quantity > a.maxQuantity:
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("addOns[%d].quantity", i), Code: "lte", Param: fmt.Sprint(a.maxQuantity),
+				Message: fmt.Sprintf("quantity must be at most %d", a.maxQuantity),
+			})
+		default:
+			total, err := a.price.Mul(int64(quantity))
+			if err != nil {
+				return nil, fmt.Errorf("failed to total add-on: %w", err)
+			}
+			lines[i] = addOnLine{addOnID: ids[i], name: a.name, quantity: quantity, price: a.price, total: total}
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, &validators.ValidationError{Fields: invalid}
+	}
+	return lines, nil
+}
+
+// addAddOns adds an order's add-ons to its totals. They belong to no seller,
+// so the sellers' totals are left as they are.
+func (t *cartTotals) addAddOns(currency string, addOns []addOnLine) error {
+	if len(addOns) == 0 {
+		return nil
+	}
+	totals := make([]money.Money, len(addOns))
+	for i, a := range addOns {
+		totals[i] = a.total
+	}
+	subtotal, err := money.Sum(currency, totals...)
+	if err != nil {
+		return fmt.Errorf("failed to total add-ons: %w", err)
+	}
+	zero := money.Zero(currency)
+	addOnTotals, err := newTotals(subtotal, zero, zero, zero)
+	if err != nil {
+		return err
+	}
+	t.order, err = sumTotals(currency, append(slices.Clone(t.sellers), addOnTotals))
+	return err
+}
+
+// quoteAddOns returns addOns as a quote shows them
+func quoteAddOns(addOns []addOnLine) []models.OrderAddOn {
+	quoted := make([]models.OrderAddOn, len(addOns))
+	for i, a := range addOns {
+		quoted[i] = models.OrderAddOn{
+			AddOnID: a.addOnID, Name: a.name, Quantity: a.quantity, Price: a.price, TotalPrice: a.total,
+			Refunded: money.Zero(a.price.Currency),
+		}
+	}
+	return quoted
+}
+
+// saveAddOns records addOns on order orderID within tx
+func saveAddOns(ctx context.Context, tx *sql.Tx, orderID string, addOns []addOnLine) error {
+	for _, a := range addOns {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO order_addons (order_id, addon_id, name, quantity, price_cents, total_cents)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			orderID, a.addOnID, a.name, a.quantity, a.price.Amount, a.total.Amount); err != nil {
+			return fmt.Errorf("failed to create order add-on: %w", err)
+		}
+	}
+	return nil
+}
+
+// listOrderAddOns returns the add-ons of order orderID, in the order they
+// were picked
+func (s *OrderService) listOrderAddOns(ctx context.Context, orderID, currency string) ([]models.OrderAddOn, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, addon_id, name, quantity, price_cents, total_cents, refunded_cents
+		FROM order_addons WHERE order_id = $1
+		ORDER BY created_at, id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order add-ons: %w", err)
+	}
+	defer rows.Close()
+
+	addOns := []models.OrderAddOn{}
+	for rows.Next() {
+		a := models.OrderAddOn{Price: money.Zero(currency), TotalPrice: money.Zero(currency), Refunded: money.Zero(currency)}
+		if err := rows.Scan(&a.ID, &a.AddOnID, &a.Name, &a.Quantity, &a.Price.Amount, &a.TotalPrice.Amount, &a.Refunded.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan order add-on: %w", err)
+		}
+		addOns = append(addOns, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order add-ons: %w", err)
+	}
+	return addOns, nil
+}
+
+// RefundAddOn refunds input.Amount of add-on addOnID of paid order orderID,
+// or all of it not yet refunded. Only staff may refund.
+func (s *OrderService) RefundAddOn(ctx context.Context, orderID, addOnID, userID string, isStaff bool, input models.RefundInput) (*models.Order, error) {
+	if !isStaff {
+		return nil, ErrOrderForbidden
+	}
+
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if !order.paid() {
+			return ErrOrderNotPaid
+		}
+		var total, refunded int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT total_cents, refunded_cents FROM order_addons WHERE id = $1 AND order_id = $2 FOR UPDATE`,
+			addOnID, orderID).Scan(&total, &refunded)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderAddOnNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get order add-on: %w", err)
+		}
+
+		remaining := money.New(total-refunded, order.currency)
+		amount := remaining
+		if input.Amount != nil {
+			amount = *input.Amount
+		}
+		if err := checkRefundAmount(amount, remaining, order.currency); err != nil {
+			return err
+		}
+		return refundAddOn(ctx, tx, order, addOnID, amount, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, orderID, userID, isStaff)
+}
+
+// refundAddOns refunds what is left of every add-on of order within tx, as
+// the order is cancelled
+func refundAddOns(ctx context.Context, tx *sql.Tx, order lockedOrder, actorID string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, total_cents - refunded_cents FROM order_addons
+		WHERE order_id = $1 AND refunded_cents < total_cents
+		ORDER BY id FOR UPDATE`, order.id)
+	if err != nil {
+		return fmt.Errorf("failed to get order add-ons: %w", err)
+	}
+	remaining := make(map[string]int64)
+	var ids []string
+	for rows.Next() {
+		var id string
+		var amount int64
+		if err := rows.Scan(&id, &amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan order add-on: %w", err)
+		}
+		ids = append(ids, id)
+		remaining[id] = amount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get order add-ons: %w", err)
+	}
+	for _, id := range ids {
+		if err := refundAddOn(ctx, tx, order, id, money.New(remaining[id], order.currency), actorID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refundAddOn records a refund of amount against add-on addOnID of order,
+// publishing EventOrderRefunded
+func refundAddOn(ctx context.Context, tx *sql.Tx, order lockedOrder, addOnID string, amount money.Money, actorID string) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE order_addons SET refunded_cents = refunded_cents + $2 WHERE id = $1`, addOnID, amount.Amount); err != nil {
+		return fmt.Errorf("failed to refund order add-on: %w", err)
+	}
+	paymentStatus, err := updateRefundStatus(ctx, tx, order.id)
+	if err != nil {
+		return err
+	}
+	if err := appendOrderEvent(ctx, tx, order.id, models.OrderEventRefunded, actorID, orderRefundEvent{
+		AddOnID: addOnID, Amount: amount, PaymentStatus: paymentStatus,
+	}); err != nil {
+		return err
+	}
+	return WriteOutbox(ctx, tx, EventOrderRefunded, order.id, OrderRefundedEvent{
+		OrderID:    order.id,
+		AddOnID:    addOnID,
+		BuyerID:    order.buyerID,
+		Amount:     amount,
+		RefundedBy: actorID,
+		RefundedAt: time.Now(),
+	})
+}
+
+// ListAddOns returns the add-ons, by name: those offered at checkout, or
+// all of them with includeInactive
+func (s *OrderService) ListAddOns(ctx context.Context, includeInactive bool) ([]models.AddOn, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+addOnColumns+` FROM addons
+		WHERE is_active OR $1
+		ORDER BY name, id`, includeInactive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list add-ons: %w", err)
+	}
+	defer rows.Close()
+
+	addOns := []models.AddOn{}
+	for rows.Next() {
+		a, err := scanAddOn(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan add-on: %w", err)
+		}
+		addOns = append(addOns, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list add-ons: %w", err)
+	}
+	return addOns, nil
+}
+
+// CreateAddOn adds an add-on to those admins offer
+func (s *OrderService) CreateAddOn(ctx context.Context, input models.AddOnInput) (*models.AddOn, error) {
+	if err := checkAddOn(input); err != nil {
+		return nil, err
+	}
+	a, err := scanAddOn(s.db.QueryRowContext(ctx, `
+		INSERT INTO addons (name, description, price_cents, currency, max_quantity, is_active)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+		RETURNING `+addOnColumns,
+		input.Name, input.Description, input.Price.Amount, input.Price.Currency, max(input.MaxQuantity, 1), addOnActive(input)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create add-on: %w", err)
+	}
+	return a, nil
+}
+
+// UpdateAddOn replaces add-on id. Orders keep the name and price they were
+// placed with; deactivating an add-on only stops it being offered.
+func (s *OrderService) UpdateAddOn(ctx context.Context, id string, input models.AddOnInput) (*models.AddOn, error) {
+	if err := checkAddOn(input); err != nil {
+		return nil, err
+	}
+	a, err := scanAddOn(s.db.QueryRowContext(ctx, `
+		UPDATE addons SET name = $2, description = NULLIF($3, ''), price_cents = $4, currency = $5, max_quantity = $6,
+			is_active = $7, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+addOnColumns,
+		id, input.Name, input.Description, input.Price.Amount, input.Price.Currency, max(input.MaxQuantity, 1), addOnActive(input)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAddOnNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update add-on: %w", err)
+	}
+	return a, nil
+}
+
+const addOnColumns = `id, name, COALESCE(description, ''), price_cents, currency, max_quantity, is_active, created_at, updated_at`
+
+func scanAddOn(row rowScanner) (*models.AddOn, error) {
+	var a models.AddOn
+	if err := row.Scan(&a.ID, &a.Name, &a.Description, &a.Price.Amount, &a.Price.Currency, &a.MaxQuantity, &a.IsActive,
+		&a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// checkAddOn checks that an add-on has a price
+func checkAddOn(input models.AddOnInput) error {
+	if input.Price.Amount <= 0 || input.Price.Currency == "" {
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "price", Code: "gt", Param: "0", Message: "price must be greater than 0",
+		}}}
+	}
+	return nil
+}
+
+func addOnActive(input models.AddOnInput) bool {
+	return input.IsActive == nil || *input.IsActive
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Backorders
+//
+// Checkout with allowBackorder orders lines short of stock without taking
+// their stock. FillBackorders takes it once it is back, oldest backorder
+// first, after which the line ships like any other. Until then its seller
+// may move the date it is expected, or cancel it when it can't be supplied,
+// which refunds it. The buyer is notified of each. Preorders are backorders
+// too, filled only once their product is available.
+
+// EventBackorderUpdated is published when a backordered order item is
+// filled, expected later or earlier, or cancelled
+const EventBackorderUpdated = "order.backorder_updated"
+
+// How a backorder changed
+const (
+	BackorderFilled      = "filled"
+	BackorderRescheduled = "rescheduled"
+	BackorderCancelled   = "cancelled"
+)
+
+// backorderFillBatch is the number of backordered items read per query
+// while filling
+const backorderFillBatch = 100
+
+var ErrItemNotBackordered = errors.New("order item is not backordered")
+
+// BackorderEvent is the payload of EventBackorderUpdated
+type BackorderEvent struct {
+	OrderID        string       `json:"orderId"`
+	OrderNumber    int64        `json:"orderNumber"`
+	OrderReference string       `json:"orderReference"`
+	BuyerID        string       `json:"buyerId"`
+	ItemID         string       `json:"itemId"`
+	ProductID      string       `json:"productId"`
+	Title          string       `json:"title"`
+	Change         string       `json:"change"`
+	Preorder       bool         `json:"preorder,omitempty"`
+	ETA            string       `json:"eta,omitempty"`       // when rescheduled
+	Refund         *money.Money `json:"refund,omitempty"`    // when cancelled
+	ChangedBy      string       `json:"changedBy,omitempty"` // empty when filled
+}
+
+// backorderedItem is a backordered order item locked for update
+type backorderedItem struct {
+	id         string
+	productID  string
+	title      string
+	sellerID   string
+	subOrderID string
+	quantity   int
+	isBundle   bool
+	preorder   bool
+	refundable int64 // its total less its discount
+}
+
+// lockBackorderedItem locks item itemID of order orderID within tx, failing
+// with ErrItemNotBackordered unless it is still backordered
+func lockBackorderedItem(ctx context.Context, tx *sql.Tx, orderID, itemID string) (backorderedItem, error) {
+	item := backorderedItem{id: itemID}
+	var backordered bool
+	var productType string
+	err := tx.QueryRowContext(ctx, `
+		SELECT oi.product_id, p.title, p.seller_id, COALESCE(oi.sub_order_id::text, ''), oi.quantity, p.product_type,
+			oi.total_cents - oi.discount_cents, oi.preorder,
+			oi.backordered_at IS NOT NULL AND oi.cancelled_at IS NULL AND oi.fulfilled_at IS NULL
+		FROM order_items oi
+		JOIN products p ON p.id = oi.product_id
+		WHERE oi.id = $1 AND oi.order_id = $2
+		FOR UPDATE OF oi`, itemID, orderID).Scan(
+		&item.productID, &item.title, &item.sellerID, &item.subOrderID, &item.quantity, &productType,
+		&item.refundable, &item.preorder, &backordered)
+	if errors.Is(err, sql.ErrNoRows) {
+		return item, ErrOrderItemNotFound
+	}
+	if err != nil {
+		return item, fmt.Errorf("failed to get order item: %w", err)
+	}
+	if !backordered {
+		return item, ErrItemNotBackordered
+	}
+	item.isBundle = productType == models.ProductTypeBundle
+	return item, nil
+}
+
+// SetBackorderETA sets when backordered item itemID of order orderID is
+// expected, and tells the buyer. Only the seller of the item's product and
+// staff may.
+func (s *OrderService) SetBackorderETA(ctx context.Context, orderID, itemID, userID string, isStaff bool, input models.BackorderInput) (*models.Order, error) {
+	eta, err := time.Parse(time.DateOnly, input.ExpectedAt)
+	if err != nil || eta.Before(time.Now().Truncate(24*time.Hour)) {
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "expectedAt", Code: "date", Message: "expectedAt must be a date (YYYY-MM-DD) no earlier than today",
+		}}}
+	}
+	if err := s.authorizeView(ctx, orderID, userID, isStaff); err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		item, err := lockBackorderedItem(ctx, tx, orderID, itemID)
+		if err != nil {
+			return err
+		}
+		if !isStaff && userID != item.sellerID {
+			return ErrOrderForbidden
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_items SET backorder_eta = $2 WHERE id = $1`, itemID, input.ExpectedAt); err != nil {
+			return fmt.Errorf("failed to update backorder: %w", err)
+		}
+		return WriteOutbox(ctx, tx, EventBackorderUpdated, orderID, BackorderEvent{
+			OrderID: orderID, OrderNumber: order.number, OrderReference: order.reference, BuyerID: order.buyerID,
+			ItemID: itemID, ProductID: item.productID, Title: item.title,
+			Change: BackorderRescheduled, ETA: input.ExpectedAt, ChangedBy: userID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, orderID, userID, isStaff)
+}
+
+// CancelBackorder cancels backordered item itemID of paid order orderID when
+// it can't be supplied, refunding the item from its sub-order. It took no
+// stock, so none comes back. A sub-order left with nothing to ship is
+// shipped when others of its items have, and cancelled otherwise. Only the
+// seller of the item's product and staff may.
+func (s *OrderService) CancelBackorder(ctx context.Context, orderID, itemID, userID string, isStaff bool) (*models.Order, error) {
+	if err := s.authorizeView(ctx, orderID, userID, isStaff); err != nil {
+		return nil, err
+	}
+
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		item, err := lockBackorderedItem(ctx, tx, orderID, itemID)
+		if err != nil {
+			return err
+		}
+		if !isStaff && userID != item.sellerID {
+			return ErrOrderForbidden
+		}
+		if !order.paid() {
+			return ErrOrderNotPaid
+		}
+		if item.subOrderID == "" {
+			return fmt.Errorf("%w: order has no sub-orders", ErrOrderNotFulfillable)
+		}
+		sub, err := lockSubOrder(ctx, tx, orderID, item.subOrderID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_items SET cancelled_at = NOW() WHERE id = $1`, itemID); err != nil {
+			return fmt.Errorf("failed to cancel backorder: %w", err)
+		}
+		refund := money.New(min(item.refundable, sub.total-sub.refunded), order.currency)
+		if refund.Amount > 0 {
+			if err := refundSubOrder(ctx, tx, order, sub, refund, userID); err != nil {
+				return err
+			}
+			sub.refunded += refund.Amount
+		}
+		if err := WriteOutbox(ctx, tx, EventBackorderUpdated, orderID, BackorderEvent{
+			OrderID: orderID, OrderNumber: order.number, OrderReference: order.reference, BuyerID: order.buyerID,
+			ItemID: itemID, ProductID: item.productID, Title: item.title,
+			Change: BackorderCancelled, Refund: &refund, ChangedBy: userID,
+		}); err != nil {
+			return err
+		}
+
+		var unshipped, shipped int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FILTER (WHERE fulfilled_at IS NULL), COUNT(*) FILTER (WHERE fulfilled_at IS NOT NULL)
+			FROM order_items WHERE sub_order_id = $1 AND cancelled_at IS NULL`, sub.id).Scan(&unshipped, &shipped)
+		if err != nil {
+			return fmt.Errorf("failed to count unfulfilled order items: %w", err)
+		}
+		switch {
+		case unshipped > 0 || sub.status != "paid":
+			return nil
+		case shipped > 0:
+			return shipRemainder(ctx, tx, order, sub, 0, userID)
+		}
+		if categoryIDs, err = s.cancelSubOrder(ctx, tx, order, sub, userID); err != nil {
+			return err
+		}
+		return rollUpOrderStatus(ctx, tx, order, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return s.Get(ctx, orderID, userID, isStaff)
+}
+
+// FillBackorders takes the stock of backordered items of live orders that
+// can now be filled, oldest backorder first, and tells their buyers.
+// Preorders wait until their product is available from its date. Each
+// item is filled in its own transaction. Backorders were bought before
+// anything now in a cart, so stock held in carts doesn't keep them waiting.
+// It returns how many items were filled.
+func (s *OrderService) FillBackorders(ctx context.Context) (int, error) {
+	filled := 0
+	var afterAt time.Time
+	afterID := "00000000-0000-0000-0000-000000000000"
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT oi.id, oi.order_id, oi.backordered_at
+			FROM order_items oi
+			JOIN orders o ON o.id = oi.order_id
+			JOIN products p ON p.id = oi.product_id
+			LEFT JOIN sub_orders so ON so.id = oi.sub_order_id
+			WHERE oi.backordered_at IS NOT NULL AND oi.cancelled_at IS NULL
+				AND COALESCE(o.status, 'pending') IN ('pending', 'paid') AND so.status IS DISTINCT FROM 'cancelled'
+				AND NOT (oi.preorder AND `+productPreorderAt("NOW()")+`)
+				AND (oi.backordered_at, oi.id) > ($1, $2)
+			ORDER BY oi.backordered_at, oi.id
+			LIMIT $3`, afterAt, afterID, backorderFillBatch)
+		if err != nil {
+			return filled, fmt.Errorf("failed to list backorders: %w", err)
+		}
+		type backorder struct{ itemID, orderID string }
+		var batch []backorder
+		for rows.Next() {
+			var b backorder
+			if err := rows.Scan(&b.itemID, &b.orderID, &afterAt); err != nil {
+				rows.Close()
+				return filled, fmt.Errorf("failed to scan backorder: %w", err)
+			}
+			batch = append(batch, b)
+			afterID = b.itemID
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return filled, fmt.Errorf("failed to list backorders: %w", err)
+		}
+
+		for _, b := range batch {
+			ok, err := s.fillBackorder(ctx, b.orderID, b.itemID)
+			if err != nil {
+				return filled, err
+			}
+			if ok {
+				filled++
+			}
+		}
+		if len(batch) < backorderFillBatch {
+			return filled, nil
+		}
+	}
+}
+
+// fillBackorder takes the stock of backordered item itemID of order orderID
+// if there is enough, reporting whether it did
+func (s *OrderService) fillBackorder(ctx context.Context, orderID, itemID string) (bool, error) {
+	filled := false
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.status != "pending" && order.status != "paid" {
+			return nil
+		}
+		item, err := lockBackorderedItem(ctx, tx, orderID, itemID)
+		if errors.Is(err, ErrItemNotBackordered) {
+			// Filled or cancelled since it was listed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		categoryIDs, _, err = s.inventory.consumeOrderStock(ctx, tx, orderID, order.buyerID, []orderLine{{
+			productID: item.productID, quantity: item.quantity, isBundle: item.isBundle, referenceID: item.subOrderID,
+		}}, nil, false)
+		var verr *validators.ValidationError
+		if errors.As(err, &verr) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_items SET backordered_at = NULL, backorder_eta = NULL WHERE id = $1`, itemID); err != nil {
+			return fmt.Errorf("failed to fill backorder: %w", err)
+		}
+		if err := appendOrderEvent(ctx, tx, orderID, models.OrderEventReserved, "", map[string]interface{}{
+			"itemId": itemID, "productId": item.productID, "quantity": item.quantity,
+		}); err != nil {
+			return err
+		}
+		filled = true
+		if err := WriteOutbox(ctx, tx, EventBackorderUpdated, orderID, BackorderEvent{
+			OrderID: orderID, OrderNumber: order.number, OrderReference: order.reference, BuyerID: order.buyerID,
+			ItemID: itemID, ProductID: item.productID, Title: item.title, Change: BackorderFilled, Preorder: item.preorder,
+		}); err != nil {
+			return err
+		}
+		if !item.preorder || order.status != "pending" || s.preorderCharge != PreorderChargeShip {
+			return nil
+		}
+		if pending, err := preordersPending(ctx, tx, orderID); err != nil || pending {
+			return err
+		}
+		return WriteOutbox(ctx, tx, EventPreordersFilled, orderID, PreordersFilledEvent{
+			OrderID: orderID, OrderNumber: order.number, OrderReference: order.reference, BuyerID: order.buyerID,
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return filled, nil
+}
+
+// RunBackorderFills runs FillBackorders as a scheduled task
+func (s *OrderService) RunBackorderFills(ctx context.Context) error {
+	n, err := s.FillBackorders(ctx)
+	if n > 0 {
+		log.Info().Int("items", n).Msg("Filled backorders")
+	}
+	if err != nil {
+		return fmt.Errorf("backorder fill failed: %w", err)
+	}
+	return nil
+}
+
+// NotifyBackorder is the job handler for EventBackorderUpdated, notifying
+// the buyer of what happened to their backordered item
+func (s *OrderService) NotifyBackorder(ctx context.Context, job *jobs.Job) error {
+	var event BackorderEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal backorder event: %w", err)
+	}
+	var message string
+	order := orderLabel(event.OrderReference, event.OrderNumber)
+	switch event.Change {
+	case BackorderFilled:
+		message = fmt.Sprintf("%s on order %s is back in stock and will ship soon", event.Title, order)
+		if event.Preorder {
+			message = fmt.Sprintf("%s on order %s is now available and will ship soon", event.Title, order)
+		}
+	case BackorderRescheduled:
+		message = fmt.Sprintf("%s on order %s is now expected by %s", event.Title, order, event.ETA)
+	case BackorderCancelled:
+		message = fmt.Sprintf("%s on order %s can't be supplied and was cancelled", event.Title, order)
+		if event.Refund != nil && event.Refund.Amount > 0 {
+			message += "; " + event.Refund.Decimal() + " " + event.Refund.Currency + " will be refunded"
+		}
+	default:
+		return fmt.Errorf("unknown backorder change %q", event.Change)
+	}
+	data := map[string]interface{}{"orderId": event.OrderID, "itemId": event.ItemID, "change": event.Change}
+	if event.ETA != "" {
+		data["eta"] = event.ETA
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.BuyerID, "backorder", "Backorder update", message, data)
+}
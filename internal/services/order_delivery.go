@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Proof of delivery
+//
+// Whoever delivers a shipped order locally, its seller or staff, confirms
+// it with a proof of delivery: optionally a photo, kept in blob storage
+// under a key only the order's viewers are given a route to, and the
+// recipient's signature and notes. Confirming moves the order to delivered
+// like any status change, so the buyer is notified and their reviews of it
+// count as verified purchases, and records when and by whom.
+
+var ErrDeliveryPhotoNotFound = errors.New("delivery photo not found")
+
+// Deliver confirms shipped order id delivered by userID with input's proof,
+// moving it to delivered. Staff and the order's only seller may deliver
+// it; buyers may not.
+func (s *OrderService) Deliver(ctx context.Context, id, userID string, isStaff bool, input models.DeliveryInput) (*models.Order, error) {
+	if err := s.authorizeView(ctx, id, userID, isStaff); err != nil {
+		return nil, err
+	}
+
+	var photo models.DeliveryPhoto
+	if input.Photo != nil {
+		photo = *input.Photo
+	}
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !isStaff {
+			if order.buyerID == userID {
+				return ErrOrderForbidden
+			}
+			if err := checkSoleSeller(ctx, tx, id, userID); err != nil {
+				return err
+			}
+		}
+		if _, err := s.transition(ctx, tx, order, "delivered", userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO order_deliveries (order_id, delivered_by, photo_key, photo_content_type, photo_size, signature, notes)
+			VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, 0), NULLIF($6, ''), NULLIF($7, ''))`,
+			id, userID, photo.Key, photo.ContentType, photo.Size,
+			strings.TrimSpace(input.Signature), strings.TrimSpace(input.Notes)); err != nil {
+			return fmt.Errorf("failed to record delivery: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id, userID, isStaff)
+}
+
+// DeliveryPhoto returns the photo proving order id was delivered, if
+// userID may view the order
+func (s *OrderService) DeliveryPhoto(ctx context.Context, id, userID string, isStaff bool) (*models.DeliveryPhoto, error) {
+	if err := s.authorizeView(ctx, id, userID, isStaff); err != nil {
+		return nil, err
+	}
+	var photo models.DeliveryPhoto
+	err := s.db.QueryRowContext(ctx, `
+		SELECT photo_key, photo_content_type, photo_size FROM order_deliveries
+		WHERE order_id = $1 AND photo_key IS NOT NULL`, id).Scan(&photo.Key, &photo.ContentType, &photo.Size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeliveryPhotoNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery photo: %w", err)
+	}
+	return &photo, nil
+}
+
+// orderDelivery returns the proof of delivery of order id, or nil when it
+// has none
+func (s *OrderService) orderDelivery(ctx context.Context, id string) (*models.OrderDelivery, error) {
+	var d models.OrderDelivery
+	var deliveredBy, signature, notes sql.NullString
+	var hasPhoto bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT delivered_by, delivered_at, photo_key IS NOT NULL, signature, notes
+		FROM order_deliveries WHERE order_id = $1`, id).Scan(&deliveredBy, &d.DeliveredAt, &hasPhoto, &signature, &notes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order delivery: %w", err)
+	}
+	d.DeliveredBy, d.Signature, d.Notes = deliveredBy.String, signature.String, notes.String
+	if hasPhoto {
+		d.PhotoURL = "/api/v1/orders/" + id + "/delivery/photo"
+	}
+	return &d, nil
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Order events
+//
+// Every transaction that changes an order appends what it did to the
+// order's event log, in the same transaction and with the order locked, so
+// each order's events are numbered 1, 2, 3... in the order they happened.
+// The log is append-only; the database refuses to change or delete an
+// event. Folding an order's events in sequence gives its status and payment
+// status: created starts it pending, events named after a status move it
+// there, paid marks it paid and refunded takes the payment status the
+// refund left; held moves it to review and released back to pending. A
+// scheduled check folds every order and flags those whose stored state has
+// drifted from their events.
+
+// orderEventStatuses are the order statuses events move orders to
+var orderEventStatuses = map[string]bool{
+	models.OrderEventPaid:      true,
+	models.OrderEventShipped:   true,
+	models.OrderEventDelivered: true,
+	models.OrderEventCancelled: true,
+}
+
+// appendOrderEvent appends an event to the log of order orderID, which tx
+// must have created or locked, with data marshalled as its details.
+// actorID may be empty for events the system caused.
+func appendOrderEvent(ctx context.Context, tx *sql.Tx, orderID, eventType, actorID string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal order event: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO order_events (order_id, sequence, event_type, actor_id, data)
+		SELECT $1, COALESCE(MAX(sequence), 0) + 1, $2, NULLIF($3, '')::uuid, $4
+		FROM order_events WHERE order_id = $1`,
+		orderID, eventType, actorID, string(encoded)); err != nil {
+		return fmt.Errorf("failed to append order event: %w", err)
+	}
+	return nil
+}
+
+// appendOrderStatusEvent appends the event of locked order moving to status
+func appendOrderStatusEvent(ctx context.Context, tx *sql.Tx, order lockedOrder, status, actorID string) error {
+	return appendOrderEvent(ctx, tx, order.id, status, actorID, map[string]string{"from": order.status})
+}
+
+// orderRefundEvent is the data of an OrderEventRefunded event
+type orderRefundEvent struct {
+	SubOrderID    string      `json:"subOrderId,omitempty"`
+	AddOnID       string      `json:"addOnId,omitempty"`
+	Amount        money.Money `json:"amount"`
+	PaymentStatus string      `json:"paymentStatus"` // the order's, after the refund
+}
+
+// foldOrderEvent applies event to state
+func foldOrderEvent(state models.OrderState, eventType string, data []byte) models.OrderState {
+	switch {
+	case eventType == models.OrderEventCreated:
+		state = models.OrderState{Status: "pending", PaymentStatus: "pending"}
+	case eventType == models.OrderEventHeld:
+		state.Status = models.OrderStatusReview
+	case eventType == models.OrderEventReleased:
+		state.Status = "pending"
+	case eventType == models.OrderEventRefunded:
+		var refund orderRefundEvent
+		if err := json.Unmarshal(data, &refund); err == nil && refund.PaymentStatus != "" {
+			state.PaymentStatus = refund.PaymentStatus
+		}
+	case orderEventStatuses[eventType]:
+		state.Status = eventType
+		if eventType == models.OrderEventPaid {
+			state.PaymentStatus = "paid"
+		}
+	}
+	return state
+}
+
+// OrderEvents returns the event log of order orderID, with the state it
+// folds to and the state stored on the order
+func (s *OrderService) OrderEvents(ctx context.Context, orderID string) (*models.OrderEventLog, error) {
+	eventLog := &models.OrderEventLog{OrderID: orderID, Events: []models.OrderEvent{}}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(status, 'pending'), COALESCE(payment_status, 'pending') FROM orders WHERE id = $1`,
+		orderID).Scan(&eventLog.Stored.Status, &eventLog.Stored.PaymentStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sequence, event_type, actor_id, data, created_at
+		FROM order_events
+		WHERE order_id = $1
+		ORDER BY sequence`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list order events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.OrderEvent
+		var data []byte
+		if err := rows.Scan(&e.Sequence, &e.Type, &e.ActorID, &data, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan order event: %w", err)
+		}
+		e.Data = data
+		eventLog.Events = append(eventLog.Events, e)
+		eventLog.Folded = foldOrderEvent(eventLog.Folded, e.Type, data)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list order events: %w", err)
+	}
+	eventLog.Consistent = eventLog.Folded == eventLog.Stored
+	return eventLog, nil
+}
+
+// CheckOrderEvents folds the events of every order and logs each order
+// whose stored status or payment status differs from its folded state,
+// returning how many do
+func (s *OrderService) CheckOrderEvents(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.id, COALESCE(o.status, 'pending'), COALESCE(o.payment_status, 'pending'), e.event_type, e.data
+		FROM orders o
+		LEFT JOIN order_events e ON e.order_id = o.id
+		ORDER BY o.id, e.sequence`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check order events: %w", err)
+	}
+	defer rows.Close()
+
+	inconsistent := 0
+	var orderID string
+	var stored, folded models.OrderState
+	flush := func() {
+		if orderID != "" && folded != stored {
+			log.Error().Str("order_id", orderID).Str("status", stored.Status).Str("payment_status", stored.PaymentStatus).
+				Str("folded_status", folded.Status).Str("folded_payment_status", folded.PaymentStatus).
+				Msg("Order state does not match its events")
+			inconsistent++
+		}
+	}
+	for rows.Next() {
+		var id string
+		var state models.OrderState
+		var eventType sql.NullString
+		var data []byte
+		if err := rows.Scan(&id, &state.Status, &state.PaymentStatus, &eventType, &data); err != nil {
+			return inconsistent, fmt.Errorf("failed to scan order events: %w", err)
+		}
+		if id != orderID {
+			flush()
+			orderID, stored, folded = id, state, models.OrderState{}
+		}
+		if eventType.Valid {
+			folded = foldOrderEvent(folded, eventType.String, data)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return inconsistent, fmt.Errorf("failed to check order events: %w", err)
+	}
+	flush()
+	return inconsistent, nil
+}
+
+// RunOrderEventChecks runs CheckOrderEvents as a scheduled task
+func (s *OrderService) RunOrderEventChecks(ctx context.Context) error {
+	n, err := s.CheckOrderEvents(ctx)
+	if err != nil {
+		return fmt.Errorf("order event check failed: %w", err)
+	}
+	if n > 0 {
+		log.Warn().Int("orders", n).Msg("Order event check found discrepancies")
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/jobs"
+)
+
+// Unpaid orders
+//
+// An order left pending past its payment timeout, counted from checkout or
+// from its release from fraud review, is cancelled by a scheduled sweep through the usual cancellation, which
+// returns the stock it took, and its buyer is told. The timeout depends on
+// the order's payment method. When orders are charged at ship, those with
+// preorders are charged once filled rather than by the buyer, so they are
+// left alone. Each order is cancelled under its row lock, which Pay charges
+// under too: a payment committed first keeps the order, and one coming
+// after finds it cancelled. Sweeps on several replicas may overlap, as an
+// order already cancelled is skipped.
+
+// EventOrderExpired is published when an unpaid order is cancelled for
+// passing its payment timeout
+const EventOrderExpired = "order.expired"
+
+// unpaidOrderBatch is the number of expired orders read per query
+const unpaidOrderBatch = 100
+
+// OrderExpiredEvent is the payload of EventOrderExpired
+type OrderExpiredEvent struct {
+	OrderID        string `json:"orderId"`
+	OrderNumber    int64  `json:"orderNumber"`
+	OrderReference string `json:"orderReference"`
+	BuyerID        string `json:"buyerId"`
+	TimeoutMinutes int    `json:"timeoutMinutes"`
+}
+
+// CancelUnpaidOrders cancels the pending orders past their payment timeout,
+// each in its own transaction, returning how many it cancelled
+func (s *OrderService) CancelUnpaidOrders(ctx context.Context) (int, error) {
+	methods := make([]string, 0, len(s.unpaidTimeouts))
+	minutes := make([]int64, 0, len(s.unpaidTimeouts))
+	for method, m := range s.unpaidTimeouts {
+		methods = append(methods, method)
+		minutes = append(minutes, int64(m))
+	}
+
+	cancelled := 0
+	afterID := "00000000-0000-0000-0000-000000000000"
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT o.id, COALESCE(t.minutes, $3)
+			FROM orders o
+			LEFT JOIN unnest($1::text[], $2::int[]) AS t(method, minutes) ON t.method = o.payment_method
+			WHERE o.status = 'pending' AND COALESCE(t.minutes, $3) > 0
+				AND COALESCE(o.released_at, o.created_at) <= NOW() - COALESCE(t.minutes, $3) * INTERVAL '1 minute'
+				AND NOT ($4 AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.preorder))
+				AND o.id > $5
+			ORDER BY o.id
+			LIMIT $6`, pq.Array(methods), pq.Array(minutes), s.unpaidTimeout, s.preorderCharge == PreorderChargeShip,
+			afterID, unpaidOrderBatch)
+		if err != nil {
+			return cancelled, fmt.Errorf("failed to list unpaid orders: %w", err)
+		}
+		type unpaid struct {
+			orderID string
+			timeout int
+		}
+		var batch []unpaid
+		for rows.Next() {
+			var u unpaid
+			if err := rows.Scan(&u.orderID, &u.timeout); err != nil {
+				rows.Close()
+				return cancelled, fmt.Errorf("failed to scan unpaid order: %w", err)
+			}
+			batch = append(batch, u)
+			afterID = u.orderID
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return cancelled, fmt.Errorf("failed to list unpaid orders: %w", err)
+		}
+
+		for _, u := range batch {
+			ok, err := s.cancelUnpaidOrder(ctx, u.orderID, u.timeout)
+			if err != nil {
+				return cancelled, err
+			}
+			if ok {
+				cancelled++
+			}
+		}
+		if len(batch) < unpaidOrderBatch {
+			return cancelled, nil
+		}
+	}
+}
+
+// cancelUnpaidOrder cancels order orderID, unpaid past its timeout of
+// timeout minutes, if it is still pending, reporting whether it did
+func (s *OrderService) cancelUnpaidOrder(ctx context.Context, orderID string, timeout int) (bool, error) {
+	cancelled := false
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.status != "pending" {
+			// Paid, or cancelled by another sweep, since it was listed
+			return nil
+		}
+		if categoryIDs, err = s.transition(ctx, tx, order, "cancelled", ""); err != nil {
+			return err
+		}
+		cancelled = true
+		return WriteOutbox(ctx, tx, EventOrderExpired, orderID, OrderExpiredEvent{
+			OrderID: orderID, OrderNumber: order.number, OrderReference: order.reference, BuyerID: order.buyerID,
+			TimeoutMinutes: timeout,
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return cancelled, nil
+}
+
+// RunUnpaidOrderCancels runs CancelUnpaidOrders as a scheduled task
+func (s *OrderService) RunUnpaidOrderCancels(ctx context.Context) error {
+	n, err := s.CancelUnpaidOrders(ctx)
+	if n > 0 {
+		log.Info().Int("orders", n).Msg("Cancelled unpaid orders")
+	}
+	if err != nil {
+		return fmt.Errorf("unpaid order cancel failed: %w", err)
+	}
+	return nil
+}
+
+// NotifyOrderExpired is the job handler for EventOrderExpired, telling the
+// buyer their unpaid order was cancelled
+func (s *OrderService) NotifyOrderExpired(ctx context.Context, job *jobs.Job) error {
+	var event OrderExpiredEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal order expired event: %w", err)
+	}
+	message := fmt.Sprintf("Order %s wasn't paid within %s and was cancelled",
+		orderLabel(event.OrderReference, event.OrderNumber), minutesText(event.TimeoutMinutes))
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.BuyerID, "order_expired", "Order cancelled", message,
+		map[string]interface{}{"orderId": event.OrderID})
+}
+
+// minutesText describes a span of minutes in the largest whole unit
+func minutesText(minutes int) string {
+	switch {
+	case minutes%1440 == 0:
+		return pluralize(minutes/1440, [2]string{"day", "days"})
+	case minutes%60 == 0:
+		return pluralize(minutes/60, [2]string{"hour", "hours"})
+	}
+	return pluralize(minutes, [2]string{"minute", "minutes"})
+}
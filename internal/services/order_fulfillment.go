@@ -0,0 +1,446 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Seller fulfillment
+//
+// Each seller ships the items of their own products on an order, one item
+// at a time. Backordered items wait for their stock and can't ship until
+// they are filled. When a seller has shipped all of theirs that can ship the
+// buyer is told that part of the order is on its way, and of when any
+// backordered items are expected. When the last item of the order ships the
+// order moves to shipped like any other status change.
+
+// EventSellerShipped is published when a seller has shipped all their items on an order
+const EventSellerShipped = "order.seller_shipped"
+
+var (
+	ErrOrderItemNotFound   = errors.New("order item not found")
+	ErrItemFulfilled       = errors.New("order item already fulfilled")
+	ErrOrderNotFulfillable = errors.New("order is not awaiting fulfillment")
+	ErrItemBackordered     = errors.New("order item is backordered")
+	ErrItemCancelled       = errors.New("order item was cancelled")
+)
+
+// SellerShippedEvent is the payload of EventSellerShipped
+type SellerShippedEvent struct {
+	OrderID        string   `json:"orderId"`
+	OrderNumber    int64    `json:"orderNumber"`
+	OrderReference string   `json:"orderReference"`
+	BuyerID        string   `json:"buyerId"`
+	SellerID       string   `json:"sellerId"`
+	ItemIDs        []string `json:"itemIds"`  // the seller's items shipped so far
+	Complete       bool     `json:"complete"` // whether this shipment completed the order
+	// The seller's items still backordered, and the latest date expected
+	BackorderedItemIDs []string `json:"backorderedItemIds,omitempty"`
+	BackorderETA       string   `json:"backorderEta,omitempty"`
+}
+
+// FulfillmentQueue returns a page of the orders in statuses (paid when none
+// are given) with items of sellerID's products still to ship, oldest first.
+// Each order carries only the seller's items.
+func (s *OrderService) FulfillmentQueue(ctx context.Context, sellerID string, statuses []string, limit, offset int) (*models.FulfillmentQueue, error) {
+	if len(statuses) == 0 {
+		statuses = []string{"paid"}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.id, o.order_number, o.reference, COALESCE(o.status, 'pending'), o.shipping_address, COALESCE(o.shipping_method, ''),
+			COALESCE(to_char(o.requested_delivery_date, 'YYYY-MM-DD'), ''), o.is_gift,
+			COALESCE(o.gift_recipient_name, ''), COALESCE(o.gift_message, ''), o.created_at, COUNT(*) OVER() AS total
+		FROM orders o
+		WHERE COALESCE(o.status, 'pending') = ANY($2) AND EXISTS (
+			SELECT 1 FROM order_items oi JOIN products p ON p.id = oi.product_id
+			LEFT JOIN sub_orders so ON so.id = oi.sub_order_id
+			WHERE oi.order_id = o.id AND p.seller_id = $1 AND oi.fulfilled_at IS NULL AND oi.cancelled_at IS NULL
+				AND so.status IS DISTINCT FROM 'cancelled'
+		)
+		ORDER BY o.created_at, o.id
+		LIMIT $3 OFFSET $4`, sellerID, pq.Array(statuses), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fulfillment queue: %w", err)
+	}
+	defer rows.Close()
+
+	queue := &models.FulfillmentQueue{Orders: []models.FulfillmentOrder{}}
+	var ids []string
+	for rows.Next() {
+		var order models.FulfillmentOrder
+		var shippingAddress []byte
+		var gift models.OrderGift
+		if err := rows.Scan(&order.ID, &order.OrderNumber, &order.Reference, &order.Status, &shippingAddress, &order.ShippingMethod, &order.DeliveryDate, &order.IsGift,
+			&gift.RecipientName, &gift.Message, &order.CreatedAt, &queue.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		order.ShippingAddress = shippingAddress
+		if order.IsGift {
+			order.Gift = &gift
+		}
+		order.Items = []models.FulfillmentItem{}
+		queue.Orders = append(queue.Orders, order)
+		ids = append(ids, order.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list fulfillment queue: %w", err)
+	}
+	if len(ids) == 0 {
+		return queue, nil
+	}
+
+	items, err := s.db.QueryContext(ctx, `
+		SELECT oi.id, oi.order_id, oi.product_id, p.title, COALESCE(p.sku, ''), oi.unit_type, oi.quantity,
+			oi.fulfilled_at, COALESCE(oi.carrier, ''), COALESCE(oi.tracking_number, ''),
+			oi.backordered_at IS NOT NULL, oi.preorder, COALESCE(to_char(oi.backorder_eta, 'YYYY-MM-DD'), '')
+		FROM order_items oi
+		JOIN products p ON p.id = oi.product_id
+		LEFT JOIN sub_orders so ON so.id = oi.sub_order_id
+		WHERE oi.order_id = ANY($1) AND p.seller_id = $2 AND oi.cancelled_at IS NULL
+			AND so.status IS DISTINCT FROM 'cancelled'
+		ORDER BY p.title, oi.id`, pq.Array(ids), sellerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	defer items.Close()
+
+	byOrder := make(map[string]*models.FulfillmentOrder, len(queue.Orders))
+	for i := range queue.Orders {
+		byOrder[queue.Orders[i].ID] = &queue.Orders[i]
+	}
+	for items.Next() {
+		var item models.FulfillmentItem
+		if err := items.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Title, &item.SKU, &item.UnitType, &item.Quantity,
+			&item.FulfilledAt, &item.Carrier, &item.TrackingNumber, &item.Backordered, &item.Preorder, &item.BackorderETA); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		item.Weight = models.LineWeight(item.UnitType, item.Quantity)
+		order := byOrder[item.OrderID]
+		order.Items = append(order.Items, item)
+	}
+	if err := items.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	return queue, nil
+}
+
+// FulfillItem marks item itemID of paid order orderID shipped by sellerID,
+// who must be the seller of the item's product. Backordered and cancelled
+// items can't ship. Shipping the last of a seller's items on the order that
+// can ship publishes EventSellerShipped with any of theirs still
+// backordered; once none are, it also ships their sub-order, and shipping
+// the order's last item moves the order to shipped.
+func (s *OrderService) FulfillItem(ctx context.Context, orderID, itemID, sellerID string, input models.FulfillmentInput) (*models.FulfillmentItem, error) {
+	if err := s.authorizeView(ctx, orderID, sellerID, false); err != nil {
+		return nil, err
+	}
+
+	var item models.FulfillmentItem
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.status != "paid" {
+			return fmt.Errorf("%w: order is %s", ErrOrderNotFulfillable, order.status)
+		}
+
+		var itemSellerID string
+		var fulfilledAt sql.NullTime
+		var backordered, cancelled bool
+		var sub lockedSubOrder
+		var subOrderID sql.NullString
+		err = tx.QueryRowContext(ctx, `
+			SELECT p.seller_id, oi.fulfilled_at, oi.backordered_at IS NOT NULL, oi.cancelled_at IS NOT NULL,
+				so.id, COALESCE(so.status, '')
+			FROM order_items oi
+			JOIN products p ON p.id = oi.product_id
+			LEFT JOIN sub_orders so ON so.id = oi.sub_order_id
+			WHERE oi.id = $1 AND oi.order_id = $2`, itemID, orderID).Scan(
+			&itemSellerID, &fulfilledAt, &backordered, &cancelled, &subOrderID, &sub.status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderItemNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get order item: %w", err)
+		}
+		if itemSellerID != sellerID {
+			return ErrOrderForbidden
+		}
+		if fulfilledAt.Valid {
+			return ErrItemFulfilled
+		}
+		if cancelled {
+			return ErrItemCancelled
+		}
+		if backordered {
+			return ErrItemBackordered
+		}
+		if subOrderID.Valid && sub.status != "paid" {
+			return fmt.Errorf("%w: sub-order is %s", ErrOrderNotFulfillable, sub.status)
+		}
+		sub.id = subOrderID.String
+
+		err = tx.QueryRowContext(ctx, `
+			UPDATE order_items oi
+			SET fulfilled_at = NOW(), fulfilled_by = $2, carrier = NULLIF($3, ''), tracking_number = NULLIF($4, '')
+			FROM products p
+			WHERE oi.id = $1 AND p.id = oi.product_id
+			RETURNING oi.id, oi.order_id, oi.product_id, p.title, COALESCE(p.sku, ''), oi.unit_type, oi.quantity,
+				oi.fulfilled_at, COALESCE(oi.carrier, ''), COALESCE(oi.tracking_number, '')`,
+			itemID, sellerID, input.Carrier, input.TrackingNumber).Scan(
+			&item.ID, &item.OrderID, &item.ProductID, &item.Title, &item.SKU, &item.UnitType, &item.Quantity,
+			&item.FulfilledAt, &item.Carrier, &item.TrackingNumber)
+		if err != nil {
+			return fmt.Errorf("failed to fulfill order item: %w", err)
+		}
+		item.Weight = models.LineWeight(item.UnitType, item.Quantity)
+		return finishSellerShipment(ctx, tx, order, sub, sellerID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// ShipOrders marks all of sellerID's items that can ship on each of
+// input's paid orders shipped, with the tracking given, in a transaction
+// per order. Orders that can't be shipped are reported in the result's
+// failed list rather than failing the batch: orders without the seller's
+// items are not found, like orders that don't exist, and orders with none
+// left that can ship fail like a single item would. Each shipped order is
+// finished as FulfillItem finishes a seller's last item, notifying the
+// buyer.
+func (s *OrderService) ShipOrders(ctx context.Context, sellerID string, input models.BulkShipInput) (*models.BulkShipResult, error) {
+	result := &models.BulkShipResult{Shipped: []models.BulkShipped{}, Failed: []models.BulkShipFailure{}}
+	for _, entry := range input.Orders {
+		itemIDs, err := s.shipSellerItems(ctx, entry, sellerID)
+		if err == nil {
+			result.Shipped = append(result.Shipped, models.BulkShipped{OrderID: entry.OrderID, ItemIDs: itemIDs})
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		failure := models.BulkShipFailure{OrderID: entry.OrderID, Code: bulkShipFailureCode(err), Message: err.Error()}
+		if failure.Code == "internal_error" {
+			log.Error().Err(err).Str("order_id", entry.OrderID).Msg("Failed to ship order")
+			failure.Message = "Order could not be shipped"
+		}
+		result.Failed = append(result.Failed, failure)
+	}
+	return result, nil
+}
+
+// shipSellerItems ships sellerID's items on entry's order that can ship,
+// returning their IDs
+func (s *OrderService) shipSellerItems(ctx context.Context, entry models.BulkShipOrder, sellerID string) ([]string, error) {
+	var shipped []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, entry.OrderID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT oi.id, oi.fulfilled_at IS NOT NULL, oi.backordered_at IS NOT NULL, oi.cancelled_at IS NOT NULL,
+				so.id, COALESCE(so.status, '')
+			FROM order_items oi
+			JOIN products p ON p.id = oi.product_id
+			LEFT JOIN sub_orders so ON so.id = oi.sub_order_id
+			WHERE oi.order_id = $1 AND p.seller_id = $2
+			ORDER BY oi.id`, entry.OrderID, sellerID)
+		if err != nil {
+			return fmt.Errorf("failed to get order items: %w", err)
+		}
+		var sub lockedSubOrder
+		var shippable []string
+		var owned, fulfilled, backordered int
+		for rows.Next() {
+			var id string
+			var isFulfilled, isBackordered, isCancelled bool
+			var subOrderID sql.NullString
+			var subStatus string
+			if err := rows.Scan(&id, &isFulfilled, &isBackordered, &isCancelled, &subOrderID, &subStatus); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan order item: %w", err)
+			}
+			owned++
+			if subOrderID.Valid {
+				sub.id, sub.status = subOrderID.String, subStatus
+			}
+			switch {
+			case isCancelled:
+			case isFulfilled:
+				fulfilled++
+			case isBackordered:
+				backordered++
+			default:
+				shippable = append(shippable, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get order items: %w", err)
+		}
+
+		switch {
+		case owned == 0:
+			return ErrOrderNotFound
+		case order.status != "paid":
+			return fmt.Errorf("%w: order is %s", ErrOrderNotFulfillable, order.status)
+		case sub.id != "" && sub.status != "paid":
+			return fmt.Errorf("%w: sub-order is %s", ErrOrderNotFulfillable, sub.status)
+		case len(shippable) == 0 && backordered > 0:
+			return ErrItemBackordered
+		case len(shippable) == 0 && fulfilled > 0:
+			return ErrItemFulfilled
+		case len(shippable) == 0:
+			return ErrItemCancelled
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_items
+			SET fulfilled_at = NOW(), fulfilled_by = $2, carrier = NULLIF($3, ''), tracking_number = NULLIF($4, '')
+			WHERE id = ANY($1)`, pq.Array(shippable), sellerID, entry.Carrier, entry.TrackingNumber); err != nil {
+			return fmt.Errorf("failed to fulfill order items: %w", err)
+		}
+		shipped = shippable
+		return finishSellerShipment(ctx, tx, order, sub, sellerID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shipped, nil
+}
+
+// bulkShipFailureCode returns the error code a failed order of a bulk
+// shipment is reported with, matching what shipping it alone would answer
+func bulkShipFailureCode(err error) string {
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		return "not_found"
+	case errors.Is(err, ErrOrderNotFulfillable):
+		return "not_fulfillable"
+	case errors.Is(err, ErrItemFulfilled):
+		return "already_fulfilled"
+	case errors.Is(err, ErrItemBackordered):
+		return "backordered"
+	case errors.Is(err, ErrItemCancelled):
+		return "cancelled"
+	default:
+		return "internal_error"
+	}
+}
+
+// finishSellerShipment runs after sellerID ships items of order. Once none
+// of theirs that can ship are left it publishes EventSellerShipped with any
+// of theirs still backordered, and once none are backordered either it
+// ships their sub-order, or the order when it has none.
+func finishSellerShipment(ctx context.Context, tx *sql.Tx, order lockedOrder, sub lockedSubOrder, sellerID string) error {
+	// Items of cancelled sub-orders, and cancelled items, are never shipped
+	var sellerShippable, remaining int
+	var sellerItemIDs, backorderedIDs []string
+	var backorderETA string
+	err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE p.seller_id = $2 AND oi.fulfilled_at IS NULL AND oi.backordered_at IS NULL),
+			COUNT(*) FILTER (WHERE oi.fulfilled_at IS NULL),
+			COALESCE(array_agg(oi.id) FILTER (WHERE p.seller_id = $2 AND oi.fulfilled_at IS NOT NULL), '{}'),
+			COALESCE(array_agg(oi.id) FILTER (WHERE p.seller_id = $2 AND oi.backordered_at IS NOT NULL), '{}'),
+			COALESCE(to_char(MAX(oi.backorder_eta) FILTER (WHERE p.seller_id = $2 AND oi.backordered_at IS NOT NULL), 'YYYY-MM-DD'), '')
+		FROM order_items oi
+		JOIN products p ON p.id = oi.product_id
+		LEFT JOIN sub_orders so ON so.id = oi.sub_order_id
+		WHERE oi.order_id = $1 AND oi.cancelled_at IS NULL AND so.status IS DISTINCT FROM 'cancelled'`,
+		order.id, sellerID).Scan(&sellerShippable, &remaining, pq.Array(&sellerItemIDs), pq.Array(&backorderedIDs), &backorderETA)
+	if err != nil {
+		return fmt.Errorf("failed to count unfulfilled order items: %w", err)
+	}
+	if sellerShippable > 0 {
+		return nil
+	}
+
+	if err := WriteOutbox(ctx, tx, EventSellerShipped, order.id, SellerShippedEvent{
+		OrderID:            order.id,
+		OrderNumber:        order.number,
+		OrderReference:     order.reference,
+		BuyerID:            order.buyerID,
+		SellerID:           sellerID,
+		ItemIDs:            sellerItemIDs,
+		Complete:           remaining == 0,
+		BackorderedItemIDs: backorderedIDs,
+		BackorderETA:       backorderETA,
+	}); err != nil {
+		return err
+	}
+	if len(backorderedIDs) > 0 {
+		return nil
+	}
+	return shipRemainder(ctx, tx, order, sub, remaining, sellerID)
+}
+
+// shipRemainder ships sub once its seller has shipped all its items, rolling
+// the order up, or for an order without sub-orders ships the order once
+// remaining, its items left to ship, is none
+func shipRemainder(ctx context.Context, tx *sql.Tx, order lockedOrder, sub lockedSubOrder, remaining int, sellerID string) error {
+	if sub.id != "" {
+		if err := setSubOrderStatus(ctx, tx, order, sub, "shipped", sellerID); err != nil {
+			return err
+		}
+		return rollUpOrderStatus(ctx, tx, order, sellerID)
+	}
+	if remaining > 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = 'shipped' WHERE id = $1`, order.id); err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+	if err := appendOrderStatusEvent(ctx, tx, order, models.OrderEventShipped, sellerID); err != nil {
+		return err
+	}
+	return WriteOutbox(ctx, tx, EventOrderStatusChanged, order.id, OrderStatusChangedEvent{
+		OrderID:        order.id,
+		OrderReference: order.reference,
+		BuyerID:        order.buyerID,
+		FromStatus:     order.status,
+		ToStatus:       "shipped",
+		ChangedBy:      sellerID,
+		ChangedAt:      time.Now(),
+		RecipientEmail: order.recipientEmail,
+	})
+}
+
+// NotifySellerShipped is the job handler for EventSellerShipped, notifying
+// the buyer that a seller's part of their order has shipped
+func (s *OrderService) NotifySellerShipped(ctx context.Context, job *jobs.Job) error {
+	var event SellerShippedEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal seller shipped event: %w", err)
+	}
+	order := orderLabel(event.OrderReference, event.OrderNumber)
+	message := fmt.Sprintf("Part of order %s has shipped", order)
+	switch {
+	case event.Complete:
+		message = fmt.Sprintf("All of order %s has shipped", order)
+	case len(event.BackorderedItemIDs) > 0:
+		message = fmt.Sprintf("Part of order %s has shipped; the rest is backordered and expected by %s",
+			order, event.BackorderETA)
+	}
+	data := map[string]interface{}{"orderId": event.OrderID, "itemIds": event.ItemIDs}
+	if len(event.BackorderedItemIDs) > 0 {
+		data["backorderedItemIds"], data["backorderEta"] = event.BackorderedItemIDs, event.BackorderETA
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.BuyerID, "partial_shipment", "Order shipped", message, data)
+}
@@ -0,0 +1,559 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Customer returns
+//
+// A buyer may ask to return items of a delivered order within their return
+// window, counted in days from the order's delivery: the product's window,
+// else its category's, else the configured default. Items can't be
+// returned beyond what was delivered, counting what earlier returns not
+// rejected already cover. An admin approves the return with the label the
+// buyer ships it back with, or rejects it. Once received, each item is
+// refunded its share of what was paid for it from its sub-order, through
+// the gateway when the order was charged there, and its units are moved to
+// quarantine, apart from sellable stock, until they are inspected. The
+// buyer is told of each step. Returns are changed under their order's row
+// lock, so two requests can't both return the last of an item.
+
+// EventReturnUpdated is published when a return is requested or changes
+// status
+const EventReturnUpdated = "order.return_updated"
+
+var (
+	ErrReturnNotFound          = errors.New("return not found")
+	ErrOrderNotDelivered       = errors.New("order has not been delivered")
+	ErrInvalidReturnTransition = errors.New("invalid return status transition")
+)
+
+// returnTransitions lists the statuses each return status may move to
+var returnTransitions = map[string][]string{
+	models.ReturnRequested: {models.ReturnApproved, models.ReturnRejected},
+	models.ReturnApproved:  {models.ReturnReceived},
+}
+
+// ReturnUpdatedEvent is the payload of EventReturnUpdated
+type ReturnUpdatedEvent struct {
+	ReturnID        string              `json:"returnId"`
+	OrderID         string              `json:"orderId"`
+	OrderNumber     int64               `json:"orderNumber"`
+	OrderReference  string              `json:"orderReference"`
+	BuyerID         string              `json:"buyerId"`
+	Status          string              `json:"status"`
+	Label           *models.ReturnLabel `json:"label,omitempty"`           // when approved
+	RejectionReason string              `json:"rejectionReason,omitempty"` // when rejected
+	Refund          *money.Money        `json:"refund,omitempty"`          // when received
+	ChangedBy       string              `json:"changedBy"`
+	ChangedAt       time.Time           `json:"changedAt"`
+}
+
+// returnColumns are the columns scanReturn reads, from order_returns
+// aliased as r joined to orders aliased as o
+const returnColumns = `r.id, r.order_id, r.status, r.reason, COALESCE(r.comment, ''), r.label_carrier, r.label_tracking_number,
+	r.label_url, COALESCE(r.rejection_reason, ''), r.refund_cents, COALESCE(r.refund_reference, ''), COALESCE(o.currency, 'USD'),
+	r.created_at, r.reviewed_at, r.received_at`
+
+// lockedReturn is a return locked for update
+type lockedReturn struct {
+	id      string
+	orderID string
+	status  string
+}
+
+// RequestReturn asks to return input's items of delivered order orderID
+// for buyerID, its buyer
+func (s *OrderService) RequestReturn(ctx context.Context, orderID, buyerID string, input models.ReturnInput) (*models.OrderReturn, error) {
+	if err := s.authorizeView(ctx, orderID, buyerID, false); err != nil {
+		return nil, err
+	}
+
+	var returnID string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.buyerID != buyerID {
+			return ErrOrderForbidden
+		}
+		if order.status != "delivered" {
+			return ErrOrderNotDelivered
+		}
+
+		items, err := returnableItems(ctx, tx, orderID, s.returnWindowDays)
+		if err != nil {
+			return err
+		}
+		var fields []validators.FieldError
+		itemIDs := make([]string, len(input.Items))
+		quantities := make([]int64, len(input.Items))
+		for i, in := range input.Items {
+			itemIDs[i], quantities[i] = strings.ToLower(in.OrderItemID), int64(in.Quantity)
+			item, ok := items[itemIDs[i]]
+			switch {
+			case !ok:
+				fields = append(fields, validators.FieldError{
+					Field: fmt.Sprintf("items[%d].orderItemId", i), Code: "not_found", Message: "order item not found",
+				})
+			case !item.split:
+				fields = append(fields, validators.FieldError{
+					Field: fmt.Sprintf("items[%d].orderItemId", i), Code: "not_returnable", Message: "item can't be returned",
+				})
+			case !item.inWindow:
+				fields = append(fields, validators.FieldError{
+					Field: fmt.Sprintf("items[%d].orderItemId", i), Code: "return_window", Param: fmt.Sprint(item.windowDays),
+					Message: fmt.Sprintf("item could only be returned within %d days of delivery", item.windowDays),
+				})
+			case in.Quantity > item.returnable:
+				fields = append(fields, validators.FieldError{
+					Field: fmt.Sprintf("items[%d].quantity", i), Code: "max", Param: fmt.Sprint(item.returnable),
+					Message: fmt.Sprintf("at most %d can be returned", item.returnable),
+				})
+			}
+		}
+		if len(fields) > 0 {
+			return &validators.ValidationError{Fields: fields}
+		}
+
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO order_returns (order_id, reason, comment) VALUES ($1, $2, NULLIF($3, ''))
+			RETURNING id`, orderID, input.Reason, input.Comment).Scan(&returnID); err != nil {
+			return fmt.Errorf("failed to create return: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO order_return_items (return_id, order_item_id, quantity)
+			SELECT $1, i.order_item_id, i.quantity FROM unnest($2::uuid[], $3::int[]) AS i(order_item_id, quantity)`,
+			returnID, pq.Array(itemIDs), pq.Array(quantities)); err != nil {
+			return fmt.Errorf("failed to create return items: %w", err)
+		}
+		return writeReturnEvent(ctx, tx, order, ReturnUpdatedEvent{ReturnID: returnID, Status: models.ReturnRequested, ChangedBy: buyerID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.getReturn(ctx, returnID)
+}
+
+// returnableItem is an order item as a return sees it
+type returnableItem struct {
+	split      bool // whether it belongs to a sub-order, which refunds it
+	returnable int  // its quantity less what returns not rejected cover
+	windowDays int
+	inWindow   bool
+}
+
+// returnableItems returns the items of delivered order orderID not
+// cancelled, by ID, with what of them can still be returned. defaultDays
+// is the return window of products whose product and category set none.
+func returnableItems(ctx context.Context, tx *sql.Tx, orderID string, defaultDays int) (map[string]returnableItem, error) {
+	rows, err := tx.QueryContext(ctx, `
+		WITH delivered AS (
+			SELECT MAX(created_at) AS at FROM order_events WHERE order_id = $1 AND event_type = 'delivered'
+		)
+		SELECT oi.id, oi.sub_order_id IS NOT NULL,
+			oi.quantity - COALESCE((
+				SELECT SUM(ri.quantity) FROM order_return_items ri JOIN order_returns r ON r.id = ri.return_id
+				WHERE ri.order_item_id = oi.id AND r.status <> 'rejected'
+			), 0),
+			w.days, COALESCE(d.at, o.updated_at) + w.days * INTERVAL '1 day' > NOW()
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		JOIN products p ON p.id = oi.product_id
+		LEFT JOIN categories c ON c.id = p.category_id
+		CROSS JOIN delivered d
+		CROSS JOIN LATERAL (SELECT COALESCE(p.return_window_days, c.return_window_days, $2) AS days) w
+		WHERE oi.order_id = $1 AND oi.cancelled_at IS NULL`, orderID, defaultDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	defer rows.Close()
+
+	items := make(map[string]returnableItem)
+	for rows.Next() {
+		var id string
+		var item returnableItem
+		if err := rows.Scan(&id, &item.split, &item.returnable, &item.windowDays, &item.inWindow); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+		items[id] = item
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	return items, nil
+}
+
+// ApproveReturn approves requested return returnID, giving the buyer
+// input's label to ship it back with
+func (s *OrderService) ApproveReturn(ctx context.Context, returnID, adminID string, input models.ReturnApprovalInput) (*models.OrderReturn, error) {
+	label := &models.ReturnLabel{Carrier: input.Carrier, TrackingNumber: input.TrackingNumber, URL: input.LabelURL}
+	err := s.updateReturn(ctx, returnID, models.ReturnApproved, func(tx *sql.Tx, order lockedOrder, ret lockedReturn) error {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_returns SET status = 'approved', label_carrier = $2, label_tracking_number = $3, label_url = $4,
+				reviewed_by = $5, reviewed_at = NOW()
+			WHERE id = $1`, ret.id, label.Carrier, label.TrackingNumber, label.URL, adminID); err != nil {
+			return fmt.Errorf("failed to approve return: %w", err)
+		}
+		return writeReturnEvent(ctx, tx, order, ReturnUpdatedEvent{ReturnID: ret.id, Status: models.ReturnApproved, Label: label, ChangedBy: adminID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.getReturn(ctx, returnID)
+}
+
+// RejectReturn rejects requested return returnID for input's reason,
+// leaving its items free to be returned again
+func (s *OrderService) RejectReturn(ctx context.Context, returnID, adminID string, input models.ReturnRejectionInput) (*models.OrderReturn, error) {
+	err := s.updateReturn(ctx, returnID, models.ReturnRejected, func(tx *sql.Tx, order lockedOrder, ret lockedReturn) error {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_returns SET status = 'rejected', rejection_reason = $2, reviewed_by = $3, reviewed_at = NOW()
+			WHERE id = $1`, ret.id, input.Reason, adminID); err != nil {
+			return fmt.Errorf("failed to reject return: %w", err)
+		}
+		return writeReturnEvent(ctx, tx, order, ReturnUpdatedEvent{
+			ReturnID: ret.id, Status: models.ReturnRejected, RejectionReason: input.Reason, ChangedBy: adminID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.getReturn(ctx, returnID)
+}
+
+// ReceiveReturn records approved return returnID as received back,
+// quarantining its items and, when its order was paid, refunding them
+func (s *OrderService) ReceiveReturn(ctx context.Context, returnID, adminID string) (*models.OrderReturn, error) {
+	var refund money.Money
+	var reference string
+	err := s.updateReturn(ctx, returnID, models.ReturnReceived, func(tx *sql.Tx, order lockedOrder, ret lockedReturn) error {
+		refunds, products, quantities, err := s.returnRefunds(ctx, tx, order, ret.id)
+		if err != nil {
+			return err
+		}
+		refund = money.New(0, order.currency)
+		if order.paid() {
+			for _, r := range refunds {
+				sub, err := lockSubOrder(ctx, tx, order.id, r.subOrderID)
+				if err != nil {
+					return err
+				}
+				amount := money.New(min(r.amount, sub.total-sub.refunded), order.currency)
+				if amount.Amount <= 0 {
+					continue
+				}
+				if err := refundSubOrder(ctx, tx, order, sub, amount, adminID); err != nil {
+					return err
+				}
+				refund.Amount += amount.Amount
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE products p SET quarantined_quantity = p.quarantined_quantity + q.quantity, updated_at = NOW()
+			FROM (
+				SELECT product_id, SUM(quantity) AS quantity FROM unnest($1::uuid[], $2::int[]) AS i(product_id, quantity)
+				GROUP BY product_id
+			) q
+			WHERE p.id = q.product_id`, pq.Array(products), pq.Array(quantities)); err != nil {
+			return fmt.Errorf("failed to quarantine returned items: %w", err)
+		}
+		if refund.Amount > 0 {
+			// Last, so nothing after it but the commit can fail
+			if reference, err = s.payments.refund(ctx, tx, order.id, refund, "return-"+ret.id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_returns SET status = 'received', refund_cents = $2, refund_reference = NULLIF($3, ''),
+				received_by = $4, received_at = NOW()
+			WHERE id = $1`, ret.id, refund.Amount, reference, adminID); err != nil {
+			return fmt.Errorf("failed to receive return: %w", err)
+		}
+		return writeReturnEvent(ctx, tx, order, ReturnUpdatedEvent{ReturnID: ret.id, Status: models.ReturnReceived, Refund: &refund, ChangedBy: adminID})
+	})
+	if err != nil && reference != "" {
+		// The gateway refunded the buyer, so a refund we failed to record
+		// needs a person
+		log.Error().Err(err).Str("return_id", returnID).Str("refund_reference", reference).
+			Msg("Refunded return but failed to record it")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.getReturn(ctx, returnID)
+}
+
+// subOrderRefund is what a return refunds from one sub-order
+type subOrderRefund struct {
+	subOrderID string
+	amount     int64
+}
+
+// returnRefunds returns what return returnID of order refunds from each of
+// its sub-orders, each item its paid total in proportion to the quantity
+// returned, and the products and quantities it returns
+func (s *OrderService) returnRefunds(ctx context.Context, tx *sql.Tx, order lockedOrder, returnID string) ([]subOrderRefund, []string, []int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT oi.sub_order_id, oi.product_id, oi.total_cents - oi.discount_cents, oi.quantity, ri.quantity
+		FROM order_return_items ri JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.return_id = $1
+		ORDER BY oi.sub_order_id, oi.id`, returnID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get return items: %w", err)
+	}
+	defer rows.Close()
+
+	var refunds []subOrderRefund
+	var products []string
+	var quantities []int64
+	for rows.Next() {
+		var subOrderID, productID string
+		var paid, ordered, returned int64
+		if err := rows.Scan(&subOrderID, &productID, &paid, &ordered, &returned); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to scan return item: %w", err)
+		}
+		share, err := money.New(paid, order.currency).MulFrac(returned, ordered)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if n := len(refunds); n == 0 || refunds[n-1].subOrderID != subOrderID {
+			refunds = append(refunds, subOrderRefund{subOrderID: subOrderID})
+		}
+		refunds[len(refunds)-1].amount += share.Amount
+		products = append(products, productID)
+		quantities = append(quantities, returned)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get return items: %w", err)
+	}
+	return refunds, products, quantities, nil
+}
+
+// updateReturn locks return returnID and its order and, if the return may
+// move to status, applies update to them
+func (s *OrderService) updateReturn(ctx context.Context, returnID, status string, update func(tx *sql.Tx, order lockedOrder, ret lockedReturn) error) error {
+	return s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		ret := lockedReturn{id: returnID}
+		err := tx.QueryRowContext(ctx, `SELECT order_id FROM order_returns WHERE id = $1`, returnID).Scan(&ret.orderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReturnNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get return: %w", err)
+		}
+		// The order first, as requests lock it before reading its returns
+		order, err := lockOrder(ctx, tx, ret.orderID)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, `
+			SELECT status FROM order_returns WHERE id = $1 FOR UPDATE`, returnID).Scan(&ret.status); err != nil {
+			return fmt.Errorf("failed to get return: %w", err)
+		}
+		if !slices.Contains(returnTransitions[ret.status], status) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidReturnTransition, ret.status, status)
+		}
+		return update(tx, order, ret)
+	})
+}
+
+// writeReturnEvent publishes event for a return of order
+func writeReturnEvent(ctx context.Context, tx *sql.Tx, order lockedOrder, event ReturnUpdatedEvent) error {
+	event.OrderID, event.OrderNumber, event.OrderReference, event.BuyerID = order.id, order.number, order.reference, order.buyerID
+	event.ChangedAt = time.Now()
+	return WriteOutbox(ctx, tx, EventReturnUpdated, event.ReturnID, event)
+}
+
+// ListReturns returns the returns of order orderID, newest first, to those
+// who may view the order
+func (s *OrderService) ListReturns(ctx context.Context, orderID, userID string, isStaff bool) ([]models.OrderReturn, error) {
+	if err := s.authorizeView(ctx, orderID, userID, isStaff); err != nil {
+		return nil, err
+	}
+	page, err := s.listReturns(ctx, `r.order_id = $1`, []interface{}{orderID}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return page.Returns, nil
+}
+
+// SearchReturns returns a page of all returns, newest first, only those
+// with status when it is given
+func (s *OrderService) SearchReturns(ctx context.Context, status string, limit, offset int) (*models.OrderReturnPage, error) {
+	return s.listReturns(ctx, `($1 = '' OR r.status = $1)`, []interface{}{status}, limit, offset)
+}
+
+// getReturn returns return id
+func (s *OrderService) getReturn(ctx context.Context, id string) (*models.OrderReturn, error) {
+	page, err := s.listReturns(ctx, `r.id = $1`, []interface{}{id}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Returns) == 0 {
+		return nil, ErrReturnNotFound
+	}
+	return &page.Returns[0], nil
+}
+
+// listReturns returns the returns matching where, a condition on returns
+// aliased as r with arguments args, with their items, newest first. A limit
+// of 0 returns them all.
+func (s *OrderService) listReturns(ctx context.Context, where string, args []interface{}, limit, offset int) (*models.OrderReturnPage, error) {
+	query := fmt.Sprintf(`
+		SELECT %s, COUNT(*) OVER() AS total
+		FROM order_returns r JOIN orders o ON o.id = r.order_id
+		WHERE %s
+		ORDER BY r.created_at DESC, r.id`, returnColumns, where)
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+		args = append(args, limit, offset)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list returns: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.OrderReturnPage{Returns: []models.OrderReturn{}}
+	index := make(map[string]int)
+	var ids []string
+	for rows.Next() {
+		ret, err := scanReturn(rows, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan return: %w", err)
+		}
+		index[ret.ID] = len(page.Returns)
+		ids = append(ids, ret.ID)
+		page.Returns = append(page.Returns, *ret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list returns: %w", err)
+	}
+	if len(ids) == 0 {
+		return page, nil
+	}
+
+	itemRows, err := s.db.QueryContext(ctx, `
+		SELECT ri.return_id, ri.order_item_id, oi.product_id, ri.quantity
+		FROM order_return_items ri JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.return_id = ANY($1::uuid[])
+		ORDER BY oi.id`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get return items: %w", err)
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var returnID string
+		var item models.OrderReturnItem
+		if err := itemRows.Scan(&returnID, &item.OrderItemID, &item.ProductID, &item.Quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan return item: %w", err)
+		}
+		ret := &page.Returns[index[returnID]]
+		ret.Items = append(ret.Items, item)
+	}
+	if err := itemRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get return items: %w", err)
+	}
+	return page, nil
+}
+
+// scanReturn scans a row of returnColumns followed by the total count
+func scanReturn(rows *sql.Rows, total *int) (*models.OrderReturn, error) {
+	var ret models.OrderReturn
+	var carrier, tracking, labelURL sql.NullString
+	var refundCents sql.NullInt64
+	var currency string
+	if err := rows.Scan(&ret.ID, &ret.OrderID, &ret.Status, &ret.Reason, &ret.Comment, &carrier, &tracking, &labelURL,
+		&ret.RejectionReason, &refundCents, &ret.RefundReference, &currency, &ret.CreatedAt, &ret.ReviewedAt, &ret.ReceivedAt,
+		total); err != nil {
+		return nil, err
+	}
+	ret.Items = []models.OrderReturnItem{}
+	if carrier.Valid {
+		ret.Label = &models.ReturnLabel{Carrier: carrier.String, TrackingNumber: tracking.String, URL: labelURL.String}
+	}
+	if refundCents.Valid {
+		refund := money.New(refundCents.Int64, currency)
+		ret.Refund = &refund
+	}
+	return &ret, nil
+}
+
+// SetProductReturnWindow sets the return window of product productID, nil
+// falling back to its category's
+func (s *OrderService) SetProductReturnWindow(ctx context.Context, productID string, days *int) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE products SET return_window_days = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, productID, days)
+	if err != nil {
+		return fmt.Errorf("failed to set product return window: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// SetCategoryReturnWindow sets the return window of category categoryID's
+// products, nil falling back to the default
+func (s *OrderService) SetCategoryReturnWindow(ctx context.Context, categoryID string, days *int) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE categories SET return_window_days = $2 WHERE id = $1`, categoryID, days)
+	if err != nil {
+		return fmt.Errorf("failed to set category return window: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// NotifyReturnUpdated is the job handler for EventReturnUpdated, telling
+// the buyer where their return stands
+func (s *OrderService) NotifyReturnUpdated(ctx context.Context, job *jobs.Job) error {
+	var event ReturnUpdatedEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal return updated event: %w", err)
+	}
+	var message string
+	order := orderLabel(event.OrderReference, event.OrderNumber)
+	switch event.Status {
+	case models.ReturnRequested:
+		message = fmt.Sprintf("We received your return request for order %s and will review it shortly", order)
+	case models.ReturnApproved:
+		message = fmt.Sprintf("Your return for order %s was approved", order)
+		if event.Label != nil {
+			message += fmt.Sprintf("; ship it with %s using the return label, tracking number %s", event.Label.Carrier, event.Label.TrackingNumber)
+		}
+	case models.ReturnRejected:
+		message = fmt.Sprintf("Your return for order %s was rejected: %s", order, event.RejectionReason)
+	case models.ReturnReceived:
+		message = fmt.Sprintf("Your return for order %s has arrived", order)
+		if event.Refund != nil && event.Refund.Amount > 0 {
+			message += "; " + event.Refund.Decimal() + " " + event.Refund.Currency + " will be refunded"
+		}
+	default:
+		return fmt.Errorf("unknown return status %q", event.Status)
+	}
+	data := map[string]interface{}{"orderId": event.OrderID, "returnId": event.ReturnID, "status": event.Status}
+	if event.Label != nil {
+		data["labelUrl"] = event.Label.URL
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.BuyerID, "order_return", "Return update", message, data)
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+var ErrInvalidOrderFilter = errors.New("invalid order filter")
+
+// orderSort is a keyset-paginated ordering of the admin order search
+type orderSort struct {
+	column string // only these fixed columns are ever interpolated
+	desc   bool
+}
+
+var orderSorts = map[string]orderSort{
+	"":             {column: "o.created_at", desc: true},
+	"created_desc": {column: "o.created_at", desc: true},
+	"created_asc":  {column: "o.created_at"},
+	"total_desc":   {column: "o.total_cents", desc: true},
+	"total_asc":    {column: "o.total_cents"},
+}
+
+// orderCursor marks the last order on a page: its sort value and ID
+type orderCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// adminOrderColumns is the column list matching scanAdminOrder, for queries
+// aliasing orders as o and their buyers as u
+const adminOrderColumns = `o.id, o.order_number, o.reference, COALESCE(o.status, 'pending'), COALESCE(o.payment_status, 'pending'),
+	o.total_cents, COALESCE(o.currency, 'USD'), o.created_at,
+	u.id, u.email, COALESCE(u.full_name, ''),
+	(SELECT COUNT(*) FROM order_items oi WHERE oi.order_id = o.id)`
+
+// orderSearchWhere returns the sort, WHERE clause and arguments selecting
+// the orders matching filter, after its cursor when it has one
+func orderSearchWhere(filter models.AdminOrderFilter) (orderSort, string, []interface{}, error) {
+	sort, ok := orderSorts[filter.Sort]
+	if !ok {
+		return sort, "", nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidOrderFilter, filter.Sort)
+	}
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(format string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	if len(filter.Statuses) > 0 {
+		addCondition("o.status = ANY($%d)", pq.Array(filter.Statuses))
+	}
+	if filter.From != nil {
+		addCondition("o.created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("o.created_at < $%d", *filter.To)
+	}
+	if filter.Email != "" {
+		addCondition("LOWER(u.email) = LOWER($%d)", filter.Email)
+	}
+	if filter.OrderNumber > 0 {
+		addCondition("o.order_number = $%d", filter.OrderNumber)
+	}
+	if filter.Reference != "" {
+		addCondition("o.reference = UPPER($%d)", filter.Reference)
+	}
+	if filter.Query != "" {
+		pattern := "%" + escapeLike(filter.Query) + "%"
+		addCondition("(u.email ILIKE $%d OR u.full_name ILIKE $%d)", pattern, pattern)
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeOrderCursor(filter.Cursor)
+		if err != nil || cursor.Sort != filter.Sort {
+			return sort, "", nil, fmt.Errorf("%w: invalid cursor", ErrInvalidOrderFilter)
+		}
+		op := ">"
+		if sort.desc {
+			op = "<"
+		}
+		cast := "timestamptz"
+		if sort.column == "o.total_cents" {
+			cast = "bigint"
+		}
+		addCondition(fmt.Sprintf("(%s, o.id) %s ($%%d::%s, $%%d::uuid)", sort.column, op, cast), cursor.Value, cursor.ID)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	return sort, where, args, nil
+}
+
+// direction is the SQL direction of the sort
+func (s orderSort) direction() string {
+	if s.desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// Search returns a page of orders for admins, newest first by default.
+// Pages are keyset paginated on the sort column and order ID so deep pages
+// stay cheap and stable while new orders arrive.
+func (s *OrderService) Search(ctx context.Context, filter models.AdminOrderFilter) (*models.AdminOrderPage, error) {
+	sort, where, args, err := orderSearchWhere(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to learn whether there is a next page
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf(`
+		SELECT `+adminOrderColumns+`, %s::text
+		FROM orders o
+		JOIN users u ON u.id = o.buyer_id
+		%s
+		ORDER BY %s %s, o.id %s
+		LIMIT $%d`, sort.column, where, sort.column, sort.direction(), sort.direction(), len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search orders: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.AdminOrderPage{Orders: []models.AdminOrderSummary{}}
+	var lastSortValue string
+	for rows.Next() {
+		var sortValue string
+		o, err := scanAdminOrder(rows, &sortValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		if len(page.Orders) == filter.Limit {
+			page.NextCursor = encodeOrderCursor(orderCursor{Sort: filter.Sort, Value: lastSortValue, ID: page.Orders[len(page.Orders)-1].ID})
+			break
+		}
+		page.Orders = append(page.Orders, o)
+		lastSortValue = sortValue
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search orders: %w", err)
+	}
+
+	if filter.IncludeItems && len(page.Orders) > 0 {
+		if err := s.attachItems(ctx, page.Orders); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// ExportOrders returns the rows of every order matching filter, in its sort
+// order, for the admin order export to stream; filter's cursor and limit
+// are ignored. Read each with ScanExportedOrder; the caller closes rows.
+func (s *OrderService) ExportOrders(ctx context.Context, filter models.AdminOrderFilter) (*sql.Rows, error) {
+	filter.Cursor = ""
+	sort, where, args, err := orderSearchWhere(filter)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+adminOrderColumns+`
+		FROM orders o
+		JOIN users u ON u.id = o.buyer_id
+		%s
+		ORDER BY %s %s, o.id %s`, where, sort.column, sort.direction(), sort.direction()), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export orders: %w", err)
+	}
+	return rows, nil
+}
+
+// ScanExportedOrder scans an order from the rows of ExportOrders
+func ScanExportedOrder(rows *sql.Rows) (models.AdminOrderSummary, error) {
+	o, err := scanAdminOrder(rows)
+	if err != nil {
+		return o, fmt.Errorf("failed to scan order: %w", err)
+	}
+	return o, nil
+}
+
+// scanAdminOrder scans a row selected with adminOrderColumns, followed by
+// any extra destinations for additional selected columns
+func scanAdminOrder(row rowScanner, extra ...interface{}) (models.AdminOrderSummary, error) {
+	var o models.AdminOrderSummary
+	dest := []interface{}{
+		&o.ID, &o.OrderNumber, &o.Reference, &o.Status, &o.PaymentStatus, &o.Total.Amount, &o.Total.Currency, &o.CreatedAt,
+		&o.Customer.ID, &o.Customer.Email, &o.Customer.Name, &o.ItemCount,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return o, err
+}
+
+// attachItems loads the items of orders in one query
+func (s *OrderService) attachItems(ctx context.Context, orders []models.AdminOrderSummary) error {
+	ids := make([]string, len(orders))
+	index := make(map[string]int, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+		index[o.ID] = i
+		orders[i].Items = []models.OrderItem{}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT oi.order_id, oi.id, oi.product_id, oi.quantity, oi.price_cents, oi.total_cents, COALESCE(o.currency, 'USD')
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		WHERE oi.order_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get order items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderID string
+		var item models.OrderItem
+		if err := rows.Scan(&orderID, &item.ID, &item.ProductID, &item.Quantity, &item.Price.Amount, &item.TotalPrice.Amount,
+			&item.Price.Currency); err != nil {
+			return fmt.Errorf("failed to scan order item: %w", err)
+		}
+		item.TotalPrice.Currency = item.Price.Currency
+		i := index[orderID]
+		orders[i].Items = append(orders[i].Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get order items: %w", err)
+	}
+	return nil
+}
+
+func encodeOrderCursor(c orderCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOrderCursor(s string) (orderCursor, error) {
+	var c orderCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
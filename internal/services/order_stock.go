@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// orderLine is a product line being checked out
+type orderLine struct {
+	productID   string
+	quantity    int
+	isBundle    bool
+	referenceID string // the sub-order the line's stock is taken for, if not the order
+	preorder    bool   // takes no stock until its preorder is filled
+}
+
+// consumeOrderStock takes the stock for an order's lines within tx, recording
+// each change in the stock ledger against the line's sub-order, or the order
+// for lines without one. Bundle lines take each
+// component's stock instead of the bundle's. If any line can't be filled
+// nothing is taken and a *validators.ValidationError lists the lines, unless
+// backorder is set: then the other lines' stock is taken and the indexes of
+// those that can't be filled are returned to be backordered. It also
+// returns the categories of the changed products for listing invalidation.
+// Stock held in other buyers' carts through holds is left for them.
+func (s *InventoryService) consumeOrderStock(ctx context.Context, tx *sql.Tx, orderID, buyerID string, lines []orderLine, holds *CartHolds, backorder bool) ([]string, map[int]bool, error) {
+	plan, short, err := planOrderStock(ctx, tx, lines, true, holds, buyerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(short) > 0 && !backorder {
+		var invalid []validators.FieldError
+		for i := range lines {
+			if short[i] {
+				invalid = append(invalid, shortStockError(i))
+			}
+		}
+		return nil, nil, &validators.ValidationError{Fields: invalid}
+	}
+	if len(short) > 0 {
+		// The rest need no more of any product than was there for them all
+		var filled []orderLine
+		for i, line := range lines {
+			if !short[i] {
+				filled = append(filled, line)
+			}
+		}
+		if len(filled) == 0 {
+			return nil, short, nil
+		}
+		var stillShort map[int]bool
+		if plan, stillShort, err = planOrderStock(ctx, tx, filled, true, holds, buyerID); err != nil {
+			return nil, nil, err
+		}
+		if len(stillShort) > 0 {
+			return nil, nil, fmt.Errorf("%w: stock changed while backordering", ErrInsufficientStock)
+		}
+	}
+
+	var categoryIDs []string
+	for _, id := range plan.ids {
+		p := plan.products[id]
+		for _, referenceID := range slices.Sorted(maps.Keys(plan.neededFor[id])) {
+			quantity := plan.neededFor[id][referenceID]
+			if referenceID == "" {
+				referenceID = orderID
+			}
+			if err := s.applyStockChange(ctx, tx, p, stockChange{
+				productID:   id,
+				delta:       -quantity,
+				reason:      StockReasonOrder,
+				referenceID: referenceID,
+				actorID:     buyerID,
+			}); err != nil {
+				return nil, nil, err
+			}
+			p.quantity -= quantity
+		}
+		categoryIDs = append(categoryIDs, p.categoryIDs...)
+	}
+	return categoryIDs, short, nil
+}
+
+// shortStockError is the field error for cart line i being short of stock
+func shortStockError(i int) validators.FieldError {
+	return validators.FieldError{
+		Field: fmt.Sprintf("items[%d].quantity", i), Code: "insufficient_stock", Message: "not enough stock",
+	}
+}
+
+// stockPlan is the stock an order's lines need
+type stockPlan struct {
+	ids       []string                  // products whose stock is taken, sorted
+	products  map[string]lockedStock    // the products read, bundles included
+	neededFor map[string]map[string]int // quantity of each product by line reference, "" for none
+}
+
+// planOrderStock works out the stock lines need within tx, reading the
+// products with their rows locked when lock is set. It returns the indexes
+// of the lines that can't be filled: their product, or one of the bundle's
+// components, is unlisted or hasn't enough stock for every line needing it
+// once what carts other than buyerID's hold is set aside. Preorder lines
+// need none and are always filled.
+func planOrderStock(ctx context.Context, tx *sql.Tx, lines []orderLine, lock bool, holds *CartHolds, buyerID string) (*stockPlan, map[int]bool, error) {
+	var bundleIDs []string
+	for _, line := range lines {
+		if line.isBundle && !line.preorder {
+			bundleIDs = append(bundleIDs, line.productID)
+		}
+	}
+	components := make(map[string][]models.BundleItemInput, len(bundleIDs))
+	if len(bundleIDs) > 0 {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT bundle_id, product_id, quantity FROM product_bundle_items WHERE bundle_id = ANY($1)`,
+			pq.Array(bundleIDs))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get bundle components: %w", err)
+		}
+		for rows.Next() {
+			var bundleID string
+			var item models.BundleItemInput
+			if err := rows.Scan(&bundleID, &item.ProductID, &item.Quantity); err != nil {
+				rows.Close()
+				return nil, nil, fmt.Errorf("failed to scan bundle component: %w", err)
+			}
+			components[bundleID] = append(components[bundleID], item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, fmt.Errorf("failed to get bundle components: %w", err)
+		}
+	}
+
+	// Total each product's stock needed, remembering which lines need it
+	// and how much is taken for each ledger reference
+	needed := make(map[string]int)
+	neededBy := make(map[string][]int)
+	plan := &stockPlan{neededFor: make(map[string]map[string]int)}
+	need := func(productID string, quantity, line int) {
+		needed[productID] += quantity
+		neededBy[productID] = append(neededBy[productID], line)
+		if plan.neededFor[productID] == nil {
+			plan.neededFor[productID] = make(map[string]int)
+		}
+		plan.neededFor[productID][lines[line].referenceID] += quantity
+	}
+	for i, line := range lines {
+		if line.preorder {
+			continue
+		}
+		if !line.isBundle {
+			need(line.productID, line.quantity, i)
+			continue
+		}
+		for _, c := range components[line.productID] {
+			need(c.ProductID, c.Quantity*line.quantity, i)
+		}
+	}
+
+	plan.ids = slices.Sorted(maps.Keys(needed))
+	// Bundles are read with their components so one can't be unlisted
+	// while it is being ordered
+	var err error
+	plan.products, err = readStock(ctx, tx, append(slices.Clone(plan.ids), bundleIDs...), lock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	held := holds.heldByOthers(ctx, buyerID, plan.ids)
+	short := make(map[int]bool)
+	for i, line := range lines {
+		if line.isBundle && !line.preorder && !plan.products[line.productID].listed {
+			short[i] = true
+		}
+	}
+	for _, id := range plan.ids {
+		if p, ok := plan.products[id]; !ok || !p.listed || p.quantity-held[id] < needed[id] {
+			for _, line := range neededBy[id] {
+				short[line] = true
+			}
+		}
+	}
+	return plan, short, nil
+}
+
+// releaseOrderStock returns the stock an order or sub-order took within tx,
+// recording the return in the stock ledger as a cancellation. It returns the categories of
+// the changed products for listing invalidation.
+func (s *InventoryService) releaseOrderStock(ctx context.Context, tx *sql.Tx, orderID, actorID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, -SUM(delta)
+		FROM stock_movements
+		WHERE reference_id = $1 AND reason IN ('order', 'cancellation')
+		GROUP BY product_id
+		HAVING SUM(delta) < 0
+		ORDER BY product_id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order stock: %w", err)
+	}
+	var ids []string
+	taken := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var quantity int
+		if err := rows.Scan(&id, &quantity); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan order stock: %w", err)
+		}
+		ids = append(ids, id)
+		taken[id] = quantity
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order stock: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	products, err := lockStock(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	var categoryIDs []string
+	for _, id := range ids {
+		p := products[id]
+		if err := s.applyStockChange(ctx, tx, p, stockChange{
+			productID:   id,
+			delta:       taken[id],
+			reason:      StockReasonCancellation,
+			referenceID: orderID,
+			actorID:     actorID,
+		}); err != nil {
+			return nil, err
+		}
+		categoryIDs = append(categoryIDs, p.categoryIDs...)
+	}
+	return categoryIDs, nil
+}
+
+// applyStockChange applies change to the locked product p and publishes any
+// threshold crossing it causes
+func (s *InventoryService) applyStockChange(ctx context.Context, tx *sql.Tx, p lockedStock, change stockChange) error {
+	balance, err := changeStock(ctx, tx, change)
+	if err != nil {
+		return err
+	}
+	return s.writeStockEvents(ctx, tx, p.sellerID, p.title, models.StockLevel{
+		ProductID:        change.productID,
+		Delta:            change.delta,
+		PreviousQuantity: p.quantity,
+		StockQuantity:    balance,
+	})
+}
+
+// invalidateListings drops cached listings after an order changed the stock
+// of products in categoryIDs
+func (s *InventoryService) invalidateListings(ctx context.Context, categoryIDs []string) {
+	if len(categoryIDs) > 0 {
+		invalidateProductListings(ctx, s.cache, categoryIDs...)
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// testDB connects to the migrated database TEST_DATABASE_URL names, such as
+// the one docker-compose starts, skipping the test when it isn't set
+func testDB(t *testing.T) *database.PostgresDB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatalf("ping database: %v", err)
+	}
+	db.SetMaxOpenConns(50)
+	t.Cleanup(func() { db.Close() })
+	return &database.PostgresDB{DB: db}
+}
+
+// createStockedProduct creates a listed product with stock for a new
+// seller. Stock movements can't be deleted, so the product is unlisted
+// rather than removed once the test ends.
+func createStockedProduct(t *testing.T, db *database.PostgresDB, stock int) string {
+	t.Helper()
+	ctx := context.Background()
+	suffix := uuid.NewString()
+
+	var sellerID, productID string
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO users (email, username, password_hash, role)
+		VALUES ($1, $2, 'x', 'seller') RETURNING id`,
+		"stock-test-"+suffix+"@example.com", "stock-test-"+suffix).Scan(&sellerID)
+	if err != nil {
+		t.Fatalf("create seller: %v", err)
+	}
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO products (seller_id, title, slug, price_cents, stock_quantity)
+		VALUES ($1, 'Stock test', $2, 100, $3) RETURNING id`,
+		sellerID, "stock-test-"+suffix, stock).Scan(&productID)
+	if err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	t.Cleanup(func() {
+		db.ExecContext(context.Background(), `
+			UPDATE products SET deleted_at = NOW(), is_active = false, status = 'archived' WHERE id = $1`, productID)
+	})
+	return productID
+}
+
+func stockOf(t *testing.T, db *database.PostgresDB, productID string) int {
+	t.Helper()
+	var stock int
+	if err := db.QueryRowContext(context.Background(), `SELECT stock_quantity FROM products WHERE id = $1`, productID).Scan(&stock); err != nil {
+		t.Fatalf("read stock: %v", err)
+	}
+	return stock
+}
+
+// placeConcurrently takes the stock for each order's lines in its own
+// transaction, all at once, returning each order's error
+func placeConcurrently(db *database.PostgresDB, orders [][]orderLine) []error {
+	inventory := &InventoryService{db: db}
+	ctx := context.Background()
+	errs := make([]error, len(orders))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, lines := range orders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = db.WithTx(ctx, func(tx *sql.Tx) error {
+				_, _, err := inventory.consumeOrderStock(ctx, tx, uuid.NewString(), "", lines, nil, false)
+				return err
+			})
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+func TestConcurrentOrdersForLowStock(t *testing.T) {
+	db := testDB(t)
+	const stock, buyers = 3, 40
+	productID := createStockedProduct(t, db, stock)
+
+	orders := make([][]orderLine, buyers)
+	for i := range orders {
+		orders[i] = []orderLine{{productID: productID, quantity: 1}}
+	}
+	errs := placeConcurrently(db, orders)
+
+	placed := 0
+	for _, err := range errs {
+		var verr *validators.ValidationError
+		switch {
+		case err == nil:
+			placed++
+		case errors.As(err, &verr) && verr.Fields[0].Code == "insufficient_stock":
+		default:
+			t.Errorf("order failed with %v, want success or insufficient_stock", err)
+		}
+	}
+	if placed != stock {
+		t.Errorf("%d orders placed, want %d", placed, stock)
+	}
+	if got := stockOf(t, db, productID); got != 0 {
+		t.Errorf("stock = %d, want 0", got)
+	}
+
+	var taken, movements int
+	err := db.QueryRowContext(context.Background(), `
+		SELECT COALESCE(-SUM(delta), 0), COUNT(*) FROM stock_movements WHERE product_id = $1 AND reason = 'order'`,
+		productID).Scan(&taken, &movements)
+	if err != nil {
+		t.Fatalf("read stock ledger: %v", err)
+	}
+	if taken != stock || movements != stock {
+		t.Errorf("ledger records %d taken in %d movements, want %d in %d", taken, movements, stock, stock)
+	}
+}
+
+// Orders listing the same products in opposite orders lock them in the same
+// order, so none of them deadlocks
+func TestConcurrentOrdersLockInConsistentOrder(t *testing.T) {
+	db := testDB(t)
+	const buyers = 30
+	a := createStockedProduct(t, db, buyers)
+	b := createStockedProduct(t, db, buyers)
+
+	orders := make([][]orderLine, buyers)
+	for i := range orders {
+		orders[i] = []orderLine{{productID: a, quantity: 1}, {productID: b, quantity: 1}}
+		if i%2 == 1 {
+			orders[i][0], orders[i][1] = orders[i][1], orders[i][0]
+		}
+	}
+	for i, err := range placeConcurrently(db, orders) {
+		if err != nil {
+			t.Errorf("order %d: %v", i, err)
+		}
+	}
+	for _, id := range []string{a, b} {
+		if got := stockOf(t, db, id); got != 0 {
+			t.Errorf("stock of %s = %d, want 0", id, got)
+		}
+	}
+}
+
+func TestLockProductForUpdate(t *testing.T) {
+	db := testDB(t)
+	s := &ProductService{db: db}
+	ctx := context.Background()
+	productID := createStockedProduct(t, db, 1)
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	holder := make(chan error, 1)
+	go func() {
+		holder <- db.WithTx(ctx, func(tx *sql.Tx) error {
+			err := s.LockProductForUpdate(ctx, tx, productID)
+			close(locked)
+			if err != nil {
+				return err
+			}
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+
+	waiter := make(chan error, 1)
+	go func() {
+		waiter <- db.WithTx(ctx, func(tx *sql.Tx) error {
+			return s.LockProductsForUpdate(ctx, tx, []string{productID})
+		})
+	}()
+	select {
+	case err := <-waiter:
+		t.Fatalf("second lock taken while the first was held: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-holder; err != nil {
+		t.Fatalf("first lock: %v", err)
+	}
+	select {
+	case err := <-waiter:
+		if err != nil {
+			t.Fatalf("second lock: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lock not taken after the first was released")
+	}
+
+	err := db.WithTx(ctx, func(tx *sql.Tx) error {
+		return s.LockProductForUpdate(ctx, tx, uuid.NewString())
+	})
+	if !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("locking a missing product: %v, want ErrProductNotFound", err)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Bulk order transitions
+//
+// Staff may move many orders to one status at once. Each order is moved in
+// its own transaction under its row lock, through the same transition as
+// UpdateStatus, so it appends to its event log and publishes
+// EventOrderStatusChanged, notifying its buyer, as moving it alone would.
+// An order that can't move, because it doesn't exist or its status doesn't
+// allow it, is reported as skipped with why, leaving the orders moved
+// before and after it in place.
+
+// TransitionOrders moves each of input's orders to input's status on behalf
+// of staff member actorID, reporting those moved in the result's applied
+// list and those left alone in its skipped list
+func (s *OrderService) TransitionOrders(ctx context.Context, actorID string, input models.BulkTransitionInput) (*models.BulkTransitionResult, error) {
+	result := &models.BulkTransitionResult{
+		Status: input.Status, Applied: []models.BulkTransitioned{}, Skipped: []models.BulkTransitionSkip{},
+	}
+	for _, orderID := range input.OrderIDs {
+		from, err := s.transitionOrder(ctx, orderID, input.Status, actorID)
+		if err == nil {
+			result.Applied = append(result.Applied, models.BulkTransitioned{OrderID: orderID, FromStatus: from})
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		skip := models.BulkTransitionSkip{OrderID: orderID, FromStatus: from, Reason: bulkTransitionSkipReason(err), Message: err.Error()}
+		if skip.Reason == "internal_error" {
+			log.Error().Err(err).Str("order_id", orderID).Msg("Failed to transition order")
+			skip.Message = "Order could not be moved"
+		}
+		result.Skipped = append(result.Skipped, skip)
+	}
+	return result, nil
+}
+
+// transitionOrder moves order orderID to status on behalf of actorID,
+// returning the status it had, empty if it wasn't found
+func (s *OrderService) transitionOrder(ctx context.Context, orderID, status, actorID string) (string, error) {
+	var from string
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		from = order.status
+		categoryIDs, err = s.transition(ctx, tx, order, status, actorID)
+		return err
+	})
+	if err != nil {
+		return from, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return from, nil
+}
+
+// bulkTransitionSkipReason returns the reason a bulk transition reports for
+// an order that failed to move with err
+func bulkTransitionSkipReason(err error) string {
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		return "not_found"
+	case errors.Is(err, ErrInvalidOrderTransition):
+		return "invalid_transition"
+	case errors.Is(err, ErrPreordersPending):
+		return "preorders_pending"
+	default:
+		return "internal_error"
+	}
+}
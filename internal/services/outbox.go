@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/jobs"
+)
+
+const outboxBatchSize = 100
+
+// WriteOutbox records an event in the outbox as part of tx, so the event is
+// published if and only if the surrounding business change commits
+func WriteOutbox(ctx context.Context, tx *sql.Tx, eventType, aggregateID string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox payload: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO outbox (event_type, aggregate_id, payload) VALUES ($1, $2, $3)`,
+		eventType, aggregateID, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	return nil
+}
+
+// OutboxRelay publishes outbox events to the job queue. Rows are claimed with
+// FOR UPDATE SKIP LOCKED so replicas relay disjoint batches, and are marked
+// dispatched only after publishing, giving at-least-once delivery. Job IDs
+// are derived from the outbox ID so consumers can drop duplicates.
+type OutboxRelay struct {
+	db       *database.PostgresDB
+	queue    *jobs.Queue
+	interval time.Duration
+}
+
+// NewOutboxRelay creates a relay polling the outbox every interval
+func NewOutboxRelay(db *database.PostgresDB, queue *jobs.Queue, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{db: db, queue: queue, interval: interval}
+}
+
+// Run relays events until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		// Keep draining while full batches are found
+		for {
+			n, err := r.relayBatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error().Err(err).Msg("Outbox relay failed")
+				}
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayBatch publishes one batch of pending events, returning how many rows
+// were claimed
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	claimed := 0
+	err := r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, event_type, payload
+			FROM outbox
+			WHERE dispatched_at IS NULL
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED`, outboxBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to claim outbox events: %w", err)
+		}
+
+		type event struct {
+			id        int64
+			eventType string
+			payload   json.RawMessage
+		}
+		var events []event
+		for rows.Next() {
+			var e event
+			if err := rows.Scan(&e.id, &e.eventType, &e.payload); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan outbox event: %w", err)
+			}
+			events = append(events, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to claim outbox events: %w", err)
+		}
+		claimed = len(events)
+
+		var dispatched []int64
+		for _, e := range events {
+			jobID := fmt.Sprintf("outbox:%d", e.id)
+			if err := r.queue.Enqueue(ctx, jobID, e.eventType, e.payload); err != nil {
+				// Preserve ordering: stop at the first failure and retry next poll
+				if _, uerr := tx.ExecContext(ctx, `
+					UPDATE outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1`,
+					e.id, err.Error()); uerr != nil {
+					return fmt.Errorf("failed to record outbox failure: %w", uerr)
+				}
+				log.Warn().Err(err).Int64("outbox_id", e.id).Str("event_type", e.eventType).Msg("Failed to publish outbox event")
+				break
+			}
+			dispatched = append(dispatched, e.id)
+		}
+
+		if len(dispatched) > 0 {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE outbox SET dispatched_at = NOW(), last_error = NULL WHERE id = ANY($1)`,
+				pq.Array(dispatched)); err != nil {
+				return fmt.Errorf("failed to mark outbox events dispatched: %w", err)
+			}
+		}
+		return nil
+	})
+	return claimed, err
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// BreachChecker looks passwords up in Have I Been Pwned's range API. Only
+// the first 5 hex characters of a password's SHA-1 hash are sent; the
+// matching suffixes come back and are compared locally.
+type BreachChecker struct {
+	url    string
+	client *http.Client
+}
+
+// NewBreachChecker creates a breach checker for cfg's range API
+func NewBreachChecker(cfg config.PasswordPolicyConfig) *BreachChecker {
+	return &BreachChecker{
+		url:    cfg.BreachCheckURL,
+		client: &http.Client{Timeout: time.Duration(cfg.BreachCheckTimeout) * time.Millisecond},
+	}
+}
+
+// Breached reports whether pw appears in a known breach
+func (c *BreachChecker) Breached(ctx context.Context, pw string) (bool, error) {
+	sum := sha1.Sum([]byte(pw))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+prefix, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create breach check request: %w", err)
+	}
+	// Padding hides the size of the answer, and so which prefix was asked for
+	req.Header.Set("Add-Padding", "true")
+
+	defer utils.TrackTiming(req.Context(), "external")()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to check password breaches: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breach check returned status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// Lines are SUFFIX:COUNT; padding lines have a count of 0
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && candidate == suffix && count != "0" {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breach check response: %w", err)
+	}
+	return false, nil
+}
+
+// CheckPassword checks pw against the password policy, for registration and
+// password resets, returning a *validators.ValidationError on field password
+// for each rule it breaks. With the breach check enabled a password found in
+// a breach is rejected too; when the check can't be made the password is let
+// through, so an outage doesn't stop sign-ups.
+func (s *UserService) CheckPassword(ctx context.Context, pw string) error {
+	if err := utils.ValidatePassword(pw, s.passwords); err != nil {
+		return err
+	}
+	if s.breaches == nil {
+		return nil
+	}
+	breached, err := s.breaches.Breached(ctx, pw)
+	if err != nil {
+		log.Warn().Err(err).Msg("Password breach check failed, allowing password")
+		return nil
+	}
+	if breached {
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "password", Code: "breached",
+			Message: "password has appeared in a data breach; choose another",
+		}}}
+	}
+	return nil
+}
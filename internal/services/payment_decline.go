@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// PaymentDeclinedError is returned when the gateway declines a payment.
+// Reason is one of the models.Decline* reasons; the gateway's own details
+// stay on the payment attempt.
+type PaymentDeclinedError struct {
+	Reason string
+}
+
+func (e *PaymentDeclinedError) Error() string {
+	return "payment declined: " + e.Reason
+}
+
+// Message returns a message about the decline that is safe to show the buyer
+func (e *PaymentDeclinedError) Message() string {
+	if message, ok := declineMessages[e.Reason]; ok {
+		return message
+	}
+	return declineMessages[models.DeclineGeneric]
+}
+
+// declineReasons maps gateway decline codes to decline reasons. Codes that
+// hint at fraud checks are deliberately left to the generic reason, so the
+// buyer learns nothing about them.
+var declineReasons = map[string]string{
+	"insufficient_funds":              models.DeclineInsufficientFunds,
+	"expired_card":                    models.DeclineCardExpired,
+	"incorrect_cvc":                   models.DeclineIncorrectCVC,
+	"invalid_cvc":                     models.DeclineIncorrectCVC,
+	"incorrect_number":                models.DeclineIncorrectNumber,
+	"invalid_number":                  models.DeclineIncorrectNumber,
+	"invalid_expiry_month":            models.DeclineCardExpired,
+	"invalid_expiry_year":             models.DeclineCardExpired,
+	"card_velocity_exceeded":          models.DeclineLimitExceeded,
+	"withdrawal_count_limit_exceeded": models.DeclineLimitExceeded,
+	"authentication_required":         models.DeclineAuthenticationRequired,
+	"card_not_supported":              models.DeclineCardNotSupported,
+	"currency_not_supported":          models.DeclineCardNotSupported,
+	"processing_error":                models.DeclineProcessingError,
+	"issuer_not_available":            models.DeclineProcessingError,
+	"try_again_later":                 models.DeclineProcessingError,
+}
+
+var declineMessages = map[string]string{
+	models.DeclineInsufficientFunds:      "Your card has insufficient funds. Try another payment method.",
+	models.DeclineCardExpired:            "Your card has expired. Check the expiry date or use another card.",
+	models.DeclineIncorrectCVC:           "Your card's security code is incorrect.",
+	models.DeclineIncorrectNumber:        "Your card number is incorrect.",
+	models.DeclineLimitExceeded:          "Your card's limit has been reached. Try another payment method.",
+	models.DeclineAuthenticationRequired: "Your bank needs you to authenticate this payment. Try again and complete the verification.",
+	models.DeclineCardNotSupported:       "Your card doesn't support this purchase. Try another card.",
+	models.DeclineProcessingError:        "Your payment couldn't be processed. Try again in a moment.",
+	models.DeclineGeneric:                "Your card was declined. Contact your bank or use another payment method.",
+}
+
+// DeclineReason maps a gateway decline code to a decline reason
+func DeclineReason(gatewayCode string) string {
+	if reason, ok := declineReasons[gatewayCode]; ok {
+		return reason
+	}
+	return models.DeclineGeneric
+}
+
+// DeclinePayment records a declined attempt to pay amount for order orderID
+// and returns the *PaymentDeclinedError to give the buyer. gatewayCode and
+// gatewayMessage are stored for support only.
+func (s *OrderService) DeclinePayment(ctx context.Context, orderID string, amount money.Money, gatewayCode, gatewayMessage string) error {
+	reason := DeclineReason(gatewayCode)
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO payment_attempts (order_id, status, amount_cents, currency, decline_reason, gateway_code, gateway_message)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))`,
+		orderID, models.PaymentAttemptDeclined, amount.Amount, amount.Currency, reason, gatewayCode, gatewayMessage); err != nil {
+		return fmt.Errorf("failed to record payment attempt: %w", err)
+	}
+	return &PaymentDeclinedError{Reason: reason}
+}
+
+// RecordPayment records a successful attempt to pay amount for order orderID
+func (s *OrderService) RecordPayment(ctx context.Context, orderID string, amount money.Money) error {
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO payment_attempts (order_id, status, amount_cents, currency) VALUES ($1, $2, $3, $4)`,
+		orderID, models.PaymentAttemptSucceeded, amount.Amount, amount.Currency); err != nil {
+		return fmt.Errorf("failed to record payment attempt: %w", err)
+	}
+	return nil
+}
+
+// PaymentAttempts returns a page of an order's payment attempts, newest
+// first, with the gateway's details, for support
+func (s *OrderService) PaymentAttempts(ctx context.Context, orderID string, limit, offset int) (*models.PaymentAttemptPage, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if !exists {
+		return nil, ErrOrderNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, order_id, status, amount_cents, currency, COALESCE(decline_reason, ''),
+			COALESCE(gateway_code, ''), COALESCE(gateway_message, ''), created_at, COUNT(*) OVER() AS total
+		FROM payment_attempts
+		WHERE order_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2 OFFSET $3`, orderID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payment attempts: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.PaymentAttemptPage{Attempts: []models.PaymentAttempt{}}
+	for rows.Next() {
+		var a models.PaymentAttempt
+		if err := rows.Scan(&a.ID, &a.OrderID, &a.Status, &a.Amount.Amount, &a.Amount.Currency, &a.DeclineReason,
+			&a.GatewayCode, &a.GatewayMessage, &a.CreatedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan payment attempt: %w", err)
+		}
+		page.Attempts = append(page.Attempts, a)
+	}
+	return page, rows.Err()
+}
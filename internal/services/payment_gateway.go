@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/version"
+)
+
+// PaymentGateway is implemented by the payment providers that can keep a
+// buyer's cards. Card details only ever reach the gateway: clients collect
+// them with the gateway's own form and send us the token it returns, and we
+// keep the gateway's IDs and how to show the card.
+type PaymentGateway interface {
+	CreateCustomer(ctx context.Context, userID, email string) (string, error)
+	AttachCard(ctx context.Context, customerID, token string) (*GatewayCard, error)
+	DetachCard(ctx context.Context, cardID string) error
+	// Charge charges amount to a card of customerID without the buyer
+	// present. Retries with the same idempotency key are charged once. A
+	// declined charge fails with a *GatewayDeclineError.
+	Charge(ctx context.Context, customerID, cardID string, amount money.Money, idempotencyKey string) (string, error)
+	// Refund refunds amount of the charge chargeID, returning the gateway's
+	// refund ID. Retries with the same idempotency key are refunded once.
+	Refund(ctx context.Context, chargeID string, amount money.Money, idempotencyKey string) (string, error)
+}
+
+// GatewayCard is a card as the gateway keeps it
+type GatewayCard struct {
+	ID          string
+	Brand       string
+	Last4       string
+	ExpMonth    int
+	ExpYear     int
+	Fingerprint string // the same for a card number whoever stores it
+	Country     string // ISO 3166 code of the country that issued it
+}
+
+// GatewayDeclineError is returned when the gateway declines a charge, with
+// its own decline code and message
+type GatewayDeclineError struct {
+	Code    string
+	Message string
+}
+
+func (e *GatewayDeclineError) Error() string {
+	return "charge declined: " + e.Code
+}
+
+// NewPaymentGateway creates the payment gateway selected in configuration.
+// Without a secret key no gateway is configured and it returns nil.
+func NewPaymentGateway(cfg config.PaymentsConfig) (PaymentGateway, error) {
+	if cfg.SecretKey == "" {
+		return nil, nil
+	}
+	switch cfg.Gateway {
+	case "stripe":
+		return &StripeGateway{
+			baseURL:   strings.TrimRight(cfg.BaseURL, "/"),
+			secretKey: cfg.SecretKey,
+			client:    &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", cfg.Gateway)
+	}
+}
+
+// StripeGateway keeps cards as Stripe payment methods attached to a Stripe
+// customer per buyer, and charges them with payment intents
+type StripeGateway struct {
+	baseURL   string
+	secretKey string
+	client    *http.Client
+}
+
+// stripePaymentMethod is the part of a Stripe payment method we read
+type stripePaymentMethod struct {
+	ID   string `json:"id"`
+	Card *struct {
+		Brand       string `json:"brand"`
+		Last4       string `json:"last4"`
+		ExpMonth    int    `json:"exp_month"`
+		ExpYear     int    `json:"exp_year"`
+		Fingerprint string `json:"fingerprint"`
+		Country     string `json:"country"`
+	} `json:"card"`
+}
+
+// stripeError is an error response from Stripe
+type stripeError struct {
+	StatusCode  int    `json:"-"`
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	DeclineCode string `json:"decline_code"`
+	Message     string `json:"message"`
+}
+
+func (e *stripeError) Error() string {
+	return fmt.Sprintf("stripe returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// CreateCustomer creates the Stripe customer a buyer's cards are attached to
+func (g *StripeGateway) CreateCustomer(ctx context.Context, userID, email string) (string, error) {
+	var customer struct {
+		ID string `json:"id"`
+	}
+	form := url.Values{"email": {email}, "metadata[user_id]": {userID}}
+	if err := g.post(ctx, "/v1/customers", form, "", &customer); err != nil {
+		return "", fmt.Errorf("failed to create payment customer: %w", err)
+	}
+	return customer.ID, nil
+}
+
+// AttachCard attaches the payment method token to customerID. Tokens Stripe
+// doesn't know, or has already attached, fail with ErrInvalidPaymentToken;
+// those that aren't cards are refused and left unattached.
+func (g *StripeGateway) AttachCard(ctx context.Context, customerID, token string) (*GatewayCard, error) {
+	var pm stripePaymentMethod
+	err := g.post(ctx, "/v1/payment_methods/"+url.PathEscape(token)+"/attach", url.Values{"customer": {customerID}}, "", &pm)
+	var serr *stripeError
+	if errors.As(err, &serr) && serr.Type == "invalid_request_error" && serr.StatusCode < 500 {
+		return nil, ErrInvalidPaymentToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach payment method: %w", err)
+	}
+	if pm.Card == nil {
+		if err := g.DetachCard(ctx, pm.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrUnsupportedPaymentMethod
+	}
+	return &GatewayCard{ID: pm.ID, Brand: pm.Card.Brand, Last4: pm.Card.Last4, ExpMonth: pm.Card.ExpMonth, ExpYear: pm.Card.ExpYear,
+		Fingerprint: pm.Card.Fingerprint, Country: pm.Card.Country}, nil
+}
+
+// DetachCard detaches a payment method from its customer, so it can't be
+// charged again
+func (g *StripeGateway) DetachCard(ctx context.Context, cardID string) error {
+	if err := g.post(ctx, "/v1/payment_methods/"+url.PathEscape(cardID)+"/detach", url.Values{}, "", nil); err != nil {
+		return fmt.Errorf("failed to detach payment method: %w", err)
+	}
+	return nil
+}
+
+// Charge confirms an off-session payment intent for amount. Card errors,
+// and intents that need the buyer to authenticate, are declines.
+func (g *StripeGateway) Charge(ctx context.Context, customerID, cardID string, amount money.Money, idempotencyKey string) (string, error) {
+	form := url.Values{
+		"amount":         {strconv.FormatInt(amount.Amount, 10)},
+		"currency":       {strings.ToLower(amount.Currency)},
+		"customer":       {customerID},
+		"payment_method": {cardID},
+		"confirm":        {"true"},
+		"off_session":    {"true"},
+	}
+	var intent struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	err := g.post(ctx, "/v1/payment_intents", form, idempotencyKey, &intent)
+	var serr *stripeError
+	if errors.As(err, &serr) && serr.Type == "card_error" {
+		code := serr.DeclineCode
+		if code == "" {
+			code = serr.Code
+		}
+		return "", &GatewayDeclineError{Code: code, Message: serr.Message}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to charge payment method: %w", err)
+	}
+	if intent.Status == "requires_action" {
+		return "", &GatewayDeclineError{Code: "authentication_required", Message: "payment intent " + intent.ID + " requires action"}
+	}
+	if intent.Status != "succeeded" {
+		return "", fmt.Errorf("payment intent %s is %s", intent.ID, intent.Status)
+	}
+	return intent.ID, nil
+}
+
+// Refund refunds amount of the payment intent chargeID
+func (g *StripeGateway) Refund(ctx context.Context, chargeID string, amount money.Money, idempotencyKey string) (string, error) {
+	form := url.Values{
+		"payment_intent": {chargeID},
+		"amount":         {strconv.FormatInt(amount.Amount, 10)},
+	}
+	var refund struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := g.post(ctx, "/v1/refunds", form, idempotencyKey, &refund); err != nil {
+		return "", fmt.Errorf("failed to refund payment: %w", err)
+	}
+	if refund.Status == "failed" || refund.Status == "canceled" {
+		return "", fmt.Errorf("refund %s is %s", refund.ID, refund.Status)
+	}
+	return refund.ID, nil
+}
+
+// post sends a form-encoded request to Stripe, decoding the response into
+// out when it is not nil
+func (g *StripeGateway) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+g.secretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	defer utils.TrackTiming(req.Context(), "external")()
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error stripeError `json:"error"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+		body.Error.StatusCode = resp.StatusCode
+		return &body.Error
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
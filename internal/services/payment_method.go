@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Stored payment methods
+//
+// Buyers store cards to pay with again without entering them. The card
+// itself is kept at the payment gateway, attached to a gateway customer
+// made for the buyer the first time they store one; we keep the gateway's
+// IDs with the brand, last four digits and expiry to show. A buyer has at
+// most one default card, and their first is it. Cards are only ever looked
+// up with their owner, so one buyer's card can't be charged or removed by
+// another.
+
+var (
+	ErrPaymentMethodNotFound    = errors.New("payment method not found")
+	ErrPaymentsUnavailable      = errors.New("no payment gateway is configured")
+	ErrInvalidPaymentToken      = errors.New("payment method token is invalid")
+	ErrUnsupportedPaymentMethod = errors.New("payment method is not a card")
+)
+
+// PaymentMethodService handles buyers' stored payment methods
+type PaymentMethodService struct {
+	db      *database.PostgresDB
+	gateway PaymentGateway // nil when none is configured
+}
+
+// NewPaymentMethodService creates a new payment method service storing
+// cards at gateway, which may be nil when no gateway is configured
+func NewPaymentMethodService(db *database.PostgresDB, gateway PaymentGateway) *PaymentMethodService {
+	return &PaymentMethodService{db: db, gateway: gateway}
+}
+
+// storedCard is a payment method with the gateway IDs needed to charge it
+type storedCard struct {
+	id         string
+	gatewayID  string
+	customerID string
+}
+
+// List returns userID's payment methods, default first and then newest first
+func (s *PaymentMethodService) List(ctx context.Context, userID string) ([]models.PaymentMethod, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, brand, last4, exp_month, exp_year, is_default, created_at
+		FROM payment_methods WHERE user_id = $1
+		ORDER BY is_default DESC, created_at DESC, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payment methods: %w", err)
+	}
+	defer rows.Close()
+
+	methods := []models.PaymentMethod{}
+	for rows.Next() {
+		var m models.PaymentMethod
+		if err := rows.Scan(&m.ID, &m.Brand, &m.Last4, &m.ExpMonth, &m.ExpYear, &m.IsDefault, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan payment method: %w", err)
+		}
+		methods = append(methods, m)
+	}
+	return methods, rows.Err()
+}
+
+// Get returns payment method id of userID's
+func (s *PaymentMethodService) Get(ctx context.Context, userID, id string) (*models.PaymentMethod, error) {
+	var m models.PaymentMethod
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, brand, last4, exp_month, exp_year, is_default, created_at
+		FROM payment_methods WHERE id = $1 AND user_id = $2`, id, userID).Scan(
+		&m.ID, &m.Brand, &m.Last4, &m.ExpMonth, &m.ExpYear, &m.IsDefault, &m.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentMethodNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment method: %w", err)
+	}
+	return &m, nil
+}
+
+// Add stores the card the gateway issued input.Token for as one of userID's
+// payment methods, making it their default when asked to or when it is
+// their first. Tokens the gateway refuses are a *validators.ValidationError.
+func (s *PaymentMethodService) Add(ctx context.Context, userID string, input models.PaymentMethodInput) (*models.PaymentMethod, error) {
+	if s.gateway == nil {
+		return nil, ErrPaymentsUnavailable
+	}
+	customerID, err := s.customer(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	card, err := s.gateway.AttachCard(ctx, customerID, input.Token)
+	if errors.Is(err, ErrInvalidPaymentToken) || errors.Is(err, ErrUnsupportedPaymentMethod) {
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "token", Code: "invalid", Message: err.Error(),
+		}}}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &models.PaymentMethod{Brand: card.Brand, Last4: card.Last4, ExpMonth: card.ExpMonth, ExpYear: card.ExpYear}
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Locked so concurrent adds agree on which is the first
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+		var hasDefault bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM payment_methods WHERE user_id = $1 AND is_default)`, userID).Scan(&hasDefault); err != nil {
+			return fmt.Errorf("failed to get default payment method: %w", err)
+		}
+		m.IsDefault = input.IsDefault || !hasDefault
+		if m.IsDefault {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE payment_methods SET is_default = false WHERE user_id = $1 AND is_default`, userID); err != nil {
+				return fmt.Errorf("failed to clear default payment method: %w", err)
+			}
+		}
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO payment_methods (user_id, gateway_id, brand, last4, exp_month, exp_year, is_default, fingerprint, country)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF(UPPER($9), ''))
+			RETURNING id, created_at`,
+			userID, card.ID, card.Brand, card.Last4, card.ExpMonth, card.ExpYear, m.IsDefault,
+			card.Fingerprint, card.Country).Scan(&m.ID, &m.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store payment method: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		// Don't leave a card at the gateway we have no record of
+		if detachErr := s.gateway.DetachCard(context.WithoutCancel(ctx), card.ID); detachErr != nil {
+			return nil, errors.Join(err, detachErr)
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetDefault makes payment method id userID's default
+func (s *PaymentMethodService) SetDefault(ctx context.Context, userID, id string) (*models.PaymentMethod, error) {
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM payment_methods WHERE id = $1 AND user_id = $2)`, id, userID).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to get payment method: %w", err)
+		}
+		if !exists {
+			return ErrPaymentMethodNotFound
+		}
+		// Cleared first so the one-default index holds after each statement
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE payment_methods SET is_default = false WHERE user_id = $1 AND is_default AND id <> $2`, userID, id); err != nil {
+			return fmt.Errorf("failed to clear default payment method: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE payment_methods SET is_default = true WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to set default payment method: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, userID, id)
+}
+
+// Delete detaches payment method id of userID's at the gateway and removes
+// it. When the gateway can't detach it the card is kept, so it is never
+// left chargeable without a record. Removing the default card makes the
+// newest remaining one the default.
+func (s *PaymentMethodService) Delete(ctx context.Context, userID, id string) error {
+	if s.gateway == nil {
+		return ErrPaymentsUnavailable
+	}
+	return s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var gatewayID string
+		var wasDefault bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT gateway_id, is_default FROM payment_methods WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+			id, userID).Scan(&gatewayID, &wasDefault)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPaymentMethodNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get payment method: %w", err)
+		}
+		if err := s.gateway.DetachCard(ctx, gatewayID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM payment_methods WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to delete payment method: %w", err)
+		}
+		if !wasDefault {
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE payment_methods SET is_default = true
+			WHERE id = (SELECT id FROM payment_methods WHERE user_id = $1 ORDER BY created_at DESC, id LIMIT 1)`, userID); err != nil {
+			return fmt.Errorf("failed to set default payment method: %w", err)
+		}
+		return nil
+	})
+}
+
+// card returns the payment method of userID's to charge: id, or their
+// default when id is empty
+func (s *PaymentMethodService) card(ctx context.Context, tx *sql.Tx, userID, id string) (*storedCard, error) {
+	card := &storedCard{}
+	err := tx.QueryRowContext(ctx, `
+		SELECT pm.id, pm.gateway_id, u.payment_customer_id
+		FROM payment_methods pm JOIN users u ON u.id = pm.user_id
+		WHERE pm.user_id = $1 AND (pm.id = NULLIF($2, '')::uuid OR ($2 = '' AND pm.is_default))`,
+		userID, id).Scan(&card.id, &card.gatewayID, &card.customerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentMethodNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment method: %w", err)
+	}
+	return card, nil
+}
+
+// charge charges amount to card for order orderID. Each call is a new
+// attempt at the gateway.
+func (s *PaymentMethodService) charge(ctx context.Context, card *storedCard, orderID string, amount money.Money) (string, error) {
+	if s.gateway == nil {
+		return "", ErrPaymentsUnavailable
+	}
+	return s.gateway.Charge(ctx, card.customerID, card.gatewayID, amount, "order-"+orderID+"-"+uuid.NewString())
+}
+
+// refund refunds amount of the charge that paid order orderID, returning
+// the gateway's refund ID. Orders not charged at the gateway, or without
+// one configured, return an empty ID, leaving the refund to be settled by
+// hand.
+func (s *PaymentMethodService) refund(ctx context.Context, tx *sql.Tx, orderID string, amount money.Money, idempotencyKey string) (string, error) {
+	if s.gateway == nil {
+		return "", nil
+	}
+	var chargeID string
+	err := tx.QueryRowContext(ctx, `
+		SELECT gateway_reference FROM payment_attempts
+		WHERE order_id = $1 AND status = $2 AND gateway_reference IS NOT NULL
+		ORDER BY created_at DESC
+		LIMIT 1`, orderID, models.PaymentAttemptSucceeded).Scan(&chargeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get order payment: %w", err)
+	}
+	return s.gateway.Refund(ctx, chargeID, amount, idempotencyKey)
+}
+
+// customer returns userID's customer at the gateway, creating it the first
+// time. Should two requests create one at once, the first stored is kept.
+func (s *PaymentMethodService) customer(ctx context.Context, userID string) (string, error) {
+	var customerID sql.NullString
+	var email string
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT payment_customer_id, email FROM users WHERE id = $1`, userID).Scan(&customerID, &email); err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+	if customerID.Valid {
+		return customerID.String, nil
+	}
+
+	created, err := s.gateway.CreateCustomer(ctx, userID, email)
+	if err != nil {
+		return "", err
+	}
+	var stored string
+	if err := s.db.QueryRowContext(ctx, `
+		UPDATE users SET payment_customer_id = COALESCE(payment_customer_id, $2)
+		WHERE id = $1
+		RETURNING payment_customer_id`, userID, created).Scan(&stored); err != nil {
+		return "", fmt.Errorf("failed to store payment customer: %w", err)
+	}
+	return stored, nil
+}
+
+// Pay charges order orderID's total to a stored card of its buyer's:
+// input.PaymentMethodID, else the card chosen at checkout, else their
+// default. Only the buyer may pay, and only while the order is pending. The
+// order stays locked while the gateway charges it, so it is never charged
+// twice. A declined charge is recorded and fails with a
+// *PaymentDeclinedError; a successful one is recorded and the order moves
+// to paid. An order the fraud rules score high enough is held for review
+// instead of being charged, failing with ErrOrderHeld, as do orders already
+// held; orders an admin released aren't scored again.
+func (s *OrderService) Pay(ctx context.Context, orderID, buyerID string, input models.PaymentInput) (*models.Order, error) {
+	if err := s.authorizeView(ctx, orderID, buyerID, false); err != nil {
+		return nil, err
+	}
+
+	var amount money.Money
+	var reference string
+	var decline *GatewayDeclineError
+	var categoryIDs []string
+	held := false
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.buyerID != buyerID {
+			return ErrOrderForbidden
+		}
+		if order.status == models.OrderStatusReview {
+			return ErrOrderHeld
+		}
+		if order.status != "pending" {
+			return fmt.Errorf("%w: %s to paid", ErrInvalidOrderTransition, order.status)
+		}
+		if err := s.checkPreordersFilled(ctx, tx, orderID); err != nil {
+			return err
+		}
+
+		var chosenID string
+		var address []byte
+		var released bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT total_cents, COALESCE(payment_method_id::text, ''), shipping_address, released_at IS NOT NULL
+			FROM orders WHERE id = $1`,
+			orderID).Scan(&amount.Amount, &chosenID, &address, &released); err != nil {
+			return fmt.Errorf("failed to get order: %w", err)
+		}
+		amount.Currency = order.currency
+		methodID := input.PaymentMethodID
+		if methodID == "" {
+			methodID = chosenID
+		}
+		card, err := s.payments.card(ctx, tx, buyerID, methodID)
+		if err != nil {
+			return err
+		}
+		if !released {
+			// Committed without charging, so the hold and its alert stand
+			held, err = s.screenOrder(ctx, tx, order, FraudOrder{
+				ID: orderID, BuyerID: buyerID, PaymentMethodID: card.id, ShippingAddress: address,
+			}, fraudStagePayment)
+			if err != nil || held {
+				return err
+			}
+		}
+
+		reference, err = s.payments.charge(ctx, card, orderID, amount)
+		if errors.As(err, &decline) {
+			// Recorded once this transaction has let go of the order
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO payment_attempts (order_id, status, amount_cents, currency, payment_method_id, gateway_reference)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			orderID, models.PaymentAttemptSucceeded, amount.Amount, amount.Currency, card.id, reference); err != nil {
+			return fmt.Errorf("failed to record payment attempt: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE orders SET payment_method_id = $2 WHERE id = $1`, orderID, card.id); err != nil {
+			return fmt.Errorf("failed to update order payment method: %w", err)
+		}
+		categoryIDs, err = s.transition(ctx, tx, order, "paid", buyerID)
+		return err
+	})
+	if decline != nil {
+		return nil, s.DeclinePayment(ctx, orderID, amount, decline.Code, decline.Message)
+	}
+	if held && err == nil {
+		return nil, ErrOrderHeld
+	}
+	if err != nil && reference != "" {
+		// The card is charged, so a payment we failed to record needs a person
+		log.Error().Err(err).Str("order_id", orderID).Str("gateway_reference", reference).
+			Msg("Charged order but failed to record payment")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return s.Get(ctx, orderID, buyerID, false)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Pre-orders
+//
+// A product on preorder takes orders without stock until its availableFrom.
+// Checkout orders its lines as backorders marked preorder, expected on the
+// day it becomes available; they take no stock and carts hold none for
+// them. FillBackorders fills them like other backorders once the product is
+// available and its stock is in, after which they ship as usual. When
+// orders are charged at ship, an order with preorders waiting can't be
+// paid for; once the last is filled the buyer's card is charged.
+
+// EventPreordersFilled is published when the last preorder of a pending
+// order charged at ship is filled
+const EventPreordersFilled = "order.preorders_filled"
+
+// When orders with preorders are paid for
+const (
+	PreorderChargeOrder = "order" // at checkout, like any order
+	PreorderChargeShip  = "ship"  // once every preorder is filled
+)
+
+// maxDeliveryDays is how far ahead a delivery date may be requested
+const maxDeliveryDays = 365
+
+var ErrPreordersPending = errors.New("order has preorders that are not yet filled")
+
+// PreordersFilledEvent is the payload of EventPreordersFilled
+type PreordersFilledEvent struct {
+	OrderID        string `json:"orderId"`
+	OrderNumber    int64  `json:"orderNumber"`
+	OrderReference string `json:"orderReference"`
+	BuyerID        string `json:"buyerId"`
+}
+
+// productPreorderAt returns the SQL for whether product p is on preorder at
+// the instant bound to param
+func productPreorderAt(param string) string {
+	return fmt.Sprintf(`(p.preorder AND p.available_from > %s)`, param)
+}
+
+// preorderETA is the date a preorder for a product available from
+// availableFrom is expected
+func preorderETA(availableFrom time.Time) string {
+	return availableFrom.UTC().Format(time.DateOnly)
+}
+
+// checkRequestedDelivery checks that date, a delivery date requested at
+// checkout as YYYY-MM-DD, is after today and within maxDeliveryDays, and no
+// earlier than the day every preorder line of cart is available
+func checkRequestedDelivery(date string, cart *pricedCart, now time.Time) error {
+	day, err := time.Parse(time.DateOnly, date)
+	today := now.UTC().Truncate(24 * time.Hour)
+	if err != nil || !day.After(today) || day.After(today.AddDate(0, 0, maxDeliveryDays)) {
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "requestedDeliveryDate", Code: "date",
+			Message: fmt.Sprintf("requestedDeliveryDate must be a date (YYYY-MM-DD) after today and within %d days", maxDeliveryDays),
+		}}}
+	}
+	earliest := ""
+	for _, line := range cart.lines {
+		if !line.preorder {
+			continue
+		}
+		if eta := preorderETA(line.availableFrom.Time); eta > earliest {
+			earliest = eta
+		}
+	}
+	if earliest != "" && date < earliest {
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "requestedDeliveryDate", Code: "preorder", Param: earliest,
+			Message: fmt.Sprintf("requestedDeliveryDate must be no earlier than %s, when the preordered items are available", earliest),
+		}}}
+	}
+	return nil
+}
+
+// checkPreordersFilled fails with ErrPreordersPending when orders are
+// charged at ship and order orderID still has preorders waiting
+func (s *OrderService) checkPreordersFilled(ctx context.Context, tx *sql.Tx, orderID string) error {
+	if s.preorderCharge != PreorderChargeShip {
+		return nil
+	}
+	pending, err := preordersPending(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return ErrPreordersPending
+	}
+	return nil
+}
+
+// preordersPending reports whether order orderID has preorders not yet
+// filled or cancelled
+func preordersPending(ctx context.Context, tx *sql.Tx, orderID string) (bool, error) {
+	var pending bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM order_items
+			WHERE order_id = $1 AND preorder AND backordered_at IS NOT NULL AND cancelled_at IS NULL
+		)`, orderID).Scan(&pending); err != nil {
+		return false, fmt.Errorf("failed to check preorders: %w", err)
+	}
+	return pending, nil
+}
+
+// ChargePreorders is the job handler for EventPreordersFilled. It charges
+// the order to the card chosen at checkout, or else the buyer's default, as
+// Pay would. Orders paid or cancelled since, and buyers without a card, are
+// left alone; the buyer can still pay themselves. The buyer is told when
+// the charge is declined.
+func (s *OrderService) ChargePreorders(ctx context.Context, job *jobs.Job) error {
+	var event PreordersFilledEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal preorders filled event: %w", err)
+	}
+	_, err := s.Pay(ctx, event.OrderID, event.BuyerID, models.PaymentInput{})
+	var declined *PaymentDeclinedError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &declined):
+		return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.BuyerID, "payment_failed", "Payment declined",
+			fmt.Sprintf("Payment for order %s was declined, so it can't ship yet. %s", orderLabel(event.OrderReference, event.OrderNumber), declined.Message()),
+			map[string]interface{}{"orderId": event.OrderID, "reason": declined.Reason})
+	case errors.Is(err, ErrInvalidOrderTransition), errors.Is(err, ErrPaymentMethodNotFound), errors.Is(err, ErrPaymentsUnavailable),
+		errors.Is(err, ErrOrderHeld):
+		log.Info().Err(err).Str("order_id", event.OrderID).Msg("Left order with filled preorders for the buyer to pay")
+		return nil
+	}
+	return err
+}
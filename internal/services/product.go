@@ -0,0 +1,921 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// productColumns is the column list matching scanProduct, for queries
+// aliasing the products table as p
+const productColumns = `p.id, p.seller_id, p.category_id, ` + productCategoryIDs + `, p.title, p.slug, COALESCE(p.description, ''), p.price_cents,
+	COALESCE(p.currency, 'USD'), COALESCE(p.condition, 'new'), ` + productStock + `,
+	p.min_order_qty, p.max_order_qty, p.step_qty, p.purchase_limit_qty, p.purchase_limit_days, COALESCE(p.sku, ''),
+	` + productTagNames + `, p.images, p.specifications, COALESCE(p.is_featured, false), COALESCE(p.is_active, true), p.status, p.published_at,
+	p.avg_rating, p.review_count, p.product_type, p.unit_type, p.bundle_pricing, COALESCE(p.bundle_discount_percent, 0),
+	p.sale_price_cents, p.sale_starts_at, p.sale_ends_at, ` + productPriceTiers + `, COALESCE(p.warehouse, ''), p.processing_days, p.nutrition,
+	p.preorder, p.available_from, p.version, p.created_at, p.updated_at`
+
+// SafeSort is an allowlist of ORDER BY clauses by sort option, and of the
+// collations text sorts may use by locale. Only its fixed strings are ever
+// interpolated into a query. A clause marks where its COLLATE clause goes
+// with {collate}.
+type SafeSort struct {
+	Clauses    map[string]string
+	Collations map[string]string // locale to collation; "" is the default
+}
+
+// OrderBy returns the ORDER BY clause of sort, collated for locale
+func (s SafeSort) OrderBy(sort, locale string) (string, error) {
+	clause, ok := s.Clauses[sort]
+	if !ok {
+		return "", fmt.Errorf("unknown sort %q", sort)
+	}
+	collation, ok := s.Collations[locale]
+	if !ok {
+		return "", fmt.Errorf("unsupported locale %q", locale)
+	}
+	return strings.ReplaceAll(clause, "{collate}", `COLLATE "`+collation+`"`), nil
+}
+
+// productSorts are the listing sorts. {price} is replaced by the price being
+// compared, which is converted when a listing has a currency, and
+// {distance} by the distance of listings near a location. Each collation
+// other than the default has an idx_products_title_* index; keep them in
+// step.
+var productSorts = SafeSort{
+	Clauses: map[string]string{
+		"":           "p.created_at DESC, p.id",
+		"newest":     "p.created_at DESC, p.id",
+		"price_asc":  "{price} ASC NULLS LAST, p.id",
+		"price_desc": "{price} DESC NULLS LAST, p.id",
+		"name_asc":   "p.title {collate} ASC, p.id",
+		"name_desc":  "p.title {collate} DESC, p.id",
+		"distance":   "{distance} ASC, p.id",
+	},
+	Collations: map[string]string{
+		"":   "default",
+		"en": "en-x-icu",
+		"de": "de-x-icu",
+		"fr": "fr-x-icu",
+		"es": "es-x-icu",
+		"sv": "sv-x-icu",
+	},
+}
+
+// Cache tags for catalog listings
+const (
+	tagCategories  = "categories"
+	tagAllProducts = "products:all"
+)
+
+// categoryTag tags listings filtered to a category
+func categoryTag(categoryID string) string {
+	return "category:" + categoryID
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+var (
+	ErrProductNotFound        = errors.New("product not found")
+	ErrProductForbidden       = errors.New("product belongs to another seller")
+	ErrInvalidProductFilter   = errors.New("invalid product filter")
+	ErrProductVersionConflict = errors.New("product has changed since it was read")
+)
+
+// ProductService handles product business logic
+type ProductService struct {
+	db      *database.PostgresDB
+	redis   *database.RedisClient
+	search  SearchBackend
+	cache   *cache.Cache
+	views   config.ViewConfig
+	pricing config.PricingConfig
+	loads   singleflight.Group // concurrent Gets of a product by ID
+
+	embeddings *embeddingStatus
+}
+
+// NewProductService creates a new product service. Product writes are
+// mirrored to the search backend so it stays in sync with the catalog, and
+// invalidate cached listings. views configures view counting for trending
+// products and pricing the limits on bulk repricing.
+func NewProductService(db *database.PostgresDB, redis *database.RedisClient, search SearchBackend, cache *cache.Cache, views config.ViewConfig, pricing config.PricingConfig) *ProductService {
+	return &ProductService{db: db, redis: redis, search: search, cache: cache, views: views, pricing: pricing, embeddings: newEmbeddingStatus(db)}
+}
+
+// Create creates a product listed by sellerID with its tags and categories,
+// opening its stock ledger with the initial stock. Products start as drafts,
+// listed once published. Bundles are created with their components. A SKU
+// another of the seller's products has fails with ErrDuplicateSKU; without a
+// SKU one is generated. Without a slug one is derived from the title; a
+// slug another product has or had fails with ErrDuplicateSlug. Sellers who
+// aren't verified get a *SellerNotVerifiedError.
+func (s *ProductService) Create(ctx context.Context, sellerID string, input models.ProductInput) (*models.Product, error) {
+	normalizeProductInput(&input)
+	if err := checkProductInput(input); err != nil {
+		return nil, err
+	}
+	if input.SKU == "" {
+		input.SKU = generateSKU()
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO products AS p (seller_id, category_id, title, description, price_cents, currency, condition,
+			stock_quantity, sku, images, specifications, product_type, min_order_qty, max_order_qty, step_qty,
+			warehouse, processing_days, unit_type, nutrition, purchase_limit_qty, purchase_limit_days, status, is_active,
+			preorder, available_from, slug)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'new'),
+			$8, NULLIF($9, ''), $10, $11, $12, $13, $14, $15, NULLIF($16, ''), $17, $18, $19, $20, $21, 'draft', false,
+			$22, CASE WHEN $22 THEN $23::timestamptz END, $24)
+		RETURNING %s`, productColumns)
+
+	var product *models.Product
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkSellerVerified(ctx, tx, sellerID); err != nil {
+			return err
+		}
+		if err := notePriceChange(ctx, tx, sellerID, models.PriceChangeCreate); err != nil {
+			return err
+		}
+		if err := checkWarehouse(ctx, tx, input.Warehouse); err != nil {
+			return err
+		}
+		if err := checkSKU(ctx, tx, sellerID, "", input.SKU); err != nil {
+			return err
+		}
+		slug, err := productSlug(ctx, tx, "", input.Title, input.Slug)
+		if err != nil {
+			return err
+		}
+		product, err = scanProduct(tx.QueryRowContext(ctx, query,
+			sellerID, input.CategoryID, input.Title, input.Description, input.Price.Amount, input.Price.Currency, input.Condition,
+			input.StockQuantity, input.SKU, jsonParam(input.Images), jsonParam(input.Specifications),
+			input.Type, input.MinOrderQty, input.MaxOrderQty, input.StepQty, input.Warehouse, input.ProcessingDays,
+			input.UnitType, nutritionParam(input.Nutrition), limitQuantity(input.PurchaseLimit), limitWindow(input.PurchaseLimit),
+			input.Preorder, input.AvailableFrom, slug))
+		if err != nil {
+			return fmt.Errorf("failed to create product: %w", slugConflict(skuConflict(err)))
+		}
+		if err := saveProductTaxonomy(ctx, tx, product.ID, input); err != nil {
+			return err
+		}
+		if input.Type == models.ProductTypeBundle {
+			return saveBundle(ctx, tx, product.ID, sellerID, product.Price.Currency, input.Bundle)
+		}
+		if product.StockQuantity == 0 {
+			return nil
+		}
+		return recordStockMovement(ctx, tx, stockChange{
+			productID: product.ID,
+			delta:     product.StockQuantity,
+			reason:    StockReasonInitial,
+			actorID:   sellerID,
+		}, product.StockQuantity)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Reload for the tags and categories, and a bundle's computed price,
+	// stock and components
+	if product, err = s.getLatest(ctx, product.ID); err != nil {
+		return nil, err
+	}
+	s.index(ctx, product)
+	invalidateProductListings(ctx, s.cache, product.CategoryIDs...)
+	return product, nil
+}
+
+// Get returns an active product by ID, priced as of now. Bundles include
+// their components. Concurrent Gets of the same product in this process share
+// one load, and each caller gets its own copy of the result. A Get may join a
+// load that started just before a change to the product; after writing a
+// product use getLatest instead.
+func (s *ProductService) Get(ctx context.Context, id string) (*models.Product, error) {
+	product, err := coalesceLoad(ctx, &s.loads, id, s.load)
+	if err != nil {
+		return nil, err
+	}
+	product.ApplySaleAt(time.Now())
+	return product, nil
+}
+
+// coalesceLoad loads product id through group, so concurrent calls for the
+// same id share one call of load, and returns each caller its own copy
+func coalesceLoad(ctx context.Context, group *singleflight.Group, id string, load func(context.Context, string) (*models.Product, error)) (*models.Product, error) {
+	// The load is shared, so it must not be cut short by whichever caller
+	// happened to start it; callers still stop waiting when cancelled
+	loadCtx := context.WithoutCancel(ctx)
+	ch := group.DoChan(id, func() (interface{}, error) {
+		return load(loadCtx, id)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*models.Product).Clone(), nil
+	}
+}
+
+// getLatest is Get without joining a load in flight, so the product read
+// reflects every write committed before the call
+func (s *ProductService) getLatest(ctx context.Context, id string) (*models.Product, error) {
+	product, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	product.ApplySaleAt(time.Now())
+	return product, nil
+}
+
+// load reads an active product with its bundle components, at its regular
+// price
+func (s *ProductService) load(ctx context.Context, id string) (*models.Product, error) {
+	query := fmt.Sprintf(`SELECT %s FROM products p WHERE p.id = $1 AND p.deleted_at IS NULL`, productColumns)
+	product, err := scanProduct(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if err := s.addBundleItems(ctx, product); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// GetByIDs returns the active products with ids in one query, keyed by ID
+// (lowercase, as stored) and priced as of now. IDs of products that don't
+// exist are left out. Bundles include their components.
+func (s *ProductService) GetByIDs(ctx context.Context, ids []string) (map[string]*models.Product, error) {
+	query := fmt.Sprintf(`SELECT %s FROM products p WHERE p.id = ANY($1) AND p.deleted_at IS NULL`, productColumns)
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get products: %w", err)
+	}
+	defer rows.Close()
+
+	products := make(map[string]*models.Product, len(ids))
+	var list []*models.Product
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		products[product.ID] = product
+		list = append(list, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get products: %w", err)
+	}
+
+	if err := s.addBundleItems(ctx, list...); err != nil {
+		return nil, err
+	}
+	applySales(time.Now(), list...)
+	return products, nil
+}
+
+// addBundleItems fills in the components of the bundles among products, and
+// what they would cost bought separately, in one query
+func (s *ProductService) addBundleItems(ctx context.Context, products ...*models.Product) error {
+	var bundleIDs []string
+	for _, product := range products {
+		if product.Bundle != nil {
+			bundleIDs = append(bundleIDs, product.ID)
+		}
+	}
+	if len(bundleIDs) == 0 {
+		return nil
+	}
+	components, err := bundleComponents(ctx, s.db, bundleIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		if product.Bundle == nil {
+			continue
+		}
+		product.Bundle.Items = components[product.ID]
+		total := money.Zero(product.Price.Currency)
+		for _, c := range product.Bundle.Items {
+			line, err := c.Price.Mul(int64(c.Quantity))
+			if err == nil {
+				total, err = total.Add(line)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to total bundle components: %w", err)
+			}
+		}
+		product.Bundle.ComponentsTotal = &total
+	}
+	return nil
+}
+
+// Update replaces a product's details. Only the listing seller or an admin
+// may update a product, and its type and unit type cannot change. A changed stock quantity
+// is recorded in the stock ledger as a correction, and percent-off bundles
+// containing the product are repriced. Changing the currency cancels the
+// product's sale and drops its price tiers, whose prices were in the old
+// currency. An input version that
+// is no longer the product's fails with ErrProductVersionConflict. SKUs are
+// checked as on Create; without a SKU the product keeps the one it has.
+// Without a slug the product keeps its own unless the title changed, when a
+// new one is derived. The slug it leaves redirects to the new one.
+func (s *ProductService) Update(ctx context.Context, id, userID string, isAdmin bool, input models.ProductInput) (*models.Product, error) {
+	normalizeProductInput(&input)
+	if err := checkProductInput(input); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE products AS p SET
+			category_id = $2, title = $3, description = $4, price_cents = $5,
+			currency = $6, condition = COALESCE(NULLIF($7, ''), 'new'),
+			stock_quantity = $8, sku = NULLIF($9, ''), images = $10, specifications = $11,
+			min_order_qty = $12, max_order_qty = $13, step_qty = $14,
+			warehouse = NULLIF($15, ''), processing_days = $16, nutrition = $17,
+			purchase_limit_qty = $18, purchase_limit_days = $19,
+			preorder = $20, available_from = CASE WHEN $20 THEN $21::timestamptz END, slug = $22,
+			sale_price_cents = CASE WHEN p.currency = $6 THEN p.sale_price_cents END,
+			sale_starts_at = CASE WHEN p.currency = $6 THEN p.sale_starts_at END,
+			sale_ends_at = CASE WHEN p.currency = $6 THEN p.sale_ends_at END,
+			sale_active = p.sale_active AND p.currency = $6,
+			version = p.version + 1
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+		RETURNING %s`, productColumns)
+
+	var product *models.Product
+	var previousCategoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var previous, version int
+		var productType, unitType, sellerID, sku, currency, title, slug string
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(p.stock_quantity, 0), `+productCategoryIDs+`, p.product_type, p.unit_type, p.seller_id, p.version,
+				COALESCE(p.sku, ''), COALESCE(p.currency, 'USD'), p.title, p.slug
+			FROM products p WHERE p.id = $1 AND p.deleted_at IS NULL FOR UPDATE`,
+			id).Scan(&previous, pq.Array(&previousCategoryIDs), &productType, &unitType, &sellerID, &version, &sku, &currency,
+			&title, &slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get product: %w", err)
+		}
+		if input.Version != nil && *input.Version != version {
+			return fmt.Errorf("%w: version is %d", ErrProductVersionConflict, version)
+		}
+		if productType != input.Type {
+			return &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: "type", Code: "immutable", Param: productType, Message: "type cannot be changed",
+			}}}
+		}
+		// Carts and stock hold the product's quantities in its unit
+		if unitType != input.UnitType {
+			return &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: "unitType", Code: "immutable", Param: unitType, Message: "unitType cannot be changed",
+			}}}
+		}
+
+		if err := checkWarehouse(ctx, tx, input.Warehouse); err != nil {
+			return err
+		}
+		if err := notePriceChange(ctx, tx, userID, models.PriceChangeUpdate); err != nil {
+			return err
+		}
+		if input.SKU == "" {
+			if input.SKU = sku; sku == "" {
+				input.SKU = generateSKU()
+			}
+		}
+		if err := checkSKU(ctx, tx, sellerID, id, input.SKU); err != nil {
+			return err
+		}
+		newSlug := slug
+		if input.Slug != "" || input.Title != title {
+			if newSlug, err = productSlug(ctx, tx, id, input.Title, input.Slug); err != nil {
+				return err
+			}
+		}
+		if currency != input.Price.Currency {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM product_price_tiers WHERE product_id = $1`, id); err != nil {
+				return fmt.Errorf("failed to clear price tiers: %w", err)
+			}
+		}
+		product, err = scanProduct(tx.QueryRowContext(ctx, query,
+			id, input.CategoryID, input.Title, input.Description, input.Price.Amount, input.Price.Currency, input.Condition,
+			input.StockQuantity, input.SKU, jsonParam(input.Images), jsonParam(input.Specifications),
+			input.MinOrderQty, input.MaxOrderQty, input.StepQty, input.Warehouse, input.ProcessingDays,
+			nutritionParam(input.Nutrition), limitQuantity(input.PurchaseLimit), limitWindow(input.PurchaseLimit),
+			input.Preorder, input.AvailableFrom, newSlug))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update product: %w", slugConflict(skuConflict(err)))
+		}
+		if err := moveSlug(ctx, tx, id, slug, newSlug); err != nil {
+			return err
+		}
+		if err := saveProductTaxonomy(ctx, tx, id, input); err != nil {
+			return err
+		}
+
+		if productType == models.ProductTypeBundle {
+			return saveBundle(ctx, tx, id, sellerID, product.Price.Currency, input.Bundle)
+		}
+		if input.StockQuantity != previous {
+			if err := recordStockMovement(ctx, tx, stockChange{
+				productID: id,
+				delta:     input.StockQuantity - previous,
+				reason:    StockReasonCorrection,
+				actorID:   userID,
+			}, input.StockQuantity); err != nil {
+				return err
+			}
+		}
+		return refreshBundlePrices(ctx, tx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if product, err = s.getLatest(ctx, id); err != nil {
+		return nil, err
+	}
+	s.index(ctx, product)
+	invalidateProductListings(ctx, s.cache, append(previousCategoryIDs, product.CategoryIDs...)...)
+	return product, nil
+}
+
+// AddImage appends stored images to a product's images, leaving out any
+// whose key the product already has. Only the listing seller or an admin may
+// add images.
+func (s *ProductService) AddImage(ctx context.Context, id, userID string, isAdmin bool, images ...models.ProductImage) (*models.Product, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(images)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal product image: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE products AS p SET images = COALESCE(p.images, '[]'::jsonb) || COALESCE((
+			SELECT jsonb_agg(i) FROM jsonb_array_elements($2::jsonb) i
+			WHERE NOT COALESCE(p.images, '[]'::jsonb) @> jsonb_build_array(jsonb_build_object('key', i->'key'))
+		), '[]'::jsonb), version = p.version + 1
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+		RETURNING %s`, productColumns)
+
+	product, err := scanProduct(s.db.QueryRowContext(ctx, query, id, string(data)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add product image: %w", err)
+	}
+
+	s.index(ctx, product)
+	invalidateProductListings(ctx, s.cache, product.CategoryIDs...)
+	return product, nil
+}
+
+// Delete soft-deletes a product. Only the listing seller or an admin may
+// delete a product.
+func (s *ProductService) Delete(ctx context.Context, id, userID string, isAdmin bool) error {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return err
+	}
+
+	var categoryIDs []string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE products p SET deleted_at = NOW(), is_active = false
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+		RETURNING `+productCategoryIDs, id).Scan(pq.Array(&categoryIDs))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete product: %w", err)
+	}
+
+	if err := s.search.Delete(ctx, id); err != nil {
+		log.Warn().Err(err).Str("product_id", id).Msg("Failed to remove product from search index")
+	}
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+	return nil
+}
+
+// List returns a page of active products matching filter. Pages other than
+// those near a location are cached briefly per filter and invalidated when a
+// product they may contain changes;
+// prices are worked out after the cache, so cached pages never show a sale
+// that has started or ended since.
+func (s *ProductService) List(ctx context.Context, filter models.ProductFilter) (*models.ProductPage, error) {
+	orderBy, err := productSorts.OrderBy(filter.Sort, filter.Locale)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProductFilter, err)
+	}
+	if filter.Sort == "distance" && filter.Near == nil {
+		return nil, fmt.Errorf("%w: sorting by distance needs a location", ErrInvalidProductFilter)
+	}
+	filter.Tags = normalizeTagFilter(filter.Tags)
+	filter.AllergenFree = normalizeAllergens(filter.AllergenFree)
+	if filter.Near != nil {
+		// Listings near a location are rarely asked for twice, so they
+		// aren't cached
+		page, err := s.list(ctx, filter, orderBy)
+		if err != nil {
+			return nil, err
+		}
+		applySales(time.Now(), page.Products...)
+		return page, nil
+	}
+
+	tag := tagAllProducts
+	if filter.CategoryID != "" {
+		tag = categoryTag(filter.CategoryID)
+	}
+	key := fmt.Sprintf("%s:%s:%g:%g:%s:%s:%g:%s:%s:%s:%d:%d", filter.CategoryID, filter.Condition, filter.MinPrice, filter.MaxPrice,
+		strings.Join(filter.Tags, ","), strings.Join(filter.AllergenFree, ","), filter.MaxCalories,
+		filter.Currency, filter.Sort, filter.Locale, filter.Limit, filter.Offset)
+
+	var page models.ProductPage
+	err = s.cache.GetOrSet(ctx, config.CacheProductList, key, []string{tag}, &page, func(ctx context.Context) (interface{}, error) {
+		return s.list(ctx, filter, orderBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	applySales(time.Now(), page.Products...)
+	return &page, nil
+}
+
+func (s *ProductService) list(ctx context.Context, filter models.ProductFilter, orderBy string) (*models.ProductPage, error) {
+	conditions := []string{"p.deleted_at IS NULL", "COALESCE(p.is_active, true)", sellerListed}
+	var args []interface{}
+	addCondition := func(format string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+	from, columns := "products p", productColumns
+	var near *nearbySellers
+	if filter.Near != nil {
+		var err error
+		if near, err = findNearbySellers(ctx, s.db, *filter.Near); err != nil {
+			return nil, err
+		}
+		if len(near.ids) == 0 {
+			return &models.ProductPage{Products: []*models.Product{}}, nil
+		}
+		args = append(args, pq.Array(near.ids), pq.Array(near.distances))
+		from = fmt.Sprintf(`products p
+			JOIN unnest($%d::uuid[], $%d::float8[]) AS near(seller_id, distance_km) ON near.seller_id = p.seller_id`, len(args)-1, len(args))
+		columns += ", near.distance_km"
+	}
+	if filter.CategoryID != "" {
+		addCondition("EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id = $%d)", filter.CategoryID)
+	}
+	if len(filter.Tags) > 0 {
+		addCondition(`p.id IN (
+			SELECT pt.product_id FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE t.slug = ANY($%[1]d::text[])
+			GROUP BY pt.product_id
+			HAVING COUNT(*) = cardinality($%[1]d::text[]))`, pq.Array(filter.Tags))
+	}
+	if filter.Condition != "" {
+		addCondition("p.condition = $%d", filter.Condition)
+	}
+	// Products without nutrition facts don't declare their allergens or
+	// calories, so they never pass these filters
+	if len(filter.AllergenFree) > 0 {
+		addCondition("p.nutrition IS NOT NULL AND NOT (p.nutrition->'allergens' ?| $%d::text[])", pq.Array(filter.AllergenFree))
+	}
+	if filter.MaxCalories > 0 {
+		addCondition("(p.nutrition->>'calories')::numeric <= $%d", filter.MaxCalories)
+	}
+	// Price bounds are in major units. Without a currency they are compared
+	// exactly against each product's minor units, in its own currency; with
+	// one, each price is converted to it first, which no index can serve.
+	var price string
+	convertedPrice := func() string {
+		if price == "" {
+			args = append(args, filter.Currency)
+			price = fmt.Sprintf(`convert_price(%s::numeric / 10::numeric ^ currency_exponent(COALESCE(p.currency, 'USD')),
+				COALESCE(p.currency, 'USD'), $%d)`, listedPriceCents, len(args))
+		}
+		return price
+	}
+	if filter.Currency != "" {
+		if filter.MinPrice > 0 {
+			addCondition(convertedPrice()+" >= $%d", filter.MinPrice)
+		}
+		if filter.MaxPrice > 0 {
+			addCondition(convertedPrice()+" <= $%d", filter.MaxPrice)
+		}
+	} else {
+		if filter.MinPrice > 0 {
+			addCondition(listedPriceCents+" >= $%d::numeric * 10::numeric ^ currency_exponent(COALESCE(p.currency, 'USD'))", filter.MinPrice)
+		}
+		if filter.MaxPrice > 0 {
+			addCondition(listedPriceCents+" <= $%d::numeric * 10::numeric ^ currency_exponent(COALESCE(p.currency, 'USD'))", filter.MaxPrice)
+		}
+	}
+	where := strings.Join(conditions, " AND ")
+
+	page := &models.ProductPage{Products: []*models.Product{}}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+from+` WHERE `+where, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count products: %w", err)
+	}
+
+	// When only the sort converts prices, its currency isn't a count argument
+	if strings.Contains(orderBy, "{price}") {
+		if filter.Currency != "" {
+			orderBy = strings.ReplaceAll(orderBy, "{price}", convertedPrice())
+		} else {
+			orderBy = strings.ReplaceAll(orderBy, "{price}", listedPriceCents)
+		}
+	}
+	orderBy = strings.ReplaceAll(orderBy, "{distance}", "near.distance_km")
+
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`,
+		columns, from, where, orderBy, len(args)+1, len(args)+2)
+	rows, err := s.db.QueryContext(ctx, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list products: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var extra []interface{}
+		var distance float64
+		if near != nil {
+			extra = append(extra, &distance)
+		}
+		product, err := scanProduct(rows, extra...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		if near != nil {
+			product.DistanceKm = &distance
+		}
+		page.Products = append(page.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list products: %w", err)
+	}
+	return page, nil
+}
+
+// Categories returns the active categories, cached until categories change.
+// Categories sharing a parent are listed in their display order.
+func (s *ProductService) Categories(ctx context.Context) ([]models.Category, error) {
+	var categories []models.Category
+	err := s.cache.GetOrSet(ctx, config.CacheCategories, "active", []string{tagCategories}, &categories, func(ctx context.Context) (interface{}, error) {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT id, name, slug, COALESCE(description, ''), parent_id, COALESCE(icon, ''), COALESCE(color, ''), display_order,
+				COALESCE(created_at, updated_at), updated_at
+			FROM categories
+			WHERE COALESCE(is_active, true)
+			ORDER BY display_order, name`)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list categories: %w", err)
+		}
+		defer rows.Close()
+
+		categories := []models.Category{}
+		for rows.Next() {
+			var c models.Category
+			if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.ParentID, &c.Icon, &c.Color, &c.DisplayOrder, &c.CreatedAt, &c.UpdatedAt); err != nil {
+				return nil, fmt.Errorf("failed to scan category: %w", err)
+			}
+			categories = append(categories, c)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list categories: %w", err)
+		}
+		return categories, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// invalidateProductListings drops cached listings that may include a product
+// in any of categoryIDs. Stale listings expire on their own, so failures are
+// logged rather than failing the write.
+func invalidateProductListings(ctx context.Context, c *cache.Cache, categoryIDs ...string) {
+	tags := []string{tagAllProducts}
+	seen := make(map[string]bool, len(categoryIDs))
+	for _, id := range categoryIDs {
+		if !seen[id] {
+			seen[id] = true
+			tags = append(tags, categoryTag(id))
+		}
+	}
+	if err := c.InvalidateTags(ctx, tags...); err != nil {
+		log.Warn().Err(err).Strs("tags", tags).Msg("Failed to invalidate product listings")
+	}
+}
+
+// authorize checks that userID may modify product id
+func (s *ProductService) authorize(ctx context.Context, id, userID string, isAdmin bool) error {
+	var sellerID string
+	err := s.db.QueryRowContext(ctx, `SELECT seller_id FROM products WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&sellerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get product: %w", err)
+	}
+	if sellerID != userID && !isAdmin {
+		return ErrProductForbidden
+	}
+	return nil
+}
+
+// index mirrors a product write to the search backend. The database is the
+// source of truth, so indexing failures are logged rather than failing the write.
+// Unpublished products and products of suspended sellers are removed from
+// the index instead.
+func (s *ProductService) index(ctx context.Context, product *models.Product) {
+	unlisted := product.Status != models.ProductStatusPublished
+	if !unlisted {
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT seller_status = 'suspended' FROM users WHERE id = $1`, product.SellerID).Scan(&unlisted); err != nil {
+			log.Warn().Err(err).Str("product_id", product.ID).Msg("Failed to get product's seller status")
+		}
+	}
+	if unlisted {
+		if err := s.search.Delete(ctx, product.ID); err != nil {
+			log.Warn().Err(err).Str("product_id", product.ID).Msg("Failed to remove product from index")
+		}
+		return
+	}
+	if err := s.search.Index(ctx, product); err != nil {
+		log.Warn().Err(err).Str("product_id", product.ID).Msg("Failed to index product")
+	}
+}
+
+// normalizeProductInput fills in the defaults of optional product fields
+func normalizeProductInput(input *models.ProductInput) {
+	if input.Type == "" {
+		input.Type = models.ProductTypeSimple
+	}
+	if input.UnitType == "" {
+		input.UnitType = models.UnitTypeEach
+	}
+	// Weights are sold in 100 g steps unless set
+	defaultQty := 1
+	if input.UnitType == models.UnitTypeWeight {
+		defaultQty = models.GramsPerUnit / 10
+	}
+	if input.MinOrderQty == 0 {
+		input.MinOrderQty = defaultQty
+	}
+	if input.StepQty == 0 {
+		input.StepQty = defaultQty
+	}
+	input.SKU = strings.TrimSpace(input.SKU)
+	if input.Nutrition != nil {
+		input.Nutrition.Allergens = normalizeAllergens(input.Nutrition.Allergens)
+	}
+}
+
+// checkProductInput checks the product rules that struct tags can't express
+func checkProductInput(input models.ProductInput) error {
+	var invalid []validators.FieldError
+	if input.MaxOrderQty != nil && *input.MaxOrderQty < input.MinOrderQty {
+		invalid = append(invalid, validators.FieldError{
+			Field: "maxOrderQty", Code: "gtefield", Param: "minOrderQty", Message: "maxOrderQty must be at least minOrderQty",
+		})
+	}
+	isBundle := input.Type == models.ProductTypeBundle
+	if input.Bundle != nil && !isBundle {
+		invalid = append(invalid, validators.FieldError{
+			Field: "bundle", Code: "excluded", Message: "bundle is only allowed on bundle products",
+		})
+	}
+	if isBundle && input.UnitType == models.UnitTypeWeight {
+		invalid = append(invalid, validators.FieldError{
+			Field: "unitType", Code: "bundle", Message: "bundles are sold each",
+		})
+	}
+	if isBundle && input.StockQuantity != 0 {
+		invalid = append(invalid, validators.FieldError{
+			Field: "stockQuantity", Code: "excluded", Message: "bundle stock follows its components",
+		})
+	}
+	invalid = append(invalid, checkTagNames("tags", input.Tags)...)
+	invalid = append(invalid, checkSlugInput(input.Slug)...)
+	invalid = append(invalid, checkNutrition(input.Nutrition)...)
+	percentOff := isBundle && input.Bundle != nil && input.Bundle.Pricing == models.BundlePricingPercentOff
+	switch {
+	case input.Price.Currency == "":
+		invalid = append(invalid, validators.FieldError{
+			Field: "price", Code: "required", Message: "price is required",
+		})
+	case input.Price.Amount < 0 || input.Price.Amount == 0 && !percentOff:
+		invalid = append(invalid, validators.FieldError{
+			Field: "price", Code: "gt", Param: "0", Message: "price must be greater than 0",
+		})
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
+
+// jsonParam converts an optional JSON document to a JSONB query parameter
+func jsonParam(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// scanProduct scans a row selected with productColumns, followed by any extra
+// destinations for additional selected columns
+func scanProduct(row rowScanner, extra ...interface{}) (*models.Product, error) {
+	var p models.Product
+	var images, specifications []byte
+	var bundlePricing sql.NullString
+	var discountPercent float64
+	var salePrice sql.NullInt64
+	var saleStartsAt, saleEndsAt sql.NullTime
+	var tiers, nutrition []byte
+	var limitQty, limitDays sql.NullInt64
+	dest := []interface{}{
+		&p.ID, &p.SellerID, &p.CategoryID, pq.Array(&p.CategoryIDs), &p.Title, &p.Slug, &p.Description, &p.Price.Amount,
+		&p.Price.Currency, &p.Condition, &p.StockQuantity, &p.MinOrderQty, &p.MaxOrderQty, &p.StepQty, &limitQty, &limitDays, &p.SKU,
+		pq.Array(&p.Tags), &images, &specifications, &p.IsFeatured, &p.IsActive, &p.Status, &p.PublishedAt,
+		&p.AvgRating, &p.ReviewCount, &p.Type, &p.UnitType, &bundlePricing, &discountPercent,
+		&salePrice, &saleStartsAt, &saleEndsAt, &tiers, &p.Warehouse, &p.ProcessingDays, &nutrition,
+		&p.Preorder, &p.AvailableFrom, &p.Version, &p.CreatedAt, &p.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	p.Images = images
+	p.Specifications = specifications
+	if nutrition != nil {
+		if err := json.Unmarshal(nutrition, &p.Nutrition); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal nutrition: %w", err)
+		}
+	}
+	if limitQty.Valid {
+		p.PurchaseLimit = &models.PurchaseLimit{Quantity: int(limitQty.Int64), WindowDays: int(limitDays.Int64)}
+	}
+	if p.Type == models.ProductTypeBundle {
+		p.Bundle = &models.Bundle{Pricing: bundlePricing.String, DiscountPercent: discountPercent}
+	}
+	if salePrice.Valid {
+		p.Sale = &models.Sale{
+			Price:    money.New(salePrice.Int64, p.Price.Currency),
+			StartsAt: saleStartsAt.Time,
+			EndsAt:   saleEndsAt.Time,
+		}
+	}
+	priceTiers, err := decodePriceTiers(tiers, p.Price.Currency)
+	if err != nil {
+		return nil, err
+	}
+	p.PriceTiers = priceTiers
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	if p.CategoryIDs == nil {
+		p.CategoryIDs = []string{}
+	}
+	return &p, nil
+}
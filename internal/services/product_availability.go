@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Availability returns whether each of the products ids can be added to a
+// cart now, with its stock and the price charged, in one query. Products
+// that don't exist or are deleted are left out; unlisted ones are
+// unavailable. As the results are shared between buyers, stock held in carts
+// isn't taken off. They are cached per set of ids, as product_availability:
+// product and stock changes drop them sooner, and a sale starting or ending
+// shows within the cache TTL.
+func (s *ProductService) Availability(ctx context.Context, ids []string) (map[string]models.ProductAvailability, error) {
+	sorted := make([]string, len(ids))
+	for i, id := range ids {
+		sorted[i] = strings.ToLower(id)
+	}
+	sort.Strings(sorted)
+	sorted = slices.Compact(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+
+	availability := map[string]models.ProductAvailability{}
+	err := s.cache.GetOrSet(ctx, config.CacheProductAvailability, hex.EncodeToString(sum[:]), []string{tagAllProducts}, &availability, func(ctx context.Context) (interface{}, error) {
+		return s.availability(ctx, sorted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return availability, nil
+}
+
+func (s *ProductService) availability(ctx context.Context, ids []string) (map[string]models.ProductAvailability, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, COALESCE(p.is_active, true) AND `+sellerListed+`, `+productStock+`, `+productPreorderAt("NOW()")+`,
+			`+productPriceAt("NOW()")+`, COALESCE(p.currency, 'USD')
+		FROM products p
+		WHERE p.id = ANY($1::uuid[]) AND p.deleted_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product availability: %w", err)
+	}
+	defer rows.Close()
+
+	availability := make(map[string]models.ProductAvailability, len(ids))
+	for rows.Next() {
+		var id, currency string
+		var listed, preorder bool
+		var a models.ProductAvailability
+		var price int64
+		if err := rows.Scan(&id, &listed, &a.Stock, &preorder, &price, &currency); err != nil {
+			return nil, fmt.Errorf("failed to scan product availability: %w", err)
+		}
+		a.Stock = max(a.Stock, 0)
+		a.Available = listed && (a.Stock > 0 || preorder)
+		a.EffectivePrice = money.New(price, currency)
+		availability[id] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get product availability: %w", err)
+	}
+	return availability, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// CategoryTree returns the active categories as a tree, each level in
+// display order. Categories under an inactive parent are left out with it.
+func (s *ProductService) CategoryTree(ctx context.Context) ([]models.CategoryNode, error) {
+	categories, err := s.Categories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]models.Category)
+	var roots []models.Category
+	for _, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+			continue
+		}
+		children[*c.ParentID] = append(children[*c.ParentID], c)
+	}
+
+	var build func(level []models.Category) []models.CategoryNode
+	build = func(level []models.Category) []models.CategoryNode {
+		nodes := make([]models.CategoryNode, len(level))
+		for i, c := range level {
+			nodes[i] = models.CategoryNode{Category: c, Children: build(children[c.ID])}
+		}
+		return nodes
+	}
+	return build(roots), nil
+}
+
+// ReorderCategories sets the display order of categories sharing a parent in
+// one transaction: those listed come first, in the order given, followed by
+// their other siblings in their current order.
+func (s *ProductService) ReorderCategories(ctx context.Context, input models.CategoryReorderInput) ([]models.Category, error) {
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, parent_id FROM categories WHERE id = ANY($1) FOR UPDATE`, pq.Array(input.IDs))
+		if err != nil {
+			return fmt.Errorf("failed to get categories: %w", err)
+		}
+		parents := make(map[string]*string, len(input.IDs))
+		for rows.Next() {
+			var id string
+			var parentID *string
+			if err := rows.Scan(&id, &parentID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan category: %w", err)
+			}
+			parents[id] = parentID
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get categories: %w", err)
+		}
+
+		var invalid []validators.FieldError
+		parentID := parents[input.IDs[0]]
+		for i, id := range input.IDs {
+			field := fmt.Sprintf("ids[%d]", i)
+			p, ok := parents[id]
+			switch {
+			case !ok:
+				invalid = append(invalid, validators.FieldError{Field: field, Code: "not_found", Message: "category does not exist"})
+			case !sameParent(p, parentID):
+				invalid = append(invalid, validators.FieldError{Field: field, Code: "parent_mismatch", Message: "categories must share a parent"})
+			}
+		}
+		if len(invalid) > 0 {
+			return &validators.ValidationError{Fields: invalid}
+		}
+
+		// Lock the siblings too, so concurrent reorders of a parent's list
+		// apply one after the other
+		if _, err := tx.ExecContext(ctx, `
+			SELECT id FROM categories WHERE parent_id IS NOT DISTINCT FROM $1 FOR UPDATE`, parentID); err != nil {
+			return fmt.Errorf("failed to lock categories: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE categories c SET display_order = o.position
+			FROM (
+				SELECT id, row_number() OVER (ORDER BY array_position($2::uuid[], id) NULLS LAST, display_order, name, id) AS position
+				FROM categories
+				WHERE parent_id IS NOT DISTINCT FROM $1
+			) o
+			WHERE o.id = c.id AND c.display_order IS DISTINCT FROM o.position`,
+			parentID, pq.Array(input.IDs)); err != nil {
+			return fmt.Errorf("failed to reorder categories: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.cache.InvalidateTags(ctx, tagCategories); err != nil {
+		log.Warn().Err(err).Msg("Failed to invalidate cached categories")
+	}
+	return s.Categories(ctx)
+}
+
+func sameParent(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Compare returns products ids side by side, in the order given. Every
+// compared product lists every specification any of them has, as null when
+// it lacks one. IDs of products that don't exist are reported in NotFound.
+func (s *ProductService) Compare(ctx context.Context, ids []string) (*models.ProductComparison, error) {
+	products, err := s.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &models.ProductComparison{
+		Attributes: []string{},
+		Products:   []models.ComparedProduct{},
+		NotFound:   []string{},
+	}
+	specs := make([]map[string]json.RawMessage, 0, len(ids))
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		product, ok := products[strings.ToLower(id)]
+		if !ok {
+			comparison.NotFound = append(comparison.NotFound, id)
+			continue
+		}
+		spec := normalizeSpecifications(product.Specifications)
+		for key := range spec {
+			if !seen[key] {
+				seen[key] = true
+				comparison.Attributes = append(comparison.Attributes, key)
+			}
+		}
+		specs = append(specs, spec)
+		comparison.Products = append(comparison.Products, models.ComparedProduct{
+			ID:            product.ID,
+			Title:         product.Title,
+			Price:         product.Price,
+			AvgRating:     product.AvgRating,
+			ReviewCount:   product.ReviewCount,
+			Condition:     product.Condition,
+			StockQuantity: product.StockQuantity,
+		})
+	}
+	sort.Strings(comparison.Attributes)
+
+	for i := range comparison.Products {
+		attributes := make(map[string]json.RawMessage, len(comparison.Attributes))
+		for _, key := range comparison.Attributes {
+			attributes[key] = specs[i][key] // nil encodes as null
+		}
+		comparison.Products[i].Attributes = attributes
+	}
+	return comparison, nil
+}
+
+// normalizeSpecifications returns the top-level entries of a product's
+// specifications keyed by attributeKey, so "Screen Size" and "screen_size"
+// line up. Specifications that aren't a JSON object have no attributes.
+func normalizeSpecifications(raw json.RawMessage) map[string]json.RawMessage {
+	var spec map[string]json.RawMessage
+	if len(raw) == 0 || json.Unmarshal(raw, &spec) != nil {
+		return nil
+	}
+	normalized := make(map[string]json.RawMessage, len(spec))
+	for key, value := range spec {
+		if key = attributeKey(key); key != "" && string(value) != "null" {
+			normalized[key] = value
+		}
+	}
+	return normalized
+}
+
+// attributeKey lowercases a specification name and joins its words with '_'
+func attributeKey(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(c rune) bool {
+		return c == ' ' || c == '_' || c == '-' || c == '.'
+	}), "_")
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Featured products
+//
+// The homepage featured slot rotates among the products admins made
+// eligible, picked at random in proportion to their weights. The pick is
+// seeded by the rotation the request falls in, so every request in a
+// rotation sees the same products, and the slot changes when the next one
+// starts. Each product's sort key is -ln(u)/weight for u drawn from a hash
+// of its ID and the rotation (weighted sampling without replacement, after
+// Efraimidis and Spirakis), which needs no state and gives a product twice
+// the weight twice the chance of coming first. Products out of stock,
+// unlisted or with a suspended seller are skipped.
+
+// featuredRotation is how long the featured slot keeps one selection
+const featuredRotation = 15 * time.Minute
+
+// featuredOrder orders featured products by their weighted random key in
+// the rotation bound to $1, as the unix time it started
+const featuredOrder = `-LN((('x' || SUBSTR(MD5(p.id::text || ':' || $1::text), 1, 8))::bit(32)::bigint + 1) / 4294967297.0)
+	/ p.featured_weight, p.id`
+
+// Featured returns up to limit products for the featured slot in the
+// rotation now falls in. Selections are cached until the rotation ends and
+// dropped with the other listings when a product changes.
+func (s *ProductService) Featured(ctx context.Context, limit int) (*models.FeaturedProducts, error) {
+	now := time.Now()
+	rotation := now.UTC().Truncate(featuredRotation)
+	rotatesAt := rotation.Add(featuredRotation)
+
+	key := fmt.Sprintf("%d:%d", rotation.Unix(), limit)
+	var featured models.FeaturedProducts
+	err := s.cache.GetOrSetWithTTL(ctx, "featured", key, rotatesAt.Sub(now), []string{tagAllProducts}, &featured, func(ctx context.Context) (interface{}, error) {
+		return s.featured(ctx, rotation, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	featured.RotatesAt = rotatesAt
+	applySales(now, featured.Products...)
+	return &featured, nil
+}
+
+func (s *ProductService) featured(ctx context.Context, rotation time.Time, limit int) (*models.FeaturedProducts, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+productColumns+`
+		FROM products p
+		WHERE p.is_featured AND p.deleted_at IS NULL AND COALESCE(p.is_active, true) AND `+sellerListed+` AND `+productStock+` > 0
+		ORDER BY `+featuredOrder+`
+		LIMIT $2`, rotation.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list featured products: %w", err)
+	}
+	defer rows.Close()
+
+	featured := &models.FeaturedProducts{Products: []*models.Product{}}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		featured.Products = append(featured.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list featured products: %w", err)
+	}
+	return featured, nil
+}
+
+// ListFeatured returns a page of the products eligible for the featured
+// slot, heaviest first, whether or not they can be picked now
+func (s *ProductService) ListFeatured(ctx context.Context, limit, offset int) (*models.FeaturedProductPage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+productColumns+`, p.featured_weight, COUNT(*) OVER() AS total
+		FROM products p
+		WHERE p.is_featured AND p.deleted_at IS NULL
+		ORDER BY p.featured_weight DESC, p.id
+		LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list featured products: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.FeaturedProductPage{Products: []models.FeaturedProduct{}}
+	for rows.Next() {
+		var weight int
+		product, err := scanProduct(rows, &weight, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		page.Products = append(page.Products, models.FeaturedProduct{Product: product, Weight: weight})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list featured products: %w", err)
+	}
+	applySales(time.Now(), productsOf(page.Products)...)
+	return page, nil
+}
+
+// SetFeatured makes product id eligible for the featured slot or not, and
+// sets its weight there when input has one
+func (s *ProductService) SetFeatured(ctx context.Context, id string, input models.FeaturedInput) (*models.FeaturedProduct, error) {
+	var weight int
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE products SET is_featured = $2, featured_weight = COALESCE($3, featured_weight), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING featured_weight`, id, *input.Featured, input.Weight).Scan(&weight)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set featured product: %w", err)
+	}
+
+	product, err := s.getLatest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.index(ctx, product)
+	invalidateProductListings(ctx, s.cache, product.CategoryIDs...)
+	return &models.FeaturedProduct{Product: product, Weight: weight}, nil
+}
+
+// productsOf returns the products of featured
+func productsOf(featured []models.FeaturedProduct) []*models.Product {
+	products := make([]*models.Product, len(featured))
+	for i := range featured {
+		products[i] = featured[i].Product
+	}
+	return products
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Category filters
+//
+// A category's filters are what its listing can be narrowed by: the brands,
+// price range and specification values of its listed products that can be
+// bought now, in stock or on preorder. Brands are the "brand" attribute of
+// specifications. Attributes are matched by attributeKey, as comparisons do,
+// and only scalar values count; an attribute with more distinct values than
+// maxFilterValues, like a model number, is too specific to filter by and is
+// left out. Filters are cached under the category's listing tag, so product
+// and stock changes in it drop them too.
+
+// maxFilterValues is the most distinct values an attribute may have to be
+// offered as a filter
+const maxFilterValues = 50
+
+var ErrCategoryNotFound = errors.New("category not found")
+
+// categoryFilterProducts holds for the products p listed in category $1 that
+// can be bought now
+var categoryFilterProducts = `p.deleted_at IS NULL AND COALESCE(p.is_active, true) AND ` + sellerListed + `
+	AND EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id = $1)
+	AND (` + productStock + ` > 0 OR ` + productPreorderAt("NOW()") + `)`
+
+// CategoryFilters returns the filter values of active category id
+func (s *ProductService) CategoryFilters(ctx context.Context, id string) (*models.CategoryFilters, error) {
+	var filters models.CategoryFilters
+	err := s.cache.GetOrSet(ctx, config.CacheCategoryFilters, id, []string{categoryTag(id)}, &filters, func(ctx context.Context) (interface{}, error) {
+		return s.categoryFilters(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &filters, nil
+}
+
+func (s *ProductService) categoryFilters(ctx context.Context, id string) (*models.CategoryFilters, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1 AND COALESCE(is_active, true))`, id).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to get category: %w", err)
+	}
+	if !exists {
+		return nil, ErrCategoryNotFound
+	}
+
+	filters := &models.CategoryFilters{CategoryID: id, Brands: []models.FilterValue{}, Prices: []models.PriceRange{}, Attributes: []models.FilterAttribute{}}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(p.currency, 'USD'), COUNT(*), MIN(`+listedPriceCents+`), MAX(`+listedPriceCents+`)
+		FROM products p
+		WHERE `+categoryFilterProducts+`
+		GROUP BY 1
+		ORDER BY 2 DESC, 1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category prices: %w", err)
+	}
+	for rows.Next() {
+		var currency string
+		var r models.PriceRange
+		var low, high int64
+		if err := rows.Scan(&currency, &r.Count, &low, &high); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan category prices: %w", err)
+		}
+		r.Min, r.Max = money.New(low, currency), money.New(high, currency)
+		filters.Prices = append(filters.Prices, r)
+		filters.Total += r.Count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get category prices: %w", err)
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT a.key, a.value #>> '{}', COUNT(*)
+		FROM products p
+		CROSS JOIN LATERAL jsonb_each(CASE WHEN jsonb_typeof(p.specifications) = 'object' THEN p.specifications ELSE '{}' END) a
+		WHERE `+categoryFilterProducts+` AND jsonb_typeof(a.value) IN ('string', 'number', 'boolean')
+		GROUP BY 1, 2`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category attributes: %w", err)
+	}
+	defer rows.Close()
+
+	// Keys that normalize alike, like "Brand" and "brand", are merged
+	counts := make(map[string]map[string]int)
+	for rows.Next() {
+		var key, value string
+		var count int
+		if err := rows.Scan(&key, &value, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan category attributes: %w", err)
+		}
+		if key = attributeKey(key); key == "" || value == "" {
+			continue
+		}
+		if counts[key] == nil {
+			counts[key] = make(map[string]int)
+		}
+		counts[key][value] += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get category attributes: %w", err)
+	}
+
+	if brands, ok := counts["brand"]; ok {
+		filters.Brands = filterValues(brands)
+		delete(counts, "brand")
+	}
+	for name, values := range counts {
+		if len(values) <= maxFilterValues {
+			filters.Attributes = append(filters.Attributes, models.FilterAttribute{Name: name, Values: filterValues(values)})
+		}
+	}
+	sort.Slice(filters.Attributes, func(i, j int) bool { return filters.Attributes[i].Name < filters.Attributes[j].Name })
+	return filters, nil
+}
+
+// filterValues lists counts by descending count, then by value
+func filterValues(counts map[string]int) []models.FilterValue {
+	values := make([]models.FilterValue, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, models.FilterValue{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	return values
+}
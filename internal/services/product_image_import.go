@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/storage"
+	"github.com/greens-marketplace/internal/utils"
+)
+
+// ImageExtensions maps accepted image content types to stored file extensions
+var ImageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// imageFetchTimeout bounds the fetch of one image URL
+const imageFetchTimeout = 15 * time.Second
+
+// ImageImporter fetches product images from URLs and stores them like
+// uploaded images. Images are stored under their content hash, so the same
+// image imported again, for any product, reuses the stored blob.
+type ImageImporter struct {
+	store    storage.BlobStore
+	products *ProductService
+	client   *utils.SafeHTTPClient
+}
+
+// NewImageImporter creates a new image importer. maxBytes caps each image,
+// as the upload body limit caps uploaded images.
+func NewImageImporter(store storage.BlobStore, products *ProductService, maxBytes int64) *ImageImporter {
+	return &ImageImporter{
+		store:    store,
+		products: products,
+		client: utils.NewSafeHTTPClient(utils.SafeHTTPOptions{
+			MaxRedirects: 3,
+			MaxBytes:     maxBytes,
+			Timeout:      imageFetchTimeout,
+		}),
+	}
+}
+
+// Import fetches urls and adds the images to a product, skipping any the
+// product already has. Only the listing seller or an admin may add images;
+// the URLs are not fetched otherwise. A URL that cannot be imported is
+// reported in the product's ImageErrors rather than failing the others.
+func (i *ImageImporter) Import(ctx context.Context, id, userID string, isAdmin bool, urls []string) (*models.Product, error) {
+	if err := i.products.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	images := make([]*models.ProductImage, len(urls))
+	imageErrors := make([]*models.ImageError, len(urls))
+	var wg sync.WaitGroup
+	for n, rawURL := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			images[n], imageErrors[n] = i.fetch(ctx, rawURL)
+		}()
+	}
+	wg.Wait()
+
+	var added []models.ProductImage
+	var failed []models.ImageError
+	seen := map[string]bool{}
+	for n := range urls {
+		if imageErrors[n] != nil {
+			failed = append(failed, *imageErrors[n])
+			continue
+		}
+		if !seen[images[n].Key] {
+			seen[images[n].Key] = true
+			added = append(added, *images[n])
+		}
+	}
+
+	var product *models.Product
+	var err error
+	if len(added) > 0 {
+		product, err = i.products.AddImage(ctx, id, userID, isAdmin, added...)
+	} else {
+		product, err = i.products.Get(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	product.ImageErrors = failed
+	return product, nil
+}
+
+// fetch downloads one image, checks its type from its content and stores it
+// under its content hash unless a blob with that hash is already stored
+func (i *ImageImporter) fetch(ctx context.Context, rawURL string) (*models.ProductImage, *models.ImageError) {
+	fail := func(code, message string) (*models.ProductImage, *models.ImageError) {
+		return nil, &models.ImageError{URL: rawURL, Code: code, Message: message}
+	}
+
+	resp, body, err := i.client.Get(ctx, rawURL)
+	switch {
+	case errors.Is(err, utils.ErrUnsafeURL), errors.Is(err, utils.ErrUnsafeAddress):
+		return fail("unsafe_url", err.Error())
+	case errors.Is(err, utils.ErrResponseTooLarge):
+		return fail("too_large", "image is larger than the upload limit")
+	case err != nil:
+		return fail("fetch_failed", "image could not be fetched")
+	case resp.StatusCode != http.StatusOK:
+		return fail("fetch_failed", fmt.Sprintf("image URL returned %d", resp.StatusCode))
+	}
+
+	contentType := http.DetectContentType(body)
+	ext, ok := ImageExtensions[contentType]
+	if !ok {
+		return fail("unsupported_media_type", "image must be JPEG, PNG, GIF or WebP")
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	key := fmt.Sprintf("products/sha256/%s/%s%s", hash[:2], hash, ext)
+	info, err := i.store.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		info, err = i.store.Put(ctx, key, bytes.NewReader(body), contentType)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Failed to store imported image")
+		return fail("fetch_failed", "image could not be stored")
+	}
+	return &models.ProductImage{
+		URL:         "/images/" + key,
+		Key:         key,
+		ContentType: contentType,
+		Size:        info.Size,
+	}, nil
+}
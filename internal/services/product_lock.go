@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// lockedStock is a product row locked for a stock change
+type lockedStock struct {
+	sellerID    string
+	title       string
+	quantity    int
+	categoryIDs []string
+	isBundle    bool
+	deleted     bool
+	listed      bool
+}
+
+// LockProductForUpdate locks the row of product productID until tx ends, so
+// that multi-step stock logic on the product within tx runs one transaction
+// at a time. A transaction locking several products must lock them together
+// with LockProductsForUpdate to keep the lock order consistent.
+func (s *ProductService) LockProductForUpdate(ctx context.Context, tx *sql.Tx, productID string) error {
+	return s.LockProductsForUpdate(ctx, tx, []string{productID})
+}
+
+// LockProductsForUpdate locks the rows of products ids until tx ends. Rows
+// are locked in ID order, so transactions spanning the same products can't
+// deadlock. It returns ErrProductNotFound if any product doesn't exist.
+func (s *ProductService) LockProductsForUpdate(ctx context.Context, tx *sql.Tx, ids []string) error {
+	products, err := lockStock(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if p, ok := products[id]; !ok || p.deleted {
+			return ErrProductNotFound
+		}
+	}
+	return nil
+}
+
+// lockStock locks the rows of products ids in ID order, whatever the order
+// of ids. Every stock change locks its products here, so concurrent orders
+// and adjustments take their locks in the same order and can't deadlock.
+func lockStock(ctx context.Context, tx *sql.Tx, ids []string) (map[string]lockedStock, error) {
+	return readStock(ctx, tx, ids, true)
+}
+
+// readStock reads the stock of products ids, locking their rows in ID order
+// when lock is set. Unlocked reads are for checks that change nothing.
+func readStock(ctx context.Context, tx *sql.Tx, ids []string, lock bool) (map[string]lockedStock, error) {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+
+	// The row locks are taken as the sorted rows are returned
+	query := `
+		SELECT p.id, p.seller_id, p.title, COALESCE(p.stock_quantity, 0), ` + productCategoryIDs + `,
+			p.product_type, p.deleted_at IS NOT NULL, COALESCE(p.is_active, true)
+		FROM products p
+		WHERE p.id = ANY($1)
+		ORDER BY p.id`
+	if lock {
+		query += `
+		FOR UPDATE`
+	}
+	rows, err := tx.QueryContext(ctx, query, pq.Array(sorted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock products: %w", err)
+	}
+	defer rows.Close()
+
+	products := make(map[string]lockedStock, len(ids))
+	for rows.Next() {
+		var id, productType string
+		var active bool
+		var p lockedStock
+		if err := rows.Scan(&id, &p.sellerID, &p.title, &p.quantity, pq.Array(&p.categoryIDs),
+			&productType, &p.deleted, &active); err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		p.isBundle = productType == models.ProductTypeBundle
+		p.listed = !p.deleted && active
+		products[id] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to lock products: %w", err)
+	}
+	return products, nil
+}
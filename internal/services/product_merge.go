@@ -0,0 +1,383 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Product merges
+//
+// An admin folds a duplicate listing, the source, into the listing it
+// duplicates, the target, in one transaction. The source's reviews, wishlist
+// and cart lines and order items move to the target, and its stock is added
+// to the target's through the ledger. Users who had both keep their line
+// for the target; their line for the source is dropped. The source is
+// soft-deleted pointing at the target, so reading it redirects there. The
+// merge records every row it moved, and can be reverted until its undo
+// window closes; a revert takes back only as much stock as the target still
+// has.
+
+// productMergeUndoWindow is how long a merge can be reverted
+const productMergeUndoWindow = 7 * 24 * time.Hour
+
+var (
+	// ErrProductMergeNotFound is returned when a product merge does not exist
+	ErrProductMergeNotFound = errors.New("product merge not found")
+	// ErrProductMergeReverted is returned when reverting a merge twice
+	ErrProductMergeReverted = errors.New("product merge has already been reverted")
+	// ErrProductMergeExpired is returned when reverting a merge after its
+	// undo window
+	ErrProductMergeExpired = errors.New("product merge can no longer be reverted")
+)
+
+// ProductMergedError is returned when reading a product that was merged into
+// another. It is also ErrProductNotFound, so callers that don't redirect
+// treat the product as gone.
+type ProductMergedError struct {
+	TargetID string
+}
+
+func (e *ProductMergedError) Error() string {
+	return "product was merged into " + e.TargetID
+}
+
+// Is makes a merged product not found
+func (e *ProductMergedError) Is(target error) bool {
+	return target == ErrProductNotFound
+}
+
+const productMergeColumns = `id, source_id, target_id, merged_by, cardinality(review_ids),
+	cardinality(wishlist_ids) + jsonb_array_length(dropped_wishlist), cardinality(cart_ids) + jsonb_array_length(dropped_cart),
+	cardinality(order_item_ids), stock_moved, created_at, undo_until, reverted_at, reverted_by, stock_returned`
+
+// Merge merges product input.SourceID into input.TargetID. Both must be
+// listed by the same seller, sold by the same unit and not bundles, and the
+// source must not be a bundle component; otherwise it fails with a
+// *validators.ValidationError.
+func (s *ProductService) Merge(ctx context.Context, adminID string, input models.ProductMergeInput) (*models.ProductMerge, error) {
+	sourceID, targetID := strings.ToLower(input.SourceID), strings.ToLower(input.TargetID)
+	if sourceID == targetID {
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "targetId", Code: "nefield", Param: "sourceId", Message: "targetId must not be sourceId",
+		}}}
+	}
+
+	id := uuid.NewString()
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		products, err := lockStock(ctx, tx, []string{sourceID, targetID})
+		if err != nil {
+			return err
+		}
+		source, ok := products[sourceID]
+		if !ok || source.deleted {
+			return ErrProductNotFound
+		}
+		target, ok := products[targetID]
+		if !ok || target.deleted {
+			return ErrProductNotFound
+		}
+		if err := checkMergeable(ctx, tx, sourceID, targetID, source, target); err != nil {
+			return err
+		}
+		categoryIDs = append(source.categoryIDs, target.categoryIDs...)
+
+		var reviewIDs, orderItemIDs []string
+		if err := returnIDs(ctx, tx, &reviewIDs, `
+			UPDATE reviews SET product_id = $2 WHERE product_id = $1 RETURNING id`, sourceID, targetID); err != nil {
+			return fmt.Errorf("failed to move reviews: %w", err)
+		}
+		if err := returnIDs(ctx, tx, &orderItemIDs, `
+			UPDATE order_items SET product_id = $2 WHERE product_id = $1 RETURNING id`, sourceID, targetID); err != nil {
+			return fmt.Errorf("failed to move order items: %w", err)
+		}
+		wishlistIDs, droppedWishlist, err := moveUserLines(ctx, tx, "wishlist", sourceID, targetID)
+		if err != nil {
+			return err
+		}
+		cartIDs, droppedCart, err := moveUserLines(ctx, tx, "cart", sourceID, targetID)
+		if err != nil {
+			return err
+		}
+
+		if source.quantity > 0 {
+			for _, change := range []stockChange{
+				{productID: sourceID, delta: -source.quantity, reason: StockReasonMerge, referenceID: id, actorID: adminID},
+				{productID: targetID, delta: source.quantity, reason: StockReasonMerge, referenceID: id, actorID: adminID},
+			} {
+				if _, err := changeStock(ctx, tx, change); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE products SET deleted_at = NOW(), is_active = false, merged_into = $2 WHERE id = $1`,
+			sourceID, targetID); err != nil {
+			return fmt.Errorf("failed to delete merged product: %w", err)
+		}
+		if err := refreshRatings(ctx, tx, sourceID, targetID); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO product_merges (id, source_id, target_id, merged_by, review_ids, wishlist_ids, cart_ids, order_item_ids,
+				dropped_wishlist, dropped_cart, stock_moved, undo_until)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW() + make_interval(secs => $12))`,
+			id, sourceID, targetID, adminID, pq.Array(reviewIDs), pq.Array(wishlistIDs), pq.Array(cartIDs), pq.Array(orderItemIDs),
+			droppedWishlist, droppedCart, max(source.quantity, 0), productMergeUndoWindow.Seconds())
+		if err != nil {
+			return fmt.Errorf("failed to record product merge: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.search.Delete(ctx, sourceID); err != nil {
+		log.Warn().Err(err).Str("product_id", sourceID).Msg("Failed to remove product from search index")
+	}
+	s.merged(ctx, categoryIDs, targetID)
+	return s.GetMerge(ctx, id)
+}
+
+// RevertMerge undoes a merge within its undo window: the source is restored
+// and the rows the merge moved go back to it, with the lines it dropped.
+// Stock goes back up to the merged amount, as much as the target still has.
+// Rows changed since, such as a cart line the user removed, stay as they are.
+func (s *ProductService) RevertMerge(ctx context.Context, adminID, id string) (*models.ProductMerge, error) {
+	var categoryIDs []string
+	var sourceID, targetID string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var reviewIDs, wishlistIDs, cartIDs, orderItemIDs []string
+		var droppedWishlist, droppedCart string
+		var stockMoved int
+		var reverted, expired bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT source_id, target_id, review_ids, wishlist_ids, cart_ids, order_item_ids, dropped_wishlist, dropped_cart,
+				stock_moved, reverted_at IS NOT NULL, undo_until <= NOW()
+			FROM product_merges WHERE id = $1
+			FOR UPDATE`, id).Scan(&sourceID, &targetID, pq.Array(&reviewIDs), pq.Array(&wishlistIDs), pq.Array(&cartIDs),
+			pq.Array(&orderItemIDs), &droppedWishlist, &droppedCart, &stockMoved, &reverted, &expired)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProductMergeNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get product merge: %w", err)
+		}
+		switch {
+		case reverted:
+			return ErrProductMergeReverted
+		case expired:
+			return ErrProductMergeExpired
+		}
+
+		products, err := lockStock(ctx, tx, []string{sourceID, targetID})
+		if err != nil {
+			return err
+		}
+		categoryIDs = append(products[sourceID].categoryIDs, products[targetID].categoryIDs...)
+
+		for _, move := range []struct {
+			table string
+			ids   []string
+		}{{"reviews", reviewIDs}, {"order_items", orderItemIDs}, {"wishlist", wishlistIDs}, {"cart", cartIDs}} {
+			// A user who since added the source again keeps that line
+			query := fmt.Sprintf(`UPDATE %[1]s m SET product_id = $1 WHERE m.id = ANY($3) AND m.product_id = $2`, move.table)
+			if move.table == "wishlist" || move.table == "cart" {
+				query += fmt.Sprintf(` AND NOT EXISTS (SELECT 1 FROM %s o WHERE o.user_id = m.user_id AND o.product_id = $1)`, move.table)
+			}
+			if _, err := tx.ExecContext(ctx, query, sourceID, targetID, pq.Array(move.ids)); err != nil {
+				return fmt.Errorf("failed to move %s back: %w", move.table, err)
+			}
+		}
+		for table, dropped := range map[string]string{"wishlist": droppedWishlist, "cart": droppedCart} {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+				INSERT INTO %[1]s SELECT * FROM jsonb_populate_recordset(NULL::%[1]s, $1)
+				ON CONFLICT DO NOTHING`, table), dropped); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", table, err)
+			}
+		}
+
+		returned := min(stockMoved, max(products[targetID].quantity, 0))
+		if returned > 0 {
+			for _, change := range []stockChange{
+				{productID: targetID, delta: -returned, reason: StockReasonMerge, referenceID: id, actorID: adminID},
+				{productID: sourceID, delta: returned, reason: StockReasonMerge, referenceID: id, actorID: adminID},
+			} {
+				if _, err := changeStock(ctx, tx, change); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE products SET deleted_at = NULL, merged_into = NULL, is_active = (status = 'published') WHERE id = $1`,
+			sourceID); err != nil {
+			return fmt.Errorf("failed to restore merged product: %w", err)
+		}
+		if err := refreshRatings(ctx, tx, sourceID, targetID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE product_merges SET reverted_at = NOW(), reverted_by = $2, stock_returned = $3 WHERE id = $1`,
+			id, adminID, returned); err != nil {
+			return fmt.Errorf("failed to record product merge revert: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.merged(ctx, categoryIDs, sourceID, targetID)
+	return s.GetMerge(ctx, id)
+}
+
+// GetMerge returns a product merge by ID
+func (s *ProductService) GetMerge(ctx context.Context, id string) (*models.ProductMerge, error) {
+	var m models.ProductMerge
+	var returned sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT `+productMergeColumns+` FROM product_merges WHERE id = $1`, id).Scan(
+		&m.ID, &m.SourceID, &m.TargetID, &m.MergedBy, &m.Reviews, &m.WishlistItems, &m.CartItems, &m.OrderItems,
+		&m.StockMoved, &m.CreatedAt, &m.UndoUntil, &m.RevertedAt, &m.RevertedBy, &returned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductMergeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product merge: %w", err)
+	}
+	if returned.Valid {
+		n := int(returned.Int64)
+		m.StockReturned = &n
+	}
+	return &m, nil
+}
+
+// mergedInto returns the ID of the product that product id was merged into,
+// or "" when it wasn't
+func (s *ProductService) mergedInto(ctx context.Context, id string) (string, error) {
+	var target sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT merged_into FROM products WHERE id = $1`, id).Scan(&target)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to get merged product: %w", err)
+	}
+	return target.String, nil
+}
+
+// merged brings the search index and cached listings up to date with the
+// products ids after a merge or revert
+func (s *ProductService) merged(ctx context.Context, categoryIDs []string, ids ...string) {
+	for _, id := range ids {
+		product, err := s.getLatest(ctx, id)
+		if err != nil {
+			log.Warn().Err(err).Str("product_id", id).Msg("Failed to reload product after merge")
+			continue
+		}
+		s.index(ctx, product)
+	}
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+}
+
+// checkMergeable checks that source can be merged into target
+func checkMergeable(ctx context.Context, tx *sql.Tx, sourceID, targetID string, source, target lockedStock) error {
+	var invalid []validators.FieldError
+	if source.sellerID != target.sellerID {
+		invalid = append(invalid, validators.FieldError{
+			Field: "targetId", Code: "seller", Message: "products of different sellers can't be merged",
+		})
+	}
+	if source.isBundle || target.isBundle {
+		invalid = append(invalid, validators.FieldError{
+			Field: "sourceId", Code: "bundle", Message: "bundles can't be merged",
+		})
+	}
+	var sameUnit, component bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT (SELECT unit_type FROM products WHERE id = $1) = (SELECT unit_type FROM products WHERE id = $2),
+			EXISTS (SELECT 1 FROM product_bundle_items WHERE product_id = $1)`,
+		sourceID, targetID).Scan(&sameUnit, &component); err != nil {
+		return fmt.Errorf("failed to check products: %w", err)
+	}
+	if !sameUnit {
+		invalid = append(invalid, validators.FieldError{
+			Field: "targetId", Code: "unit_type", Message: "products sold by different units can't be merged",
+		})
+	}
+	if component {
+		invalid = append(invalid, validators.FieldError{
+			Field: "sourceId", Code: "bundle_component", Message: "the source is part of a bundle; take it out of its bundles first",
+		})
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
+
+// moveUserLines moves the source's lines in table, wishlist or cart, to the
+// target, returning the IDs of those moved and, as a JSON array, the rows of
+// users who already had the target, which are dropped
+func moveUserLines(ctx context.Context, tx *sql.Tx, table, sourceID, targetID string) ([]string, string, error) {
+	var dropped string
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf(`
+		WITH dropped AS (
+			DELETE FROM %[1]s m
+			WHERE m.product_id = $1 AND EXISTS (SELECT 1 FROM %[1]s t WHERE t.user_id = m.user_id AND t.product_id = $2)
+			RETURNING m.*
+		)
+		SELECT COALESCE(jsonb_agg(to_jsonb(dropped)), '[]') FROM dropped`, table), sourceID, targetID).Scan(&dropped); err != nil {
+		return nil, "", fmt.Errorf("failed to drop duplicate %s lines: %w", table, err)
+	}
+	var moved []string
+	if err := returnIDs(ctx, tx, &moved, fmt.Sprintf(`
+		UPDATE %s SET product_id = $2 WHERE product_id = $1 RETURNING id`, table), sourceID, targetID); err != nil {
+		return nil, "", fmt.Errorf("failed to move %s lines: %w", table, err)
+	}
+	return moved, dropped, nil
+}
+
+// returnIDs runs query in tx, collecting the IDs it returns into ids
+func returnIDs(ctx context.Context, tx *sql.Tx, ids *[]string, query string, args ...interface{}) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	*ids = []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		*ids = append(*ids, id)
+	}
+	return rows.Err()
+}
+
+// refreshRatings recomputes the rating aggregates of products ids from their
+// visible reviews
+func refreshRatings(ctx context.Context, tx *sql.Tx, ids ...string) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE products p SET avg_rating = agg.avg_rating, review_count = agg.review_count
+		FROM (
+			SELECT i.id, COALESCE(ROUND(AVG(r.rating), 2), 0) AS avg_rating, COUNT(r.id) AS review_count
+			FROM unnest($1::uuid[]) AS i(id)
+			LEFT JOIN reviews r ON r.product_id = i.id AND `+reviewVisible+`
+			GROUP BY i.id
+		) agg
+		WHERE p.id = agg.id`, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to update product ratings: %w", err)
+	}
+	return nil
+}
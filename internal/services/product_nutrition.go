@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// kcalPerGram is the most energy a gram of food can hold, that of fat
+const kcalPerGram = 9
+
+// ErrNutritionNotFound is returned when a product has no nutrition facts
+var ErrNutritionNotFound = errors.New("product has no nutrition facts")
+
+// Nutrition returns product id's nutrition facts, as viewerID sees the
+// product (see GetAs)
+func (s *ProductService) Nutrition(ctx context.Context, id, viewerID string, isAdmin bool) (*models.ProductNutrition, error) {
+	product, err := s.GetAs(ctx, id, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if product.Nutrition == nil {
+		return nil, ErrNutritionNotFound
+	}
+	return &models.ProductNutrition{ProductID: product.ID, Title: product.Title, Nutrition: product.Nutrition}, nil
+}
+
+// checkNutrition checks the nutrition rules that struct tags can't express:
+// known allergens, and nutrients that fit in the serving. Millilitres aren't
+// converted, so servings measured in them are only checked for nutrients
+// exceeding the nutrients they are part of.
+func checkNutrition(n *models.Nutrition) []validators.FieldError {
+	if n == nil {
+		return nil
+	}
+	var invalid []validators.FieldError
+	for _, allergen := range n.Allergens {
+		if !slices.Contains(models.Allergens, allergen) {
+			invalid = append(invalid, validators.FieldError{
+				Field: "nutrition.allergens", Code: "oneof", Param: strings.Join(models.Allergens, " "),
+				Message: fmt.Sprintf("unknown allergen %q; allergens must be among: %s", allergen, strings.Join(models.Allergens, ", ")),
+			})
+		}
+	}
+	for _, part := range []struct {
+		field, of  string
+		value, max *float64
+	}{
+		{"saturatedFat", "fat", n.SaturatedFat, n.Fat},
+		{"sugars", "carbohydrates", n.Sugars, n.Carbohydrates},
+	} {
+		if part.value != nil && part.max != nil && *part.value > *part.max {
+			invalid = append(invalid, validators.FieldError{
+				Field: "nutrition." + part.field, Code: "ltefield", Param: part.of,
+				Message: fmt.Sprintf("nutrition.%s must be at most nutrition.%s", part.field, part.of),
+			})
+		}
+	}
+	if n.ServingUnit != "g" {
+		return invalid
+	}
+
+	var grams float64
+	for _, v := range []*float64{n.Fat, n.Carbohydrates, n.Fiber, n.Protein, n.Salt} {
+		if v != nil {
+			grams += *v
+		}
+	}
+	if grams > n.ServingSize {
+		invalid = append(invalid, validators.FieldError{
+			Field: "nutrition", Code: "serving", Param: "servingSize",
+			Message: "nutrition's fat, carbohydrates, fiber, protein and salt must add up to at most servingSize",
+		})
+	}
+	if n.Calories > kcalPerGram*n.ServingSize {
+		invalid = append(invalid, validators.FieldError{
+			Field: "nutrition.calories", Code: "serving", Param: "servingSize",
+			Message: fmt.Sprintf("nutrition.calories must be at most %d kcal per gram of servingSize", kcalPerGram),
+		})
+	}
+	return invalid
+}
+
+// normalizeAllergens lowercases, sorts and deduplicates allergens
+func normalizeAllergens(allergens []string) []string {
+	if allergens == nil {
+		return nil
+	}
+	normalized := make([]string, 0, len(allergens))
+	for _, allergen := range allergens {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(allergen)))
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// nutritionParam is n as a JSONB parameter, NULL when there is none
+func nutritionParam(n *models.Nutrition) interface{} {
+	if n == nil {
+		return nil
+	}
+	raw, _ := json.Marshal(n)
+	return string(raw)
+}
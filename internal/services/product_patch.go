@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// patchAttempts is how many times a patch without a version is applied to a
+// product that keeps changing before it is saved
+const patchAttempts = 3
+
+// ErrInvalidProductPatch is returned when a patch's field values can't be
+// read as the fields they replace
+var ErrInvalidProductPatch = errors.New("invalid product patch")
+
+// Patch changes only the fields of product id present in patch and then
+// saves it like Update. The patch is applied to the product as read, so a
+// patch with a version fails with ErrProductVersionConflict if the product
+// has changed since the client read it. A patch without one is applied again
+// to the changed product instead, so a concurrent edit of other fields is
+// never lost.
+func (s *ProductService) Patch(ctx context.Context, id, userID string, isAdmin bool, patch models.ProductPatch) (*models.Product, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		current, err := s.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		input, err := applyProductPatch(current, patch)
+		if err != nil {
+			return nil, err
+		}
+		versioned := input.Version != nil
+		if !versioned {
+			input.Version = &current.Version
+		}
+
+		product, err := s.Update(ctx, id, userID, isAdmin, input)
+		if errors.Is(err, ErrProductVersionConflict) && !versioned && attempt < patchAttempts {
+			continue
+		}
+		return product, err
+	}
+}
+
+// applyProductPatch returns the input of current with the fields in patch
+// replaced, checked against the input's struct tags
+func applyProductPatch(current *models.Product, patch models.ProductPatch) (models.ProductInput, error) {
+	var input models.ProductInput
+	base, err := json.Marshal(current.Input())
+	if err != nil {
+		return input, fmt.Errorf("failed to marshal product: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(base, &fields); err != nil {
+		return input, fmt.Errorf("failed to unmarshal product: %w", err)
+	}
+
+	var invalid []validators.FieldError
+	for _, name := range slices.Sorted(maps.Keys(patch)) {
+		if _, ok := fields[name]; !ok {
+			invalid = append(invalid, validators.FieldError{
+				Field: name, Code: "unknown", Message: fmt.Sprintf("%s is not a product field", name),
+			})
+			continue
+		}
+		fields[name] = patch[name]
+	}
+	if len(invalid) > 0 {
+		return input, &validators.ValidationError{Fields: invalid}
+	}
+
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return input, fmt.Errorf("failed to marshal product patch: %w", err)
+	}
+	if err := json.Unmarshal(merged, &input); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return input, &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: typeErr.Field, Code: "type", Param: typeErr.Type.String(),
+				Message: fmt.Sprintf("%s must be of type %s", typeErr.Field, typeErr.Type),
+			}}}
+		}
+		return input, fmt.Errorf("%w: %v", ErrInvalidProductPatch, err)
+	}
+	return input, validators.Validate(input)
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Price history
+//
+// A trigger on products records every change of a regular or sale price in
+// product_price_history, so no write path can miss one. Write paths name the
+// actor and reason of the changes their transaction makes with
+// notePriceChange; changes made without are recorded as system changes.
+
+// notePriceChange names actorID and reason as the actor and reason of the
+// price changes the rest of tx makes
+func notePriceChange(ctx context.Context, tx *sql.Tx, actorID, reason string) error {
+	if _, err := tx.ExecContext(ctx, `
+		SELECT set_config('greens.price_actor', $1, true), set_config('greens.price_reason', $2, true)`,
+		actorID, reason); err != nil {
+		return fmt.Errorf("failed to note price change: %w", err)
+	}
+	return nil
+}
+
+// PublicPriceHistory returns a page of a listed product's price changes
+// over the last pricing.public_history_days days, newest first, without
+// their reasons and actors
+func (s *ProductService) PublicPriceHistory(ctx context.Context, productID string, limit, offset int) (*models.PriceHistoryPage, error) {
+	var listed bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(is_active, true) FROM products WHERE id = $1 AND deleted_at IS NULL`, productID).Scan(&listed)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !listed {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	since := time.Now().AddDate(0, 0, -s.pricing.PublicHistoryDays)
+	return s.priceHistory(ctx, productID, since, false, limit, offset)
+}
+
+// SellerPriceHistory returns a page of a product's whole price history,
+// newest first. Only the listing seller may view it.
+func (s *ProductService) SellerPriceHistory(ctx context.Context, productID, sellerID string, limit, offset int) (*models.PriceHistoryPage, error) {
+	var owner string
+	err := s.db.QueryRowContext(ctx, `SELECT seller_id FROM products WHERE id = $1 AND deleted_at IS NULL`, productID).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if owner != sellerID {
+		return nil, ErrProductForbidden
+	}
+	return s.priceHistory(ctx, productID, time.Time{}, true, limit, offset)
+}
+
+// priceHistory returns a page of a product's price changes since since,
+// with their reasons and actors when full
+func (s *ProductService) priceHistory(ctx context.Context, productID string, since time.Time, full bool, limit, offset int) (*models.PriceHistoryPage, error) {
+	page := &models.PriceHistoryPage{Changes: []models.PriceChange{}}
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM product_price_history WHERE product_id = $1 AND created_at >= $2`,
+		productID, since).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count price changes: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, kind, old_price_cents, new_price_cents, COALESCE(old_currency, currency), currency,
+			sale_starts_at, sale_ends_at, reason, changed_by, created_at
+		FROM product_price_history
+		WHERE product_id = $1 AND created_at >= $2
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4`, productID, since, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list price changes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.PriceChange
+		var oldPrice, newPrice sql.NullInt64
+		var oldCurrency, currency string
+		if err := rows.Scan(&c.ID, &c.Kind, &oldPrice, &newPrice, &oldCurrency, &currency,
+			&c.SaleStartsAt, &c.SaleEndsAt, &c.Reason, &c.ActorID, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan price change: %w", err)
+		}
+		if oldPrice.Valid {
+			old := money.New(oldPrice.Int64, oldCurrency)
+			c.OldPrice = &old
+		}
+		if newPrice.Valid {
+			price := money.New(newPrice.Int64, currency)
+			c.NewPrice = &price
+		}
+		if !full {
+			c.Reason, c.ActorID = "", nil
+		}
+		page.Changes = append(page.Changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list price changes: %w", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Product recategorization
+//
+// When the catalog is restructured an admin moves many products to another
+// category in one transaction, chosen by ID or by filter: those in a
+// category, with a tag, or both. Each product leaves its source category,
+// the one filtered by or else its primary one, and joins the target, which
+// becomes its primary category. The target must be active. Deleted products
+// are skipped, and products already only in the target are left alone. The
+// listings and filters of every category the products were in, and of the
+// target, are dropped from the cache so they are recomputed, and the move is
+// recorded for the audit trail.
+
+// Recategorize moves the products chosen by input to its target category on
+// behalf of admin adminID
+func (s *ProductService) Recategorize(ctx context.Context, adminID string, input models.RecategorizeInput) (*models.Recategorization, error) {
+	byFilter := input.FromCategoryID != nil || input.Tag != ""
+	slug := tagSlug(input.Tag)
+	switch {
+	case len(input.ProductIDs) == 0 && !byFilter:
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "productIds", Code: "required_without", Param: "fromCategoryId tag", Message: "productIds, fromCategoryId or tag is required",
+		}}}
+	case len(input.ProductIDs) > 0 && byFilter:
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "productIds", Code: "excluded_with", Param: "fromCategoryId tag", Message: "productIds cannot be combined with fromCategoryId or tag",
+		}}}
+	case input.Tag != "" && slug == "":
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "tag", Code: "slug", Message: "tag must contain a letter or digit",
+		}}}
+	case input.FromCategoryID != nil && strings.EqualFold(*input.FromCategoryID, input.TargetCategoryID):
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "targetCategoryId", Code: "nefield", Param: "fromCategoryId", Message: "targetCategoryId must differ from fromCategoryId",
+		}}}
+	}
+
+	record := &models.Recategorization{
+		TargetCategoryID: input.TargetCategoryID,
+		FromCategoryID:   input.FromCategoryID,
+		Skipped:          []string{},
+		NotFound:         []string{},
+		RecategorizedBy:  &adminID,
+	}
+	if slug != "" {
+		record.Tag = &slug
+	}
+	var moved, categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkRecategorizeCategories(ctx, tx, input); err != nil {
+			return err
+		}
+
+		// A product is unchanged when its primary category is the target
+		// and it isn't in the source category filtered by
+		unchanged := "p.category_id IS NOT DISTINCT FROM $1::uuid"
+		args := []interface{}{input.TargetCategoryID}
+		if input.FromCategoryID != nil {
+			args = append(args, *input.FromCategoryID)
+			unchanged += " AND NOT EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id = $2)"
+		}
+		var conditions []string
+		addCondition := func(format string, arg interface{}) {
+			args = append(args, arg)
+			conditions = append(conditions, fmt.Sprintf(format, len(args)))
+		}
+		if len(input.ProductIDs) > 0 {
+			addCondition("p.id = ANY($%d)", pq.Array(input.ProductIDs))
+		}
+		if input.FromCategoryID != nil {
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id = $2)")
+		}
+		if slug != "" {
+			addCondition(`EXISTS (
+				SELECT 1 FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+				WHERE pt.product_id = p.id AND t.slug = $%d)`, slug)
+		}
+
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+			SELECT p.id::text, p.deleted_at IS NOT NULL, %s
+			FROM products p
+			WHERE %s
+			ORDER BY p.id
+			FOR UPDATE OF p`, unchanged, strings.Join(conditions, " AND ")), args...)
+		if err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+		exists := make(map[string]bool)
+		for rows.Next() {
+			var id string
+			var deleted, unchanged bool
+			if err := rows.Scan(&id, &deleted, &unchanged); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan product: %w", err)
+			}
+			exists[id] = true
+			switch {
+			case deleted:
+				record.Skipped = append(record.Skipped, id)
+			case unchanged:
+				record.Unchanged++
+			default:
+				moved = append(moved, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+		for _, id := range input.ProductIDs {
+			if !exists[strings.ToLower(id)] {
+				record.NotFound = append(record.NotFound, id)
+			}
+		}
+		record.Moved = len(moved)
+
+		if len(moved) > 0 {
+			// Listings of the categories left change too
+			if categoryIDs, err = categoriesOf(ctx, tx, moved); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM product_categories pc USING products p
+				WHERE p.id = pc.product_id AND p.id = ANY($1) AND pc.category_id = COALESCE($2::uuid, p.category_id)`,
+				pq.Array(moved), input.FromCategoryID); err != nil {
+				return fmt.Errorf("failed to detach categories: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO product_categories (product_id, category_id)
+				SELECT p, $2 FROM unnest($1::uuid[]) p
+				ON CONFLICT DO NOTHING`, pq.Array(moved), input.TargetCategoryID); err != nil {
+				return fmt.Errorf("failed to attach categories: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE products SET category_id = $2 WHERE id = ANY($1)`,
+				pq.Array(moved), input.TargetCategoryID); err != nil {
+				return fmt.Errorf("failed to update primary categories: %w", err)
+			}
+		}
+
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO product_recategorizations (target_category_id, from_category_id, tag, product_ids, skipped_ids, recategorized_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at`,
+			input.TargetCategoryID, input.FromCategoryID, record.Tag, pq.Array(moved), pq.Array(record.Skipped), adminID,
+		).Scan(&record.ID, &record.CreatedAt); err != nil {
+			return fmt.Errorf("failed to record recategorization: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(moved) > 0 {
+		s.reindex(ctx, moved)
+		invalidateProductListings(ctx, s.cache, append(categoryIDs, input.TargetCategoryID)...)
+	}
+	return record, nil
+}
+
+// checkRecategorizeCategories checks that the target category of input
+// exists and is active, and that its source category, if any, exists
+func checkRecategorizeCategories(ctx context.Context, tx *sql.Tx, input models.RecategorizeInput) error {
+	var active bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(is_active, true) FROM categories WHERE id = $1`, input.TargetCategoryID).Scan(&active)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "targetCategoryId", Code: "not_found", Message: "category not found",
+		}}}
+	case err != nil:
+		return fmt.Errorf("failed to get category: %w", err)
+	case !active:
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "targetCategoryId", Code: "archived", Message: "category is archived",
+		}}}
+	}
+
+	if input.FromCategoryID == nil {
+		return nil
+	}
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, *input.FromCategoryID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to get category: %w", err)
+	}
+	if !exists {
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "fromCategoryId", Code: "not_found", Message: "category not found",
+		}}}
+	}
+	return nil
+}
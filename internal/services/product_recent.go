@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// recentlyViewedKey is the Redis list of the products userID viewed last,
+// most recent first and without repeats
+func recentlyViewedKey(userID string) string {
+	return "views:recent:" + userID
+}
+
+// TrackRecentlyViewed moves productID to the front of userID's recently
+// viewed products, dropping the oldest past the configured limit. It writes
+// in the background and returns at once, so the read it follows is not
+// slowed down; failures are logged.
+func (s *ProductService) TrackRecentlyViewed(ctx context.Context, userID, productID string) {
+	if userID == "" || s.views.RecentLimit <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	go func() {
+		defer cancel()
+		key := recentlyViewedKey(userID)
+		_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LRem(ctx, key, 0, productID)
+			pipe.LPush(ctx, key, productID)
+			pipe.LTrim(ctx, key, 0, int64(s.views.RecentLimit-1))
+			pipe.Expire(ctx, key, time.Duration(s.views.RecentTTL)*time.Second)
+			return nil
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("product_id", productID).Msg("Failed to track recently viewed product")
+		}
+	}()
+}
+
+// RecentlyViewed returns userID's recently viewed products, most recent
+// first. Guests have no list kept for them, so for an empty userID the
+// products of ids are returned in the order given instead, which clients
+// keep themselves.
+func (s *ProductService) RecentlyViewed(ctx context.Context, userID string, ids []string) (*models.RecentlyViewed, error) {
+	recent := &models.RecentlyViewed{Products: []models.RecentlyViewedProduct{}}
+	if s.views.RecentLimit <= 0 {
+		return recent, nil
+	}
+	if userID != "" {
+		var err error
+		ids, err = s.redis.LRange(ctx, recentlyViewedKey(userID), 0, int64(s.views.RecentLimit-1)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recently viewed products: %w", err)
+		}
+	}
+	ids = ids[:min(len(ids), s.views.RecentLimit)]
+	if len(ids) == 0 {
+		return recent, nil
+	}
+
+	products, err := s.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		product, ok := products[id]
+		if !ok || !product.IsActive {
+			continue
+		}
+		recent.Products = append(recent.Products, models.RecentlyViewedProduct{Product: product, InStock: product.StockQuantity > 0})
+	}
+	return recent, nil
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// repriceLimit is the most products one bulk reprice may pick
+const repriceLimit = 1000
+
+// repriced is a product picked by a bulk reprice, as it was before
+type repriced struct {
+	id, title  string
+	price      money.Money
+	salePrice  sql.NullInt64
+	saleStarts sql.NullTime
+	saleEnds   sql.NullTime
+	computed   bool // a percent-off bundle
+	listed     bool
+}
+
+// Reprice changes the regular prices of sellerID's products picked by
+// input's filter in one transaction, recording each change in the price
+// history and publishing EventPriceDrop for listed products not on sale
+// whose price went down. With input.DryRun the new prices are worked out the
+// same way but nothing is saved. A product is skipped, with the reason, when
+// its price is computed, when a delta or price is in another currency, or
+// when its new price would be unchanged, below the currency's floor or not
+// above its scheduled sale price. The filter may pick at most repriceLimit
+// products.
+func (s *ProductService) Reprice(ctx context.Context, sellerID string, input models.RepriceInput) (*models.RepriceResult, error) {
+	conditions := []string{"p.seller_id = $1", "p.deleted_at IS NULL"}
+	args := []interface{}{sellerID}
+	if input.CategoryID != "" {
+		args = append(args, input.CategoryID)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id = $%d)", len(args)))
+	}
+	if input.Tag != "" {
+		args = append(args, tagSlug(input.Tag))
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.product_id = p.id AND t.slug = $%d)`, len(args)))
+	}
+
+	now := time.Now()
+	result := &models.RepriceResult{DryRun: input.DryRun, Changed: []models.RepricedProduct{}, Skipped: []models.RepricedProduct{}}
+	var changedIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := notePriceChange(ctx, tx, sellerID, models.PriceChangeReprice); err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+			SELECT p.id, p.title, p.price_cents, COALESCE(p.currency, 'USD'), p.sale_price_cents, p.sale_starts_at, p.sale_ends_at,
+				COALESCE(p.product_type = 'bundle' AND p.bundle_pricing = 'percent_off', false), COALESCE(p.is_active, true)
+			FROM products p
+			WHERE %s
+			ORDER BY p.title, p.id
+			LIMIT %d
+			FOR UPDATE`, strings.Join(conditions, " AND "), repriceLimit+1), args...)
+		if err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+		var products []repriced
+		for rows.Next() {
+			var p repriced
+			if err := rows.Scan(&p.id, &p.title, &p.price.Amount, &p.price.Currency, &p.salePrice, &p.saleStarts, &p.saleEnds,
+				&p.computed, &p.listed); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan product: %w", err)
+			}
+			products = append(products, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+		if len(products) > repriceLimit {
+			return &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: "filter", Code: "max", Param: fmt.Sprint(repriceLimit),
+				Message: fmt.Sprintf("the filter picks more than %d products; narrow it with categoryId or tag", repriceLimit),
+			}}}
+		}
+
+		for _, p := range products {
+			item := models.RepricedProduct{ProductID: p.id, Title: p.title, OldPrice: p.price}
+			newPrice, reason, message := s.repricePrice(p, input, now)
+			if reason != models.RepriceSkipComputed && reason != models.RepriceSkipCurrency {
+				item.NewPrice = &newPrice
+			}
+			if reason != "" {
+				item.Reason, item.Message = reason, message
+				result.Skipped = append(result.Skipped, item)
+				continue
+			}
+			result.Changed = append(result.Changed, item)
+			changedIDs = append(changedIDs, p.id)
+			if input.DryRun {
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE products SET price_cents = $2, version = version + 1 WHERE id = $1`, p.id, newPrice.Amount); err != nil {
+				return fmt.Errorf("failed to reprice product: %w", err)
+			}
+			if err := refreshBundlePrices(ctx, tx, p.id); err != nil {
+				return err
+			}
+			onSale := p.salePrice.Valid && p.saleStarts.Time.Compare(now) <= 0 && p.saleEnds.Time.After(now)
+			if newPrice.Amount < p.price.Amount && p.listed && !onSale {
+				if err := WriteOutbox(ctx, tx, EventPriceDrop, p.id, PriceDropEvent{
+					ProductID: p.id, Title: p.title, RegularPrice: p.price, SalePrice: newPrice,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if input.DryRun || len(changedIDs) == 0 {
+		return result, nil
+	}
+
+	// Bundles repriced from their components change too, so every listing
+	// of the seller's categories may be stale
+	var categoryIDs []string
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(DISTINCT pc.category_id::text), '{}') FROM product_categories pc
+		WHERE pc.product_id = ANY($1)`, pq.Array(changedIDs)).Scan(pq.Array(&categoryIDs)); err != nil {
+		return nil, fmt.Errorf("failed to get product categories: %w", err)
+	}
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+	for _, id := range changedIDs {
+		if product, err := s.getLatest(ctx, id); err == nil {
+			s.index(ctx, product)
+		}
+	}
+	return result, nil
+}
+
+// repricePrice returns p's new regular price under input, or the reason and
+// message p is skipped for
+func (s *ProductService) repricePrice(p repriced, input models.RepriceInput, now time.Time) (money.Money, string, string) {
+	if p.computed {
+		return money.Money{}, models.RepriceSkipComputed, "the bundle is priced from its components"
+	}
+	var newPrice money.Money
+	switch input.Operation {
+	case models.RepricePercent:
+		newPrice = money.New(int64(math.Round(float64(p.price.Amount)*(100+input.Percent)/100)), p.price.Currency)
+	default:
+		if input.Amount.Currency != p.price.Currency {
+			return money.Money{}, models.RepriceSkipCurrency,
+				fmt.Sprintf("the product is priced in %s, not %s", p.price.Currency, input.Amount.Currency)
+		}
+		newPrice = *input.Amount
+		if input.Operation == models.RepriceDelta {
+			var err error
+			if newPrice, err = p.price.Add(*input.Amount); err != nil {
+				return money.Money{}, models.RepriceSkipCurrency, err.Error()
+			}
+		}
+	}
+
+	floor := s.priceFloor(p.price.Currency)
+	switch {
+	case newPrice == p.price:
+		return newPrice, models.RepriceSkipUnchanged, "the price would not change"
+	case newPrice.Amount < floor.Amount:
+		return newPrice, models.RepriceSkipBelowFloor, fmt.Sprintf("the price would be below the floor of %s", floor)
+	case p.salePrice.Valid && p.saleEnds.Time.After(now) && newPrice.Amount <= p.salePrice.Int64:
+		return newPrice, models.RepriceSkipBelowSale,
+			fmt.Sprintf("the price would not be above the sale price of %s", money.New(p.salePrice.Int64, p.price.Currency))
+	}
+	return newPrice, "", ""
+}
+
+// priceFloor returns the lowest price a bulk reprice may set in currency
+func (s *ProductService) priceFloor(currency string) money.Money {
+	if floor, err := money.Parse(s.pricing.Floors[currency], currency); err == nil && floor.Amount > 0 {
+		return floor
+	}
+	return money.New(1, currency)
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Scheduled sales
+//
+// The price a product is charged is worked out from its sale window at a
+// given instant: in Go with models.Product.ApplySaleAt for reads, and in SQL
+// with productPriceAt for carts and checkout. Both use the same rule, so a
+// price shown and charged for the same instant always agree. sale_active is
+// a materialized copy of the rule, kept current by RunSaleTransitions, that
+// lets listings filter and sort on an indexed price; it can lag by a
+// transition interval but never decides what is shown or charged.
+
+// EventPriceDrop is published when a sale on a listed product starts, or
+// when a bulk reprice cuts the regular price of one not on sale
+const EventPriceDrop = "product.price_drop"
+
+// listedPriceCents is the price listings filter and sort on, matching the
+// idx_products_listed_price expression index
+const listedPriceCents = `(CASE WHEN p.sale_active THEN p.sale_price_cents ELSE p.price_cents END)`
+
+// productPriceAt returns the SQL for the price charged for product p at the
+// instant bound to param. It must agree with models.Sale.ActiveAt.
+func productPriceAt(param string) string {
+	return fmt.Sprintf(`CASE WHEN p.sale_price_cents IS NOT NULL AND p.sale_starts_at <= %[1]s AND p.sale_ends_at > %[1]s
+		THEN p.sale_price_cents ELSE p.price_cents END`, param)
+}
+
+// PriceDropEvent is the payload of EventPriceDrop. For a cut of the regular
+// price SalePrice is the new price and RegularPrice the old one, with no end.
+type PriceDropEvent struct {
+	ProductID    string      `json:"productId"`
+	Title        string      `json:"title"`
+	RegularPrice money.Money `json:"regularPrice"`
+	SalePrice    money.Money `json:"salePrice"`
+	EndsAt       *time.Time  `json:"endsAt,omitempty"`
+}
+
+// applySales prices products as of at
+func applySales(at time.Time, products ...*models.Product) {
+	for _, p := range products {
+		p.ApplySaleAt(at)
+	}
+}
+
+// SetSale schedules a sale of product id, replacing any it had. The sale
+// price must be below the regular price and in the product's currency, and
+// the sale must not have ended already. Only the listing seller or an admin
+// may schedule a sale.
+func (s *ProductService) SetSale(ctx context.Context, id, userID string, isAdmin bool, input models.SaleInput) (*models.Product, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var title string
+		var regular money.Money
+		var wasActive, listed bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT title, price_cents, COALESCE(currency, 'USD'), sale_active, COALESCE(is_active, true)
+			FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			id).Scan(&title, &regular.Amount, &regular.Currency, &wasActive, &listed)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get product: %w", err)
+		}
+		if err := checkSaleInput(input, regular, now); err != nil {
+			return err
+		}
+		if err := notePriceChange(ctx, tx, userID, models.PriceChangeSale); err != nil {
+			return err
+		}
+
+		active := (&models.Sale{StartsAt: input.StartsAt, EndsAt: input.EndsAt}).ActiveAt(now)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE products SET sale_price_cents = $2, sale_starts_at = $3, sale_ends_at = $4, sale_active = $5,
+				version = version + 1
+			WHERE id = $1`, id, input.Price.Amount, input.StartsAt, input.EndsAt, active); err != nil {
+			return fmt.Errorf("failed to schedule sale: %w", err)
+		}
+		if active && !wasActive && listed {
+			return WriteOutbox(ctx, tx, EventPriceDrop, id, PriceDropEvent{
+				ProductID: id, Title: title, RegularPrice: regular, SalePrice: input.Price, EndsAt: &input.EndsAt,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.saleChanged(ctx, id)
+}
+
+// ClearSale cancels product id's sale, running or scheduled. Only the
+// listing seller or an admin may cancel a sale.
+func (s *ProductService) ClearSale(ctx context.Context, id, userID string, isAdmin bool) (*models.Product, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := notePriceChange(ctx, tx, userID, models.PriceChangeSale); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `
+			UPDATE products SET sale_price_cents = NULL, sale_starts_at = NULL, sale_ends_at = NULL, sale_active = false,
+				version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL`, id)
+		if err != nil {
+			return fmt.Errorf("failed to cancel sale: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrProductNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.saleChanged(ctx, id)
+}
+
+// ApplySaleTransitions brings sale_active up to date with every sale window
+// as of now, publishing EventPriceDrop for each listed product whose sale
+// started. It returns the number of products whose sale started or ended.
+// Each transition is applied by one UPDATE, so replicas running it at the
+// same time never publish a price drop twice.
+func (s *ProductService) ApplySaleTransitions(ctx context.Context) (int, error) {
+	var changed []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		changed = changed[:0]
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE products p SET sale_active = NOT p.sale_active
+			WHERE (p.sale_price_cents IS NOT NULL OR p.sale_active)
+				AND p.sale_active <> COALESCE(p.sale_starts_at <= $1 AND p.sale_ends_at > $1, false)
+			RETURNING p.id, p.sale_active, p.deleted_at IS NULL AND COALESCE(p.is_active, true), p.title,
+				p.price_cents, COALESCE(p.sale_price_cents, 0), COALESCE(p.currency, 'USD'), p.sale_ends_at`, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to apply sale transitions: %w", err)
+		}
+		var drops []PriceDropEvent
+		for rows.Next() {
+			var started, listed bool
+			var event PriceDropEvent
+			var currency string
+			var endsAt sql.NullTime
+			if err := rows.Scan(&event.ProductID, &started, &listed, &event.Title,
+				&event.RegularPrice.Amount, &event.SalePrice.Amount, &currency, &endsAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan sale transition: %w", err)
+			}
+			changed = append(changed, event.ProductID)
+			if started && listed {
+				event.RegularPrice.Currency, event.SalePrice.Currency = currency, currency
+				event.EndsAt = &endsAt.Time
+				drops = append(drops, event)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to apply sale transitions: %w", err)
+		}
+
+		for _, event := range drops {
+			if err := WriteOutbox(ctx, tx, EventPriceDrop, event.ProductID, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range changed {
+		s.saleChanged(ctx, id)
+	}
+	return len(changed), nil
+}
+
+// RunSaleTransitions runs ApplySaleTransitions as a scheduled task
+func (s *ProductService) RunSaleTransitions(ctx context.Context) error {
+	if _, err := s.ApplySaleTransitions(ctx); err != nil {
+		return fmt.Errorf("sale transitions failed: %w", err)
+	}
+	return nil
+}
+
+// NotifyPriceDrop is the job handler for EventPriceDrop, notifying users
+// with the product on their wishlist. Users who set a target price are only
+// notified once the new price reaches it; a target in a currency the product
+// is no longer priced in can't be compared, so it doesn't hold back the
+// notification.
+func (s *ProductService) NotifyPriceDrop(ctx context.Context, job *jobs.Job) error {
+	var event PriceDropEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal price drop event: %w", err)
+	}
+	message := fmt.Sprintf("%s is now %s (was %s)", event.Title, event.SalePrice, event.RegularPrice)
+	if event.EndsAt != nil {
+		message = fmt.Sprintf("%s is on sale for %s (was %s) until %s",
+			event.Title, event.SalePrice, event.RegularPrice, event.EndsAt.UTC().Format("Jan 2 15:04 MST"))
+	}
+	// Only the integer amount and the quoted currency are interpolated
+	recipients := fmt.Sprintf(`
+		SELECT user_id FROM wishlist
+		WHERE product_id = $1
+			AND (target_price_cents IS NULL OR target_currency <> %s OR target_price_cents >= %d)`,
+		pq.QuoteLiteral(event.SalePrice.Currency), event.SalePrice.Amount)
+	return notifyProductEvent(ctx, s.db, job.ID, recipients, event.ProductID,
+		"price_drop", "Price drop", message, event.ProductID)
+}
+
+// saleChanged refreshes the search document and cached listings of product
+// id after its sale changed, returning the product priced as of now
+func (s *ProductService) saleChanged(ctx context.Context, id string) (*models.Product, error) {
+	product, err := s.getLatest(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrProductNotFound) {
+			log.Warn().Err(err).Str("product_id", id).Msg("Failed to reload product after sale change")
+		}
+		return nil, err
+	}
+	s.index(ctx, product)
+	invalidateProductListings(ctx, s.cache, product.CategoryIDs...)
+	return product, nil
+}
+
+// checkSaleInput checks a sale against the product's regular price as of now
+func checkSaleInput(input models.SaleInput, regular money.Money, now time.Time) error {
+	var invalid []validators.FieldError
+	switch {
+	case input.Price.Currency != regular.Currency:
+		invalid = append(invalid, validators.FieldError{
+			Field: "price", Code: "currency", Param: regular.Currency,
+			Message: fmt.Sprintf("price must be in the product's currency, %s", regular.Currency),
+		})
+	case input.Price.Amount <= 0:
+		invalid = append(invalid, validators.FieldError{
+			Field: "price", Code: "gt", Param: "0", Message: "price must be greater than 0",
+		})
+	case input.Price.Amount >= regular.Amount:
+		invalid = append(invalid, validators.FieldError{
+			Field: "price", Code: "lt", Param: regular.Decimal(),
+			Message: fmt.Sprintf("price must be less than the regular price of %s", regular.Decimal()),
+		})
+	}
+	if !input.EndsAt.After(now) {
+		invalid = append(invalid, validators.FieldError{
+			Field: "endsAt", Code: "future", Message: "endsAt must be in the future",
+		})
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// embeddingCheckInterval is how long whether product_embeddings has any rows
+// is remembered
+const embeddingCheckInterval = time.Minute
+
+// embeddingStatus remembers whether any product has an embedding, so
+// similarity and semantic search can fall back before querying an empty
+// table. It is checked at most once per embeddingCheckInterval.
+type embeddingStatus struct {
+	db *database.PostgresDB
+
+	mu        sync.Mutex
+	present   bool
+	checkedAt time.Time
+}
+
+func newEmbeddingStatus(db *database.PostgresDB) *embeddingStatus {
+	return &embeddingStatus{db: db}
+}
+
+// available reports whether any product has an embedding. A failed check
+// counts as none, so callers fall back rather than fail.
+func (e *embeddingStatus) available(ctx context.Context) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.checkedAt.IsZero() && time.Since(e.checkedAt) < embeddingCheckInterval {
+		return e.present
+	}
+
+	var present bool
+	if err := e.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM product_embeddings WHERE combined_embedding IS NOT NULL)`).Scan(&present); err != nil {
+		log.Warn().Err(err).Msg("Failed to check product embeddings")
+		return false
+	}
+	if !present {
+		log.Warn().Msg("No product embeddings, similar products and semantic search fall back; reindex embeddings")
+	}
+	e.present, e.checkedAt = present, time.Now()
+	return present
+}
+
+// Similar returns up to limit listed products closest to a product by
+// embedding, priced as of now. While there are no embeddings, or the product
+// has none, it falls back to Related and flags the result. The product is
+// found as viewerID sees it (see GetAs).
+func (s *ProductService) Similar(ctx context.Context, id, viewerID string, isAdmin bool, limit int) (*models.SimilarProducts, error) {
+	product, err := s.GetAs(ctx, id, viewerID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.embeddings.available(ctx) {
+		query := fmt.Sprintf(`
+			SELECT %s
+			FROM product_embeddings target
+			JOIN product_embeddings pe ON pe.product_id <> target.product_id AND pe.combined_embedding IS NOT NULL
+			JOIN products p ON p.id = pe.product_id
+			WHERE target.product_id = $1 AND target.combined_embedding IS NOT NULL
+			AND p.is_active = true AND p.deleted_at IS NULL AND `+sellerListed+`
+			ORDER BY pe.combined_embedding <=> target.combined_embedding
+			LIMIT $2`, productColumns)
+		products, err := s.queryProducts(ctx, query, id, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(products) > 0 {
+			similar := &models.SimilarProducts{Products: make([]models.SimilarProduct, len(products))}
+			reason := models.RecommendationReason{Type: models.RecommendationSimilar, Reference: models.RecommendationReference{
+				Type: models.RecommendationRefProduct, ID: product.ID, Name: product.Title,
+			}}
+			for i, p := range products {
+				similar.Products[i] = models.SimilarProduct{Product: p, Reason: reason}
+			}
+			return similar, nil
+		}
+	}
+
+	related, err := s.Related(ctx, product, limit)
+	if err != nil {
+		return nil, err
+	}
+	similar := &models.SimilarProducts{Products: make([]models.SimilarProduct, len(related)), Fallback: true}
+	for i, p := range related {
+		similar.Products[i] = models.SimilarProduct{Product: p, Reason: relatedReason(product, p)}
+	}
+	return similar, nil
+}
+
+// relatedReason returns the strongest reason Related ranked related for
+// product, from their tags and categories in the order it ranks them:
+// shared tags, then product's primary category, then any other category
+// they share
+func relatedReason(product, related *models.Product) models.RecommendationReason {
+	var shared []string
+	for _, tag := range related.Tags {
+		if slices.Contains(product.Tags, tag) {
+			shared = append(shared, tag)
+		}
+	}
+	if len(shared) > 0 {
+		return models.RecommendationReason{Type: models.RecommendationSharedTags, Reference: models.RecommendationReference{
+			Type: models.RecommendationRefTag, ID: shared[0], Count: int64(len(shared)),
+		}}
+	}
+	if product.CategoryID != nil && related.CategoryID != nil && *related.CategoryID == *product.CategoryID {
+		return models.RecommendationReason{Type: models.RecommendationSameCategory, Reference: models.RecommendationReference{
+			Type: models.RecommendationRefCategory, ID: *product.CategoryID,
+		}}
+	}
+	for _, categoryID := range related.CategoryIDs {
+		if slices.Contains(product.CategoryIDs, categoryID) {
+			return models.RecommendationReason{Type: models.RecommendationSharedCategory, Reference: models.RecommendationReference{
+				Type: models.RecommendationRefCategory, ID: categoryID,
+			}}
+		}
+	}
+	// Related only returns products sharing one or the other
+	return models.RecommendationReason{Type: models.RecommendationSharedCategory, Reference: models.RecommendationReference{
+		Type: models.RecommendationRefCategory,
+	}}
+}
+
+// Related returns up to limit listed products sharing a category or tag with
+// product, priced as of now: those sharing the most tags first, then those
+// in its primary category, then the best rated
+func (s *ProductService) Related(ctx context.Context, product *models.Product, limit int) ([]*models.Product, error) {
+	query := fmt.Sprintf(`
+		WITH shared AS (
+			SELECT pt.product_id, COUNT(*) AS tags
+			FROM product_tags pt
+			WHERE pt.tag_id IN (SELECT tag_id FROM product_tags WHERE product_id = $1)
+			GROUP BY pt.product_id
+		)
+		SELECT %s
+		FROM products p
+		LEFT JOIN shared ON shared.product_id = p.id
+		WHERE p.id <> $1 AND p.is_active = true AND p.deleted_at IS NULL AND `+sellerListed+`
+		AND (shared.product_id IS NOT NULL OR EXISTS (
+			SELECT 1 FROM product_categories pc
+			WHERE pc.product_id = p.id AND pc.category_id IN (SELECT category_id FROM product_categories WHERE product_id = $1)))
+		ORDER BY COALESCE(shared.tags, 0) DESC, (p.category_id IS NOT DISTINCT FROM $3) DESC,
+			p.avg_rating DESC, p.created_at DESC
+		LIMIT $2`, productColumns)
+	return s.queryProducts(ctx, query, product.ID, limit, product.CategoryID)
+}
+
+// queryProducts runs a query selecting productColumns and returns its
+// products in order, priced as of now
+func (s *ProductService) queryProducts(ctx context.Context, query string, args ...interface{}) ([]*models.Product, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get products: %w", err)
+	}
+	defer rows.Close()
+
+	products := []*models.Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get products: %w", err)
+	}
+	applySales(time.Now(), products...)
+	return products, nil
+}
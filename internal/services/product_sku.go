@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateSKU = errors.New("sku is already used by another of the seller's products")
+
+// productSKUIndex is the unique index on the SKUs of a seller's live products
+const productSKUIndex = "products_seller_sku_key"
+
+// generateSKU returns a SKU for a product listed without one
+func generateSKU() string {
+	return "SKU-" + strings.ToUpper(strings.ReplaceAll(uuid.NewString(), "-", "")[:12])
+}
+
+// checkSKU fails with ErrDuplicateSKU when a live product of sellerID other
+// than productID, which is empty for a new product, already has sku. It
+// gives a clear error up front; the unique index still decides races,
+// through skuConflict.
+func checkSKU(ctx context.Context, tx *sql.Tx, sellerID, productID, sku string) error {
+	var existing string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM products
+		WHERE seller_id = $1 AND sku = $2 AND deleted_at IS NULL AND id IS DISTINCT FROM NULLIF($3, '')::uuid
+		LIMIT 1`, sellerID, sku, productID).Scan(&existing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check sku: %w", err)
+	}
+	return fmt.Errorf("%w: product %s has sku %q", ErrDuplicateSKU, existing, sku)
+}
+
+// skuConflict returns ErrDuplicateSKU for a violation of the unique SKU
+// index, and err otherwise
+func skuConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == productSKUIndex {
+		return ErrDuplicateSKU
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Product slugs
+//
+// Every product has a unique slug naming it in URLs, derived from its title
+// as tag slugs are, or chosen by its seller. A slug another product has, or
+// had, is taken: a chosen one fails with ErrDuplicateSlug and a derived one
+// gets the lowest free numeric suffix. A product's slug follows its title
+// when the title changes, unless a slug is given. The slugs it had before
+// are kept in slug_history, so old links redirect to the current one, and
+// it may take them back.
+
+var ErrDuplicateSlug = errors.New("slug is already used by another product")
+
+// productSlugIndex is the unique index on product slugs
+const productSlugIndex = "products_slug_key"
+
+// maxSlugLength is the longest slug; derived slugs are cut shorter to leave
+// room for a suffix
+const (
+	maxSlugLength     = 120
+	maxBaseSlugLength = 100
+)
+
+// slugPattern matches URL-safe slugs: lowercase ASCII letters and digits in
+// runs joined by single dashes
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ProductSlugMovedError is returned for a slug a product had before, naming
+// the product's current one
+type ProductSlugMovedError struct {
+	Slug string
+}
+
+func (e *ProductSlugMovedError) Error() string {
+	return "product slug has moved to " + e.Slug
+}
+
+// titleSlug returns the slug derived from title, cut to leave room for a
+// suffix, or "product" for a title without letters or digits
+func titleSlug(title string) string {
+	slug := tagSlug(title)
+	if len(slug) > maxBaseSlugLength {
+		slug = strings.TrimRight(slug[:maxBaseSlugLength], "-")
+	}
+	if slug == "" {
+		return "product"
+	}
+	return slug
+}
+
+// checkSlugInput returns a field error when slug, given on a product input,
+// isn't a URL-safe slug
+func checkSlugInput(slug string) []validators.FieldError {
+	if slug == "" || len(slug) <= maxSlugLength && slugPattern.MatchString(slug) {
+		return nil
+	}
+	return []validators.FieldError{{
+		Field: "slug", Code: "slug",
+		Message: fmt.Sprintf("slug must be at most %d lowercase letters and digits, in words joined by '-'", maxSlugLength),
+	}}
+}
+
+// takenSlugs returns which of base and the slugs extending it after a dash
+// a product other than productID has or had. productID is empty for a new
+// product.
+func takenSlugs(ctx context.Context, tx *sql.Tx, productID, base string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT slug FROM products
+		WHERE (slug = $1 OR slug LIKE $1 || '-%') AND id IS DISTINCT FROM NULLIF($2, '')::uuid
+		UNION
+		SELECT slug FROM slug_history
+		WHERE (slug = $1 OR slug LIKE $1 || '-%') AND product_id IS DISTINCT FROM NULLIF($2, '')::uuid`,
+		base, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check slugs: %w", err)
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, fmt.Errorf("failed to scan slug: %w", err)
+		}
+		taken[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to check slugs: %w", err)
+	}
+	return taken, nil
+}
+
+// productSlug returns the slug for product productID, empty for a new
+// product: requested when given, failing with ErrDuplicateSlug if it is
+// taken, or else the first free slug derived from title. Like checkSKU, it
+// gives a clear error up front; the unique index still decides races,
+// through slugConflict.
+func productSlug(ctx context.Context, tx *sql.Tx, productID, title, requested string) (string, error) {
+	base := requested
+	if base == "" {
+		base = titleSlug(title)
+	}
+	taken, err := takenSlugs(ctx, tx, productID, base)
+	if err != nil {
+		return "", err
+	}
+	if !taken[base] {
+		return base, nil
+	}
+	if requested != "" {
+		return "", fmt.Errorf("%w: %q", ErrDuplicateSlug, requested)
+	}
+	for n := 2; ; n++ {
+		if slug := base + "-" + strconv.Itoa(n); !taken[slug] {
+			return slug, nil
+		}
+	}
+}
+
+// moveSlug records that product productID moved from slug previous to
+// slug, keeping previous to redirect from and taking slug out of the
+// product's history in case it had it before
+func moveSlug(ctx context.Context, tx *sql.Tx, productID, previous, slug string) error {
+	if previous == slug {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM slug_history WHERE slug = $1 AND product_id = $2`, slug, productID); err != nil {
+		return fmt.Errorf("failed to update slug history: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO slug_history (slug, product_id) VALUES ($1, $2)
+		ON CONFLICT (slug) DO NOTHING`, previous, productID); err != nil {
+		return fmt.Errorf("failed to update slug history: %w", err)
+	}
+	return nil
+}
+
+// slugConflict returns ErrDuplicateSlug for a violation of the unique slug
+// index, and err otherwise
+func slugConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == productSlugIndex {
+		return ErrDuplicateSlug
+	}
+	return err
+}
+
+// GetBySlug is GetAs for the product with slug. A slug the product had
+// before fails with a *ProductSlugMovedError naming its current one.
+func (s *ProductService) GetBySlug(ctx context.Context, slug, viewerID string, isAdmin bool) (*models.Product, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM products WHERE slug = $1`, slug).Scan(&id)
+	if err == nil {
+		// A deleted product's slug still leads to what it was merged into
+		return s.GetAs(ctx, id, viewerID, isAdmin)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get product by slug: %w", err)
+	}
+
+	var current string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT p.slug FROM slug_history h JOIN products p ON p.id = h.product_id
+		WHERE h.slug = $1`, slug).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product by slug: %w", err)
+	}
+	return nil, &ProductSlugMovedError{Slug: current}
+}
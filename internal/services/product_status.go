@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Product status
+//
+// A product is created as a draft, which only its seller and admins can see,
+// and is listed once published. Publishing checks that the listing is
+// complete, so buyers never see a product without a description, a category
+// or an image, or one they can't buy. Archiving unlists a product as deleting
+// it would, carts included, but it is kept and can be published again.
+// is_active follows the status, so everything that lists active products
+// lists published ones.
+
+// EventProductPublished is published when a product is first published, by
+// a seller who isn't suspended, for their followers to be notified
+const EventProductPublished = "product.published"
+
+// ProductPublishedEvent is the payload of EventProductPublished
+type ProductPublishedEvent struct {
+	ProductID  string `json:"productId"`
+	SellerID   string `json:"sellerId"`
+	SellerName string `json:"sellerName"`
+	Title      string `json:"title"`
+}
+
+// GetAs is Get for a viewer: products that aren't published are found only
+// by their seller and admins. A product merged into another fails with a
+// *ProductMergedError naming it.
+func (s *ProductService) GetAs(ctx context.Context, id, viewerID string, isAdmin bool) (*models.Product, error) {
+	product, err := s.Get(ctx, id)
+	if errors.Is(err, ErrProductNotFound) {
+		if target, err := s.mergedInto(ctx, id); err != nil {
+			log.Warn().Err(err).Str("product_id", id).Msg("Failed to look up merged product")
+		} else if target != "" {
+			return nil, &ProductMergedError{TargetID: target}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if product.Status != models.ProductStatusPublished && product.SellerID != viewerID && !isAdmin {
+		return nil, ErrProductNotFound
+	}
+	return product, nil
+}
+
+// Publish lists a draft or archived product. Only the listing seller or an
+// admin may publish a product, and an incomplete one fails with a
+// *validators.ValidationError naming what is missing. Publishing a published
+// product changes nothing.
+func (s *ProductService) Publish(ctx context.Context, id, userID string, isAdmin bool) (*models.Product, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	product, err := s.getLatest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if product.Status == models.ProductStatusPublished {
+		return product, nil
+	}
+	if err := checkPublishable(product); err != nil {
+		return nil, err
+	}
+	// The check was of this version; a concurrent edit could have undone it
+	return s.setStatus(ctx, id, &product.Version, models.ProductStatusPublished)
+}
+
+// Archive unlists a product until it is published again. Only the listing
+// seller or an admin may archive a product.
+func (s *ProductService) Archive(ctx context.Context, id, userID string, isAdmin bool) (*models.Product, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	return s.setStatus(ctx, id, nil, models.ProductStatusArchived)
+}
+
+// setStatus sets a product's status, at version unless it is nil, and
+// mirrors the change to search and listings. A product's first publish
+// stamps published_at and publishes EventProductPublished.
+func (s *ProductService) setStatus(ctx context.Context, id string, version *int, status string) (*models.Product, error) {
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// published_at is the transaction's NOW() only when this publish set it
+		event := ProductPublishedEvent{ProductID: id}
+		var first bool
+		err := tx.QueryRowContext(ctx, `
+			UPDATE products p SET status = $2, is_active = ($2 = 'published'), version = version + 1,
+				published_at = CASE WHEN $2 = 'published' THEN COALESCE(published_at, NOW()) ELSE published_at END
+			WHERE id = $1 AND deleted_at IS NULL AND status <> $2 AND ($3::int IS NULL OR version = $3)
+			RETURNING p.seller_id, p.title,
+				(SELECT COALESCE(NULLIF(u.full_name, ''), u.username) FROM users u WHERE u.id = p.seller_id),
+				COALESCE(p.published_at = NOW(), false) AND `+sellerListed,
+			id, status, version).Scan(&event.SellerID, &event.Title, &event.SellerName, &first)
+		if errors.Is(err, sql.ErrNoRows) {
+			if version != nil {
+				return ErrProductVersionConflict
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set product status: %w", err)
+		}
+		if !first {
+			return nil
+		}
+		return WriteOutbox(ctx, tx, EventProductPublished, id, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := s.getLatest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.index(ctx, product)
+	invalidateProductListings(ctx, s.cache, product.CategoryIDs...)
+	return product, nil
+}
+
+// checkPublishable checks that a product is complete enough to list: the
+// rules every product input meets, a description, a category, an image and
+// stock to sell
+func checkPublishable(product *models.Product) error {
+	var invalid []validators.FieldError
+	var verr *validators.ValidationError
+	if err := checkProductInput(product.Input()); errors.As(err, &verr) {
+		invalid = append(invalid, verr.Fields...)
+	}
+	if strings.TrimSpace(product.Description) == "" {
+		invalid = append(invalid, validators.FieldError{
+			Field: "description", Code: "required", Message: "description is required to publish",
+		})
+	}
+	if product.CategoryID == nil {
+		invalid = append(invalid, validators.FieldError{
+			Field: "categoryId", Code: "required", Message: "categoryId is required to publish",
+		})
+	}
+	var images []json.RawMessage
+	if len(product.Images) == 0 || json.Unmarshal(product.Images, &images) != nil || len(images) == 0 {
+		invalid = append(invalid, validators.FieldError{
+			Field: "images", Code: "min", Param: "1", Message: "at least one image is required to publish",
+		})
+	}
+	if product.StockQuantity <= 0 {
+		invalid = append(invalid, validators.FieldError{
+			Field: "stockQuantity", Code: "gt", Param: "0", Message: "stockQuantity must be greater than 0 to publish",
+		})
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Delta sync
+//
+// Clients mirroring the catalog fetch the products changed since their last
+// sync, in updated_at order, and get tombstones for products deleted,
+// unpublished or hidden with their seller, so they can drop them. Triggers
+// touch updated_at on every change to what a product shows, including its
+// tags, categories, components and seller status. updated_at is the start
+// of the writing transaction, so a change committed late can carry a time
+// before changes already served; changes are only served once they are
+// productSyncLag old, by when such transactions have committed.
+
+// productSyncLag is how long after its updated_at a change is first served
+const productSyncLag = time.Minute
+
+// productSyncCursor marks a change: its updated_at, as Postgres prints it
+// so no precision is lost, and product ID
+type productSyncCursor struct {
+	UpdatedAt string `json:"u"`
+	ID        string `json:"id"`
+}
+
+// Changes returns the rows of every product changed after filter's cursor,
+// or after filter.Since without one, oldest change first, to be read one at
+// a time with ScanChange
+func (s *ProductService) Changes(ctx context.Context, filter models.ProductChangeFilter) (*sql.Rows, error) {
+	after := productSyncCursor{UpdatedAt: filter.Since.UTC().Format(time.RFC3339Nano)}
+	if filter.Cursor != "" {
+		cursor, err := decodeProductSyncCursor(filter.Cursor)
+		if err != nil || cursor.UpdatedAt == "" {
+			return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidProductFilter)
+		}
+		after = cursor
+	}
+
+	// A cursor without an ID is a time: everything at it was served
+	query := fmt.Sprintf(`
+		SELECT %s, p.deleted_at IS NULL AND p.is_active AND `+sellerListed+`, p.updated_at::text
+		FROM products p
+		WHERE (p.updated_at, p.id) > ($1::timestamptz, COALESCE(NULLIF($2, '')::uuid, 'ffffffff-ffff-ffff-ffff-ffffffffffff'))
+			AND p.updated_at < NOW() - make_interval(secs => $3)
+		ORDER BY p.updated_at, p.id`, productColumns)
+	rows, err := s.db.QueryContext(ctx, query, after.UpdatedAt, after.ID, productSyncLag.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product changes: %w", err)
+	}
+	return rows, nil
+}
+
+// ScanChange returns a function scanning a row of Changes into a change
+// with the cursor that resumes after it. Listed products are priced as of
+// now, and bundles get their components with a query of their own.
+func (s *ProductService) ScanChange(ctx context.Context) func(rows *sql.Rows) (models.ProductChange, error) {
+	now := time.Now()
+	return func(rows *sql.Rows) (models.ProductChange, error) {
+		var isListed bool
+		var updatedAt string
+		product, err := scanProduct(rows, &isListed, &updatedAt)
+		if err != nil {
+			return models.ProductChange{}, fmt.Errorf("failed to scan product change: %w", err)
+		}
+		change := models.ProductChange{
+			ID:        product.ID,
+			Deleted:   !isListed,
+			UpdatedAt: product.UpdatedAt,
+			Cursor:    encodeProductSyncCursor(productSyncCursor{UpdatedAt: updatedAt, ID: product.ID}),
+		}
+		if isListed {
+			if err := s.addBundleItems(ctx, product); err != nil {
+				return models.ProductChange{}, err
+			}
+			product.ApplySaleAt(now)
+			change.Product = product
+		}
+		return change, nil
+	}
+}
+
+func encodeProductSyncCursor(c productSyncCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProductSyncCursor(s string) (productSyncCursor, error) {
+	var c productSyncCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
@@ -0,0 +1,444 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+var ErrTagNotFound = errors.New("tag not found")
+
+// Tag names and category IDs of the product aliased as p
+const (
+	productTagNames = `ARRAY(SELECT t.name FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+		WHERE pt.product_id = p.id ORDER BY t.name)`
+	productCategoryIDs = `ARRAY(SELECT pc.category_id::text FROM product_categories pc
+		WHERE pc.product_id = p.id ORDER BY pc.category_id)`
+)
+
+// tagSlug returns the slug identifying tag name: its lowercase ASCII letters
+// and digits, with each other run of characters replaced by '-'. It matches
+// the slugs migrated from the old tags column.
+func tagSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// normalizeTagFilter returns the distinct slugs of tags, sorted so equal
+// filters share a cache key
+func normalizeTagFilter(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	slugs := []string{}
+	for _, tag := range tags {
+		if slug := tagSlug(tag); slug != "" && !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+// checkTagNames returns a field error for each name without a slug
+func checkTagNames(field string, names []string) []validators.FieldError {
+	var invalid []validators.FieldError
+	for i, name := range names {
+		if tagSlug(name) == "" {
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("%s[%d]", field, i), Code: "tag", Message: "tag must contain a letter or digit",
+			})
+		}
+	}
+	return invalid
+}
+
+// tagIDs returns the IDs of the tags named names, creating missing ones. A
+// new tag keeps the name it was first given.
+func tagIDs(ctx context.Context, tx *sql.Tx, names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	var tagNames, slugs []string
+	for _, name := range names {
+		slug := tagSlug(name)
+		if !seen[slug] {
+			seen[slug] = true
+			tagNames = append(tagNames, strings.TrimSpace(name))
+			slugs = append(slugs, slug)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO tags (name, slug)
+		SELECT * FROM unnest($1::text[], $2::text[])
+		ON CONFLICT (slug) DO UPDATE SET slug = EXCLUDED.slug
+		RETURNING id`, pq.Array(tagNames), pq.Array(slugs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tags: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0, len(slugs))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to save tags: %w", err)
+	}
+	return ids, nil
+}
+
+// checkCategories returns a field error for each of ids that isn't a category
+func checkCategories(ctx context.Context, tx *sql.Tx, field string, ids []string) ([]validators.FieldError, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id::text FROM categories WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get categories: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool, len(ids))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get categories: %w", err)
+	}
+
+	var invalid []validators.FieldError
+	for i, id := range ids {
+		if !found[strings.ToLower(id)] {
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("%s[%d]", field, i), Code: "not_found", Message: "category not found",
+			})
+		}
+	}
+	return invalid, nil
+}
+
+// saveProductTaxonomy replaces the tags and categories of product id with
+// those of input within tx
+func saveProductTaxonomy(ctx context.Context, tx *sql.Tx, id string, input models.ProductInput) error {
+	categoryIDs := input.CategoryIDs
+	if input.CategoryID != nil {
+		categoryIDs = append([]string{*input.CategoryID}, categoryIDs...)
+	}
+	invalid, err := checkCategories(ctx, tx, "categoryIds", input.CategoryIDs)
+	if err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM product_categories WHERE product_id = $1 AND category_id <> ALL($2::uuid[])`,
+		id, pq.Array(categoryIDs)); err != nil {
+		return fmt.Errorf("failed to replace product categories: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO product_categories (product_id, category_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT DO NOTHING`, id, pq.Array(categoryIDs)); err != nil {
+		return fmt.Errorf("failed to add product categories: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM product_tags WHERE product_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to replace product tags: %w", err)
+	}
+	if len(input.Tags) == 0 {
+		return nil
+	}
+	ids, err := tagIDs(ctx, tx, input.Tags)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO product_tags (product_id, tag_id) SELECT $1, unnest($2::uuid[])`,
+		id, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to add product tags: %w", err)
+	}
+	return nil
+}
+
+// TagProducts attaches and detaches tags on many products in one
+// transaction. Products that don't exist are reported rather than failing
+// the change.
+func (s *ProductService) TagProducts(ctx context.Context, input models.ProductTagsInput) (*models.BulkAssignResult, error) {
+	invalid := append(checkTagNames("attach", input.Attach), checkTagNames("detach", input.Detach)...)
+	invalid = append(invalid, checkBulkChange(normalizeTagFilter(input.Attach), normalizeTagFilter(input.Detach))...)
+	if len(invalid) > 0 {
+		return nil, &validators.ValidationError{Fields: invalid}
+	}
+
+	var result *models.BulkAssignResult
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var found []string
+		var err error
+		if result, found, err = findProducts(ctx, tx, input.ProductIDs); err != nil {
+			return err
+		}
+
+		if len(input.Detach) > 0 {
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM product_tags pt USING tags t
+				WHERE t.id = pt.tag_id AND pt.product_id = ANY($1) AND t.slug = ANY($2)`,
+				pq.Array(found), pq.Array(normalizeTagFilter(input.Detach))); err != nil {
+				return fmt.Errorf("failed to detach tags: %w", err)
+			}
+		}
+		if len(input.Attach) > 0 {
+			ids, err := tagIDs(ctx, tx, input.Attach)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO product_tags (product_id, tag_id)
+				SELECT p, t FROM unnest($1::uuid[]) p CROSS JOIN unnest($2::uuid[]) t
+				ON CONFLICT DO NOTHING`, pq.Array(found), pq.Array(ids)); err != nil {
+				return fmt.Errorf("failed to attach tags: %w", err)
+			}
+		}
+
+		categoryIDs, err = categoriesOf(ctx, tx, found)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.reindex(ctx, input.ProductIDs)
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+	return result, nil
+}
+
+// CategorizeProducts adds and removes categories on many products in one
+// transaction. A product without a primary category takes the first one
+// attached, and one whose primary category is detached falls back to its
+// oldest remaining category. Products that don't exist are reported rather
+// than failing the change.
+func (s *ProductService) CategorizeProducts(ctx context.Context, input models.ProductCategoriesInput) (*models.BulkAssignResult, error) {
+	invalid := checkBulkChange(lowerAll(input.Attach), lowerAll(input.Detach))
+	if len(invalid) > 0 {
+		return nil, &validators.ValidationError{Fields: invalid}
+	}
+
+	var result *models.BulkAssignResult
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		invalid, err := checkCategories(ctx, tx, "attach", input.Attach)
+		if err != nil {
+			return err
+		}
+		if len(invalid) > 0 {
+			return &validators.ValidationError{Fields: invalid}
+		}
+
+		var found []string
+		if result, found, err = findProducts(ctx, tx, input.ProductIDs); err != nil {
+			return err
+		}
+		// Listings of detached categories change too
+		if categoryIDs, err = categoriesOf(ctx, tx, found); err != nil {
+			return err
+		}
+
+		if len(input.Detach) > 0 {
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM product_categories WHERE product_id = ANY($1) AND category_id = ANY($2)`,
+				pq.Array(found), pq.Array(input.Detach)); err != nil {
+				return fmt.Errorf("failed to detach categories: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE products p SET category_id = (
+					SELECT pc.category_id FROM product_categories pc
+					WHERE pc.product_id = p.id
+					ORDER BY pc.created_at, pc.category_id
+					LIMIT 1)
+				WHERE p.id = ANY($1) AND p.category_id = ANY($2)`,
+				pq.Array(found), pq.Array(input.Detach)); err != nil {
+				return fmt.Errorf("failed to update primary categories: %w", err)
+			}
+		}
+		if len(input.Attach) > 0 {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO product_categories (product_id, category_id)
+				SELECT p, c FROM unnest($1::uuid[]) p CROSS JOIN unnest($2::uuid[]) c
+				ON CONFLICT DO NOTHING`, pq.Array(found), pq.Array(input.Attach)); err != nil {
+				return fmt.Errorf("failed to attach categories: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE products SET category_id = $2 WHERE id = ANY($1) AND category_id IS NULL`,
+				pq.Array(found), input.Attach[0]); err != nil {
+				return fmt.Errorf("failed to update primary categories: %w", err)
+			}
+			categoryIDs = append(categoryIDs, input.Attach...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.reindex(ctx, input.ProductIDs)
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+	return result, nil
+}
+
+// Collection returns a page of the active products tagged slug that match
+// filter
+func (s *ProductService) Collection(ctx context.Context, slug string, filter models.ProductFilter) (*models.Collection, error) {
+	var c models.Collection
+	err := s.db.QueryRowContext(ctx, `SELECT id, name, slug FROM tags WHERE slug = $1`, tagSlug(slug)).Scan(
+		&c.Tag.ID, &c.Tag.Name, &c.Tag.Slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTagNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag: %w", err)
+	}
+
+	filter.Tags = append(filter.Tags, c.Tag.Slug)
+	page, err := s.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c.ProductPage = *page
+	return &c, nil
+}
+
+// checkBulkChange checks that a bulk change attaches or detaches something,
+// and not the same thing both ways
+func checkBulkChange(attach, detach []string) []validators.FieldError {
+	if len(attach) == 0 && len(detach) == 0 {
+		return []validators.FieldError{{Field: "attach", Code: "required_without", Param: "detach", Message: "attach or detach is required"}}
+	}
+	attached := make(map[string]bool, len(attach))
+	for _, a := range attach {
+		attached[a] = true
+	}
+	for i, d := range detach {
+		if attached[d] {
+			return []validators.FieldError{{
+				Field: fmt.Sprintf("detach[%d]", i), Code: "conflict", Message: "cannot attach and detach the same value",
+			}}
+		}
+	}
+	return nil
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	return lowered
+}
+
+// findProducts splits ids into the undeleted products that exist and those
+// that don't
+func findProducts(ctx context.Context, tx *sql.Tx, ids []string) (*models.BulkAssignResult, []string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id::text FROM products WHERE id = ANY($1) AND deleted_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get products: %w", err)
+	}
+	defer rows.Close()
+
+	exists := make(map[string]bool, len(ids))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		exists[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to get products: %w", err)
+	}
+
+	result := &models.BulkAssignResult{NotFound: []string{}}
+	var found []string
+	for _, id := range ids {
+		if exists[strings.ToLower(id)] {
+			found = append(found, id)
+		} else {
+			result.NotFound = append(result.NotFound, id)
+		}
+	}
+	result.Updated = len(found)
+	return result, found, nil
+}
+
+// categoriesOf returns every category of products ids
+func categoriesOf(ctx context.Context, tx *sql.Tx, ids []string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT category_id::text FROM product_categories WHERE product_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categoryIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan product category: %w", err)
+		}
+		categoryIDs = append(categoryIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get product categories: %w", err)
+	}
+	return categoryIDs, nil
+}
+
+// reindex refreshes the search documents of products ids after a bulk change
+func (s *ProductService) reindex(ctx context.Context, ids []string) {
+	query := fmt.Sprintf(`SELECT %s FROM products p WHERE p.id = ANY($1) AND p.deleted_at IS NULL`, productColumns)
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load products for reindexing")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to scan product for reindexing")
+			return
+		}
+		s.index(ctx, product)
+	}
+}
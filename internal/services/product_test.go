@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// countingLoader stands in for ProductService.load, counting the loads that
+// would have hit the database
+type countingLoader struct {
+	calls   atomic.Int64
+	release chan struct{} // closed to let loads finish; nil finishes them at once
+	delay   time.Duration
+}
+
+func (l *countingLoader) load(ctx context.Context, id string) (*models.Product, error) {
+	l.calls.Add(1)
+	if l.release != nil {
+		<-l.release
+	}
+	time.Sleep(l.delay)
+	open, distance, published := true, 2.5, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &models.Product{
+		ID: id, Tags: []string{"organic"}, SellerOpen: &open, DistanceKm: &distance, PublishedAt: &published,
+		ImageErrors: []models.ImageError{{URL: "https://example.com/a.png", Code: "fetch_failed"}},
+	}, nil
+}
+
+func TestCoalesceLoadSharesOneLoad(t *testing.T) {
+	const callers = 50
+	loader := &countingLoader{release: make(chan struct{})}
+	var group singleflight.Group
+
+	var started, done sync.WaitGroup
+	results := make([]*models.Product, callers)
+	errs := make([]error, callers)
+	started.Add(callers)
+	done.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer done.Done()
+			started.Done()
+			results[i], errs[i] = coalesceLoad(context.Background(), &group, "p1", loader.load)
+		}(i)
+	}
+	started.Wait()
+	// Give every caller time to join the load before it finishes
+	time.Sleep(100 * time.Millisecond)
+	close(loader.release)
+	done.Wait()
+
+	if n := loader.calls.Load(); n != 1 {
+		t.Fatalf("loads = %d, want 1", n)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("caller %d: %v", i, err)
+		}
+	}
+
+	// Each caller gets its own copy: changing one leaves the others as loaded
+	first := results[0]
+	*first.SellerOpen = false
+	*first.DistanceKm = 99
+	*first.PublishedAt = time.Time{}
+	first.Tags[0] = "changed"
+	first.ImageErrors[0].Code = "changed"
+	for i, p := range results[1:] {
+		if p == first {
+			t.Fatalf("caller %d shares the first caller's product", i+1)
+		}
+		if !*p.SellerOpen || *p.DistanceKm != 2.5 || p.PublishedAt.IsZero() || p.Tags[0] != "organic" ||
+			p.ImageErrors[0].Code != "fetch_failed" {
+			t.Fatalf("caller %d sees another caller's changes: %+v", i+1, p)
+		}
+	}
+}
+
+func TestCoalesceLoadCallerCancelled(t *testing.T) {
+	loader := &countingLoader{release: make(chan struct{})}
+	defer close(loader.release)
+	var group singleflight.Group
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := coalesceLoad(ctx, &group, "p1", loader.load); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+// BenchmarkProductLoad compares the loads hitting the database when
+// concurrent requests for one hot product go straight to the database and
+// when they are coalesced. db-loads/op is the share of requests that loaded.
+func BenchmarkProductLoad(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		loader := &countingLoader{delay: time.Millisecond}
+		b.SetParallelism(16)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := loader.load(context.Background(), "p1"); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+		b.ReportMetric(float64(loader.calls.Load())/float64(b.N), "db-loads/op")
+	})
+	b.Run("coalesced", func(b *testing.B) {
+		loader := &countingLoader{delay: time.Millisecond}
+		var group singleflight.Group
+		b.SetParallelism(16)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := coalesceLoad(context.Background(), &group, "p1", loader.load); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+		b.ReportMetric(float64(loader.calls.Load())/float64(b.N), "db-loads/op")
+	})
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Quantity price tiers
+//
+// A product's tiers lower its unit price for lines of at least a tier's
+// minimum quantity. Carts and checkout pick the tier in SQL with
+// productUnitPriceAt, from the line's quantity, and charge the lower of the
+// tier price and the product's price at the time: a sale below a tier wins,
+// a tier below a sale wins, and the two never stack. Tiers only ever lower
+// the price, so one left above a cut regular price simply stops applying.
+// Order-level discounts apply to line totals after tiers. Tier savings count
+// as sale discounts in an order's discounts and the cart's savings.
+
+// productPriceTiers is the SQL for product p's tiers as a JSON array, by
+// ascending minimum quantity
+const productPriceTiers = `COALESCE((SELECT json_agg(json_build_object('minQuantity', t.min_quantity, 'priceCents', t.price_cents)
+		ORDER BY t.min_quantity) FROM product_price_tiers t WHERE t.product_id = p.id), '[]')`
+
+// productUnitPriceAt returns the SQL for the unit price charged for a line
+// of quantity of product p at the instant bound to at
+func productUnitPriceAt(at, quantity string) string {
+	return fmt.Sprintf(`LEAST(%s, COALESCE((SELECT t.price_cents FROM product_price_tiers t
+		WHERE t.product_id = p.id AND t.min_quantity <= %s ORDER BY t.min_quantity DESC LIMIT 1), p.price_cents))`,
+		productPriceAt(at), quantity)
+}
+
+// decodePriceTiers decodes tiers selected with productPriceTiers, priced in
+// currency. A product without tiers has none.
+func decodePriceTiers(data []byte, currency string) ([]models.PriceTier, error) {
+	var rows []struct {
+		MinQuantity int   `json:"minQuantity"`
+		PriceCents  int64 `json:"priceCents"`
+	}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal price tiers: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	tiers := make([]models.PriceTier, len(rows))
+	for i, row := range rows {
+		tiers[i] = models.PriceTier{MinQuantity: row.MinQuantity, Price: money.New(row.PriceCents, currency)}
+	}
+	return tiers, nil
+}
+
+// SetPriceTiers replaces product id's price tiers; an empty list removes
+// them. Tiers must be given by ascending minimum quantity, each above the
+// product's minimum order quantity and within its maximum, with prices
+// falling from tier to tier, below the regular price and in the product's
+// currency. Only the listing seller or an admin may set tiers.
+func (s *ProductService) SetPriceTiers(ctx context.Context, id, userID string, isAdmin bool, input models.PriceTiersInput) (*models.Product, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var regular money.Money
+		var rules quantityRules
+		err := tx.QueryRowContext(ctx, `
+			SELECT price_cents, COALESCE(currency, 'USD'), min_order_qty, max_order_qty
+			FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			id).Scan(&regular.Amount, &regular.Currency, &rules.min, &rules.max)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get product: %w", err)
+		}
+		if err := checkPriceTiers(input, regular, rules); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM product_price_tiers WHERE product_id = $1`, id); err != nil {
+			return fmt.Errorf("failed to clear price tiers: %w", err)
+		}
+		if len(input.Tiers) > 0 {
+			quantities := make([]int64, len(input.Tiers))
+			prices := make([]int64, len(input.Tiers))
+			for i, tier := range input.Tiers {
+				quantities[i], prices[i] = int64(tier.MinQuantity), tier.Price.Amount
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO product_price_tiers (product_id, min_quantity, price_cents)
+				SELECT $1, q, c FROM unnest($2::int[], $3::bigint[]) AS t(q, c)`,
+				id, pq.Array(quantities), pq.Array(prices)); err != nil {
+				return fmt.Errorf("failed to save price tiers: %w", err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET version = version + 1 WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to update product: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.saleChanged(ctx, id)
+}
+
+// checkPriceTiers checks tiers against the product's regular price and
+// order quantity rules
+func checkPriceTiers(input models.PriceTiersInput, regular money.Money, rules quantityRules) error {
+	var invalid []validators.FieldError
+	for i, tier := range input.Tiers {
+		quantity := fmt.Sprintf("tiers[%d].minQuantity", i)
+		price := fmt.Sprintf("tiers[%d].price", i)
+		switch {
+		case tier.MinQuantity <= rules.min:
+			invalid = append(invalid, validators.FieldError{
+				Field: quantity, Code: "gt", Param: fmt.Sprint(rules.min),
+				Message: fmt.Sprintf("minQuantity must be greater than the minimum order quantity of %d", rules.min),
+			})
+		case rules.max != nil && tier.MinQuantity > *rules.max:
+			invalid = append(invalid, validators.FieldError{
+				Field: quantity, Code: "lte", Param: fmt.Sprint(*rules.max),
+				Message: fmt.Sprintf("minQuantity must be at most the maximum order quantity of %d", *rules.max),
+			})
+		case i > 0 && tier.MinQuantity <= input.Tiers[i-1].MinQuantity:
+			invalid = append(invalid, validators.FieldError{
+				Field: quantity, Code: "ascending",
+				Message: "minQuantity must be greater than the previous tier's",
+			})
+		}
+		switch {
+		case tier.Price.Currency != regular.Currency:
+			invalid = append(invalid, validators.FieldError{
+				Field: price, Code: "currency", Param: regular.Currency,
+				Message: fmt.Sprintf("price must be in the product's currency, %s", regular.Currency),
+			})
+		case tier.Price.Amount <= 0:
+			invalid = append(invalid, validators.FieldError{
+				Field: price, Code: "gt", Param: "0", Message: "price must be greater than 0",
+			})
+		case tier.Price.Amount >= regular.Amount:
+			invalid = append(invalid, validators.FieldError{
+				Field: price, Code: "lt", Param: regular.Decimal(),
+				Message: fmt.Sprintf("price must be less than the regular price of %s", regular.Decimal()),
+			})
+		case i > 0 && tier.Price.Amount >= input.Tiers[i-1].Price.Amount:
+			invalid = append(invalid, validators.FieldError{
+				Field: price, Code: "descending",
+				Message: "price must be less than the previous tier's",
+			})
+		}
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
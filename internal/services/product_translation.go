@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Product translations
+//
+// A product's own title and description are in utils.DefaultLocale. Sellers
+// may add translations into other locales, and reads are localized to the
+// first of the reader's languages the product has a translation for, trying
+// each language tag and then its primary language, so pt-BR readers get a pt
+// translation when there is no pt-br one. Languages after the default locale
+// in the reader's preferences are never reached, and a translation without a
+// description shows the product's own. Products are localized after caching,
+// so writing a translation invalidates nothing.
+
+var ErrProductTranslationNotFound = errors.New("product translation not found")
+
+// Translations returns the translations of product id, by locale. Only the
+// listing seller or an admin may see them.
+func (s *ProductService) Translations(ctx context.Context, id, userID string, isAdmin bool) ([]models.ProductTranslation, error) {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT locale, title, COALESCE(description, ''), updated_at
+		FROM product_translations WHERE product_id = $1
+		ORDER BY locale`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product translations: %w", err)
+	}
+	defer rows.Close()
+
+	translations := []models.ProductTranslation{}
+	for rows.Next() {
+		var t models.ProductTranslation
+		if err := rows.Scan(&t.Locale, &t.Title, &t.Description, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan product translation: %w", err)
+		}
+		translations = append(translations, t)
+	}
+	return translations, rows.Err()
+}
+
+// PutTranslation sets the translation of product id into input.Locale,
+// replacing any it had. Only the listing seller or an admin may translate a
+// product. The default locale is the product's own text and can't be
+// translated into.
+func (s *ProductService) PutTranslation(ctx context.Context, id, userID string, isAdmin bool, input models.ProductTranslationInput) (*models.ProductTranslation, error) {
+	locale := strings.ToLower(input.Locale)
+	if locale == utils.DefaultLocale {
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "locale", Code: "default_locale", Param: utils.DefaultLocale,
+			Message: "locale is the product's own language; edit the product instead",
+		}}}
+	}
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+
+	t := &models.ProductTranslation{Locale: locale}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO product_translations (product_id, locale, title, description)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT (product_id, locale) DO UPDATE SET title = EXCLUDED.title, description = EXCLUDED.description
+		RETURNING title, COALESCE(description, ''), updated_at`,
+		id, locale, strings.TrimSpace(input.Title), strings.TrimSpace(input.Description)).Scan(&t.Title, &t.Description, &t.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save product translation: %w", err)
+	}
+	return t, nil
+}
+
+// DeleteTranslation removes the translation of product id into locale, so
+// readers of it get the next language they prefer
+func (s *ProductService) DeleteTranslation(ctx context.Context, id, locale, userID string, isAdmin bool) error {
+	if err := s.authorize(ctx, id, userID, isAdmin); err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM product_translations WHERE product_id = $1 AND locale = $2`, id, strings.ToLower(locale))
+	if err != nil {
+		return fmt.Errorf("failed to delete product translation: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrProductTranslationNotFound
+	}
+	return nil
+}
+
+// Localize replaces the title and description of products with their
+// translations into the first of locales each has one for, most preferred
+// first, in one query. Every product gets its Locale set, to the default
+// locale when left untranslated.
+func (s *ProductService) Localize(ctx context.Context, locales []string, products ...*models.Product) error {
+	candidates := translationCandidates(locales)
+	ids := make([]string, 0, len(products))
+	for _, product := range products {
+		product.Locale = utils.DefaultLocale
+		ids = append(ids, product.ID)
+	}
+	if len(candidates) == 0 || len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT product_id, locale, title, COALESCE(description, '')
+		FROM product_translations
+		WHERE product_id = ANY($1) AND locale = ANY($2)`, pq.Array(ids), pq.Array(candidates))
+	if err != nil {
+		return fmt.Errorf("failed to get product translations: %w", err)
+	}
+	defer rows.Close()
+
+	// translations[productID][locale]
+	translations := make(map[string]map[string]models.ProductTranslation)
+	for rows.Next() {
+		var productID string
+		var t models.ProductTranslation
+		if err := rows.Scan(&productID, &t.Locale, &t.Title, &t.Description); err != nil {
+			return fmt.Errorf("failed to scan product translation: %w", err)
+		}
+		if translations[productID] == nil {
+			translations[productID] = make(map[string]models.ProductTranslation)
+		}
+		translations[productID][t.Locale] = t
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get product translations: %w", err)
+	}
+
+	for _, product := range products {
+		for _, locale := range candidates {
+			t, ok := translations[product.ID][locale]
+			if !ok {
+				continue
+			}
+			product.Title = t.Title
+			if t.Description != "" {
+				product.Description = t.Description
+			}
+			product.Locale = locale
+			break
+		}
+	}
+	return nil
+}
+
+// translationCandidates returns the locales to look for translations in, in
+// order: each of locales followed by its primary language, up to the
+// default locale, whose text the products table already holds
+func translationCandidates(locales []string) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, locale := range locales {
+		locale = strings.ToLower(locale)
+		primary, _, _ := strings.Cut(locale, "-")
+		for _, candidate := range []string{locale, primary} {
+			if candidate == utils.DefaultLocale {
+				return candidates
+			}
+			if !seen[candidate] {
+				seen[candidate] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Product view tracking
+//
+// Views are counted in Redis as they happen and flushed by RunViewFlush into
+// hourly buckets in product_views, which trending lists are ranked from.
+// Each viewer counts once per product per dedup window and a bounded number
+// of times an hour, sellers viewing their own products and known bots don't
+// count, so refreshing or crawling pages can't inflate the counts.
+
+// viewsPendingKey is the hash of view counts not yet flushed, by product ID
+const viewsPendingKey = "views:pending"
+
+// TrendingWindows are the windows trending products can be ranked over
+var TrendingWindows = map[string]time.Duration{
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// botAgents are User-Agent substrings of crawlers and scripted clients
+var botAgents = []string{"bot", "crawler", "spider", "slurp", "curl", "wget", "python-requests", "headless", "phantomjs"}
+
+// recordViewScript counts a view unless the viewer was counted for the
+// product within the dedup window or has hit the hourly cap. KEYS are the
+// viewer's dedup key for the product, the viewer's hourly counter and the
+// pending hash; ARGV the dedup window in seconds, the cap and the product ID.
+// Returns 1 when the view was counted.
+var recordViewScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], 1, 'NX', 'EX', ARGV[1]) then
+	return 0
+end
+local n = redis.call('INCR', KEYS[2])
+if n == 1 then
+	redis.call('EXPIRE', KEYS[2], 3600)
+end
+if n > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HINCRBY', KEYS[3], ARGV[3], 1)
+return 1
+`)
+
+// takePendingViewsScript returns the pending counts and clears them in one
+// step, so replicas flushing concurrently never count a view twice
+var takePendingViewsScript = redis.NewScript(`
+local counts = redis.call('HGETALL', KEYS[1])
+redis.call('DEL', KEYS[1])
+return counts
+`)
+
+// RecordView counts viewerID's view of product. Counting is best effort:
+// failures are logged and never fail the read.
+func (s *ProductService) RecordView(ctx context.Context, product *models.Product, viewerID, userAgent string) {
+	if viewerID == "" || viewerID == product.SellerID || isBot(userAgent) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	keys := []string{
+		"views:seen:" + product.ID + ":" + viewerID,
+		"views:viewer:" + viewerID,
+		viewsPendingKey,
+	}
+	err := recordViewScript.Run(ctx, s.redis.Client, keys, s.views.DedupWindow, s.views.MaxPerViewer, product.ID).Err()
+	if err != nil {
+		log.Warn().Err(err).Str("product_id", product.ID).Msg("Failed to record product view")
+	}
+}
+
+// FlushViews moves the view counts held in Redis into the current hour's
+// buckets, returning how many products had views. Counts that can't be
+// written are put back for the next flush.
+func (s *ProductService) FlushViews(ctx context.Context) (int, error) {
+	res, err := takePendingViewsScript.Run(ctx, s.redis.Client, []string{viewsPendingKey}).StringSlice()
+	if err != nil {
+		return 0, fmt.Errorf("failed to take pending views: %w", err)
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, 0, len(res)/2)
+	counts := make([]int64, 0, len(res)/2)
+	for i := 0; i+1 < len(res); i += 2 {
+		n, err := strconv.ParseInt(res[i+1], 10, 64)
+		if err != nil || n <= 0 {
+			continue
+		}
+		ids = append(ids, res[i])
+		counts = append(counts, n)
+	}
+
+	// Views of products deleted since are dropped by the join
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO product_views (product_id, bucket_start, views)
+		SELECT v.product_id, date_trunc('hour', NOW()), v.views
+		FROM unnest($1::uuid[], $2::bigint[]) AS v(product_id, views)
+		JOIN products p ON p.id = v.product_id
+		ON CONFLICT (product_id, bucket_start) DO UPDATE SET views = product_views.views + EXCLUDED.views`,
+		pq.Array(ids), pq.Array(counts))
+	if err != nil {
+		s.restorePendingViews(ids, counts)
+		return 0, fmt.Errorf("failed to flush product views: %w", err)
+	}
+	return len(ids), nil
+}
+
+// restorePendingViews adds counts that failed to flush back to the pending
+// hash. It uses its own context so a cancelled flush still restores them.
+func (s *ProductService) restorePendingViews(ids []string, counts []int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipe := s.redis.Pipeline()
+	for i, id := range ids {
+		pipe.HIncrBy(ctx, viewsPendingKey, id, counts[i])
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error().Err(err).Int("products", len(ids)).Msg("Failed to restore unflushed product views")
+	}
+}
+
+// RunViewFlush runs FlushViews as a scheduled task
+func (s *ProductService) RunViewFlush(ctx context.Context) error {
+	if _, err := s.FlushViews(ctx); err != nil {
+		return fmt.Errorf("product view flush failed: %w", err)
+	}
+	return nil
+}
+
+// Trending returns a page of the listed, in-stock products most viewed in
+// window, one of TrendingWindows. Lists are cached for the configured TTL
+// and dropped with the other listings when a product changes.
+func (s *ProductService) Trending(ctx context.Context, window string, limit, offset int) (*models.TrendingProducts, error) {
+	d, ok := TrendingWindows[window]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown trending window %q", ErrInvalidProductFilter, window)
+	}
+
+	key := fmt.Sprintf("%s:%d:%d", window, limit, offset)
+	ttl := time.Duration(s.views.TrendingTTL) * time.Second
+	var trending models.TrendingProducts
+	err := s.cache.GetOrSetWithTTL(ctx, "trending", key, ttl, []string{tagAllProducts}, &trending, func(ctx context.Context) (interface{}, error) {
+		return s.trending(ctx, window, d, limit, offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range trending.Products {
+		p := &trending.Products[i]
+		p.ApplySaleAt(now)
+		// Set after the cache, so cached lists needn't carry it
+		p.Reason = models.RecommendationReason{Type: models.RecommendationTrending, Reference: models.RecommendationReference{
+			Type: models.RecommendationRefWindow, ID: window, Count: p.Views,
+		}}
+	}
+	return &trending, nil
+}
+
+func (s *ProductService) trending(ctx context.Context, window string, d time.Duration, limit, offset int) (*models.TrendingProducts, error) {
+	// The oldest bucket is included whole, so windows round out to the hour
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+productColumns+`, v.views
+		FROM (
+			SELECT product_id, SUM(views) AS views
+			FROM product_views
+			WHERE bucket_start >= date_trunc('hour', NOW() - $1 * INTERVAL '1 second')
+			GROUP BY product_id
+		) v
+		JOIN products p ON p.id = v.product_id
+		WHERE p.deleted_at IS NULL AND COALESCE(p.is_active, true) AND `+sellerListed+` AND `+productStock+` > 0
+		ORDER BY v.views DESC, p.id
+		LIMIT $2 OFFSET $3`, d.Seconds(), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trending products: %w", err)
+	}
+	defer rows.Close()
+
+	trending := &models.TrendingProducts{Window: window, Products: []models.TrendingProduct{}}
+	for rows.Next() {
+		var views int64
+		product, err := scanProduct(rows, &views)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		trending.Products = append(trending.Products, models.TrendingProduct{Product: product, Views: views})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list trending products: %w", err)
+	}
+	return trending, nil
+}
+
+// isBot reports whether userAgent looks like a crawler or script. Browsers
+// always send a User-Agent, so requests without one are treated as bots.
+func isBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	if userAgent == "" {
+		return true
+	}
+	for _, agent := range botAgents {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Purchase limits
+//
+// A product with a purchase limit may be ordered only up to its quantity by
+// one buyer over its window, counting back from checkout. Every order the
+// buyer placed in the window counts, pending ones included, except what was
+// cancelled or refunded: cancelled and fully refunded orders and sub-orders
+// and cancelled items. A bundle's limit counts the bundle alone, not its
+// components.
+
+// limitQuantity and limitWindow are a purchase limit's quantity and window
+// as query parameters, NULL when there is no limit
+func limitQuantity(limit *models.PurchaseLimit) interface{} {
+	if limit == nil {
+		return nil
+	}
+	return limit.Quantity
+}
+
+func limitWindow(limit *models.PurchaseLimit) interface{} {
+	if limit == nil {
+		return nil
+	}
+	return limit.WindowDays
+}
+
+// checkPurchaseLimits gives a problem to each limited line of the priced cart
+// that would take buyerID past the product's purchase limit as of now. With
+// lock, the buyer's row is locked first, so concurrent checkouts by the same
+// buyer count each other's orders rather than both passing.
+func (c *pricedCart) checkPurchaseLimits(ctx context.Context, tx *sql.Tx, buyerID string, now time.Time, lock bool) error {
+	var productIDs []string
+	for _, line := range c.lines {
+		if line.problem == nil && line.limit != nil {
+			productIDs = append(productIDs, line.productID)
+		}
+	}
+	if len(productIDs) == 0 {
+		return nil
+	}
+	if lock {
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, buyerID); err != nil {
+			return fmt.Errorf("failed to lock buyer: %w", err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT oi.product_id, SUM(oi.quantity)
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		JOIN products p ON p.id = oi.product_id
+		LEFT JOIN sub_orders so ON so.id = oi.sub_order_id
+		WHERE o.buyer_id = $1 AND oi.product_id = ANY($2)
+			AND o.created_at > $3::timestamptz - make_interval(days => p.purchase_limit_days)
+			AND COALESCE(o.status, 'pending') NOT IN ('cancelled', 'refunded')
+			AND oi.cancelled_at IS NULL
+			AND (so.id IS NULL OR so.status <> 'cancelled' AND so.refunded_cents < so.total_cents)
+		GROUP BY oi.product_id`, buyerID, pq.Array(productIDs), now)
+	if err != nil {
+		return fmt.Errorf("failed to get recent purchases: %w", err)
+	}
+	defer rows.Close()
+	purchased := make(map[string]int)
+	for rows.Next() {
+		var productID string
+		var quantity int
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			return fmt.Errorf("failed to scan recent purchases: %w", err)
+		}
+		purchased[productID] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get recent purchases: %w", err)
+	}
+
+	for i := range c.lines {
+		line := &c.lines[i]
+		if line.problem != nil || line.limit == nil || purchased[line.productID]+line.quantity <= line.limit.Quantity {
+			continue
+		}
+		left := max(line.limit.Quantity-purchased[line.productID], 0)
+		line.problem = &validators.FieldError{
+			Field: fmt.Sprintf("items[%d].%s", i, line.rules.field()), Code: "purchase_limit", Param: line.rules.param(line.limit.Quantity),
+			Message: fmt.Sprintf("at most %s can be bought every %d days; %s left",
+				line.rules.format(line.limit.Quantity), line.limit.WindowDays, line.rules.format(left)),
+		}
+	}
+	return nil
+}
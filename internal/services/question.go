@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Product questions
+//
+// Shoppers ask questions about a product, which its seller and buyers with a
+// delivered order of it may answer; anyone else signed in may vote an answer
+// helpful, once. The seller is notified of each new question and the asker
+// of each answer through notifyEvent, so their notification preferences
+// apply. Questions leave with their product.
+
+// Events published as questions are asked and answered
+const (
+	EventQuestionAsked    = "question.asked"
+	EventQuestionAnswered = "question.answered"
+)
+
+var (
+	ErrQuestionNotFound   = errors.New("question not found")
+	ErrAnswerNotFound     = errors.New("answer not found")
+	ErrQuestionOwnProduct = errors.New("sellers cannot ask questions about their own products")
+	ErrAnswerNotAllowed   = errors.New("only the seller and buyers of a product may answer questions about it")
+	ErrAnswerOwnVote      = errors.New("you cannot vote for your own answer")
+)
+
+// QuestionEvent is the payload of EventQuestionAsked and
+// EventQuestionAnswered. AnswerID and AnswererID are set when answered.
+type QuestionEvent struct {
+	QuestionID   string `json:"questionId"`
+	ProductID    string `json:"productId"`
+	ProductTitle string `json:"productTitle"`
+	SellerID     string `json:"sellerId"`
+	AskerID      string `json:"askerId"`
+	AnswerID     string `json:"answerId,omitempty"`
+	AnswererID   string `json:"answererId,omitempty"`
+}
+
+// questionColumns and answerColumns are the column lists matching
+// scanQuestion and scanAnswer, for queries aliasing the tables as q and a
+const (
+	questionColumns = `q.id, q.product_id, q.user_id, q.body, q.answer_count, q.created_at`
+	answerColumns   = `a.id, a.question_id, a.user_id, a.body, a.is_seller, a.is_verified_purchase, a.helpful_votes, a.created_at`
+)
+
+// QuestionService handles questions and answers about products
+type QuestionService struct {
+	db *database.PostgresDB
+}
+
+// NewQuestionService creates a new question service
+func NewQuestionService(db *database.PostgresDB) *QuestionService {
+	return &QuestionService{db: db}
+}
+
+// Ask adds userID's question about product productID
+func (s *QuestionService) Ask(ctx context.Context, productID, userID string, input models.QuestionInput) (*models.ProductQuestion, error) {
+	var question *models.ProductQuestion
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		event := QuestionEvent{ProductID: productID, AskerID: userID}
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(title, ''), seller_id FROM products WHERE id = $1 AND deleted_at IS NULL`,
+			productID).Scan(&event.ProductTitle, &event.SellerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get product: %w", err)
+		}
+		if event.SellerID == userID {
+			return ErrQuestionOwnProduct
+		}
+
+		question, err = scanQuestion(tx.QueryRowContext(ctx, `
+			INSERT INTO product_questions AS q (product_id, user_id, body)
+			VALUES ($1, $2, $3)
+			RETURNING `+questionColumns, productID, userID, input.Body))
+		if err != nil {
+			return fmt.Errorf("failed to create question: %w", err)
+		}
+		event.QuestionID = question.ID
+		return WriteOutbox(ctx, tx, EventQuestionAsked, question.ID, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	question.Answers = []models.ProductAnswer{}
+	return question, nil
+}
+
+// Answer adds userID's answer to question id. Only the product's seller and
+// buyers with a delivered order of it may answer.
+func (s *QuestionService) Answer(ctx context.Context, id, userID string, input models.QuestionInput) (*models.ProductAnswer, error) {
+	var answer *models.ProductAnswer
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		event := QuestionEvent{QuestionID: id, AnswererID: userID}
+		var purchased bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT q.product_id, COALESCE(p.title, ''), p.seller_id, q.user_id,
+				EXISTS (
+					SELECT 1 FROM order_items oi JOIN orders o ON o.id = oi.order_id
+					WHERE oi.product_id = q.product_id AND o.buyer_id = $2 AND o.status = 'delivered')
+			FROM product_questions q JOIN products p ON p.id = q.product_id
+			WHERE q.id = $1 AND p.deleted_at IS NULL
+			FOR UPDATE OF q`, id, userID).Scan(&event.ProductID, &event.ProductTitle, &event.SellerID, &event.AskerID, &purchased)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrQuestionNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get question: %w", err)
+		}
+		isSeller := event.SellerID == userID
+		if !isSeller && !purchased {
+			return ErrAnswerNotAllowed
+		}
+
+		answer, err = scanAnswer(tx.QueryRowContext(ctx, `
+			INSERT INTO product_answers AS a (question_id, user_id, body, is_seller, is_verified_purchase)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+answerColumns, id, userID, input.Body, isSeller, purchased))
+		if err != nil {
+			return fmt.Errorf("failed to create answer: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE product_questions SET answer_count = answer_count + 1 WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to count answer: %w", err)
+		}
+		if event.AskerID == userID {
+			return nil
+		}
+		event.AnswerID = answer.ID
+		return WriteOutbox(ctx, tx, EventQuestionAnswered, id, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// List returns a page of a product's questions, newest first, each with all
+// its answers
+func (s *QuestionService) List(ctx context.Context, productID string, limit, offset int) (*models.QuestionPage, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)`, productID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+questionColumns+`, COUNT(*) OVER() AS total
+		FROM product_questions q
+		WHERE q.product_id = $1
+		ORDER BY q.created_at DESC, q.id
+		LIMIT $2 OFFSET $3`, productID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list questions: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.QuestionPage{Questions: []models.ProductQuestion{}}
+	var ids []string
+	for rows.Next() {
+		question, err := scanQuestion(rows, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan question: %w", err)
+		}
+		question.Answers = []models.ProductAnswer{}
+		page.Questions = append(page.Questions, *question)
+		ids = append(ids, question.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list questions: %w", err)
+	}
+	if len(ids) == 0 {
+		return page, nil
+	}
+
+	answers, err := s.db.QueryContext(ctx, `
+		SELECT `+answerColumns+`
+		FROM product_answers a
+		WHERE a.question_id = ANY($1::uuid[])
+		ORDER BY a.is_seller DESC, a.helpful_votes DESC, a.created_at, a.id`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list answers: %w", err)
+	}
+	defer answers.Close()
+
+	byQuestion := make(map[string][]models.ProductAnswer)
+	for answers.Next() {
+		answer, err := scanAnswer(answers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan answer: %w", err)
+		}
+		byQuestion[answer.QuestionID] = append(byQuestion[answer.QuestionID], *answer)
+	}
+	if err := answers.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list answers: %w", err)
+	}
+	for i := range page.Questions {
+		if a, ok := byQuestion[page.Questions[i].ID]; ok {
+			page.Questions[i].Answers = a
+		}
+	}
+	return page, nil
+}
+
+// VoteHelpful records userID finding answer id helpful. Voting again
+// changes nothing, and nobody may vote for their own answer.
+func (s *QuestionService) VoteHelpful(ctx context.Context, id, userID string) (*models.ProductAnswer, error) {
+	var answer *models.ProductAnswer
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var authorID string
+		err := tx.QueryRowContext(ctx, `SELECT user_id FROM product_answers WHERE id = $1 FOR UPDATE`, id).Scan(&authorID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAnswerNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get answer: %w", err)
+		}
+		if authorID == userID {
+			return ErrAnswerOwnVote
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO product_answer_votes (answer_id, user_id) VALUES ($1, $2)
+			ON CONFLICT (answer_id, user_id) DO NOTHING`, id, userID)
+		if err != nil {
+			return fmt.Errorf("failed to record vote: %w", err)
+		}
+		increment := 0
+		if n, _ := result.RowsAffected(); n > 0 {
+			increment = 1
+		}
+		answer, err = scanAnswer(tx.QueryRowContext(ctx, `
+			UPDATE product_answers a SET helpful_votes = helpful_votes + $2 WHERE a.id = $1
+			RETURNING `+answerColumns, id, increment))
+		if err != nil {
+			return fmt.Errorf("failed to count vote: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// NotifyQuestionAsked is the job handler for EventQuestionAsked, telling the
+// seller a shopper asked about their product
+func (s *QuestionService) NotifyQuestionAsked(ctx context.Context, job *jobs.Job) error {
+	var event QuestionEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal question event: %w", err)
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.SellerID, "product_question", "New question",
+		fmt.Sprintf("A shopper asked a question about %s", event.ProductTitle),
+		map[string]interface{}{"questionId": event.QuestionID, "productId": event.ProductID})
+}
+
+// NotifyQuestionAnswered is the job handler for EventQuestionAnswered,
+// telling the asker their question was answered
+func (s *QuestionService) NotifyQuestionAnswered(ctx context.Context, job *jobs.Job) error {
+	var event QuestionEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal question event: %w", err)
+	}
+	message := fmt.Sprintf("A buyer answered your question about %s", event.ProductTitle)
+	if event.AnswererID == event.SellerID {
+		message = fmt.Sprintf("The seller answered your question about %s", event.ProductTitle)
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.AskerID, "question_answer", "Question answered", message,
+		map[string]interface{}{"questionId": event.QuestionID, "answerId": event.AnswerID, "productId": event.ProductID})
+}
+
+// scanQuestion scans a row selected with questionColumns, followed by any
+// extra destinations for additional selected columns
+func scanQuestion(row rowScanner, extra ...interface{}) (*models.ProductQuestion, error) {
+	var q models.ProductQuestion
+	dest := []interface{}{&q.ID, &q.ProductID, &q.UserID, &q.Body, &q.AnswerCount, &q.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+func scanAnswer(row rowScanner) (*models.ProductAnswer, error) {
+	var a models.ProductAnswer
+	if err := row.Scan(&a.ID, &a.QuestionID, &a.UserID, &a.Body, &a.IsSeller, &a.IsVerifiedPurchase,
+		&a.HelpfulVotes, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
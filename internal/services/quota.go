@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// SemanticSearchQuota is the quota feature name for semantic searches
+const SemanticSearchQuota = "semantic_search"
+
+// DefaultPlan is the plan of users without a paid subscription
+const DefaultPlan = "free"
+
+// QuotaService tracks per-user daily usage quotas in Redis
+type QuotaService struct {
+	db     *database.PostgresDB
+	redis  *database.RedisClient
+	config config.QuotaConfig
+	ttls   *cache.TTLs
+}
+
+// NewQuotaService creates a new quota service. Users' plans are cached for
+// their cache type's TTL in ttls.
+func NewQuotaService(db *database.PostgresDB, redis *database.RedisClient, cfg config.QuotaConfig, ttls *cache.TTLs) *QuotaService {
+	return &QuotaService{db: db, redis: redis, config: cfg, ttls: ttls}
+}
+
+// ConsumeSemanticSearch counts one semantic search against the user's daily
+// quota, reporting whether it is allowed. Admins are never limited.
+func (s *QuotaService) ConsumeSemanticSearch(ctx context.Context, userID string, isAdmin bool) (*models.QuotaStatus, bool, error) {
+	status, err := s.status(ctx, SemanticSearchQuota, s.config.SemanticSearch, userID, isAdmin)
+	if err != nil {
+		return nil, false, err
+	}
+	if status.Unlimited {
+		return status, true, nil
+	}
+
+	key := s.key(SemanticSearchQuota, userID, status.ResetsAt)
+	used, allowed, err := s.redis.IncrByBounded(ctx, key, 1, 0, int64(status.Limit))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to consume quota: %w", err)
+	}
+	// The key names its period, so one left without an expiry is never
+	// counted against again; it only lingers
+	if allowed {
+		if err := s.redis.ExpireAt(ctx, key, status.ResetsAt).Err(); err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("Failed to expire quota counter")
+		}
+	}
+
+	status.Used = int(used)
+	status.Remaining = max(status.Limit-status.Used, 0)
+	return status, allowed, nil
+}
+
+// Quotas returns the user's current usage of every quota
+func (s *QuotaService) Quotas(ctx context.Context, userID string, isAdmin bool) ([]models.QuotaStatus, error) {
+	status, err := s.status(ctx, SemanticSearchQuota, s.config.SemanticSearch, userID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Unlimited {
+		raw, err := s.redis.Get(ctx, s.key(SemanticSearchQuota, userID, status.ResetsAt))
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to get quota usage: %w", err)
+		}
+		used, _ := strconv.Atoi(raw)
+		status.Used = used
+		status.Remaining = max(status.Limit-used, 0)
+	}
+	return []models.QuotaStatus{*status}, nil
+}
+
+// status resolves the user's plan and limit for feature in the current period
+func (s *QuotaService) status(ctx context.Context, feature string, limits map[string]int, userID string, isAdmin bool) (*models.QuotaStatus, error) {
+	status := &models.QuotaStatus{Feature: feature, ResetsAt: s.periodEnd(time.Now())}
+	if isAdmin {
+		status.Plan = "admin"
+		status.Unlimited = true
+		return status, nil
+	}
+
+	plan, err := s.plan(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	status.Plan = plan
+
+	limit, ok := limits[plan]
+	if !ok {
+		limit = limits[DefaultPlan]
+	}
+	if limit < 0 {
+		status.Unlimited = true
+		return status, nil
+	}
+	status.Limit = limit
+	status.Remaining = limit
+	return status, nil
+}
+
+// plan returns the user's subscription plan, cached briefly in Redis
+func (s *QuotaService) plan(ctx context.Context, userID string) (string, error) {
+	cacheKey := "user_plan:" + userID
+	if plan, err := s.redis.Get(ctx, cacheKey); err == nil {
+		return plan, nil
+	}
+
+	var plan string
+	err := s.db.QueryRowContext(ctx, `SELECT plan FROM users WHERE id = $1`, userID).Scan(&plan)
+	if errors.Is(err, sql.ErrNoRows) {
+		plan = DefaultPlan
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get user plan: %w", err)
+	}
+
+	s.redis.SetWithExpiration(ctx, cacheKey, plan, s.ttls.Get(config.CacheUserPlans))
+	return plan, nil
+}
+
+// periodEnd returns when the daily quota period containing now resets
+func (s *QuotaService) periodEnd(now time.Time) time.Time {
+	now = now.UTC()
+	reset := time.Date(now.Year(), now.Month(), now.Day(), s.config.ResetHourUTC, 0, 0, 0, time.UTC)
+	if !reset.After(now) {
+		reset = reset.Add(24 * time.Hour)
+	}
+	return reset
+}
+
+// key returns the Redis counter for a user's quota in the period ending at resetsAt
+func (s *QuotaService) key(feature, userID string, resetsAt time.Time) string {
+	return fmt.Sprintf("quota:%s:%s:%d", feature, userID, resetsAt.Unix())
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// quoteCharges itemizes totals as a quote shows them: each seller's goods
+// and discount, each add-on, shipping by the option named shippingMethod
+// among options, and taxes, the tax charges TaxService.tax itemized. They are
+// built from the totals checkout charges, so they add up to totals.order;
+// discounts are listed only when given.
+func quoteCharges(currency string, totals *cartTotals, addOns []addOnLine, options []models.ShippingOption, shippingMethod string, taxes []models.QuoteCharge) ([]models.QuoteCharge, error) {
+	charges := []models.QuoteCharge{}
+	for i, sellerID := range totals.sellerIDs {
+		seller := totals.sellers[i]
+		charges = append(charges, models.QuoteCharge{Type: models.ChargeGoods, Label: "Items", SellerID: sellerID, Amount: seller.Subtotal})
+		if seller.Discount.IsZero() {
+			continue
+		}
+		discount, err := money.Zero(currency).Sub(seller.Discount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to itemize discount: %w", err)
+		}
+		charges = append(charges, models.QuoteCharge{Type: models.ChargeDiscount, Label: "Discount", SellerID: sellerID, Amount: discount})
+	}
+	for _, a := range addOns {
+		charges = append(charges, models.QuoteCharge{Type: models.ChargeAddOn, Label: a.name, Amount: a.total})
+	}
+
+	shippingLabel := "Shipping"
+	for _, option := range options {
+		if option.Method == shippingMethod {
+			shippingLabel = option.Name
+		}
+	}
+	charges = append(charges, models.QuoteCharge{Type: models.ChargeShipping, Label: shippingLabel, Amount: totals.order.Shipping})
+	charges = append(charges, taxes...)
+
+	amounts := make([]money.Money, len(charges))
+	for i, charge := range charges {
+		amounts[i] = charge.Amount
+	}
+	sum, err := money.Sum(currency, amounts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total charges: %w", err)
+	}
+	if sum != totals.order.Total {
+		return nil, fmt.Errorf("quote charges sum to %s, not the total %s", sum, totals.order.Total)
+	}
+	return charges, nil
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/metrics"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// purgeLockKey is held by the replica purging, renewed after each batch
+const (
+	purgeLockKey = "locks:retention_purge"
+	purgeLockTTL = 5 * time.Minute
+)
+
+var (
+	ErrPurgeRunning  = errors.New("a purge is already running")
+	errPurgeLockLost = errors.New("purge lock lost")
+)
+
+// legalHoldEntities are never purged, whatever the config says
+var legalHoldEntities = map[string]bool{
+	"orders":           true,
+	"order_items":      true,
+	"sub_orders":       true,
+	"refunds":          true,
+	"payment_attempts": true,
+	"order_notes":      true,
+}
+
+// purgeTarget is an entity the purge job deletes rows of. where selects the
+// rows of table t past retention, with $1 the cutoff, and dependents are
+// the tables, with their column referencing t.id, whose rows go with them.
+type purgeTarget struct {
+	table      string
+	where      string
+	dependents [][2]string
+}
+
+var purgeTargets = map[string]purgeTarget{
+	// Products that were ordered are kept with their orders, and components
+	// with their bundles
+	"deleted_products": {
+		table: "products",
+		where: `t.deleted_at < $1
+			AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.product_id = t.id)
+			AND NOT EXISTS (SELECT 1 FROM product_bundle_items b WHERE b.product_id = t.id)`,
+		dependents: [][2]string{
+			{"cart", "product_id"},
+			{"stock_movements", "product_id"},
+			{"stock_adjustment_items", "product_id"},
+			{"stock_delivery_items", "product_id"},
+		},
+	},
+	"stale_carts":           {table: "cart", where: `t.updated_at < $1`},
+	"read_notifications":    {table: "notifications", where: `t.is_read AND COALESCE(t.read_at, t.created_at) < $1`},
+	"webhook_deliveries":    {table: "webhook_deliveries", where: `t.attempted_at < $1`},
+	"inbound_webhooks":      {table: "inbound_webhook_events", where: `t.received_at < $1`},
+	"search_queries":        {table: "search_queries", where: `t.created_at < $1`},
+	"outbox":                {table: "outbox", where: `t.dispatched_at < $1`},
+	"bulk_jobs":             {table: "bulk_jobs", where: `t.finished_at < $1`},
+	"seller_status_changes": {table: "seller_status_changes", where: `t.created_at < $1`},
+}
+
+// RetentionService purges rows kept past their retention
+type RetentionService struct {
+	db    *database.PostgresDB
+	redis *database.RedisClient
+	cfg   config.RetentionConfig
+}
+
+// NewRetentionService creates a new retention service. Entities in cfg that
+// can't be purged, being under legal hold or unknown, are an error.
+func NewRetentionService(db *database.PostgresDB, redis *database.RedisClient, cfg config.RetentionConfig) (*RetentionService, error) {
+	for entity := range cfg.Days {
+		if legalHoldEntities[entity] {
+			return nil, fmt.Errorf("retention.days.%s: %s are under legal hold and never purged", entity, entity)
+		}
+		if _, ok := purgeTargets[entity]; !ok {
+			return nil, fmt.Errorf("retention.days.%s: unknown entity", entity)
+		}
+	}
+	return &RetentionService{db: db, redis: redis, cfg: cfg}, nil
+}
+
+// Purge deletes the rows of each entity kept past its retention in batches,
+// one transaction per batch, skipping rows other transactions have locked.
+// In a dry run the rows are counted and nothing is deleted. A replica takes
+// the purge lock for the run, and ErrPurgeRunning is returned while another
+// holds it.
+func (s *RetentionService) Purge(ctx context.Context, dryRun bool) (*models.PurgeReport, error) {
+	lock, err := s.redis.TryLock(ctx, purgeLockKey, purgeLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, ErrPurgeRunning
+	}
+	defer func() {
+		if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+			log.Warn().Err(err).Msg("Failed to release purge lock")
+		}
+	}()
+
+	report := &models.PurgeReport{DryRun: dryRun, Entities: []models.PurgedEntity{}, StartedAt: time.Now()}
+	mode := "delete"
+	if dryRun {
+		mode = "dry_run"
+	}
+	for _, entity := range slices.Sorted(maps.Keys(s.cfg.Days)) {
+		days := s.cfg.Days[entity]
+		if days == 0 {
+			continue
+		}
+		cutoff := report.StartedAt.AddDate(0, 0, -days)
+		rows, err := s.purge(ctx, lock, purgeTargets[entity], cutoff, dryRun)
+		report.Entities = append(report.Entities, models.PurgedEntity{Entity: entity, Cutoff: cutoff, Rows: rows})
+		metrics.RetentionPurgedRows.WithLabelValues(entity, mode).Add(float64(rows))
+		if err != nil {
+			return report, fmt.Errorf("failed to purge %s: %w", entity, err)
+		}
+	}
+
+	elapsed := time.Since(report.StartedAt)
+	report.DurationMs = elapsed.Milliseconds()
+	metrics.RetentionPurgeDuration.Observe(elapsed.Seconds())
+	return report, nil
+}
+
+// purge deletes target's rows from before cutoff, or counts them in a dry
+// run, returning how many there were. The lock is renewed after each batch,
+// and the purge stops if it was lost.
+func (s *RetentionService) purge(ctx context.Context, lock *database.RedisLock, target purgeTarget, cutoff time.Time, dryRun bool) (int64, error) {
+	if dryRun {
+		var n int64
+		err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+target.table+` t WHERE `+target.where, cutoff).Scan(&n)
+		return n, err
+	}
+
+	var total int64
+	for {
+		var n int64
+		err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+			rows, err := tx.QueryContext(ctx, `
+				SELECT t.id FROM `+target.table+` t WHERE `+target.where+`
+				LIMIT $2 FOR UPDATE SKIP LOCKED`, cutoff, s.cfg.BatchSize)
+			if err != nil {
+				return err
+			}
+			var ids []string
+			for rows.Next() {
+				var id string
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return err
+				}
+				ids = append(ids, id)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+
+			for _, dep := range target.dependents {
+				if _, err := tx.ExecContext(ctx, `DELETE FROM `+dep[0]+` WHERE `+dep[1]+` = ANY($1)`, pq.Array(ids)); err != nil {
+					return fmt.Errorf("%s: %w", dep[0], err)
+				}
+			}
+			res, err := tx.ExecContext(ctx, `DELETE FROM `+target.table+` WHERE id = ANY($1)`, pq.Array(ids))
+			if err != nil {
+				return err
+			}
+			n, err = res.RowsAffected()
+			return err
+		})
+		total += n
+		if err != nil || n < int64(s.cfg.BatchSize) {
+			return total, err
+		}
+
+		ok, err := lock.Extend(ctx, purgeLockTTL)
+		if err != nil {
+			return total, err
+		}
+		if !ok {
+			return total, errPurgeLockLost
+		}
+	}
+}
+
+// RunPurges purges rows past retention as a scheduled task, in a dry run
+// when configured. A run that finds another replica purging is skipped.
+func (s *RetentionService) RunPurges(ctx context.Context) error {
+	report, err := s.Purge(ctx, s.cfg.DryRun)
+	if errors.Is(err, ErrPurgeRunning) {
+		log.Debug().Msg("Retention purge running on another replica, skipped")
+		return nil
+	}
+	if report != nil {
+		var purged []string
+		for _, e := range report.Entities {
+			if e.Rows > 0 {
+				purged = append(purged, fmt.Sprintf("%s=%d", e.Entity, e.Rows))
+			}
+		}
+		if len(purged) > 0 {
+			log.Info().Bool("dry_run", report.DryRun).Str("purged", strings.Join(purged, " ")).Msg("Purged rows past retention")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("retention purge failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// ReviewRestoreWindow is how long after deleting a review its author may
+// restore it
+const ReviewRestoreWindow = 30 * 24 * time.Hour
+
+var (
+	ErrReviewNotFound       = errors.New("review not found")
+	ErrReviewForbidden      = errors.New("insufficient permissions for review")
+	ErrReviewOwnProduct     = errors.New("sellers cannot review their own products")
+	ErrReviewRestoreExpired = errors.New("review can no longer be restored")
+	// ErrReviewPurchaseRequired and ErrReviewAccountTooNew are returned when
+	// a user may not review a product they haven't bought
+	ErrReviewPurchaseRequired = errors.New("only buyers of a product may review it")
+	ErrReviewAccountTooNew    = errors.New("account is too new to review products it hasn't bought")
+)
+
+// ReviewCooldownError is returned when a review is edited again before the
+// edit cooldown has passed
+type ReviewCooldownError struct {
+	RetryAfter time.Duration // until the review can be edited again
+}
+
+func (e *ReviewCooldownError) Error() string {
+	return fmt.Sprintf("review was edited too recently, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// reviewColumns is the column list matching scanReview, for queries aliasing
+// the reviews table as r
+const reviewColumns = `r.id, r.product_id, COALESCE(r.buyer_id::text, ''), COALESCE(r.author_name, ''), r.seller_id, r.status,
+	COALESCE(r.source, ''), COALESCE(r.source_id, ''), COALESCE(r.rating, 0), COALESCE(r.title, ''),
+	COALESCE(r.comment, ''), COALESCE(r.is_verified_purchase, false), COALESCE(r.helpful_votes, 0),
+	r.created_at, r.updated_at, r.edited_at, r.deleted_at`
+
+// reviewVisible holds for reviews r shown on their product: published ones
+// not deleted whose author isn't suspended. Ratings count only these.
+const reviewVisible = `r.status = 'published' AND r.deleted_at IS NULL AND
+	NOT EXISTS (SELECT 1 FROM users ru WHERE ru.id = r.buyer_id AND ru.suspended_at IS NOT NULL)`
+
+// ReviewService handles product reviews and keeps each product's avg_rating
+// and review_count in step with its visible reviews
+type ReviewService struct {
+	db       *database.PostgresDB
+	products *ProductService
+	cfg      config.ReviewConfig
+}
+
+// NewReviewService creates a new review service. Rating changes are pushed
+// to the search index and cached listings through products; cfg sets who
+// may review and how often reviews may be edited.
+func NewReviewService(db *database.PostgresDB, products *ProductService, cfg config.ReviewConfig) *ReviewService {
+	return &ReviewService{db: db, products: products, cfg: cfg}
+}
+
+// Create adds buyerID's review of product productID. Reviews by a buyer with
+// a delivered order of the product are marked as verified purchases. Other
+// users may only review it once their account is old enough, unless the
+// configuration requires a purchase or role is exempt.
+func (s *ReviewService) Create(ctx context.Context, productID, buyerID, role string, input models.ReviewInput) (*models.Review, error) {
+	var review *models.Review
+	err := s.changeReviews(ctx, productID, func(tx *sql.Tx, p lockedStock) error {
+		if p.deleted {
+			return ErrProductNotFound
+		}
+		if p.sellerID == buyerID {
+			return ErrReviewOwnProduct
+		}
+		purchased, err := s.checkEligible(ctx, tx, productID, buyerID, role)
+		if err != nil {
+			return err
+		}
+		review, err = scanReview(tx.QueryRowContext(ctx, `
+			INSERT INTO reviews AS r (product_id, buyer_id, seller_id, rating, title, comment, is_verified_purchase)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+			RETURNING `+reviewColumns,
+			productID, buyerID, p.sellerID, input.Rating, input.Title, input.Comment, purchased))
+		if err != nil {
+			return fmt.Errorf("failed to create review: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// Update replaces the rating and text of a review. Only its author may edit
+// it, and only once the edit cooldown since their previous edit has passed,
+// unless role is exempt.
+func (s *ReviewService) Update(ctx context.Context, id, userID, role string, input models.ReviewInput) (*models.Review, error) {
+	review, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if review.DeletedAt != nil || review.Status != models.ReviewPublished {
+		return nil, ErrReviewNotFound
+	}
+	if review.BuyerID != userID {
+		return nil, ErrReviewForbidden
+	}
+
+	cooldown := time.Duration(s.cfg.EditCooldown) * time.Second
+	if s.exempt(role) {
+		cooldown = 0
+	}
+	err = s.changeReviews(ctx, review.ProductID, func(tx *sql.Tx, p lockedStock) error {
+		if p.deleted {
+			return ErrReviewNotFound
+		}
+		// Edits of the review are serialized by the product row lock, and
+		// the cooldown is measured on the database clock like edited_at
+		var wait float64
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(EXTRACT(EPOCH FROM edited_at + $2 * INTERVAL '1 second' - NOW()), 0)
+			FROM reviews WHERE id = $1 AND deleted_at IS NULL AND status = 'published'`, id, cooldown.Seconds()).Scan(&wait)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReviewNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get review: %w", err)
+		}
+		if wait > 0 {
+			return &ReviewCooldownError{RetryAfter: time.Duration(wait * float64(time.Second))}
+		}
+
+		review, err = scanReview(tx.QueryRowContext(ctx, `
+			UPDATE reviews r SET rating = $2, title = NULLIF($3, ''), comment = NULLIF($4, ''), edited_at = NOW()
+			WHERE r.id = $1
+			RETURNING `+reviewColumns, id, input.Rating, input.Title, input.Comment))
+		if err != nil {
+			return fmt.Errorf("failed to update review: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// List returns a page of a product's visible reviews, newest first, each
+// with its seller's reply
+func (s *ReviewService) List(ctx context.Context, productID string, limit, offset int) (*models.ReviewPage, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)`, productID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+reviewColumns+`, COUNT(*) OVER() AS total
+		FROM reviews r
+		WHERE r.product_id = $1 AND `+reviewVisible+`
+		ORDER BY r.created_at DESC, r.id
+		LIMIT $2 OFFSET $3`, productID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.ReviewPage{Reviews: []models.Review{}}
+	for rows.Next() {
+		review, err := scanReview(rows, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan review: %w", err)
+		}
+		page.Reviews = append(page.Reviews, *review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	if err := s.attachReplies(ctx, page.Reviews); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Delete hides a review from its product until its author restores it.
+// Only the author or an admin may delete a review.
+func (s *ReviewService) Delete(ctx context.Context, id, userID string, isAdmin bool) error {
+	review, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if review.DeletedAt != nil || review.Status != models.ReviewPublished {
+		return ErrReviewNotFound
+	}
+	if review.BuyerID != userID && !isAdmin {
+		return ErrReviewForbidden
+	}
+
+	return s.changeReviews(ctx, review.ProductID, func(tx *sql.Tx, _ lockedStock) error {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE reviews SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL AND status = 'published'`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete review: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrReviewNotFound
+		}
+		return nil
+	})
+}
+
+// Restore shows a deleted review again. Only its author may restore it, and
+// only within ReviewRestoreWindow of deleting it.
+func (s *ReviewService) Restore(ctx context.Context, id, userID string) (*models.Review, error) {
+	review, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if review.Status != models.ReviewPublished {
+		return nil, ErrReviewNotFound
+	}
+	if review.BuyerID != userID {
+		return nil, ErrReviewForbidden
+	}
+	if review.DeletedAt == nil {
+		return review, nil
+	}
+
+	err = s.changeReviews(ctx, review.ProductID, func(tx *sql.Tx, p lockedStock) error {
+		if p.deleted {
+			return ErrReviewNotFound
+		}
+		review, err = scanReview(tx.QueryRowContext(ctx, `
+			UPDATE reviews r SET deleted_at = NULL
+			WHERE r.id = $1 AND r.deleted_at > NOW() - $2 * INTERVAL '1 second'
+			RETURNING `+reviewColumns, id, ReviewRestoreWindow.Seconds()))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReviewRestoreExpired
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore review: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// HardDelete permanently removes a review, whether or not it was deleted by
+// its author or is awaiting moderation. It is for admins.
+func (s *ReviewService) HardDelete(ctx context.Context, id string) error {
+	review, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.changeReviews(ctx, review.ProductID, func(tx *sql.Tx, _ lockedStock) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete review: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrReviewNotFound
+		}
+		return nil
+	})
+}
+
+// checkEligible reports whether buyerID has a delivered order of product
+// productID, returning an error if they may not review it
+func (s *ReviewService) checkEligible(ctx context.Context, tx *sql.Tx, productID, buyerID, role string) (bool, error) {
+	var purchased, established bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+				SELECT 1 FROM order_items oi JOIN orders o ON o.id = oi.order_id
+				WHERE oi.product_id = $1 AND o.buyer_id = $2 AND o.status = 'delivered'),
+			COALESCE(u.created_at <= NOW() - $3 * INTERVAL '1 hour', true)
+		FROM users u
+		WHERE u.id = $2`, productID, buyerID, s.cfg.MinAccountAge).Scan(&purchased, &established)
+	if err != nil {
+		return false, fmt.Errorf("failed to check review eligibility: %w", err)
+	}
+	if purchased || s.exempt(role) {
+		return purchased, nil
+	}
+	if s.cfg.RequirePurchase {
+		return false, ErrReviewPurchaseRequired
+	}
+	if !established {
+		return false, ErrReviewAccountTooNew
+	}
+	return false, nil
+}
+
+// exempt reports whether users with role are exempt from review limits
+func (s *ReviewService) exempt(role string) bool {
+	for _, r := range s.cfg.ExemptRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns a review, deleted or not
+func (s *ReviewService) get(ctx context.Context, id string) (*models.Review, error) {
+	review, err := scanReview(s.db.QueryRowContext(ctx, `SELECT `+reviewColumns+` FROM reviews r WHERE r.id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review: %w", err)
+	}
+	return review, nil
+}
+
+// changeReviews runs change on the reviews of product productID and
+// recomputes the product's rating aggregate in one transaction. The product
+// row is locked first, so concurrent changes to its reviews each recompute
+// from the others' committed results.
+func (s *ReviewService) changeReviews(ctx context.Context, productID string, change func(tx *sql.Tx, p lockedStock) error) error {
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		products, err := lockStock(ctx, tx, []string{productID})
+		if err != nil {
+			return err
+		}
+		p, ok := products[strings.ToLower(productID)]
+		if !ok {
+			return ErrProductNotFound
+		}
+		if err := change(tx, p); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE products p SET avg_rating = agg.avg_rating, review_count = agg.review_count
+			FROM (
+				SELECT COALESCE(ROUND(AVG(r.rating), 2), 0) AS avg_rating, COUNT(*) AS review_count
+				FROM reviews r
+				WHERE r.product_id = $1 AND `+reviewVisible+`
+			) agg
+			WHERE p.id = $1`, productID); err != nil {
+			return fmt.Errorf("failed to update product rating: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.products.ratingChanged(ctx, productID)
+	return nil
+}
+
+// ratingChanged refreshes the search document and cached listings of
+// product id after its rating aggregate changed
+func (s *ProductService) ratingChanged(ctx context.Context, id string) {
+	product, err := s.getLatest(ctx, id)
+	if errors.Is(err, ErrProductNotFound) {
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("product_id", id).Msg("Failed to reload product after rating change")
+		return
+	}
+	s.index(ctx, product)
+	invalidateProductListings(ctx, s.cache, product.CategoryIDs...)
+}
+
+// scanReview scans a row selected with reviewColumns, followed by any extra
+// destinations for additional selected columns
+func scanReview(row rowScanner, extra ...interface{}) (*models.Review, error) {
+	var r models.Review
+	dest := []interface{}{
+		&r.ID, &r.ProductID, &r.BuyerID, &r.AuthorName, &r.SellerID, &r.Status, &r.Source, &r.SourceID, &r.Rating, &r.Title, &r.Comment,
+		&r.IsVerifiedPurchase, &r.HelpfulVotes, &r.CreatedAt, &r.UpdatedAt, &r.EditedAt, &r.DeletedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Review imports
+//
+// Reviews brought over from another platform are staged as 'imported':
+// hidden from their product and left out of its rating, like deleted ones,
+// until an admin approves or rejects them. They keep the time they were
+// written, the platform they came from and their ID there, and importing a
+// review with a source ID already imported from that platform does nothing,
+// so an import can be sent again after a failure. Approving publishes them
+// in batches, each recomputing the ratings of its products under their row
+// locks, as changeReviews does, so a large approval neither holds every
+// product at once nor races with reviews posted meanwhile.
+
+// reviewModerationBatch is the number of imported reviews moderated per
+// transaction
+const reviewModerationBatch = 500
+
+// ImportReviews stages input's reviews for moderation. Reviews of products
+// that don't exist, by buyers that don't exist or by the product's seller
+// are skipped.
+func (s *ReviewService) ImportReviews(ctx context.Context, input models.ReviewImportInput) (*models.ReviewImportResult, error) {
+	result := &models.ReviewImportResult{Skipped: []models.ReviewImportSkip{}}
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		productIDs := make([]string, 0, len(input.Reviews))
+		buyerIDs := make([]string, 0, len(input.Reviews))
+		for _, item := range input.Reviews {
+			productIDs = append(productIDs, strings.ToLower(item.ProductID))
+			if item.BuyerID != "" {
+				buyerIDs = append(buyerIDs, strings.ToLower(item.BuyerID))
+			}
+		}
+		products, err := readStock(ctx, tx, productIDs, false)
+		if err != nil {
+			return err
+		}
+		var known []string
+		if err := returnIDs(ctx, tx, &known, `SELECT id FROM users WHERE id = ANY($1::uuid[])`, pq.Array(buyerIDs)); err != nil {
+			return fmt.Errorf("failed to check reviewers: %w", err)
+		}
+		buyers := make(map[string]bool, len(known))
+		for _, id := range known {
+			buyers[id] = true
+		}
+
+		var sourceIDs, reviewProducts, reviewBuyers, authors, titles, comments, createdAt []string
+		var ratings []int64
+		var verified []bool
+		for _, item := range input.Reviews {
+			productID, buyerID := strings.ToLower(item.ProductID), strings.ToLower(item.BuyerID)
+			product, ok := products[productID]
+			reason := ""
+			switch {
+			case !ok || product.deleted:
+				reason = "product_not_found"
+			case buyerID != "" && !buyers[buyerID]:
+				reason = "buyer_not_found"
+			case buyerID != "" && buyerID == product.sellerID:
+				reason = "own_product"
+			}
+			if reason != "" {
+				result.Skipped = append(result.Skipped, models.ReviewImportSkip{SourceID: item.SourceID, Reason: reason})
+				continue
+			}
+			sourceIDs = append(sourceIDs, item.SourceID)
+			reviewProducts = append(reviewProducts, productID)
+			reviewBuyers = append(reviewBuyers, buyerID)
+			authors = append(authors, item.AuthorName)
+			ratings = append(ratings, int64(item.Rating))
+			titles = append(titles, item.Title)
+			comments = append(comments, item.Comment)
+			verified = append(verified, item.IsVerifiedPurchase)
+			createdAt = append(createdAt, item.CreatedAt.Format(time.RFC3339Nano))
+		}
+		if len(sourceIDs) == 0 {
+			return nil
+		}
+
+		var imported []string
+		if err := returnIDs(ctx, tx, &imported, `
+			INSERT INTO reviews (product_id, buyer_id, author_name, seller_id, status, source, source_id, rating, title, comment,
+				is_verified_purchase, created_at, updated_at)
+			SELECT i.product_id, NULLIF(i.buyer_id, '')::uuid, NULLIF(i.author_name, ''), p.seller_id, 'imported', $1, i.source_id,
+				i.rating, NULLIF(i.title, ''), NULLIF(i.comment, ''), i.verified, i.created_at, i.created_at
+			FROM unnest($2::text[], $3::uuid[], $4::text[], $5::text[], $6::int[], $7::text[], $8::text[], $9::bool[], $10::timestamptz[])
+				AS i(source_id, product_id, buyer_id, author_name, rating, title, comment, verified, created_at)
+			JOIN products p ON p.id = i.product_id
+			ON CONFLICT (source, source_id) WHERE source IS NOT NULL DO NOTHING
+			RETURNING id`,
+			input.Source, pq.Array(sourceIDs), pq.Array(reviewProducts), pq.Array(reviewBuyers), pq.Array(authors),
+			pq.Array(ratings), pq.Array(titles), pq.Array(comments), pq.Array(verified), pq.Array(createdAt)); err != nil {
+			return fmt.Errorf("failed to import reviews: %w", err)
+		}
+		result.Imported = len(imported)
+		result.Duplicates = len(sourceIDs) - len(imported)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListImported returns a page of the imported reviews awaiting moderation
+// that match filter, oldest first
+func (s *ReviewService) ListImported(ctx context.Context, filter models.ReviewImportFilter, limit, offset int) (*models.ReviewPage, error) {
+	where, args := importedReviewsWhere(filter)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+reviewColumns+`, COUNT(*) OVER() AS total
+		FROM reviews r
+		WHERE %s
+		ORDER BY r.created_at, r.id
+		LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2), append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list imported reviews: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.ReviewPage{Reviews: []models.Review{}}
+	for rows.Next() {
+		review, err := scanReview(rows, &page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan review: %w", err)
+		}
+		page.Reviews = append(page.Reviews, *review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list imported reviews: %w", err)
+	}
+	return page, nil
+}
+
+// ModerateImported approves or rejects the imported reviews input selects,
+// reviewModerationBatch at a time. Approved reviews are published and their
+// products' ratings recomputed in the same transaction; rejected ones stay
+// hidden. Reviews already moderated are left alone, so a moderation cut off
+// by an error can be sent again.
+func (s *ReviewService) ModerateImported(ctx context.Context, input models.ReviewModerationInput) (*models.ReviewModerationResult, error) {
+	filter := input.ReviewImportFilter
+	if len(input.ReviewIDs) == 0 && filter.Source == "" && len(filter.Ratings) == 0 && filter.Keyword == "" {
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "reviewIds", Code: "required_without", Message: "reviewIds or a filter (source, ratings, keyword) is required",
+		}}}
+	}
+	status := models.ReviewPublished
+	if input.Action == "reject" {
+		status = models.ReviewRejected
+	}
+
+	where, args := importedReviewsWhere(filter)
+	if len(input.ReviewIDs) > 0 {
+		args = append(args, pq.Array(input.ReviewIDs))
+		where += fmt.Sprintf(" AND r.id = ANY($%d::uuid[])", len(args))
+	}
+	result := &models.ReviewModerationResult{Action: input.Action}
+	recomputed := make(map[string]bool)
+	afterID := "00000000-0000-0000-0000-000000000000"
+	for {
+		var ids, productIDs []string
+		inBatch := make(map[string]bool)
+		rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT r.id, r.product_id FROM reviews r
+			WHERE %s AND r.id > $%d
+			ORDER BY r.id
+			LIMIT $%d`, where, len(args)+1, len(args)+2), append(args, afterID, reviewModerationBatch)...)
+		if err != nil {
+			return result, fmt.Errorf("failed to list imported reviews: %w", err)
+		}
+		for rows.Next() {
+			var id, productID string
+			if err := rows.Scan(&id, &productID); err != nil {
+				rows.Close()
+				return result, fmt.Errorf("failed to scan review: %w", err)
+			}
+			ids = append(ids, id)
+			if !inBatch[productID] {
+				inBatch[productID] = true
+				productIDs = append(productIDs, productID)
+			}
+			afterID = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return result, fmt.Errorf("failed to list imported reviews: %w", err)
+		}
+		if len(ids) == 0 {
+			return result, nil
+		}
+
+		changed, err := s.moderateBatch(ctx, ids, productIDs, status)
+		if err != nil {
+			return result, err
+		}
+		result.Reviews += changed
+		if status == models.ReviewPublished && changed > 0 {
+			for _, id := range productIDs {
+				if !recomputed[id] {
+					recomputed[id] = true
+					result.Products++
+				}
+				s.products.ratingChanged(ctx, id)
+			}
+		}
+		if len(ids) < reviewModerationBatch {
+			return result, nil
+		}
+	}
+}
+
+// moderateBatch sets the status of the reviews ids, of the distinct
+// products productIDs, that are still imported, returning how many it changed. Publishing them
+// recomputes the products' ratings.
+func (s *ReviewService) moderateBatch(ctx context.Context, ids, productIDs []string, status string) (int, error) {
+	var changed []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if status == models.ReviewPublished {
+			if _, err := lockStock(ctx, tx, productIDs); err != nil {
+				return err
+			}
+		}
+		if err := returnIDs(ctx, tx, &changed, `
+			UPDATE reviews SET status = $2 WHERE id = ANY($1::uuid[]) AND status = 'imported' RETURNING id`,
+			pq.Array(ids), status); err != nil {
+			return fmt.Errorf("failed to moderate reviews: %w", err)
+		}
+		if status != models.ReviewPublished || len(changed) == 0 {
+			return nil
+		}
+		return refreshRatings(ctx, tx, productIDs...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(changed), nil
+}
+
+// importedReviewsWhere returns the condition, on reviews aliased as r,
+// selecting the imported reviews matching filter, and its arguments
+func importedReviewsWhere(filter models.ReviewImportFilter) (string, []interface{}) {
+	conditions := []string{"r.status = 'imported'"}
+	var args []interface{}
+	if filter.Source != "" {
+		args = append(args, filter.Source)
+		conditions = append(conditions, fmt.Sprintf("r.source = $%d", len(args)))
+	}
+	if len(filter.Ratings) > 0 {
+		ratings := make([]int64, len(filter.Ratings))
+		for i, rating := range filter.Ratings {
+			ratings[i] = int64(rating)
+		}
+		args = append(args, pq.Array(ratings))
+		conditions = append(conditions, fmt.Sprintf("r.rating = ANY($%d::int[])", len(args)))
+	}
+	if filter.Keyword != "" {
+		args = append(args, "%"+escapeLike(filter.Keyword)+"%")
+		conditions = append(conditions, fmt.Sprintf("(r.title ILIKE $%[1]d OR r.comment ILIKE $%[1]d)", len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
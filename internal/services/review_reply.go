@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Review replies
+//
+// The seller of a product may reply publicly to each of its reviews, once;
+// replying again edits the reply. Replies are listed with their review, so
+// they are hidden while it is deleted and removed with it when it is
+// permanently deleted. The reviewer is notified of the first reply through
+// notifyEvent, so their notification preferences apply.
+
+// EventReviewReplied is published when a seller first replies to a review
+const EventReviewReplied = "review.replied"
+
+var ErrReviewReplyForbidden = errors.New("only the seller of the product may reply to its reviews")
+
+// ReviewRepliedEvent is the payload of EventReviewReplied
+type ReviewRepliedEvent struct {
+	ReviewID     string `json:"reviewId"`
+	ProductID    string `json:"productId"`
+	ProductTitle string `json:"productTitle"`
+	BuyerID      string `json:"buyerId"`
+}
+
+// reviewReplyColumns is the column list matching scanReviewReply, for
+// queries aliasing the review_replies table as rr
+const reviewReplyColumns = `rr.review_id, rr.seller_id, rr.comment, rr.created_at, rr.updated_at, rr.edited_at`
+
+// Reply sets sellerID's reply to review id, which must be of one of their
+// products, and reports whether it is their first
+func (s *ReviewService) Reply(ctx context.Context, id, sellerID string, input models.ReviewReplyInput) (*models.ReviewReply, bool, error) {
+	var reply *models.ReviewReply
+	var created bool
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var event ReviewRepliedEvent
+		var productSellerID string
+		err := tx.QueryRowContext(ctx, `
+			SELECT r.id, r.product_id, COALESCE(p.title, ''), COALESCE(r.buyer_id::text, ''), p.seller_id
+			FROM reviews r JOIN products p ON p.id = r.product_id
+			WHERE r.id = $1 AND r.deleted_at IS NULL AND r.status = 'published' AND p.deleted_at IS NULL
+			FOR UPDATE OF r`, id).Scan(&event.ReviewID, &event.ProductID, &event.ProductTitle, &event.BuyerID, &productSellerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReviewNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get review: %w", err)
+		}
+		if productSellerID != sellerID {
+			return ErrReviewReplyForbidden
+		}
+
+		reply, err = scanReviewReply(tx.QueryRowContext(ctx, `
+			INSERT INTO review_replies AS rr (review_id, seller_id, comment)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (review_id) DO UPDATE SET
+				seller_id = EXCLUDED.seller_id, comment = EXCLUDED.comment, updated_at = NOW(), edited_at = NOW()
+			RETURNING `+reviewReplyColumns+`, xmax = 0`, id, sellerID, input.Comment), &created)
+		if err != nil {
+			return fmt.Errorf("failed to save review reply: %w", err)
+		}
+		if !created {
+			return nil
+		}
+		return WriteOutbox(ctx, tx, EventReviewReplied, id, event)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return reply, created, nil
+}
+
+// NotifyReviewReply is the job handler for EventReviewReplied. It tells the
+// reviewer the seller replied, unless the review was deleted since or was
+// imported from a reviewer without an account.
+func (s *ReviewService) NotifyReviewReply(ctx context.Context, job *jobs.Job) error {
+	var event ReviewRepliedEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal review replied event: %w", err)
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT r.buyer_id FROM reviews r WHERE r.id = $1 AND r.deleted_at IS NULL AND r.buyer_id IS NOT NULL`,
+		event.ReviewID, "review_reply", "Seller replied to your review",
+		fmt.Sprintf("The seller of %s replied to your review", event.ProductTitle),
+		map[string]interface{}{"reviewId": event.ReviewID, "productId": event.ProductID})
+}
+
+// attachReplies sets the reply of each of reviews that has one
+func (s *ReviewService) attachReplies(ctx context.Context, reviews []models.Review) error {
+	if len(reviews) == 0 {
+		return nil
+	}
+	ids := make([]string, len(reviews))
+	for i, review := range reviews {
+		ids[i] = review.ID
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+reviewReplyColumns+` FROM review_replies rr WHERE rr.review_id = ANY($1::uuid[])`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get review replies: %w", err)
+	}
+	defer rows.Close()
+
+	replies := make(map[string]*models.ReviewReply)
+	for rows.Next() {
+		reply, err := scanReviewReply(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan review reply: %w", err)
+		}
+		replies[reply.ReviewID] = reply
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get review replies: %w", err)
+	}
+	for i := range reviews {
+		reviews[i].Reply = replies[reviews[i].ID]
+	}
+	return nil
+}
+
+// scanReviewReply scans a row selected with reviewReplyColumns, followed by
+// any extra destinations for additional selected columns
+func scanReviewReply(row rowScanner, extra ...interface{}) (*models.ReviewReply, error) {
+	var r models.ReviewReply
+	dest := []interface{}{&r.ReviewID, &r.SellerID, &r.Comment, &r.CreatedAt, &r.UpdatedAt, &r.EditedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// SearchRankingExperiment is the experiment that selects the keyword search ranking
+const SearchRankingExperiment = "search_ranking"
+
+// SearchParams represents the parameters of a keyword product search
+type SearchParams struct {
+	Query      string
+	CategoryID string
+	UserID     string
+	Limit      int
+	Offset     int
+	Highlight  bool   // return highlights of where each result matched
+	Locale     string // also match products' translations into this locale
+	Near       *models.GeoFilter
+	// SortByDistance ranks results nearest first; it needs Near
+	SortByDistance bool
+}
+
+// SearchResult represents a page of keyword search results
+type SearchResult struct {
+	Products []models.Product `json:"products"`
+	Total    int              `json:"total"`
+	Variant  string           `json:"variant"` // ranking variant served, for conversion attribution
+	Fuzzy    bool             `json:"fuzzy"`   // also matched titles similar to the query
+	// By product ID, when asked for
+	Highlights map[string]models.SearchHighlight `json:"highlights,omitempty"`
+}
+
+// SearchService handles product search
+type SearchService struct {
+	db          *database.PostgresDB
+	redis       *database.RedisClient
+	backend     SearchBackend
+	experiments *ExperimentService
+	queue       *jobs.Queue // query log; nil disables logging
+	embeddings  *embeddingStatus
+	fuzzy       config.FuzzySearchConfig
+}
+
+// NewSearchService creates a new search service. Searches finding fewer
+// results than fuzzy.MinResults are matched fuzzily when backend can.
+func NewSearchService(db *database.PostgresDB, redis *database.RedisClient, backend SearchBackend, experiments *ExperimentService, queue *jobs.Queue, fuzzy config.FuzzySearchConfig) *SearchService {
+	return &SearchService{db: db, redis: redis, backend: backend, experiments: experiments, queue: queue, embeddings: newEmbeddingStatus(db), fuzzy: fuzzy}
+}
+
+// Search performs a full-text product search, ranking results according to
+// the user's search_ranking experiment variant, with prices as of now. Each
+// search is logged for search analytics in the background. Results are
+// returned without highlights when making them fails. When the search finds
+// fewer than the configured minimum, it is run again matching titles
+// similar to the query too, so misspellings still find products; those
+// results are marked fuzzy. A search near a location only finds the
+// products of sellers within range, each with its distance.
+func (s *SearchService) Search(ctx context.Context, params SearchParams) (*SearchResult, error) {
+	start := time.Now()
+	variant := s.experiments.Variant(ctx, SearchRankingExperiment, params.UserID)
+	q := SearchQuery{
+		Text:       params.Query,
+		CategoryID: params.CategoryID,
+		Ranking:    variant,
+		Locale:     params.Locale,
+		Limit:      params.Limit,
+		Offset:     params.Offset,
+	}
+	var near *nearbySellers
+	if params.Near != nil {
+		var err error
+		if near, err = findNearbySellers(ctx, s.db, *params.Near); err != nil {
+			return nil, err
+		}
+		if len(near.ids) == 0 {
+			s.logQuery(ctx, params, 0, time.Since(start))
+			return &SearchResult{Products: []models.Product{}, Variant: variant}, nil
+		}
+		q.SellerIDs, q.SellerDistances, q.ByDistance = near.ids, near.distances, params.SortByDistance
+	}
+	products, total, err := s.backend.Search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	fuzzy := false
+	if fb, ok := s.backend.(FuzzySearchBackend); ok && total < s.fuzzy.MinResults {
+		fuzzyProducts, fuzzyTotal, err := fb.FuzzySearch(ctx, q, s.fuzzy.Threshold)
+		switch {
+		case err != nil:
+			log.Warn().Err(err).Msg("Failed to search products fuzzily")
+		case fuzzyTotal > total:
+			products, total, fuzzy = fuzzyProducts, fuzzyTotal, true
+		}
+	}
+	for i := range products {
+		products[i].ApplySaleAt(start)
+		if near != nil {
+			near.setDistance(&products[i])
+		}
+	}
+	result := &SearchResult{Products: products, Total: total, Variant: variant, Fuzzy: fuzzy}
+	if params.Highlight {
+		if result.Highlights, err = s.highlight(ctx, params.Query, products); err != nil {
+			log.Warn().Err(err).Msg("Failed to highlight search results")
+		}
+	}
+	s.logQuery(ctx, params, total, time.Since(start))
+	return result, nil
+}
+
+// Suggest returns product title completions for prefix
+func (s *SearchService) Suggest(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return s.backend.Suggest(ctx, prefix, limit)
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// JobSearchQueryLogged records a search in the search query log
+const JobSearchQueryLogged = "search.query_logged"
+
+// maxLoggedQueryLength caps stored queries so pasted text can't bloat the log
+const maxLoggedQueryLength = 200
+
+// SearchQueryLog is the payload of JobSearchQueryLogged
+type SearchQueryLog struct {
+	Query       string    `json:"query"`
+	CategoryID  string    `json:"categoryId,omitempty"`
+	ResultCount int       `json:"resultCount"`
+	LatencyMs   int64     `json:"latencyMs"`
+	UserID      string    `json:"userId,omitempty"`
+	SearchedAt  time.Time `json:"searchedAt"`
+}
+
+// NormalizeSearchQuery lowercases query, collapses whitespace and truncates
+// it so equivalent searches are counted together
+func NormalizeSearchQuery(query string) string {
+	query = strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	if len(query) > maxLoggedQueryLength {
+		query = query[:maxLoggedQueryLength]
+		for !utf8.ValidString(query) {
+			query = query[:len(query)-1]
+		}
+	}
+	return query
+}
+
+// logQuery enqueues a search for the query log. Only first pages are logged
+// so paging through results doesn't count as more searches. Failures are
+// logged and never fail the search.
+func (s *SearchService) logQuery(ctx context.Context, params SearchParams, total int, latency time.Duration) {
+	if s.queue == nil || params.Offset > 0 {
+		return
+	}
+	query := NormalizeSearchQuery(params.Query)
+	if query == "" {
+		return
+	}
+	entry := SearchQueryLog{
+		Query:       query,
+		CategoryID:  params.CategoryID,
+		ResultCount: total,
+		LatencyMs:   latency.Milliseconds(),
+		UserID:      params.UserID,
+		SearchedAt:  time.Now(),
+	}
+	if err := s.queue.Enqueue(ctx, "", JobSearchQueryLogged, entry); err != nil {
+		log.Warn().Err(err).Msg("Failed to enqueue search query log")
+	}
+}
+
+// RecordSearchQuery is the job handler for JobSearchQueryLogged. The job ID
+// is the row ID so redelivered jobs are recorded once.
+func (s *SearchService) RecordSearchQuery(ctx context.Context, job *jobs.Job) error {
+	var entry SearchQueryLog
+	if err := json.Unmarshal(job.Payload, &entry); err != nil {
+		return fmt.Errorf("failed to unmarshal search query log: %w", err)
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO search_queries (id, query, category_id, result_count, latency_ms, user_id, created_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, NULLIF($6, '')::uuid, $7)
+		ON CONFLICT (id) DO NOTHING`,
+		job.ID, entry.Query, entry.CategoryID, entry.ResultCount, entry.LatencyMs, entry.UserID, entry.SearchedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record search query: %w", err)
+	}
+	return nil
+}
+
+// Analytics summarizes searches since the given time: the most frequent
+// queries and the most frequent queries that returned nothing, limit of each
+func (s *SearchService) Analytics(ctx context.Context, since time.Time, limit int) (*models.SearchAnalytics, error) {
+	analytics := &models.SearchAnalytics{Since: since}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE result_count = 0)
+		FROM search_queries WHERE created_at >= $1`, since).Scan(&analytics.Searches, &analytics.ZeroResultSearches)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count search queries: %w", err)
+	}
+
+	analytics.TopQueries, err = s.topQueries(ctx, since, limit, "")
+	if err != nil {
+		return nil, err
+	}
+	analytics.TopZeroResultQueries, err = s.topQueries(ctx, since, limit, "AND result_count = 0")
+	if err != nil {
+		return nil, err
+	}
+	return analytics, nil
+}
+
+// topQueries returns the most frequent queries since the given time, with
+// condition (a fixed SQL fragment) narrowing the searches counted
+func (s *SearchService) topQueries(ctx context.Context, since time.Time, limit int, condition string) ([]models.SearchQueryStat, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT query, COUNT(*), COUNT(DISTINCT user_id), AVG(result_count), AVG(latency_ms)
+		FROM search_queries
+		WHERE created_at >= $1 %s
+		GROUP BY query
+		ORDER BY COUNT(*) DESC, query
+		LIMIT $2`, condition), since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top search queries: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []models.SearchQueryStat{}
+	for rows.Next() {
+		var stat models.SearchQueryStat
+		if err := rows.Scan(&stat.Query, &stat.Searches, &stat.Users, &stat.AvgResults, &stat.AvgLatencyMs); err != nil {
+			return nil, fmt.Errorf("failed to scan search query stats: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get top search queries: %w", err)
+	}
+	return stats, nil
+}
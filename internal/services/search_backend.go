@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// SearchQuery represents a keyword search against a search backend
+type SearchQuery struct {
+	Text       string
+	CategoryID string
+	Ranking    string // search_ranking experiment variant
+	Locale     string // also match translations into this locale; Postgres only
+	// SellerIDs, when set, narrows results to the products of these
+	// sellers, who are SellerDistances kilometres from the searcher.
+	// ByDistance ranks the nearest first; Postgres only.
+	SellerIDs       []string
+	SellerDistances []float64
+	ByDistance      bool
+	Limit           int
+	Offset          int
+}
+
+// SearchBackend is implemented by the engines that can serve product search.
+// Index and Delete keep the engine in sync with product writes; backends that
+// search the products table directly treat them as no-ops.
+type SearchBackend interface {
+	Search(ctx context.Context, q SearchQuery) ([]models.Product, int, error)
+	Suggest(ctx context.Context, prefix string, limit int) ([]string, error)
+	Index(ctx context.Context, product *models.Product) error
+	Delete(ctx context.Context, productID string) error
+}
+
+// FuzzySearchBackend is implemented by backends that can match queries
+// with typos. FuzzySearch matches what Search does as well as products whose
+// title is at least threshold similar to the query, ranked after them.
+type FuzzySearchBackend interface {
+	FuzzySearch(ctx context.Context, q SearchQuery, threshold float64) ([]models.Product, int, error)
+}
+
+// NewSearchBackend creates the search backend selected in configuration
+func NewSearchBackend(cfg config.SearchConfig, db *database.PostgresDB) (SearchBackend, error) {
+	switch cfg.Backend {
+	case "", "postgres":
+		return NewPostgresSearchBackend(db), nil
+	case "elasticsearch", "opensearch":
+		return NewElasticsearchBackend(cfg.Elasticsearch)
+	default:
+		return nil, fmt.Errorf("unknown search backend %q", cfg.Backend)
+	}
+}
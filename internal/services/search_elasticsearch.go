@@ -0,0 +1,208 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/version"
+)
+
+// ElasticsearchBackend serves product search from an Elasticsearch or
+// OpenSearch index over the REST API. Only the control ranking is supported;
+// other search_ranking variants, and ranking by distance, fall back to
+// relevance order.
+type ElasticsearchBackend struct {
+	baseURL  string
+	index    string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewElasticsearchBackend creates a new Elasticsearch search backend
+func NewElasticsearchBackend(cfg config.ElasticsearchConfig) (*ElasticsearchBackend, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("elasticsearch url is required")
+	}
+	index := cfg.Index
+	if index == "" {
+		index = "products"
+	}
+	return &ElasticsearchBackend{
+		baseURL:  strings.TrimRight(cfg.URL, "/"),
+		index:    index,
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Search performs a multi-field match over indexed products
+func (b *ElasticsearchBackend) Search(ctx context.Context, q SearchQuery) ([]models.Product, int, error) {
+	filter := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"isActive": true}},
+	}
+	if q.CategoryID != "" {
+		filter = append(filter, map[string]interface{}{"term": map[string]interface{}{"categoryIds": q.CategoryID}})
+	}
+	if len(q.SellerIDs) > 0 {
+		filter = append(filter, map[string]interface{}{"terms": map[string]interface{}{"sellerId": q.SellerIDs}})
+	}
+	body := map[string]interface{}{
+		"from": q.Offset,
+		"size": q.Limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":  strings.TrimSpace(q.Text),
+						"fields": []string{"title^2", "description", "tags"},
+					},
+				},
+				"filter": filter,
+			},
+		},
+	}
+
+	var resp struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source models.Product `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := b.do(ctx, http.MethodPost, "/"+b.index+"/_search", body, &resp); err != nil {
+		return nil, 0, fmt.Errorf("failed to search products: %w", err)
+	}
+
+	products := make([]models.Product, len(resp.Hits.Hits))
+	for i, hit := range resp.Hits.Hits {
+		products[i] = hit.Source
+	}
+	return products, resp.Hits.Total.Value, nil
+}
+
+// Suggest returns titles of indexed products matching prefix
+func (b *ElasticsearchBackend) Suggest(ctx context.Context, prefix string, limit int) ([]string, error) {
+	body := map[string]interface{}{
+		"size":    limit,
+		"_source": []string{"title"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"match_phrase_prefix": map[string]interface{}{"title": strings.TrimSpace(prefix)},
+				},
+				"filter": map[string]interface{}{"term": map[string]interface{}{"isActive": true}},
+			},
+		},
+	}
+
+	var resp struct {
+		Hits struct {
+			Hits []struct {
+				Source struct {
+					Title string `json:"title"`
+				} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := b.do(ctx, http.MethodPost, "/"+b.index+"/_search", body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to suggest products: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	suggestions := []string{}
+	for _, hit := range resp.Hits.Hits {
+		if title := hit.Source.Title; !seen[title] {
+			seen[title] = true
+			suggestions = append(suggestions, title)
+		}
+	}
+	return suggestions, nil
+}
+
+// Index creates or replaces the product document
+func (b *ElasticsearchBackend) Index(ctx context.Context, product *models.Product) error {
+	if err := b.do(ctx, http.MethodPut, "/"+b.index+"/_doc/"+url.PathEscape(product.ID), product, nil); err != nil {
+		return fmt.Errorf("failed to index product: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the product document; missing documents are not an error
+func (b *ElasticsearchBackend) Delete(ctx context.Context, productID string) error {
+	err := b.do(ctx, http.MethodDelete, "/"+b.index+"/_doc/"+url.PathEscape(productID), nil, nil)
+	var statusErr *elasticsearchError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete product from index: %w", err)
+	}
+	return nil
+}
+
+// elasticsearchError is returned for non-2xx cluster responses
+type elasticsearchError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *elasticsearchError) Error() string {
+	return fmt.Sprintf("elasticsearch returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// do sends a JSON request to the cluster and decodes the response into out
+func (b *ElasticsearchBackend) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+
+	defer utils.TrackTiming(req.Context(), "external")()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &elasticsearchError{StatusCode: resp.StatusCode, Body: string(msg)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
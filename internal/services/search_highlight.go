@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Markers ts_headline and markMatches put around matches before the text is
+// escaped; they are stripped from the text first so products can't fake one
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// highlightDescriptionChars is the length of description snippets made
+// without ts_headline
+const highlightDescriptionChars = 200
+
+// SearchHighlighter is implemented by search backends that make their own
+// highlight snippets
+type SearchHighlighter interface {
+	// Highlight returns snippets of the title and description of each of
+	// products ids matching text, keyed by product ID, with matches between
+	// highlightStart and highlightStop
+	Highlight(ctx context.Context, text string, ids []string) (map[string]models.SearchHighlight, error)
+}
+
+// highlight makes the highlights of products matching text, with snippets
+// from the backend when it makes them and marked term matches otherwise
+func (s *SearchService) highlight(ctx context.Context, text string, products []models.Product) (map[string]models.SearchHighlight, error) {
+	terms := searchTerms(text)
+	snippets := map[string]models.SearchHighlight{}
+	if h, ok := s.backend.(SearchHighlighter); ok && len(products) > 0 {
+		ids := make([]string, len(products))
+		for i, p := range products {
+			ids[i] = p.ID
+		}
+		var err error
+		if snippets, err = h.Highlight(ctx, text, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	highlights := make(map[string]models.SearchHighlight, len(products))
+	for _, p := range products {
+		titleMatches := matchTerms(p.Title, terms)
+		descriptionMatches := matchTerms(p.Description, terms)
+		h, ok := snippets[p.ID]
+		if !ok {
+			h = models.SearchHighlight{
+				Title:       markMatches(p.Title, titleMatches),
+				Description: markMatches(descriptionSnippet(p.Description, descriptionMatches)),
+			}
+		}
+		h.Title = escapeHighlight(h.Title)
+		h.Description = escapeHighlight(h.Description)
+		h.Matches = []models.TextMatch{}
+		for _, m := range titleMatches {
+			h.Matches = append(h.Matches, models.TextMatch{Field: "title", Start: m[0], End: m[1]})
+		}
+		for _, m := range descriptionMatches {
+			h.Matches = append(h.Matches, models.TextMatch{Field: "description", Start: m[0], End: m[1]})
+		}
+		highlights[p.ID] = h
+	}
+	return highlights, nil
+}
+
+// Highlight makes snippets with ts_headline, which matches stemmed words as
+// the full-text search does
+func (b *PostgresSearchBackend) Highlight(ctx context.Context, text string, ids []string) (map[string]models.SearchHighlight, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT p.id,
+			ts_headline('english', translate(p.title, $3, ''), q, 'HighlightAll=true, StartSel=' || $4 || ', StopSel=' || $5),
+			ts_headline('english', translate(COALESCE(p.description, ''), $3, ''), q,
+				'MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=" … ", StartSel=' || $4 || ', StopSel=' || $5)
+		FROM products p, plainto_tsquery('english', $1) q
+		WHERE p.id = ANY($2)`,
+		strings.TrimSpace(text), pq.Array(ids), highlightStart+highlightStop, highlightStart, highlightStop)
+	if err != nil {
+		return nil, fmt.Errorf("failed to highlight search results: %w", err)
+	}
+	defer rows.Close()
+
+	highlights := make(map[string]models.SearchHighlight, len(ids))
+	for rows.Next() {
+		var id string
+		var h models.SearchHighlight
+		if err := rows.Scan(&id, &h.Title, &h.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan search highlight: %w", err)
+		}
+		highlights[id] = h
+	}
+	return highlights, rows.Err()
+}
+
+// searchTerms splits a query into lower-case words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchTerms returns the character offsets of the words of text starting
+// with one of terms, ignoring case
+func matchTerms(text string, terms []string) [][2]int {
+	var matches [][2]int
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		if !isWordRune(runes[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		word := strings.ToLower(string(runes[start:end]))
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				matches = append(matches, [2]int{start, start + len([]rune(term))})
+				break
+			}
+		}
+		start = end
+	}
+	return matches
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// descriptionSnippet cuts description down to about
+// highlightDescriptionChars characters around its first match, shifting
+// matches to the snippet
+func descriptionSnippet(description string, matches [][2]int) (string, [][2]int) {
+	runes := []rune(description)
+	if len(runes) <= highlightDescriptionChars {
+		return description, matches
+	}
+	start := 0
+	if len(matches) > 0 {
+		start = max(matches[0][0]-highlightDescriptionChars/4, 0)
+	}
+	end := min(start+highlightDescriptionChars, len(runes))
+
+	var shifted [][2]int
+	for _, m := range matches {
+		if m[0] >= start && m[1] <= end {
+			shifted = append(shifted, [2]int{m[0] - start, m[1] - start})
+		}
+	}
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+		for i := range shifted {
+			shifted[i][0]++
+			shifted[i][1]++
+		}
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet, shifted
+}
+
+// markMatches puts the highlight markers around matches in text
+func markMatches(text string, matches [][2]int) string {
+	runes := []rune(strings.NewReplacer(highlightStart, "", highlightStop, "").Replace(text))
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m[0] < last || m[1] > len(runes) {
+			continue
+		}
+		b.WriteString(string(runes[last:m[0]]))
+		b.WriteString(highlightStart + string(runes[m[0]:m[1]]) + highlightStop)
+		last = m[1]
+	}
+	b.WriteString(string(runes[last:]))
+	return b.String()
+}
+
+// escapeHighlight HTML-escapes text and turns the highlight markers into
+// <mark> tags, so the only markup in a snippet is ours
+func escapeHighlight(text string) string {
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(html.EscapeString(text))
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// searchRankings maps search_ranking variants to their ORDER BY clause. Only
+// these fixed clauses are ever interpolated into the query.
+var searchRankings = map[string]string{
+	ControlVariant: "rank DESC, p.created_at DESC",
+	// Decay relevance by listing age in weeks so fresh listings surface first
+	"recency_boost": "rank / (1 + EXTRACT(EPOCH FROM NOW() - p.created_at) / 604800) DESC, p.created_at DESC",
+	// Lift featured listings above equally relevant ones
+	"featured_boost": "(rank + CASE WHEN p.is_featured THEN 0.1 ELSE 0 END) DESC, p.created_at DESC",
+}
+
+// searchConfigs maps primary languages to the text search configuration
+// their translations are matched with. Only these fixed names are ever
+// interpolated into the query; other languages use simple.
+var searchConfigs = map[string]string{
+	"de": "german",
+	"es": "spanish",
+	"fr": "french",
+	"pt": "portuguese",
+	"sv": "swedish",
+}
+
+// searchNearJoin joins the distances of the sellers searches near a location
+// are narrowed to, given as $6 and $7, and searchNearCondition narrows them
+// to those sellers' products; both leave searches without sellers alone
+const (
+	searchNearJoin      = `LEFT JOIN unnest($6::uuid[], $7::float8[]) AS near(seller_id, distance_km) ON near.seller_id = p.seller_id`
+	searchNearCondition = `($6::uuid[] IS NULL OR near.seller_id IS NOT NULL)`
+)
+
+// PostgresSearchBackend searches the products table with Postgres full-text search
+type PostgresSearchBackend struct {
+	db *database.PostgresDB
+}
+
+// NewPostgresSearchBackend creates a new Postgres search backend
+func NewPostgresSearchBackend(db *database.PostgresDB) *PostgresSearchBackend {
+	return &PostgresSearchBackend{db: db}
+}
+
+// Search performs a full-text search over product titles and descriptions.
+// With a locale, products also match on their translation into it, or else
+// into its primary language, ranked by whichever text matches better.
+func (b *PostgresSearchBackend) Search(ctx context.Context, q SearchQuery) ([]models.Product, int, error) {
+	orderBy, ok := searchRankings[q.Ranking]
+	if !ok {
+		orderBy = searchRankings[ControlVariant]
+	}
+	if q.ByDistance {
+		orderBy = "near.distance_km, " + orderBy
+	}
+	var locales []string
+	textConfig := "simple"
+	if q.Locale != "" {
+		locale := strings.ToLower(q.Locale)
+		primary, _, _ := strings.Cut(locale, "-")
+		locales = []string{locale, primary}
+		if cfg, ok := searchConfigs[primary]; ok {
+			textConfig = cfg
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s,
+			GREATEST(ts_rank(to_tsvector('english', p.title || ' ' || COALESCE(p.description, '')), plainto_tsquery('english', $1)),
+				COALESCE(ts_rank(to_tsvector('%[2]s', pt.title || ' ' || COALESCE(pt.description, '')), plainto_tsquery('%[2]s', $1)), 0)) AS rank,
+			COUNT(*) OVER() AS total
+		FROM products p
+		LEFT JOIN LATERAL (
+			SELECT title, description FROM product_translations
+			WHERE product_id = p.id AND locale = ANY($5)
+			ORDER BY length(locale) DESC
+			LIMIT 1
+		) pt ON true
+		`+searchNearJoin+`
+		WHERE p.is_active = true AND p.deleted_at IS NULL AND `+sellerListed+`
+		AND (to_tsvector('english', p.title || ' ' || COALESCE(p.description, '')) @@ plainto_tsquery('english', $1)
+			OR to_tsvector('%[2]s', pt.title || ' ' || COALESCE(pt.description, '')) @@ plainto_tsquery('%[2]s', $1))
+		AND ($2 = '' OR EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id::text = $2))
+		AND `+searchNearCondition+`
+		ORDER BY %[3]s
+		LIMIT $3 OFFSET $4`, productColumns, textConfig, orderBy)
+
+	rows, err := b.db.QueryContext(ctx, query, strings.TrimSpace(q.Text), q.CategoryID, q.Limit, q.Offset, pq.Array(locales),
+		pq.Array(q.SellerIDs), pq.Array(q.SellerDistances))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search products: %w", err)
+	}
+	defer rows.Close()
+
+	products := []models.Product{}
+	total := 0
+	for rows.Next() {
+		var rank float64
+		p, err := scanProduct(rows, &rank, &total)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		products = append(products, *p)
+	}
+	return products, total, rows.Err()
+}
+
+// FuzzySearch matches products as Search does, or whose title has a word
+// similarity of at least threshold to the query, using pg_trgm so the
+// title's trigram index serves it. Full-text matches rank first, as Search
+// ranks them under the control ranking, then similar titles by similarity.
+// Translations aren't matched fuzzily.
+func (b *PostgresSearchBackend) FuzzySearch(ctx context.Context, q SearchQuery, threshold float64) ([]models.Product, int, error) {
+	distanceFirst := ""
+	if q.ByDistance {
+		distanceFirst = "p.distance_km, "
+	}
+	var locales []string
+	textConfig := "simple"
+	if q.Locale != "" {
+		locale := strings.ToLower(q.Locale)
+		primary, _, _ := strings.Cut(locale, "-")
+		locales = []string{locale, primary}
+		if cfg, ok := searchConfigs[primary]; ok {
+			textConfig = cfg
+		}
+	}
+
+	query := fmt.Sprintf(`
+		WITH matches AS (
+			SELECT %s,
+				GREATEST(ts_rank(to_tsvector('english', p.title || ' ' || COALESCE(p.description, '')), plainto_tsquery('english', $1)),
+					COALESCE(ts_rank(to_tsvector('%[2]s', pt.title || ' ' || COALESCE(pt.description, '')), plainto_tsquery('%[2]s', $1)), 0)) AS rank,
+				to_tsvector('english', p.title || ' ' || COALESCE(p.description, '')) @@ plainto_tsquery('english', $1)
+					OR COALESCE(to_tsvector('%[2]s', pt.title || ' ' || COALESCE(pt.description, '')) @@ plainto_tsquery('%[2]s', $1), false) AS exact,
+				word_similarity($1, p.title) AS similarity,
+				near.distance_km
+			FROM products p
+			LEFT JOIN LATERAL (
+				SELECT title, description FROM product_translations
+				WHERE product_id = p.id AND locale = ANY($5)
+				ORDER BY length(locale) DESC
+				LIMIT 1
+			) pt ON true
+			`+searchNearJoin+`
+			WHERE p.is_active = true AND p.deleted_at IS NULL AND `+sellerListed+`
+			AND (to_tsvector('english', p.title || ' ' || COALESCE(p.description, '')) @@ plainto_tsquery('english', $1)
+				OR to_tsvector('%[2]s', pt.title || ' ' || COALESCE(pt.description, '')) @@ plainto_tsquery('%[2]s', $1)
+				OR $1 <%% p.title)
+			AND ($2 = '' OR EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id::text = $2))
+			AND `+searchNearCondition+`
+		)
+		SELECT *, COUNT(*) OVER() AS total
+		FROM matches p
+		ORDER BY %[3]sexact DESC, CASE WHEN exact THEN rank ELSE similarity END DESC, p.created_at DESC
+		LIMIT $3 OFFSET $4`, productColumns, textConfig, distanceFirst)
+
+	products := []models.Product{}
+	total := 0
+	err := b.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Scoped to the transaction, so <% matches at threshold here only
+		if _, err := tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+			strconv.FormatFloat(threshold, 'f', -1, 64)); err != nil {
+			return fmt.Errorf("failed to set similarity threshold: %w", err)
+		}
+		rows, err := tx.QueryContext(ctx, query, strings.TrimSpace(q.Text), q.CategoryID, q.Limit, q.Offset, pq.Array(locales),
+			pq.Array(q.SellerIDs), pq.Array(q.SellerDistances))
+		if err != nil {
+			return fmt.Errorf("failed to search products: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var rank, similarity float64
+			var exact bool
+			var distance sql.NullFloat64
+			p, err := scanProduct(rows, &rank, &exact, &similarity, &distance, &total)
+			if err != nil {
+				return fmt.Errorf("failed to scan search result: %w", err)
+			}
+			products = append(products, *p)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return products, total, nil
+}
+
+// Suggest returns product titles starting with prefix
+func (b *PostgresSearchBackend) Suggest(ctx context.Context, prefix string, limit int) ([]string, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT DISTINCT p.title
+		FROM products p
+		WHERE p.is_active = true AND p.deleted_at IS NULL AND `+sellerListed+`
+		AND p.title ILIKE $1 || '%' ESCAPE '\'
+		ORDER BY p.title
+		LIMIT $2`, escapeLike(strings.TrimSpace(prefix)), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest products: %w", err)
+	}
+	defer rows.Close()
+
+	suggestions := []string{}
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, fmt.Errorf("failed to scan suggestion: %w", err)
+		}
+		suggestions = append(suggestions, title)
+	}
+	return suggestions, rows.Err()
+}
+
+// Index is a no-op; Postgres searches the products table directly
+func (b *PostgresSearchBackend) Index(ctx context.Context, product *models.Product) error {
+	return nil
+}
+
+// Delete is a no-op; deleted products are filtered out by the search query
+func (b *PostgresSearchBackend) Delete(ctx context.Context, productID string) error {
+	return nil
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// SemanticSearchParams represents the parameters of a semantic product search
+type SemanticSearchParams struct {
+	Query      string
+	CategoryID string
+	Limit      int
+	Offset     int
+}
+
+// SemanticSearchResult represents a page of semantic search results
+type SemanticSearchResult struct {
+	Products []models.Product `json:"products"`
+	Fallback bool             `json:"fallback"` // keyword results, as there are no embeddings yet
+}
+
+// Semantic searches listed products by the meaning of the query, closest
+// first, with prices as of now. While no product has an embedding, such as
+// before the first reindex, it returns the control keyword ranking instead
+// and flags the result.
+func (s *SearchService) Semantic(ctx context.Context, params SemanticSearchParams) (*SemanticSearchResult, error) {
+	start := time.Now()
+	if !s.embeddings.available(ctx) {
+		products, _, err := s.backend.Search(ctx, SearchQuery{
+			Text:       params.Query,
+			CategoryID: params.CategoryID,
+			Ranking:    ControlVariant,
+			Limit:      params.Limit,
+			Offset:     params.Offset,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range products {
+			products[i].ApplySaleAt(start)
+		}
+		return &SemanticSearchResult{Products: products, Fallback: true}, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM search_products_semantic($1, NULLIF($2, '')::uuid, $3, $4) s
+		JOIN products p ON p.id = s.product_id
+		WHERE p.deleted_at IS NULL AND `+sellerListed+`
+		ORDER BY s.similarity`, productColumns)
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(params.Query), params.CategoryID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search products semantically: %w", err)
+	}
+	defer rows.Close()
+
+	products := []models.Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		p.ApplySaleAt(start)
+		products = append(products, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search products semantically: %w", err)
+	}
+	return &SemanticSearchResult{Products: products}, nil
+}
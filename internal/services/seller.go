@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// EventSellerStatusChanged is published when an admin changes a seller's
+// status
+const EventSellerStatusChanged = "seller.status_changed"
+
+var ErrSellerNotFound = errors.New("seller not found")
+
+// SellerNotVerifiedError is returned when a seller who isn't verified does
+// what only verified sellers may
+type SellerNotVerifiedError struct {
+	Status string
+}
+
+func (e *SellerNotVerifiedError) Error() string {
+	return "seller is " + e.Status
+}
+
+// Message explains to the seller why they were refused
+func (e *SellerNotVerifiedError) Message() string {
+	if e.Status == models.SellerSuspended {
+		return "Your seller account is suspended"
+	}
+	return "Your seller account is pending verification"
+}
+
+// SellerStatusChangedEvent is the payload of EventSellerStatusChanged
+type SellerStatusChangedEvent struct {
+	ChangeID   string `json:"changeId"`
+	SellerID   string `json:"sellerId"`
+	FromStatus string `json:"fromStatus"`
+	ToStatus   string `json:"toStatus"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// sellerListed holds for products p whose seller isn't suspended. Listings
+// and search leave out the others.
+const sellerListed = `NOT EXISTS (SELECT 1 FROM users su WHERE su.id = p.seller_id AND su.seller_status = 'suspended')`
+
+// checkSellerVerified fails with a *SellerNotVerifiedError unless sellerID
+// is verified, keeping their status from changing until tx ends
+func checkSellerVerified(ctx context.Context, tx *sql.Tx, sellerID string) error {
+	var status string
+	err := tx.QueryRowContext(ctx, `SELECT seller_status FROM users WHERE id = $1 FOR SHARE`, sellerID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSellerNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get seller status: %w", err)
+	}
+	if status != models.SellerVerified {
+		return &SellerNotVerifiedError{Status: status}
+	}
+	return nil
+}
+
+// SellerService handles the verification of sellers
+type SellerService struct {
+	db       *database.PostgresDB
+	products *ProductService
+}
+
+// NewSellerService creates a new seller service
+func NewSellerService(db *database.PostgresDB, products *ProductService) *SellerService {
+	return &SellerService{db: db, products: products}
+}
+
+// isSeller holds for users u who sell or have sold
+const isSeller = `(u.role = 'seller' OR EXISTS (SELECT 1 FROM products p WHERE p.seller_id = u.id))`
+
+const sellerColumns = `u.id, u.username, u.email, COALESCE(u.full_name, ''), u.seller_status, u.accepting_orders, u.created_at,
+	(SELECT COUNT(*) FROM products p WHERE p.seller_id = u.id AND p.deleted_at IS NULL),
+	(SELECT MAX(c.created_at) FROM seller_status_changes c WHERE c.seller_id = u.id)`
+
+// ListSellers returns a page of sellers, oldest first, only those with
+// status when it is given
+func (s *SellerService) ListSellers(ctx context.Context, status string, limit, offset int) (*models.SellerPage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+sellerColumns+`, COUNT(*) OVER()
+		FROM users u
+		WHERE `+isSeller+` AND ($1 = '' OR u.seller_status = $1)
+		ORDER BY u.created_at, u.id
+		LIMIT $2 OFFSET $3`, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sellers: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.SellerPage{Sellers: []models.Seller{}}
+	for rows.Next() {
+		var seller models.Seller
+		if err := rows.Scan(&seller.ID, &seller.Username, &seller.Email, &seller.FullName, &seller.Status, &seller.AcceptingOrders, &seller.CreatedAt,
+			&seller.Products, &seller.StatusChangedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan seller: %w", err)
+		}
+		page.Sellers = append(page.Sellers, seller)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sellers: %w", err)
+	}
+	return page, nil
+}
+
+// SetSellerStatus changes a seller's status, recording who changed it and
+// why. Payouts due to a seller are held while they aren't verified and
+// become due again once they are. The seller is notified, and their products
+// brought in or out of search, by EventSellerStatusChanged. Setting the
+// status a seller already has changes nothing.
+func (s *SellerService) SetSellerStatus(ctx context.Context, sellerID, adminID string, input models.SellerStatusInput) (*models.Seller, error) {
+	var event *SellerStatusChangedEvent
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var from string
+		err := tx.QueryRowContext(ctx, `SELECT seller_status FROM users WHERE id = $1 FOR UPDATE`, sellerID).Scan(&from)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSellerNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get seller status: %w", err)
+		}
+		if from == input.Status {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users SET seller_status = $2, updated_at = NOW() WHERE id = $1`, sellerID, input.Status); err != nil {
+			return fmt.Errorf("failed to update seller status: %w", err)
+		}
+		event = &SellerStatusChangedEvent{SellerID: sellerID, FromStatus: from, ToStatus: input.Status, Reason: input.Reason}
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO seller_status_changes (seller_id, from_status, to_status, reason, changed_by)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5) RETURNING id`,
+			sellerID, from, input.Status, input.Reason, adminID).Scan(&event.ChangeID); err != nil {
+			return fmt.Errorf("failed to record seller status change: %w", err)
+		}
+
+		payoutFrom, payoutTo := models.PayoutHeld, models.PayoutDue
+		if input.Status != models.SellerVerified {
+			payoutFrom, payoutTo = payoutTo, payoutFrom
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE sub_orders SET payout_status = $3, updated_at = NOW() WHERE seller_id = $1 AND payout_status = $2`,
+			sellerID, payoutFrom, payoutTo); err != nil {
+			return fmt.Errorf("failed to update seller payouts: %w", err)
+		}
+		return WriteOutbox(ctx, tx, EventSellerStatusChanged, sellerID, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if event != nil && (event.FromStatus == models.SellerSuspended || event.ToStatus == models.SellerSuspended) {
+		s.products.invalidateSellerListings(ctx, sellerID)
+	}
+	seller, err := scanSeller(s.db.QueryRowContext(ctx, `SELECT `+sellerColumns+` FROM users u WHERE u.id = $1`, sellerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get seller: %w", err)
+	}
+	return seller, nil
+}
+
+// SellerStatusHistory returns a page of a seller's status changes, newest
+// first
+func (s *SellerService) SellerStatusHistory(ctx context.Context, sellerID string, limit, offset int) (*models.SellerStatusChangePage, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, sellerID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to get seller: %w", err)
+	}
+	if !exists {
+		return nil, ErrSellerNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, seller_id, from_status, to_status, COALESCE(reason, ''), changed_by, created_at, COUNT(*) OVER()
+		FROM seller_status_changes
+		WHERE seller_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2 OFFSET $3`, sellerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get seller status history: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.SellerStatusChangePage{Changes: []models.SellerStatusChange{}}
+	for rows.Next() {
+		var c models.SellerStatusChange
+		if err := rows.Scan(&c.ID, &c.SellerID, &c.FromStatus, &c.ToStatus, &c.Reason, &c.ChangedBy, &c.CreatedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan seller status change: %w", err)
+		}
+		page.Changes = append(page.Changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get seller status history: %w", err)
+	}
+	return page, nil
+}
+
+// sellerStatusMessages are the notifications sent for each new status
+var sellerStatusMessages = map[string][2]string{
+	models.SellerVerified:  {"Seller account verified", "You can now list products and receive payouts"},
+	models.SellerPending:   {"Seller account under review", "Your seller account is being reviewed again"},
+	models.SellerSuspended: {"Seller account suspended", "Your products are hidden and payouts are held"},
+}
+
+// NotifySellerStatusChanged is the job handler for
+// EventSellerStatusChanged. It brings the seller's products in or out of
+// the search index when they are suspended or reinstated, then notifies the
+// seller, with the admin's reason when one was given.
+func (s *SellerService) NotifySellerStatusChanged(ctx context.Context, job *jobs.Job) error {
+	var event SellerStatusChangedEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal seller status event: %w", err)
+	}
+	if event.FromStatus == models.SellerSuspended || event.ToStatus == models.SellerSuspended {
+		if err := s.products.reindexSeller(ctx, event.SellerID); err != nil {
+			return err
+		}
+	}
+
+	text := sellerStatusMessages[event.ToStatus]
+	message := text[1]
+	if event.Reason != "" {
+		message += ": " + event.Reason
+	}
+	return notifyEvent(ctx, s.db, job.ID, `SELECT $1::uuid`, event.SellerID, "seller_status", text[0], message,
+		map[string]interface{}{"status": event.ToStatus, "changeId": event.ChangeID})
+}
+
+func scanSeller(row rowScanner) (*models.Seller, error) {
+	var seller models.Seller
+	if err := row.Scan(&seller.ID, &seller.Username, &seller.Email, &seller.FullName, &seller.Status, &seller.AcceptingOrders, &seller.CreatedAt,
+		&seller.Products, &seller.StatusChangedAt); err != nil {
+		return nil, err
+	}
+	return &seller, nil
+}
+
+// invalidateSellerListings invalidates the listings a seller's products are
+// in, after they are hidden or shown again
+func (s *ProductService) invalidateSellerListings(ctx context.Context, sellerID string) {
+	var categoryIDs []string
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(DISTINCT pc.category_id::text), '{}')
+		FROM product_categories pc JOIN products p ON p.id = pc.product_id
+		WHERE p.seller_id = $1 AND p.deleted_at IS NULL`, sellerID).Scan(pq.Array(&categoryIDs)); err != nil {
+		log.Warn().Err(err).Str("seller_id", sellerID).Msg("Failed to get seller's categories")
+	}
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+}
+
+// reindexSeller mirrors a seller's products to the search backend as index
+// does, after the seller was suspended or reinstated
+func (s *ProductService) reindexSeller(ctx context.Context, sellerID string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id FROM products p WHERE p.seller_id = $1 AND p.deleted_at IS NULL`, sellerID)
+	if err != nil {
+		return fmt.Errorf("failed to list seller's products: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan product: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list seller's products: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	products, err := s.GetByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		s.index(ctx, product)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Seller follows
+//
+// Shoppers follow sellers to see their products in a feed, newest first by
+// when each was first published, and are notified as each new one is unless
+// they opt out. Notifications go through notifyEvent, so followers taking a
+// daily digest get them in their digest. Products of suspended sellers stay
+// out of the feed as they do out of listings, but their follows are kept for
+// when the seller is reinstated.
+
+// feedCursor marks the last product of a feed page: its published_at, as
+// Postgres prints it so no precision is lost, and ID
+type feedCursor struct {
+	PublishedAt string `json:"p"`
+	ID          string `json:"id"`
+}
+
+// Follow makes userID follow seller sellerID, notified of each product they
+// publish unless input.Notify is false. Following a seller again only
+// changes whether the follower is notified. Sellers who are suspended, or
+// don't sell, are not found.
+func (s *SellerService) Follow(ctx context.Context, userID, sellerID string, input models.SellerFollowInput) (*models.SellerFollow, error) {
+	if userID == sellerID {
+		return nil, &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "id", Code: "self", Message: "you can't follow yourself",
+		}}}
+	}
+	notify := input.Notify == nil || *input.Notify
+
+	follow := &models.SellerFollow{}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO seller_followers (user_id, seller_id, notify)
+		SELECT $1, u.id, $3 FROM users u
+		WHERE u.id = $2 AND `+isSeller+` AND u.seller_status <> 'suspended'
+		ON CONFLICT (user_id, seller_id) DO UPDATE SET notify = EXCLUDED.notify
+		RETURNING seller_id, notify, created_at`, userID, sellerID, notify).Scan(&follow.SellerID, &follow.Notify, &follow.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSellerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to follow seller: %w", err)
+	}
+	return follow, nil
+}
+
+// Unfollow stops userID following seller sellerID. Unfollowing a seller the
+// user doesn't follow changes nothing.
+func (s *SellerService) Unfollow(ctx context.Context, userID, sellerID string) error {
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM seller_followers WHERE user_id = $1 AND seller_id = $2`, userID, sellerID); err != nil {
+		return fmt.Errorf("failed to unfollow seller: %w", err)
+	}
+	return nil
+}
+
+// Feed returns a page of the published products of the sellers userID
+// follows, newest first, after filter's cursor when it has one. Products are
+// priced as of now and bundles include their components.
+func (s *SellerService) Feed(ctx context.Context, userID string, filter models.FeedFilter) (*models.FeedPage, error) {
+	var after feedCursor
+	if filter.Cursor != "" {
+		cursor, err := decodeFeedCursor(filter.Cursor)
+		if err != nil || cursor.PublishedAt == "" || uuid.Validate(cursor.ID) != nil {
+			return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidProductFilter)
+		}
+		after = cursor
+	}
+
+	// Fetch one extra row to learn whether there is a next page. Each
+	// followed seller's products are read from idx_products_seller_published.
+	query := fmt.Sprintf(`
+		SELECT %s, p.published_at::text
+		FROM seller_followers f
+		JOIN products p ON p.seller_id = f.seller_id
+		WHERE f.user_id = $1 AND p.status = 'published' AND p.deleted_at IS NULL AND `+sellerListed+`
+			AND ($2 OR `+productStock+` > 0)
+			AND ($3 = '' OR (p.published_at, p.id) < (NULLIF($3, '')::timestamptz, NULLIF($4, '')::uuid))
+		ORDER BY p.published_at DESC, p.id DESC
+		LIMIT $5`, productColumns)
+	rows, err := s.db.QueryContext(ctx, query, userID, filter.IncludeOutOfStock, after.PublishedAt, after.ID, filter.Limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feed: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.FeedPage{Products: []models.Product{}}
+	var last feedCursor
+	for rows.Next() {
+		var publishedAt string
+		product, err := scanProduct(rows, &publishedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan feed product: %w", err)
+		}
+		if len(page.Products) == filter.Limit {
+			page.NextCursor = encodeFeedCursor(last)
+			break
+		}
+		page.Products = append(page.Products, *product)
+		last = feedCursor{PublishedAt: publishedAt, ID: product.ID}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get feed: %w", err)
+	}
+
+	products := make([]*models.Product, len(page.Products))
+	for i := range page.Products {
+		products[i] = &page.Products[i]
+	}
+	if err := s.products.addBundleItems(ctx, products...); err != nil {
+		return nil, err
+	}
+	applySales(time.Now(), products...)
+	return page, nil
+}
+
+// NotifyFollowers is the job handler for EventProductPublished, notifying
+// the seller's followers who haven't opted out
+func (s *SellerService) NotifyFollowers(ctx context.Context, job *jobs.Job) error {
+	var event ProductPublishedEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal product published event: %w", err)
+	}
+	return notifyProductEvent(ctx, s.db, job.ID, `SELECT user_id FROM seller_followers WHERE seller_id = $1 AND notify`, event.SellerID,
+		"new_product", "New from "+event.SellerName, fmt.Sprintf("%s listed %s", event.SellerName, event.Title), event.ProductID)
+}
+
+func encodeFeedCursor(c feedCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeFeedCursor(s string) (feedCursor, error) {
+	var c feedCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Seller availability
+//
+// A seller can set weekly business hours in their timezone and pause
+// accepting orders. Shipping methods configured with seller_hours, like
+// pickup, need the seller at hand, so checkout refuses them while any
+// seller of the order is closed or paused; other methods ignore hours.
+// Hours are read in the seller's timezone at the moment of checking, so
+// daylight saving changes move them with the local clock. A range closing
+// at or before it opens runs past midnight into the next day. A seller
+// without hours is open whenever they accept orders.
+
+// weekdays are the days of BusinessHours, indexed by time.Weekday
+var weekdays = [...]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+)
+
+// sellerAvailabilityColumns is the column list matching
+// scanSellerAvailability, for queries aliasing the users table as u
+const sellerAvailabilityColumns = `u.id, u.accepting_orders, u.business_hours`
+
+// scanSellerAvailability scans a row selected with sellerAvailabilityColumns,
+// followed by any extra destinations, and works out whether the seller is
+// open at now
+func scanSellerAvailability(row rowScanner, now time.Time, extra ...interface{}) (*models.SellerAvailability, error) {
+	var a models.SellerAvailability
+	var hours []byte
+	if err := row.Scan(append([]interface{}{&a.SellerID, &a.AcceptingOrders, &hours}, extra...)...); err != nil {
+		return nil, err
+	}
+	if hours != nil {
+		if err := json.Unmarshal(hours, &a.BusinessHours); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal business hours: %w", err)
+		}
+	}
+	a.OpenNow = a.AcceptingOrders && openAt(a.BusinessHours, now)
+	return &a, nil
+}
+
+// Availability returns whether seller sellerID takes orders now
+func (s *SellerService) Availability(ctx context.Context, sellerID string) (*models.SellerAvailability, error) {
+	a, err := scanSellerAvailability(s.db.QueryRowContext(ctx, `
+		SELECT `+sellerAvailabilityColumns+` FROM users u WHERE u.id = $1 AND `+isSeller, sellerID), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSellerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get seller availability: %w", err)
+	}
+	return a, nil
+}
+
+// SetAvailability replaces the business hours of seller sellerID and
+// whether they accept orders. The hours are checked first, failing with a
+// *validators.ValidationError for each problem.
+func (s *SellerService) SetAvailability(ctx context.Context, sellerID string, input models.SellerAvailabilityInput) (*models.SellerAvailability, error) {
+	var hours []byte
+	if input.BusinessHours != nil {
+		if invalid := checkBusinessHours(input.BusinessHours); len(invalid) > 0 {
+			return nil, &validators.ValidationError{Fields: invalid}
+		}
+		var err error
+		if hours, err = json.Marshal(input.BusinessHours); err != nil {
+			return nil, fmt.Errorf("failed to marshal business hours: %w", err)
+		}
+	}
+	a, err := scanSellerAvailability(s.db.QueryRowContext(ctx, `
+		UPDATE users u SET accepting_orders = $2, business_hours = $3, updated_at = NOW()
+		WHERE u.id = $1
+		RETURNING `+sellerAvailabilityColumns, sellerID, *input.AcceptingOrders, jsonParam(hours)), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSellerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set seller availability: %w", err)
+	}
+	return a, nil
+}
+
+// SellerOpen sets whether product's seller is accepting orders and within
+// their business hours now. Failures leave it unset.
+func (s *ProductService) SellerOpen(ctx context.Context, product *models.Product) {
+	a, err := scanSellerAvailability(s.db.QueryRowContext(ctx, `
+		SELECT `+sellerAvailabilityColumns+` FROM users u WHERE u.id = $1`, product.SellerID), time.Now())
+	if err != nil {
+		return
+	}
+	product.SellerOpen = &a.OpenNow
+}
+
+// checkSellersOpen fails with a *validators.ValidationError on shippingMethod
+// when method needs the seller at hand and any of sellerIDs is closed or not
+// accepting orders at now
+func (s *ShippingService) checkSellersOpen(ctx context.Context, tx *sql.Tx, method string, sellerIDs []string, now time.Time) error {
+	if len(sellerIDs) == 0 || !s.needsSellerHours(method) {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+sellerAvailabilityColumns+`, u.username FROM users u WHERE u.id = ANY($1)
+		ORDER BY u.username`, pq.Array(sellerIDs))
+	if err != nil {
+		return fmt.Errorf("failed to get seller availability: %w", err)
+	}
+	defer rows.Close()
+
+	var invalid []validators.FieldError
+	for rows.Next() {
+		var username string
+		a, err := scanSellerAvailability(rows, now, &username)
+		if err != nil {
+			return fmt.Errorf("failed to scan seller availability: %w", err)
+		}
+		if a.OpenNow {
+			continue
+		}
+		reason := "is closed right now"
+		if !a.AcceptingOrders {
+			reason = "isn't accepting orders right now"
+		}
+		invalid = append(invalid, validators.FieldError{
+			Field: "shippingMethod", Code: "seller_closed", Param: a.SellerID,
+			Message: fmt.Sprintf("%s %s, so %s isn't available; choose standard shipping or try again during their business hours", username, reason, method),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get seller availability: %w", err)
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
+
+// openAt reports whether hours are open at t; no hours are always open
+func openAt(hours *models.BusinessHours, t time.Time) bool {
+	if hours == nil {
+		return true
+	}
+	loc, err := time.LoadLocation(hours.Timezone)
+	if err != nil {
+		return false
+	}
+	local := t.In(loc)
+	minute := int(local.Weekday())*minutesPerDay + local.Hour()*60 + local.Minute()
+	for _, r := range weekRanges(hours) {
+		// Saturday's overnight ranges run into Sunday, the start of the week
+		if (minute >= r.opens && minute < r.closes) || minute+minutesPerWeek < r.closes {
+			return true
+		}
+	}
+	return false
+}
+
+// weekRange is an HoursRange in minutes from the start of Sunday, closing
+// after it opens
+type weekRange struct {
+	field         string
+	opens, closes int
+}
+
+// weekRanges returns the ranges of hours, which must parse, by opening time
+func weekRanges(hours *models.BusinessHours) []weekRange {
+	var ranges []weekRange
+	for day, name := range weekdays {
+		for i, r := range hours.Days[name] {
+			opens, _ := parseHoursMinute(r.Open)
+			closes, _ := parseHoursMinute(r.Close)
+			if closes <= opens {
+				closes += minutesPerDay
+			}
+			start := day * minutesPerDay
+			ranges = append(ranges, weekRange{
+				field: fmt.Sprintf("businessHours.days.%s[%d]", name, i), opens: start + opens, closes: start + closes,
+			})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].opens < ranges[j].opens })
+	return ranges
+}
+
+// parseHoursMinute parses an HH:MM time of day into minutes after midnight.
+// "24:00" is midnight at the end of the day.
+func parseHoursMinute(s string) (int, bool) {
+	if s == "24:00" {
+		return minutesPerDay, true
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != 5 {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// checkBusinessHours returns a field error for each problem with hours: an
+// unknown timezone or day, a time that isn't HH:MM, a range that opens and
+// closes at once, and ranges that overlap, overnight ones included
+func checkBusinessHours(hours *models.BusinessHours) []validators.FieldError {
+	var invalid []validators.FieldError
+	if _, err := time.LoadLocation(hours.Timezone); err != nil || hours.Timezone == "" || strings.EqualFold(hours.Timezone, "local") {
+		invalid = append(invalid, validators.FieldError{
+			Field: "businessHours.timezone", Code: "timezone", Message: "timezone must be an IANA time zone, like Europe/London",
+		})
+	}
+	known := make(map[string]bool, len(weekdays))
+	for _, name := range weekdays {
+		known[name] = true
+	}
+	var names []string
+	for name := range hours.Days {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !known[name] {
+			invalid = append(invalid, validators.FieldError{
+				Field: "businessHours.days." + name, Code: "weekday", Message: "days must be keyed by lowercase weekday name",
+			})
+			continue
+		}
+		for i, r := range hours.Days[name] {
+			field := fmt.Sprintf("businessHours.days.%s[%d]", name, i)
+			opens, ok := parseHoursMinute(r.Open)
+			if !ok || opens == minutesPerDay {
+				invalid = append(invalid, validators.FieldError{Field: field + ".open", Code: "time", Message: "open must be a time of day as HH:MM"})
+				continue
+			}
+			closes, ok := parseHoursMinute(r.Close)
+			if !ok {
+				invalid = append(invalid, validators.FieldError{Field: field + ".close", Code: "time", Message: "close must be a time of day as HH:MM, or 24:00"})
+				continue
+			}
+			if opens == closes {
+				invalid = append(invalid, validators.FieldError{Field: field + ".close", Code: "nefield", Param: "open", Message: "close must differ from open"})
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		return invalid
+	}
+
+	ranges := weekRanges(hours)
+	for i := 1; i < len(ranges); i++ {
+		if prev := ranges[i-1]; ranges[i].opens < prev.closes {
+			invalid = append(invalid, validators.FieldError{
+				Field: ranges[i].field, Code: "overlap", Param: prev.field, Message: "hours overlap " + prev.field,
+			})
+		}
+	}
+	// Saturday's overnight ranges may run into Sunday's first
+	if n := len(ranges); n > 1 && ranges[n-1].closes-minutesPerWeek > ranges[0].opens {
+		last := ranges[n-1]
+		invalid = append(invalid, validators.FieldError{
+			Field: ranges[0].field, Code: "overlap", Param: last.field, Message: "hours overlap " + last.field,
+		})
+	}
+	return invalid
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Seller locations
+//
+// A seller can say where they are, so shoppers can find products from
+// sellers near them. Listings and searches given a location are narrowed to
+// the products of sellers within a radius of it, each with its distance,
+// and can be sorted nearest first. Sellers in range are found first: by a
+// bounding box around the location, which their coordinates' index serves,
+// then by their great-circle distance, from geo_distance_km. The product
+// queries join the sellers found, so search backends other than Postgres
+// filter by them too. Sellers without a location never match.
+
+// earthRadiusKm is the mean radius of the earth; keep it in step with
+// geo_distance_km
+const earthRadiusKm = 6371.0
+
+// nearbySellers are the sellers within range of a location, with their
+// distances from it in kilometres
+type nearbySellers struct {
+	ids       []string
+	distances []float64
+	byID      map[string]float64
+}
+
+// findNearbySellers returns the sellers within near.RadiusKm of near's
+// location
+func findNearbySellers(ctx context.Context, db *database.PostgresDB, near models.GeoFilter) (*nearbySellers, error) {
+	// The box bounds the latitudes and longitudes within the radius. Near a
+	// pole it takes in every longitude, and across the antimeridian its
+	// longitudes wrap around.
+	angle := near.RadiusKm / earthRadiusKm
+	latDelta := angle * 180 / math.Pi
+	minLat, maxLat := near.Latitude-latDelta, near.Latitude+latDelta
+	minLng, maxLng, wraps := -180.0, 180.0, false
+	if ratio := math.Sin(angle) / math.Cos(near.Latitude*math.Pi/180); minLat > -90 && maxLat < 90 && ratio < 1 {
+		lngDelta := math.Asin(ratio) * 180 / math.Pi
+		minLng, maxLng = near.Longitude-lngDelta, near.Longitude+lngDelta
+		if minLng < -180 {
+			minLng, wraps = minLng+360, true
+		}
+		if maxLng > 180 {
+			maxLng, wraps = maxLng-360, true
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, distance FROM (
+			SELECT id, geo_distance_km($1, $2, latitude, longitude) AS distance
+			FROM users
+			WHERE latitude BETWEEN $3 AND $4
+				AND CASE WHEN $7 THEN longitude >= $5 OR longitude <= $6 ELSE longitude BETWEEN $5 AND $6 END
+		) near
+		WHERE distance <= $8`,
+		near.Latitude, near.Longitude, minLat, maxLat, minLng, maxLng, wraps, near.RadiusKm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find nearby sellers: %w", err)
+	}
+	defer rows.Close()
+
+	sellers := &nearbySellers{byID: make(map[string]float64)}
+	for rows.Next() {
+		var id string
+		var distance float64
+		if err := rows.Scan(&id, &distance); err != nil {
+			return nil, fmt.Errorf("failed to scan nearby seller: %w", err)
+		}
+		sellers.ids = append(sellers.ids, id)
+		sellers.distances = append(sellers.distances, distance)
+		sellers.byID[id] = distance
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find nearby sellers: %w", err)
+	}
+	return sellers, nil
+}
+
+// setDistance sets product's distance from the location its seller was
+// found near
+func (n *nearbySellers) setDistance(product *models.Product) {
+	if distance, ok := n.byID[product.SellerID]; ok {
+		product.DistanceKm = &distance
+	}
+}
+
+// Location returns where seller sellerID is
+func (s *SellerService) Location(ctx context.Context, sellerID string) (*models.SellerLocation, error) {
+	location := &models.SellerLocation{SellerID: sellerID}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.latitude, u.longitude FROM users u WHERE u.id = $1 AND `+isSeller, sellerID).Scan(&location.Latitude, &location.Longitude)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSellerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get seller location: %w", err)
+	}
+	return location, nil
+}
+
+// SetLocation sets where seller sellerID is; a nil input clears it
+func (s *SellerService) SetLocation(ctx context.Context, sellerID string, input *models.SellerLocationInput) (*models.SellerLocation, error) {
+	var latitude, longitude *float64
+	if input != nil {
+		latitude, longitude = input.Latitude, input.Longitude
+	}
+	location := &models.SellerLocation{SellerID: sellerID}
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE users SET latitude = $2, longitude = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING latitude, longitude`, sellerID, latitude, longitude).Scan(&location.Latitude, &location.Longitude)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSellerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set seller location: %w", err)
+	}
+	return location, nil
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Shipping
+//
+// An order ships by one method, chosen at checkout, at the method's
+// configured rate in the order's currency. A method is free once the
+// order's subtotal after discounts reaches its free-shipping threshold. The
+// cost is split over the order's sub-orders in proportion to their
+// subtotals, so the sub-orders' totals still sum to the order's. Quote and
+// Create price shipping the same way from the same priced cart, so checkout
+// charges the shipping the quote showed for the same cart and method.
+
+// ShippingService prices and dates the shipping methods an order can ship by
+type ShippingService struct {
+	delivery *DeliveryService
+	methods  []shippingMethod
+}
+
+// shippingMethod is a configured shipping method with its amounts parsed
+type shippingMethod struct {
+	method   string
+	name     string
+	rates    map[string]money.Money
+	freeOver map[string]money.Money
+	transit  *deliveryTransit // nil to take the delivery estimate's
+	hours    bool             // needs the sellers open
+}
+
+// NewShippingService creates a new shipping service, failing on rate table
+// amounts that don't parse in their currency
+func NewShippingService(delivery *DeliveryService, cfg config.ShippingConfig) (*ShippingService, error) {
+	s := &ShippingService{delivery: delivery, methods: make([]shippingMethod, len(cfg.Methods))}
+	for i, m := range cfg.Methods {
+		method := shippingMethod{method: m.Method, name: m.Name, hours: m.SellerHours}
+		var err error
+		if method.rates, err = parseShippingAmounts(m.Rates); err != nil {
+			return nil, fmt.Errorf("shipping.methods: %s rates: %w", m.Method, err)
+		}
+		if method.freeOver, err = parseShippingAmounts(m.FreeOver); err != nil {
+			return nil, fmt.Errorf("shipping.methods: %s free_over: %w", m.Method, err)
+		}
+		if m.Method != models.ShippingStandard {
+			method.transit = &deliveryTransit{MinDays: m.MinDays, MaxDays: m.MaxDays}
+		}
+		s.methods[i] = method
+	}
+	return s, nil
+}
+
+// needsSellerHours reports whether method can only be chosen while the
+// order's sellers are open
+func (s *ShippingService) needsSellerHours(method string) bool {
+	for _, m := range s.methods {
+		if m.method == method {
+			return m.hours
+		}
+	}
+	return false
+}
+
+// parseShippingAmounts parses amounts keyed by currency code
+func parseShippingAmounts(amounts map[string]string) (map[string]money.Money, error) {
+	parsed := make(map[string]money.Money, len(amounts))
+	for currency, amount := range amounts {
+		m, err := money.Parse(amount, currency)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", currency, amount, err)
+		}
+		if m.Amount < 0 {
+			return nil, fmt.Errorf("%s %s is negative", currency, amount)
+		}
+		parsed[currency] = m
+	}
+	return parsed, nil
+}
+
+// cost returns what shipping goods, an order's subtotal after discounts, by
+// the method costs, and false when the method has no rate in goods' currency
+func (m shippingMethod) cost(goods money.Money) (money.Money, bool) {
+	if len(m.rates) == 0 {
+		return money.Zero(goods.Currency), true
+	}
+	rate, ok := m.rates[goods.Currency]
+	if !ok {
+		return money.Money{}, false
+	}
+	if threshold, ok := m.freeOver[goods.Currency]; ok && goods.Amount >= threshold.Amount {
+		return money.Zero(goods.Currency), true
+	}
+	return rate, true
+}
+
+// options returns the methods the priced cart can ship by to postalCode, in
+// configured order, with what each would cost and when it would arrive
+func (s *ShippingService) options(ctx context.Context, cart *pricedCart, totals *cartTotals, postalCode string) ([]models.ShippingOption, error) {
+	options := []models.ShippingOption{}
+	lines := s.deliveryLines(cart)
+	if len(lines) == 0 {
+		return options, nil
+	}
+	goods, err := totals.order.Subtotal.Sub(totals.order.Discount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total order: %w", err)
+	}
+	for _, m := range s.methods {
+		cost, ok := m.cost(goods)
+		if !ok {
+			continue
+		}
+		option := models.ShippingOption{Method: m.method, Name: m.name, Cost: cost, Free: cost.IsZero()}
+		if threshold, ok := m.freeOver[goods.Currency]; ok {
+			option.FreeOver = &threshold
+		}
+		estimate := s.delivery.estimate(ctx, postalCode, lines, m.transit)
+		option.EarliestDate, option.LatestDate = estimate.EarliestDate, estimate.LatestDate
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// ship adds the cost of shipping the priced cart by method to its totals. It
+// fails with a *validators.ValidationError when the method can't ship the
+// cart's currency.
+func (s *ShippingService) ship(cart *pricedCart, totals *cartTotals, method string) error {
+	if len(totals.sellerIDs) == 0 {
+		return nil
+	}
+	goods, err := totals.order.Subtotal.Sub(totals.order.Discount)
+	if err != nil {
+		return fmt.Errorf("failed to total order: %w", err)
+	}
+	for _, m := range s.methods {
+		if m.method != method {
+			continue
+		}
+		if cost, ok := m.cost(goods); ok {
+			return totals.addShipping(cart.currency, cost)
+		}
+		break
+	}
+	return &validators.ValidationError{Fields: []validators.FieldError{{
+		Field: "shippingMethod", Code: "unavailable", Param: method,
+		Message: fmt.Sprintf("%s shipping is not available for orders in %s", method, cart.currency),
+	}}}
+}
+
+// deliveryLines returns the cart's lines without problems as delivered
+func (s *ShippingService) deliveryLines(cart *pricedCart) []deliveryLine {
+	var lines []deliveryLine
+	for _, line := range cart.lines {
+		if line.problem != nil {
+			continue
+		}
+		delivery := deliveryLine{productID: line.productID, warehouse: line.warehouse}
+		s.delivery.applyDefaults(&delivery, line.processingDays)
+		lines = append(lines, delivery)
+	}
+	return lines
+}
+
+// addShipping adds an order's shipping cost to its totals, split over the
+// sellers in proportion to their subtotals
+func (t *cartTotals) addShipping(currency string, cost money.Money) error {
+	weights := make([]money.Money, len(t.sellers))
+	for i, seller := range t.sellers {
+		weights[i] = seller.Subtotal
+	}
+	shares, err := cost.Allocate(weights)
+	if err != nil {
+		return fmt.Errorf("failed to allocate shipping: %w", err)
+	}
+	for i, seller := range t.sellers {
+		if t.sellers[i], err = newTotals(seller.Subtotal, seller.Discount, seller.Tax, shares[i]); err != nil {
+			return err
+		}
+	}
+	t.order, err = sumTotals(currency, t.sellers)
+	return err
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/version"
+)
+
+// ShippingCarrier is implemented by the carriers shipping labels are
+// bought from
+type ShippingCarrier interface {
+	// BuyLabel buys a label for request's parcel. Retries with the same
+	// idempotency key are bought once. A label the carrier won't sell fails
+	// with a *CarrierError, and a carrier that can't be reached with
+	// ErrCarrierUnavailable.
+	BuyLabel(ctx context.Context, request CarrierLabelRequest, idempotencyKey string) (*CarrierLabel, error)
+	// DownloadLabel fetches the file of a bought label, returning it and
+	// its content type
+	DownloadLabel(ctx context.Context, labelURL string) ([]byte, string, error)
+}
+
+// ErrCarrierUnavailable is returned when the carrier can't be reached or
+// fails on its side
+var ErrCarrierUnavailable = errors.New("shipping carrier is unavailable")
+
+// Carrier error codes
+const (
+	CarrierInvalidAddress = "invalid_address"
+	CarrierInvalidParcel  = "invalid_parcel"
+	CarrierNoRates        = "no_rates"
+	CarrierRejected       = "label_rejected"
+)
+
+// CarrierError is returned when the carrier refuses, or would refuse, to
+// sell a label, with what is wrong in words the seller can act on
+type CarrierError struct {
+	Code    string
+	Message string
+}
+
+func (e *CarrierError) Error() string {
+	return "label refused: " + e.Code + ": " + e.Message
+}
+
+// CarrierAddress is an address a label ships from or to
+type CarrierAddress struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postalCode"`
+	Country    string `json:"country"` // ISO 3166 code
+	Phone      string `json:"phone"`
+	Email      string `json:"email"`
+}
+
+// CarrierParcel is a parcel's weight in grams and dimensions in
+// centimetres
+type CarrierParcel struct {
+	Weight, Length, Width, Height int
+}
+
+// CarrierLabelRequest is what a label is bought for
+type CarrierLabelRequest struct {
+	From   CarrierAddress
+	To     CarrierAddress
+	Parcel CarrierParcel
+}
+
+// CarrierLabel is a label as the carrier sold it
+type CarrierLabel struct {
+	Reference      string // the carrier's ID of the purchase
+	Carrier        string
+	Service        string
+	TrackingNumber string
+	LabelURL       string
+	Cost           money.Money
+}
+
+// maxLabelBytes caps a downloaded label file
+const maxLabelBytes = 5 << 20
+
+// NewShippingCarrier creates the carrier selected in configuration. Without
+// an API key no carrier is configured and it returns nil.
+func NewShippingCarrier(cfg config.LabelsConfig) (ShippingCarrier, error) {
+	if cfg.APIKey == "" {
+		return nil, nil
+	}
+	switch cfg.Carrier {
+	case "shippo":
+		return &ShippoCarrier{
+			baseURL:      strings.TrimRight(cfg.BaseURL, "/"),
+			apiKey:       cfg.APIKey,
+			serviceLevel: cfg.ServiceLevel,
+			fileType:     cfg.FileType,
+			client:       &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown shipping carrier %q", cfg.Carrier)
+	}
+}
+
+// ShippoCarrier buys labels through Shippo: a shipment is rated across the
+// carriers of the Shippo account, and a transaction buys the chosen rate
+type ShippoCarrier struct {
+	baseURL      string
+	apiKey       string
+	serviceLevel string
+	fileType     string
+	client       *http.Client
+}
+
+// shippoRate is the part of a Shippo rate we read
+type shippoRate struct {
+	ObjectID     string `json:"object_id"`
+	Amount       string `json:"amount"`
+	Currency     string `json:"currency"`
+	Provider     string `json:"provider"`
+	ServiceLevel struct {
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	} `json:"servicelevel"`
+}
+
+// shippoMessage is a message Shippo attaches to a shipment or transaction
+type shippoMessage struct {
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+// shippoError is an error response from Shippo. Its body names the fields
+// it refused, in nested objects, or has a detail.
+type shippoError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *shippoError) Error() string {
+	return fmt.Sprintf("shippo returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// BuyLabel rates a shipment and buys the configured service level's rate,
+// or the cheapest
+func (c *ShippoCarrier) BuyLabel(ctx context.Context, request CarrierLabelRequest, idempotencyKey string) (*CarrierLabel, error) {
+	p := request.Parcel
+	var shipment struct {
+		ObjectID string          `json:"object_id"`
+		Rates    []shippoRate    `json:"rates"`
+		Messages []shippoMessage `json:"messages"`
+	}
+	err := c.post(ctx, "/shipments/", map[string]interface{}{
+		"address_from": shippoAddress(request.From),
+		"address_to":   shippoAddress(request.To),
+		"parcels": []map[string]interface{}{{
+			"length": fmt.Sprint(p.Length), "width": fmt.Sprint(p.Width), "height": fmt.Sprint(p.Height),
+			"distance_unit": "cm", "weight": fmt.Sprint(p.Weight), "mass_unit": "g",
+		}},
+		"async": false,
+	}, "", &shipment)
+	var serr *shippoError
+	if errors.As(err, &serr) && serr.StatusCode < 500 {
+		code := CarrierInvalidAddress
+		if strings.Contains(serr.Body, `"parcels"`) {
+			code = CarrierInvalidParcel
+		}
+		return nil, &CarrierError{Code: code, Message: serr.Body}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rate shipment: %w", err)
+	}
+
+	rate := c.pickRate(shipment.Rates)
+	if rate == nil {
+		message := "no carrier service can ship this parcel between these addresses"
+		if texts := shippoTexts(shipment.Messages); texts != "" {
+			message += ": " + texts
+		}
+		return nil, &CarrierError{Code: CarrierNoRates, Message: message}
+	}
+	cost, err := money.Parse(rate.Amount, strings.ToUpper(rate.Currency))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse label rate %s %s: %w", rate.Amount, rate.Currency, err)
+	}
+
+	var transaction struct {
+		ObjectID       string          `json:"object_id"`
+		Status         string          `json:"status"`
+		TrackingNumber string          `json:"tracking_number"`
+		LabelURL       string          `json:"label_url"`
+		Messages       []shippoMessage `json:"messages"`
+	}
+	err = c.post(ctx, "/transactions/", map[string]interface{}{
+		"rate":            rate.ObjectID,
+		"label_file_type": c.fileType,
+		"metadata":        idempotencyKey,
+		"async":           false,
+	}, idempotencyKey, &transaction)
+	if errors.As(err, &serr) && serr.StatusCode < 500 {
+		return nil, &CarrierError{Code: CarrierRejected, Message: serr.Body}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to buy label: %w", err)
+	}
+	if transaction.Status != "SUCCESS" {
+		message := "the carrier refused the label"
+		if texts := shippoTexts(transaction.Messages); texts != "" {
+			message += ": " + texts
+		}
+		return nil, &CarrierError{Code: CarrierRejected, Message: message}
+	}
+	return &CarrierLabel{
+		Reference:      transaction.ObjectID,
+		Carrier:        rate.Provider,
+		Service:        rate.ServiceLevel.Name,
+		TrackingNumber: transaction.TrackingNumber,
+		LabelURL:       transaction.LabelURL,
+		Cost:           cost,
+	}, nil
+}
+
+// pickRate returns the rate of the configured service level, or else the
+// cheapest, or nil when there are none to pick from
+func (c *ShippoCarrier) pickRate(rates []shippoRate) *shippoRate {
+	if c.serviceLevel != "" {
+		for i := range rates {
+			if rates[i].ServiceLevel.Token == c.serviceLevel {
+				return &rates[i]
+			}
+		}
+		return nil
+	}
+	var cheapest *shippoRate
+	var lowest int64
+	for i := range rates {
+		amount, err := money.Parse(rates[i].Amount, strings.ToUpper(rates[i].Currency))
+		if err != nil {
+			continue
+		}
+		if cheapest == nil || amount.Amount < lowest {
+			cheapest, lowest = &rates[i], amount.Amount
+		}
+	}
+	return cheapest
+}
+
+// DownloadLabel fetches a label file from the URL Shippo gave for it
+func (c *ShippoCarrier) DownloadLabel(ctx context.Context, labelURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, labelURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	defer utils.TrackTiming(req.Context(), "external")()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCarrierUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%w: label download returned %d", ErrCarrierUnavailable, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLabelBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCarrierUnavailable, err)
+	}
+	if len(body) > maxLabelBytes {
+		return nil, "", fmt.Errorf("label file is larger than %d bytes", maxLabelBytes)
+	}
+	contentType := http.DetectContentType(body)
+	if contentType != "application/pdf" && contentType != "image/png" {
+		return nil, "", fmt.Errorf("label file is %s, not a PDF or PNG", contentType)
+	}
+	return body, contentType, nil
+}
+
+// post sends a JSON request to Shippo, decoding the response into out.
+// Failures to reach Shippo, and its server errors, are
+// ErrCarrierUnavailable.
+func (c *ShippoCarrier) post(ctx context.Context, path string, body interface{}, idempotencyKey string, out interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(encoded))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "ShippoToken "+c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	defer utils.TrackTiming(req.Context(), "external")()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCarrierUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		serr := &shippoError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(text))}
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%w: %v", ErrCarrierUnavailable, serr)
+		}
+		return serr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// shippoAddress returns address as Shippo takes it
+func shippoAddress(address CarrierAddress) map[string]string {
+	return map[string]string{
+		"name": address.Name, "street1": address.Line1, "street2": address.Line2, "city": address.City,
+		"state": address.State, "zip": address.PostalCode, "country": address.Country, "phone": address.Phone,
+		"email": address.Email,
+	}
+}
+
+// shippoTexts joins the texts of messages
+func shippoTexts(messages []shippoMessage) string {
+	texts := make([]string, 0, len(messages))
+	for _, m := range messages {
+		if m.Text != "" {
+			texts = append(texts, m.Text)
+		}
+	}
+	return strings.Join(texts, "; ")
+}
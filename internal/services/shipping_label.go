@@ -0,0 +1,301 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/storage"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Shipping labels
+//
+// The seller of a paid order, or staff, buys its shipping label from the
+// carrier, for the parcel they describe, from the seller's address to the
+// order's shipping address. An order has one label: it is bought under the
+// order's row lock and recorded in the same transaction, so asking again,
+// even at once, returns the label bought rather than paying for another.
+// The label file is then fetched from the carrier and kept in blob storage
+// under a key only the order's viewers are given a route to; should that
+// fail, asking again fetches it without buying. Orders with several
+// sellers ship as sub-orders and get no label of their own.
+
+var (
+	ErrLabelsUnavailable   = errors.New("no shipping carrier is configured")
+	ErrLabelNotFound       = errors.New("shipping label not found")
+	ErrOrderSeveralSellers = errors.New("order ships from several sellers")
+)
+
+// maxParcelLengthGirth is the most a parcel's length and girth may add up
+// to, in centimetres, under the carriers' common size limit
+const maxParcelLengthGirth = 419
+
+// ShippingLabelService buys shipping labels for orders
+type ShippingLabelService struct {
+	db      *database.PostgresDB
+	store   storage.BlobStore
+	carrier ShippingCarrier // nil when none is configured
+	orders  *OrderService
+}
+
+// NewShippingLabelService creates a new shipping label service buying
+// labels from carrier and keeping their files in store. Who may view an
+// order is as orders says.
+func NewShippingLabelService(db *database.PostgresDB, store storage.BlobStore, carrier ShippingCarrier, orders *OrderService) *ShippingLabelService {
+	return &ShippingLabelService{db: db, store: store, carrier: carrier, orders: orders}
+}
+
+// labelColumns are the columns getLabel reads, from shipping_labels
+const labelColumns = `order_id, carrier, service, tracking_number, carrier_label_url, COALESCE(label_key, ''),
+	COALESCE(content_type, ''), cost_cents, currency, weight_grams, length_cm, width_cm, height_cm,
+	COALESCE(created_by::text, ''), created_at`
+
+// storedLabel is a label with where its file is
+type storedLabel struct {
+	models.ShippingLabel
+	carrierURL string
+	key        string
+}
+
+// CreateLabel returns the shipping label of order orderID, buying it for
+// input's parcel unless the order has one, and reports whether it bought
+// it. Staff and the order's only seller may buy it.
+func (s *ShippingLabelService) CreateLabel(ctx context.Context, orderID, userID string, isStaff bool, input models.ShippingLabelInput) (*models.ShippingLabel, bool, error) {
+	if err := s.orders.authorizeView(ctx, orderID, userID, isStaff); err != nil {
+		return nil, false, err
+	}
+	if s.carrier == nil {
+		return nil, false, ErrLabelsUnavailable
+	}
+	if fields := checkParcel(input); len(fields) > 0 {
+		return nil, false, &validators.ValidationError{Fields: fields}
+	}
+
+	var label *storedLabel
+	var bought *CarrierLabel
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		sellerID, err := orderSeller(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if !isStaff && userID != sellerID {
+			return ErrOrderForbidden
+		}
+		// A label bought before is returned whatever the order's status now
+		if label, err = getLabel(ctx, tx, orderID); !errors.Is(err, ErrLabelNotFound) {
+			return err
+		}
+		if order.status != "paid" {
+			return ErrOrderNotFulfillable
+		}
+
+		request, err := labelRequest(ctx, tx, orderID, sellerID)
+		if err != nil {
+			return err
+		}
+		request.Parcel = CarrierParcel{Weight: input.Weight, Length: input.Length, Width: input.Width, Height: input.Height}
+		// Last but the insert, so little can fail after the label is paid for
+		if bought, err = s.carrier.BuyLabel(ctx, request, "label-"+orderID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO shipping_labels (order_id, carrier, service, tracking_number, carrier_reference, carrier_label_url,
+				cost_cents, currency, weight_grams, length_cm, width_cm, height_cm, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, '')::uuid)`,
+			orderID, bought.Carrier, bought.Service, bought.TrackingNumber, bought.Reference, bought.LabelURL,
+			bought.Cost.Amount, bought.Cost.Currency, input.Weight, input.Length, input.Width, input.Height, userID); err != nil {
+			return fmt.Errorf("failed to record shipping label: %w", err)
+		}
+		label, err = getLabel(ctx, tx, orderID)
+		return err
+	})
+	if err != nil && bought != nil {
+		// The label is paid for, so one we failed to record needs a person
+		log.Error().Err(err).Str("order_id", orderID).Str("carrier_reference", bought.Reference).
+			Str("tracking_number", bought.TrackingNumber).Msg("Bought shipping label but failed to record it")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if label.key == "" {
+		if err := s.storeLabelFile(ctx, label); err != nil {
+			return nil, false, err
+		}
+	}
+	return &label.ShippingLabel, bought != nil, nil
+}
+
+// LabelFile returns the blob key and content type of the label file of
+// order orderID, if userID may view the order
+func (s *ShippingLabelService) LabelFile(ctx context.Context, orderID, userID string, isStaff bool) (string, string, error) {
+	if err := s.orders.authorizeView(ctx, orderID, userID, isStaff); err != nil {
+		return "", "", err
+	}
+	var key, contentType string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT label_key, content_type FROM shipping_labels
+		WHERE order_id = $1 AND label_key IS NOT NULL`, orderID).Scan(&key, &contentType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", ErrLabelNotFound
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get shipping label: %w", err)
+	}
+	return key, contentType, nil
+}
+
+// storeLabelFile fetches label's file from the carrier and stores it,
+// recording where on the label
+func (s *ShippingLabelService) storeLabelFile(ctx context.Context, label *storedLabel) error {
+	body, contentType, err := s.carrier.DownloadLabel(ctx, label.carrierURL)
+	if err != nil {
+		return fmt.Errorf("failed to download shipping label: %w", err)
+	}
+	ext := ".pdf"
+	if contentType == "image/png" {
+		ext = ".png"
+	}
+	key := "labels/" + label.OrderID + ext
+	info, err := s.store.Put(ctx, key, bytes.NewReader(body), contentType)
+	if err != nil {
+		return fmt.Errorf("failed to store shipping label: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE shipping_labels SET label_key = $2, content_type = $3, size = $4 WHERE order_id = $1`,
+		label.OrderID, key, contentType, info.Size); err != nil {
+		return fmt.Errorf("failed to record shipping label file: %w", err)
+	}
+	label.key, label.ContentType = key, contentType
+	return nil
+}
+
+// checkParcel returns a field error when input's parcel is beyond the
+// carriers' size limit, its length and girth together
+func checkParcel(input models.ShippingLabelInput) []validators.FieldError {
+	longest := max(input.Length, input.Width, input.Height)
+	lengthGirth := longest + 2*(input.Length+input.Width+input.Height-longest)
+	if lengthGirth <= maxParcelLengthGirth {
+		return nil
+	}
+	return []validators.FieldError{{
+		Field: "length", Code: "max", Param: fmt.Sprint(maxParcelLengthGirth),
+		Message: fmt.Sprintf("parcel length plus girth must be at most %d cm, not %d", maxParcelLengthGirth, lengthGirth),
+	}}
+}
+
+// orderSeller returns the seller of order orderID's items, failing with
+// ErrOrderSeveralSellers when there are more than one
+func orderSeller(ctx context.Context, tx *sql.Tx, orderID string) (string, error) {
+	var sellers []string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(DISTINCT p.seller_id::text), '{}')
+		FROM order_items oi JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = $1`, orderID).Scan(pq.Array(&sellers)); err != nil {
+		return "", fmt.Errorf("failed to get order sellers: %w", err)
+	}
+	if len(sellers) != 1 {
+		return "", ErrOrderSeveralSellers
+	}
+	return sellers[0], nil
+}
+
+// labelRequest returns the label request for order orderID shipped by
+// sellerID, from the seller's address to the order's, failing with a
+// *CarrierError naming what either lacks
+func labelRequest(ctx context.Context, tx *sql.Tx, orderID, sellerID string) (CarrierLabelRequest, error) {
+	var from, to []byte
+	var sellerName, sellerPhone, sellerEmail, buyerName, buyerPhone, buyerEmail string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(s.address, '{}'), COALESCE(s.full_name, s.username), COALESCE(s.phone, ''), s.email,
+			COALESCE(o.shipping_address, '{}'), COALESCE(b.full_name, b.username), COALESCE(b.phone, ''), b.email
+		FROM orders o JOIN users b ON b.id = o.buyer_id, users s
+		WHERE o.id = $1 AND s.id = $2`, orderID, sellerID).Scan(
+		&from, &sellerName, &sellerPhone, &sellerEmail, &to, &buyerName, &buyerPhone, &buyerEmail); err != nil {
+		return CarrierLabelRequest{}, fmt.Errorf("failed to get label addresses: %w", err)
+	}
+
+	var request CarrierLabelRequest
+	var err error
+	if request.From, err = carrierAddress("seller address", from, sellerName, sellerPhone, sellerEmail); err != nil {
+		return CarrierLabelRequest{}, err
+	}
+	if request.To, err = carrierAddress("shipping address", to, buyerName, buyerPhone, buyerEmail); err != nil {
+		return CarrierLabelRequest{}, err
+	}
+	return request, nil
+}
+
+// carrierAddress parses the stored address raw, described as what, taking
+// the name, phone and email it lacks from its user. It fails with a
+// *CarrierError when the address lacks a street, city, postal code or
+// two-letter country.
+func carrierAddress(what string, raw []byte, name, phone, email string) (CarrierAddress, error) {
+	var address CarrierAddress
+	if err := json.Unmarshal(raw, &address); err != nil {
+		return CarrierAddress{}, &CarrierError{Code: CarrierInvalidAddress, Message: what + " can't be read"}
+	}
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"line1", address.Line1}, {"city", address.City}, {"postalCode", address.PostalCode},
+	} {
+		if strings.TrimSpace(f.value) == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(address.Country) != 2 {
+		missing = append(missing, "country")
+	}
+	if len(missing) > 0 {
+		return CarrierAddress{}, &CarrierError{
+			Code: CarrierInvalidAddress, Message: what + " is missing " + strings.Join(missing, ", "),
+		}
+	}
+	if address.Name == "" {
+		address.Name = name
+	}
+	if address.Phone == "" {
+		address.Phone = phone
+	}
+	if address.Email == "" {
+		address.Email = email
+	}
+	return address, nil
+}
+
+// getLabel returns the label of order orderID within tx, or
+// ErrLabelNotFound
+func getLabel(ctx context.Context, tx *sql.Tx, orderID string) (*storedLabel, error) {
+	var label storedLabel
+	var cost int64
+	var currency string
+	err := tx.QueryRowContext(ctx, `SELECT `+labelColumns+` FROM shipping_labels WHERE order_id = $1`, orderID).Scan(
+		&label.OrderID, &label.Carrier, &label.Service, &label.TrackingNumber, &label.carrierURL, &label.key,
+		&label.ContentType, &cost, &currency, &label.Parcel.Weight, &label.Parcel.Length, &label.Parcel.Width,
+		&label.Parcel.Height, &label.CreatedBy, &label.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLabelNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shipping label: %w", err)
+	}
+	label.Cost = money.New(cost, currency)
+	label.URL = "/api/v1/orders/" + label.OrderID + "/label/file"
+	return &label, nil
+}
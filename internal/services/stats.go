@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/cache"
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+const (
+	// statsWindowStep rounds the default window, which ends on a
+	// statsWindowStep boundary so repeated dashboard loads share an entry
+	statsWindowStep = 5 * time.Minute
+	// statsQueryTimeout bounds each stats query; one that runs out leaves its
+	// figures out of the stats rather than failing them
+	statsQueryTimeout = 10 * time.Second
+	// topCategoriesLimit is how many categories the stats rank
+	topCategoriesLimit = 10
+	// countedOrders selects the orders stats count: paid and not cancelled
+	countedOrders = `o.status NOT IN ('pending', 'cancelled')`
+	// marketItems selects the order items marketplace stats count: those not
+	// of a seller buying their own product
+	marketItems = `NOT oi.self_purchase`
+)
+
+// StatsParams selects the window and series granularity of marketplace stats
+type StatsParams struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+}
+
+// DefaultStatsWindow returns the window stats cover when none is given: the
+// 30 days up to the last statsWindowStep boundary
+func DefaultStatsWindow(now time.Time) (from, to time.Time) {
+	to = now.UTC().Truncate(statsWindowStep)
+	return to.AddDate(0, 0, -30), to
+}
+
+// StatsService computes aggregate marketplace stats for admins
+type StatsService struct {
+	db    *database.PostgresDB
+	cache *cache.Cache
+}
+
+// NewStatsService creates a new stats service
+func NewStatsService(db *database.PostgresDB, cache *cache.Cache) *StatsService {
+	return &StatsService{db: db, cache: cache}
+}
+
+// partialStatsError carries stats that are missing figures, so they are
+// returned to every caller sharing the load without being cached
+type partialStatsError struct {
+	stats *models.MarketplaceStats
+}
+
+func (e *partialStatsError) Error() string { return "marketplace stats are partial" }
+
+// Marketplace returns the marketplace stats for a window, cached as
+// admin_stats. Each figure is computed by its own query, concurrently; a query
+// that fails or times out is reported in the stats' warnings and the rest are
+// still returned. Partial stats are not cached.
+func (s *StatsService) Marketplace(ctx context.Context, params StatsParams) (*models.MarketplaceStats, error) {
+	key := strconv.FormatInt(params.From.Unix(), 10) + ":" + strconv.FormatInt(params.To.Unix(), 10) + ":" + params.Granularity
+	var stats models.MarketplaceStats
+	err := s.cache.GetOrSet(ctx, config.CacheAdminStats, key, nil, &stats, func(ctx context.Context) (interface{}, error) {
+		computed, err := s.compute(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(computed.Warnings) > 0 {
+			return nil, &partialStatsError{stats: computed}
+		}
+		return computed, nil
+	})
+	var perr *partialStatsError
+	if errors.As(err, &perr) {
+		return perr.stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// compute runs the stats queries. It fails only when every query does.
+func (s *StatsService) compute(ctx context.Context, params StatsParams) (*models.MarketplaceStats, error) {
+	stats := &models.MarketplaceStats{
+		From:          params.From,
+		To:            params.To,
+		Granularity:   params.Granularity,
+		GMV:           []money.Money{},
+		Series:        []models.StatsPoint{},
+		TopCategories: []models.CategoryStat{},
+		GeneratedAt:   time.Now(),
+	}
+
+	queries := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"users", func(ctx context.Context) error { return s.users(ctx, params, stats) }},
+		{"sellers", func(ctx context.Context) error { return s.activeSellers(ctx, params, stats) }},
+		{"orders", func(ctx context.Context) error { return s.orderSeries(ctx, params, stats) }},
+		{"categories", func(ctx context.Context) error { return s.topCategories(ctx, params, stats) }},
+	}
+	// Each query sets only its own fields of stats, so they run unlocked
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i, query := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queryCtx, cancel := context.WithTimeout(ctx, statsQueryTimeout)
+			defer cancel()
+			errs[i] = query.run(queryCtx)
+		}()
+	}
+	wg.Wait()
+
+	var failed int
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		log.Warn().Err(err).Str("query", queries[i].name).Msg("Marketplace stats query failed")
+		stats.Warnings = append(stats.Warnings, fmt.Sprintf("%s stats are unavailable", queries[i].name))
+	}
+	if failed == len(queries) {
+		return nil, fmt.Errorf("failed to get marketplace stats: %w", errors.Join(errs...))
+	}
+	return stats, nil
+}
+
+// users sets the total and new user counts
+func (s *StatsService) users(ctx context.Context, params StatsParams, stats *models.MarketplaceStats) error {
+	var total, created int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE created_at >= $1)
+		FROM users WHERE created_at < $2`, params.From, params.To).Scan(&total, &created)
+	if err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	stats.TotalUsers, stats.NewUsers = &total, &created
+	return nil
+}
+
+// activeSellers sets the number of sellers with an item in a counted order
+func (s *StatsService) activeSellers(ctx context.Context, params StatsParams, stats *models.MarketplaceStats) error {
+	var sellers int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT p.seller_id)
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		JOIN products p ON p.id = oi.product_id
+		WHERE o.created_at >= $1 AND o.created_at < $2 AND `+countedOrders+` AND `+marketItems, params.From, params.To).Scan(&sellers)
+	if err != nil {
+		return fmt.Errorf("failed to count active sellers: %w", err)
+	}
+	stats.ActiveSellers = &sellers
+	return nil
+}
+
+// orderSeries sets the order count and GMV of each period of the window, and
+// their totals
+func (s *StatsService) orderSeries(ctx context.Context, params StatsParams, stats *models.MarketplaceStats) error {
+	// Periods are whole UTC days, weeks (from Monday) or months; the first
+	// starts on or before the window's start and every one is clipped to it
+	rows, err := s.db.QueryContext(ctx, `
+		WITH periods AS (
+			SELECT period FROM generate_series(
+				date_trunc($3, $1::timestamptz, 'UTC'), $2::timestamptz - interval '1 microsecond', ('1 ' || $3)::interval) period
+		)
+		SELECT periods.period, o.currency, COUNT(o.id), COALESCE(SUM(o.total_cents), 0)
+		FROM periods
+		LEFT JOIN orders o ON o.created_at >= GREATEST(periods.period, $1) AND o.created_at < LEAST(periods.period + ('1 ' || $3)::interval, $2)
+			AND `+countedOrders+` AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND `+marketItems+`)
+		GROUP BY periods.period, o.currency
+		ORDER BY periods.period, o.currency`, params.From, params.To, params.Granularity)
+	if err != nil {
+		return fmt.Errorf("failed to get order series: %w", err)
+	}
+	defer rows.Close()
+
+	series := []models.StatsPoint{}
+	var orders int
+	var gmv []money.Money
+	for rows.Next() {
+		var period time.Time
+		var currency sql.NullString
+		var count int
+		var total int64
+		if err := rows.Scan(&period, &currency, &count, &total); err != nil {
+			return fmt.Errorf("failed to scan order series: %w", err)
+		}
+		if n := len(series); n == 0 || !series[n-1].Period.Equal(period) {
+			series = append(series, models.StatsPoint{Period: period.UTC(), GMV: []money.Money{}})
+		}
+		if count == 0 {
+			continue
+		}
+		amount := money.New(total, orderCurrency(currency))
+		point := &series[len(series)-1]
+		point.Orders += count
+		point.GMV = addGMV(point.GMV, amount)
+		orders += count
+		gmv = addGMV(gmv, amount)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get order series: %w", err)
+	}
+	stats.Series, stats.Orders = series, &orders
+	if gmv != nil {
+		stats.GMV = gmv
+	}
+	return nil
+}
+
+// topCategories sets the primary categories with the most counted orders
+func (s *StatsService) topCategories(ctx context.Context, params StatsParams, stats *models.MarketplaceStats) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.id, c.name, o.currency, COUNT(DISTINCT o.id), SUM(oi.total_cents)
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		JOIN products p ON p.id = oi.product_id
+		JOIN categories c ON c.id = p.category_id
+		WHERE o.created_at >= $1 AND o.created_at < $2 AND `+countedOrders+` AND `+marketItems+`
+		GROUP BY c.id, c.name, o.currency`, params.From, params.To)
+	if err != nil {
+		return fmt.Errorf("failed to get top categories: %w", err)
+	}
+	defer rows.Close()
+
+	byID := map[string]*models.CategoryStat{}
+	for rows.Next() {
+		var id, name string
+		var currency sql.NullString
+		var count int
+		var total int64
+		if err := rows.Scan(&id, &name, &currency, &count, &total); err != nil {
+			return fmt.Errorf("failed to scan top categories: %w", err)
+		}
+		stat, ok := byID[id]
+		if !ok {
+			stat = &models.CategoryStat{CategoryID: id, Name: name, GMV: []money.Money{}}
+			byID[id] = stat
+		}
+		// An order is in one currency, so its orders add up across currencies
+		stat.Orders += count
+		stat.GMV = addGMV(stat.GMV, money.New(total, orderCurrency(currency)))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get top categories: %w", err)
+	}
+
+	categories := make([]models.CategoryStat, 0, len(byID))
+	for _, stat := range byID {
+		categories = append(categories, *stat)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Orders != categories[j].Orders {
+			return categories[i].Orders > categories[j].Orders
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	stats.TopCategories = categories[:min(len(categories), topCategoriesLimit)]
+	return nil
+}
+
+// orderCurrency returns an order's currency, USD for orders without one
+func orderCurrency(currency sql.NullString) string {
+	if currency.Valid && currency.String != "" {
+		return currency.String
+	}
+	return "USD"
+}
+
+// addGMV adds amount to the per-currency totals, keeping them sorted by
+// currency
+func addGMV(totals []money.Money, amount money.Money) []money.Money {
+	for i, total := range totals {
+		if total.Currency == amount.Currency {
+			totals[i].Amount += amount.Amount
+			return totals
+		}
+	}
+	totals = append(totals, amount)
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Currency < totals[j].Currency })
+	return totals
+}
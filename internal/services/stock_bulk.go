@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/jobs"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// JobStockAdjustment applies a stock adjustment queued as a bulk job
+const JobStockAdjustment = "stock.adjustment_queued"
+
+var ErrBulkJobNotFound = errors.New("bulk job not found")
+
+// bulkJobEvent is the payload of JobStockAdjustment
+type bulkJobEvent struct {
+	BulkJobID string `json:"bulkJobId"`
+}
+
+// QueueStockAdjustment records a stock adjustment too large to apply during
+// the request and queues it through the outbox, returning the bulk job to
+// follow it by
+func (s *InventoryService) QueueStockAdjustment(ctx context.Context, sellerID string, input models.StockAdjustmentInput) (*models.BulkJob, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal stock adjustment: %w", err)
+	}
+
+	job := &models.BulkJob{Kind: models.BulkStockAdjustment, Status: models.BulkJobQueued, Items: len(input.Items)}
+	err = s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO bulk_jobs (owner_id, kind, items, input) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+			sellerID, job.Kind, job.Items, string(data)).Scan(&job.ID, &job.CreatedAt); err != nil {
+			return fmt.Errorf("failed to queue stock adjustment: %w", err)
+		}
+		return WriteOutbox(ctx, tx, JobStockAdjustment, job.ID, bulkJobEvent{BulkJobID: job.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ApplyQueuedStockAdjustment is the job handler for JobStockAdjustment. The
+// adjustment is applied in the transaction that finishes its bulk job, so a
+// repeated job applies it once. Items failing validation fail the job with
+// their errors; other errors leave it queued for the job to be retried.
+func (s *InventoryService) ApplyQueuedStockAdjustment(ctx context.Context, job *jobs.Job) error {
+	var event bulkJobEvent
+	if err := json.Unmarshal(job.Payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal bulk job event: %w", err)
+	}
+
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		var sellerID, status string
+		var raw []byte
+		err := tx.QueryRowContext(ctx, `
+			SELECT owner_id, status, input FROM bulk_jobs WHERE id = $1 FOR UPDATE`,
+			event.BulkJobID).Scan(&sellerID, &status, &raw)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get bulk job: %w", err)
+		}
+		if status != models.BulkJobQueued {
+			return nil
+		}
+
+		var input models.StockAdjustmentInput
+		if err := json.Unmarshal(raw, &input); err != nil {
+			return fmt.Errorf("failed to unmarshal stock adjustment: %w", err)
+		}
+		adjustment, ids, err := s.adjustStock(ctx, tx, sellerID, input)
+		var verr *validators.ValidationError
+		var result, errs sql.NullString
+		switch {
+		case errors.As(err, &verr):
+			status = models.BulkJobFailed
+			data, err := json.Marshal(verr.Fields)
+			if err != nil {
+				return fmt.Errorf("failed to marshal bulk job errors: %w", err)
+			}
+			errs = sql.NullString{String: string(data), Valid: true}
+		case err != nil:
+			return err
+		default:
+			status = models.BulkJobSucceeded
+			data, err := json.Marshal(adjustment)
+			if err != nil {
+				return fmt.Errorf("failed to marshal bulk job result: %w", err)
+			}
+			result = sql.NullString{String: string(data), Valid: true}
+			categoryIDs = ids
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE bulk_jobs SET status = $2, result = $3, errors = $4, finished_at = NOW() WHERE id = $1`,
+			event.BulkJobID, status, result, errs); err != nil {
+			return fmt.Errorf("failed to finish bulk job: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	invalidateProductListings(ctx, s.cache, categoryIDs...)
+	return nil
+}
+
+// GetBulkJob returns one of the owner's bulk jobs
+func (s *InventoryService) GetBulkJob(ctx context.Context, id, ownerID string) (*models.BulkJob, error) {
+	var job models.BulkJob
+	var result, errs []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, kind, status, items, result, errors, created_at, finished_at
+		FROM bulk_jobs WHERE id = $1 AND owner_id = $2`, id, ownerID).Scan(
+		&job.ID, &job.Kind, &job.Status, &job.Items, &result, &errs, &job.CreatedAt, &job.FinishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBulkJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bulk job: %w", err)
+	}
+	job.Result, job.Errors = result, errs
+	return &job, nil
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ErrDeliveryReferenceReused is returned when a delivery is posted under the
+// reference of an earlier delivery with different items
+var ErrDeliveryReferenceReused = errors.New("delivery reference already used for a different delivery")
+
+// ReceiveDelivery restocks a seller's products from a supplier delivery in a
+// single transaction, recording it in the stock ledger and publishing
+// back-in-stock events. Each reference restocks once: posting a delivery
+// again returns the delivery first recorded, with Replayed set, and posting
+// different items under a recorded reference fails with
+// ErrDeliveryReferenceReused. Items are checked as AdjustStock checks them.
+func (s *InventoryService) ReceiveDelivery(ctx context.Context, sellerID string, input models.StockDeliveryInput) (*models.StockDelivery, error) {
+	delivery := &models.StockDelivery{SellerID: sellerID, Reference: input.Reference, Items: make([]models.StockDeliveryLine, len(input.Items))}
+	var categoryIDs []string
+
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// A concurrent post of the same reference waits here until the
+		// first commits, then finds it
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO stock_deliveries (seller_id, reference) VALUES ($1, $2)
+			ON CONFLICT (seller_id, reference) DO NOTHING
+			RETURNING id, created_at`, sellerID, input.Reference).Scan(&delivery.ID, &delivery.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			recorded, err := getDelivery(ctx, tx, sellerID, input.Reference)
+			if err != nil {
+				return err
+			}
+			if !sameDelivery(recorded, input) {
+				return ErrDeliveryReferenceReused
+			}
+			recorded.Replayed = true
+			delivery = recorded
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create delivery: %w", err)
+		}
+
+		ids := make([]string, len(input.Items))
+		for i, item := range input.Items {
+			ids[i] = item.ProductID
+		}
+		products, err := lockStock(ctx, tx, ids)
+		if err != nil {
+			return err
+		}
+
+		var invalid []validators.FieldError
+		for i, item := range input.Items {
+			p, ok := products[item.ProductID]
+			switch {
+			case !ok || p.deleted:
+				invalid = append(invalid, validators.FieldError{
+					Field: fmt.Sprintf("items[%d].productId", i), Code: "not_found", Message: "product not found",
+				})
+			case p.sellerID != sellerID:
+				invalid = append(invalid, validators.FieldError{
+					Field: fmt.Sprintf("items[%d].productId", i), Code: "forbidden", Message: "product belongs to another seller",
+				})
+			case p.isBundle:
+				invalid = append(invalid, validators.FieldError{
+					Field: fmt.Sprintf("items[%d].productId", i), Code: "bundle", Message: "bundle stock follows its components",
+				})
+			}
+		}
+		if len(invalid) > 0 {
+			return &validators.ValidationError{Fields: invalid}
+		}
+
+		for i, item := range input.Items {
+			p := products[item.ProductID]
+			line := models.StockDeliveryLine{
+				ProductID:        item.ProductID,
+				QuantityOrdered:  item.QuantityOrdered,
+				QuantityReceived: item.QuantityReceived,
+				PreviousQuantity: p.quantity,
+				StockQuantity:    p.quantity,
+			}
+			if item.QuantityOrdered != nil {
+				line.Shortfall = max(*item.QuantityOrdered-item.QuantityReceived, 0)
+			}
+			if item.QuantityReceived > 0 {
+				balance, err := changeStock(ctx, tx, stockChange{
+					productID:   item.ProductID,
+					delta:       item.QuantityReceived,
+					reason:      StockReasonDelivery,
+					referenceID: delivery.ID,
+					actorID:     sellerID,
+				})
+				if err != nil {
+					return err
+				}
+				line.StockQuantity = balance
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO stock_delivery_items (delivery_id, product_id, position, quantity_ordered, quantity_received,
+					previous_quantity, stock_quantity)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				delivery.ID, item.ProductID, i, item.QuantityOrdered, item.QuantityReceived,
+				line.PreviousQuantity, line.StockQuantity); err != nil {
+				return fmt.Errorf("failed to record delivery: %w", err)
+			}
+			if err := s.writeStockEvents(ctx, tx, p.sellerID, p.title, models.StockLevel{
+				ProductID:        item.ProductID,
+				Delta:            item.QuantityReceived,
+				PreviousQuantity: line.PreviousQuantity,
+				StockQuantity:    line.StockQuantity,
+			}); err != nil {
+				return err
+			}
+			delivery.Items[i] = line
+			categoryIDs = append(categoryIDs, p.categoryIDs...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !delivery.Replayed {
+		invalidateProductListings(ctx, s.cache, categoryIDs...)
+	}
+	return delivery, nil
+}
+
+// getDelivery reads a recorded delivery by its reference
+func getDelivery(ctx context.Context, tx *sql.Tx, sellerID, reference string) (*models.StockDelivery, error) {
+	delivery := &models.StockDelivery{SellerID: sellerID, Reference: reference, Items: []models.StockDeliveryLine{}}
+	if err := tx.QueryRowContext(ctx, `
+		SELECT id, created_at FROM stock_deliveries WHERE seller_id = $1 AND reference = $2`,
+		sellerID, reference).Scan(&delivery.ID, &delivery.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, quantity_ordered, quantity_received, previous_quantity, stock_quantity
+		FROM stock_delivery_items
+		WHERE delivery_id = $1
+		ORDER BY position`, delivery.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line models.StockDeliveryLine
+		var ordered sql.NullInt64
+		if err := rows.Scan(&line.ProductID, &ordered, &line.QuantityReceived, &line.PreviousQuantity, &line.StockQuantity); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery item: %w", err)
+		}
+		if ordered.Valid {
+			n := int(ordered.Int64)
+			line.QuantityOrdered = &n
+			line.Shortfall = max(n-line.QuantityReceived, 0)
+		}
+		delivery.Items = append(delivery.Items, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get delivery items: %w", err)
+	}
+	return delivery, nil
+}
+
+// sameDelivery reports whether input posts the items of recorded again
+func sameDelivery(recorded *models.StockDelivery, input models.StockDeliveryInput) bool {
+	if len(recorded.Items) != len(input.Items) {
+		return false
+	}
+	for i, item := range input.Items {
+		line := recorded.Items[i]
+		if line.ProductID != item.ProductID || line.QuantityReceived != item.QuantityReceived ||
+			(line.QuantityOrdered == nil) != (item.QuantityOrdered == nil) ||
+			(item.QuantityOrdered != nil && *line.QuantityOrdered != *item.QuantityOrdered) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Stock movement reasons
+const (
+	StockReasonInitial      = "initial"
+	StockReasonAdjustment   = "adjustment"
+	StockReasonCorrection   = "correction"
+	StockReasonOrder        = "order"
+	StockReasonCancellation = "cancellation"
+	StockReasonReservation  = "reservation"
+	StockReasonRelease      = "release"
+	StockReasonDelivery     = "delivery"
+	StockReasonMerge        = "merge"
+)
+
+// stockChange describes a change to a product's stock for the ledger
+type stockChange struct {
+	productID   string
+	delta       int
+	reason      string
+	referenceID string // optional
+	actorID     string // optional
+}
+
+// changeStock applies a stock delta and records it in the ledger within tx,
+// returning the new balance. Every stock change must go through changeStock
+// or recordStockMovement so stock always equals the sum of its movements.
+func changeStock(ctx context.Context, tx *sql.Tx, change stockChange) (int, error) {
+	var balance int
+	err := tx.QueryRowContext(ctx, `
+		UPDATE products SET stock_quantity = COALESCE(stock_quantity, 0) + $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING stock_quantity`, change.productID, change.delta).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update stock: %w", err)
+	}
+	return balance, recordStockMovement(ctx, tx, change, balance)
+}
+
+// recordStockMovement records a stock change that was already applied in tx
+func recordStockMovement(ctx context.Context, tx *sql.Tx, change stockChange, balance int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO stock_movements (product_id, delta, reason, reference_id, actor_id, balance)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, '')::uuid, $6)`,
+		change.productID, change.delta, change.reason, change.referenceID, change.actorID, balance)
+	if err != nil {
+		return fmt.Errorf("failed to record stock movement: %w", err)
+	}
+	return nil
+}
+
+// StockHistory returns a page of a product's stock ledger, newest first.
+// Only the listing seller may view it.
+func (s *InventoryService) StockHistory(ctx context.Context, productID, sellerID string, limit, offset int) (*models.StockMovementPage, error) {
+	var owner string
+	err := s.db.QueryRowContext(ctx, `SELECT seller_id FROM products WHERE id = $1 AND deleted_at IS NULL`, productID).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if owner != sellerID {
+		return nil, ErrProductForbidden
+	}
+
+	page := &models.StockMovementPage{Movements: []models.StockMovement{}}
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM stock_movements WHERE product_id = $1`, productID).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count stock movements: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, product_id, delta, reason, reference_id, actor_id, balance, created_at
+		FROM stock_movements
+		WHERE product_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3`, productID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stock movements: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.StockMovement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.Delta, &m.Reason, &m.ReferenceID, &m.ActorID, &m.Balance, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan stock movement: %w", err)
+		}
+		page.Movements = append(page.Movements, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list stock movements: %w", err)
+	}
+	return page, nil
+}
+
+// CheckStockConsistency compares each product's stock with the sum of its
+// ledger movements, logging every product that disagrees. It returns the
+// number of inconsistent products.
+func (s *InventoryService) CheckStockConsistency(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, COALESCE(p.stock_quantity, 0), COALESCE(m.total, 0)
+		FROM products p
+		LEFT JOIN (
+			SELECT product_id, SUM(delta) AS total FROM stock_movements GROUP BY product_id
+		) m ON m.product_id = p.id
+		WHERE COALESCE(p.stock_quantity, 0) <> COALESCE(m.total, 0)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check stock consistency: %w", err)
+	}
+	defer rows.Close()
+
+	inconsistent := 0
+	for rows.Next() {
+		var productID string
+		var stock, ledger int
+		if err := rows.Scan(&productID, &stock, &ledger); err != nil {
+			return inconsistent, fmt.Errorf("failed to scan stock consistency: %w", err)
+		}
+		log.Error().Str("product_id", productID).Int("stock_quantity", stock).Int("ledger_total", ledger).
+			Msg("Product stock does not match stock ledger")
+		inconsistent++
+	}
+	if err := rows.Err(); err != nil {
+		return inconsistent, fmt.Errorf("failed to check stock consistency: %w", err)
+	}
+	return inconsistent, nil
+}
+
+// RunConsistencyChecks runs CheckStockConsistency as a scheduled task
+func (s *InventoryService) RunConsistencyChecks(ctx context.Context) error {
+	n, err := s.CheckStockConsistency(ctx)
+	if err != nil {
+		return fmt.Errorf("stock consistency check failed: %w", err)
+	}
+	if n > 0 {
+		log.Warn().Int("products", n).Msg("Stock consistency check found discrepancies")
+	}
+	return nil
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Sub-orders
+//
+// Checkout splits an order into one sub-order per seller. The buyer pays the
+// parent order once, and moving it to paid pays every sub-order. After that
+// each seller ships and delivers their sub-order on their own, and the
+// parent follows: it is shipped or delivered once all its sub-orders not
+// cancelled are, and cancelled once all are. A sub-order can be cancelled
+// or refunded alone; cancelling a paid sub-order refunds what is left of it.
+// An order's add-ons are refunded apart from its sub-orders, and once every
+// sub-order is cancelled so are they. Refunds are published as EventOrderRefunded for the payment provider to
+// settle. Orders placed before the split have no sub-orders and are managed
+// as a whole.
+
+// EventOrderRefunded is published through the outbox when a sub-order or an
+// order's add-on is refunded
+const EventOrderRefunded = "order.refunded"
+
+var (
+	ErrSubOrderNotFound = errors.New("sub-order not found")
+	ErrOrderNotPaid     = errors.New("order has not been paid")
+)
+
+// orderStatusRank orders the statuses an order goes through
+var orderStatusRank = map[string]int{"pending": 0, "paid": 1, "shipped": 2, "delivered": 3}
+
+// OrderRefundedEvent is the payload of EventOrderRefunded
+type OrderRefundedEvent struct {
+	OrderID    string      `json:"orderId"`
+	SubOrderID string      `json:"subOrderId,omitempty"` // set when a sub-order was refunded
+	AddOnID    string      `json:"addOnId,omitempty"`    // the order add-on, when one was refunded
+	BuyerID    string      `json:"buyerId"`
+	Amount     money.Money `json:"amount"`
+	RefundedBy string      `json:"refundedBy"`
+	RefundedAt time.Time   `json:"refundedAt"`
+}
+
+// lockedOrder is an order locked for update
+type lockedOrder struct {
+	id             string
+	number         int64
+	reference      string
+	status         string
+	paymentStatus  string
+	buyerID        string
+	currency       string
+	recipientEmail string // gift recipient to notify of status changes
+}
+
+// paid reports whether the order's payment has been captured
+func (o lockedOrder) paid() bool {
+	return o.paymentStatus == "paid" || o.paymentStatus == "partially_refunded"
+}
+
+// lockedSubOrder is a sub-order locked for update
+type lockedSubOrder struct {
+	id       string
+	sellerID string
+	status   string
+	total    int64
+	refunded int64
+}
+
+// UpdateSubOrderStatus moves sub-order subOrderID of order orderID to
+// status, publishing EventOrderStatusChanged for it and for the order if
+// that follows. Staff and the sub-order's seller may advance it; the buyer
+// may only cancel it. Sub-orders are paid with their order, never alone.
+// Cancelling returns the sub-order's stock and refunds it if it was paid.
+func (s *OrderService) UpdateSubOrderStatus(ctx context.Context, orderID, subOrderID, userID string, isStaff bool, status string) (*models.Order, error) {
+	if err := s.authorizeView(ctx, orderID, userID, isStaff); err != nil {
+		return nil, err
+	}
+
+	var categoryIDs []string
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		sub, err := lockSubOrder(ctx, tx, orderID, subOrderID)
+		if err != nil {
+			return err
+		}
+
+		if !isStaff && userID != sub.sellerID && !(userID == order.buyerID && status == "cancelled") {
+			return ErrOrderForbidden
+		}
+		if status == "paid" || !slices.Contains(orderTransitions[sub.status], status) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidOrderTransition, sub.status, status)
+		}
+
+		if status == "cancelled" {
+			if categoryIDs, err = s.cancelSubOrder(ctx, tx, order, sub, userID); err != nil {
+				return err
+			}
+		} else if err := setSubOrderStatus(ctx, tx, order, sub, status, userID); err != nil {
+			return err
+		}
+		return rollUpOrderStatus(ctx, tx, order, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.inventory.invalidateListings(ctx, categoryIDs)
+	return s.Get(ctx, orderID, userID, isStaff)
+}
+
+// RefundSubOrder refunds input.Amount of sub-order subOrderID of paid order
+// orderID, or all of it not yet refunded. Only staff may refund.
+func (s *OrderService) RefundSubOrder(ctx context.Context, orderID, subOrderID, userID string, isStaff bool, input models.RefundInput) (*models.Order, error) {
+	if !isStaff {
+		return nil, ErrOrderForbidden
+	}
+
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		order, err := lockOrder(ctx, tx, orderID)
+		if err != nil {
+			return err
+		}
+		if !order.paid() {
+			return ErrOrderNotPaid
+		}
+		sub, err := lockSubOrder(ctx, tx, orderID, subOrderID)
+		if err != nil {
+			return err
+		}
+
+		remaining := money.New(sub.total-sub.refunded, order.currency)
+		amount := remaining
+		if input.Amount != nil {
+			amount = *input.Amount
+		}
+		if err := checkRefundAmount(amount, remaining, order.currency); err != nil {
+			return err
+		}
+		return refundSubOrder(ctx, tx, order, sub, amount, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, orderID, userID, isStaff)
+}
+
+// checkRefundAmount checks that amount is in the order's currency and at
+// most the remaining not yet refunded
+func checkRefundAmount(amount, remaining money.Money, currency string) error {
+	switch {
+	case amount.Currency != currency:
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "amount", Code: "currency", Param: currency,
+			Message: fmt.Sprintf("amount must be in the order's currency, %s", currency),
+		}}}
+	case amount.Amount <= 0 || amount.Amount > remaining.Amount:
+		return &validators.ValidationError{Fields: []validators.FieldError{{
+			Field: "amount", Code: "refundable", Param: remaining.Decimal(),
+			Message: fmt.Sprintf("amount must be greater than 0 and at most the %s not yet refunded", remaining.Decimal()),
+		}}}
+	}
+	return nil
+}
+
+// listSubOrders returns the sub-orders of order orderID, each with the IDs
+// of its items
+func (s *OrderService) listSubOrders(ctx context.Context, orderID, currency string) ([]models.SubOrder, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT so.id, so.seller_id, so.status, so.subtotal_cents, so.discount_cents, so.tax_cents, so.shipping_cents, so.total_cents,
+			so.refunded_cents, so.commission_cents, so.payout_status, so.created_at, so.updated_at,
+			COALESCE(array_agg(oi.id ORDER BY oi.id) FILTER (WHERE oi.id IS NOT NULL), '{}')
+		FROM sub_orders so
+		LEFT JOIN order_items oi ON oi.sub_order_id = so.id
+		WHERE so.order_id = $1
+		GROUP BY so.id
+		ORDER BY so.created_at, so.id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sub-orders: %w", err)
+	}
+	defer rows.Close()
+
+	var subOrders []models.SubOrder
+	for rows.Next() {
+		var sub models.SubOrder
+		var commission int64
+		sub.Subtotal, sub.Discount, sub.Tax, sub.Total = money.Zero(currency), money.Zero(currency), money.Zero(currency), money.Zero(currency)
+		sub.Shipping, sub.Refunded = money.Zero(currency), money.Zero(currency)
+		if err := rows.Scan(&sub.ID, &sub.SellerID, &sub.Status,
+			&sub.Subtotal.Amount, &sub.Discount.Amount, &sub.Tax.Amount, &sub.Shipping.Amount, &sub.Total.Amount,
+			&sub.Refunded.Amount, &commission, &sub.PayoutStatus, &sub.CreatedAt, &sub.UpdatedAt, pq.Array(&sub.ItemIDs)); err != nil {
+			return nil, fmt.Errorf("failed to scan sub-order: %w", err)
+		}
+		sub.Commission = money.New(netCommission(commission, sub.Total.Amount, sub.Refunded.Amount), currency)
+		sub.Payout = money.New(sub.Total.Amount-sub.Refunded.Amount-sub.Commission.Amount, currency)
+		subOrders = append(subOrders, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get sub-orders: %w", err)
+	}
+	return subOrders, nil
+}
+
+// cascadeOrderStatus moves the sub-orders of order to the status the order
+// just moved to. Cancelling cancels every sub-order not cancelled yet, or
+// fails if one has shipped, and returns the categories of the products
+// whose stock came back.
+func (s *OrderService) cascadeOrderStatus(ctx context.Context, tx *sql.Tx, order lockedOrder, status, actorID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, seller_id, status, total_cents, refunded_cents
+		FROM sub_orders WHERE order_id = $1 AND status <> 'cancelled'
+		ORDER BY id FOR UPDATE`, order.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sub-orders: %w", err)
+	}
+	var subs []lockedSubOrder
+	for rows.Next() {
+		var sub lockedSubOrder
+		if err := rows.Scan(&sub.id, &sub.sellerID, &sub.status, &sub.total, &sub.refunded); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan sub-order: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get sub-orders: %w", err)
+	}
+
+	var categoryIDs []string
+	for _, sub := range subs {
+		switch {
+		case status == "cancelled":
+			if !slices.Contains(orderTransitions[sub.status], status) {
+				return nil, fmt.Errorf("%w: sub-order %s is %s", ErrInvalidOrderTransition, sub.id, sub.status)
+			}
+			released, err := s.cancelSubOrder(ctx, tx, order, sub, actorID)
+			if err != nil {
+				return nil, err
+			}
+			categoryIDs = append(categoryIDs, released...)
+		case slices.Contains(orderTransitions[sub.status], status):
+			if err := setSubOrderStatus(ctx, tx, order, sub, status, actorID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return categoryIDs, nil
+}
+
+// cancelSubOrder cancels sub, returning its stock and refunding what is left
+// of it if the order was paid. It returns the categories of the products
+// whose stock came back.
+func (s *OrderService) cancelSubOrder(ctx context.Context, tx *sql.Tx, order lockedOrder, sub lockedSubOrder, actorID string) ([]string, error) {
+	if err := setSubOrderStatus(ctx, tx, order, sub, "cancelled", actorID); err != nil {
+		return nil, err
+	}
+	categoryIDs, err := s.inventory.releaseOrderStock(ctx, tx, sub.id, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if order.paid() && sub.refunded < sub.total {
+		if err := refundSubOrder(ctx, tx, order, sub, money.New(sub.total-sub.refunded, order.currency), actorID); err != nil {
+			return nil, err
+		}
+	}
+	return categoryIDs, nil
+}
+
+// setSubOrderStatus moves sub to status, publishing EventOrderStatusChanged
+// for it. Delivered sub-orders become due for payout, or held while their
+// seller isn't verified, and cancelled ones are never paid out.
+func setSubOrderStatus(ctx context.Context, tx *sql.Tx, order lockedOrder, sub lockedSubOrder, status, actorID string) error {
+	payout := models.PayoutDue
+	if status == "delivered" {
+		// Locked so a concurrent verification can't miss the payout it holds
+		var sellerStatus string
+		if err := tx.QueryRowContext(ctx, `
+			SELECT seller_status FROM users WHERE id = $1 FOR SHARE`, sub.sellerID).Scan(&sellerStatus); err != nil {
+			return fmt.Errorf("failed to get seller status: %w", err)
+		}
+		if sellerStatus != models.SellerVerified {
+			payout = models.PayoutHeld
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sub_orders SET status = $2,
+			payout_status = CASE $2 WHEN 'delivered' THEN $3 WHEN 'cancelled' THEN 'cancelled' ELSE payout_status END,
+			updated_at = NOW()
+		WHERE id = $1`, sub.id, status, payout); err != nil {
+		return fmt.Errorf("failed to update sub-order status: %w", err)
+	}
+	return WriteOutbox(ctx, tx, EventOrderStatusChanged, order.id, OrderStatusChangedEvent{
+		OrderID:        order.id,
+		OrderReference: order.reference,
+		SubOrderID:     sub.id,
+		BuyerID:        order.buyerID,
+		FromStatus:     sub.status,
+		ToStatus:       status,
+		ChangedBy:      actorID,
+		ChangedAt:      time.Now(),
+		RecipientEmail: order.recipientEmail,
+	})
+}
+
+// refundSubOrder records a refund of amount against sub and its order,
+// publishing EventOrderRefunded. A sub-order refunded in full is never paid
+// out.
+func refundSubOrder(ctx context.Context, tx *sql.Tx, order lockedOrder, sub lockedSubOrder, amount money.Money, actorID string) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sub_orders SET refunded_cents = refunded_cents + $2,
+			payout_status = CASE WHEN refunded_cents + $2 = total_cents THEN 'cancelled' ELSE payout_status END,
+			updated_at = NOW()
+		WHERE id = $1`, sub.id, amount.Amount); err != nil {
+		return fmt.Errorf("failed to refund sub-order: %w", err)
+	}
+	paymentStatus, err := updateRefundStatus(ctx, tx, order.id)
+	if err != nil {
+		return err
+	}
+	if err := appendOrderEvent(ctx, tx, order.id, models.OrderEventRefunded, actorID, orderRefundEvent{
+		SubOrderID: sub.id, Amount: amount, PaymentStatus: paymentStatus,
+	}); err != nil {
+		return err
+	}
+	return WriteOutbox(ctx, tx, EventOrderRefunded, order.id, OrderRefundedEvent{
+		OrderID:    order.id,
+		SubOrderID: sub.id,
+		BuyerID:    order.buyerID,
+		Amount:     amount,
+		RefundedBy: actorID,
+		RefundedAt: time.Now(),
+	})
+}
+
+// updateRefundStatus marks order orderID refunded once all its sub-orders
+// and add-ons are, and partially refunded until then, returning its payment
+// status
+func updateRefundStatus(ctx context.Context, tx *sql.Tx, orderID string) (string, error) {
+	var paymentStatus string
+	if err := tx.QueryRowContext(ctx, `
+		UPDATE orders SET payment_status = CASE
+			WHEN NOT EXISTS (SELECT 1 FROM sub_orders WHERE order_id = $1 AND refunded_cents < total_cents)
+				AND NOT EXISTS (SELECT 1 FROM order_addons WHERE order_id = $1 AND refunded_cents < total_cents) THEN 'refunded'
+			ELSE 'partially_refunded' END,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING payment_status`, orderID).Scan(&paymentStatus); err != nil {
+		return "", fmt.Errorf("failed to update order payment status: %w", err)
+	}
+	return paymentStatus, nil
+}
+
+// rollUpOrderStatus moves order to the status its sub-orders have all
+// reached, publishing EventOrderStatusChanged when it changes: cancelled
+// when every sub-order is, otherwise the least advanced status of those not
+// cancelled. A paid order cancelled this way refunds its add-ons. Orders
+// without sub-orders are left as they are.
+func rollUpOrderStatus(ctx context.Context, tx *sql.Tx, order lockedOrder, actorID string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT status FROM sub_orders WHERE order_id = $1`, order.id)
+	if err != nil {
+		return fmt.Errorf("failed to get sub-orders: %w", err)
+	}
+	var statuses []string
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan sub-order: %w", err)
+		}
+		statuses = append(statuses, status)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get sub-orders: %w", err)
+	}
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	status := "cancelled"
+	for _, s := range statuses {
+		if s != "cancelled" && (status == "cancelled" || orderStatusRank[s] < orderStatusRank[status]) {
+			status = s
+		}
+	}
+	if status == order.status {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = $2, updated_at = NOW() WHERE id = $1`, order.id, status); err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+	if err := appendOrderStatusEvent(ctx, tx, order, status, actorID); err != nil {
+		return err
+	}
+	if status == "cancelled" && order.paid() {
+		if err := refundAddOns(ctx, tx, order, actorID); err != nil {
+			return err
+		}
+	}
+	return WriteOutbox(ctx, tx, EventOrderStatusChanged, order.id, OrderStatusChangedEvent{
+		OrderID:        order.id,
+		OrderReference: order.reference,
+		BuyerID:        order.buyerID,
+		FromStatus:     order.status,
+		ToStatus:       status,
+		ChangedBy:      actorID,
+		ChangedAt:      time.Now(),
+		RecipientEmail: order.recipientEmail,
+	})
+}
+
+// lockOrder locks order id for update within tx
+func lockOrder(ctx context.Context, tx *sql.Tx, id string) (lockedOrder, error) {
+	order := lockedOrder{id: id}
+	err := tx.QueryRowContext(ctx, `
+		SELECT order_number, reference, COALESCE(status, 'pending'), COALESCE(payment_status, 'pending'), buyer_id, COALESCE(currency, 'USD'),
+			CASE WHEN gift_notify_recipient THEN gift_recipient_email ELSE '' END
+		FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(
+		&order.number, &order.reference, &order.status, &order.paymentStatus, &order.buyerID, &order.currency, &order.recipientEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lockedOrder{}, ErrOrderNotFound
+	}
+	if err != nil {
+		return lockedOrder{}, fmt.Errorf("failed to get order: %w", err)
+	}
+	return order, nil
+}
+
+// lockSubOrder locks sub-order id of order orderID for update within tx
+func lockSubOrder(ctx context.Context, tx *sql.Tx, orderID, id string) (lockedSubOrder, error) {
+	sub := lockedSubOrder{id: id}
+	err := tx.QueryRowContext(ctx, `
+		SELECT seller_id, status, total_cents, refunded_cents
+		FROM sub_orders WHERE id = $1 AND order_id = $2 FOR UPDATE`, id, orderID).Scan(
+		&sub.sellerID, &sub.status, &sub.total, &sub.refunded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lockedSubOrder{}, ErrSubOrderNotFound
+	}
+	if err != nil {
+		return lockedSubOrder{}, fmt.Errorf("failed to get sub-order: %w", err)
+	}
+	return sub, nil
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+// Sales tax
+//
+// An order is taxed by every configured jurisdiction its shipping address is
+// in, such as a state and a city within it, each at its own rate on the
+// goods after discounts; shipping and add-ons aren't taxed. Each seller's
+// tax in each jurisdiction is worked out on that seller's lines and rounded
+// to the minor unit, so sub-orders carry their own tax and the order's is
+// their sum. A buyer with a tax exemption pays none, and neither do the
+// lines of an exempt product, or of a product whose category is exempt.
+// Quotes itemize each jurisdiction's tax and each exemption, as a zero
+// charge with its reason. Quote and Create tax the same priced cart for the
+// same address the same way, so checkout charges the tax the quote showed.
+
+// TaxService works out the sales tax of orders
+type TaxService struct {
+	jurisdictions []taxJurisdiction
+}
+
+// taxJurisdiction is a configured jurisdiction with its rate parsed
+type taxJurisdiction struct {
+	code    string
+	name    string
+	country string
+	state   string
+	rate    string // percent, as configured
+	ppm     int64  // the rate in millionths
+}
+
+// NewTaxService creates a new tax service, failing on rates that don't parse
+func NewTaxService(cfg config.TaxConfig) (*TaxService, error) {
+	s := &TaxService{jurisdictions: make([]taxJurisdiction, len(cfg.Jurisdictions))}
+	for i, j := range cfg.Jurisdictions {
+		ppm, err := parseTaxRate(j.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("tax.jurisdictions: %s rate: %w", j.Code, err)
+		}
+		s.jurisdictions[i] = taxJurisdiction{
+			code: j.Code, name: j.Name, country: strings.ToUpper(j.Country), state: strings.TrimSpace(j.State),
+			rate: strings.TrimSpace(j.Rate), ppm: ppm,
+		}
+	}
+	return s, nil
+}
+
+// parseTaxRate parses a percentage from 0 to 100 with up to four decimals,
+// such as 7.25, into millionths
+func parseTaxRate(rate string) (int64, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(rate), ".")
+	if whole == "" || len(frac) > 4 {
+		return 0, fmt.Errorf("%q is not a percentage with up to 4 decimals", rate)
+	}
+	ppm, err := strconv.ParseUint(whole+frac+strings.Repeat("0", 4-len(frac)), 10, 32)
+	if err != nil || ppm > 1000000 {
+		return 0, fmt.Errorf("%q is not a percentage from 0 to 100", rate)
+	}
+	return int64(ppm), nil
+}
+
+// taxAddress is the part of a shipping address that decides its taxes
+type taxAddress struct {
+	country string
+	state   string
+}
+
+// taxAddressOf returns the country and state of a shipping address, empty
+// when it has none
+func taxAddressOf(address json.RawMessage) taxAddress {
+	var a struct {
+		Country string `json:"country"`
+		State   string `json:"state"`
+	}
+	if len(address) == 0 || json.Unmarshal(address, &a) != nil {
+		return taxAddress{}
+	}
+	return taxAddress{country: strings.ToUpper(strings.TrimSpace(a.Country)), state: strings.TrimSpace(a.State)}
+}
+
+// jurisdictionsOf returns the jurisdictions address is in, in configured
+// order
+func (s *TaxService) jurisdictionsOf(address taxAddress) []taxJurisdiction {
+	var in []taxJurisdiction
+	for _, j := range s.jurisdictions {
+		if j.country == address.country && (j.state == "" || strings.EqualFold(j.state, address.state)) {
+			in = append(in, j)
+		}
+	}
+	return in
+}
+
+// taxExemptions are why a buyer and the products of a cart are exempt from
+// tax
+type taxExemptions struct {
+	buyer    string            // empty when the buyer pays tax
+	products map[string]string // by product ID, exempt products only
+}
+
+// loadTaxExemptions reads the exemptions of buyerID and of the products of
+// cart within tx. A product without an exemption of its own takes its
+// category's.
+func loadTaxExemptions(ctx context.Context, tx *sql.Tx, buyerID string, cart *pricedCart) (taxExemptions, error) {
+	e := taxExemptions{products: make(map[string]string)}
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE(tax_exempt_reason, '') FROM users WHERE id = $1`, buyerID).Scan(&e.buyer)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return e, fmt.Errorf("failed to get buyer tax exemption: %w", err)
+	}
+
+	productIDs := make([]string, len(cart.lines))
+	for i, line := range cart.lines {
+		productIDs[i] = line.productID
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.id, r.reason
+		FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		CROSS JOIN LATERAL (SELECT COALESCE(p.tax_exempt_reason, c.tax_exempt_reason) AS reason) r
+		WHERE p.id = ANY($1) AND r.reason IS NOT NULL`, pq.Array(productIDs))
+	if err != nil {
+		return e, fmt.Errorf("failed to get product tax exemptions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, reason string
+		if err := rows.Scan(&id, &reason); err != nil {
+			return e, fmt.Errorf("failed to scan product tax exemption: %w", err)
+		}
+		e.products[id] = reason
+	}
+	if err := rows.Err(); err != nil {
+		return e, fmt.Errorf("failed to get product tax exemptions: %w", err)
+	}
+	return e, nil
+}
+
+// tax adds the sales tax of the priced cart in jurisdictions to its totals
+// and returns it itemized: each jurisdiction's tax, then a zero charge for
+// each exempt line. When the buyer is exempt each jurisdiction's charge is
+// zero with the buyer's reason instead. Outside every jurisdiction there is
+// neither tax nor charge.
+func (s *TaxService) tax(cart *pricedCart, totals *cartTotals, jurisdictions []taxJurisdiction, exemptions taxExemptions) ([]models.QuoteCharge, error) {
+	charges := []models.QuoteCharge{}
+	if len(jurisdictions) == 0 || len(totals.sellerIDs) == 0 {
+		return charges, nil
+	}
+	zero := money.Zero(cart.currency)
+	if exemptions.buyer != "" {
+		for _, j := range jurisdictions {
+			charges = append(charges, models.QuoteCharge{
+				Type: models.ChargeTax, Label: j.name, Jurisdiction: j.code, Rate: j.rate, Amount: zero, Reason: exemptions.buyer,
+			})
+		}
+		return charges, nil
+	}
+
+	// Each seller's taxable goods after discounts
+	bases := make(map[string]money.Money, len(totals.sellerIDs))
+	for _, sellerID := range totals.sellerIDs {
+		bases[sellerID] = zero
+	}
+	var exempt []models.QuoteCharge
+	for _, line := range cart.lines {
+		if line.problem != nil {
+			continue
+		}
+		if reason, ok := exemptions.products[line.productID]; ok {
+			exempt = append(exempt, models.QuoteCharge{
+				Type: models.ChargeTax, Label: "Tax exempt", SellerID: line.sellerID, ProductID: line.productID, Amount: zero, Reason: reason,
+			})
+			continue
+		}
+		taxable, err := line.lineTotal.Sub(line.discount)
+		if err == nil {
+			bases[line.sellerID], err = bases[line.sellerID].Add(taxable)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to total taxable goods: %w", err)
+		}
+	}
+
+	taxes := make([]money.Money, len(totals.sellerIDs))
+	for i := range taxes {
+		taxes[i] = zero
+	}
+	for _, j := range jurisdictions {
+		charge := models.QuoteCharge{Type: models.ChargeTax, Label: j.name, Jurisdiction: j.code, Rate: j.rate, Amount: zero}
+		for i, sellerID := range totals.sellerIDs {
+			tax, err := bases[sellerID].MulFrac(j.ppm, 1000000)
+			if err == nil {
+				taxes[i], err = taxes[i].Add(tax)
+			}
+			if err == nil {
+				charge.Amount, err = charge.Amount.Add(tax)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to total tax: %w", err)
+			}
+		}
+		charges = append(charges, charge)
+	}
+	if err := totals.addTax(cart.currency, taxes); err != nil {
+		return nil, err
+	}
+	return append(charges, exempt...), nil
+}
+
+// addTax sets the sellers' taxes, given in the order of their IDs, and
+// totals the order again
+func (t *cartTotals) addTax(currency string, taxes []money.Money) error {
+	for i, seller := range t.sellers {
+		var err error
+		if t.sellers[i], err = newTotals(seller.Subtotal, seller.Discount, taxes[i], seller.Shipping); err != nil {
+			return err
+		}
+	}
+	var err error
+	t.order, err = sumTotals(currency, t.sellers)
+	return err
+}
+
+// taxCart adds the sales tax of buyerID's priced cart, shipped to address,
+// to its totals within tx, returning it itemized as TaxService.tax does
+func (s *OrderService) taxCart(ctx context.Context, tx *sql.Tx, buyerID string, cart *pricedCart, totals *cartTotals, address json.RawMessage) ([]models.QuoteCharge, error) {
+	jurisdictions := s.tax.jurisdictionsOf(taxAddressOf(address))
+	if len(jurisdictions) == 0 {
+		return []models.QuoteCharge{}, nil
+	}
+	exemptions, err := loadTaxExemptions(ctx, tx, buyerID, cart)
+	if err != nil {
+		return nil, err
+	}
+	return s.tax.tax(cart, totals, jurisdictions, exemptions)
+}
+
+// SetProductTaxExemption sets why product productID is exempt from tax, nil
+// falling back to its category's exemption
+func (s *OrderService) SetProductTaxExemption(ctx context.Context, productID string, reason *string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE products SET tax_exempt_reason = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, productID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to set product tax exemption: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// SetCategoryTaxExemption sets why category categoryID's products are
+// exempt from tax, nil making them taxable
+func (s *OrderService) SetCategoryTaxExemption(ctx context.Context, categoryID string, reason *string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE categories SET tax_exempt_reason = $2 WHERE id = $1`, categoryID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to set category tax exemption: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// SetBuyerTaxExemption sets why user userID's orders are exempt from tax,
+// nil making them taxable
+func (s *OrderService) SetBuyerTaxExemption(ctx context.Context, userID string, reason *string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE users SET tax_exempt_reason = $2, updated_at = NOW() WHERE id = $1`, userID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to set buyer tax exemption: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
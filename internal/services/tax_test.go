@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+func TestParseTaxRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    int64
+		wantErr bool
+	}{
+		{rate: "7.25", want: 72500},
+		{rate: "6.875", want: 68750},
+		{rate: " 8 ", want: 80000},
+		{rate: "0", want: 0},
+		{rate: "100", want: 1000000},
+		{rate: "0.0001", want: 1},
+		{rate: "", wantErr: true},
+		{rate: ".5", wantErr: true},
+		{rate: "7.12345", wantErr: true},
+		{rate: "-1", wantErr: true},
+		{rate: "100.01", wantErr: true},
+		{rate: "seven", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTaxRate(tt.rate)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTaxRate(%q) = %d, want an error", tt.rate, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseTaxRate(%q) = %d, %v; want %d", tt.rate, got, err, tt.want)
+		}
+	}
+}
+
+// testTaxService is California with Los Angeles County in it, New York and
+// Germany
+func testTaxService(t *testing.T) *TaxService {
+	t.Helper()
+	s, err := NewTaxService(config.TaxConfig{Jurisdictions: []config.TaxJurisdictionConfig{
+		{Code: "US-CA", Name: "California", Country: "US", State: "CA", Rate: "7.25"},
+		{Code: "US-CA-LA", Name: "Los Angeles County", Country: "US", State: "CA", Rate: "2.25"},
+		{Code: "US-NY", Name: "New York", Country: "US", State: "NY", Rate: "4"},
+		{Code: "DE", Name: "Germany", Country: "de", Rate: "19"},
+	}})
+	if err != nil {
+		t.Fatalf("NewTaxService: %v", err)
+	}
+	return s
+}
+
+func TestTaxJurisdictionsOf(t *testing.T) {
+	s := testTaxService(t)
+	tests := []struct {
+		address string
+		want    []string
+	}{
+		{address: `{"country": "US", "state": "CA"}`, want: []string{"US-CA", "US-CA-LA"}},
+		{address: `{"country": "us", "state": "ca", "city": "Los Angeles"}`, want: []string{"US-CA", "US-CA-LA"}},
+		{address: `{"country": "US", "state": "NY"}`, want: []string{"US-NY"}},
+		{address: `{"country": "DE", "state": "Berlin"}`, want: []string{"DE"}},
+		{address: `{"country": "US", "state": "TX"}`},
+		{address: `{"country": "US"}`},
+		{address: `{"state": "CA"}`},
+		{address: `"not an address"`},
+		{address: ``},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, j := range s.jurisdictionsOf(taxAddressOf(json.RawMessage(tt.address))) {
+			got = append(got, j.code)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("jurisdictionsOf(%s) = %v, want %v", tt.address, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("jurisdictionsOf(%s) = %v, want %v", tt.address, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// taxedCart is two sellers' lines and a line that can't be ordered, totalled
+// as checkout totals them
+func taxedCart(t *testing.T) (*pricedCart, *cartTotals) {
+	t.Helper()
+	line := func(productID, sellerID string, cents int64) cartLine {
+		return cartLine{orderLine: orderLine{productID: productID, quantity: 1}, sellerID: sellerID, lineTotal: money.New(cents, "USD")}
+	}
+	cart := &pricedCart{currency: "USD", lines: []cartLine{
+		line("apples", "seller-a", 1000),
+		line("pears", "seller-a", 333),
+		line("honey", "seller-b", 1999),
+		line("gone", "seller-b", 500),
+	}}
+	cart.lines[3].problem = &validators.FieldError{Field: "items[3]", Code: "unlisted", Message: "no longer listed"}
+	totals, err := cart.totals()
+	if err != nil {
+		t.Fatalf("totals: %v", err)
+	}
+	return cart, totals
+}
+
+func TestTaxItemizesEachJurisdiction(t *testing.T) {
+	s := testTaxService(t)
+	cart, totals := taxedCart(t)
+	jurisdictions := s.jurisdictionsOf(taxAddress{country: "US", state: "CA"})
+
+	taxes, err := s.tax(cart, totals, jurisdictions, taxExemptions{})
+	if err != nil {
+		t.Fatalf("tax: %v", err)
+	}
+	// Seller A's 13.33 is taxed 0.97 and 0.30 and seller B's 19.99 1.45 and
+	// 0.45, each rounded on its own
+	want := []models.QuoteCharge{
+		{Type: models.ChargeTax, Label: "California", Jurisdiction: "US-CA", Rate: "7.25", Amount: money.New(242, "USD")},
+		{Type: models.ChargeTax, Label: "Los Angeles County", Jurisdiction: "US-CA-LA", Rate: "2.25", Amount: money.New(75, "USD")},
+	}
+	if len(taxes) != len(want) {
+		t.Fatalf("tax charges = %+v, want %+v", taxes, want)
+	}
+	for i := range want {
+		if taxes[i] != want[i] {
+			t.Errorf("tax charge %d = %+v, want %+v", i, taxes[i], want[i])
+		}
+	}
+	if got := totals.sellers[0].Tax; got != money.New(127, "USD") {
+		t.Errorf("seller A tax = %s, want 1.27", got)
+	}
+	if got := totals.sellers[1].Tax; got != money.New(190, "USD") {
+		t.Errorf("seller B tax = %s, want 1.90", got)
+	}
+	if got := totals.order.Total; got != money.New(3649, "USD") {
+		t.Errorf("order total = %s, want 36.49", got)
+	}
+
+	// The quote's charges, taxes included, sum to what checkout charges
+	if _, err := quoteCharges("USD", totals, nil, nil, "standard", taxes); err != nil {
+		t.Errorf("quoteCharges: %v", err)
+	}
+}
+
+func TestTaxExemptProduct(t *testing.T) {
+	s := testTaxService(t)
+	cart, totals := taxedCart(t)
+	jurisdictions := s.jurisdictionsOf(taxAddress{country: "US", state: "CA"})
+
+	exemptions := taxExemptions{products: map[string]string{"pears": "Unprepared food"}}
+	taxes, err := s.tax(cart, totals, jurisdictions, exemptions)
+	if err != nil {
+		t.Fatalf("tax: %v", err)
+	}
+	// Only seller A's apples are taxed for seller A: 0.73 and 0.23
+	want := []models.QuoteCharge{
+		{Type: models.ChargeTax, Label: "California", Jurisdiction: "US-CA", Rate: "7.25", Amount: money.New(218, "USD")},
+		{Type: models.ChargeTax, Label: "Los Angeles County", Jurisdiction: "US-CA-LA", Rate: "2.25", Amount: money.New(68, "USD")},
+		{Type: models.ChargeTax, Label: "Tax exempt", SellerID: "seller-a", ProductID: "pears", Amount: money.Zero("USD"), Reason: "Unprepared food"},
+	}
+	if len(taxes) != len(want) {
+		t.Fatalf("tax charges = %+v, want %+v", taxes, want)
+	}
+	for i := range want {
+		if taxes[i] != want[i] {
+			t.Errorf("tax charge %d = %+v, want %+v", i, taxes[i], want[i])
+		}
+	}
+	if got := totals.order.Tax; got != money.New(286, "USD") {
+		t.Errorf("order tax = %s, want 2.86", got)
+	}
+	if _, err := quoteCharges("USD", totals, nil, nil, "standard", taxes); err != nil {
+		t.Errorf("quoteCharges: %v", err)
+	}
+}
+
+func TestTaxExemptBuyer(t *testing.T) {
+	s := testTaxService(t)
+	cart, totals := taxedCart(t)
+	jurisdictions := s.jurisdictionsOf(taxAddress{country: "US", state: "CA"})
+
+	exemptions := taxExemptions{buyer: "Resale certificate", products: map[string]string{"pears": "Unprepared food"}}
+	taxes, err := s.tax(cart, totals, jurisdictions, exemptions)
+	if err != nil {
+		t.Fatalf("tax: %v", err)
+	}
+	if len(taxes) != 2 {
+		t.Fatalf("tax charges = %+v, want one per jurisdiction", taxes)
+	}
+	for i, charge := range taxes {
+		if charge.Jurisdiction != jurisdictions[i].code || !charge.Amount.IsZero() || charge.Reason != "Resale certificate" {
+			t.Errorf("tax charge %d = %+v, want a zero charge for %s with the buyer's reason", i, charge, jurisdictions[i].code)
+		}
+	}
+	if !totals.order.Tax.IsZero() {
+		t.Errorf("order tax = %s, want none", totals.order.Tax)
+	}
+	if _, err := quoteCharges("USD", totals, nil, nil, "standard", taxes); err != nil {
+		t.Errorf("quoteCharges: %v", err)
+	}
+}
+
+func TestTaxOutsideEveryJurisdiction(t *testing.T) {
+	s := testTaxService(t)
+	cart, totals := taxedCart(t)
+
+	taxes, err := s.tax(cart, totals, s.jurisdictionsOf(taxAddress{country: "US", state: "TX"}), taxExemptions{})
+	if err != nil {
+		t.Fatalf("tax: %v", err)
+	}
+	if len(taxes) != 0 {
+		t.Errorf("tax charges = %+v, want none", taxes)
+	}
+	if !totals.order.Tax.IsZero() || totals.order.Total != money.New(3332, "USD") {
+		t.Errorf("order totals = %+v, want no tax and a total of 33.32", totals.order)
+	}
+}
+
+func TestNewTaxServiceRejectsBadRates(t *testing.T) {
+	_, err := NewTaxService(config.TaxConfig{Jurisdictions: []config.TaxJurisdictionConfig{
+		{Code: "US-CA", Name: "California", Country: "US", State: "CA", Rate: "7.25%"},
+	}})
+	if err == nil {
+		t.Error("NewTaxService accepted a rate of 7.25%")
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+// TokenKeys holds the keys used to sign and verify access tokens. With an
+// asymmetric algorithm tokens carry the signing key's kid and are verified
+// against every published public key, so keys can be rotated by adding the
+// new key and keeping the old one as a verification key until its tokens
+// expire.
+type TokenKeys struct {
+	signer     *jwtauth.JWTAuth
+	publicKeys jwk.Set // nil for HMAC algorithms
+}
+
+// LoadTokenKeys loads the token keys described by cfg
+func LoadTokenKeys(cfg config.JWTConfig) (*TokenKeys, error) {
+	alg := cfg.Alg
+	if alg == "" {
+		alg = "HS256"
+	}
+	if strings.HasPrefix(alg, "HS") {
+		return &TokenKeys{signer: jwtauth.New(alg, []byte(cfg.Secret), nil)}, nil
+	}
+
+	signKey, err := loadPEMKey(cfg.PrivateKeyFile, cfg.KeyID, alg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWT signing key: %w", err)
+	}
+	if !isPrivateKeyFor(signKey, alg) {
+		return nil, fmt.Errorf("JWT signing key is not a %s private key", alg)
+	}
+
+	publicKeys := jwk.NewSet()
+	signPublic, err := jwk.PublicKeyOf(signKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive JWT public key: %w", err)
+	}
+	if err := publicKeys.AddKey(signPublic); err != nil {
+		return nil, fmt.Errorf("failed to add JWT public key: %w", err)
+	}
+
+	for _, keyCfg := range cfg.VerificationKeys {
+		key, err := loadPEMKey(keyCfg.PublicKeyFile, keyCfg.KeyID, alg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT verification key %s: %w", keyCfg.KeyID, err)
+		}
+		public, err := jwk.PublicKeyOf(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT verification key %s: %w", keyCfg.KeyID, err)
+		}
+		if err := publicKeys.AddKey(public); err != nil {
+			return nil, fmt.Errorf("failed to add JWT verification key %s: %w", keyCfg.KeyID, err)
+		}
+	}
+
+	return &TokenKeys{
+		signer:     jwtauth.New(alg, signKey, nil),
+		publicKeys: publicKeys,
+	}, nil
+}
+
+// Encode signs a token with the given claims
+func (k *TokenKeys) Encode(claims map[string]interface{}) (jwt.Token, string, error) {
+	return k.signer.Encode(claims)
+}
+
+// Decode verifies a token's signature and parses it. Claims are not
+// validated; callers check exp, nbf, iss and aud themselves.
+func (k *TokenKeys) Decode(tokenString string) (jwt.Token, error) {
+	if k.publicKeys == nil {
+		return k.signer.Decode(tokenString)
+	}
+	return jwt.Parse([]byte(tokenString), jwt.WithKeySet(k.publicKeys), jwt.WithValidate(false))
+}
+
+// PublicKeys returns the public verification keys as a JWK set. The set is
+// empty for HMAC algorithms, whose secret must never be published.
+func (k *TokenKeys) PublicKeys() jwk.Set {
+	if k.publicKeys == nil {
+		return jwk.NewSet()
+	}
+	return k.publicKeys
+}
+
+// loadPEMKey reads a PEM encoded key and tags it with kid and alg
+func loadPEMKey(path, kid, alg string) (jwk.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwk.ParseKey(data, jwk.WithPEM(true))
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.KeyIDKey, kid); err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.AlgorithmKey, jwa.SignatureAlgorithm(alg)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// isPrivateKeyFor reports whether key is a private key of the type alg signs with
+func isPrivateKeyFor(key jwk.Key, alg string) bool {
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		_, ok := key.(jwk.RSAPrivateKey)
+		return ok
+	case strings.HasPrefix(alg, "ES"):
+		_, ok := key.(jwk.ECDSAPrivateKey)
+		return ok
+	default:
+		return false
+	}
+}
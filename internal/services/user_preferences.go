@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+const preferencesColumns = `COALESCE(theme, 'system'), COALESCE(language, 'en'),
+	COALESCE(email_notifications, true), COALESCE(push_notifications, true), COALESCE(marketing_emails, false),
+	COALESCE(interests, '{}'), COALESCE(notification_mode, 'instant'), updated_at`
+
+// GetPreferences returns a user's preferences, or the defaults if they
+// haven't saved any
+func (s *UserService) GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	prefs, err := scanPreferences(s.db.QueryRowContext(ctx, `
+		SELECT `+preferencesColumns+` FROM user_preferences WHERE user_id = $1`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.UserPreferences{PreferencesInput: models.DefaultPreferences()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+// UpdatePreferences replaces a user's preferences
+func (s *UserService) UpdatePreferences(ctx context.Context, userID string, input models.PreferencesInput) (*models.UserPreferences, error) {
+	prefs, err := savePreferences(ctx, s.db.QueryRowContext, userID, input)
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// PatchPreferences merges patch into a user's stored preferences and saves
+// the result once it passes validation. The user's preferences row is locked
+// while the patch is applied, so concurrent patches are applied one after
+// the other and neither is lost.
+func (s *UserService) PatchPreferences(ctx context.Context, userID string, patch models.PreferencesPatch) (*models.UserPreferences, error) {
+	var prefs *models.UserPreferences
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Lock a row even for a user with no preferences saved yet
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO user_preferences (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`, userID); err != nil {
+			return fmt.Errorf("failed to create preferences: %w", err)
+		}
+		current, err := scanPreferences(tx.QueryRowContext(ctx, `
+			SELECT `+preferencesColumns+` FROM user_preferences WHERE user_id = $1 FOR UPDATE`, userID))
+		if err != nil {
+			return fmt.Errorf("failed to get preferences: %w", err)
+		}
+
+		input, err := applyPreferencesPatch(current.PreferencesInput, patch)
+		if err != nil {
+			return err
+		}
+		prefs, err = savePreferences(ctx, tx.QueryRowContext, userID, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// applyPreferencesPatch returns current with the keys in patch replaced,
+// those set to null by their defaults, checked against the input's struct
+// tags
+func applyPreferencesPatch(current models.PreferencesInput, patch models.PreferencesPatch) (models.PreferencesInput, error) {
+	fields, err := preferencesFields(current)
+	if err != nil {
+		return current, err
+	}
+	defaults, err := preferencesFields(models.DefaultPreferences())
+	if err != nil {
+		return current, err
+	}
+
+	var invalid []validators.FieldError
+	for _, name := range slices.Sorted(maps.Keys(patch)) {
+		if _, ok := fields[name]; !ok {
+			invalid = append(invalid, validators.FieldError{
+				Field: name, Code: "unknown", Message: fmt.Sprintf("%s is not a preference", name),
+			})
+			continue
+		}
+		if string(patch[name]) == "null" {
+			fields[name] = defaults[name]
+			continue
+		}
+		fields[name] = patch[name]
+	}
+	if len(invalid) > 0 {
+		return current, &validators.ValidationError{Fields: invalid}
+	}
+
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return current, fmt.Errorf("failed to marshal preferences patch: %w", err)
+	}
+	var input models.PreferencesInput
+	if err := json.Unmarshal(merged, &input); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return current, &validators.ValidationError{Fields: []validators.FieldError{{
+				Field: typeErr.Field, Code: "type", Param: typeErr.Type.String(),
+				Message: fmt.Sprintf("%s must be of type %s", typeErr.Field, typeErr.Type),
+			}}}
+		}
+		return current, fmt.Errorf("failed to unmarshal preferences patch: %w", err)
+	}
+	return input, validators.Validate(input)
+}
+
+// preferencesFields returns prefs keyed by JSON field name
+func preferencesFields(prefs models.PreferencesInput) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal preferences: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal preferences: %w", err)
+	}
+	return fields, nil
+}
+
+// savePreferences writes a user's preferences with queryRow, the database's
+// or a transaction's
+func savePreferences(ctx context.Context, queryRow func(context.Context, string, ...interface{}) *sql.Row, userID string, input models.PreferencesInput) (*models.UserPreferences, error) {
+	if input.Interests == nil {
+		input.Interests = []string{}
+	}
+	if input.NotificationMode == "" {
+		input.NotificationMode = models.NotificationModeInstant
+	}
+	prefs, err := scanPreferences(queryRow(ctx, `
+		INSERT INTO user_preferences (user_id, theme, language, email_notifications, push_notifications, marketing_emails, interests,
+			notification_mode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id) DO UPDATE SET theme = EXCLUDED.theme, language = EXCLUDED.language,
+			email_notifications = EXCLUDED.email_notifications, push_notifications = EXCLUDED.push_notifications,
+			marketing_emails = EXCLUDED.marketing_emails, interests = EXCLUDED.interests,
+			notification_mode = EXCLUDED.notification_mode
+		RETURNING `+preferencesColumns,
+		userID, input.Theme, input.Language, input.EmailNotifications, input.PushNotifications, input.MarketingEmails,
+		pq.Array(input.Interests), input.NotificationMode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+func scanPreferences(row rowScanner) (*models.UserPreferences, error) {
+	var prefs models.UserPreferences
+	if err := row.Scan(&prefs.Theme, &prefs.Language, &prefs.EmailNotifications, &prefs.PushNotifications,
+		&prefs.MarketingEmails, pq.Array(&prefs.Interests), &prefs.NotificationMode, &prefs.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if prefs.Interests == nil {
+		prefs.Interests = []string{}
+	}
+	return &prefs, nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+)
+
+const (
+	// userStatsMonths is how many calendar months the spend series covers
+	userStatsMonths = 12
+	// topProductsLimit is how many products a buyer's stats rank
+	topProductsLimit = 5
+)
+
+// orderNetCents is the SQL for what was paid for order o less what has been
+// refunded of its sub-orders and add-ons
+const orderNetCents = `(o.total_cents
+	- COALESCE((SELECT SUM(so.refunded_cents) FROM sub_orders so WHERE so.order_id = o.id), 0)
+	- COALESCE((SELECT SUM(oa.refunded_cents) FROM order_addons oa WHERE oa.order_id = o.id), 0))`
+
+// Buyer returns the order stats of buyer userID, cached under the buyer's
+// own key. The cache TTL is kept short so a new order shows up soon without
+// invalidating on every checkout.
+func (s *StatsService) Buyer(ctx context.Context, userID string) (*models.UserStats, error) {
+	var stats models.UserStats
+	err := s.cache.GetOrSet(ctx, config.CacheUserStats, userID, nil, &stats, func(ctx context.Context) (interface{}, error) {
+		return s.buyer(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (s *StatsService) buyer(ctx context.Context, userID string) (*models.UserStats, error) {
+	stats := &models.UserStats{Spent: []money.Money{}, TopProducts: []models.ProductStat{}, GeneratedAt: time.Now()}
+	for _, query := range []func(ctx context.Context, userID string, stats *models.UserStats) error{
+		s.buyerMonths, s.buyerTopProducts, s.buyerFavoriteCategory,
+	} {
+		if err := query(ctx, userID, stats); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// buyerMonths sets the buyer's lifetime orders and spend, and those of each
+// of the last userStatsMonths months
+func (s *StatsService) buyerMonths(ctx context.Context, userID string, stats *models.UserStats) error {
+	start := time.Now().UTC()
+	start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-userStatsMonths, 0)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT CASE WHEN o.created_at >= $2 THEN date_trunc('month', o.created_at, 'UTC') END,
+			o.currency, COUNT(*), SUM(`+orderNetCents+`)
+		FROM orders o
+		WHERE o.buyer_id = $1 AND `+countedOrders+`
+		GROUP BY 1, 2`, userID, start)
+	if err != nil {
+		return fmt.Errorf("failed to get user order stats: %w", err)
+	}
+	defer rows.Close()
+
+	months := make([]models.UserStatsMonth, userStatsMonths)
+	for i := range months {
+		months[i] = models.UserStatsMonth{Month: start.AddDate(0, i, 0), Spent: []money.Money{}}
+	}
+	for rows.Next() {
+		var month sql.NullTime
+		var currency sql.NullString
+		var count int
+		var total int64
+		if err := rows.Scan(&month, &currency, &count, &total); err != nil {
+			return fmt.Errorf("failed to scan user order stats: %w", err)
+		}
+		amount := money.New(total, orderCurrency(currency))
+		stats.Orders += count
+		stats.Spent = addGMV(stats.Spent, amount)
+		if !month.Valid {
+			continue
+		}
+		m := month.Time.UTC()
+		if i := (m.Year()-start.Year())*12 + int(m.Month()-start.Month()); i >= 0 && i < len(months) {
+			months[i].Orders += count
+			months[i].Spent = addGMV(months[i].Spent, amount)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get user order stats: %w", err)
+	}
+	stats.Months = months
+	return nil
+}
+
+// buyerTopProducts sets the products in most of the buyer's orders
+func (s *StatsService) buyerTopProducts(ctx context.Context, userID string, stats *models.UserStats) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.name, COUNT(DISTINCT o.id)
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id AND oi.cancelled_at IS NULL
+		JOIN products p ON p.id = oi.product_id
+		WHERE o.buyer_id = $1 AND `+countedOrders+`
+		GROUP BY p.id, p.name
+		ORDER BY 3 DESC, p.name
+		LIMIT $2`, userID, topProductsLimit)
+	if err != nil {
+		return fmt.Errorf("failed to get user top products: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var product models.ProductStat
+		if err := rows.Scan(&product.ProductID, &product.Name, &product.Orders); err != nil {
+			return fmt.Errorf("failed to scan user top products: %w", err)
+		}
+		stats.TopProducts = append(stats.TopProducts, product)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get user top products: %w", err)
+	}
+	return nil
+}
+
+// buyerFavoriteCategory sets the primary category in most of the buyer's
+// orders, with the buyer's spend on its items
+func (s *StatsService) buyerFavoriteCategory(ctx context.Context, userID string, stats *models.UserStats) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.id, c.name, o.currency, COUNT(DISTINCT o.id), SUM(oi.total_cents)
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id AND oi.cancelled_at IS NULL
+		JOIN products p ON p.id = oi.product_id
+		JOIN categories c ON c.id = p.category_id
+		WHERE o.buyer_id = $1 AND `+countedOrders+`
+		GROUP BY c.id, c.name, o.currency`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user favorite category: %w", err)
+	}
+	defer rows.Close()
+
+	byID := map[string]*models.CategoryStat{}
+	for rows.Next() {
+		var id, name string
+		var currency sql.NullString
+		var count int
+		var total int64
+		if err := rows.Scan(&id, &name, &currency, &count, &total); err != nil {
+			return fmt.Errorf("failed to scan user favorite category: %w", err)
+		}
+		stat, ok := byID[id]
+		if !ok {
+			stat = &models.CategoryStat{CategoryID: id, Name: name, GMV: []money.Money{}}
+			byID[id] = stat
+		}
+		stat.Orders += count
+		stat.GMV = addGMV(stat.GMV, money.New(total, orderCurrency(currency)))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get user favorite category: %w", err)
+	}
+
+	categories := make([]*models.CategoryStat, 0, len(byID))
+	for _, stat := range byID {
+		categories = append(categories, stat)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Orders != categories[j].Orders {
+			return categories[i].Orders > categories[j].Orders
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	if len(categories) > 0 {
+		stats.FavoriteCategory = categories[0]
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+func newTokenTestService(skew int) *UserService {
+	return &UserService{
+		tokenKeys: &TokenKeys{signer: jwtauth.New("HS256", []byte("test-secret"), nil)},
+		jwt:       config.JWTConfig{Expiration: 1, ClockSkew: skew},
+	}
+}
+
+func TestGenerateTokenSetsIssuedAt(t *testing.T) {
+	s := newTokenTestService(30)
+	before := time.Now().Truncate(time.Second)
+
+	tokenString, expiresAt, err := s.GenerateToken("user-1", "user")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	token, err := s.ValidateToken(tokenString)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if token.IssuedAt().Before(before) {
+		t.Errorf("iat = %v, want at least %v", token.IssuedAt(), before)
+	}
+	if !token.Expiration().Equal(expiresAt.Truncate(time.Second)) {
+		t.Errorf("exp = %v, want %v", token.Expiration(), expiresAt.Truncate(time.Second))
+	}
+}
+
+func TestValidateTokenClockSkew(t *testing.T) {
+	now := time.Now()
+	// a token issued in the future fails on iat or nbf, whichever is checked
+	// first
+	notYetValid := []error{jwt.ErrInvalidIssuedAt(), jwt.ErrTokenNotYetValid()}
+	expired := []error{jwt.ErrTokenExpired()}
+
+	tests := []struct {
+		name      string
+		skew      int
+		issuedAt  time.Time
+		expiresAt time.Time
+		want      []error // any of, or none for a valid token
+	}{
+		{"valid", 30, now.Add(-time.Minute), now.Add(time.Hour), nil},
+		{"issued slightly in the future", 30, now.Add(10 * time.Second), now.Add(time.Hour), nil},
+		{"issued beyond the leeway", 30, now.Add(2 * time.Minute), now.Add(time.Hour), notYetValid},
+		{"slightly expired", 30, now.Add(-time.Hour), now.Add(-10 * time.Second), nil},
+		{"expired beyond the leeway", 30, now.Add(-time.Hour), now.Add(-2 * time.Minute), expired},
+		{"slightly expired without leeway", 0, now.Add(-time.Hour), now.Add(-10 * time.Second), expired},
+		{"slightly in the future without leeway", 0, now.Add(10 * time.Second), now.Add(time.Hour), notYetValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTokenTestService(tt.skew)
+			tokenString, err := s.signToken("user-1", "user", tt.issuedAt, tt.expiresAt, nil)
+			if err != nil {
+				t.Fatalf("signToken: %v", err)
+			}
+
+			_, err = s.ValidateToken(tokenString)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("ValidateToken: %v, want a valid token", err)
+				}
+				return
+			}
+			for _, want := range tt.want {
+				if errors.Is(err, want) {
+					return
+				}
+			}
+			t.Errorf("ValidateToken: %v, want one of %v", err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/utils"
+	"github.com/greens-marketplace/internal/validators"
+	"github.com/greens-marketplace/internal/version"
+)
+
+// Headers of outbound webhook requests
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// EventWebhookPing is sent by WebhookService.Test to check a subscription
+const EventWebhookPing = "webhook.ping"
+
+// webhookResponseLimit is how much of a subscriber's response is read
+const webhookResponseLimit = 64 << 10
+
+var ErrWebhookNotFound = errors.New("webhook subscription not found")
+
+// WebhookEvents are the events a webhook can subscribe to
+var WebhookEvents = []string{
+	EventOrderStatusChanged,
+	EventOrderRefunded,
+	EventSellerShipped,
+	EventStockLow,
+	EventStockBackInStock,
+	EventPriceDrop,
+}
+
+// WebhookService manages webhook subscriptions and sends them events
+type WebhookService struct {
+	db     *database.PostgresDB
+	client *utils.SafeHTTPClient
+}
+
+// NewWebhookService creates a new webhook service
+func NewWebhookService(db *database.PostgresDB, cfg config.WebhookConfig) *WebhookService {
+	return &WebhookService{
+		db: db,
+		// Redirects are reported as is rather than followed
+		client: utils.NewSafeHTTPClient(utils.SafeHTTPOptions{
+			MaxBytes: webhookResponseLimit,
+			Timeout:  time.Duration(cfg.Timeout) * time.Second,
+		}),
+	}
+}
+
+// webhookEnvelope is the body of every webhook request
+type webhookEnvelope struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// SignWebhook returns the hex HMAC-SHA256 signature of a webhook request,
+// computed over the unix timestamp and body joined by a dot. Subscribers
+// verify requests with it.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// List returns a page of ownerID's subscriptions, or everyone's for admins,
+// oldest first
+func (s *WebhookService) List(ctx context.Context, ownerID string, isAdmin bool, limit, offset int) (*models.WebhookSubscriptionPage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, owner_id, url, events, is_active, payload_version, secret_rotated_at, created_at, updated_at, COUNT(*) OVER() AS total
+		FROM webhook_subscriptions
+		WHERE $2 OR owner_id::text = $1
+		ORDER BY created_at, id
+		LIMIT $3 OFFSET $4`, ownerID, isAdmin, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.WebhookSubscriptionPage{Subscriptions: []models.WebhookSubscription{}}
+	for rows.Next() {
+		var sub models.WebhookSubscription
+		if err := rows.Scan(&sub.ID, &sub.OwnerID, &sub.URL, pq.Array(&sub.Events), &sub.IsActive, &sub.PayloadVersion,
+			&sub.SecretRotatedAt, &sub.CreatedAt, &sub.UpdatedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription: %w", err)
+		}
+		page.Subscriptions = append(page.Subscriptions, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	return page, nil
+}
+
+// Get returns subscription id. Only its owner and admins may see it; others
+// get ErrWebhookNotFound.
+func (s *WebhookService) Get(ctx context.Context, id, userID string, isAdmin bool) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, owner_id, url, events, is_active, payload_version, secret_rotated_at, created_at, updated_at
+		FROM webhook_subscriptions
+		WHERE id = $1 AND ($3 OR owner_id::text = $2)`, id, userID, isAdmin).Scan(
+		&sub.ID, &sub.OwnerID, &sub.URL, pq.Array(&sub.Events), &sub.IsActive, &sub.PayloadVersion,
+		&sub.SecretRotatedAt, &sub.CreatedAt, &sub.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return &sub, nil
+}
+
+// Create subscribes input.URL to input.Events for ownerID, returning the
+// subscription with its signing secret
+func (s *WebhookService) Create(ctx context.Context, ownerID string, input models.WebhookInput) (*models.WebhookSubscription, error) {
+	if err := checkWebhookInput(ctx, input); err != nil {
+		return nil, err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	active := input.IsActive == nil || *input.IsActive
+	payloadVersion := CurrentWebhookPayloadVersion
+	if input.PayloadVersion != nil {
+		payloadVersion = *input.PayloadVersion
+	}
+
+	sub := models.WebhookSubscription{
+		OwnerID: ownerID, URL: input.URL, Events: input.Events, IsActive: active, PayloadVersion: payloadVersion, Secret: secret,
+	}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO webhook_subscriptions (owner_id, url, events, is_active, payload_version, secret)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, secret_rotated_at, created_at, updated_at`,
+		ownerID, input.URL, pq.Array(input.Events), active, payloadVersion, secret).Scan(
+		&sub.ID, &sub.SecretRotatedAt, &sub.CreatedAt, &sub.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	return &sub, nil
+}
+
+// Update replaces subscription id's URL and events, and its active flag and
+// payload version when input sets them
+func (s *WebhookService) Update(ctx context.Context, id, userID string, isAdmin bool, input models.WebhookInput) (*models.WebhookSubscription, error) {
+	if err := checkWebhookInput(ctx, input); err != nil {
+		return nil, err
+	}
+	var sub models.WebhookSubscription
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE webhook_subscriptions
+		SET url = $4, events = $5, is_active = COALESCE($6, is_active), payload_version = COALESCE($7, payload_version),
+			updated_at = NOW()
+		WHERE id = $1 AND ($3 OR owner_id::text = $2)
+		RETURNING id, owner_id, url, events, is_active, payload_version, secret_rotated_at, created_at, updated_at`,
+		id, userID, isAdmin, input.URL, pq.Array(input.Events), input.IsActive, input.PayloadVersion).Scan(
+		&sub.ID, &sub.OwnerID, &sub.URL, pq.Array(&sub.Events), &sub.IsActive, &sub.PayloadVersion,
+		&sub.SecretRotatedAt, &sub.CreatedAt, &sub.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	return &sub, nil
+}
+
+// Delete removes subscription id and its delivery history
+func (s *WebhookService) Delete(ctx context.Context, id, userID string, isAdmin bool) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM webhook_subscriptions WHERE id = $1 AND ($3 OR owner_id::text = $2)`, id, userID, isAdmin)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// RotateSecret replaces subscription id's signing secret, returning the
+// subscription with the new secret. Requests are signed with the new secret
+// from then on.
+func (s *WebhookService) RotateSecret(ctx context.Context, id, userID string, isAdmin bool) (*models.WebhookSubscription, error) {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	sub := models.WebhookSubscription{Secret: secret}
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE webhook_subscriptions SET secret = $4, secret_rotated_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND ($3 OR owner_id::text = $2)
+		RETURNING id, owner_id, url, events, is_active, payload_version, secret_rotated_at, created_at, updated_at`,
+		id, userID, isAdmin, secret).Scan(
+		&sub.ID, &sub.OwnerID, &sub.URL, pq.Array(&sub.Events), &sub.IsActive, &sub.PayloadVersion,
+		&sub.SecretRotatedAt, &sub.CreatedAt, &sub.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate webhook secret: %w", err)
+	}
+	return &sub, nil
+}
+
+// Deliveries returns a page of subscription id's delivery attempts, newest first
+func (s *WebhookService) Deliveries(ctx context.Context, id, userID string, isAdmin bool, limit, offset int) (*models.WebhookDeliveryPage, error) {
+	if _, err := s.Get(ctx, id, userID, isAdmin); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, subscription_id, event_id, event_type, payload_version, status, response_code, COALESCE(error, ''), duration_ms,
+			attempted_at,
+			COUNT(*) OVER() AS total
+		FROM webhook_deliveries
+		WHERE subscription_id = $1
+		ORDER BY attempted_at DESC, id
+		LIMIT $2 OFFSET $3`, id, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.WebhookDeliveryPage{Deliveries: []models.WebhookDelivery{}}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.PayloadVersion, &d.Status, &d.ResponseCode, &d.Error,
+			&d.DurationMs, &d.AttemptedAt, &page.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		page.Deliveries = append(page.Deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return page, nil
+}
+
+// Test sends a signed EventWebhookPing to subscription id, active or not,
+// and returns the recorded attempt. A subscriber that can't be reached or
+// answers with an error is a failed attempt, not an error.
+func (s *WebhookService) Test(ctx context.Context, id, userID string, isAdmin bool) (*models.WebhookDelivery, error) {
+	var subURL, secret string
+	var payloadVersion int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT url, secret, payload_version FROM webhook_subscriptions WHERE id = $1 AND ($3 OR owner_id::text = $2)`,
+		id, userID, isAdmin).Scan(&subURL, &secret, &payloadVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return s.send(ctx, id, subURL, secret, payloadVersion, EventWebhookPing, map[string]string{"subscriptionId": id})
+}
+
+// send delivers an event to a subscription, with its payload data in the
+// subscription's payload version, and records the attempt
+func (s *WebhookService) send(ctx context.Context, subscriptionID, rawURL, secret string, payloadVersion int, eventType string, data interface{}) (*models.WebhookDelivery, error) {
+	payload, err := webhookPayload(eventType, payloadVersion, data)
+	if err != nil {
+		return nil, err
+	}
+	envelope := webhookEnvelope{ID: uuid.NewString(), Type: eventType, CreatedAt: time.Now().UTC(), Data: payload}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	delivery := models.WebhookDelivery{SubscriptionID: subscriptionID, EventID: envelope.ID, EventType: eventType, PayloadVersion: &payloadVersion}
+	start := time.Now()
+	code, err := s.post(ctx, rawURL, secret, payloadVersion, envelope, body)
+	delivery.DurationMs = int(time.Since(start).Milliseconds())
+	switch {
+	case err != nil:
+		delivery.Status, delivery.Error = models.WebhookDeliveryFailed, err.Error()
+	case code < 200 || code > 299:
+		delivery.Status, delivery.ResponseCode = models.WebhookDeliveryFailed, &code
+		delivery.Error = fmt.Sprintf("subscriber answered %d", code)
+	default:
+		delivery.Status, delivery.ResponseCode = models.WebhookDeliverySucceeded, &code
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload_version, status, response_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		RETURNING id, attempted_at`,
+		subscriptionID, delivery.EventID, eventType, payloadVersion, delivery.Status, delivery.ResponseCode, delivery.Error,
+		delivery.DurationMs).Scan(&delivery.ID, &delivery.AttemptedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+// post signs and posts body to rawURL, returning the response status. The
+// safe client checks the address connected to, since what the URL's host
+// resolves to may have changed since it was subscribed.
+func (s *WebhookService) post(ctx context.Context, rawURL, secret string, payloadVersion int, envelope webhookEnvelope, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(envelope.CreatedAt.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	req.Header.Set(WebhookIDHeader, envelope.ID)
+	req.Header.Set(WebhookEventHeader, envelope.Type)
+	req.Header.Set(WebhookVersionHeader, strconv.Itoa(payloadVersion))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// checkWebhookInput checks a subscription's URL, that it only subscribes to
+// known events and that it asks for a supported payload version
+func checkWebhookInput(ctx context.Context, input models.WebhookInput) error {
+	var invalid []validators.FieldError
+	for i, event := range input.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			invalid = append(invalid, validators.FieldError{
+				Field: fmt.Sprintf("events[%d]", i), Code: "oneof", Param: strings.Join(WebhookEvents, " "),
+				Message: fmt.Sprintf("events[%d] must be one of %s", i, strings.Join(WebhookEvents, ", ")),
+			})
+		}
+	}
+	if input.PayloadVersion != nil && !supportedWebhookPayloadVersion(*input.PayloadVersion) {
+		var versions []string
+		for _, v := range WebhookPayloadVersions() {
+			versions = append(versions, strconv.Itoa(v.Version))
+		}
+		invalid = append(invalid, validators.FieldError{
+			Field: "payloadVersion", Code: "oneof", Param: strings.Join(versions, " "),
+			Message: fmt.Sprintf("payloadVersion %d is not supported; supported versions are %s", *input.PayloadVersion, strings.Join(versions, ", ")),
+		})
+	}
+	if err := checkWebhookURL(ctx, input.URL); err != nil {
+		invalid = append(invalid, validators.FieldError{Field: "url", Code: "unsafe_url", Message: err.Error()})
+	}
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
+
+// checkWebhookURL checks that rawURL is an allowed URL whose host resolves
+// only to public addresses, so webhooks can't be aimed at the internal
+// network
+func checkWebhookURL(ctx context.Context, rawURL string) error {
+	u, err := utils.CheckURL(rawURL, utils.SafeHTTPOptions{})
+	if err != nil {
+		return err
+	}
+	return utils.LookupPublicHost(ctx, u.Hostname())
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
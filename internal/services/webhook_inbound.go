@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/database"
+)
+
+// Inbound webhooks
+//
+// Payment and shipping providers retry webhook deliveries, and sometimes
+// deliver the same event twice within seconds. Receive processes each
+// provider event once. A short lock in Redis turns away a delivery while
+// another of the same event is in flight, without waiting for it, and the
+// event's ID is recorded in inbound_webhook_events in the transaction that
+// processes it, so later redeliveries are skipped too. Receivers answer
+// duplicates with 200 so the provider stops retrying.
+
+// ErrWebhookDuplicate is returned by Receive for an event that was
+// processed already or is being processed by another delivery
+var ErrWebhookDuplicate = errors.New("webhook event already received")
+
+// InboundWebhookService deduplicates webhook events received from providers
+type InboundWebhookService struct {
+	db      *database.PostgresDB
+	redis   *database.RedisClient
+	lockTTL time.Duration
+}
+
+// NewInboundWebhookService creates a new inbound webhook service
+func NewInboundWebhookService(db *database.PostgresDB, redis *database.RedisClient, cfg config.WebhookConfig) *InboundWebhookService {
+	return &InboundWebhookService{db: db, redis: redis, lockTTL: time.Duration(cfg.InboundLockTTL) * time.Second}
+}
+
+// Receive processes event eventID from provider once, calling process in
+// the transaction that records it. It returns ErrWebhookDuplicate without
+// calling process when the event was processed already or another delivery
+// of it is in flight. A delivery whose processing fails records nothing, so
+// the provider's retry processes it. Without Redis the record alone keeps
+// events from being processed twice, simultaneous deliveries waiting for
+// the first to commit.
+func (s *InboundWebhookService) Receive(ctx context.Context, provider, eventID string, process func(ctx context.Context, tx *sql.Tx) error) error {
+	lock, err := s.redis.TryLock(ctx, "webhook:inflight:"+provider+":"+eventID, s.lockTTL)
+	if err != nil {
+		log.Warn().Err(err).Str("provider", provider).Str("event_id", eventID).Msg("Failed to lock webhook event")
+	} else if lock == nil {
+		return ErrWebhookDuplicate
+	} else {
+		defer func() {
+			if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+				log.Warn().Err(err).Str("provider", provider).Str("event_id", eventID).Msg("Failed to release webhook event lock")
+			}
+		}()
+	}
+
+	return s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO inbound_webhook_events (provider, event_id) VALUES ($1, $2)
+			ON CONFLICT (provider, event_id) DO NOTHING`, provider, eventID)
+		if err != nil {
+			return fmt.Errorf("failed to record webhook event: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrWebhookDuplicate
+		}
+		return process(ctx, tx)
+	})
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+// Webhook payload versions
+//
+// Each subscription is sent event payloads in the version it registered
+// for, named in the X-Webhook-Version header, so integrators aren't broken
+// when a payload's shape changes. Events are published in the shape of
+// version 1; each later version is a change in webhookPayloadChanges,
+// listing how it reshapes the payloads of the events it affects. A payload
+// is built by applying the changes of every version up to the one asked
+// for, so adding a version only means appending a change here. Changing an
+// event's struct would change version 1, so shapes move by adding versions
+// instead. Versions below minWebhookPayloadVersion are no longer sent.
+
+// WebhookVersionHeader names the payload version of a webhook request
+const WebhookVersionHeader = "X-Webhook-Version"
+
+// minWebhookPayloadVersion is the oldest payload version still sent
+const minWebhookPayloadVersion = 1
+
+// webhookPayloadChange is what a payload version changed from the one
+// before it. Payloads are reshaped as JSON objects, and events without a
+// reshape are sent as in the previous version.
+type webhookPayloadChange struct {
+	version int
+	summary string
+	reshape map[string]func(data map[string]interface{})
+}
+
+// webhookPayloadChanges are the payload versions after 1, oldest first
+var webhookPayloadChanges = []webhookPayloadChange{
+	{
+		version: 2,
+		summary: "order.status_changed drops recipientEmail, the gift recipient's email address; " +
+			"order.seller_shipped drops orderNumber, as orders are named by orderReference",
+		reshape: map[string]func(data map[string]interface{}){
+			EventOrderStatusChanged: func(data map[string]interface{}) {
+				delete(data, "recipientEmail")
+			},
+			EventSellerShipped: func(data map[string]interface{}) {
+				delete(data, "orderNumber")
+			},
+		},
+	},
+}
+
+// CurrentWebhookPayloadVersion is the newest payload version, which
+// subscriptions get unless they ask for another
+var CurrentWebhookPayloadVersion = webhookPayloadChanges[len(webhookPayloadChanges)-1].version
+
+// WebhookPayloadVersions returns the payload versions subscriptions can
+// register for, oldest first, with what each changed
+func WebhookPayloadVersions() []models.WebhookPayloadVersion {
+	versions := []models.WebhookPayloadVersion{}
+	if minWebhookPayloadVersion == 1 {
+		versions = append(versions, models.WebhookPayloadVersion{Version: 1, Changes: "Payloads as first published"})
+	}
+	for _, change := range webhookPayloadChanges {
+		if change.version >= minWebhookPayloadVersion {
+			versions = append(versions, models.WebhookPayloadVersion{Version: change.version, Changes: change.summary})
+		}
+	}
+	versions[len(versions)-1].Current = true
+	return versions
+}
+
+// supportedWebhookPayloadVersion reports whether subscriptions can register
+// for payload version version
+func supportedWebhookPayloadVersion(version int) bool {
+	return version >= minWebhookPayloadVersion && version <= CurrentWebhookPayloadVersion
+}
+
+// webhookPayload returns data, the payload of an event of eventType as
+// published, in payload version version
+func webhookPayload(eventType string, version int, data interface{}) (interface{}, error) {
+	var reshapes []func(map[string]interface{})
+	for _, change := range webhookPayloadChanges {
+		if change.version > version {
+			break
+		}
+		if reshape, ok := change.reshape[eventType]; ok {
+			reshapes = append(reshapes, reshape)
+		}
+	}
+	if len(reshapes) == 0 {
+		return data, nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		return nil, fmt.Errorf("failed to reshape webhook payload: %w", err)
+	}
+	for _, reshape := range reshapes {
+		reshape(payload)
+	}
+	return payload, nil
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/greens-marketplace/internal/models"
+	"github.com/greens-marketplace/internal/money"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// Wishlist price snapshots
+//
+// A wishlist item records the price its product was charged when it was
+// added, worked out with productPriceAt like carts and checkout, in the
+// same statement that adds it, under a share lock on the product taken
+// before its target price was checked: a concurrent price or currency change
+// lands wholly before or after the add. The added price is never changed, so
+// the wishlist shows how the price moved since. Moving items to the cart
+// doesn't carry it over; cart lines are always priced as of now.
+
+// Wishlist returns a user's wishlist, newest item first, with each item's
+// current and added prices
+func (s *CartService) Wishlist(ctx context.Context, userID string) (*models.Wishlist, error) {
+	items, err := s.wishlistItems(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	return &models.Wishlist{Items: items}, nil
+}
+
+// WishlistItem returns the item of product productID on a user's wishlist
+func (s *CartService) WishlistItem(ctx context.Context, userID, productID string) (*models.WishlistItem, error) {
+	items, err := s.wishlistItems(ctx, userID, productID)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrProductNotFound
+	}
+	return &items[0], nil
+}
+
+// wishlistItems returns the items of a user's wishlist, newest first, or
+// only the one of product productID unless it is empty
+func (s *CartService) wishlistItems(ctx context.Context, userID, productID string) ([]models.WishlistItem, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.title, `+productPriceAt("NOW()")+`, COALESCE(p.currency, 'USD'), w.added_price_cents, w.added_currency,
+			w.target_price_cents, w.target_currency, `+productStock+`, p.min_order_qty,
+			p.deleted_at IS NOT NULL, COALESCE(p.is_active, true) AND `+sellerListed+`, w.created_at
+		FROM wishlist w
+		JOIN products p ON p.id = w.product_id
+		WHERE w.user_id = $1 AND ($2 = '' OR w.product_id = NULLIF($2, '')::uuid)
+		ORDER BY w.created_at DESC, p.id`, userID, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wishlist: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.WishlistItem{}
+	for rows.Next() {
+		var item models.WishlistItem
+		var added, target sql.NullInt64
+		var addedCurrency, targetCurrency sql.NullString
+		var stock, minQuantity int
+		var deleted, listed bool
+		if err := rows.Scan(&item.ProductID, &item.Title, &item.Price.Amount, &item.Price.Currency, &added, &addedCurrency,
+			&target, &targetCurrency, &stock, &minQuantity, &deleted, &listed, &item.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan wishlist item: %w", err)
+		}
+		if added.Valid {
+			price := money.New(added.Int64, addedCurrency.String)
+			item.AddedPrice = &price
+			if change, err := item.Price.Sub(price); err == nil && price.Amount > 0 {
+				percent := math.Round(float64(change.Amount)*10000/float64(price.Amount)) / 100
+				item.PriceChange, item.PriceChangePercent = &change, &percent
+			}
+		}
+		if target.Valid {
+			price := money.New(target.Int64, targetCurrency.String)
+			item.TargetPrice = &price
+		}
+		item.Status = wishlistItemStatus(deleted, listed, stock, minQuantity)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get wishlist: %w", err)
+	}
+	return items, nil
+}
+
+// ExportWishlist returns the rows of a user's wishlist items for
+// ScanWishlistExportItem, oldest first, deleted and unavailable products
+// included. The caller must close them.
+func (s *CartService) ExportWishlist(ctx context.Context, userID string) (*sql.Rows, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.title, `+productPriceAt("NOW()")+`, COALESCE(p.currency, 'USD'),
+			w.target_price_cents, w.target_currency, `+productStock+`, p.min_order_qty,
+			p.deleted_at IS NOT NULL, COALESCE(p.is_active, true) AND `+sellerListed+`, w.created_at
+		FROM wishlist w
+		JOIN products p ON p.id = w.product_id
+		WHERE w.user_id = $1
+		ORDER BY w.created_at, p.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export wishlist: %w", err)
+	}
+	return rows, nil
+}
+
+// ScanWishlistExportItem scans an item from the rows of ExportWishlist
+func ScanWishlistExportItem(rows *sql.Rows) (models.WishlistExportItem, error) {
+	var item models.WishlistExportItem
+	var target sql.NullInt64
+	var targetCurrency sql.NullString
+	var stock, minQuantity int
+	var deleted, listed bool
+	if err := rows.Scan(&item.ProductID, &item.Name, &item.Price.Amount, &item.Price.Currency, &target, &targetCurrency,
+		&stock, &minQuantity, &deleted, &listed, &item.AddedAt); err != nil {
+		return item, fmt.Errorf("failed to scan wishlist item: %w", err)
+	}
+	if target.Valid {
+		price := money.New(target.Int64, targetCurrency.String)
+		item.TargetPrice = &price
+	}
+	item.Status = wishlistItemStatus(deleted, listed, stock, minQuantity)
+	item.ProductURL = "/api/v1/products/" + item.ProductID
+	return item, nil
+}
+
+// wishlistItemStatus returns the status of a wishlist item's product
+func wishlistItemStatus(deleted, listed bool, stock, minQuantity int) string {
+	switch {
+	case deleted:
+		return models.WishlistItemDeleted
+	case !listed:
+		return models.WishlistItemUnavailable
+	case stock < minQuantity:
+		return models.WishlistItemOutOfStock
+	}
+	return models.WishlistItemAvailable
+}
+
+// AddWishlistItems adds the listed products among input's to a user's
+// wishlist in one transaction, with their target prices and current prices.
+// Products already on the wishlist are left as they are, target and added
+// prices included, and products
+// that don't exist or aren't listed are reported as not found. It fails with
+// a *validators.ValidationError when a target price isn't a positive amount
+// in its product's currency, or when the wishlist would hold more than
+// cart.wishlist_max_items products.
+func (s *CartService) AddWishlistItems(ctx context.Context, userID string, input models.WishlistBulkInput) (*models.WishlistBulkResult, error) {
+	result := &models.WishlistBulkResult{Added: []string{}, AlreadyPresent: []string{}, NotFound: []string{}}
+
+	// The first of repeated products counts
+	var ids []string
+	items := make(map[string]int, len(input.Items))
+	for i, item := range input.Items {
+		id := strings.ToLower(item.ProductID)
+		if _, ok := items[id]; !ok {
+			items[id] = i
+			ids = append(ids, id)
+		}
+	}
+
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		// Locked so concurrent adds count each other against the limit
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		// Share locked, in ID order like lockStock, so the currency checked
+		// and the price snapshotted are those the products have on adding
+		rows, err := tx.QueryContext(ctx, `
+			SELECT p.id, COALESCE(p.currency, 'USD'), w.id IS NOT NULL
+			FROM products p
+			LEFT JOIN wishlist w ON w.product_id = p.id AND w.user_id = $1
+			WHERE p.id = ANY($2) AND p.deleted_at IS NULL AND COALESCE(p.is_active, true) AND `+sellerListed+`
+			ORDER BY p.id
+			FOR SHARE OF p`,
+			userID, pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+		currencies := make(map[string]string, len(ids))
+		present := make(map[string]bool)
+		for rows.Next() {
+			var id, currency string
+			var onWishlist bool
+			if err := rows.Scan(&id, &currency, &onWishlist); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan product: %w", err)
+			}
+			currencies[id] = currency
+			present[id] = onWishlist
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get products: %w", err)
+		}
+
+		var invalid []validators.FieldError
+		var added []string
+		var targets []sql.NullInt64
+		var targetCurrencies []sql.NullString
+		for _, id := range ids {
+			currency, ok := currencies[id]
+			switch {
+			case !ok:
+				result.NotFound = append(result.NotFound, id)
+				continue
+			case present[id]:
+				result.AlreadyPresent = append(result.AlreadyPresent, id)
+				continue
+			}
+			i := items[id]
+			var target sql.NullInt64
+			var targetCurrency sql.NullString
+			if price := input.Items[i].TargetPrice; price != nil {
+				switch {
+				case price.Currency != currency:
+					invalid = append(invalid, validators.FieldError{
+						Field: fmt.Sprintf("items[%d].targetPrice", i), Code: "currency", Param: currency,
+						Message: fmt.Sprintf("targetPrice must be in the product's currency, %s", currency),
+					})
+				case price.Amount <= 0:
+					invalid = append(invalid, validators.FieldError{
+						Field: fmt.Sprintf("items[%d].targetPrice", i), Code: "gt", Param: "0", Message: "targetPrice must be greater than 0",
+					})
+				}
+				target = sql.NullInt64{Int64: price.Amount, Valid: true}
+				targetCurrency = sql.NullString{String: price.Currency, Valid: true}
+			}
+			added = append(added, id)
+			targets = append(targets, target)
+			targetCurrencies = append(targetCurrencies, targetCurrency)
+		}
+
+		var count int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM wishlist WHERE user_id = $1`, userID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count wishlist: %w", err)
+		}
+		if count+len(added) > s.wishlistMaxItems {
+			invalid = append(invalid, validators.FieldError{
+				Field: "items", Code: "max", Param: fmt.Sprint(s.wishlistMaxItems),
+				Message: fmt.Sprintf("a wishlist holds at most %d products; %d more fit", s.wishlistMaxItems, max(s.wishlistMaxItems-count, 0)),
+			})
+		}
+		if len(invalid) > 0 {
+			return &validators.ValidationError{Fields: invalid}
+		}
+		if len(added) == 0 {
+			return nil
+		}
+
+		// A product added on its own meanwhile is already present
+		rows, err = tx.QueryContext(ctx, `
+			INSERT INTO wishlist (user_id, product_id, target_price_cents, target_currency, added_price_cents, added_currency)
+			SELECT $1, i.product_id, i.target_price_cents, i.target_currency, `+productPriceAt("NOW()")+`, COALESCE(p.currency, 'USD')
+			FROM unnest($2::uuid[], $3::bigint[], $4::text[]) WITH ORDINALITY AS i(product_id, target_price_cents, target_currency, n)
+			JOIN products p ON p.id = i.product_id
+			ORDER BY i.n
+			ON CONFLICT (user_id, product_id) DO NOTHING
+			RETURNING product_id`,
+			userID, pq.Array(added), pq.Array(targets), pq.Array(targetCurrencies))
+		if err != nil {
+			return fmt.Errorf("failed to add wishlist items: %w", err)
+		}
+		inserted := make(map[string]bool, len(added))
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan wishlist item: %w", err)
+			}
+			inserted[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to add wishlist items: %w", err)
+		}
+		for _, id := range added {
+			if inserted[id] {
+				result.Added = append(result.Added, id)
+			} else {
+				result.AlreadyPresent = append(result.AlreadyPresent, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// ByteRange is an inclusive range of byte offsets within a blob
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Length returns the number of bytes in the range
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string // strong, quoted entity tag
+	LastModified time.Time
+}
+
+// Blob is an open blob, or a range of one, being read
+type Blob struct {
+	BlobInfo
+	Body io.ReadCloser
+}
+
+// BlobStore stores uploaded files such as product images
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) (*BlobInfo, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	// Get opens the blob, or only rng of it when rng is non-nil. Backends
+	// stream ranges from their storage rather than reading the whole blob.
+	Get(ctx context.Context, key string, rng *ByteRange) (*Blob, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewBlobStore creates the blob store selected in configuration
+func NewBlobStore(cfg config.StorageConfig) (BlobStore, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocalStore(cfg.LocalDir)
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// CleanKey validates a blob key, rejecting absolute paths and traversal
+func CleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned != key || cleaned == "." || strings.HasPrefix(cleaned, "../") || cleaned == ".." {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// LocalStore stores blobs as files under a root directory. Content types
+// are derived from the key's extension.
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore creates a local blob store rooted at dir
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStore{root: dir}, nil
+}
+
+// Put writes a blob, replacing any existing blob atomically
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, contentType string) (*BlobInfo, error) {
+	filename, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return nil, fmt.Errorf("failed to store blob: %w", err)
+	}
+	return s.Stat(ctx, key)
+}
+
+// Stat returns a blob's metadata
+func (s *LocalStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	filename, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filename)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && fi.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	return s.info(key, fi), nil
+}
+
+// Get opens a blob, seeking to the start of rng when given
+func (s *LocalStore) Get(ctx context.Context, key string, rng *ByteRange) (*Blob, error) {
+	filename, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+
+	blob := &Blob{BlobInfo: *s.info(key, fi), Body: f}
+	if rng != nil {
+		if _, err := f.Seek(rng.Start, io.SeekStart); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to seek blob: %w", err)
+		}
+		blob.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, rng.Length()), f}
+	}
+	return blob, nil
+}
+
+// Delete removes a blob; deleting a missing blob is not an error
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStore) info(key string, fi fs.FileInfo) *BlobInfo {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &BlobInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  contentType,
+		ETag:         fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()),
+		LastModified: fi.ModTime(),
+	}
+}
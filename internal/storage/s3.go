@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+// S3Store stores blobs in an S3 bucket
+type S3Store struct {
+	bucket   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewS3Store creates an S3 blob store
+func NewS3Store(cfg config.S3Config) (*S3Store, error) {
+	awsCfg := aws.NewConfig().WithRegion(cfg.Region).WithS3ForcePathStyle(cfg.ForcePathStyle)
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	if cfg.AccessKeyID != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 session: %w", err)
+	}
+	client := s3.New(sess)
+	return &S3Store{
+		bucket:   cfg.Bucket,
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client),
+	}, nil
+}
+
+// Put uploads a blob, streaming large bodies as a multipart upload
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) (*BlobInfo, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload blob: %w", err)
+	}
+	return s.Stat(ctx, key)
+}
+
+// Stat returns a blob's metadata
+func (s *S3Store) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(err, "failed to stat blob")
+	}
+	return &BlobInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		ContentType:  aws.StringValue(out.ContentType),
+		ETag:         aws.StringValue(out.ETag),
+		LastModified: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+// Get opens a blob, passing rng to S3 so only the requested bytes are
+// transferred
+func (s *S3Store) Get(ctx context.Context, key string, rng *ByteRange) (*Blob, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if rng != nil {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", rng.Start, rng.End))
+	}
+
+	out, err := s.client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, s3Error(err, "failed to get blob")
+	}
+
+	size := aws.Int64Value(out.ContentLength)
+	if rng != nil {
+		// ContentLength is the range length; the total comes from Content-Range
+		var start, end int64
+		if _, err := fmt.Sscanf(aws.StringValue(out.ContentRange), "bytes %d-%d/%d", &start, &end, &size); err != nil {
+			out.Body.Close()
+			return nil, fmt.Errorf("unexpected S3 content range %q", aws.StringValue(out.ContentRange))
+		}
+	}
+	return &Blob{
+		BlobInfo: BlobInfo{
+			Key:          key,
+			Size:         size,
+			ContentType:  aws.StringValue(out.ContentType),
+			ETag:         aws.StringValue(out.ETag),
+			LastModified: aws.TimeValue(out.LastModified),
+		},
+		Body: out.Body,
+	}, nil
+}
+
+// Delete removes a blob; deleting a missing blob is not an error
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	key, err := CleanKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return s3Error(err, "failed to delete blob")
+	}
+	return nil
+}
+
+// s3Error maps missing-object errors to ErrNotFound
+func s3Error(err error, msg string) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Response formats chosen by content negotiation
+const (
+	FormatJSON = "json"
+	FormatXML  = "xml"
+)
+
+// NegotiateFormat picks the response format for an Accept header: the
+// supported format with the highest quality, JSON when the header is empty
+// or only has wildcards. It reports false when neither JSON nor XML is
+// acceptable.
+func NegotiateFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return FormatJSON, true
+	}
+	best, bestQ := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(param, "="); ok && strings.TrimSpace(k) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		var format string
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json", "application/*", "*/*":
+			format = FormatJSON
+		case "application/xml", "text/xml":
+			format = FormatXML
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, best != ""
+}
+
+// negotiatedWriter carries the format and language negotiated for a request
+// to the functions writing its response
+type negotiatedWriter struct {
+	http.ResponseWriter
+	format string // empty until negotiated
+	locale string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *negotiatedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// negotiated returns a writer wrapping w that keeps whatever was already
+// negotiated on w
+func negotiated(w http.ResponseWriter) *negotiatedWriter {
+	if nw, ok := w.(*negotiatedWriter); ok {
+		copied := *nw
+		return &copied
+	}
+	return &negotiatedWriter{ResponseWriter: w}
+}
+
+// Rewrap returns inner carrying whatever was negotiated on w, for middleware
+// that replaces w with a writer of its own wrapping it
+func Rewrap(w, inner http.ResponseWriter) http.ResponseWriter {
+	if nw, ok := w.(*negotiatedWriter); ok {
+		copied := *nw
+		copied.ResponseWriter = inner
+		return &copied
+	}
+	return inner
+}
+
+// WithFormat returns w set to write Respond and error responses as format
+func WithFormat(w http.ResponseWriter, format string) http.ResponseWriter {
+	nw := negotiated(w)
+	nw.format = format
+	return nw
+}
+
+// responseFormat returns the format w was set to with WithFormat, or JSON
+func responseFormat(w http.ResponseWriter) string {
+	if nw, ok := w.(*negotiatedWriter); ok && nw.format != "" {
+		return nw.format
+	}
+	return FormatJSON
+}
+
+// Respond writes data as JSON or XML, as negotiated for the request by
+// WithFormat or else from r's Accept header. Clients accepting neither get
+// 406. XML responses need xml struct tags on data.
+func Respond(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	format := FormatJSON
+	if nw, ok := w.(*negotiatedWriter); ok && nw.format != "" {
+		format = nw.format
+	} else {
+		var acceptable bool
+		w.Header().Add("Vary", "Accept")
+		if format, acceptable = NegotiateFormat(r.Header.Get("Accept")); !acceptable {
+			RespondError(w, http.StatusNotAcceptable, "not_acceptable", "Responses are available as application/json or application/xml")
+			return
+		}
+	}
+	if format == FormatXML {
+		respondXML(w, status, data)
+		return
+	}
+	RespondJSON(w, status, data)
+}
+
+// respondXML writes data as an XML document with the given status code
+func respondXML(w http.ResponseWriter, status int, data interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if data != nil {
+		if err := xml.NewEncoder(&buf).Encode(data); err != nil {
+			log.Error().Err(err).Msg("Failed to encode XML response")
+			if env, ok := data.(ErrorResponse); ok && env.Error.Details != nil {
+				// Report the error without the details that didn't encode
+				env.Error.Details = nil
+				respondXML(w, status, env)
+				return
+			}
+			respondXML(w, http.StatusInternalServerError, ErrorResponse{Error: ErrorBody{
+				Code: "internal_error", Message: "Failed to encode response",
+			}})
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// respondEnvelope writes an error envelope in the format set on w
+func respondEnvelope(w http.ResponseWriter, status int, env ErrorResponse) {
+	env.Error.Message = safeErrorMessage(status, env.Error.Message)
+	if responseFormat(w) == FormatXML {
+		respondXML(w, status, env)
+		return
+	}
+	RespondJSON(w, status, env)
+}
+
+// MarshalXML encodes the envelope as <error><code>...</code>...</error>, the
+// XML counterpart of {"error": {"code": ...}}
+func (e ErrorResponse) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	return enc.EncodeElement(e.Error, xml.StartElement{Name: xml.Name{Local: "error"}})
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/greens-marketplace/internal/config"
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ValidatePassword checks pw against the rules of policy, returning a
+// *validators.ValidationError on field password with one entry for each
+// rule it breaks, or nil. The breach check is not run here; it needs the
+// network.
+func ValidatePassword(pw string, policy config.PasswordPolicyConfig) error {
+	var invalid []validators.FieldError
+	fail := func(code, param, message string) {
+		invalid = append(invalid, validators.FieldError{Field: "password", Code: code, Param: param, Message: message})
+	}
+
+	if n := utf8.RuneCountInString(pw); n < policy.MinLength {
+		fail("min_length", strconv.Itoa(policy.MinLength), fmt.Sprintf("password must be at least %d characters long", policy.MinLength))
+	}
+	if policy.MaxLength > 0 && len(pw) > policy.MaxLength {
+		fail("max_length", strconv.Itoa(policy.MaxLength), fmt.Sprintf("password must be at most %d bytes long", policy.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if policy.RequireUpper && !upper {
+		fail("uppercase", "", "password must contain an uppercase letter")
+	}
+	if policy.RequireLower && !lower {
+		fail("lowercase", "", "password must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		fail("digit", "", "password must contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		fail("symbol", "", "password must contain a symbol")
+	}
+
+	if len(invalid) > 0 {
+		return &validators.ValidationError{Fields: invalid}
+	}
+	return nil
+}
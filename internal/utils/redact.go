@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redaction
+//
+// Personal data (emails, addresses, card digits) must not end up in logs or
+// error responses whole. Redact masks a value known to be sensitive,
+// keeping just enough to tell values apart: the first character, and an
+// email's domain. RedactText masks what looks sensitive inside free text,
+// like error messages. The log writer from NewRedactingWriter masks the
+// configured fields of every log line and redacts its other strings, and
+// error envelopes are redacted before they are sent. Outside
+// development, error messages that look like SQL, connection strings or
+// stack traces are replaced with the status text altogether.
+
+var (
+	// emailPattern matches email addresses in text
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// cardPattern matches what could be card numbers: runs of 13 to 19
+	// digits, or four groups of four split by spaces or dashes
+	cardPattern = regexp.MustCompile(`\b(?:\d{13,19}|\d{4}(?:[ \-]\d{4}){3})\b`)
+	// credentialsPattern matches the user info of URLs like connection strings
+	credentialsPattern = regexp.MustCompile(`([a-z][a-z0-9+.\-]*://)[^/\s:@]+(?::[^/\s@]*)?@`)
+	// passwordPattern matches password settings of key=value connection strings
+	passwordPattern = regexp.MustCompile(`(?i)\b(password|passwd|pwd)=\S+`)
+	// internalErrorPattern matches messages that leak internals: SQL, driver
+	// errors, connection strings and stack traces
+	internalErrorPattern = regexp.MustCompile(`\b(SELECT|INSERT|UPDATE|DELETE)\b.+\b(FROM|INTO|SET|WHERE)\b|\bpq: |\bsql: |[a-z]+://[^\s]*@|\bgoroutine \d+|\.go:\d+|\bpanic:`)
+)
+
+var development bool
+
+// SetDevelopment sets whether error messages are sent to clients as they
+// are; outside development those leaking internals are replaced
+func SetDevelopment(dev bool) {
+	development = dev
+}
+
+// Redact masks a sensitive value: an email keeps its first character and
+// its domain, like j***@example.com, and anything else its first character
+// when it is longer than four characters
+func Redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	if local, domain, ok := strings.Cut(value, "@"); ok && local != "" && domain != "" {
+		return local[:1] + "***@" + domain
+	}
+	if len(value) <= 4 {
+		return "***"
+	}
+	return value[:1] + "***"
+}
+
+// RedactText masks the emails, card numbers and credentials in text
+func RedactText(text string) string {
+	text = emailPattern.ReplaceAllStringFunc(text, Redact)
+	text = cardPattern.ReplaceAllString(text, "[card]")
+	text = credentialsPattern.ReplaceAllString(text, "${1}***@")
+	return passwordPattern.ReplaceAllString(text, "${1}=***")
+}
+
+// safeErrorMessage returns message as it may be sent with status: redacted,
+// and outside development the status text when it leaks internals
+func safeErrorMessage(status int, message string) string {
+	if !development && internalErrorPattern.MatchString(message) {
+		return http.StatusText(status)
+	}
+	return RedactText(message)
+}
+
+// redactingWriter masks the configured fields of each JSON log line
+type redactingWriter struct {
+	out    io.Writer
+	fields map[string]bool
+	keys   [][]byte // `"field":` of each field, to skip lines without any
+
+	mu sync.Mutex
+}
+
+// NewRedactingWriter returns a writer for zerolog that writes each JSON log
+// line to out with the values of fields masked by Redact, at any depth, and
+// its other strings, message and error included, redacted by RedactText.
+// zerolog hooks can add fields but not change those already written, so
+// redaction happens here, as each line is written. Lines with nothing to
+// redact are written as they are; lines that aren't JSON are redacted as
+// text.
+func NewRedactingWriter(out io.Writer, fields []string) io.Writer {
+	w := &redactingWriter{out: out, fields: make(map[string]bool, len(fields))}
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		w.fields[field] = true
+		w.keys = append(w.keys, []byte(`"`+field+`":`))
+	}
+	return w
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	line := w.redact(p)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redact returns line with its sensitive values masked
+func (w *redactingWriter) redact(line []byte) []byte {
+	if !w.needsRedaction(line) {
+		return line
+	}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var event map[string]interface{}
+	if err := decoder.Decode(&event); err != nil {
+		return []byte(RedactText(string(line)))
+	}
+	w.redactValue(event)
+	redacted, err := json.Marshal(event)
+	if err != nil {
+		return []byte(RedactText(string(line)))
+	}
+	return append(redacted, '\n')
+}
+
+// needsRedaction reports whether line may hold anything to redact
+func (w *redactingWriter) needsRedaction(line []byte) bool {
+	if bytes.IndexByte(line, '@') >= 0 || cardPattern.Match(line) || passwordPattern.Match(line) {
+		return true
+	}
+	lower := bytes.ToLower(line)
+	for _, key := range w.keys {
+		if bytes.Contains(lower, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue returns v with the configured fields masked and its other
+// strings redacted, changing maps and slices in place
+func (w *redactingWriter) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return RedactText(v)
+	case map[string]interface{}:
+		for key, value := range v {
+			if !w.fields[strings.ToLower(key)] {
+				v[key] = w.redactValue(value)
+				continue
+			}
+			switch value := value.(type) {
+			case string:
+				v[key] = Redact(value)
+			case nil:
+			default:
+				v[key] = "***"
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = w.redactValue(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/greens-marketplace/internal/config"
+)
+
+// leaks are what must never reach a log line or an error envelope whole
+var leaks = []string{"jane.doe@example.com", "4242", "s3cret", "SELECT", "postgres://app"}
+
+func assertNoLeaks(t *testing.T, output string) {
+	t.Helper()
+	for _, leak := range leaks {
+		if strings.Contains(output, leak) {
+			t.Errorf("output contains %q: %s", leak, output)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", ""},
+		{"jane.doe@example.com", "j***@example.com"},
+		{"4242", "***"},
+		{"Jane Doe", "J***"},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.value); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRedactText(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"no account for jane.doe@example.com", "no account for j***@example.com"},
+		{"card 4242424242424242 declined", "card [card] declined"},
+		{"card 4242-4242-4242-4242 declined", "card [card] declined"},
+		{"dial postgres://app:s3cret@db:5432/greens failed", "dial postgres://***@db:5432/greens failed"},
+		{"host=db user=app password=s3cret dbname=greens", "host=db user=app password=*** dbname=greens"},
+		{"order 1234 shipped", "order 1234 shipped"},
+	}
+	for _, tt := range tests {
+		if got := RedactText(tt.text); got != tt.want {
+			t.Errorf("RedactText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(NewRedactingWriter(&buf, config.DefaultConfig().Logging.RedactFields))
+
+	logger.Error().
+		Str("email", "jane.doe@example.com").
+		Str("card_last4", "4242").
+		Err(errors.New("dial postgres://app:s3cret@db:5432/greens: connection refused")).
+		Msg("payment failed for jane.doe@example.com")
+	logger.Info().
+		Dict("customer", zerolog.Dict().Str("Email", "jane.doe@example.com").Str("last4", "4242")).
+		Strs("notified", []string{"jane.doe@example.com"}).
+		Msg("receipt sent")
+	logger.Info().Str("order_id", "order-1").Msg("order placed")
+
+	output := buf.String()
+	assertNoLeaks(t, output)
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want 3: %s", len(lines), output)
+	}
+	for _, line := range lines {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Errorf("log line is not JSON: %v: %s", err, line)
+		}
+	}
+	if !strings.Contains(lines[0], "j***@example.com") {
+		t.Errorf("redacted email lost its domain: %s", lines[0])
+	}
+	if want := `{"level":"info","order_id":"order-1","message":"order placed"}`; lines[2] != want {
+		t.Errorf("line with nothing to redact = %s, want it as written: %s", lines[2], want)
+	}
+}
+
+func TestErrorEnvelopeRedaction(t *testing.T) {
+	defer SetDevelopment(development)
+
+	tests := []struct {
+		name    string
+		dev     bool
+		status  int
+		message string
+		want    string
+	}{
+		{"SQL", false, http.StatusInternalServerError, "failed to run SELECT id FROM users WHERE email = 'jane.doe@example.com'", "Internal Server Error"},
+		{"driver error", false, http.StatusInternalServerError, `pq: duplicate key value violates unique constraint "users_email_key"`, "Internal Server Error"},
+		{"connection string", false, http.StatusBadGateway, "dial postgres://app:s3cret@db:5432/greens: connection refused", "Bad Gateway"},
+		{"email", false, http.StatusNotFound, "no account for jane.doe@example.com", "no account for j***@example.com"},
+		{"card number", false, http.StatusPaymentRequired, "card 4242424242424242 was declined", "card [card] was declined"},
+		{"connection string in development", true, http.StatusBadGateway, "dial postgres://app:s3cret@db:5432/greens: connection refused", "dial postgres://***@db:5432/greens: connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetDevelopment(tt.dev)
+			rec := httptest.NewRecorder()
+			RespondError(rec, tt.status, "error", tt.message)
+
+			if !tt.dev {
+				assertNoLeaks(t, rec.Body.String())
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode error envelope: %v", err)
+			}
+			if body.Error.Message != tt.want {
+				t.Errorf("message = %q, want %q", body.Error.Message, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// DecodeJSON decodes the request body into v
+func DecodeJSON(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// TooManyItemsError is returned by DecodeJSONLimited when a bulk request's
+// array holds more items than the endpoint accepts
+type TooManyItemsError struct {
+	Field string
+	Max   int
+}
+
+func (e *TooManyItemsError) Error() string {
+	return fmt.Sprintf("%s has more than %d items", e.Field, e.Max)
+}
+
+// DecodeJSONLimited decodes a JSON object from the request body into v like
+// DecodeJSON, reading the array under field one item at a time. It fails
+// with a *TooManyItemsError as soon as the array holds more than maxItems items,
+// without reading the rest of the body; maxItems <= 0 means no limit.
+func DecodeJSONLimited(r *http.Request, v interface{}, field string, maxItems int) error {
+	dec := json.NewDecoder(r.Body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		// Keys match fields case-insensitively, as they do for Decode
+		if !strings.EqualFold(key, field) {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			fields[key] = raw
+			continue
+		}
+		items, err := decodeArrayLimited(dec, field, maxItems)
+		if err != nil {
+			return err
+		}
+		fields[key] = items
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeArrayLimited reads the array at dec's position item by item, giving
+// it back as raw JSON. A null is given back as is.
+func decodeArrayLimited(dec *json.Decoder, field string, maxItems int) (json.RawMessage, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		return json.RawMessage("null"), nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("%s must be an array", field)
+	}
+
+	items := []byte{'['}
+	for n := 0; dec.More(); n++ {
+		if maxItems > 0 && n == maxItems {
+			return nil, &TooManyItemsError{Field: field, Max: maxItems}
+		}
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			items = append(items, ',')
+		}
+		items = append(items, item...)
+	}
+	if err := expectDelim(dec, ']'); err != nil {
+		return nil, err
+	}
+	return append(items, ']'), nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v in request body", delim)
+	}
+	return nil
+}
+
+// Global list defaults, used when an endpoint does not set its own. They
+// start at 20 and 100; SetListDefaults sets them from config.
+var (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// SetListDefaults sets the global list defaults. It is meant to be called
+// once at startup, before any request is served.
+func SetListDefaults(limit, maxLimit int) {
+	defaultListLimit, maxListLimit = limit, maxLimit
+}
+
+// ListDefaults configures how ParseListParams reads an endpoint's list
+// parameters. Zero limits fall back to the global list defaults.
+type ListDefaults struct {
+	Limit    int
+	MaxLimit int
+	// Cursor marks a cursor-paginated endpoint: cursor is accepted and
+	// offset is rejected. Other endpoints reject cursor.
+	Cursor bool
+}
+
+// ListParams are the validated list parameters of a request
+type ListParams struct {
+	Limit  int
+	Offset int
+	Cursor string
+	Sort   string
+}
+
+// ParseListParams reads the limit, offset, cursor and sort query parameters.
+// A missing limit uses the endpoint default and a limit above the endpoint
+// maximum is clamped to it; a malformed or non-positive limit, a malformed or
+// negative offset, or a parameter the endpoint does not support is a
+// *validators.ValidationError. Sort values are validated by each endpoint.
+func ParseListParams(r *http.Request, defaults ListDefaults) (ListParams, error) {
+	if defaults.MaxLimit <= 0 {
+		defaults.MaxLimit = maxListLimit
+	}
+	if defaults.Limit <= 0 {
+		defaults.Limit = defaultListLimit
+	}
+	if defaults.Limit > defaults.MaxLimit {
+		defaults.Limit = defaults.MaxLimit
+	}
+
+	q := r.URL.Query()
+	params := ListParams{Limit: defaults.Limit, Cursor: q.Get("cursor"), Sort: q.Get("sort")}
+	var invalid []validators.FieldError
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		switch {
+		case err != nil || limit < 1:
+			invalid = append(invalid, validators.FieldError{Field: "limit", Code: "min", Param: "1", Message: "must be a positive integer"})
+		case limit > defaults.MaxLimit:
+			params.Limit = defaults.MaxLimit
+		default:
+			params.Limit = limit
+		}
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		switch {
+		case defaults.Cursor:
+			invalid = append(invalid, validators.FieldError{Field: "offset", Code: "unsupported", Message: "use cursor to page this list"})
+		case err != nil || offset < 0:
+			invalid = append(invalid, validators.FieldError{Field: "offset", Code: "min", Param: "0", Message: "must be a non-negative integer"})
+		default:
+			params.Offset = offset
+		}
+	}
+
+	if params.Cursor != "" && !defaults.Cursor {
+		invalid = append(invalid, validators.FieldError{Field: "cursor", Code: "unsupported", Message: "use offset to page this list"})
+	}
+
+	if len(invalid) > 0 {
+		return ListParams{}, &validators.ValidationError{Fields: invalid}
+	}
+	return params, nil
+}
+
+// ParseMultipartForm parses a multipart request, holding at most maxMemory
+// bytes of file parts in memory and spilling the rest to temporary files
+func ParseMultipartForm(r *http.Request, maxMemory int64) error {
+	return r.ParseMultipartForm(maxMemory)
+}
+
+// IsBodyTooLarge reports whether err was caused by a request body exceeding its size limit
+func IsBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// RespondDecodeError writes the error response for a failed request body decode
+func RespondDecodeError(w http.ResponseWriter, err error) {
+	if IsBodyTooLarge(err) {
+		RespondError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large")
+		return
+	}
+	var tooMany *TooManyItemsError
+	if errors.As(err, &tooMany) {
+		RespondErrorWithDetails(w, http.StatusRequestEntityTooLarge, "too_many_items",
+			fmt.Sprintf("Too many %s, at most %d are accepted per request", tooMany.Field, tooMany.Max),
+			map[string]interface{}{"field": tooMany.Field, "max": tooMany.Max})
+		return
+	}
+	RespondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+}
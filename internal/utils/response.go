@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// ErrorResponse represents the standard error envelope returned by the API
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody represents the body of an API error
+type ErrorBody struct {
+	Code    string      `json:"code" xml:"code"`
+	Message string      `json:"message" xml:"message"`
+	Details interface{} `json:"details,omitempty" xml:"details>detail,omitempty"`
+}
+
+// RespondJSON writes data as a JSON response with the given status code
+func RespondJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if data != nil {
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// RespondError writes an error envelope with the given status code, as XML
+// when XML was negotiated for the request
+func RespondError(w http.ResponseWriter, status int, code, message string) {
+	respondEnvelope(w, status, ErrorResponse{Error: ErrorBody{Code: code, Message: message}})
+}
+
+// RespondErrorWithDetails writes an error envelope carrying additional details
+func RespondErrorWithDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	respondEnvelope(w, status, ErrorResponse{Error: ErrorBody{Code: code, Message: message, Details: details}})
+}
+
+// RespondValidationError writes a 400 response listing each failed validation
+// rule by code, with messages in the language set on w with WithLocale
+func RespondValidationError(w http.ResponseWriter, err error) {
+	var verr *validators.ValidationError
+	if errors.As(err, &verr) {
+		locale := responseLocale(w)
+		message, ok := Translate(locale, "validation_error", "", "")
+		if !ok {
+			message = "Validation failed"
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", locale)
+		RespondErrorWithDetails(w, http.StatusBadRequest, "validation_error", message, TranslateFields(locale, verr.Fields))
+		return
+	}
+	RespondError(w, http.StatusBadRequest, "validation_error", err.Error())
+}
@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Outbound requests to user-supplied URLs
+//
+// A URL a user gives us can point anywhere, including at our own network.
+// The safe client only allows the configured schemes and ports, and it checks
+// the address it is about to connect to, not the host name in the URL. A name
+// can resolve to a public address when it is checked and to a private one
+// when it is dialled (DNS rebinding), so only the dial-time check counts.
+// Redirects are checked the same way as the original URL.
+
+var (
+	ErrUnsafeURL        = errors.New("url is not allowed")
+	ErrUnsafeAddress    = errors.New("address is not public")
+	ErrResponseTooLarge = errors.New("response body too large")
+)
+
+// SafeHTTPOptions restricts what a SafeHTTPClient fetches. Zero values use
+// the defaults noted on each field.
+type SafeHTTPOptions struct {
+	Schemes      []string      // allowed URL schemes; http and https when empty
+	Ports        []int         // allowed ports; 80 and 443 when empty
+	MaxRedirects int           // redirects followed; 0 returns the redirect response as is
+	MaxBytes     int64         // response body cap; 1MB when zero
+	Timeout      time.Duration // whole request timeout; 10s when zero
+	Resolver     *net.Resolver // resolves host names at dial time; net.DefaultResolver when nil
+}
+
+func (o SafeHTTPOptions) withDefaults() SafeHTTPOptions {
+	if len(o.Schemes) == 0 {
+		o.Schemes = []string{"http", "https"}
+	}
+	if len(o.Ports) == 0 {
+		o.Ports = []int{80, 443}
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 1 << 20
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// nonPublicNetworks are the ranges, beyond those the net.IP predicates
+// cover, that are not routable on the internet
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved, including broadcast
+	"64:ff9b::/96",  // NAT64, which reaches IPv4 addresses of any kind
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// IsPublicIP reports whether ip is a globally routable unicast address.
+// IPv4-mapped IPv6 addresses are judged by their IPv4 address.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL parses rawURL and checks its scheme and port against opts, that it
+// carries no credentials, and that a host given as an IP address is public.
+// Host names are not resolved; use LookupPublicHost for an early check.
+func CheckURL(rawURL string, opts SafeHTTPOptions) (*url.URL, error) {
+	opts = opts.withDefaults()
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: not an absolute URL", ErrUnsafeURL)
+	}
+	if !slices.Contains(opts.Schemes, u.Scheme) {
+		return nil, fmt.Errorf("%w: scheme %q is not allowed", ErrUnsafeURL, u.Scheme)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("%w: credentials are not allowed", ErrUnsafeURL)
+	}
+	if port := urlPort(u); !slices.Contains(opts.Ports, port) {
+		return nil, fmt.Errorf("%w: port %d is not allowed", ErrUnsafeURL, port)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !IsPublicIP(ip) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsafeAddress, ip)
+	}
+	return u, nil
+}
+
+// urlPort returns u's port, or its scheme's default port; unknown schemes
+// without a port give 0
+func urlPort(u *url.URL) int {
+	if p := u.Port(); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return 0
+		}
+		return port
+	}
+	switch u.Scheme {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return 0
+}
+
+// LookupPublicHost resolves host and checks that every address it resolves
+// to is public. It gives a clear error when a URL is submitted, but it does
+// not protect the later fetch: the address may change before then, which is
+// why SafeDialer checks again at connect time.
+func LookupPublicHost(ctx context.Context, host string) error {
+	return lookupPublicHost(ctx, net.DefaultResolver, host)
+}
+
+func lookupPublicHost(ctx context.Context, resolver *net.Resolver, host string) error {
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: host %s could not be resolved", ErrUnsafeURL, host)
+	}
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP) {
+			return fmt.Errorf("%w: host %s resolves to %s", ErrUnsafeAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// SafeDialer returns a dialer that only connects to public addresses on the
+// ports opts allows. The check runs after name resolution, on the address
+// actually being connected to.
+func SafeDialer(opts SafeHTTPOptions) *net.Dialer {
+	opts = opts.withDefaults()
+	return &net.Dialer{
+		Timeout:   opts.Timeout,
+		KeepAlive: 30 * time.Second,
+		Resolver:  opts.Resolver,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrUnsafeAddress, address)
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrUnsafeAddress, host)
+			}
+			if p, err := strconv.Atoi(port); err != nil || !slices.Contains(opts.Ports, p) {
+				return fmt.Errorf("%w: port %s is not allowed", ErrUnsafeAddress, port)
+			}
+			return nil
+		},
+	}
+}
+
+// SafeHTTPClient fetches user-supplied URLs, refusing those that lead to
+// non-public addresses
+type SafeHTTPClient struct {
+	client *http.Client
+	opts   SafeHTTPOptions
+}
+
+// NewSafeHTTPClient creates a new safe HTTP client
+func NewSafeHTTPClient(opts SafeHTTPOptions) *SafeHTTPClient {
+	opts = opts.withDefaults()
+	transport := &http.Transport{
+		// A proxy would make the connection for us, out of the dialer's sight
+		Proxy:                 nil,
+		DialContext:           SafeDialer(opts).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &SafeHTTPClient{
+		opts: opts,
+		client: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if opts.MaxRedirects == 0 {
+					return http.ErrUseLastResponse
+				}
+				if len(via) > opts.MaxRedirects {
+					return fmt.Errorf("%w: more than %d redirects", ErrUnsafeURL, opts.MaxRedirects)
+				}
+				_, err := CheckURL(req.URL.String(), opts)
+				return err
+			},
+		},
+	}
+}
+
+// Do checks req's URL and sends it. Reading more than MaxBytes of the
+// response body fails with ErrResponseTooLarge.
+func (c *SafeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	defer TrackTiming(req.Context(), "external")()
+	if _, err := CheckURL(req.URL.String(), c.opts); err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &cappedBody{ReadCloser: resp.Body, remaining: c.opts.MaxBytes}
+	return resp, nil
+}
+
+// Get fetches rawURL and returns the response with its body read
+func (c *SafeHTTPClient) Get(ctx context.Context, rawURL string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrUnsafeURL, err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// SafeHTTPGet fetches a user-supplied URL with a one-off SafeHTTPClient.
+// Callers fetching repeatedly should keep a client instead.
+func SafeHTTPGet(ctx context.Context, rawURL string, opts SafeHTTPOptions) (*http.Response, []byte, error) {
+	return NewSafeHTTPClient(opts).Get(ctx, rawURL)
+}
+
+// cappedBody fails reads past its remaining byte budget
+type cappedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Only an error if there was more to read
+		var one [1]byte
+		if n, _ := b.ReadCloser.Read(one[:]); n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
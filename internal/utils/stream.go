@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// streamFlushRows is how many elements or records the stream helpers write
+// between flushes
+const streamFlushRows = 100
+
+// StreamJSONArray writes a 200 JSON array of the rows scanned by scan, each
+// encoded as soon as it is scanned, so memory stays flat however many rows
+// there are. The response is flushed every streamFlushRows elements.
+//
+// An error before the first row is scanned is returned, with nothing
+// written, for the caller to respond with. Once the array has started the
+// status can no longer change, so an error scanning or reading rows is
+// logged and the connection aborted with http.ErrAbortHandler; the client
+// sees a truncated response rather than a well-formed partial array. An
+// error writing to the client just ends the stream.
+func StreamJSONArray[T any](w http.ResponseWriter, rows *sql.Rows, scan func(rows *sql.Rows) (T, error)) error {
+	rc := http.NewResponseController(w)
+	started := false
+	fail := func(err error) error {
+		if !started {
+			return err
+		}
+		log.Error().Err(err).Msg("Streamed response failed, aborting it")
+		panic(http.ErrAbortHandler)
+	}
+
+	n := 0
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return fail(err)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fail(fmt.Errorf("failed to encode streamed row: %w", err))
+		}
+		sep := ","
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			started, sep = true, "["
+		}
+		if _, err := w.Write(append([]byte(sep), data...)); err != nil {
+			return nil
+		}
+		if n++; n%streamFlushRows == 0 {
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return nil
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+
+	if !started {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("[]\n"))
+		return nil
+	}
+	w.Write([]byte("]\n"))
+	return nil
+}
+
+// StreamCSV writes a 200 CSV of header followed by a record for each of the
+// rows scanned by scan, each written as soon as it is scanned, flushing
+// every streamFlushRows records. Errors are handled as by StreamJSONArray,
+// the header counting as the start of the response.
+func StreamCSV(w http.ResponseWriter, header []string, rows *sql.Rows, scan func(rows *sql.Rows) ([]string, error)) error {
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	started := false
+	fail := func(err error) error {
+		if !started {
+			return err
+		}
+		log.Error().Err(err).Msg("Streamed response failed, aborting it")
+		panic(http.ErrAbortHandler)
+	}
+	start := func() error {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		started = true
+		return cw.Write(header)
+	}
+
+	n := 0
+	for rows.Next() {
+		record, err := scan(rows)
+		if err != nil {
+			return fail(err)
+		}
+		if !started {
+			if err := start(); err != nil {
+				return nil
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return nil
+		}
+		if n++; n%streamFlushRows == 0 {
+			cw.Flush()
+			if cw.Error() != nil {
+				return nil
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return nil
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+
+	if !started {
+		if err := start(); err != nil {
+			return nil
+		}
+	}
+	cw.Flush()
+	return nil
+}
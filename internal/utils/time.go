@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"errors"
+	"time"
+)
+
+// Timestamps
+//
+// Times are UTC throughout: the process runs with time.Local set to UTC, the
+// database session's time zone is UTC, and columns are timestamptz, so every
+// time.Time the API holds is in UTC and is serialized as RFC 3339 with a Z
+// offset. Times sent by clients must carry an explicit offset, which
+// RFC 3339 requires; they are converted to UTC as they are read.
+
+// UseUTC makes UTC the process's local time zone, so times taken from the
+// clock are UTC like those read from the database
+func UseUTC() {
+	time.Local = time.UTC
+}
+
+// ErrTimeOffset is returned by ParseTime for a time without an offset
+var ErrTimeOffset = errors.New("time must be RFC 3339 with an explicit offset, such as 2006-01-02T15:04:05Z")
+
+// ParseTime parses a client-supplied RFC 3339 time, fractional seconds
+// allowed, and returns it in UTC. Times without an offset are rejected
+// rather than read in some local time zone.
+func ParseTime(raw string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, ErrTimeOffset
+	}
+	return t.UTC(), nil
+}
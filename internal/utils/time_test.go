@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greens-marketplace/internal/models"
+)
+
+func TestParseTime(t *testing.T) {
+	valid := []struct {
+		raw  string
+		want time.Time
+	}{
+		{"2026-03-01T10:00:00Z", time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{"2026-03-01T12:00:00+02:00", time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{"2026-03-01T05:30:00-04:30", time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{"2026-03-01T10:00:00.25Z", time.Date(2026, 3, 1, 10, 0, 0, 250000000, time.UTC)},
+	}
+	for _, tt := range valid {
+		got, err := ParseTime(tt.raw)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", tt.raw, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("ParseTime(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"2026-03-01T10:00:00", "2026-03-01 10:00:00", "2026-03-01T10:00", "2026-03-01", "1772359200", ""} {
+		if _, err := ParseTime(raw); !errors.Is(err, ErrTimeOffset) {
+			t.Errorf("ParseTime(%q) = %v, want ErrTimeOffset", raw, err)
+		}
+	}
+}
+
+// timestampPattern matches the strings in a response that are timestamps
+var timestampPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T`)
+
+// timestamps collects every timestamp string in a decoded JSON document
+func timestamps(v any, found *[]string) {
+	switch v := v.(type) {
+	case string:
+		if timestampPattern.MatchString(v) {
+			*found = append(*found, v)
+		}
+	case map[string]any:
+		for _, e := range v {
+			timestamps(e, found)
+		}
+	case []any:
+		for _, e := range v {
+			timestamps(e, found)
+		}
+	}
+}
+
+func TestResponseTimestampsAreUTC(t *testing.T) {
+	// start from a server whose zone isn't UTC
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("CEST", 2*60*60)
+	UseUTC()
+
+	requested, err := ParseTime("2026-03-01T12:00:00+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	order := models.Order{
+		ID:        "order-1",
+		CreatedAt: now,
+		UpdatedAt: requested,
+		Items:     []models.OrderItem{{ID: "item-1", FulfilledAt: &now, CancelledAt: &requested}},
+		Delivery:  &models.OrderDelivery{DeliveredAt: now.Add(time.Hour)},
+	}
+
+	rec := httptest.NewRecorder()
+	RespondJSON(rec, 200, order)
+
+	var decoded any
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	var found []string
+	timestamps(decoded, &found)
+	if len(found) != 5 {
+		t.Fatalf("found %d timestamps, want 5: %s", len(found), rec.Body)
+	}
+	for _, ts := range found {
+		if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+			t.Errorf("timestamp %q is not RFC 3339: %v", ts, err)
+		}
+		if !strings.HasSuffix(ts, "Z") {
+			t.Errorf("timestamp %q is not UTC", ts)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request timings
+//
+// In development, middleware.ServerTiming records how long each request
+// spends in phases such as auth, db, cache and external, and reports them in
+// a Server-Timing header that browser dev tools show. Code contributes with
+// TrackTiming or RecordTiming. Until EnableTimings is called both return at
+// once, so outside development they cost one atomic load.
+
+var timingsEnabled atomic.Bool
+
+// EnableTimings turns request timings on for the process
+func EnableTimings() {
+	timingsEnabled.Store(true)
+}
+
+type timingsKey struct{}
+
+// phaseTiming is the time a request spent in one phase
+type phaseTiming struct {
+	label    string
+	count    int
+	duration time.Duration
+}
+
+// Timings are the phase timings of a request
+type Timings struct {
+	mu     sync.Mutex
+	phases []*phaseTiming // in the order first recorded
+}
+
+// WithTimings returns ctx recording phase timings into the returned Timings
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+func noTiming() {}
+
+// TrackTiming starts timing phase label of ctx's request, returning the
+// function that ends it:
+//
+//	defer utils.TrackTiming(ctx, "db")()
+//
+// Time a phase spends in several calls, or in concurrent ones, adds up.
+func TrackTiming(ctx context.Context, label string) func() {
+	if !timingsEnabled.Load() {
+		return noTiming
+	}
+	start := time.Now()
+	return func() { RecordTiming(ctx, label, time.Since(start)) }
+}
+
+// RecordTiming adds d to phase label of ctx's request
+func RecordTiming(ctx context.Context, label string, d time.Duration) {
+	if !timingsEnabled.Load() {
+		return
+	}
+	t, ok := ctx.Value(timingsKey{}).(*Timings)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, phase := range t.phases {
+		if phase.label == label {
+			phase.count++
+			phase.duration += d
+			return
+		}
+	}
+	t.phases = append(t.phases, &phaseTiming{label: label, count: 1, duration: d})
+}
+
+// Header renders the timings as a Server-Timing header value, with the total
+// time since start last
+func (t *Timings) Header(start time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, 0, len(t.phases)+1)
+	for _, phase := range t.phases {
+		parts = append(parts, fmt.Sprintf(`%s;dur=%.1f;desc="%d calls"`, phase.label, millis(phase.duration), phase.count))
+	}
+	parts = append(parts, fmt.Sprintf("total;dur=%.1f", millis(time.Since(start))))
+	return strings.Join(parts, ", ")
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/greens-marketplace/internal/validators"
+)
+
+// DefaultLocale is the language of messages built into the API
+const DefaultLocale = "en"
+
+// codeAliases maps validation codes to the code whose message they share
+var codeAliases = map[string]string{
+	"required_if":      "required",
+	"required_without": "required",
+	"gte":              "min",
+	"lte":              "max",
+	"uuid4":            "uuid",
+}
+
+// catalogs holds the validation messages of each supported locale besides
+// English, keyed by code. {field} and {param} are replaced by the failed
+// field and rule parameter.
+var catalogs = map[string]map[string]string{
+	"de": {
+		"validation_error": "Validierung fehlgeschlagen",
+		"required":         "{field} ist erforderlich",
+		"min":              "{field} muss mindestens {param} sein",
+		"max":              "{field} darf höchstens {param} sein",
+		"gt":               "{field} muss größer als {param} sein",
+		"lt":               "{field} muss kleiner als {param} sein",
+		"oneof":            "{field} muss einer der folgenden Werte sein: {param}",
+		"email":            "{field} muss eine gültige E-Mail-Adresse sein",
+		"uuid":             "{field} muss eine gültige ID sein",
+		"unique":           "{field} darf keine Duplikate enthalten",
+		"len":              "{field} muss die Länge {param} haben",
+	},
+	"es": {
+		"validation_error": "La validación ha fallado",
+		"required":         "{field} es obligatorio",
+		"min":              "{field} debe ser como mínimo {param}",
+		"max":              "{field} debe ser como máximo {param}",
+		"gt":               "{field} debe ser mayor que {param}",
+		"lt":               "{field} debe ser menor que {param}",
+		"oneof":            "{field} debe ser uno de: {param}",
+		"email":            "{field} debe ser una dirección de correo electrónico válida",
+		"uuid":             "{field} debe ser un identificador válido",
+		"unique":           "{field} no debe contener duplicados",
+		"len":              "{field} debe tener una longitud de {param}",
+	},
+	"fr": {
+		"validation_error": "La validation a échoué",
+		"required":         "{field} est obligatoire",
+		"min":              "{field} doit valoir au moins {param}",
+		"max":              "{field} doit valoir au plus {param}",
+		"gt":               "{field} doit être supérieur à {param}",
+		"lt":               "{field} doit être inférieur à {param}",
+		"oneof":            "{field} doit être l'une des valeurs suivantes : {param}",
+		"email":            "{field} doit être une adresse e-mail valide",
+		"uuid":             "{field} doit être un identifiant valide",
+		"unique":           "{field} ne doit pas contenir de doublons",
+		"len":              "{field} doit avoir une longueur de {param}",
+	},
+	"pt": {
+		"validation_error": "A validação falhou",
+		"required":         "{field} é obrigatório",
+		"min":              "{field} deve ser no mínimo {param}",
+		"max":              "{field} deve ser no máximo {param}",
+		"gt":               "{field} deve ser maior que {param}",
+		"lt":               "{field} deve ser menor que {param}",
+		"oneof":            "{field} deve ser um dos seguintes: {param}",
+		"email":            "{field} deve ser um endereço de e-mail válido",
+		"uuid":             "{field} deve ser um identificador válido",
+		"unique":           "{field} não deve conter duplicados",
+		"len":              "{field} deve ter comprimento {param}",
+	},
+}
+
+// NegotiateLanguage picks the supported locale an Accept-Language header
+// prefers, matching on the primary language subtag so fr-CA gets fr. It
+// returns DefaultLocale when no listed language is supported.
+func NegotiateLanguage(acceptLanguage string) string {
+	for _, tag := range LanguagePreferences(acceptLanguage) {
+		primary, _, _ := strings.Cut(tag, "-")
+		if _, ok := catalogs[primary]; ok || primary == DefaultLocale {
+			return primary
+		}
+	}
+	return DefaultLocale
+}
+
+// LanguagePreferences returns the language tags an Accept-Language header
+// lists, lowercased and most preferred first, leaving out the wildcard and
+// those refused with q=0
+func LanguagePreferences(acceptLanguage string) []string {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+	var candidates []candidate
+	for _, languageRange := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(languageRange, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(param, "="); ok && strings.TrimSpace(k) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && tag != "*" && q > 0 {
+			candidates = append(candidates, candidate{tag: tag, q: q})
+		}
+	}
+	// Stable, so equally preferred languages keep the client's order
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	tags := make([]string, len(candidates))
+	for i, c := range candidates {
+		tags[i] = c.tag
+	}
+	return tags
+}
+
+// WithLocale returns w set to write validation errors in locale
+func WithLocale(w http.ResponseWriter, locale string) http.ResponseWriter {
+	nw := negotiated(w)
+	nw.locale = locale
+	return nw
+}
+
+// responseLocale returns the locale w was set to with WithLocale, or
+// DefaultLocale
+func responseLocale(w http.ResponseWriter) string {
+	if nw, ok := w.(*negotiatedWriter); ok && nw.locale != "" {
+		return nw.locale
+	}
+	return DefaultLocale
+}
+
+// Translate returns the message for code in locale, with {field} and
+// {param} filled in. It reports false when locale has no message for code,
+// as for English, whose messages are built in where they are raised.
+func Translate(locale, code, field, param string) (string, bool) {
+	if alias, ok := codeAliases[code]; ok {
+		code = alias
+	}
+	template, ok := catalogs[locale][code]
+	if !ok {
+		return "", false
+	}
+	return strings.NewReplacer("{field}", field, "{param}", param).Replace(template), true
+}
+
+// TranslateFields returns fields with their messages in locale. Fields whose
+// code has no message in locale keep their English message.
+func TranslateFields(locale string, fields []validators.FieldError) []validators.FieldError {
+	translated := make([]validators.FieldError, len(fields))
+	for i, f := range fields {
+		if message, ok := Translate(locale, f.Code, f.Field, f.Param); ok {
+			f.Message = message
+		}
+		translated[i] = f
+	}
+	return translated
+}
@@ -0,0 +1,102 @@
+package validators
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var validate = newValidator()
+
+// FieldError describes a single failed validation rule
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Code    string `json:"code" xml:"code"` // the failed rule, e.g. required, min, email
+	Param   string `json:"param,omitempty" xml:"param,omitempty"`
+	Message string `json:"message" xml:"message"`
+}
+
+// ValidationError is returned when a struct fails validation
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Message
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	// Report fields by their JSON names so errors match the request payload
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
+
+// Validate validates s against its `validate` struct tags, returning a
+// *ValidationError describing every failed rule
+func Validate(s interface{}) error {
+	err := validate.Struct(s)
+	if err == nil {
+		return nil
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+
+	fields := make([]FieldError, len(verrs))
+	for i, fe := range verrs {
+		fields[i] = FieldError{
+			Field:   fe.Field(),
+			Code:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: message(fe),
+		}
+	}
+	return &ValidationError{Fields: fields}
+}
+
+// message renders an English message for a failed rule
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_if":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "min", "gte":
+		return fmt.Sprintf("%s must be at least %s", fe.Field(), fe.Param())
+	case "max", "lte":
+		return fmt.Sprintf("%s must be at most %s", fe.Field(), fe.Param())
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", fe.Field(), fe.Param())
+	case "lt":
+		return fmt.Sprintf("%s must be less than %s", fe.Field(), fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", fe.Field(), fe.Param())
+	case "email":
+		return fmt.Sprintf("%s must be a valid email address", fe.Field())
+	case "uuid", "uuid4":
+		return fmt.Sprintf("%s must be a valid id", fe.Field())
+	case "unique":
+		return fmt.Sprintf("%s must not contain duplicates", fe.Field())
+	case "len":
+		return fmt.Sprintf("%s must have length %s", fe.Field(), fe.Param())
+	default:
+		return fmt.Sprintf("%s is invalid", fe.Field())
+	}
+}
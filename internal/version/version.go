@@ -0,0 +1,43 @@
+// Package version holds the build information of the running binary. The
+// values are injected at link time, for example:
+//
+//	go build -ldflags "-X github.com/greens-marketplace/internal/version.Version=1.4.0 \
+//		-X github.com/greens-marketplace/internal/version.Commit=$(git rev-parse HEAD) \
+//		-X github.com/greens-marketplace/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set with -ldflags -X
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// GoVersion is the Go release the binary was built with
+var GoVersion = runtime.Version()
+
+func init() {
+	// Fall back to the VCS stamp go build records for untagged local builds
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && Commit == "unknown":
+			Commit = s.Value
+		case s.Key == "vcs.time" && BuildTime == "unknown":
+			BuildTime = s.Value
+		}
+	}
+}
+
+// UserAgent is the User-Agent sent on outbound requests
+func UserAgent() string {
+	return "greens-marketplace/" + Version
+}
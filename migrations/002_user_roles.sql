@@ -0,0 +1,4 @@
+-- Add roles to users for staff and seller permissions
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user'; -- user, seller, admin
+
+CREATE INDEX idx_users_role ON users(role);
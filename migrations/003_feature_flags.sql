@@ -0,0 +1,15 @@
+-- Create feature flags table
+CREATE TABLE feature_flags (
+    key VARCHAR(100) PRIMARY KEY,
+    description TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    rollout_percentage INTEGER NOT NULL DEFAULT 100 CHECK (rollout_percentage >= 0 AND rollout_percentage <= 100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TRIGGER update_feature_flags_updated_at BEFORE UPDATE ON feature_flags FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Seed flags for features that are rolled out gradually
+INSERT INTO feature_flags (key, description, enabled, rollout_percentage) VALUES
+('semantic_search', 'AI-powered semantic product search', true, 100);
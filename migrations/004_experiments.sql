@@ -0,0 +1,13 @@
+-- Create experiment events table for A/B test exposure and conversion tracking
+CREATE TABLE experiment_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    experiment VARCHAR(100) NOT NULL,
+    variant VARCHAR(100) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_type VARCHAR(20) NOT NULL, -- exposure, conversion
+    event_name VARCHAR(100), -- conversion name, e.g. search_click, purchase
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_experiment_events_lookup ON experiment_events(experiment, created_at);
+CREATE INDEX idx_experiment_events_variant ON experiment_events(experiment, variant, event_type);
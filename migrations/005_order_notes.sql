@@ -0,0 +1,21 @@
+-- Create order notes table for customer instructions and internal support comments
+CREATE TABLE order_notes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    author_id UUID NOT NULL REFERENCES users(id),
+    visibility VARCHAR(20) NOT NULL DEFAULT 'customer' CHECK (visibility IN ('customer', 'internal')),
+    body TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_notes_order ON order_notes(order_id, created_at);
+
+-- Notes are append-only
+CREATE OR REPLACE FUNCTION prevent_order_note_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'order notes are immutable';
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER prevent_order_notes_update BEFORE UPDATE ON order_notes FOR EACH ROW EXECUTE FUNCTION prevent_order_note_update();
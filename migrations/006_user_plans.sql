@@ -0,0 +1,2 @@
+-- Add subscription plans to users for per-plan quotas
+ALTER TABLE users ADD COLUMN plan VARCHAR(20) NOT NULL DEFAULT 'free'; -- free, pro, business
@@ -0,0 +1,19 @@
+-- Create stock adjustments recording seller restocks and shrinkage for reconciliation
+CREATE TABLE stock_adjustments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    seller_id UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE stock_adjustment_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    adjustment_id UUID NOT NULL REFERENCES stock_adjustments(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id),
+    delta INTEGER NOT NULL,
+    previous_quantity INTEGER NOT NULL,
+    stock_quantity INTEGER NOT NULL CHECK (stock_quantity >= 0)
+);
+
+CREATE INDEX idx_stock_adjustments_seller ON stock_adjustments(seller_id, created_at);
+CREATE INDEX idx_stock_adjustment_items_adjustment ON stock_adjustment_items(adjustment_id);
+CREATE INDEX idx_stock_adjustment_items_product ON stock_adjustment_items(product_id);
@@ -0,0 +1,29 @@
+-- Create stock ledger recording every change to product stock
+CREATE TABLE stock_movements (
+    id BIGSERIAL PRIMARY KEY,
+    product_id UUID NOT NULL REFERENCES products(id),
+    delta INTEGER NOT NULL,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('initial', 'adjustment', 'correction', 'order', 'cancellation', 'reservation', 'release')),
+    reference_id VARCHAR(100), -- e.g. order or stock adjustment id
+    actor_id UUID REFERENCES users(id),
+    balance INTEGER NOT NULL, -- product stock after this movement
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_stock_movements_product ON stock_movements(product_id, id);
+
+-- Open the ledger with each product's current stock so balances reconcile
+INSERT INTO stock_movements (product_id, delta, reason, balance)
+SELECT id, stock_quantity, 'initial', stock_quantity
+FROM products
+WHERE COALESCE(stock_quantity, 0) <> 0;
+
+-- Movements are append-only
+CREATE OR REPLACE FUNCTION prevent_stock_movement_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'stock movements are immutable';
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER prevent_stock_movements_update BEFORE UPDATE OR DELETE ON stock_movements FOR EACH ROW EXECUTE FUNCTION prevent_stock_movement_update();
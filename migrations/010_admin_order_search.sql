@@ -0,0 +1,13 @@
+-- Support admin order search: human-readable order numbers, status/date
+-- keyset indexes, and trigram indexes for customer email/name search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE orders ADD COLUMN order_number BIGSERIAL;
+CREATE UNIQUE INDEX idx_orders_order_number ON orders(order_number);
+
+CREATE INDEX idx_orders_status_created ON orders(status, created_at DESC, id DESC);
+CREATE INDEX idx_orders_created ON orders(created_at DESC, id DESC);
+
+CREATE INDEX idx_users_email_lower ON users(LOWER(email));
+CREATE INDEX idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX idx_users_full_name_trgm ON users USING GIN (full_name gin_trgm_ops);
@@ -0,0 +1,14 @@
+-- Create search query log for search analytics. Only the normalized query,
+-- result count, latency and the searcher's opaque user ID are kept.
+CREATE TABLE search_queries (
+    id UUID PRIMARY KEY,
+    query TEXT NOT NULL,
+    category_id UUID,
+    result_count INTEGER NOT NULL,
+    latency_ms INTEGER NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_search_queries_created ON search_queries(created_at);
+CREATE INDEX idx_search_queries_zero_results ON search_queries(created_at) WHERE result_count = 0;
@@ -0,0 +1,20 @@
+-- Add bundle products: a bundle sells other products together at a fixed
+-- price or a percentage off their total, and has no stock of its own
+ALTER TABLE products
+    ADD COLUMN product_type VARCHAR(20) NOT NULL DEFAULT 'simple' CHECK (product_type IN ('simple', 'bundle')),
+    ADD COLUMN bundle_pricing VARCHAR(20) CHECK (bundle_pricing IN ('fixed', 'percent_off')),
+    ADD COLUMN bundle_discount_percent DECIMAL(5,2) CHECK (bundle_discount_percent > 0 AND bundle_discount_percent < 100);
+
+CREATE TABLE product_bundle_items (
+    bundle_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id),
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (bundle_id, product_id),
+    CHECK (bundle_id <> product_id)
+);
+
+CREATE INDEX idx_product_bundle_items_product ON product_bundle_items(product_id);
+
+-- Checkout records component stock movements against the order, and
+-- cancellation reverses them
+CREATE INDEX idx_stock_movements_reference ON stock_movements(reference_id) WHERE reference_id IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Add per-product order quantity rules: a line must hold at least
+-- min_order_qty, at most max_order_qty (no cap when NULL), in steps of
+-- step_qty from the minimum
+ALTER TABLE products
+    ADD COLUMN min_order_qty INTEGER NOT NULL DEFAULT 1 CHECK (min_order_qty >= 1),
+    ADD COLUMN max_order_qty INTEGER,
+    ADD COLUMN step_qty INTEGER NOT NULL DEFAULT 1 CHECK (step_qty >= 1),
+    ADD CONSTRAINT products_order_qty_range CHECK (max_order_qty IS NULL OR max_order_qty >= min_order_qty);
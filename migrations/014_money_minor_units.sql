@@ -0,0 +1,108 @@
+-- Store money as integer minor units of its currency (cents for USD, yen for
+-- JPY) so that line and order totals add up exactly
+
+-- Decimal places in a currency's minor unit; keep in step with money.Exponent
+CREATE FUNCTION currency_exponent(code TEXT) RETURNS INTEGER AS $$
+    SELECT CASE
+        WHEN code IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW',
+            'PYG', 'RWF', 'UGX', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 0
+        WHEN code IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 3
+        ELSE 2
+    END
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE products ADD COLUMN price_cents BIGINT;
+UPDATE products SET price_cents = ROUND(price * 10::numeric ^ currency_exponent(COALESCE(currency, 'USD')));
+ALTER TABLE products
+    ALTER COLUMN price_cents SET NOT NULL,
+    ADD CONSTRAINT products_price_cents_check CHECK (price_cents >= 0);
+
+-- search_products_semantic returns the old price column
+DROP FUNCTION search_products_semantic(TEXT, UUID, INTEGER, INTEGER);
+ALTER TABLE products DROP COLUMN price;
+CREATE INDEX idx_products_price_cents ON products(price_cents);
+
+CREATE FUNCTION search_products_semantic(
+    query_text TEXT,
+    category_filter UUID DEFAULT NULL,
+    limit_results INTEGER DEFAULT 10,
+    offset_results INTEGER DEFAULT 0
+)
+RETURNS TABLE(
+    product_id UUID,
+    title VARCHAR,
+    description TEXT,
+    price_cents BIGINT,
+    currency VARCHAR,
+    seller_id UUID,
+    similarity FLOAT
+) AS $$
+BEGIN
+    RETURN QUERY
+    SELECT
+        p.id,
+        p.title,
+        p.description,
+        p.price_cents,
+        p.currency,
+        p.seller_id,
+        (pe.combined_embedding <=> (
+            SELECT vectorized_text
+            FROM (
+                SELECT openai_embed_text(query_text) as vectorized_text
+            ) t
+        )) as similarity
+    FROM products p
+    JOIN product_embeddings pe ON p.id = pe.product_id
+    WHERE p.is_active = true
+    AND (category_filter IS NULL OR p.category_id = category_filter)
+    ORDER BY pe.combined_embedding <=> (
+        SELECT vectorized_text
+        FROM (
+            SELECT openai_embed_text(query_text) as vectorized_text
+        ) t
+    )
+    LIMIT limit_results
+    OFFSET offset_results;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Orders keep their totals broken down; total_cents is always
+-- subtotal_cents - discount_cents + tax_cents
+ALTER TABLE orders
+    ADD COLUMN subtotal_cents BIGINT,
+    ADD COLUMN discount_cents BIGINT NOT NULL DEFAULT 0 CHECK (discount_cents >= 0),
+    ADD COLUMN tax_cents BIGINT NOT NULL DEFAULT 0 CHECK (tax_cents >= 0),
+    ADD COLUMN total_cents BIGINT,
+    ADD COLUMN escrow_cents BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN escrow_fee_cents BIGINT NOT NULL DEFAULT 0;
+UPDATE orders SET
+    total_cents = ROUND(total_amount * 10::numeric ^ currency_exponent(COALESCE(currency, 'USD'))),
+    subtotal_cents = ROUND(total_amount * 10::numeric ^ currency_exponent(COALESCE(currency, 'USD'))),
+    escrow_cents = ROUND(COALESCE(escrow_amount, 0) * 10::numeric ^ currency_exponent(COALESCE(currency, 'USD'))),
+    escrow_fee_cents = ROUND(COALESCE(escrow_fee, 0) * 10::numeric ^ currency_exponent(COALESCE(currency, 'USD')));
+ALTER TABLE orders
+    ALTER COLUMN subtotal_cents SET NOT NULL,
+    ALTER COLUMN total_cents SET NOT NULL,
+    ADD CONSTRAINT orders_totals_check CHECK (total_cents = subtotal_cents - discount_cents + tax_cents),
+    DROP COLUMN total_amount,
+    DROP COLUMN escrow_amount,
+    DROP COLUMN escrow_fee;
+
+-- Line totals of older orders were rounded from floats, so only new lines
+-- are held to price times quantity
+ALTER TABLE order_items
+    ADD COLUMN price_cents BIGINT,
+    ADD COLUMN total_cents BIGINT;
+UPDATE order_items oi SET
+    price_cents = ROUND(oi.price * 10::numeric ^ currency_exponent(COALESCE(o.currency, 'USD'))),
+    total_cents = ROUND(oi.total_price * 10::numeric ^ currency_exponent(COALESCE(o.currency, 'USD')))
+FROM orders o
+WHERE o.id = oi.order_id;
+ALTER TABLE order_items
+    ALTER COLUMN price_cents SET NOT NULL,
+    ALTER COLUMN total_cents SET NOT NULL,
+    DROP COLUMN price,
+    DROP COLUMN total_price;
+ALTER TABLE order_items
+    ADD CONSTRAINT order_items_total_cents_check CHECK (total_cents = price_cents * quantity) NOT VALID;
@@ -0,0 +1,50 @@
+-- Let products carry many tags and belong to many categories. A product's
+-- category_id stays its primary category and is always one of its
+-- product_categories.
+CREATE TABLE tags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(50) NOT NULL,
+    slug VARCHAR(50) NOT NULL UNIQUE, -- lowercase letters and digits joined by '-'
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE product_tags (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    tag_id UUID NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (product_id, tag_id)
+);
+
+-- Tag filters and collections look products up by tag
+CREATE INDEX idx_product_tags_tag ON product_tags(tag_id, product_id);
+
+CREATE TABLE product_categories (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (product_id, category_id)
+);
+
+CREATE INDEX idx_product_categories_category ON product_categories(category_id, product_id);
+
+INSERT INTO product_categories (product_id, category_id)
+SELECT id, category_id FROM products WHERE category_id IS NOT NULL;
+
+-- Move the tags arrays into tags, keeping one spelling of each slug. Tags
+-- were limited to 50 characters, so their slugs fit.
+INSERT INTO tags (name, slug)
+SELECT DISTINCT ON (slug) name, slug
+FROM (
+    SELECT btrim(t.name) AS name, btrim(regexp_replace(lower(t.name), '[^a-z0-9]+', '-', 'g'), '-') AS slug
+    FROM products p CROSS JOIN LATERAL unnest(p.tags) AS t(name)
+) s
+WHERE slug <> ''
+ORDER BY slug, name;
+
+INSERT INTO product_tags (product_id, tag_id)
+SELECT DISTINCT p.id, tg.id
+FROM products p
+CROSS JOIN LATERAL unnest(p.tags) AS t(name)
+JOIN tags tg ON tg.slug = btrim(regexp_replace(lower(t.name), '[^a-z0-9]+', '-', 'g'), '-');
+
+ALTER TABLE products DROP COLUMN tags;
@@ -0,0 +1,18 @@
+-- Let authors delete their reviews and restore them within a grace window,
+-- and keep each product's rating aggregate over its visible reviews
+ALTER TABLE reviews ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Product review listings show visible reviews, newest first
+CREATE INDEX idx_reviews_product_visible ON reviews(product_id, created_at DESC) WHERE deleted_at IS NULL;
+
+ALTER TABLE products
+    ADD COLUMN avg_rating NUMERIC(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN review_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE products p SET avg_rating = r.avg_rating, review_count = r.review_count
+FROM (
+    SELECT product_id, COALESCE(ROUND(AVG(rating), 2), 0) AS avg_rating, COUNT(*) AS review_count
+    FROM reviews
+    GROUP BY product_id
+) r
+WHERE r.product_id = p.id;
@@ -0,0 +1,3 @@
+-- Record when a review's author last edited it, so edits can be rate limited
+-- and shown as edited. updated_at also changes on deletes and restores.
+ALTER TABLE reviews ADD COLUMN edited_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,10 @@
+-- Product views in hourly buckets, flushed from the counters kept in Redis.
+-- Trending products are ranked by their views over recent buckets.
+CREATE TABLE product_views (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL, -- start of the hour
+    views BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (product_id, bucket_start)
+);
+
+CREATE INDEX idx_product_views_bucket ON product_views(bucket_start, product_id);
@@ -0,0 +1,18 @@
+-- Scheduled sales. A product is charged sale_price_cents from sale_starts_at
+-- until sale_ends_at; the price is worked out from the times on every read
+-- and at checkout. sale_active is the sale state as of the last transition
+-- run, so listings can filter and sort on the current price with an index.
+ALTER TABLE products
+    ADD COLUMN sale_price_cents BIGINT CHECK (sale_price_cents > 0),
+    ADD COLUMN sale_starts_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN sale_ends_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN sale_active BOOLEAN NOT NULL DEFAULT false,
+    ADD CONSTRAINT products_sale_window CHECK (
+        (sale_price_cents IS NULL AND sale_starts_at IS NULL AND sale_ends_at IS NULL)
+        OR (sale_price_cents IS NOT NULL AND sale_ends_at > sale_starts_at)
+    );
+
+CREATE INDEX idx_products_listed_price ON products ((CASE WHEN sale_active THEN sale_price_cents ELSE price_cents END));
+
+-- Sale transitions only look at products with a sale or still marked active
+CREATE INDEX idx_products_sales ON products(sale_starts_at, sale_ends_at) WHERE sale_price_cents IS NOT NULL OR sale_active;
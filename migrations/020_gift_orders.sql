@@ -0,0 +1,14 @@
+-- Gift orders ship to the recipient at the order's shipping address and stay
+-- the buyer's order for history and refunds. Packing slips of gift orders
+-- carry the gift message and leave out prices.
+ALTER TABLE orders
+    ADD COLUMN is_gift BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN gift_recipient_name VARCHAR(100),
+    ADD COLUMN gift_recipient_email VARCHAR(255),
+    ADD COLUMN gift_message TEXT,
+    ADD COLUMN gift_notify_recipient BOOLEAN NOT NULL DEFAULT false,
+    ADD CONSTRAINT orders_gift_recipient CHECK (
+        (is_gift AND gift_recipient_name IS NOT NULL)
+        OR (NOT is_gift AND gift_recipient_name IS NULL AND gift_recipient_email IS NULL AND gift_message IS NULL)
+    ),
+    ADD CONSTRAINT orders_gift_notify CHECK (NOT gift_notify_recipient OR gift_recipient_email IS NOT NULL);
@@ -0,0 +1,29 @@
+-- Delivery estimates. A product ships from its warehouse (the default
+-- warehouse when NULL) after its processing days (the default when NULL),
+-- then takes the transit days from its warehouse to the zone of the
+-- destination postal code.
+CREATE TABLE warehouses (
+    code VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    postal_code VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Postal codes resolve to the zone of their longest matching prefix
+CREATE TABLE delivery_zones (
+    postal_prefix VARCHAR(20) PRIMARY KEY,
+    zone VARCHAR(50) NOT NULL
+);
+
+CREATE TABLE delivery_transit (
+    warehouse VARCHAR(50) NOT NULL REFERENCES warehouses(code) ON DELETE CASCADE,
+    zone VARCHAR(50) NOT NULL,
+    min_days INTEGER NOT NULL CHECK (min_days >= 0),
+    max_days INTEGER NOT NULL,
+    PRIMARY KEY (warehouse, zone),
+    CHECK (max_days >= min_days)
+);
+
+ALTER TABLE products
+    ADD COLUMN warehouse VARCHAR(50) REFERENCES warehouses(code),
+    ADD COLUMN processing_days INTEGER CHECK (processing_days >= 0);
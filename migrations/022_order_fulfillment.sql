@@ -0,0 +1,10 @@
+-- Sellers fulfill the items of their products on an order one by one. An
+-- order is shipped once every item is fulfilled.
+ALTER TABLE order_items
+    ADD COLUMN fulfilled_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN fulfilled_by UUID REFERENCES users(id),
+    ADD COLUMN carrier VARCHAR(50),
+    ADD COLUMN tracking_number VARCHAR(100);
+
+-- Seller fulfillment queues look up the unfulfilled items of their products
+CREATE INDEX idx_order_items_unfulfilled ON order_items(product_id) WHERE fulfilled_at IS NULL;
@@ -0,0 +1,27 @@
+-- Checkout splits an order into one sub-order per seller. The buyer pays
+-- the parent order once; each sub-order has its own status, fulfillment and
+-- payout and can be cancelled or refunded on its own. The parent's totals
+-- are the sums of its sub-orders'. Orders placed before the split have no
+-- sub-orders and are managed as a whole.
+CREATE TABLE sub_orders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    seller_id UUID NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, paid, shipped, delivered, cancelled
+    subtotal_cents BIGINT NOT NULL,
+    discount_cents BIGINT NOT NULL DEFAULT 0,
+    tax_cents BIGINT NOT NULL DEFAULT 0,
+    total_cents BIGINT NOT NULL,
+    refunded_cents BIGINT NOT NULL DEFAULT 0,
+    payout_status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, due, cancelled
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (order_id, seller_id),
+    CONSTRAINT sub_orders_total CHECK (total_cents = subtotal_cents - discount_cents + tax_cents),
+    CONSTRAINT sub_orders_refunded CHECK (refunded_cents BETWEEN 0 AND total_cents)
+);
+
+CREATE INDEX idx_sub_orders_seller ON sub_orders(seller_id, status);
+
+ALTER TABLE order_items ADD COLUMN sub_order_id UUID REFERENCES sub_orders(id);
+CREATE INDEX idx_order_items_sub_order ON order_items(sub_order_id);
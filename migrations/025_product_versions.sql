@@ -0,0 +1,3 @@
+-- Every edit of a listing bumps its version, so a client can update a
+-- product only if it hasn't changed since the client read it.
+ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
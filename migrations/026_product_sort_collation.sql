@@ -0,0 +1,28 @@
+-- Exchange rates let listings compare prices in different currencies. Each
+-- rate is the units of a currency that one unit of the base currency buys,
+-- so the base currency has rate 1; rates are loaded by operations.
+CREATE TABLE exchange_rates (
+    currency CHAR(3) PRIMARY KEY,
+    rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO exchange_rates (currency, rate) VALUES ('USD', 1);
+
+-- Converts an amount in major units between currencies through the base
+-- currency; NULL when either currency has no rate
+CREATE FUNCTION convert_price(amount NUMERIC, from_currency TEXT, to_currency TEXT) RETURNS NUMERIC AS $$
+    SELECT CASE WHEN from_currency = to_currency THEN amount ELSE (
+        SELECT amount / f.rate * t.rate
+        FROM exchange_rates f, exchange_rates t
+        WHERE f.currency = from_currency AND t.currency = to_currency
+    ) END
+$$ LANGUAGE SQL STABLE;
+
+-- Name sorts in each locale services.productSorts allows. Converted price
+-- sorts depend on the rates and can't be indexed.
+CREATE INDEX idx_products_title_en ON products ((title COLLATE "en-x-icu")) WHERE deleted_at IS NULL;
+CREATE INDEX idx_products_title_de ON products ((title COLLATE "de-x-icu")) WHERE deleted_at IS NULL;
+CREATE INDEX idx_products_title_fr ON products ((title COLLATE "fr-x-icu")) WHERE deleted_at IS NULL;
+CREATE INDEX idx_products_title_es ON products ((title COLLATE "es-x-icu")) WHERE deleted_at IS NULL;
+CREATE INDEX idx_products_title_sv ON products ((title COLLATE "sv-x-icu")) WHERE deleted_at IS NULL;
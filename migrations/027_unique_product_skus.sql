@@ -0,0 +1,30 @@
+-- A seller's SKUs identify their products in the seller's own inventory
+-- systems, so no two of a seller's live products may share one. Different
+-- sellers may use the same SKU, and deleted products give theirs up.
+
+-- Products listed without a SKU get a generated one
+UPDATE products SET sku = 'SKU-' || upper(substr(replace(id::text, '-', ''), 1, 12))
+WHERE sku IS NULL OR btrim(sku) = '';
+
+-- Which of two products keeps a SKU is up to their seller, so duplicates are
+-- listed and the migration stops until they have been fixed
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('seller %s, SKU %L: products %s', seller_id, sku, product_ids), E'\n')
+    INTO duplicates
+    FROM (
+        SELECT seller_id, sku, string_agg(id::text, ', ' ORDER BY created_at) AS product_ids
+        FROM products
+        WHERE deleted_at IS NULL
+        GROUP BY seller_id, sku
+        HAVING COUNT(*) > 1
+    ) d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'products share SKUs; give them distinct SKUs and run the migration again'
+            USING DETAIL = duplicates;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX products_seller_sku_key ON products (seller_id, sku) WHERE deleted_at IS NULL;
@@ -0,0 +1,15 @@
+-- Order lines keep their regular price and their share of the order's
+-- discount, so what was taken off an order can be accounted for line by
+-- line: the sale discount is the regular price less the price charged.
+ALTER TABLE order_items
+    ADD COLUMN regular_price_cents BIGINT,
+    ADD COLUMN discount_cents BIGINT NOT NULL DEFAULT 0;
+
+-- Older lines didn't record whether they were on sale; they count as sold
+-- at their regular price
+UPDATE order_items SET regular_price_cents = price_cents;
+
+ALTER TABLE order_items
+    ALTER COLUMN regular_price_cents SET NOT NULL,
+    ADD CONSTRAINT order_items_regular_price_cents_check CHECK (regular_price_cents >= price_cents),
+    ADD CONSTRAINT order_items_discount_cents_check CHECK (discount_cents BETWEEN 0 AND total_cents);
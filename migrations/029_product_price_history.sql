@@ -0,0 +1,14 @@
+-- Every change of a product's regular price made by a bulk reprice, with
+-- who made it
+CREATE TABLE product_price_history (
+    id BIGSERIAL PRIMARY KEY,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    old_price_cents BIGINT NOT NULL,
+    new_price_cents BIGINT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    reason VARCHAR(20) NOT NULL, -- reprice
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_price_history_product ON product_price_history(product_id, created_at DESC);
@@ -0,0 +1,47 @@
+-- The price history records every change of a product's regular or sale
+-- price, however it was made. A trigger writes it so no write path can miss
+-- one; the application names the actor and reason of a transaction's
+-- changes in the greens.price_actor and greens.price_reason settings, and
+-- changes made without them are recorded as 'system'.
+ALTER TABLE product_price_history
+    ADD COLUMN kind VARCHAR(10) NOT NULL DEFAULT 'regular' CHECK (kind IN ('regular', 'sale')),
+    ADD COLUMN old_currency VARCHAR(3),
+    ADD COLUMN sale_starts_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN sale_ends_at TIMESTAMP WITH TIME ZONE,
+    ALTER COLUMN old_price_cents DROP NOT NULL, -- the first regular price, and a new sale
+    ALTER COLUMN new_price_cents DROP NOT NULL; -- a cancelled sale
+ALTER TABLE product_price_history ALTER COLUMN kind DROP DEFAULT;
+UPDATE product_price_history SET old_currency = currency;
+
+CREATE OR REPLACE FUNCTION record_product_price_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    actor UUID := NULLIF(current_setting('greens.price_actor', true), '')::uuid;
+    change_reason VARCHAR(20) := COALESCE(NULLIF(current_setting('greens.price_reason', true), ''), 'system');
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO product_price_history (product_id, kind, new_price_cents, currency, reason, changed_by)
+        VALUES (NEW.id, 'regular', NEW.price_cents, COALESCE(NEW.currency, 'USD'), change_reason, actor);
+        RETURN NEW;
+    END IF;
+    IF NEW.price_cents IS DISTINCT FROM OLD.price_cents OR NEW.currency IS DISTINCT FROM OLD.currency THEN
+        INSERT INTO product_price_history (product_id, kind, old_price_cents, new_price_cents, old_currency, currency,
+            reason, changed_by)
+        VALUES (NEW.id, 'regular', OLD.price_cents, NEW.price_cents, COALESCE(OLD.currency, 'USD'), COALESCE(NEW.currency, 'USD'),
+            change_reason, actor);
+    END IF;
+    IF NEW.sale_price_cents IS DISTINCT FROM OLD.sale_price_cents
+        OR NEW.sale_starts_at IS DISTINCT FROM OLD.sale_starts_at
+        OR NEW.sale_ends_at IS DISTINCT FROM OLD.sale_ends_at THEN
+        INSERT INTO product_price_history (product_id, kind, old_price_cents, new_price_cents, old_currency, currency,
+            sale_starts_at, sale_ends_at, reason, changed_by)
+        VALUES (NEW.id, 'sale', OLD.sale_price_cents, NEW.sale_price_cents, COALESCE(OLD.currency, 'USD'), COALESCE(NEW.currency, 'USD'),
+            NEW.sale_starts_at, NEW.sale_ends_at, change_reason, actor);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_product_price_change
+    AFTER INSERT OR UPDATE OF price_cents, currency, sale_price_cents, sale_starts_at, sale_ends_at ON products
+    FOR EACH ROW EXECUTE FUNCTION record_product_price_change();
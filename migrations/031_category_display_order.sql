@@ -0,0 +1,31 @@
+-- Categories are shown in the order merchandising sets, per parent. New
+-- categories, and those moved to another parent, go to the end of their
+-- parent's list.
+ALTER TABLE categories ADD COLUMN display_order INTEGER;
+
+UPDATE categories c SET display_order = o.position
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY parent_id ORDER BY name, id) AS position
+    FROM categories
+) o
+WHERE o.id = c.id;
+
+ALTER TABLE categories ALTER COLUMN display_order SET NOT NULL;
+
+CREATE INDEX idx_categories_parent_order ON categories(parent_id, display_order);
+
+CREATE OR REPLACE FUNCTION append_category_display_order()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.display_order IS NULL OR (TG_OP = 'UPDATE' AND NEW.parent_id IS DISTINCT FROM OLD.parent_id) THEN
+        SELECT COALESCE(MAX(display_order), 0) + 1 INTO NEW.display_order
+        FROM categories
+        WHERE parent_id IS NOT DISTINCT FROM NEW.parent_id AND id <> NEW.id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER categories_display_order
+    BEFORE INSERT OR UPDATE OF parent_id ON categories
+    FOR EACH ROW EXECUTE FUNCTION append_category_display_order();
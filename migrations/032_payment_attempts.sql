@@ -0,0 +1,16 @@
+-- Every attempt to pay for an order, so support can see why payments failed.
+-- gateway_code and gateway_message are the gateway's own words, kept for
+-- support only; clients see decline_reason.
+CREATE TABLE payment_attempts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('succeeded', 'declined')),
+    amount_cents BIGINT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    decline_reason VARCHAR(30),
+    gateway_code VARCHAR(100),
+    gateway_message TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_payment_attempts_order ON payment_attempts(order_id, created_at DESC);
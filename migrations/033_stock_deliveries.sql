@@ -0,0 +1,28 @@
+-- Deliveries received from suppliers. Each restocks its products once: the
+-- seller's reference, such as the supplier's delivery note number, is
+-- unique per seller, so posting a delivery again changes nothing.
+CREATE TABLE stock_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    seller_id UUID NOT NULL REFERENCES users(id),
+    reference VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (seller_id, reference)
+);
+
+CREATE TABLE stock_delivery_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    delivery_id UUID NOT NULL REFERENCES stock_deliveries(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id),
+    position INTEGER NOT NULL, -- order of the item in the delivery
+    quantity_ordered INTEGER CHECK (quantity_ordered >= 0), -- NULL when not given
+    quantity_received INTEGER NOT NULL CHECK (quantity_received >= 0),
+    previous_quantity INTEGER NOT NULL,
+    stock_quantity INTEGER NOT NULL
+);
+
+CREATE INDEX idx_stock_delivery_items_delivery ON stock_delivery_items(delivery_id, position);
+CREATE INDEX idx_stock_delivery_items_product ON stock_delivery_items(product_id);
+
+ALTER TABLE stock_movements DROP CONSTRAINT stock_movements_reason_check;
+ALTER TABLE stock_movements ADD CONSTRAINT stock_movements_reason_check
+    CHECK (reason IN ('initial', 'adjustment', 'correction', 'order', 'cancellation', 'reservation', 'release', 'delivery'));
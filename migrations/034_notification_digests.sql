@@ -0,0 +1,21 @@
+-- Users may take their notifications as one daily digest. Notifications for
+-- them wait in notification_digest_items until the digest is sent; sent
+-- items are kept for a while so redelivered events are still recognized.
+ALTER TABLE user_preferences ADD COLUMN notification_mode VARCHAR(20) DEFAULT 'instant'
+    CHECK (notification_mode IN ('instant', 'daily_digest'));
+
+CREATE TABLE notification_digest_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    job_id VARCHAR(100) NOT NULL, -- the event the notification is for
+    type VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    message TEXT,
+    data JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    sent_at TIMESTAMP WITH TIME ZONE, -- when its digest was sent
+    UNIQUE (user_id, job_id)
+);
+
+CREATE INDEX idx_notification_digest_items_pending ON notification_digest_items(user_id, created_at) WHERE sent_at IS NULL;
+CREATE INDEX idx_notification_digest_items_sent ON notification_digest_items(sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,21 @@
+-- Announcements broadcast by admins to a segment of users. Batches of users
+-- are notified by background jobs, keyset-paginated on user ID from
+-- last_user_id. The key is chosen by the admin, so posting a broadcast
+-- again doesn't send it twice.
+CREATE TABLE notification_broadcasts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    key VARCHAR(100) UNIQUE NOT NULL,
+    created_by UUID REFERENCES users(id),
+    audience VARCHAR(10) NOT NULL CHECK (audience IN ('all', 'role', 'plan')),
+    audience_value VARCHAR(20), -- the role or plan
+    title VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL,
+    promotional BOOLEAN NOT NULL DEFAULT false, -- only sent to users accepting marketing
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'sending', 'completed')),
+    recipients INTEGER NOT NULL, -- users in the segment when it was created
+    sent INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0, -- opted out of promotions
+    last_user_id UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
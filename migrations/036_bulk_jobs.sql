@@ -0,0 +1,17 @@
+-- Bulk requests too large to apply during the request, applied by a
+-- background job. input is the request as received; result is the response
+-- it would have had, and errors the validation errors when it failed.
+CREATE TABLE bulk_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id UUID NOT NULL REFERENCES users(id),
+    kind VARCHAR(30) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'succeeded', 'failed')),
+    items INTEGER NOT NULL,
+    input JSONB NOT NULL,
+    result JSONB,
+    errors JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_bulk_jobs_owner ON bulk_jobs(owner_id, created_at DESC);
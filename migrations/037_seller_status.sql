@@ -0,0 +1,26 @@
+-- Sellers are verified before they can list products or be paid out. Users
+-- already selling are verified so they keep working; everyone else starts
+-- pending. Suspended sellers' products stay but are left out of listings.
+ALTER TABLE users ADD COLUMN seller_status VARCHAR(20) NOT NULL DEFAULT 'pending'
+    CHECK (seller_status IN ('pending', 'verified', 'suspended'));
+
+UPDATE users u SET seller_status = 'verified'
+WHERE u.role = 'seller' OR EXISTS (SELECT 1 FROM products p WHERE p.seller_id = u.id);
+
+CREATE INDEX idx_users_seller_status ON users(seller_status) WHERE seller_status <> 'pending';
+
+-- Every change of a seller's status, with who made it and why
+CREATE TABLE seller_status_changes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    seller_id UUID NOT NULL REFERENCES users(id),
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    changed_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_seller_status_changes_seller ON seller_status_changes(seller_id, created_at DESC);
+
+-- sub_orders.payout_status may now also be 'held': delivered, but the
+-- seller isn't verified. Held payouts become due once the seller is.
@@ -0,0 +1,11 @@
+-- Products sold by weight are priced per kg. Their stock, order quantity
+-- rules, cart quantities and order item quantities are in grams, so every
+-- quantity column stays an exact integer. Order items keep the unit they
+-- were sold in.
+ALTER TABLE products ADD COLUMN unit_type VARCHAR(10) NOT NULL DEFAULT 'each'
+    CHECK (unit_type IN ('each', 'weight'));
+ALTER TABLE products ADD CONSTRAINT products_bundle_unit_type
+    CHECK (product_type <> 'bundle' OR unit_type = 'each');
+
+ALTER TABLE order_items ADD COLUMN unit_type VARCHAR(10) NOT NULL DEFAULT 'each'
+    CHECK (unit_type IN ('each', 'weight'));
@@ -0,0 +1,8 @@
+-- Guest carts merged into a user's cart at sign-in, by the token the guest's
+-- client gave its cart. A token merges once, so a retried merge doesn't add
+-- the guest's items again.
+CREATE TABLE cart_merges (
+    guest_cart_token UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    merged_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
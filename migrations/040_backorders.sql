@@ -0,0 +1,11 @@
+-- Lines ordered with too little stock are backordered: they are ordered
+-- without taking stock, and a scheduled job takes it when it returns.
+-- backordered_at is cleared then, and backorder_eta is when the seller
+-- expects the stock. A backordered line that can't be supplied is
+-- cancelled and refunded instead of shipped.
+ALTER TABLE order_items ADD COLUMN backordered_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE order_items ADD COLUMN backorder_eta DATE;
+ALTER TABLE order_items ADD COLUMN cancelled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_order_items_backordered ON order_items(backordered_at)
+    WHERE backordered_at IS NOT NULL AND cancelled_at IS NULL;
@@ -0,0 +1,11 @@
+-- Events received from payment and shipping providers' webhooks, by the
+-- provider's event ID. An event is recorded in the transaction that
+-- processes it, so a redelivery of it is recognised and skipped.
+CREATE TABLE inbound_webhook_events (
+    provider VARCHAR(50) NOT NULL,
+    event_id VARCHAR(255) NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (provider, event_id)
+);
+
+CREATE INDEX idx_inbound_webhook_events_received_at ON inbound_webhook_events(received_at);
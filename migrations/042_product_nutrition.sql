@@ -0,0 +1,6 @@
+-- Nutrition facts of a product, per serving: calories, macronutrients,
+-- allergens and ingredients. NULL when the seller hasn't given them.
+ALTER TABLE products ADD COLUMN nutrition JSONB;
+
+-- Listings filtered by allergens they must not contain
+CREATE INDEX idx_products_nutrition_allergens ON products USING GIN ((nutrition->'allergens'));
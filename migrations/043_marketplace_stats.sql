@@ -0,0 +1,6 @@
+-- Indexes for the admin marketplace stats. Counted orders are paid and not
+-- cancelled, so their index holds only those, with what GMV sums.
+CREATE INDEX idx_orders_counted_created ON orders(created_at) INCLUDE (currency, total_cents)
+    WHERE status NOT IN ('pending', 'cancelled');
+CREATE INDEX idx_order_items_order ON order_items(order_id);
+CREATE INDEX idx_users_created ON users(created_at);
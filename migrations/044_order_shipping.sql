@@ -0,0 +1,13 @@
+-- Orders ship by a method chosen at checkout. Orders placed before have no
+-- method and no shipping cost. An order's shipping is split over its
+-- sub-orders, and both totals now include it.
+ALTER TABLE orders
+    ADD COLUMN shipping_method VARCHAR(20),
+    ADD COLUMN shipping_cents BIGINT NOT NULL DEFAULT 0 CHECK (shipping_cents >= 0),
+    DROP CONSTRAINT orders_totals_check,
+    ADD CONSTRAINT orders_totals_check CHECK (total_cents = subtotal_cents - discount_cents + tax_cents + shipping_cents);
+
+ALTER TABLE sub_orders
+    ADD COLUMN shipping_cents BIGINT NOT NULL DEFAULT 0 CHECK (shipping_cents >= 0),
+    DROP CONSTRAINT sub_orders_total,
+    ADD CONSTRAINT sub_orders_total CHECK (total_cents = subtotal_cents - discount_cents + tax_cents + shipping_cents);
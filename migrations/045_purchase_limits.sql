@@ -0,0 +1,9 @@
+-- Purchase limits: a buyer may order at most purchase_limit_qty of a
+-- product over the last purchase_limit_days days. Both are NULL for no limit.
+ALTER TABLE products
+    ADD COLUMN purchase_limit_qty INTEGER CHECK (purchase_limit_qty >= 1),
+    ADD COLUMN purchase_limit_days INTEGER CHECK (purchase_limit_days BETWEEN 1 AND 365),
+    ADD CONSTRAINT products_purchase_limit CHECK ((purchase_limit_qty IS NULL) = (purchase_limit_days IS NULL));
+
+-- Checkout sums a buyer's recent orders of limited products
+CREATE INDEX idx_orders_buyer_created ON orders(buyer_id, created_at);
@@ -0,0 +1,16 @@
+-- Product status: new products start as drafts, seen only by their seller,
+-- and are listed once published. Archiving unlists a product until it is
+-- published again. is_active stays what listings filter on and follows the
+-- status.
+ALTER TABLE products
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published'
+        CHECK (status IN ('draft', 'published', 'archived'));
+
+-- Existing products are published unless already unlisted
+UPDATE products SET status = 'archived' WHERE deleted_at IS NOT NULL OR is_active = false;
+UPDATE products SET is_active = true WHERE deleted_at IS NULL AND is_active IS NULL;
+
+ALTER TABLE products
+    ALTER COLUMN status SET DEFAULT 'draft',
+    ALTER COLUMN is_active SET DEFAULT false,
+    ADD CONSTRAINT products_status_active CHECK (deleted_at IS NOT NULL OR is_active = (status = 'published'));
@@ -0,0 +1,27 @@
+-- Every mutable entity records when it was created and last changed,
+-- defaulting on the database side
+ALTER TABLE categories ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE;
+UPDATE categories SET updated_at = COALESCE(created_at, NOW());
+ALTER TABLE categories ALTER COLUMN updated_at SET DEFAULT NOW(), ALTER COLUMN updated_at SET NOT NULL;
+
+ALTER TABLE order_items
+    ADD COLUMN created_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE;
+UPDATE order_items oi SET created_at = COALESCE(o.created_at, NOW()), updated_at = COALESCE(o.created_at, NOW())
+FROM orders o WHERE o.id = oi.order_id;
+ALTER TABLE order_items
+    ALTER COLUMN created_at SET DEFAULT NOW(), ALTER COLUMN created_at SET NOT NULL,
+    ALTER COLUMN updated_at SET DEFAULT NOW(), ALTER COLUMN updated_at SET NOT NULL;
+
+ALTER TABLE notifications ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE;
+UPDATE notifications SET updated_at = COALESCE(read_at, created_at, NOW());
+ALTER TABLE notifications ALTER COLUMN updated_at SET DEFAULT NOW(), ALTER COLUMN updated_at SET NOT NULL;
+
+ALTER TABLE user_preferences ADD COLUMN created_at TIMESTAMP WITH TIME ZONE;
+UPDATE user_preferences SET created_at = COALESCE(updated_at, NOW());
+ALTER TABLE user_preferences ALTER COLUMN created_at SET DEFAULT NOW(), ALTER COLUMN created_at SET NOT NULL;
+
+-- Added after the backfills, so they don't stamp every row as just changed
+CREATE TRIGGER update_categories_updated_at BEFORE UPDATE ON categories FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_order_items_updated_at BEFORE UPDATE ON order_items FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_notifications_updated_at BEFORE UPDATE ON notifications FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,61 @@
+-- Delta sync lists products changed since a time by updated_at, so every
+-- change to what a product shows must touch its row. Writes to products do
+-- through update_products_updated_at; these triggers cover what is stored
+-- elsewhere, so no write path can miss one: a product's tags, categories and
+-- bundle items, the components of a bundle, and the seller's status, which
+-- hides or shows their products.
+CREATE OR REPLACE FUNCTION touch_product_of_row()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE products SET updated_at = NOW() WHERE id = OLD.product_id;
+        RETURN OLD;
+    END IF;
+    UPDATE products SET updated_at = NOW() WHERE id = NEW.product_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION touch_bundle_of_row()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE products SET updated_at = NOW() WHERE id = OLD.bundle_id;
+        RETURN OLD;
+    END IF;
+    UPDATE products SET updated_at = NOW() WHERE id = NEW.bundle_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- A bundle shows its components' prices, stock and listing
+CREATE OR REPLACE FUNCTION touch_bundles_of_component()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE products SET updated_at = NOW()
+    WHERE id IN (SELECT bundle_id FROM product_bundle_items WHERE product_id = NEW.id);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION touch_products_of_seller()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE products SET updated_at = NOW() WHERE seller_id = NEW.id AND deleted_at IS NULL;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER touch_product_tags AFTER INSERT OR UPDATE OR DELETE ON product_tags
+    FOR EACH ROW EXECUTE FUNCTION touch_product_of_row();
+CREATE TRIGGER touch_product_categories AFTER INSERT OR UPDATE OR DELETE ON product_categories
+    FOR EACH ROW EXECUTE FUNCTION touch_product_of_row();
+CREATE TRIGGER touch_product_bundle_items AFTER INSERT OR UPDATE OR DELETE ON product_bundle_items
+    FOR EACH ROW EXECUTE FUNCTION touch_bundle_of_row();
+CREATE TRIGGER touch_product_bundles AFTER UPDATE ON products
+    FOR EACH ROW WHEN (NEW.product_type <> 'bundle') EXECUTE FUNCTION touch_bundles_of_component();
+CREATE TRIGGER touch_seller_products AFTER UPDATE OF seller_status ON users
+    FOR EACH ROW WHEN (OLD.seller_status IS DISTINCT FROM NEW.seller_status) EXECUTE FUNCTION touch_products_of_seller();
+
+-- Delta sync pages through (updated_at, id)
+CREATE INDEX idx_products_updated ON products(updated_at, id);
@@ -0,0 +1,6 @@
+-- Price watch: a wishlist item with a target price is notified of price
+-- drops only once the price is at or below it
+ALTER TABLE wishlist
+    ADD COLUMN target_price_cents BIGINT CHECK (target_price_cents > 0),
+    ADD COLUMN target_currency VARCHAR(3),
+    ADD CONSTRAINT wishlist_target_price CHECK ((target_price_cents IS NULL) = (target_currency IS NULL));
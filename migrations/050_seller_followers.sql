@@ -0,0 +1,21 @@
+-- Seller follows: shoppers follow sellers to get their new products in a
+-- feed, and a notification as each is published unless they opt out.
+CREATE TABLE IF NOT EXISTS seller_followers (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seller_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    notify BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, seller_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_seller_followers_seller ON seller_followers(seller_id) WHERE notify;
+
+-- When a product was first published; republishing an archived product
+-- keeps it, so the feed doesn't show it as new again
+ALTER TABLE products ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ;
+
+UPDATE products SET published_at = created_at WHERE status <> 'draft' AND published_at IS NULL;
+
+-- The feed reads each followed seller's products newest first
+CREATE INDEX IF NOT EXISTS idx_products_seller_published ON products(seller_id, published_at DESC, id DESC)
+    WHERE status = 'published' AND deleted_at IS NULL;
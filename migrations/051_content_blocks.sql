@@ -0,0 +1,20 @@
+-- Content blocks: banners and other merchandising content, keyed by the
+-- slot they fill and their locale, shown between starts_at and ends_at
+-- (either open). Where blocks for a slot and locale overlap, the one that
+-- started last is shown, so a campaign can run over a standing block.
+CREATE TABLE content_blocks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    key VARCHAR(100) NOT NULL,
+    locale VARCHAR(20) NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    payload JSONB NOT NULL, -- validated against the key's schema by the API
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (starts_at IS NULL OR ends_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX idx_content_blocks_key ON content_blocks(key, ends_at);
+
+CREATE TRIGGER update_content_blocks_updated_at BEFORE UPDATE ON content_blocks FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
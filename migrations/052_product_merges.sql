@@ -0,0 +1,32 @@
+-- Product merges: an admin folds a duplicate listing (the source) into
+-- another (the target). The source is soft-deleted with merged_into
+-- pointing at the target, so its URLs redirect. Each merge records the rows
+-- it moved, so it can be reverted until undo_until.
+ALTER TABLE products ADD COLUMN merged_into UUID REFERENCES products(id);
+
+CREATE TABLE product_merges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    source_id UUID NOT NULL REFERENCES products(id),
+    target_id UUID NOT NULL REFERENCES products(id),
+    merged_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    review_ids UUID[] NOT NULL DEFAULT '{}',
+    wishlist_ids UUID[] NOT NULL DEFAULT '{}', -- moved to the target
+    cart_ids UUID[] NOT NULL DEFAULT '{}',
+    order_item_ids UUID[] NOT NULL DEFAULT '{}',
+    dropped_wishlist JSONB NOT NULL DEFAULT '[]', -- lines of users who had both, restored on revert
+    dropped_cart JSONB NOT NULL DEFAULT '[]',
+    stock_moved INTEGER NOT NULL DEFAULT 0,
+    stock_returned INTEGER, -- by the revert, as much of stock_moved as the target still had
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    undo_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    reverted_at TIMESTAMP WITH TIME ZONE,
+    reverted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    CHECK (source_id <> target_id)
+);
+
+CREATE INDEX idx_product_merges_source ON product_merges(source_id, created_at DESC);
+CREATE INDEX idx_product_merges_target ON product_merges(target_id, created_at DESC);
+
+ALTER TABLE stock_movements DROP CONSTRAINT stock_movements_reason_check;
+ALTER TABLE stock_movements ADD CONSTRAINT stock_movements_reason_check
+    CHECK (reason IN ('initial', 'adjustment', 'correction', 'order', 'cancellation', 'reservation', 'release', 'delivery', 'merge'));
@@ -0,0 +1,14 @@
+-- Product translations: a product's title and description in another
+-- locale. The products table holds them in the default locale (en), which
+-- is shown where no translation matches the reader's languages.
+CREATE TABLE product_translations (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    locale VARCHAR(20) NOT NULL, -- lowercase BCP 47 tag, e.g. fr or pt-br
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (product_id, locale)
+);
+
+CREATE TRIGGER update_product_translations_updated_at BEFORE UPDATE ON product_translations FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
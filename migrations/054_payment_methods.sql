@@ -0,0 +1,24 @@
+-- Stored payment methods: buyers' cards kept at the payment gateway. Only
+-- the gateway's IDs and what's needed to show a card are stored here; card
+-- numbers never reach the API.
+ALTER TABLE users ADD COLUMN payment_customer_id VARCHAR(255) UNIQUE; -- the buyer at the gateway
+
+CREATE TABLE payment_methods (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    gateway_id VARCHAR(255) NOT NULL UNIQUE,
+    brand VARCHAR(30) NOT NULL,
+    last4 CHAR(4) NOT NULL,
+    exp_month SMALLINT NOT NULL CHECK (exp_month BETWEEN 1 AND 12),
+    exp_year SMALLINT NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_payment_methods_user ON payment_methods(user_id, created_at DESC);
+CREATE UNIQUE INDEX idx_payment_methods_default ON payment_methods(user_id) WHERE is_default;
+
+-- The stored method an order is to be, or was, charged to
+ALTER TABLE orders ADD COLUMN payment_method_id UUID REFERENCES payment_methods(id) ON DELETE SET NULL;
+ALTER TABLE payment_attempts ADD COLUMN payment_method_id UUID REFERENCES payment_methods(id) ON DELETE SET NULL;
+ALTER TABLE payment_attempts ADD COLUMN gateway_reference VARCHAR(255); -- the gateway's charge ID
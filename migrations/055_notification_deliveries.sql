@@ -0,0 +1,10 @@
+-- Notifications are persisted in-app first and then delivered through each
+-- external channel (email) by background jobs. A row records that a
+-- notification went out through a channel, so retried jobs don't send it
+-- twice.
+CREATE TABLE notification_deliveries (
+    notification_id UUID NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL, -- email
+    delivered_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (notification_id, channel)
+);
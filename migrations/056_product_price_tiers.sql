@@ -0,0 +1,9 @@
+-- Quantity price tiers: buying at least min_quantity of a product (in its
+-- unit, grams for weight products) charges price_cents a unit, in the
+-- product's currency. Changing the product's currency drops its tiers.
+CREATE TABLE product_price_tiers (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    min_quantity INTEGER NOT NULL CHECK (min_quantity > 1),
+    price_cents BIGINT NOT NULL CHECK (price_cents > 0),
+    PRIMARY KEY (product_id, min_quantity)
+);
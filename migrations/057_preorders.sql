@@ -0,0 +1,11 @@
+-- Pre-orders: a product on preorder until available_from can be ordered
+-- without stock. Its order items are backordered with preorder set and
+-- filled once it is available. Orders may ask to be delivered on a date.
+ALTER TABLE products
+    ADD COLUMN preorder BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN available_from TIMESTAMP WITH TIME ZONE,
+    ADD CONSTRAINT products_preorder_available_from CHECK (NOT preorder OR available_from IS NOT NULL);
+
+ALTER TABLE order_items ADD COLUMN preorder BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE orders ADD COLUMN requested_delivery_date DATE;
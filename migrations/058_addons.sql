@@ -0,0 +1,30 @@
+-- Checkout add-ons: paid extras such as gift wrapping or a greeting card,
+-- offered from a set admins manage. They aren't products: they take no
+-- stock and belong to no seller, so they are ordered on the order itself
+-- rather than on a sub-order, and are refunded on their own.
+CREATE TABLE addons (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    price_cents BIGINT NOT NULL CHECK (price_cents > 0),
+    currency CHAR(3) NOT NULL,
+    max_quantity INTEGER NOT NULL DEFAULT 1 CHECK (max_quantity > 0),
+    is_active BOOLEAN NOT NULL DEFAULT true, -- offered at checkout
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- The add-ons of an order, named and priced as they were ordered
+CREATE TABLE order_addons (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    addon_id UUID NOT NULL REFERENCES addons(id),
+    name VARCHAR(100) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    price_cents BIGINT NOT NULL,
+    total_cents BIGINT NOT NULL,
+    refunded_cents BIGINT NOT NULL DEFAULT 0 CHECK (refunded_cents BETWEEN 0 AND total_cents),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_addons_order ON order_addons(order_id);
@@ -0,0 +1,22 @@
+-- Admins can suspend abusive accounts without deleting them. Suspended
+-- users can't sign in or use any token, and their reviews are hidden from
+-- products but kept.
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN suspension_reason TEXT;
+-- Tokens issued at or before tokens_revoked_at are rejected, so a suspension
+-- ends every session at once and lifting it doesn't bring them back
+ALTER TABLE users ADD COLUMN tokens_revoked_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_suspended ON users(suspended_at) WHERE suspended_at IS NOT NULL;
+
+-- Every suspension and reinstatement, with who made it and why
+CREATE TABLE account_suspensions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id),
+    suspended BOOLEAN NOT NULL, -- false when the suspension was lifted
+    reason TEXT,
+    changed_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_account_suspensions_user ON account_suspensions(user_id, created_at DESC);
@@ -0,0 +1,29 @@
+-- The platform's commission on each seller's sales. A rate applies to one
+-- seller, to the products whose primary category is one category, or, with
+-- neither, to everything else; a seller's rate wins over a category's. Each
+-- scope keeps its history: the rate in effect is the one with the latest
+-- effective_from that has passed, so new rates can be scheduled ahead.
+CREATE TABLE commission_rates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    seller_id UUID REFERENCES users(id),
+    category_id UUID REFERENCES categories(id),
+    rate_bps INTEGER NOT NULL CHECK (rate_bps BETWEEN 0 AND 10000), -- basis points of the sale
+    effective_from TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT commission_rates_scope CHECK (seller_id IS NULL OR category_id IS NULL)
+);
+
+CREATE INDEX idx_commission_rates_seller ON commission_rates(seller_id, effective_from DESC) WHERE seller_id IS NOT NULL;
+CREATE INDEX idx_commission_rates_category ON commission_rates(category_id, effective_from DESC) WHERE category_id IS NOT NULL;
+
+-- Checkout snapshots the rate in effect for each line and the commission
+-- on it, the line's total less its discount, so later rate changes never
+-- touch placed orders. Orders placed before commissions took none.
+ALTER TABLE order_items
+    ADD COLUMN commission_bps INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN commission_cents BIGINT NOT NULL DEFAULT 0 CHECK (commission_cents >= 0);
+
+ALTER TABLE sub_orders
+    ADD COLUMN commission_cents BIGINT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT sub_orders_commission CHECK (commission_cents BETWEEN 0 AND total_cents);
@@ -0,0 +1,10 @@
+-- Sellers reply publicly to reviews of their products, once per review.
+-- Replies go with their review when it is permanently deleted.
+CREATE TABLE review_replies (
+    review_id UUID PRIMARY KEY REFERENCES reviews(id) ON DELETE CASCADE,
+    seller_id UUID NOT NULL REFERENCES users(id),
+    comment TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    edited_at TIMESTAMP WITH TIME ZONE -- last edit by the seller
+);
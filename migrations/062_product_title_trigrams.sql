@@ -0,0 +1,6 @@
+-- Searches finding few results also match titles similar to the query, so
+-- misspellings like "bananna" still find products. The trigram index keeps
+-- those similarity matches fast on a large catalog.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_products_title_trgm ON products USING GIN (title gin_trgm_ops);
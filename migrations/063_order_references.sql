@@ -0,0 +1,28 @@
+-- Orders get a reference for customers and support, like GM-2024-000123:
+-- the year the order was placed (UTC) and its order number, padded to six
+-- digits. Order numbers come from a sequence, so references are unique
+-- however many orders are created at once; a trigger sets them so every
+-- insert gets one.
+CREATE OR REPLACE FUNCTION order_reference(number BIGINT, placed_at TIMESTAMP WITH TIME ZONE)
+RETURNS TEXT AS $$
+    SELECT 'GM-' || to_char(placed_at AT TIME ZONE 'UTC', 'YYYY') || '-'
+        || repeat('0', GREATEST(6 - length(number::text), 0)) || number::text;
+$$ LANGUAGE sql STABLE;
+
+ALTER TABLE orders ADD COLUMN reference TEXT;
+UPDATE orders SET reference = order_reference(order_number, COALESCE(created_at, NOW()));
+ALTER TABLE orders ALTER COLUMN reference SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_orders_reference ON orders(reference);
+
+CREATE OR REPLACE FUNCTION set_order_reference()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.reference := order_reference(NEW.order_number, COALESCE(NEW.created_at, NOW()));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_set_reference
+    BEFORE INSERT ON orders
+    FOR EACH ROW EXECUTE FUNCTION set_order_reference();
@@ -0,0 +1,33 @@
+-- Shoppers ask questions about products, answered by the product's seller
+-- or by buyers who have received it. Answers can be voted helpful, once
+-- per user.
+CREATE TABLE product_questions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id),
+    body TEXT NOT NULL,
+    answer_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_questions_product ON product_questions(product_id, created_at DESC);
+
+CREATE TABLE product_answers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    question_id UUID NOT NULL REFERENCES product_questions(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id),
+    body TEXT NOT NULL,
+    is_seller BOOLEAN NOT NULL DEFAULT false, -- answered by the product's seller
+    is_verified_purchase BOOLEAN NOT NULL DEFAULT false,
+    helpful_votes INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_answers_question ON product_answers(question_id);
+
+CREATE TABLE product_answer_votes (
+    answer_id UUID NOT NULL REFERENCES product_answers(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (answer_id, user_id)
+);
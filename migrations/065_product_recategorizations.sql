@@ -0,0 +1,16 @@
+-- Product recategorizations: an admin moves many products to another
+-- category at once, chosen by id or by their current category or tag. Each
+-- move records the products it moved and those it skipped for the audit
+-- trail.
+CREATE TABLE product_recategorizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    target_category_id UUID NOT NULL REFERENCES categories(id),
+    from_category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
+    tag VARCHAR(50), -- slug of the tag filtered by
+    product_ids UUID[] NOT NULL DEFAULT '{}', -- moved to the target
+    skipped_ids UUID[] NOT NULL DEFAULT '{}', -- deleted, so left alone
+    recategorized_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_recategorizations_created ON product_recategorizations(created_at DESC);
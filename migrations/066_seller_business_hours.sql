@@ -0,0 +1,7 @@
+-- Seller availability: a seller's weekly business hours in their timezone,
+-- and whether they are accepting orders at all. Shipping methods that need
+-- the seller at hand, like pickup, can only be chosen while every seller of
+-- the order is open and accepting orders. Sellers without hours are always
+-- open.
+ALTER TABLE users ADD COLUMN business_hours JSONB;
+ALTER TABLE users ADD COLUMN accepting_orders BOOLEAN NOT NULL DEFAULT true;
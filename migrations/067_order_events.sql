@@ -0,0 +1,39 @@
+-- Order events: an append-only log of what happened to each order, in the
+-- order it happened. Every transaction that changes an order appends to it
+-- with the next sequence number of the order, so folding an order's events
+-- in sequence gives its status and payment status.
+CREATE TABLE order_events (
+    order_id UUID NOT NULL REFERENCES orders(id),
+    sequence INTEGER NOT NULL CHECK (sequence > 0),
+    event_type VARCHAR(20) NOT NULL
+        CHECK (event_type IN ('created', 'reserved', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded')),
+    actor_id UUID, -- kept when the user is deleted, as the log is never changed
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (order_id, sequence)
+);
+
+-- Events are append-only
+CREATE OR REPLACE FUNCTION prevent_order_event_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'order events are immutable';
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER prevent_order_events_change BEFORE UPDATE OR DELETE ON order_events FOR EACH ROW EXECUTE FUNCTION prevent_order_event_change();
+
+-- Existing orders get the events that lead to where they are now
+INSERT INTO order_events (order_id, sequence, event_type, data, created_at)
+SELECT o.id, ROW_NUMBER() OVER (PARTITION BY o.id ORDER BY e.step), e.event_type, e.data || '{"backfilled": true}',
+    CASE WHEN e.step = 1 THEN o.created_at ELSE COALESCE(o.updated_at, o.created_at) END
+FROM orders o
+CROSS JOIN LATERAL (VALUES
+    (1, 'created', '{}'::jsonb, true),
+    (2, 'paid', '{}'::jsonb, COALESCE(o.payment_status, 'pending') IN ('paid', 'partially_refunded', 'refunded')),
+    (3, 'shipped', '{}'::jsonb, o.status IN ('shipped', 'delivered')),
+    (4, 'delivered', '{}'::jsonb, o.status = 'delivered'),
+    (5, 'cancelled', '{}'::jsonb, o.status = 'cancelled'),
+    (6, 'refunded', jsonb_build_object('paymentStatus', o.payment_status), o.payment_status IN ('partially_refunded', 'refunded'))
+) AS e(step, event_type, data, applies)
+WHERE e.applies;
@@ -0,0 +1,20 @@
+-- System alerts: an inbox of operational problems for admins. A condition
+-- raised again while its alert is unacknowledged updates that alert instead
+-- of adding another; once acknowledged, the next occurrence opens a new one.
+CREATE TABLE system_alerts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    severity VARCHAR(10) NOT NULL CHECK (severity IN ('info', 'warning', 'critical')),
+    source VARCHAR(50) NOT NULL, -- the subsystem raising it, e.g. jobs
+    dedupe_key VARCHAR(200) NOT NULL,
+    title VARCHAR(200) NOT NULL,
+    message TEXT NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    occurrences INTEGER NOT NULL DEFAULT 1,
+    first_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    acknowledged_at TIMESTAMP WITH TIME ZONE,
+    acknowledged_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX idx_system_alerts_open ON system_alerts(dedupe_key) WHERE acknowledged_at IS NULL;
+CREATE INDEX idx_system_alerts_last_seen ON system_alerts(last_seen_at DESC);
@@ -0,0 +1,20 @@
+-- Maintenance windows: scheduled downtime announced in advance. From
+-- notice_at responses warn of it and its banner shows; from starts_at until
+-- ends_at the API answers 503, except health checks and admin routes.
+CREATE TABLE maintenance_windows (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    notice_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    message VARCHAR(200) NOT NULL,
+    banner_block_id UUID REFERENCES content_blocks(id) ON DELETE SET NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    cancelled_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (ends_at > starts_at AND notice_at <= starts_at)
+);
+
+CREATE INDEX idx_maintenance_windows_open ON maintenance_windows(ends_at) WHERE cancelled_at IS NULL;
+
+CREATE TRIGGER update_maintenance_windows_updated_at BEFORE UPDATE ON maintenance_windows FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
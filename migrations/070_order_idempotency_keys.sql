@@ -0,0 +1,5 @@
+-- Idempotency keys of orders placed by buy now, so a retried request
+-- returns the order the first one placed instead of placing another
+ALTER TABLE orders ADD COLUMN idempotency_key VARCHAR(255);
+
+CREATE UNIQUE INDEX idx_orders_idempotency_key ON orders (buyer_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Proof of delivery of locally delivered orders: who delivered the order
+-- and when, with an optional photo, kept in blob storage and served only to
+-- those who can see the order, and the recipient's signature and notes
+CREATE TABLE order_deliveries (
+    order_id UUID PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+    delivered_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    delivered_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    photo_key VARCHAR(255),
+    photo_content_type VARCHAR(100),
+    photo_size BIGINT,
+    signature VARCHAR(100),
+    notes VARCHAR(500)
+);
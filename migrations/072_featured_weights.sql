@@ -0,0 +1,3 @@
+-- Featured slot weights: products with is_featured are eligible for the
+-- homepage featured slot, picked at random in proportion to their weight.
+ALTER TABLE products ADD COLUMN featured_weight INTEGER NOT NULL DEFAULT 1 CHECK (featured_weight BETWEEN 1 AND 1000);
@@ -0,0 +1,10 @@
+-- Self-purchases: order lines of products the buyer sells, when
+-- orders.self_purchase lets them through. They are left out of marketplace
+-- stats and raised as alerts for admins to review.
+ALTER TABLE order_items ADD COLUMN self_purchase BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE order_items oi SET self_purchase = true
+FROM orders o, products p
+WHERE o.id = oi.order_id AND p.id = oi.product_id AND p.seller_id = o.buyer_id;
+
+CREATE INDEX idx_order_items_self_purchase ON order_items(order_id) WHERE self_purchase;
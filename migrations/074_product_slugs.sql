@@ -0,0 +1,28 @@
+-- Product slugs: a unique, URL-safe name for each product, derived from its
+-- title as tag slugs are. Slugs a product had before are kept in
+-- slug_history so old links redirect to its current one.
+ALTER TABLE products ADD COLUMN slug VARCHAR(120);
+
+-- The oldest product with a title keeps its slug; the others tell theirs
+-- apart with the start of their id
+UPDATE products p SET slug = CASE WHEN s.n = 1 THEN s.base ELSE s.base || '-' || left(p.id::text, 8) END
+FROM (
+    SELECT id, base, ROW_NUMBER() OVER (PARTITION BY base ORDER BY created_at, id) AS n
+    FROM (
+        SELECT id, created_at,
+            COALESCE(NULLIF(rtrim(left(btrim(regexp_replace(lower(title), '[^a-z0-9]+', '-', 'g'), '-'), 100), '-'), ''), 'product') AS base
+        FROM products
+    ) b
+) s
+WHERE s.id = p.id;
+
+ALTER TABLE products ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE products ADD CONSTRAINT products_slug_key UNIQUE (slug);
+
+CREATE TABLE slug_history (
+    slug VARCHAR(120) PRIMARY KEY, -- never any product's current slug
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_slug_history_product ON slug_history(product_id);
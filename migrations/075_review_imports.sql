@@ -0,0 +1,16 @@
+-- Reviews imported from another platform wait as 'imported', hidden like
+-- deleted ones, until an admin approves ('published') or rejects them. They
+-- carry the platform they came from and their ID there, which makes
+-- importing them again a no-op, and may be by reviewers without an account,
+-- named by author_name.
+ALTER TABLE reviews
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published' CHECK (status IN ('published', 'imported', 'rejected')),
+    ADD COLUMN source VARCHAR(50),
+    ADD COLUMN source_id VARCHAR(255),
+    ADD COLUMN author_name VARCHAR(100),
+    ALTER COLUMN buyer_id DROP NOT NULL;
+
+CREATE UNIQUE INDEX reviews_source_key ON reviews(source, source_id) WHERE source IS NOT NULL;
+
+-- The moderation queue lists imported reviews, oldest first
+CREATE INDEX idx_reviews_imported ON reviews(created_at, id) WHERE status = 'imported';
@@ -0,0 +1,7 @@
+-- The price a wishlist item's product was charged at when it was added, in
+-- its currency then, so the wishlist can show how the price moved since.
+-- Items added before have none.
+ALTER TABLE wishlist
+    ADD COLUMN added_price_cents BIGINT,
+    ADD COLUMN added_currency VARCHAR(3),
+    ADD CONSTRAINT wishlist_added_price CHECK ((added_price_cents IS NULL) = (added_currency IS NULL));
@@ -0,0 +1,28 @@
+-- Admins impersonating users, for support. Each impersonation is a short
+-- lived token acting as the user, which stops working once expired or
+-- revoked; every request made with it is kept.
+CREATE TABLE impersonations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    admin_id UUID NOT NULL REFERENCES users(id),
+    user_id UUID NOT NULL REFERENCES users(id),
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by UUID REFERENCES users(id)
+);
+
+CREATE INDEX idx_impersonations_user ON impersonations(user_id, created_at DESC);
+CREATE INDEX idx_impersonations_admin ON impersonations(admin_id, created_at DESC);
+
+CREATE TABLE impersonation_requests (
+    id BIGSERIAL PRIMARY KEY,
+    impersonation_id UUID NOT NULL REFERENCES impersonations(id),
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    status INT NOT NULL,
+    request_id TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_impersonation_requests ON impersonation_requests(impersonation_id, created_at);
@@ -0,0 +1,20 @@
+-- Where sellers are, so shoppers can find products from sellers near them.
+-- Sellers without a location are left out of listings near a location.
+ALTER TABLE users
+    ADD COLUMN latitude DOUBLE PRECISION,
+    ADD COLUMN longitude DOUBLE PRECISION,
+    ADD CONSTRAINT users_location CHECK ((latitude IS NULL) = (longitude IS NULL)),
+    ADD CONSTRAINT users_latitude CHECK (latitude BETWEEN -90 AND 90),
+    ADD CONSTRAINT users_longitude CHECK (longitude BETWEEN -180 AND 180);
+
+-- Serves the bounding box sellers near a location are narrowed to first
+CREATE INDEX idx_users_location ON users(latitude, longitude) WHERE latitude IS NOT NULL;
+
+-- Great-circle distance in kilometres between two points, by the haversine
+-- formula; keep the earth's radius in step with earthRadiusKm
+CREATE FUNCTION geo_distance_km(lat1 DOUBLE PRECISION, lng1 DOUBLE PRECISION, lat2 DOUBLE PRECISION, lng2 DOUBLE PRECISION)
+RETURNS DOUBLE PRECISION AS $$
+    SELECT 2 * 6371.0 * asin(LEAST(1, sqrt(
+        power(sin(radians(lat2 - lat1) / 2), 2)
+        + cos(radians(lat1)) * cos(radians(lat2)) * power(sin(radians(lng2 - lng1) / 2), 2))))
+$$ LANGUAGE SQL IMMUTABLE;
@@ -0,0 +1,5 @@
+-- The payload version each subscription is sent. Existing subscriptions keep
+-- getting the shape they were built against, version 1; deliveries record
+-- the version they were sent in.
+ALTER TABLE webhook_subscriptions ADD COLUMN payload_version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE webhook_deliveries ADD COLUMN payload_version INTEGER;
@@ -0,0 +1,17 @@
+-- Users can delete their accounts. The user row is kept, stripped of
+-- personal data, as orders and other records under legal hold refer to it,
+-- and deleted_at marks it. The user chooses whether their reviews are
+-- deleted or kept without their name.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Every account deletion, with what was done to the user's reviews
+CREATE TABLE account_deletions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id),
+    reviews VARCHAR(20) NOT NULL CHECK (reviews IN ('anonymize', 'delete')),
+    reviews_deleted INTEGER NOT NULL DEFAULT 0,
+    reviews_anonymized INTEGER NOT NULL DEFAULT 0,
+    replies_deleted INTEGER NOT NULL DEFAULT 0, -- sellers' replies deleted with the reviews
+    product_ids UUID[] NOT NULL DEFAULT '{}', -- whose ratings were recomputed
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,19 @@
+-- Admins search users by email, name, phone or ID. Email lookups ignore
+-- case, and partial matches on email, name and phone use trigram indexes
+-- (pg_trgm is enabled by 062).
+CREATE INDEX idx_users_email_lower ON users(LOWER(email));
+CREATE INDEX idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX idx_users_full_name_trgm ON users USING GIN (full_name gin_trgm_ops);
+CREATE INDEX idx_users_phone_trgm ON users USING GIN (phone gin_trgm_ops);
+
+-- Every admin user search that returned users: who searched, with what
+-- filters, and which users they were shown
+CREATE TABLE admin_user_searches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    admin_id UUID NOT NULL REFERENCES users(id),
+    filters JSONB NOT NULL,
+    user_ids UUID[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_admin_user_searches_admin ON admin_user_searches(admin_id, created_at DESC);
@@ -0,0 +1,32 @@
+-- Fraud review: orders scoring high on the fraud rules at checkout or
+-- payment are held in 'review' until an admin releases or cancels them.
+-- Cards keep the gateway's fingerprint, the same for a card number however
+-- many accounts store it, and the country that issued it.
+ALTER TABLE payment_methods ADD COLUMN fingerprint VARCHAR(255);
+ALTER TABLE payment_methods ADD COLUMN country CHAR(2);
+
+CREATE INDEX idx_payment_methods_fingerprint ON payment_methods(fingerprint) WHERE fingerprint IS NOT NULL;
+
+-- The order's latest score and the rules that matched
+ALTER TABLE orders ADD COLUMN fraud_score INTEGER;
+ALTER TABLE orders ADD COLUMN fraud_reasons TEXT[];
+ALTER TABLE orders ADD COLUMN held_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE orders ADD COLUMN released_at TIMESTAMP WITH TIME ZONE; -- released orders aren't held again
+
+ALTER TABLE order_events DROP CONSTRAINT order_events_event_type_check;
+ALTER TABLE order_events ADD CONSTRAINT order_events_event_type_check
+    CHECK (event_type IN ('created', 'reserved', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded', 'held', 'released'));
+
+-- Each admin decision on a held order
+CREATE TABLE order_fraud_reviews (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id),
+    admin_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('release', 'cancel')),
+    note TEXT,
+    score INTEGER NOT NULL,
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_fraud_reviews_order ON order_fraud_reviews(order_id, created_at);
@@ -0,0 +1,41 @@
+-- Customer returns: buyers ask to return delivered items within their
+-- return window, admins approve them with a return label or reject them,
+-- and received returns are refunded and their items quarantined.
+-- Return windows, in days after delivery, set per product or per category;
+-- NULL falls back to the category's, then to the configured default.
+ALTER TABLE products ADD COLUMN return_window_days INTEGER CHECK (return_window_days >= 0);
+ALTER TABLE categories ADD COLUMN return_window_days INTEGER CHECK (return_window_days >= 0);
+
+-- Returned units waiting for inspection, kept apart from sellable stock
+ALTER TABLE products ADD COLUMN quarantined_quantity INTEGER NOT NULL DEFAULT 0 CHECK (quarantined_quantity >= 0);
+
+CREATE TABLE order_returns (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'approved', 'rejected', 'received')),
+    reason VARCHAR(30) NOT NULL CHECK (reason IN ('damaged', 'wrong_item', 'not_as_described', 'no_longer_needed', 'other')),
+    comment TEXT,
+    label_carrier VARCHAR(50),
+    label_tracking_number VARCHAR(100),
+    label_url TEXT,
+    rejection_reason TEXT,
+    refund_cents BIGINT,
+    refund_reference VARCHAR(255), -- the gateway's refund ID; NULL when settled by hand
+    reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    received_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    received_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_order_returns_order ON order_returns(order_id, created_at);
+CREATE INDEX idx_order_returns_status ON order_returns(status, created_at);
+
+CREATE TABLE order_return_items (
+    return_id UUID NOT NULL REFERENCES order_returns(id) ON DELETE CASCADE,
+    order_item_id UUID NOT NULL REFERENCES order_items(id),
+    quantity INTEGER NOT NULL CHECK (quantity > 0), -- in grams when sold by weight
+    PRIMARY KEY (return_id, order_item_id)
+);
+
+CREATE INDEX idx_order_return_items_item ON order_return_items(order_item_id);
@@ -0,0 +1,25 @@
+-- Shipping labels bought from the carrier for an order, one per order. The
+-- row is written as soon as the label is bought, so asking again returns it
+-- rather than buying another; the label file is stored after, and fetched
+-- again from the carrier while label_key is NULL.
+CREATE TABLE shipping_labels (
+    order_id UUID PRIMARY KEY REFERENCES orders(id),
+    carrier VARCHAR(50) NOT NULL,
+    service VARCHAR(100) NOT NULL,
+    tracking_number VARCHAR(100) NOT NULL,
+    carrier_reference VARCHAR(255) NOT NULL, -- the carrier's ID of the purchase
+    carrier_label_url TEXT NOT NULL,
+    label_key TEXT,
+    content_type VARCHAR(50),
+    size BIGINT,
+    cost_cents BIGINT NOT NULL CHECK (cost_cents >= 0),
+    currency VARCHAR(3) NOT NULL,
+    weight_grams INTEGER NOT NULL CHECK (weight_grams > 0),
+    length_cm INTEGER NOT NULL CHECK (length_cm > 0),
+    width_cm INTEGER NOT NULL CHECK (width_cm > 0),
+    height_cm INTEGER NOT NULL CHECK (height_cm > 0),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_shipping_labels_tracking ON shipping_labels(tracking_number);
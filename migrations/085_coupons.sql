@@ -0,0 +1,37 @@
+-- Coupons: codes admins hand out that take a percentage or a fixed amount
+-- off the cart lines they apply to. Codes are stored upper case and matched
+-- ignoring case. A coupon with no products or categories applies to every
+-- line; otherwise to lines of its products or in its categories.
+CREATE TABLE coupons (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(32) NOT NULL,
+    percent_off INTEGER CHECK (percent_off BETWEEN 1 AND 100),
+    amount_off_cents BIGINT CHECK (amount_off_cents > 0),
+    currency VARCHAR(3) NOT NULL,
+    min_spend_cents BIGINT NOT NULL DEFAULT 0 CHECK (min_spend_cents >= 0), -- over the lines it applies to
+    product_ids UUID[] NOT NULL DEFAULT '{}',
+    category_ids UUID[] NOT NULL DEFAULT '{}',
+    usage_limit INTEGER CHECK (usage_limit > 0), -- orders it may be used on, unlimited when NULL
+    times_used INTEGER NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    deactivated_at TIMESTAMP WITH TIME ZONE,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT coupons_discount_check CHECK ((percent_off IS NULL) <> (amount_off_cents IS NULL)),
+    CONSTRAINT coupons_dates_check CHECK (expires_at > starts_at)
+);
+
+CREATE UNIQUE INDEX idx_coupons_code ON coupons(code);
+
+-- The coupon applied to a user's cart, at most one. Checkout re-checks it
+-- and removes it with the cart's lines.
+CREATE TABLE cart_coupons (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    coupon_id UUID NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- The coupon an order was placed with; its discount is the order's
+ALTER TABLE orders ADD COLUMN coupon_id UUID REFERENCES coupons(id);
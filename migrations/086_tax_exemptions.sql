@@ -0,0 +1,6 @@
+-- Sales tax exemptions, each with the reason quotes show for it. A product
+-- with no reason of its own falls back to its category's; a buyer with a
+-- reason is exempt on every order.
+ALTER TABLE products ADD COLUMN tax_exempt_reason VARCHAR(200);
+ALTER TABLE categories ADD COLUMN tax_exempt_reason VARCHAR(200);
+ALTER TABLE users ADD COLUMN tax_exempt_reason VARCHAR(200);